@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmalert-logs/config"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmalert-logs/rule"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/tenant"
+	"gopkg.in/yaml.v2"
+)
+
+// apiAlert is an alert in Prometheus /api/v1/alerts format.
+//
+// See https://prometheus.io/docs/prometheus/latest/querying/api/#alerts
+type apiAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	State       string            `json:"state"`
+	ActiveAt    time.Time         `json:"activeAt"`
+	Value       string            `json:"value"`
+}
+
+// apiRule is a rule in Prometheus /api/v1/rules format.
+//
+// See https://prometheus.io/docs/prometheus/latest/querying/api/#rules
+type apiRule struct {
+	Type           string            `json:"type"`
+	Name           string            `json:"name"`
+	Query          string            `json:"query"`
+	Duration       float64           `json:"duration,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	Annotations    map[string]string `json:"annotations,omitempty"`
+	Alerts         []apiAlert        `json:"alerts,omitempty"`
+	State          string            `json:"state,omitempty"`
+	Health         string            `json:"health"`
+	LastError      string            `json:"lastError,omitempty"`
+	LastEvaluation time.Time         `json:"lastEvaluation"`
+	EvaluationTime float64           `json:"evaluationTime"`
+}
+
+type apiGroup struct {
+	Name           string    `json:"name"`
+	File           string    `json:"file"`
+	Interval       float64   `json:"interval"`
+	Rules          []apiRule `json:"rules"`
+	LastEvaluation time.Time `json:"lastEvaluation"`
+	EvaluationTime float64   `json:"evaluationTime"`
+}
+
+type apiResponse struct {
+	Status string      `json:"status"`
+	Data   interface{} `json:"data"`
+}
+
+// getGroups returns rule groups for tenants from X-Scope-OrgID header of r.
+func getGroups(r *http.Request) ([]*rule.Group, error) {
+	tenants, err := tenant.GetTenants(r)
+	if err != nil {
+		return nil, err
+	}
+	var groups []*rule.Group
+	for _, g := range manager.Groups() {
+		at := g.Tenant().AuthToken
+		for _, t := range tenants {
+			if *t.AuthToken == *at {
+				groups = append(groups, g)
+				break
+			}
+		}
+	}
+	return groups, nil
+}
+
+func alertsHandler(w http.ResponseWriter, r *http.Request) error {
+	groups, err := getGroups(r)
+	if err != nil {
+		return err
+	}
+	alerts := []apiAlert{}
+	for _, g := range groups {
+		for _, rl := range g.Rules() {
+			if ar, ok := rl.(*rule.AlertingRule); ok {
+				alerts = append(alerts, getAPIAlerts(ar)...)
+			}
+		}
+	}
+	return writeJSON(w, map[string]interface{}{
+		"alerts": alerts,
+	})
+}
+
+func rulesHandler(w http.ResponseWriter, r *http.Request) error {
+	groups, err := getGroups(r)
+	if err != nil {
+		return err
+	}
+	ruleType := r.FormValue("type")
+	if ruleType != "" && ruleType != "alert" && ruleType != "record" {
+		return fmt.Errorf("unsupported `type` arg: %q; supported values: alert, record", ruleType)
+	}
+	apiGroups := []apiGroup{}
+	for _, g := range groups {
+		ag := apiGroup{
+			Name:     g.Name(),
+			File:     g.File(),
+			Interval: g.Interval().Seconds(),
+			Rules:    []apiRule{},
+		}
+		for _, rl := range g.Rules() {
+			cfg := rl.Config()
+			s := rl.State()
+			ar := apiRule{
+				Name:           cfg.Name(),
+				Query:          cfg.Expr,
+				Labels:         cfg.Labels,
+				Health:         s.Health(),
+				LastEvaluation: s.LastEvaluation,
+				EvaluationTime: s.EvaluationDuration.Seconds(),
+			}
+			if s.LastError != nil {
+				ar.LastError = s.LastError.Error()
+			}
+			if ar.LastEvaluation.After(ag.LastEvaluation) {
+				ag.LastEvaluation = ar.LastEvaluation
+			}
+			ag.EvaluationTime += ar.EvaluationTime
+			switch rl := rl.(type) {
+			case *rule.AlertingRule:
+				if ruleType == "record" {
+					continue
+				}
+				ar.Type = "alerting"
+				ar.Duration = cfg.For.Seconds()
+				ar.Annotations = cfg.Annotations
+				ar.Alerts = getAPIAlerts(rl)
+				ar.State = rl.AlertsState().String()
+			default:
+				if ruleType == "alert" {
+					continue
+				}
+				ar.Type = "recording"
+			}
+			ag.Rules = append(ag.Rules, ar)
+		}
+		apiGroups = append(apiGroups, ag)
+	}
+	return writeJSON(w, map[string]interface{}{
+		"groups": apiGroups,
+	})
+}
+
+// lokiRulesHandler returns rules in YAML format compatible with Loki ruler API.
+//
+// See https://grafana.com/docs/loki/latest/api/#list-rule-groups
+func lokiRulesHandler(w http.ResponseWriter, r *http.Request) error {
+	groups, err := getGroups(r)
+	if err != nil {
+		return err
+	}
+	namespaces := make(map[string][]config.Group)
+	for _, g := range groups {
+		namespace := strings.TrimSuffix(filepath.Base(g.File()), filepath.Ext(g.File()))
+		namespaces[namespace] = append(namespaces[namespace], *g.Config())
+	}
+	if len(namespaces) == 0 {
+		http.Error(w, "no rule groups found", http.StatusNotFound)
+		return nil
+	}
+	data, err := yaml.Marshal(namespaces)
+	if err != nil {
+		return fmt.Errorf("cannot marshal rule groups: %w", err)
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	_, _ = w.Write(data)
+	return nil
+}
+
+func getAPIAlerts(ar *rule.AlertingRule) []apiAlert {
+	var alerts []apiAlert
+	for _, a := range ar.Alerts() {
+		alerts = append(alerts, apiAlert{
+			Labels:      a.Labels.Map(),
+			Annotations: a.Annotations,
+			State:       a.State.String(),
+			ActiveAt:    a.ActiveAt,
+			Value:       strconv.FormatFloat(a.Value, 'g', -1, 64),
+		})
+	}
+	return alerts
+}
+
+func writeJSON(w http.ResponseWriter, data interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(&apiResponse{
+		Status: "success",
+		Data:   data,
+	})
+}
@@ -0,0 +1,219 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logql"
+	"gopkg.in/yaml.v2"
+)
+
+// Group is a group of rules evaluated serially at the same interval.
+//
+// See https://prometheus.io/docs/prometheus/latest/configuration/recording_rules/#rule_group
+type Group struct {
+	Name string `yaml:"name"`
+
+	// Interval is the evaluation interval for the group. -evaluationInterval is used if it is zero.
+	Interval time.Duration `yaml:"interval,omitempty"`
+
+	// Tenant is X-Scope-OrgID value for the tenant the rules are evaluated for.
+	//
+	// The tenant with zero AccountID and ProjectID is used if it is empty.
+	Tenant string `yaml:"tenant,omitempty"`
+
+	Rules []Rule `yaml:"rules"`
+
+	// File is the path to the file the group was loaded from.
+	File string `yaml:"-"`
+}
+
+// Rule is an alerting or recording rule.
+//
+// See https://prometheus.io/docs/prometheus/latest/configuration/alerting_rules/
+type Rule struct {
+	// Alert is the name of the alerting rule.
+	Alert string `yaml:"alert,omitempty"`
+
+	// Record is the name of the series for the recording rule.
+	Record string `yaml:"record,omitempty"`
+
+	// Expr is LogQL metric query to evaluate.
+	Expr string `yaml:"expr"`
+
+	// For is the duration the alert must be pending before firing.
+	For time.Duration `yaml:"for,omitempty"`
+
+	// Labels are added to the alerts and series produced by the rule.
+	//
+	// Label values of alerting rules may contain templates.
+	Labels map[string]string `yaml:"labels,omitempty"`
+
+	// Annotations are added to the alerts. They may contain templates.
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// Name returns the name of r.
+func (r *Rule) Name() string {
+	if len(r.Alert) > 0 {
+		return r.Alert
+	}
+	return r.Record
+}
+
+type rulesFile struct {
+	Groups []Group `yaml:"groups"`
+}
+
+// Parse parses rule groups from files matching the given path patterns.
+//
+// Path patterns may contain wildcards supported by filepath.Glob.
+func Parse(pathPatterns []string) ([]Group, error) {
+	var files []string
+	for _, pattern := range pathPatterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path pattern %q: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files found for path pattern %q", pattern)
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+	var groups []Group
+	seen := make(map[string]bool)
+	for _, path := range files {
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read rules file: %w", err)
+		}
+		gs, err := parseData(data)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse rules file %q: %w", path, err)
+		}
+		for i := range gs {
+			gs[i].File = path
+		}
+		groups = append(groups, gs...)
+	}
+	return groups, nil
+}
+
+func parseData(data []byte) ([]Group, error) {
+	var rf rulesFile
+	if err := yaml.UnmarshalStrict(data, &rf); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for i := range rf.Groups {
+		g := &rf.Groups[i]
+		if err := g.Validate(); err != nil {
+			return nil, err
+		}
+		if seen[g.Name] {
+			return nil, fmt.Errorf("duplicate group name %q", g.Name)
+		}
+		seen[g.Name] = true
+	}
+	return rf.Groups, nil
+}
+
+// Validate validates g.
+func (g *Group) Validate() error {
+	if len(g.Name) == 0 {
+		return fmt.Errorf("group name cannot be empty")
+	}
+	if g.Interval < 0 {
+		return fmt.Errorf("group %q: interval cannot be negative; got %s", g.Name, g.Interval)
+	}
+	if len(g.Rules) == 0 {
+		return fmt.Errorf("group %q: rules cannot be empty", g.Name)
+	}
+	for i := range g.Rules {
+		if err := g.Rules[i].Validate(); err != nil {
+			return fmt.Errorf("group %q: %w", g.Name, err)
+		}
+	}
+	return nil
+}
+
+// Validate validates r.
+func (r *Rule) Validate() error {
+	switch {
+	case len(r.Alert) > 0 && len(r.Record) > 0:
+		return fmt.Errorf("rule cannot contain both `alert` and `record` fields; got alert=%q, record=%q", r.Alert, r.Record)
+	case len(r.Alert) == 0 && len(r.Record) == 0:
+		return fmt.Errorf("rule must contain either `alert` or `record` field; expr=%q", r.Expr)
+	}
+	name := r.Name()
+	if len(r.Expr) == 0 {
+		return fmt.Errorf("rule %q: expr cannot be empty", name)
+	}
+	e, err := logql.Parse(r.Expr)
+	if err != nil {
+		return fmt.Errorf("rule %q: cannot parse expr %q: %w", name, r.Expr, err)
+	}
+	if _, ok := e.(*logql.MetricExpr); ok {
+		return fmt.Errorf("rule %q: expr must be LogQL metric query instead of log stream selector; got %q", name, r.Expr)
+	}
+	if len(r.Record) > 0 {
+		if !isValidMetricName(r.Record) {
+			return fmt.Errorf("rule %q: invalid series name for recording rule", name)
+		}
+		if r.For != 0 {
+			return fmt.Errorf("rule %q: `for` cannot be set for recording rule", name)
+		}
+		if len(r.Annotations) > 0 {
+			return fmt.Errorf("rule %q: `annotations` cannot be set for recording rule", name)
+		}
+	}
+	if r.For < 0 {
+		return fmt.Errorf("rule %q: `for` cannot be negative; got %s", name, r.For)
+	}
+	for k, v := range r.Labels {
+		if err := validateTemplate(v); err != nil {
+			return fmt.Errorf("rule %q: invalid template in label %q: %w", name, k, err)
+		}
+	}
+	for k, v := range r.Annotations {
+		if err := validateTemplate(v); err != nil {
+			return fmt.Errorf("rule %q: invalid template in annotation %q: %w", name, k, err)
+		}
+	}
+	return nil
+}
+
+// TemplatePrefix is prepended to label and annotation templates, so they may refer to $labels and $value like in Prometheus.
+const TemplatePrefix = "{{- $labels := .Labels }}{{- $value := .Value }}"
+
+func validateTemplate(s string) error {
+	if !strings.Contains(s, "{{") {
+		return nil
+	}
+	_, err := template.New("").Option("missingkey=zero").Parse(TemplatePrefix + s)
+	return err
+}
+
+func isValidMetricName(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '_' || c == ':' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9' {
+			continue
+		}
+		return false
+	}
+	return true
+}
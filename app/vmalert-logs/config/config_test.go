@@ -0,0 +1,159 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDataSuccess(t *testing.T) {
+	groups, err := parseData([]byte(`
+groups:
+  - name: foo
+    interval: 30s
+    tenant: team-a
+    rules:
+      - alert: TooManyWarnings
+        expr: sum by (component) (count_over_time({level="WARN"}[5m])) > 100
+        for: 10m
+        labels:
+          severity: warning
+        annotations:
+          summary: "{{ $labels.component }}: {{ $value }}"
+      - record: component:warnings:rate5m
+        expr: sum by (component) (rate({level="WARN"}[5m]))
+  - name: bar
+    rules:
+      - alert: Errors
+        expr: count_over_time({app="foo"} |> "<_> error"[1m]) > 0
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("unexpected number of groups; got %d; want 2", len(groups))
+	}
+	g := &groups[0]
+	if g.Name != "foo" || g.Interval != 30*time.Second || g.Tenant != "team-a" || len(g.Rules) != 2 {
+		t.Fatalf("unexpected group: %+v", g)
+	}
+	r := &g.Rules[0]
+	if r.Name() != "TooManyWarnings" || r.For != 10*time.Minute || r.Labels["severity"] != "warning" {
+		t.Fatalf("unexpected rule: %+v", r)
+	}
+	if name := g.Rules[1].Name(); name != "component:warnings:rate5m" {
+		t.Fatalf("unexpected rule name; got %q; want %q", name, "component:warnings:rate5m")
+	}
+}
+
+func TestParseDataFailure(t *testing.T) {
+	f := func(s string) {
+		t.Helper()
+		if _, err := parseData([]byte(s)); err == nil {
+			t.Fatalf("expecting non-nil error when parsing %q", s)
+		}
+	}
+
+	// unknown field
+	f(`
+groups:
+  - name: foo
+    foo: bar
+    rules:
+      - alert: a
+        expr: count_over_time({app="foo"}[1m])
+`)
+
+	// missing group name
+	f(`
+groups:
+  - rules:
+      - alert: a
+        expr: count_over_time({app="foo"}[1m])
+`)
+
+	// duplicate group name
+	f(`
+groups:
+  - name: foo
+    rules:
+      - alert: a
+        expr: count_over_time({app="foo"}[1m])
+  - name: foo
+    rules:
+      - alert: b
+        expr: count_over_time({app="foo"}[1m])
+`)
+
+	// empty rules
+	f(`
+groups:
+  - name: foo
+    rules: []
+`)
+
+	// both alert and record
+	f(`
+groups:
+  - name: foo
+    rules:
+      - alert: a
+        record: b
+        expr: count_over_time({app="foo"}[1m])
+`)
+
+	// neither alert nor record
+	f(`
+groups:
+  - name: foo
+    rules:
+      - expr: count_over_time({app="foo"}[1m])
+`)
+
+	// invalid expr
+	f(`
+groups:
+  - name: foo
+    rules:
+      - alert: a
+        expr: count_over_time({app="foo"
+`)
+
+	// log stream selector
+	f(`
+groups:
+  - name: foo
+    rules:
+      - alert: a
+        expr: '{app="foo"} | trace_id="abc"'
+`)
+
+	// invalid series name
+	f(`
+groups:
+  - name: foo
+    rules:
+      - record: foo-bar
+        expr: count_over_time({app="foo"}[1m])
+`)
+
+	// for in recording rule
+	f(`
+groups:
+  - name: foo
+    rules:
+      - record: foo
+        expr: count_over_time({app="foo"}[1m])
+        for: 1m
+`)
+
+	// invalid template
+	f(`
+groups:
+  - name: foo
+    rules:
+      - alert: a
+        expr: count_over_time({app="foo"}[1m])
+        annotations:
+          summary: "{{ $labels.foo "
+`)
+}
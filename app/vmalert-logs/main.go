@@ -0,0 +1,317 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmalert-logs/config"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmalert-logs/notifier"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmalert-logs/rule"
+	insertnetstorage "github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/netstorage"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/querier"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/searchutils"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/clustertls"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/tenant"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/buildinfo"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/cgroup"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/envflag"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/procutil"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+var (
+	httpListenAddr = flag.String("httpListenAddr", ":8880", "Address to listen for http connections")
+	storageNodes   = flagutil.NewArray("storageNode", "Addresses of vmstorage nodes; usage: -storageNode=vmstorage-host1:8401 -storageNode=vmstorage-host2:8401")
+	rulePaths      = flagutil.NewArray("rule", "Path to the file with alerting and recording rules in Prometheus-compatible format. "+
+		"The path may contain wildcards supported by filepath.Glob, for example `/etc/rules/*.yml`. Rules are re-read on SIGHUP")
+	evaluationInterval = flag.Duration("evaluationInterval", time.Minute, "Default evaluation interval for rule groups without `interval`")
+	evalTimeout        = flag.Duration("rule.evalTimeout", 30*time.Second, "The maximum duration for a single rule evaluation")
+	notifierURLs       = flagutil.NewArray("notifier.url", "Alertmanager-compatible URL for sending alerts, for example http://alertmanager:9093/api/v2/alerts. "+
+		"Alerts are sent to all the given urls")
+	notifierTimeout = flag.Duration("notifier.timeout", 10*time.Second, "Timeout for sending alerts to -notifier.url")
+	externalURL     = flag.String("external.url", "", "URL of the Loki-compatible query API such as Grafana or vmselect, which is used for generatorURL in alerts")
+
+	insertStorageNodes = flagutil.NewArray("insertStorageNode", "Addresses of vmstorage nodes for writing results of recording rules; "+
+		"usage: -insertStorageNode=vmstorage-host1:8400 -insertStorageNode=vmstorage-host2:8400. Results of recording rules aren't written if the flag is empty")
+	replayFrom = flag.String("replay.timeFrom", "", "Optional start time in RFC3339 format for backfilling results of recording rules, for example 2020-10-01T00:00:00Z. "+
+		"vmalert-logs evaluates recording rules on [-replay.timeFrom..-replay.timeTo] time range, writes the results to -insertStorageNode and exits")
+	replayTo                    = flag.String("replay.timeTo", "", "Optional end time in RFC3339 format for backfilling results of recording rules. The current time is used if empty")
+	replayMaxDatapointsPerQuery = flag.Int("replay.maxDatapointsPerQuery", 1000, "The maximum number of points per range query during backfilling. "+
+		"The time range is split into multiple queries if it contains more points at the group interval")
+)
+
+func main() {
+	// Write flags and help message to stdout, since it is easier to grep or pipe.
+	flag.CommandLine.SetOutput(os.Stdout)
+	envflag.Parse()
+	buildinfo.Init()
+	logger.Init()
+	cgroup.UpdateGOMAXPROCSToCPUQuota()
+
+	if len(*rulePaths) == 0 {
+		logger.Fatalf("missing -rule arg")
+	}
+	if len(*storageNodes) == 0 {
+		logger.Fatalf("missing -storageNode arg")
+	}
+	logger.Infof("starting netstorage at storageNodes %s", *storageNodes)
+	startTime := time.Now()
+	clustertls.Init()
+	netstorage.InitStorageNodes(*storageNodes)
+	logger.Infof("started netstorage in %.3f seconds", time.Since(startTime).Seconds())
+	netstorage.InitTmpBlocksDir("")
+	tenant.Init()
+
+	var wf rule.WriteFunc
+	if len(*insertStorageNodes) > 0 {
+		logger.Infof("starting insert netstorage at insertStorageNodes %s", *insertStorageNodes)
+		startTime = time.Now()
+		insertnetstorage.InitStorageNodes(*insertStorageNodes)
+		logger.Infof("started insert netstorage in %.3f seconds", time.Since(startTime).Seconds())
+		wf = writeSamples
+	}
+
+	if len(*replayFrom) > 0 {
+		if err := replay(wf); err != nil {
+			logger.Fatalf("cannot replay recording rules: %s", err)
+		}
+		stopNetstorage()
+		return
+	}
+
+	var notifiers []*notifier.Notifier
+	for _, url := range *notifierURLs {
+		notifiers = append(notifiers, notifier.New(url, *notifierTimeout))
+	}
+	manager = rule.NewManager(execQuery, wf, notifiers, *evaluationInterval, strings.TrimSuffix(*externalURL, "/"))
+	groups, err := config.Parse(*rulePaths)
+	if err != nil {
+		logger.Fatalf("cannot load -rule=%q: %s", *rulePaths, err)
+	}
+	if err := manager.Update(groups); err != nil {
+		logger.Fatalf("cannot start rule groups from -rule=%q: %s", *rulePaths, err)
+	}
+	sighupCh := procutil.NewSighupChan()
+	go func() {
+		for range sighupCh {
+			logger.Infof("received SIGHUP; reloading -rule=%q...", *rulePaths)
+			if err := reloadRules(); err != nil {
+				rulesReloadErrors.Inc()
+				logger.Errorf("cannot reload -rule=%q: %s; preserving the previous rules", *rulePaths, err)
+				continue
+			}
+			logger.Infof("successfully reloaded -rule=%q", *rulePaths)
+		}
+	}()
+
+	go func() {
+		httpserver.Serve(*httpListenAddr, requestHandler)
+	}()
+
+	sig := procutil.WaitForSigterm()
+	logger.Infof("service received signal %s", sig)
+
+	logger.Infof("gracefully shutting down http service at %q", *httpListenAddr)
+	startTime = time.Now()
+	if err := httpserver.Stop(*httpListenAddr); err != nil {
+		logger.Fatalf("cannot stop http service: %s", err)
+	}
+	logger.Infof("successfully shut down http service in %.3f seconds", time.Since(startTime).Seconds())
+
+	logger.Infof("stopping rule groups...")
+	startTime = time.Now()
+	manager.Stop()
+	logger.Infof("stopped rule groups in %.3f seconds", time.Since(startTime).Seconds())
+
+	stopNetstorage()
+
+	logger.Infof("the vmalert-logs has been stopped")
+}
+
+func stopNetstorage() {
+	logger.Infof("shutting down neststorage...")
+	startTime := time.Now()
+	netstorage.Stop()
+	if len(*insertStorageNodes) > 0 {
+		insertnetstorage.Stop()
+	}
+	logger.Infof("successfully stopped netstorage in %.3f seconds", time.Since(startTime).Seconds())
+}
+
+func replay(wf rule.WriteFunc) error {
+	if wf == nil {
+		return fmt.Errorf("missing -insertStorageNode arg")
+	}
+	from, err := time.Parse(time.RFC3339, *replayFrom)
+	if err != nil {
+		return fmt.Errorf("cannot parse -replay.timeFrom=%q: %w", *replayFrom, err)
+	}
+	to := time.Now()
+	if len(*replayTo) > 0 {
+		to, err = time.Parse(time.RFC3339, *replayTo)
+		if err != nil {
+			return fmt.Errorf("cannot parse -replay.timeTo=%q: %w", *replayTo, err)
+		}
+	}
+	groups, err := config.Parse(*rulePaths)
+	if err != nil {
+		return fmt.Errorf("cannot load -rule=%q: %w", *rulePaths, err)
+	}
+	logger.Infof("replaying recording rules from -rule=%q on [%s..%s]", *rulePaths, from.Format(time.RFC3339), to.Format(time.RFC3339))
+	startTime := time.Now()
+	if err := rule.Replay(groups, execRangeQuery, wf, from, to, *evaluationInterval, *replayMaxDatapointsPerQuery); err != nil {
+		return err
+	}
+	logger.Infof("replayed recording rules in %.3f seconds", time.Since(startTime).Seconds())
+	return nil
+}
+
+var manager *rule.Manager
+
+var rulesReloadErrors = metrics.NewCounter(`vm_alerts_rules_reload_errors_total{name="vmalert-logs"}`)
+
+func reloadRules() error {
+	groups, err := config.Parse(*rulePaths)
+	if err != nil {
+		return err
+	}
+	return manager.Update(groups)
+}
+
+// execQuery evaluates instant LogQL query for the tenant at at the given ts.
+func execQuery(at *auth.Token, query string, ts time.Time) ([]netstorage.Result, error) {
+	startTime := time.Now()
+	t := ts.UnixNano() / 1e6
+	ec := &querier.EvalConfig{
+		AuthToken:           at,
+		Start:               t,
+		End:                 t,
+		Step:                defaultStep,
+		Deadline:            searchutils.NewDeadline(startTime, *evalTimeout, "-rule.evalTimeout"),
+		DenyPartialResponse: true,
+		QueryStats:          netstorage.NewQueryStats(startTime),
+	}
+	result, _, err := querier.Exec(ec, query, true)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// execRangeQuery evaluates range LogQL query for the tenant at on the [start..end] time range with the given step.
+func execRangeQuery(at *auth.Token, query string, start, end time.Time, step time.Duration) ([]netstorage.Result, error) {
+	startTime := time.Now()
+	ec := &querier.EvalConfig{
+		AuthToken:           at,
+		Start:               start.UnixNano() / 1e6,
+		End:                 end.UnixNano() / 1e6,
+		Step:                step.Milliseconds(),
+		Deadline:            searchutils.NewDeadline(startTime, *evalTimeout, "-rule.evalTimeout"),
+		DenyPartialResponse: true,
+		QueryStats:          netstorage.NewQueryStats(startTime),
+	}
+	if err := querier.ValidateMaxPointsPerTimeseries(ec.Start, ec.End, ec.Step); err != nil {
+		return nil, err
+	}
+	result, _, err := querier.Exec(ec, query, false)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// writeSamples writes samples produced by recording rules for the tenant at to -insertStorageNode.
+//
+// Samples are stored as numeric values, so they are returned as metrics by LogQL queries.
+func writeSamples(at *auth.Token, samples []rule.Sample) error {
+	ctx := insertnetstorage.GetInsertCtx()
+	defer insertnetstorage.PutInsertCtx(ctx)
+
+	ctx.Reset() // This line is required for initializing ctx internals.
+	var valueBuf []byte
+	for i := range samples {
+		s := &samples[i]
+		ctx.Labels = ctx.Labels[:0]
+		for _, label := range s.Labels {
+			name := []byte(label.Name)
+			if label.Name == "__name__" {
+				// Metric name is stored as a label with empty name.
+				name = nil
+			}
+			ctx.AddLabel(name, []byte(label.Value))
+		}
+		valueBuf = storage.MarshalNumericValue(valueBuf[:0], s.Value)
+		if err := ctx.WriteDataPoint(at, ctx.Labels, s.Timestamp, valueBuf); err != nil {
+			return err
+		}
+	}
+	return ctx.FlushBufs()
+}
+
+// defaultStep is the step in milliseconds used for instant queries like in /loki/api/v1/query.
+const defaultStep = 5 * 60 * 1000
+
+func requestHandler(w http.ResponseWriter, r *http.Request) bool {
+	if r.RequestURI == "/" {
+		fmt.Fprintf(w, "vmalert-logs - LogQL ruler for alerting and recording rules. See docs at https://github.com/VictoriaMetrics/VictoriaLogs")
+		return true
+	}
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch path {
+	case "/prometheus/api/v1/alerts", "/api/v1/alerts":
+		alertsRequests.Inc()
+		httpserver.EnableCORS(w, r)
+		if err := alertsHandler(w, r); err != nil {
+			alertsErrors.Inc()
+			httpserver.Errorf(w, r, "error in %q: %s", r.URL.Path, err)
+		}
+		return true
+	case "/prometheus/api/v1/rules", "/api/v1/rules":
+		rulesRequests.Inc()
+		httpserver.EnableCORS(w, r)
+		if err := rulesHandler(w, r); err != nil {
+			rulesErrors.Inc()
+			httpserver.Errorf(w, r, "error in %q: %s", r.URL.Path, err)
+		}
+		return true
+	case "/loki/api/v1/rules":
+		lokiRulesRequests.Inc()
+		httpserver.EnableCORS(w, r)
+		if err := lokiRulesHandler(w, r); err != nil {
+			lokiRulesErrors.Inc()
+			httpserver.Errorf(w, r, "error in %q: %s", r.URL.Path, err)
+		}
+		return true
+	case "/-/reload":
+		reloadRequests.Inc()
+		if err := reloadRules(); err != nil {
+			rulesReloadErrors.Inc()
+			httpserver.Errorf(w, r, "cannot reload -rule=%q: %s", *rulePaths, err)
+			return true
+		}
+		w.WriteHeader(http.StatusOK)
+		return true
+	default:
+		return false
+	}
+}
+
+var (
+	alertsRequests    = metrics.NewCounter(`vm_http_requests_total{path="/prometheus/api/v1/alerts"}`)
+	alertsErrors      = metrics.NewCounter(`vm_http_request_errors_total{path="/prometheus/api/v1/alerts"}`)
+	rulesRequests     = metrics.NewCounter(`vm_http_requests_total{path="/prometheus/api/v1/rules"}`)
+	rulesErrors       = metrics.NewCounter(`vm_http_request_errors_total{path="/prometheus/api/v1/rules"}`)
+	lokiRulesRequests = metrics.NewCounter(`vm_http_requests_total{path="/loki/api/v1/rules"}`)
+	lokiRulesErrors   = metrics.NewCounter(`vm_http_request_errors_total{path="/loki/api/v1/rules"}`)
+	reloadRequests    = metrics.NewCounter(`vm_http_requests_total{path="/-/reload"}`)
+)
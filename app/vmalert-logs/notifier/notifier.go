@@ -0,0 +1,79 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// Alert is an alert sent to Alertmanager.
+//
+// See https://prometheus.io/docs/alerting/latest/clients/
+type Alert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+// Notifier sends alerts to Alertmanager-compatible webhook.
+type Notifier struct {
+	url    string
+	client *http.Client
+
+	sent   *metrics.Counter
+	errors *metrics.Counter
+}
+
+// New returns Notifier, which sends alerts to the given url with the given timeout.
+//
+// url must point to Alertmanager alerts API such as http://alertmanager:9093/api/v2/alerts or to a compatible webhook.
+func New(url string, timeout time.Duration) *Notifier {
+	return &Notifier{
+		url: url,
+		client: &http.Client{
+			Timeout: timeout,
+		},
+		sent:   metrics.GetOrCreateCounter(fmt.Sprintf(`vm_alerts_sent_total{name="vmalert-logs", addr=%q}`, url)),
+		errors: metrics.GetOrCreateCounter(fmt.Sprintf(`vm_alerts_send_errors_total{name="vmalert-logs", addr=%q}`, url)),
+	}
+}
+
+// URL returns the url n sends alerts to.
+func (n *Notifier) URL() string {
+	return n.url
+}
+
+// Send sends alerts to n.
+func (n *Notifier) Send(alerts []Alert) error {
+	if len(alerts) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(alerts)
+	if err != nil {
+		return fmt.Errorf("cannot marshal alerts: %w", err)
+	}
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		n.errors.Inc()
+		return fmt.Errorf("cannot send %d alerts to %q: %w", len(alerts), n.url, err)
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		n.errors.Inc()
+		return fmt.Errorf("unexpected response code %d when sending %d alerts to %q; response body: %q", resp.StatusCode, len(alerts), n.url, body)
+	}
+	n.sent.Add(len(alerts))
+	return nil
+}
@@ -0,0 +1,55 @@
+package notifier
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNotifierSend(t *testing.T) {
+	var alertsReceived []Alert
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("unexpected method; got %q; want %q", r.Method, http.MethodPost)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&alertsReceived); err != nil {
+			t.Errorf("cannot decode alerts: %s", err)
+		}
+	}))
+	defer s.Close()
+
+	ts := time.Unix(1600000000, 0).UTC()
+	alerts := []Alert{{
+		Labels: map[string]string{
+			"alertname": "foo",
+			"app":       "bar",
+		},
+		Annotations: map[string]string{
+			"summary": "baz",
+		},
+		StartsAt: ts,
+		EndsAt:   ts.Add(time.Minute),
+	}}
+	n := New(s.URL, time.Second)
+	if err := n.Send(alerts); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(alertsReceived, alerts) {
+		t.Fatalf("unexpected alerts received\ngot\n%+v\nwant\n%+v", alertsReceived, alerts)
+	}
+}
+
+func TestNotifierSendError(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer s.Close()
+
+	n := New(s.URL, time.Second)
+	if err := n.Send([]Alert{{Labels: map[string]string{"alertname": "foo"}}}); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+}
@@ -0,0 +1,237 @@
+package rule
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmalert-logs/config"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmalert-logs/notifier"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+	"github.com/cespare/xxhash/v2"
+)
+
+// AlertState is the state of an alert.
+type AlertState int
+
+// Alert states.
+const (
+	// StateInactive is the state of resolved alerts.
+	StateInactive AlertState = iota
+
+	// StatePending is the state of active alerts, which are active for less than `for` duration.
+	StatePending
+
+	// StateFiring is the state of active alerts, which are active for at least `for` duration.
+	StateFiring
+)
+
+// String returns Prometheus-compatible name for s.
+func (s AlertState) String() string {
+	switch s {
+	case StatePending:
+		return "pending"
+	case StateFiring:
+		return "firing"
+	default:
+		return "inactive"
+	}
+}
+
+// Alert is an alert produced by AlertingRule.
+type Alert struct {
+	Labels      Labels
+	Annotations map[string]string
+	State       AlertState
+	Value       float64
+
+	// ActiveAt is the time the alert became pending.
+	ActiveAt time.Time
+
+	// ResolvedAt is the time the alert has been resolved.
+	ResolvedAt time.Time
+}
+
+// AlertingRule is an alerting rule.
+type AlertingRule struct {
+	cfg config.Rule
+
+	ruleState
+
+	mu     sync.Mutex
+	alerts map[uint64]*Alert
+}
+
+func newAlertingRule(cfg config.Rule) *AlertingRule {
+	return &AlertingRule{
+		cfg:    cfg,
+		alerts: make(map[uint64]*Alert),
+	}
+}
+
+// Config returns ar config.
+func (ar *AlertingRule) Config() *config.Rule {
+	return &ar.cfg
+}
+
+// Alerts returns copies of active alerts for ar sorted by labels.
+func (ar *AlertingRule) Alerts() []Alert {
+	ar.mu.Lock()
+	alerts := make([]Alert, 0, len(ar.alerts))
+	for _, a := range ar.alerts {
+		if a.State != StateInactive {
+			alerts = append(alerts, *a)
+		}
+	}
+	ar.mu.Unlock()
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].Labels.String() < alerts[j].Labels.String()
+	})
+	return alerts
+}
+
+// AlertsState returns the state of ar according to the states of its alerts.
+func (ar *AlertingRule) AlertsState() AlertState {
+	state := StateInactive
+	for _, a := range ar.Alerts() {
+		if a.State > state {
+			state = a.State
+		}
+	}
+	return state
+}
+
+// exec evaluates ar at ts and updates its alerts.
+//
+// Alerts, which must be sent to notifiers, are returned. These are firing alerts and alerts resolved at ts.
+func (ar *AlertingRule) exec(qf QueryFunc, at *auth.Token, ts time.Time) ([]Alert, error) {
+	startTime := time.Now()
+	results, err := qf(at, ar.cfg.Expr, ts)
+	if err == nil {
+		err = ar.updateAlerts(results, ts)
+	}
+	ar.update(ts, time.Since(startTime), len(results), err)
+	if err != nil {
+		// Preserve the alerts state on errors, so temporary errors don't resolve firing alerts.
+		return nil, fmt.Errorf("cannot evaluate alerting rule %q: %w", ar.cfg.Alert, err)
+	}
+	return ar.alertsToSend(), nil
+}
+
+func (ar *AlertingRule) updateAlerts(results []netstorage.Result, ts time.Time) error {
+	type activeAlert struct {
+		labels      Labels
+		annotations map[string]string
+		value       float64
+	}
+	active := make(map[uint64]*activeAlert, len(results))
+	for i := range results {
+		r := &results[i]
+		value, ok, err := resultValue(r)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		m := resultLabels(r)
+		extraLabels, err := expandTemplates(ar.cfg.Labels, m, value)
+		if err != nil {
+			return err
+		}
+		annotations, err := expandTemplates(ar.cfg.Annotations, m, value)
+		if err != nil {
+			return err
+		}
+		for k, v := range extraLabels {
+			m[k] = v
+		}
+		m["alertname"] = ar.cfg.Alert
+		labels := labelsFromMap(m)
+		h := xxhash.Sum64String(labels.String())
+		if _, ok := active[h]; ok {
+			return fmt.Errorf("duplicate alert labels %s; make sure the query returns series with unique labels", labels)
+		}
+		active[h] = &activeAlert{
+			labels:      labels,
+			annotations: annotations,
+			value:       value,
+		}
+	}
+
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	for h, a := range ar.alerts {
+		if a.State == StateInactive {
+			// The alert has been already sent as resolved.
+			delete(ar.alerts, h)
+			continue
+		}
+		if _, ok := active[h]; ok {
+			continue
+		}
+		if a.State == StatePending {
+			delete(ar.alerts, h)
+			continue
+		}
+		a.State = StateInactive
+		a.ResolvedAt = ts
+	}
+	for h, aa := range active {
+		a := ar.alerts[h]
+		if a == nil {
+			a = &Alert{
+				Labels:   aa.labels,
+				State:    StatePending,
+				ActiveAt: ts,
+			}
+			ar.alerts[h] = a
+		}
+		a.Annotations = aa.annotations
+		a.Value = aa.value
+		if a.State == StatePending && ts.Sub(a.ActiveAt) >= ar.cfg.For {
+			a.State = StateFiring
+		}
+	}
+	return nil
+}
+
+func (ar *AlertingRule) alertsToSend() []Alert {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	var alerts []Alert
+	for _, a := range ar.alerts {
+		if a.State == StateFiring || a.State == StateInactive {
+			alerts = append(alerts, *a)
+		}
+	}
+	return alerts
+}
+
+// toNotifierAlert converts a to notifier.Alert.
+//
+// Firing alerts are valid until ts+validity unless they are re-sent.
+func toNotifierAlert(a *Alert, ts time.Time, validity time.Duration, generatorURL string) notifier.Alert {
+	na := notifier.Alert{
+		Labels:       a.Labels.Map(),
+		Annotations:  a.Annotations,
+		StartsAt:     a.ActiveAt,
+		EndsAt:       ts.Add(validity),
+		GeneratorURL: generatorURL,
+	}
+	if a.State == StateInactive {
+		na.EndsAt = a.ResolvedAt
+	}
+	return na
+}
+
+// getGeneratorURL returns url for the query, which produced alerts, at the given externalURL.
+func getGeneratorURL(externalURL, query string) string {
+	if len(externalURL) == 0 {
+		return ""
+	}
+	return externalURL + "/loki/api/v1/query?query=" + url.QueryEscape(query)
+}
@@ -0,0 +1,122 @@
+package rule
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmalert-logs/config"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+)
+
+func newResult(app string, value float64) netstorage.Result {
+	var r netstorage.Result
+	r.MetricName.AddTag("app", app)
+	r.Timestamps = []int64{0}
+	r.Values = []float64{value}
+	return r
+}
+
+func TestAlertingRuleExec(t *testing.T) {
+	var results []netstorage.Result
+	var queryErr error
+	qf := func(at *auth.Token, query string, ts time.Time) ([]netstorage.Result, error) {
+		return results, queryErr
+	}
+	ar := newAlertingRule(config.Rule{
+		Alert: "TooManyErrors",
+		Expr:  `count_over_time({app=~".+"}[1m]) > 10`,
+		For:   2 * time.Minute,
+		Labels: map[string]string{
+			"severity": "critical",
+		},
+		Annotations: map[string]string{
+			"summary": "{{ $labels.app }} has {{ $value }} errors",
+		},
+	})
+	at := &auth.Token{}
+	ts := time.Unix(1600000000, 0)
+
+	f := func(stateExpected AlertState, sentExpected []string) {
+		t.Helper()
+		alerts, err := ar.exec(qf, at, ts)
+		if queryErr != nil {
+			if err == nil {
+				t.Fatalf("expecting non-nil error")
+			}
+		} else if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if state := ar.AlertsState(); state != stateExpected {
+			t.Fatalf("unexpected state; got %s; want %s", state, stateExpected)
+		}
+		var sent []string
+		for _, a := range alerts {
+			sent = append(sent, fmt.Sprintf("%s %s", a.Labels.Map()["app"], a.State))
+		}
+		if fmt.Sprint(sent) != fmt.Sprint(sentExpected) {
+			t.Fatalf("unexpected alerts to send; got %q; want %q", sent, sentExpected)
+		}
+		ts = ts.Add(time.Minute)
+	}
+
+	// no results
+	f(StateInactive, nil)
+
+	// new alert is pending
+	results = []netstorage.Result{newResult("foo", 12)}
+	f(StatePending, nil)
+	alerts := ar.Alerts()
+	if len(alerts) != 1 {
+		t.Fatalf("unexpected number of alerts; got %d; want 1", len(alerts))
+	}
+	a := &alerts[0]
+	if s := a.Labels.String(); s != `{alertname="TooManyErrors", app="foo", severity="critical"}` {
+		t.Fatalf("unexpected alert labels: %s", s)
+	}
+	if s := a.Annotations["summary"]; s != "foo has 12 errors" {
+		t.Fatalf("unexpected alert summary: %q", s)
+	}
+	f(StatePending, nil)
+
+	// the alert is firing after `for` duration
+	f(StateFiring, []string{"foo firing"})
+
+	// the alert state is preserved on errors
+	queryErr = fmt.Errorf("some error")
+	f(StateFiring, nil)
+	if s := ar.State(); s.Health() != "err" {
+		t.Fatalf("unexpected health; got %q; want %q", s.Health(), "err")
+	}
+	queryErr = nil
+
+	// the alert is resolved and is sent once
+	results = nil
+	f(StateInactive, []string{"foo inactive"})
+	f(StateInactive, nil)
+
+	// pending alert disappears without notification
+	results = []netstorage.Result{newResult("bar", 11)}
+	f(StatePending, nil)
+	results = nil
+	f(StateInactive, nil)
+	if n := len(ar.alerts); n != 0 {
+		t.Fatalf("unexpected number of tracked alerts; got %d; want 0", n)
+	}
+}
+
+func TestAlertingRuleExecLogLines(t *testing.T) {
+	qf := func(at *auth.Token, query string, ts time.Time) ([]netstorage.Result, error) {
+		r := newResult("foo", 0)
+		r.Datas = [][]byte{[]byte("error")}
+		return []netstorage.Result{r}, nil
+	}
+	ar := newAlertingRule(config.Rule{
+		Alert: "foo",
+		Expr:  `count_over_time({app="foo"}[1m])`,
+	})
+	if _, err := ar.exec(qf, &auth.Token{}, time.Now()); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+}
@@ -0,0 +1,185 @@
+package rule
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmalert-logs/config"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmalert-logs/notifier"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/tenant"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// Group is a group of rules evaluated serially at the same interval.
+type Group struct {
+	cfg      config.Group
+	tenant   *tenant.Tenant
+	interval time.Duration
+	rules    []Rule
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newGroup(cfg config.Group, defaultInterval time.Duration) (*Group, error) {
+	t := &tenant.Tenant{
+		OrgID:     "0",
+		AuthToken: &auth.Token{},
+	}
+	if len(cfg.Tenant) > 0 {
+		tenants, err := tenant.ParseOrgIDs(cfg.Tenant)
+		if err != nil {
+			return nil, fmt.Errorf("group %q: cannot parse tenant: %w", cfg.Name, err)
+		}
+		if len(tenants) != 1 {
+			return nil, fmt.Errorf("group %q: tenant must refer to a single tenant; got %q", cfg.Name, cfg.Tenant)
+		}
+		t = tenants[0]
+	}
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	g := &Group{
+		cfg:      cfg,
+		tenant:   t,
+		interval: interval,
+		stopCh:   make(chan struct{}),
+	}
+	for _, rc := range cfg.Rules {
+		if len(rc.Alert) > 0 {
+			g.rules = append(g.rules, newAlertingRule(rc))
+		} else {
+			g.rules = append(g.rules, newRecordingRule(rc))
+		}
+	}
+	return g, nil
+}
+
+// Name returns g name.
+func (g *Group) Name() string {
+	return g.cfg.Name
+}
+
+// File returns the path to the file g was loaded from.
+func (g *Group) File() string {
+	return g.cfg.File
+}
+
+// Tenant returns the tenant g rules are evaluated for.
+func (g *Group) Tenant() *tenant.Tenant {
+	return g.tenant
+}
+
+// Interval returns g evaluation interval.
+func (g *Group) Interval() time.Duration {
+	return g.interval
+}
+
+// Config returns g config.
+func (g *Group) Config() *config.Group {
+	return &g.cfg
+}
+
+// Rules returns g rules.
+func (g *Group) Rules() []Rule {
+	return g.rules
+}
+
+func (g *Group) start(m *Manager) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		g.run(m)
+	}()
+}
+
+func (g *Group) stop() {
+	close(g.stopCh)
+	g.wg.Wait()
+}
+
+func (g *Group) run(m *Manager) {
+	// Spread evaluations of distinct groups over the interval.
+	t := time.NewTimer(time.Duration(float64(g.interval) * rand.Float64()))
+	select {
+	case <-g.stopCh:
+		t.Stop()
+		return
+	case <-t.C:
+	}
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+	g.eval(m, time.Now())
+	for {
+		select {
+		case <-g.stopCh:
+			return
+		case ts := <-ticker.C:
+			g.eval(m, ts)
+		}
+	}
+}
+
+func (g *Group) eval(m *Manager, ts time.Time) {
+	groupEvals.Inc()
+	// All the rules in the group are evaluated at the same timestamp.
+	ts = ts.Truncate(time.Second)
+	var alerts []notifier.Alert
+	var samples []Sample
+	for _, r := range g.rules {
+		ruleEvals.Inc()
+		switch r := r.(type) {
+		case *AlertingRule:
+			as, err := r.exec(m.qf, g.tenant.AuthToken, ts)
+			if err != nil {
+				ruleEvalErrors.Inc()
+				logger.Errorf("group %q: %s", g.cfg.Name, err)
+				continue
+			}
+			generatorURL := getGeneratorURL(m.externalURL, r.cfg.Expr)
+			for i := range as {
+				alerts = append(alerts, toNotifierAlert(&as[i], ts, 4*g.interval, generatorURL))
+			}
+		case *RecordingRule:
+			ss, err := r.exec(m.qf, g.tenant.AuthToken, ts)
+			if err != nil {
+				ruleEvalErrors.Inc()
+				logger.Errorf("group %q: %s", g.cfg.Name, err)
+				continue
+			}
+			samples = append(samples, ss...)
+		default:
+			logger.Panicf("BUG: unexpected rule type %T", r)
+		}
+	}
+	if len(samples) > 0 && m.wf != nil {
+		if err := m.wf(g.tenant.AuthToken, samples); err != nil {
+			samplesWriteErrors.Inc()
+			logger.Errorf("group %q: cannot write %d samples produced by recording rules: %s", g.cfg.Name, len(samples), err)
+		} else {
+			samplesWritten.Add(len(samples))
+		}
+	}
+	if len(alerts) == 0 {
+		return
+	}
+	for _, n := range m.notifiers {
+		if err := n.Send(alerts); err != nil {
+			logger.Errorf("group %q: %s", g.cfg.Name, err)
+		}
+	}
+}
+
+var (
+	groupEvals     = metrics.NewCounter(`vm_alerts_group_evals_total{name="vmalert-logs"}`)
+	ruleEvals      = metrics.NewCounter(`vm_alerts_rule_evals_total{name="vmalert-logs"}`)
+	ruleEvalErrors = metrics.NewCounter(`vm_alerts_rule_eval_errors_total{name="vmalert-logs"}`)
+
+	samplesWritten     = metrics.NewCounter(`vm_alerts_samples_written_total{name="vmalert-logs"}`)
+	samplesWriteErrors = metrics.NewCounter(`vm_alerts_samples_write_errors_total{name="vmalert-logs"}`)
+)
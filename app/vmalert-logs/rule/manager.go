@@ -0,0 +1,112 @@
+package rule
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmalert-logs/config"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmalert-logs/notifier"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+// Manager runs rule groups.
+type Manager struct {
+	qf              QueryFunc
+	wf              WriteFunc
+	notifiers       []*notifier.Notifier
+	defaultInterval time.Duration
+	externalURL     string
+
+	mu     sync.Mutex
+	groups map[string]*Group
+}
+
+// NewManager returns new Manager.
+//
+// Rules are evaluated via qf. Samples produced by recording rules are written via wf if it isn't nil. Alerts are sent to notifiers. defaultInterval is used for groups without interval.
+// externalURL is used for building generatorURL for alerts if it isn't empty.
+func NewManager(qf QueryFunc, wf WriteFunc, notifiers []*notifier.Notifier, defaultInterval time.Duration, externalURL string) *Manager {
+	return &Manager{
+		qf:              qf,
+		wf:              wf,
+		notifiers:       notifiers,
+		defaultInterval: defaultInterval,
+		externalURL:     externalURL,
+		groups:          make(map[string]*Group),
+	}
+}
+
+func groupKey(cfg *config.Group) string {
+	return cfg.File + "\x00" + cfg.Name
+}
+
+// Update updates m groups according to cfgs.
+//
+// Groups with unchanged configs continue running with the preserved alerts state.
+// Nothing is changed if cfgs contain invalid groups.
+func (m *Manager) Update(cfgs []config.Group) error {
+	newGroups := make(map[string]*Group, len(cfgs))
+	for _, cfg := range cfgs {
+		g, err := newGroup(cfg, m.defaultInterval)
+		if err != nil {
+			return err
+		}
+		key := groupKey(&cfg)
+		if _, ok := newGroups[key]; ok {
+			return fmt.Errorf("duplicate group %q in file %q", cfg.Name, cfg.File)
+		}
+		newGroups[key] = g
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, g := range m.groups {
+		ng := newGroups[key]
+		if ng != nil && reflect.DeepEqual(g.cfg, ng.cfg) && *g.tenant.AuthToken == *ng.tenant.AuthToken {
+			newGroups[key] = g
+			continue
+		}
+		g.stop()
+		logger.Infof("stopped group %q from %q", g.cfg.Name, g.cfg.File)
+	}
+	for key, g := range newGroups {
+		if m.groups[key] == g {
+			continue
+		}
+		g.start(m)
+		logger.Infof("started group %q from %q with %d rules; interval: %s", g.cfg.Name, g.cfg.File, len(g.rules), g.interval)
+	}
+	m.groups = newGroups
+	return nil
+}
+
+// Groups returns m groups sorted by file and name.
+func (m *Manager) Groups() []*Group {
+	m.mu.Lock()
+	groups := make([]*Group, 0, len(m.groups))
+	for _, g := range m.groups {
+		groups = append(groups, g)
+	}
+	m.mu.Unlock()
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := groups[i], groups[j]
+		if a.cfg.File != b.cfg.File {
+			return a.cfg.File < b.cfg.File
+		}
+		return a.cfg.Name < b.cfg.Name
+	})
+	return groups
+}
+
+// Stop stops all m groups.
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, g := range m.groups {
+		g.stop()
+	}
+	m.groups = make(map[string]*Group)
+}
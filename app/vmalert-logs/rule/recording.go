@@ -0,0 +1,110 @@
+package rule
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmalert-logs/config"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+)
+
+// RecordingRule is a recording rule.
+//
+// Its results are written back to storage as series with numeric samples named after the rule.
+type RecordingRule struct {
+	cfg config.Rule
+
+	ruleState
+}
+
+func newRecordingRule(cfg config.Rule) *RecordingRule {
+	return &RecordingRule{
+		cfg: cfg,
+	}
+}
+
+// Config returns rr config.
+func (rr *RecordingRule) Config() *config.Rule {
+	return &rr.cfg
+}
+
+// exec evaluates rr at ts and returns the produced samples.
+func (rr *RecordingRule) exec(qf QueryFunc, at *auth.Token, ts time.Time) ([]Sample, error) {
+	startTime := time.Now()
+	results, err := qf(at, rr.cfg.Expr, ts)
+	var samples []Sample
+	if err == nil {
+		samples, err = rr.toSamples(samples, results, ts.UnixNano()/1e6)
+	}
+	rr.update(ts, time.Since(startTime), len(samples), err)
+	if err != nil {
+		return nil, fmt.Errorf("cannot evaluate recording rule %q: %w", rr.cfg.Record, err)
+	}
+	return samples, nil
+}
+
+// toSamples appends samples for the last values from results at the given timestamp to dst and returns the result.
+func (rr *RecordingRule) toSamples(dst []Sample, results []netstorage.Result, timestamp int64) ([]Sample, error) {
+	seen := make(map[string]bool, len(results))
+	for i := range results {
+		r := &results[i]
+		value, ok, err := resultValue(r)
+		if err != nil {
+			return dst, err
+		}
+		if !ok {
+			continue
+		}
+		labels := rr.seriesLabels(r)
+		key := labels.String()
+		if seen[key] {
+			return dst, fmt.Errorf("duplicate series %s; make sure the query returns series with unique labels", key)
+		}
+		seen[key] = true
+		dst = append(dst, Sample{
+			Labels:    labels,
+			Timestamp: timestamp,
+			Value:     value,
+		})
+	}
+	return dst, nil
+}
+
+// seriesLabels returns labels for the series produced by rr from the query result r.
+func (rr *RecordingRule) seriesLabels(r *netstorage.Result) Labels {
+	m := resultLabels(r)
+	for k, v := range rr.cfg.Labels {
+		m[k] = v
+	}
+	m["__name__"] = rr.cfg.Record
+	return labelsFromMap(m)
+}
+
+// replay evaluates rr on the given time range with the given step via rqf and returns the produced samples.
+func (rr *RecordingRule) replay(rqf RangeQueryFunc, at *auth.Token, start, end time.Time, step time.Duration) ([]Sample, error) {
+	results, err := rqf(at, rr.cfg.Expr, start, end, step)
+	if err != nil {
+		return nil, fmt.Errorf("cannot evaluate recording rule %q on the time range [%s..%s]: %w", rr.cfg.Record, start.Format(time.RFC3339), end.Format(time.RFC3339), err)
+	}
+	var samples []Sample
+	for i := range results {
+		r := &results[i]
+		if len(r.Datas) > 0 {
+			return nil, fmt.Errorf("recording rule %q: query returned log lines for %s instead of numeric values; use LogQL metric query", rr.cfg.Record, &r.MetricName)
+		}
+		labels := rr.seriesLabels(r)
+		for j, v := range r.Values {
+			if math.IsNaN(v) {
+				continue
+			}
+			samples = append(samples, Sample{
+				Labels:    labels,
+				Timestamp: r.Timestamps[j],
+				Value:     v,
+			})
+		}
+	}
+	return samples, nil
+}
@@ -0,0 +1,127 @@
+package rule
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmalert-logs/config"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+)
+
+func TestRecordingRuleExec(t *testing.T) {
+	results := []netstorage.Result{
+		newResult("foo", 12),
+		newResult("bar", math.NaN()),
+		newResult("baz", 3),
+	}
+	qf := func(at *auth.Token, query string, ts time.Time) ([]netstorage.Result, error) {
+		return results, nil
+	}
+	rr := newRecordingRule(config.Rule{
+		Record: "app:errors:count1m",
+		Expr:   `sum by (app) (count_over_time({env="prod"}[1m]))`,
+		Labels: map[string]string{
+			"source": "ruler",
+		},
+	})
+	ts := time.Unix(1600000000, 0)
+	samples, err := rr.exec(qf, &auth.Token{}, ts)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	samplesExpected := []Sample{
+		{
+			Labels:    Labels{{"__name__", "app:errors:count1m"}, {"app", "foo"}, {"source", "ruler"}},
+			Timestamp: 1600000000000,
+			Value:     12,
+		},
+		{
+			Labels:    Labels{{"__name__", "app:errors:count1m"}, {"app", "baz"}, {"source", "ruler"}},
+			Timestamp: 1600000000000,
+			Value:     3,
+		},
+	}
+	if !reflect.DeepEqual(samples, samplesExpected) {
+		t.Fatalf("unexpected samples\ngot\n%+v\nwant\n%+v", samples, samplesExpected)
+	}
+	if s := rr.State(); s.LastSamples != 2 || s.Health() != "ok" {
+		t.Fatalf("unexpected state: %+v", s)
+	}
+
+	// Series with duplicate labels after applying rule labels.
+	results = []netstorage.Result{newResult("foo", 1), newResult("foo", 2)}
+	if _, err := rr.exec(qf, &auth.Token{}, ts); err == nil {
+		t.Fatalf("expecting non-nil error for duplicate series")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	type rangeQuery struct {
+		start, end time.Time
+		step       time.Duration
+	}
+	var queries []rangeQuery
+	rqf := func(at *auth.Token, query string, start, end time.Time, step time.Duration) ([]netstorage.Result, error) {
+		if at.AccountID != 42 {
+			return nil, fmt.Errorf("unexpected tenant: %d", at.AccountID)
+		}
+		queries = append(queries, rangeQuery{start, end, step})
+		var r netstorage.Result
+		r.MetricName.AddTag("app", "foo")
+		for ts := start; !ts.After(end); ts = ts.Add(step) {
+			r.Timestamps = append(r.Timestamps, ts.UnixNano()/1e6)
+			r.Values = append(r.Values, 1)
+		}
+		return []netstorage.Result{r}, nil
+	}
+	var samples []Sample
+	wf := func(at *auth.Token, ss []Sample) error {
+		samples = append(samples, ss...)
+		return nil
+	}
+	cfgs := []config.Group{{
+		Name:     "foo",
+		Interval: time.Minute,
+		Tenant:   "42",
+		Rules: []config.Rule{
+			{
+				Record: "app:lines:count1m",
+				Expr:   `count_over_time({app="foo"}[1m])`,
+			},
+			{
+				Alert: "Lines",
+				Expr:  `count_over_time({app="foo"}[1m]) > 0`,
+			},
+		},
+	}}
+	from := time.Unix(1600000010, 0).UTC()
+	to := from.Add(10 * time.Minute)
+	if err := Replay(cfgs, rqf, wf, from, to, time.Minute, 4); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	start := time.Unix(1600000020, 0).UTC()
+	queriesExpected := []rangeQuery{
+		{start, start.Add(3 * time.Minute), time.Minute},
+		{start.Add(4 * time.Minute), start.Add(7 * time.Minute), time.Minute},
+		{start.Add(8 * time.Minute), to, time.Minute},
+	}
+	if !reflect.DeepEqual(queries, queriesExpected) {
+		t.Fatalf("unexpected queries\ngot\n%v\nwant\n%v", queries, queriesExpected)
+	}
+	if len(samples) != 10 {
+		t.Fatalf("unexpected number of samples; got %d; want 10", len(samples))
+	}
+	for i, s := range samples {
+		if tsExpected := start.Add(time.Duration(i)*time.Minute).UnixNano() / 1e6; s.Timestamp != tsExpected {
+			t.Fatalf("unexpected timestamp for sample #%d; got %d; want %d", i, s.Timestamp, tsExpected)
+		}
+	}
+
+	if err := Replay(cfgs, rqf, wf, to, from, time.Minute, 4); err == nil {
+		t.Fatalf("expecting non-nil error for invalid time range")
+	}
+}
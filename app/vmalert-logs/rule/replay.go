@@ -0,0 +1,70 @@
+package rule
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmalert-logs/config"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+// Replay evaluates recording rules from cfgs on the time range [from..to] and writes the produced samples via wf.
+//
+// Every group is evaluated at its interval, so the written samples are equal to samples written by regularly running groups.
+// Rules are evaluated via range queries with up to maxPointsPerQuery points each. Alerting rules are skipped.
+func Replay(cfgs []config.Group, rqf RangeQueryFunc, wf WriteFunc, from, to time.Time, defaultInterval time.Duration, maxPointsPerQuery int) error {
+	if !from.Before(to) {
+		return fmt.Errorf("replay start must be smaller than replay end; got [%s..%s]", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+	if maxPointsPerQuery <= 0 {
+		return fmt.Errorf("maxPointsPerQuery must be positive; got %d", maxPointsPerQuery)
+	}
+	for _, cfg := range cfgs {
+		g, err := newGroup(cfg, defaultInterval)
+		if err != nil {
+			return err
+		}
+		if err := g.replay(rqf, wf, from, to, maxPointsPerQuery); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *Group) replay(rqf RangeQueryFunc, wf WriteFunc, from, to time.Time, maxPointsPerQuery int) error {
+	at := g.tenant.AuthToken
+	// Align the evaluation timestamps to the interval.
+	start := from.Truncate(g.interval)
+	if start.Before(from) {
+		start = start.Add(g.interval)
+	}
+	chunkDuration := g.interval * time.Duration(maxPointsPerQuery-1)
+	for _, r := range g.rules {
+		rr, ok := r.(*RecordingRule)
+		if !ok {
+			logger.Infof("group %q: skipping alerting rule %q during replay", g.cfg.Name, r.Config().Alert)
+			continue
+		}
+		samplesTotal := 0
+		for chunkStart := start; !chunkStart.After(to); chunkStart = chunkStart.Add(chunkDuration + g.interval) {
+			chunkEnd := chunkStart.Add(chunkDuration)
+			if chunkEnd.After(to) {
+				chunkEnd = to
+			}
+			samples, err := rr.replay(rqf, at, chunkStart, chunkEnd, g.interval)
+			if err != nil {
+				return fmt.Errorf("group %q: %w", g.cfg.Name, err)
+			}
+			if len(samples) == 0 {
+				continue
+			}
+			if err := wf(at, samples); err != nil {
+				return fmt.Errorf("group %q: cannot write %d samples produced by recording rule %q: %w", g.cfg.Name, len(samples), rr.cfg.Record, err)
+			}
+			samplesTotal += len(samples)
+		}
+		logger.Infof("group %q: replayed recording rule %q on [%s..%s]; written samples: %d",
+			g.cfg.Name, rr.cfg.Record, from.Format(time.RFC3339), to.Format(time.RFC3339), samplesTotal)
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package rule
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmalert-logs/config"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+)
+
+// QueryFunc evaluates the instant LogQL query for the tenant at at the given ts.
+type QueryFunc func(at *auth.Token, query string, ts time.Time) ([]netstorage.Result, error)
+
+// RangeQueryFunc evaluates the range LogQL query for the tenant at on the given time range with the given step.
+type RangeQueryFunc func(at *auth.Token, query string, start, end time.Time, step time.Duration) ([]netstorage.Result, error)
+
+// Sample is a sample produced by recording rule.
+type Sample struct {
+	Labels Labels
+
+	// Timestamp is unix timestamp in milliseconds.
+	Timestamp int64
+
+	Value float64
+}
+
+// WriteFunc writes samples produced by recording rules for the tenant at.
+type WriteFunc func(at *auth.Token, samples []Sample) error
+
+// Rule is an alerting or recording rule.
+type Rule interface {
+	// Config returns the rule config.
+	Config() *config.Rule
+
+	// State returns the state of the last rule evaluation.
+	State() State
+}
+
+// State is the state of the last rule evaluation.
+type State struct {
+	// LastEvaluation is the time of the last evaluation.
+	LastEvaluation time.Time
+
+	// EvaluationDuration is the duration of the last evaluation.
+	EvaluationDuration time.Duration
+
+	// LastError is the error of the last evaluation.
+	LastError error
+
+	// LastSamples is the number of series returned by the last evaluation.
+	LastSamples int
+}
+
+// Health returns Prometheus-compatible health of the rule according to s.
+func (s *State) Health() string {
+	if s.LastError != nil {
+		return "err"
+	}
+	if s.LastEvaluation.IsZero() {
+		return "unknown"
+	}
+	return "ok"
+}
+
+// ruleState holds State for concurrent access.
+type ruleState struct {
+	mu sync.Mutex
+	s  State
+}
+
+func (rs *ruleState) State() State {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.s
+}
+
+func (rs *ruleState) update(ts time.Time, d time.Duration, samples int, err error) {
+	rs.mu.Lock()
+	rs.s = State{
+		LastEvaluation:     ts,
+		EvaluationDuration: d,
+		LastError:          err,
+		LastSamples:        samples,
+	}
+	rs.mu.Unlock()
+}
+
+// Label is a label of a series or an alert.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Labels is a list of labels sorted by name.
+type Labels []Label
+
+// Map returns labels as a map.
+func (ls Labels) Map() map[string]string {
+	m := make(map[string]string, len(ls))
+	for _, l := range ls {
+		m[l.Name] = l.Value
+	}
+	return m
+}
+
+// String returns string representation of ls.
+func (ls Labels) String() string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, l := range ls {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%s=%q", l.Name, l.Value)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func labelsFromMap(m map[string]string) Labels {
+	ls := make(Labels, 0, len(m))
+	for name, value := range m {
+		ls = append(ls, Label{
+			Name:  name,
+			Value: value,
+		})
+	}
+	sort.Slice(ls, func(i, j int) bool {
+		return ls[i].Name < ls[j].Name
+	})
+	return ls
+}
+
+// resultLabels returns labels for the query result r.
+//
+// Metric name isn't included in the labels, since it is usually missing in results of LogQL metric queries.
+func resultLabels(r *netstorage.Result) map[string]string {
+	m := make(map[string]string, len(r.MetricName.Tags))
+	for _, tag := range r.MetricName.Tags {
+		m[string(tag.Key)] = string(tag.Value)
+	}
+	return m
+}
+
+// resultValue returns the last value from r.
+//
+// false is returned if r contains log lines instead of numeric values.
+func resultValue(r *netstorage.Result) (float64, bool, error) {
+	if len(r.Datas) > 0 {
+		return 0, false, fmt.Errorf("query returned log lines for %s instead of numeric values; use LogQL metric query", &r.MetricName)
+	}
+	if len(r.Values) == 0 {
+		return 0, false, nil
+	}
+	v := r.Values[len(r.Values)-1]
+	if math.IsNaN(v) {
+		return 0, false, nil
+	}
+	return v, true, nil
+}
+
+// templateData is passed to label and annotation templates.
+type templateData struct {
+	Labels map[string]string
+	Value  float64
+}
+
+// expandTemplates returns tpls with expanded templates for the given labels and value.
+func expandTemplates(tpls map[string]string, labels map[string]string, value float64) (map[string]string, error) {
+	if len(tpls) == 0 {
+		return nil, nil
+	}
+	m := make(map[string]string, len(tpls))
+	data := &templateData{
+		Labels: labels,
+		Value:  value,
+	}
+	var bb bytes.Buffer
+	for k, s := range tpls {
+		if !strings.Contains(s, "{{") {
+			m[k] = s
+			continue
+		}
+		t, err := template.New(k).Option("missingkey=zero").Parse(config.TemplatePrefix + s)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse template for %q: %w", k, err)
+		}
+		bb.Reset()
+		if err := t.Execute(&bb, data); err != nil {
+			return nil, fmt.Errorf("cannot execute template for %q: %w", k, err)
+		}
+		m[k] = bb.String()
+	}
+	return m, nil
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/netstorage"
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/relabel"
 	parser "github.com/VictoriaMetrics/VictoriaLogs/lib/protoparser/importer"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/tenantmetrics"
@@ -36,6 +37,7 @@ func insertRows(at *auth.Token, rows []parser.Row) error {
 	ctx.Reset() // This line is required for initializing ctx internals.
 	atCopy := *at
 	hasRelabeling := relabel.HasRelabeling()
+	var valueBuf []byte
 	for i := range rows {
 		r := &rows[i]
 		ctx.Labels = ctx.Labels[:0]
@@ -61,7 +63,9 @@ func insertRows(at *auth.Token, rows []parser.Row) error {
 			// Skip metric without labels.
 			continue
 		}
-		if err := ctx.WriteDataPoint(&atCopy, ctx.Labels, r.Timestamp, r.Value); err != nil {
+		// Lines starting with structured metadata marker must be escaped.
+		valueBuf = storage.MarshalLineWithMetadata(valueBuf[:0], r.Value, nil)
+		if err := ctx.WriteDataPoint(&atCopy, ctx.Labels, r.Timestamp, valueBuf); err != nil {
 			return err
 		}
 	}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -13,7 +14,10 @@ import (
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/netstorage"
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/relabel"
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/remotewrite"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/clustertls"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/httpauth"
 	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/tenant"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/buildinfo"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/cgroup"
@@ -48,10 +52,13 @@ func main() {
 	if len(*storageNodes) == 0 {
 		logger.Fatalf("missing -storageNode arg")
 	}
+	clustertls.Init()
 	netstorage.InitStorageNodes(*storageNodes)
 	logger.Infof("successfully initialized netstorage in %.3f seconds", time.Since(startTime).Seconds())
 
 	relabel.Init()
+	tenant.Init()
+	httpauth.Init()
 	storage.SetMaxLabelsPerTimeseries(*maxLabelsPerTimeseries)
 	common.StartUnmarshalWorkers()
 	writeconcurrencylimiter.Init()
@@ -90,6 +97,24 @@ func requestHandler(w http.ResponseWriter, r *http.Request) bool {
 		fmt.Fprintf(w, "vminsert - a component of VictoriaMetrics cluster. See docs at https://victoriametrics.github.io/Cluster-VictoriaMetrics.html")
 		return true
 	}
+	if strings.HasPrefix(r.URL.Path, "/loki/") {
+		// Loki path without tenant in it. The tenant is read from X-Scope-OrgID header.
+		tenants, err := tenant.GetTenants(r)
+		if err != nil {
+			httpserver.Errorf(w, r, "auth error: %s", err)
+			return true
+		}
+		if len(tenants) > 1 {
+			httpserver.Errorf(w, r, "auth error: data cannot be written to multiple tenants from %s header", tenant.OrgIDHeader)
+			return true
+		}
+		p := &httpserver.Path{
+			Prefix: "insert",
+			Suffix: r.URL.Path[1:],
+		}
+		return insertHandler(w, r, p, tenants[0].AuthToken)
+	}
+
 	p, err := httpserver.ParsePath(r.URL.Path)
 	if err != nil {
 		httpserver.Errorf(w, r, "cannot parse path %q: %s", r.URL.Path, err)
@@ -104,9 +129,17 @@ func requestHandler(w http.ResponseWriter, r *http.Request) bool {
 		httpserver.Errorf(w, r, "auth error: %s", err)
 		return true
 	}
+	return insertHandler(w, r, p, at)
+}
+
+func insertHandler(w http.ResponseWriter, r *http.Request, p *httpserver.Path, at *auth.Token) bool {
 
 	switch p.Suffix {
 	case "loki/api/v1/push":
+		if err := httpauth.Authorize(r, httpauth.OpPush, []*auth.Token{at}); err != nil {
+			httpserver.Errorf(w, r, "%s", err)
+			return true
+		}
 		prometheusWriteRequests.Inc()
 		if err := remotewrite.InsertHandler(at, r); err != nil {
 			prometheusWriteErrors.Inc()
@@ -41,6 +41,8 @@ func insertRows(at *auth.Token, timeseries []lokipb.Stream) error {
 
 	var err error
 	var tail []byte
+	var metadata []storage.Label
+	var valueBuf []byte
 	for i := range timeseries {
 		ts := &timeseries[i]
 		ctx.Labels = ctx.Labels[:0]
@@ -66,7 +68,16 @@ func insertRows(at *auth.Token, timeseries []lokipb.Stream) error {
 			if len(ctx.MetricNameBuf) == 0 {
 				ctx.MetricNameBuf = storage.MarshalMetricNameRaw(ctx.MetricNameBuf[:0], at.AccountID, at.ProjectID, ctx.Labels)
 			}
-			if err := ctx.WriteDataPointExt(at, storageNodeIdx, ctx.MetricNameBuf, r.Timestamp.UnixNano()/1e6, bytesutil.ToUnsafeBytes(r.Line)); err != nil {
+			metadata = metadata[:0]
+			for j := range r.StructuredMetadata {
+				label := &r.StructuredMetadata[j]
+				metadata = append(metadata, storage.Label{
+					Name:  bytesutil.ToUnsafeBytes(label.Name),
+					Value: bytesutil.ToUnsafeBytes(label.Value),
+				})
+			}
+			valueBuf = storage.MarshalLineWithMetadata(valueBuf[:0], bytesutil.ToUnsafeBytes(r.Line), metadata)
+			if err := ctx.WriteDataPointExt(at, storageNodeIdx, ctx.MetricNameBuf, r.Timestamp.UnixNano()/1e6, valueBuf); err != nil {
 				return err
 			}
 		}
@@ -0,0 +1,49 @@
+package loki
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/searchutils"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/tenant"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+)
+
+// GetCrossTenants returns tenants for cross-tenant query from `tenant` args of r.
+//
+// Each `tenant` arg must be in the form `accountID[:projectID]`. All the tenants
+// with streams in vmstorage nodes are returned if r has no `tenant` args.
+func GetCrossTenants(startTime time.Time, r *http.Request) ([]*tenant.Tenant, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, fmt.Errorf("cannot parse form values: %w", err)
+	}
+	authTokens := r.Form["tenant"]
+	if len(authTokens) == 0 {
+		deadline := searchutils.GetDeadlineForQuery(r, startTime)
+		var isPartial bool
+		var err error
+		authTokens, isPartial, err = netstorage.GetTenants(deadline)
+		if err != nil {
+			return nil, err
+		}
+		if isPartial && searchutils.GetDenyPartialResponse(r) {
+			return nil, fmt.Errorf("cannot return full response, since some of vmstorage nodes are unavailable")
+		}
+	}
+	tenants := make([]*tenant.Tenant, 0, len(authTokens))
+	seen := make(map[auth.Token]bool, len(authTokens))
+	for _, authToken := range authTokens {
+		at, err := auth.NewToken(authToken)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse `tenant` arg: %w", err)
+		}
+		if seen[*at] {
+			continue
+		}
+		seen[*at] = true
+		tenants = append(tenants, tenant.NewCrossTenant(at))
+	}
+	return tenants, nil
+}
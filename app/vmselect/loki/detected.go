@@ -0,0 +1,512 @@
+package loki
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/querier"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/searchutils"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vmselect/bufferedwriter"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/valyala/fastjson"
+)
+
+// Default limits for /loki/api/v1/detected_fields if not set.
+const (
+	defaultDetectedFieldsLineLimit  = 1000
+	defaultDetectedFieldsFieldLimit = 1000
+)
+
+// detectedFieldsSampleRowsPerBlock is the number of log lines sampled by vmstorage per each block for /loki/api/v1/detected_fields.
+//
+// Small value spreads the sample over more streams and blocks.
+const detectedFieldsSampleRowsPerBlock = 10
+
+// DetectedFieldsHandler processes /loki/api/v1/detected_fields request.
+//
+// Fields are detected in up to `line_limit` log lines sampled from the lines matching `query`.
+//
+// See https://grafana.com/docs/loki/latest/reference/loki-http-api/#query-detected-fields
+func DetectedFieldsHandler(startTime time.Time, at *auth.Token, w http.ResponseWriter, r *http.Request) error {
+	ct := startTime.UnixNano() / 1e6
+	query := r.FormValue("query")
+	if len(query) == 0 {
+		return fmt.Errorf("missing `query` arg")
+	}
+	end, err := searchutils.GetTime(r, "end", ct)
+	if err != nil {
+		return err
+	}
+	start, err := searchutils.GetTime(r, "start", end-defaultStep)
+	if err != nil {
+		return err
+	}
+	if start >= end {
+		end = start + defaultStep
+	}
+	lineLimit, err := searchutils.GetInt64(r, "line_limit", defaultDetectedFieldsLineLimit)
+	if err != nil {
+		return err
+	}
+	fieldLimit, err := searchutils.GetInt64(r, "field_limit", defaultDetectedFieldsFieldLimit)
+	if err != nil {
+		return err
+	}
+	deadline := searchutils.GetDeadlineForQuery(r, startTime)
+
+	tq, ok, err := querier.ParseTailQuery(query)
+	if err != nil {
+		return fmt.Errorf("cannot parse `query` arg: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("`query` arg must contain a log stream selector with optional structured metadata and pattern filters; got %q", query)
+	}
+	sq := &storage.SearchQuery{
+		AccountID:    at.AccountID,
+		ProjectID:    at.ProjectID,
+		MinTimestamp: start,
+		MaxTimestamp: end,
+		TagFilterss:  searchutils.JoinTagFilterss([][]storage.TagFilter{tq.TagFilters}, searchutils.GetEnforcedTagFilters(r)),
+		FetchData:    storage.FetchAll,
+	}
+	ls := newLinesSampler(int(lineLimit))
+	isPartial, err := netstorage.SampleRows(at, sq, detectedFieldsSampleRowsPerBlock, deadline, func(sb *storage.SampledBlock) error {
+		for _, data := range sb.Values {
+			if tq.MatchData(data) {
+				ls.add(data)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot sample log lines for %q: %w", sq, err)
+	}
+	if isPartial && searchutils.GetDenyPartialResponse(r) {
+		return fmt.Errorf("cannot return full response, since some of vmstorage nodes are unavailable")
+	}
+	fields := getDetectedFields(ls.datas, int(fieldLimit))
+
+	w.Header().Set("Content-Type", "application/json")
+	bw := bufferedwriter.Get(w)
+	defer bufferedwriter.Put(bw)
+	WriteDetectedFieldsResponse(bw, fields, int(fieldLimit))
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	detectedFieldsDuration.UpdateDuration(startTime)
+	return nil
+}
+
+var detectedFieldsDuration = metrics.NewSummary(`vm_request_duration_seconds{path="/loki/api/v1/detected_fields"}`)
+
+// linesSampler selects up to maxLines random lines out of all the added lines.
+//
+// See https://en.wikipedia.org/wiki/Reservoir_sampling
+type linesSampler struct {
+	maxLines int
+	n        int
+	rng      *rand.Rand
+	datas    [][]byte
+}
+
+func newLinesSampler(maxLines int) *linesSampler {
+	return &linesSampler{
+		maxLines: maxLines,
+		rng:      rand.New(rand.NewSource(1)),
+	}
+}
+
+func (ls *linesSampler) add(data []byte) {
+	ls.n++
+	if len(ls.datas) < ls.maxLines {
+		ls.datas = append(ls.datas, append([]byte{}, data...))
+		return
+	}
+	if i := ls.rng.Intn(ls.n); i < ls.maxLines {
+		ls.datas[i] = append(ls.datas[i][:0], data...)
+	}
+}
+
+// detectedField is a field detected in log lines.
+type detectedField struct {
+	label   string
+	typ     string
+	parsers []string
+	values  map[string]struct{}
+}
+
+// Parsers used for detecting fields in log lines.
+const (
+	parserJSON   = "json"
+	parserLogfmt = "logfmt"
+)
+
+// getDetectedFields returns up to fieldLimit fields found in structured metadata and in log lines from datas.
+//
+// Log lines in JSON and logfmt formats are parsed.
+func getDetectedFields(datas [][]byte, fieldLimit int) []*detectedField {
+	m := make(map[string]*detectedField)
+	addField := func(name, value, parser string) {
+		name = sanitizeFieldName(name)
+		if len(name) == 0 {
+			return
+		}
+		f := m[name]
+		if f == nil {
+			f = &detectedField{
+				label:  name,
+				values: make(map[string]struct{}),
+			}
+			m[name] = f
+		}
+		f.typ = mergeFieldTypes(f.typ, guessFieldType(value))
+		f.values[value] = struct{}{}
+		if len(parser) > 0 && !containsString(f.parsers, parser) {
+			f.parsers = append(f.parsers, parser)
+			sort.Strings(f.parsers)
+		}
+	}
+	var p fastjson.Parser
+	var metadata []storage.Label
+	for _, data := range datas {
+		line, md, err := storage.UnmarshalLineWithMetadata(metadata[:0], data)
+		if err != nil {
+			continue
+		}
+		metadata = md
+		for i := range metadata {
+			addField(string(metadata[i].Name), string(metadata[i].Value), "")
+		}
+		s := strings.TrimSpace(string(line))
+		if strings.HasPrefix(s, "{") {
+			v, err := p.Parse(s)
+			if err == nil && v.Type() == fastjson.TypeObject {
+				visitJSONFields(v, "", func(name, value string) {
+					addField(name, value, parserJSON)
+				})
+				continue
+			}
+		}
+		visitLogfmtFields(s, func(name, value string) {
+			addField(name, value, parserLogfmt)
+		})
+	}
+
+	fields := make([]*detectedField, 0, len(m))
+	for _, f := range m {
+		fields = append(fields, f)
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].label < fields[j].label
+	})
+	if fieldLimit > 0 && len(fields) > fieldLimit {
+		fields = fields[:fieldLimit]
+	}
+	return fields
+}
+
+// visitJSONFields calls f for every scalar field in the JSON object v.
+//
+// Names of nested fields are joined with `_` like Loki json parser does.
+func visitJSONFields(v *fastjson.Value, prefix string, f func(name, value string)) {
+	o, _ := v.Object()
+	o.Visit(func(key []byte, v *fastjson.Value) {
+		name := string(key)
+		if len(prefix) > 0 {
+			name = prefix + "_" + name
+		}
+		switch v.Type() {
+		case fastjson.TypeObject:
+			visitJSONFields(v, name, f)
+		case fastjson.TypeString:
+			f(name, string(v.GetStringBytes()))
+		case fastjson.TypeNumber, fastjson.TypeTrue, fastjson.TypeFalse:
+			f(name, v.String())
+		}
+	})
+}
+
+// visitLogfmtFields calls f for every `key=value` pair in the logfmt line s.
+//
+// Tokens without `=` are skipped. See https://brandur.org/logfmt
+func visitLogfmtFields(s string, f func(name, value string)) {
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " \t")
+		n := strings.IndexAny(s, "= \t")
+		if n < 0 {
+			return
+		}
+		if s[n] != '=' {
+			// Skip the token without value.
+			s = s[n:]
+			continue
+		}
+		name := s[:n]
+		s = s[n+1:]
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			n = closingQuoteIndex(s)
+			if n < 0 {
+				// Unclosed quote.
+				return
+			}
+			v, err := strconv.Unquote(s[:n+1])
+			if err != nil {
+				v = s[1:n]
+			}
+			value = v
+			s = s[n+1:]
+		} else {
+			n = strings.IndexAny(s, " \t")
+			if n < 0 {
+				n = len(s)
+			}
+			value = s[:n]
+			s = s[n:]
+		}
+		if len(name) > 0 {
+			f(name, value)
+		}
+	}
+}
+
+// closingQuoteIndex returns the index of the quote closing the quoted string at the start of s.
+//
+// -1 is returned if the quoted string isn't closed.
+func closingQuoteIndex(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// sanitizeFieldName converts name to a valid label name like Loki does for the extracted fields.
+func sanitizeFieldName(name string) string {
+	b := []byte(strings.TrimSpace(name))
+	for i, c := range b {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	if len(b) > 0 && b[0] >= '0' && b[0] <= '9' {
+		return "_" + string(b)
+	}
+	return string(b)
+}
+
+// Field types returned by /loki/api/v1/detected_fields.
+const (
+	fieldTypeString   = "string"
+	fieldTypeInt      = "int"
+	fieldTypeFloat    = "float"
+	fieldTypeBoolean  = "boolean"
+	fieldTypeDuration = "duration"
+	fieldTypeBytes    = "bytes"
+)
+
+var bytesValueRegexp = regexp.MustCompile(`(?i)^[0-9]+(\.[0-9]+)?\s?[kmgtpe]?i?b$`)
+
+func guessFieldType(value string) string {
+	if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return fieldTypeInt
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return fieldTypeFloat
+	}
+	if strings.EqualFold(value, "true") || strings.EqualFold(value, "false") {
+		return fieldTypeBoolean
+	}
+	if _, err := time.ParseDuration(value); err == nil {
+		return fieldTypeDuration
+	}
+	if bytesValueRegexp.MatchString(value) {
+		return fieldTypeBytes
+	}
+	return fieldTypeString
+}
+
+// mergeFieldTypes returns the type suitable for values of both a and b types.
+func mergeFieldTypes(a, b string) string {
+	switch {
+	case len(a) == 0 || a == b:
+		return b
+	case a == fieldTypeInt && b == fieldTypeFloat, a == fieldTypeFloat && b == fieldTypeInt:
+		return fieldTypeFloat
+	default:
+		return fieldTypeString
+	}
+}
+
+func containsString(a []string, s string) bool {
+	for _, x := range a {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// maxDetectedLabelCardinality is the maximum cardinality for labels returned from /loki/api/v1/detected_labels.
+//
+// Labels with higher cardinality aren't useful for grouping log lines.
+const maxDetectedLabelCardinality = 50
+
+// maxDetectedLabelsDays is the maximum number of days, which are inspected by /loki/api/v1/detected_labels without `query` arg.
+const maxDetectedLabelsDays = 31
+
+// detectedLabel is a label suggested for grouping log lines.
+type detectedLabel struct {
+	label       string
+	cardinality uint64
+}
+
+// DetectedLabelsHandler processes /loki/api/v1/detected_labels request.
+//
+// Label cardinality is calculated from the index for streams matching `query` arg.
+// If `query` arg is missing, then per-day index stats are used for all the tenant streams.
+//
+// See https://grafana.com/docs/loki/latest/reference/loki-http-api/#query-detected-labels
+func DetectedLabelsHandler(startTime time.Time, at *auth.Token, w http.ResponseWriter, r *http.Request) error {
+	var cardinalities map[string]uint64
+	// Per-day tsdb status cannot be limited to streams allowed for the user, so stream stats are used for users with enforced filters.
+	if len(r.FormValue("query")) > 0 || len(searchutils.GetEnforcedTagFilters(r)) > 0 {
+		sss, _, _, err := getStreamStats(startTime, at, r)
+		if err != nil {
+			return err
+		}
+		cardinalities, err = getLabelCardinalitiesFromStreamStats(sss)
+		if err != nil {
+			return err
+		}
+	} else {
+		m, err := getLabelCardinalitiesFromTSDBStatus(startTime, at, r)
+		if err != nil {
+			return err
+		}
+		cardinalities = m
+	}
+	labels := getDetectedLabels(cardinalities)
+
+	w.Header().Set("Content-Type", "application/json")
+	bw := bufferedwriter.Get(w)
+	defer bufferedwriter.Put(bw)
+	WriteDetectedLabelsResponse(bw, labels)
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	detectedLabelsDuration.UpdateDuration(startTime)
+	return nil
+}
+
+var detectedLabelsDuration = metrics.NewSummary(`vm_request_duration_seconds{path="/loki/api/v1/detected_labels"}`)
+
+// getLabelCardinalitiesFromStreamStats returns the number of unique values per label for streams from sss.
+func getLabelCardinalitiesFromStreamStats(sss []storage.StreamStats) (map[string]uint64, error) {
+	values := make(map[string]map[string]struct{})
+	var mn storage.MetricName
+	for i := range sss {
+		if err := mn.Unmarshal(sss[i].MetricName); err != nil {
+			return nil, fmt.Errorf("cannot unmarshal MetricName for stream stats: %w", err)
+		}
+		for j := range mn.Tags {
+			tag := &mn.Tags[j]
+			m := values[string(tag.Key)]
+			if m == nil {
+				m = make(map[string]struct{})
+				values[string(tag.Key)] = m
+			}
+			m[string(tag.Value)] = struct{}{}
+		}
+	}
+	cardinalities := make(map[string]uint64, len(values))
+	for label, m := range values {
+		cardinalities[label] = uint64(len(m))
+	}
+	return cardinalities, nil
+}
+
+// getLabelCardinalitiesFromTSDBStatus returns the number of unique values per label for all the tenant streams
+// on the time range from `start` and `end` args.
+//
+// The maximum per-day cardinality is returned for time ranges spanning multiple days.
+func getLabelCardinalitiesFromTSDBStatus(startTime time.Time, at *auth.Token, r *http.Request) (map[string]uint64, error) {
+	ct := startTime.UnixNano() / 1e6
+	end, err := searchutils.GetTime(r, "end", ct)
+	if err != nil {
+		return nil, err
+	}
+	start, err := searchutils.GetTime(r, "start", end-defaultStep)
+	if err != nil {
+		return nil, err
+	}
+	deadline := searchutils.GetDeadlineForQuery(r, startTime)
+	endDate := uint64(end) / 1e3 / secsPerDay
+	startDate := uint64(start) / 1e3 / secsPerDay
+	if start < 0 || startDate > endDate {
+		startDate = endDate
+	}
+	if endDate-startDate >= maxDetectedLabelsDays {
+		startDate = endDate - maxDetectedLabelsDays + 1
+	}
+	cardinalities := make(map[string]uint64)
+	for date := startDate; date <= endDate; date++ {
+		status, isPartial, err := netstorage.GetTSDBStatusForDate(at, deadline, date, maxDetectedLabelCardinality*20)
+		if err != nil {
+			return nil, fmt.Errorf("cannot obtain tsdb status for date=%d: %w", date, err)
+		}
+		if isPartial && searchutils.GetDenyPartialResponse(r) {
+			return nil, fmt.Errorf("cannot return full response, since some of vmstorage nodes are unavailable")
+		}
+		for _, e := range status.LabelValueCountByLabelName {
+			if e.Count > cardinalities[e.Name] {
+				cardinalities[e.Name] = e.Count
+			}
+		}
+	}
+	return cardinalities, nil
+}
+
+// alwaysDetectedLabels contains labels, which are suggested for grouping regardless of their cardinality.
+var alwaysDetectedLabels = map[string]bool{
+	"cluster":   true,
+	"namespace": true,
+	"instance":  true,
+	"pod":       true,
+}
+
+// getDetectedLabels returns labels from cardinalities, which are suitable for grouping log lines.
+//
+// Labels with a single value or with too many values are skipped like Loki does.
+func getDetectedLabels(cardinalities map[string]uint64) []detectedLabel {
+	var labels []detectedLabel
+	for label, cardinality := range cardinalities {
+		if label == "__name__" || len(label) == 0 {
+			continue
+		}
+		if !alwaysDetectedLabels[label] && (cardinality < 2 || cardinality > maxDetectedLabelCardinality) {
+			continue
+		}
+		labels = append(labels, detectedLabel{
+			label:       label,
+			cardinality: cardinality,
+		})
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].label < labels[j].label
+	})
+	return labels
+}
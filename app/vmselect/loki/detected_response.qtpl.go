@@ -0,0 +1,166 @@
+// Code generated by qtc from "detected_response.qtpl". DO NOT EDIT.
+// See https://github.com/valyala/quicktemplate for details.
+
+// DetectedFieldsResponse generates response for /loki/api/v1/detected_fields .
+
+//line app/vmselect/loki/detected_response.qtpl:3
+package loki
+
+//line app/vmselect/loki/detected_response.qtpl:3
+import (
+	qtio422016 "io"
+
+	qt422016 "github.com/valyala/quicktemplate"
+)
+
+//line app/vmselect/loki/detected_response.qtpl:3
+var (
+	_ = qtio422016.Copy
+	_ = qt422016.AcquireByteBuffer
+)
+
+//line app/vmselect/loki/detected_response.qtpl:3
+func StreamDetectedFieldsResponse(qw422016 *qt422016.Writer, fields []*detectedField, limit int) {
+//line app/vmselect/loki/detected_response.qtpl:3
+	qw422016.N().S(`{"fields":[`)
+//line app/vmselect/loki/detected_response.qtpl:6
+	for i, f := range fields {
+//line app/vmselect/loki/detected_response.qtpl:6
+		qw422016.N().S(`{"label":`)
+//line app/vmselect/loki/detected_response.qtpl:8
+		qw422016.N().Q(f.label)
+//line app/vmselect/loki/detected_response.qtpl:8
+		qw422016.N().S(`,"type":`)
+//line app/vmselect/loki/detected_response.qtpl:9
+		qw422016.N().Q(f.typ)
+//line app/vmselect/loki/detected_response.qtpl:9
+		qw422016.N().S(`,"cardinality":`)
+//line app/vmselect/loki/detected_response.qtpl:10
+		qw422016.N().D(len(f.values))
+//line app/vmselect/loki/detected_response.qtpl:10
+		qw422016.N().S(`,"parsers":`)
+//line app/vmselect/loki/detected_response.qtpl:12
+		if len(f.parsers) == 0 {
+//line app/vmselect/loki/detected_response.qtpl:12
+			qw422016.N().S(`null`)
+//line app/vmselect/loki/detected_response.qtpl:14
+		} else {
+//line app/vmselect/loki/detected_response.qtpl:14
+			qw422016.N().S(`[`)
+//line app/vmselect/loki/detected_response.qtpl:16
+			for j, p := range f.parsers {
+//line app/vmselect/loki/detected_response.qtpl:17
+				qw422016.N().Q(p)
+//line app/vmselect/loki/detected_response.qtpl:18
+				if j+1 < len(f.parsers) {
+//line app/vmselect/loki/detected_response.qtpl:18
+					qw422016.N().S(`,`)
+//line app/vmselect/loki/detected_response.qtpl:18
+				}
+//line app/vmselect/loki/detected_response.qtpl:19
+			}
+//line app/vmselect/loki/detected_response.qtpl:19
+			qw422016.N().S(`]`)
+//line app/vmselect/loki/detected_response.qtpl:21
+		}
+//line app/vmselect/loki/detected_response.qtpl:21
+		qw422016.N().S(`}`)
+//line app/vmselect/loki/detected_response.qtpl:23
+		if i+1 < len(fields) {
+//line app/vmselect/loki/detected_response.qtpl:23
+			qw422016.N().S(`,`)
+//line app/vmselect/loki/detected_response.qtpl:23
+		}
+//line app/vmselect/loki/detected_response.qtpl:24
+	}
+//line app/vmselect/loki/detected_response.qtpl:24
+	qw422016.N().S(`],"limit":`)
+//line app/vmselect/loki/detected_response.qtpl:26
+	qw422016.N().D(limit)
+//line app/vmselect/loki/detected_response.qtpl:26
+	qw422016.N().S(`}`)
+//line app/vmselect/loki/detected_response.qtpl:28
+}
+
+//line app/vmselect/loki/detected_response.qtpl:28
+func WriteDetectedFieldsResponse(qq422016 qtio422016.Writer, fields []*detectedField, limit int) {
+//line app/vmselect/loki/detected_response.qtpl:28
+	qw422016 := qt422016.AcquireWriter(qq422016)
+//line app/vmselect/loki/detected_response.qtpl:28
+	StreamDetectedFieldsResponse(qw422016, fields, limit)
+//line app/vmselect/loki/detected_response.qtpl:28
+	qt422016.ReleaseWriter(qw422016)
+//line app/vmselect/loki/detected_response.qtpl:28
+}
+
+//line app/vmselect/loki/detected_response.qtpl:28
+func DetectedFieldsResponse(fields []*detectedField, limit int) string {
+//line app/vmselect/loki/detected_response.qtpl:28
+	qb422016 := qt422016.AcquireByteBuffer()
+//line app/vmselect/loki/detected_response.qtpl:28
+	WriteDetectedFieldsResponse(qb422016, fields, limit)
+//line app/vmselect/loki/detected_response.qtpl:28
+	qs422016 := string(qb422016.B)
+//line app/vmselect/loki/detected_response.qtpl:28
+	qt422016.ReleaseByteBuffer(qb422016)
+//line app/vmselect/loki/detected_response.qtpl:28
+	return qs422016
+//line app/vmselect/loki/detected_response.qtpl:28
+}
+
+// DetectedLabelsResponse generates response for /loki/api/v1/detected_labels .
+
+//line app/vmselect/loki/detected_response.qtpl:31
+func StreamDetectedLabelsResponse(qw422016 *qt422016.Writer, labels []detectedLabel) {
+//line app/vmselect/loki/detected_response.qtpl:31
+	qw422016.N().S(`{"detectedLabels":[`)
+//line app/vmselect/loki/detected_response.qtpl:34
+	for i, l := range labels {
+//line app/vmselect/loki/detected_response.qtpl:34
+		qw422016.N().S(`{"label":`)
+//line app/vmselect/loki/detected_response.qtpl:36
+		qw422016.N().Q(l.label)
+//line app/vmselect/loki/detected_response.qtpl:36
+		qw422016.N().S(`,"cardinality":`)
+//line app/vmselect/loki/detected_response.qtpl:37
+		qw422016.N().DUL(l.cardinality)
+//line app/vmselect/loki/detected_response.qtpl:37
+		qw422016.N().S(`}`)
+//line app/vmselect/loki/detected_response.qtpl:39
+		if i+1 < len(labels) {
+//line app/vmselect/loki/detected_response.qtpl:39
+			qw422016.N().S(`,`)
+//line app/vmselect/loki/detected_response.qtpl:39
+		}
+//line app/vmselect/loki/detected_response.qtpl:40
+	}
+//line app/vmselect/loki/detected_response.qtpl:40
+	qw422016.N().S(`]}`)
+//line app/vmselect/loki/detected_response.qtpl:43
+}
+
+//line app/vmselect/loki/detected_response.qtpl:43
+func WriteDetectedLabelsResponse(qq422016 qtio422016.Writer, labels []detectedLabel) {
+//line app/vmselect/loki/detected_response.qtpl:43
+	qw422016 := qt422016.AcquireWriter(qq422016)
+//line app/vmselect/loki/detected_response.qtpl:43
+	StreamDetectedLabelsResponse(qw422016, labels)
+//line app/vmselect/loki/detected_response.qtpl:43
+	qt422016.ReleaseWriter(qw422016)
+//line app/vmselect/loki/detected_response.qtpl:43
+}
+
+//line app/vmselect/loki/detected_response.qtpl:43
+func DetectedLabelsResponse(labels []detectedLabel) string {
+//line app/vmselect/loki/detected_response.qtpl:43
+	qb422016 := qt422016.AcquireByteBuffer()
+//line app/vmselect/loki/detected_response.qtpl:43
+	WriteDetectedLabelsResponse(qb422016, labels)
+//line app/vmselect/loki/detected_response.qtpl:43
+	qs422016 := string(qb422016.B)
+//line app/vmselect/loki/detected_response.qtpl:43
+	qt422016.ReleaseByteBuffer(qb422016)
+//line app/vmselect/loki/detected_response.qtpl:43
+	return qs422016
+//line app/vmselect/loki/detected_response.qtpl:43
+}
@@ -0,0 +1,120 @@
+package loki
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+)
+
+func TestGetDetectedFields(t *testing.T) {
+	f := func(lines []string, fieldLimit int, resultExpected []string) {
+		t.Helper()
+		var datas [][]byte
+		for _, line := range lines {
+			var metadata []storage.Label
+			if n := strings.Index(line, " | "); n >= 0 {
+				// Structured metadata in the form `line | name=value`.
+				kv := strings.SplitN(line[n+3:], "=", 2)
+				metadata = append(metadata, storage.Label{Name: []byte(kv[0]), Value: []byte(kv[1])})
+				line = line[:n]
+			}
+			datas = append(datas, storage.MarshalLineWithMetadata(nil, []byte(line), metadata))
+		}
+		var result []string
+		for _, field := range getDetectedFields(datas, fieldLimit) {
+			result = append(result, fmt.Sprintf("%s:%s:%d:%s", field.label, field.typ, len(field.values), strings.Join(field.parsers, ",")))
+		}
+		if !reflect.DeepEqual(result, resultExpected) {
+			t.Fatalf("unexpected fields\ngot\n%q\nwant\n%q", result, resultExpected)
+		}
+	}
+
+	// Plain text lines
+	f([]string{"foo bar", "GET /api 200"}, 0, nil)
+
+	// JSON lines
+	f([]string{
+		`{"level":"info","duration":"15ms","status":200,"req":{"size":"1.5KiB","ok":true}}`,
+		`{"level":"error","duration":"1s","status":500.5,"req":{"size":"10B","ok":false},"tags":["a"]}`,
+	}, 0, []string{
+		"duration:duration:2:json",
+		"level:string:2:json",
+		"req_ok:boolean:2:json",
+		"req_size:bytes:2:json",
+		"status:float:2:json",
+	})
+
+	// logfmt lines
+	f([]string{
+		`level=info msg="user logged in" user.id=42 standalone`,
+		`level=warn msg="slow \"query\"" user.id=abc`,
+	}, 0, []string{
+		"level:string:2:logfmt",
+		"msg:string:2:logfmt",
+		"user_id:string:2:logfmt",
+	})
+
+	// Mixed formats with structured metadata
+	f([]string{
+		`{"level":"info"} | trace_id=abc`,
+		`level=info | trace_id=def`,
+	}, 0, []string{
+		"level:string:1:json,logfmt",
+		"trace_id:string:2:",
+	})
+
+	// Field limit
+	f([]string{`a=1 b=2 c=3`}, 2, []string{
+		"a:int:1:logfmt",
+		"b:int:1:logfmt",
+	})
+}
+
+func TestLinesSampler(t *testing.T) {
+	ls := newLinesSampler(10)
+	for i := 0; i < 1000; i++ {
+		ls.add([]byte(fmt.Sprintf("line %d", i)))
+	}
+	if len(ls.datas) != 10 {
+		t.Fatalf("unexpected number of sampled lines; got %d; want 10", len(ls.datas))
+	}
+	m := make(map[string]bool)
+	for _, data := range ls.datas {
+		m[string(data)] = true
+	}
+	if len(m) != 10 {
+		t.Fatalf("sampled lines must be unique; got %q", ls.datas)
+	}
+}
+
+func TestGetDetectedLabels(t *testing.T) {
+	newStreamStats := func(labels ...string) storage.StreamStats {
+		// Labels must be passed in sorted order.
+		var mn storage.MetricName
+		for i := 0; i < len(labels); i += 2 {
+			mn.AddTag(labels[i], labels[i+1])
+		}
+		return storage.StreamStats{
+			MetricName: mn.Marshal(nil),
+		}
+	}
+	var sss []storage.StreamStats
+	for i := 0; i < 60; i++ {
+		sss = append(sss, newStreamStats("app", fmt.Sprintf("app%d", i%3), "env", "prod", "pod", fmt.Sprintf("pod%d", i), "request_id", fmt.Sprintf("%d", i)))
+	}
+	cardinalities, err := getLabelCardinalitiesFromStreamStats(sss)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	labels := getDetectedLabels(cardinalities)
+	labelsExpected := []detectedLabel{
+		{label: "app", cardinality: 3},
+		{label: "pod", cardinality: 60},
+	}
+	if !reflect.DeepEqual(labels, labelsExpected) {
+		t.Fatalf("unexpected labels\ngot\n%+v\nwant\n%+v", labels, labelsExpected)
+	}
+}
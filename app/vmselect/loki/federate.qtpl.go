@@ -7,69 +7,70 @@ package loki
 //line app/vmselect/loki/federate.qtpl:1
 import (
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
 )
 
 // Federate writes rs in /federate format.// See https://prometheus.io/docs/prometheus/latest/federation/
 
-//line app/vmselect/loki/federate.qtpl:9
+//line app/vmselect/loki/federate.qtpl:10
 import (
 	qtio422016 "io"
 
 	qt422016 "github.com/valyala/quicktemplate"
 )
 
-//line app/vmselect/loki/federate.qtpl:9
+//line app/vmselect/loki/federate.qtpl:10
 var (
 	_ = qtio422016.Copy
 	_ = qt422016.AcquireByteBuffer
 )
 
-//line app/vmselect/loki/federate.qtpl:9
-func StreamFederate(qw422016 *qt422016.Writer, rs *netstorage.Result) {
 //line app/vmselect/loki/federate.qtpl:10
+func StreamFederate(qw422016 *qt422016.Writer, rs *netstorage.Result) {
+//line app/vmselect/loki/federate.qtpl:11
 	if len(rs.Timestamps) == 0 || len(rs.Datas) == 0 {
-//line app/vmselect/loki/federate.qtpl:10
-		return
-//line app/vmselect/loki/federate.qtpl:10
-	}
 //line app/vmselect/loki/federate.qtpl:11
-	streamprometheusMetricName(qw422016, &rs.MetricName)
+		return
 //line app/vmselect/loki/federate.qtpl:11
-	qw422016.N().S(` `)
+	}
 //line app/vmselect/loki/federate.qtpl:12
-	qw422016.N().Z(rs.Datas[len(rs.Datas)-1])
+	streamprometheusMetricName(qw422016, &rs.MetricName)
 //line app/vmselect/loki/federate.qtpl:12
 	qw422016.N().S(` `)
 //line app/vmselect/loki/federate.qtpl:13
-	qw422016.N().DL(rs.Timestamps[len(rs.Timestamps)-1])
+	qw422016.N().Z(storage.GetLine(rs.Datas[len(rs.Datas)-1]))
 //line app/vmselect/loki/federate.qtpl:13
+	qw422016.N().S(` `)
+//line app/vmselect/loki/federate.qtpl:14
+	qw422016.N().DL(rs.Timestamps[len(rs.Timestamps)-1])
+//line app/vmselect/loki/federate.qtpl:14
 	qw422016.N().S(`
 `)
-//line app/vmselect/loki/federate.qtpl:14
+//line app/vmselect/loki/federate.qtpl:15
 }
 
-//line app/vmselect/loki/federate.qtpl:14
+//line app/vmselect/loki/federate.qtpl:15
 func WriteFederate(qq422016 qtio422016.Writer, rs *netstorage.Result) {
-//line app/vmselect/loki/federate.qtpl:14
+//line app/vmselect/loki/federate.qtpl:15
 	qw422016 := qt422016.AcquireWriter(qq422016)
-//line app/vmselect/loki/federate.qtpl:14
+//line app/vmselect/loki/federate.qtpl:15
 	StreamFederate(qw422016, rs)
-//line app/vmselect/loki/federate.qtpl:14
+//line app/vmselect/loki/federate.qtpl:15
 	qt422016.ReleaseWriter(qw422016)
-//line app/vmselect/loki/federate.qtpl:14
+//line app/vmselect/loki/federate.qtpl:15
 }
 
-//line app/vmselect/loki/federate.qtpl:14
+//line app/vmselect/loki/federate.qtpl:15
 func Federate(rs *netstorage.Result) string {
-//line app/vmselect/loki/federate.qtpl:14
+//line app/vmselect/loki/federate.qtpl:15
 	qb422016 := qt422016.AcquireByteBuffer()
-//line app/vmselect/loki/federate.qtpl:14
+//line app/vmselect/loki/federate.qtpl:15
 	WriteFederate(qb422016, rs)
-//line app/vmselect/loki/federate.qtpl:14
+//line app/vmselect/loki/federate.qtpl:15
 	qs422016 := string(qb422016.B)
-//line app/vmselect/loki/federate.qtpl:14
+//line app/vmselect/loki/federate.qtpl:15
 	qt422016.ReleaseByteBuffer(qb422016)
-//line app/vmselect/loki/federate.qtpl:14
+//line app/vmselect/loki/federate.qtpl:15
 	return qs422016
-//line app/vmselect/loki/federate.qtpl:14
+//line app/vmselect/loki/federate.qtpl:15
 }
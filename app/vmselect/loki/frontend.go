@@ -0,0 +1,298 @@
+package loki
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/querier"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/searchutils"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logql"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/querytracer"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/tenant"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/valyala/fastjson"
+)
+
+var (
+	downstreamNodes = flagutil.NewArray("frontend.downstreamNode", "Addresses of downstream vmselect nodes for query frontend mode; usage: -frontend.downstreamNode=vmselect-host1:8481 -frontend.downstreamNode=vmselect-host2:8481. "+
+		"Range metric queries are split into sub-queries by -frontend.splitQueriesByInterval and by -frontend.shards stream shards, which are executed on these nodes. "+
+		"Query frontend mode is disabled if the flag isn't set")
+	frontendSplitQueriesByInterval = flag.Duration("frontend.splitQueriesByInterval", 24*time.Hour, "Range metric queries are split into sub-queries on time ranges aligned to this interval in query frontend mode. "+
+		"See -frontend.downstreamNode. Zero disables splitting by time")
+	frontendShards = flag.Int("frontend.shards", 0, "The number of stream shards for sum, count, max, min and topk aggregates in query frontend mode. "+
+		"See -frontend.downstreamNode. By default it equals to the number of -frontend.downstreamNode. Sharding is disabled if the flag is set to 1")
+)
+
+// isFrontendQuery returns true if the range query may be executed in query frontend mode.
+func isFrontendQuery(r *http.Request, tenants []*tenant.Tenant, tenantLabel string, tail bool, pagination *querier.Pagination) bool {
+	if len(*downstreamNodes) == 0 || tail || len(tenants) > 1 || tenantLabel != "" {
+		return false
+	}
+	if pagination != nil && pagination.Cursor != nil {
+		return false
+	}
+	// Sub-queries from the query frontend contain `shard` arg. They mustn't be split again.
+	return r.FormValue("shard") == ""
+}
+
+// execFrontend executes range query q for ec on -frontend.downstreamNode nodes.
+func execFrontend(ec *querier.EvalConfig, q string, r *http.Request) ([]netstorage.Result, logql.Expr, error) {
+	shardsCount := *frontendShards
+	if shardsCount <= 0 {
+		shardsCount = len(*downstreamNodes)
+	}
+	f := func(sq *querier.Subquery) ([]netstorage.Result, error) {
+		return execSubquery(r, ec.AuthToken, &ec.Deadline, sq)
+	}
+	return querier.ExecFrontend(ec, q, frontendSplitQueriesByInterval.Milliseconds(), shardsCount, f)
+}
+
+// execSubquery executes sq on the next -frontend.downstreamNode.
+//
+// The sub-query is retried on the remaining nodes if the node is unavailable.
+func execSubquery(r *http.Request, at *auth.Token, deadline *searchutils.Deadline, sq *querier.Subquery) ([]netstorage.Result, error) {
+	// The context is canceled on timeout, on client disconnect and on query cancelation via /active_queries/cancel,
+	// so downstream sub-queries are stopped in all these cases.
+	ctx, cancel := deadline.Context(r.Context())
+	defer cancel()
+
+	args := url.Values{}
+	args.Set("query", sq.Query)
+	args.Set("start", formatTimestamp(sq.Start))
+	args.Set("end", formatTimestamp(sq.End))
+	args.Set("step", fmt.Sprintf("%dms", sq.Step))
+	args.Set("shard", fmt.Sprintf("%d_of_%d", sq.ShardIdx, sq.ShardsCount))
+	for _, arg := range []string{"nocache", "max_lookback", "deny_partial_response"} {
+		if v := r.FormValue(arg); v != "" {
+			args.Set(arg, v)
+		}
+	}
+	if sq.Tracer.Enabled() {
+		args.Set("trace", "1")
+	}
+	body := args.Encode()
+
+	nodes := *downstreamNodes
+	n := atomic.AddUint32(&downstreamNodeIdx, 1)
+	var lastErr error
+	for i := range nodes {
+		node := nodes[(int(n)+i)%len(nodes)]
+		rs, err := execSubqueryOnNode(ctx, r, node, at, body, sq.Tracer)
+		if err == nil {
+			return rs, nil
+		}
+		if !isRetriableSubqueryError(err) || ctx.Err() != nil {
+			return nil, err
+		}
+		subqueryRetries.Inc()
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+var downstreamNodeIdx uint32
+
+var (
+	subqueryRequests = metrics.NewCounter(`vm_frontend_subquery_requests_total`)
+	subqueryErrors   = metrics.NewCounter(`vm_frontend_subquery_errors_total`)
+	subqueryRetries  = metrics.NewCounter(`vm_frontend_subquery_retries_total`)
+)
+
+var frontendClient = &http.Client{}
+
+func execSubqueryOnNode(ctx context.Context, r *http.Request, node string, at *auth.Token, body string, qt *querytracer.Tracer) ([]netstorage.Result, error) {
+	subqueryRequests.Inc()
+	callURL := fmt.Sprintf("http://%s/select/%d:%d/loki/api/v1/query_range", node, at.AccountID, at.ProjectID)
+	req, err := http.NewRequest(http.MethodPost, callURL, strings.NewReader(body))
+	if err != nil {
+		subqueryErrors.Inc()
+		return nil, fmt.Errorf("cannot create request to %q: %w", callURL, err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+		// Downstream nodes authenticate sub-queries with the credentials of the original request.
+		req.Header.Set("Authorization", authHeader)
+	}
+	resp, err := frontendClient.Do(req)
+	if err != nil {
+		subqueryErrors.Inc()
+		return nil, &subqueryError{
+			err:         fmt.Errorf("cannot execute sub-query at %q: %w", callURL, err),
+			isRetriable: true,
+		}
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		subqueryErrors.Inc()
+		return nil, &subqueryError{
+			err:         fmt.Errorf("cannot read sub-query response from %q: %w", callURL, err),
+			isRetriable: true,
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		subqueryErrors.Inc()
+		return nil, &subqueryError{
+			err: &httpserver.ErrorWithStatusCode{
+				Err:        fmt.Errorf("unexpected status code at %q; got %d; want %d; response: %s", callURL, resp.StatusCode, http.StatusOK, getErrorMessage(data)),
+				StatusCode: resp.StatusCode,
+			},
+			isRetriable: resp.StatusCode >= 500,
+		}
+	}
+	rs, err := parseMatrixResponse(data)
+	if err != nil {
+		subqueryErrors.Inc()
+		return nil, fmt.Errorf("cannot parse sub-query response from %q: %w", callURL, err)
+	}
+	if qt.Enabled() {
+		if err := qt.AddJSON(getQueryTrace(data)); err != nil {
+			return nil, fmt.Errorf("cannot parse query trace from %q: %w", callURL, err)
+		}
+	}
+	return rs, nil
+}
+
+// subqueryError is an error returned from the downstream node.
+type subqueryError struct {
+	err error
+
+	// isRetriable is set if the sub-query may be retried on another node.
+	isRetriable bool
+}
+
+func (e *subqueryError) Error() string {
+	return e.err.Error()
+}
+
+func (e *subqueryError) Unwrap() error {
+	return e.err
+}
+
+func isRetriableSubqueryError(err error) bool {
+	se, ok := err.(*subqueryError)
+	return ok && se.isRetriable
+}
+
+func formatTimestamp(timestamp int64) string {
+	return fmt.Sprintf("%d.%03d", timestamp/1e3, timestamp%1e3)
+}
+
+// getErrorMessage returns error message from ErrorResponse data.
+func getErrorMessage(data []byte) string {
+	var p fastjson.Parser
+	v, err := p.ParseBytes(data)
+	if err != nil {
+		return string(data)
+	}
+	msg := v.GetStringBytes("error")
+	if msg == nil {
+		return string(data)
+	}
+	return string(msg)
+}
+
+// getQueryTrace returns query trace from the response data generated with `trace=1` arg.
+//
+// nil is returned if the response doesn't contain query trace.
+func getQueryTrace(data []byte) []byte {
+	var p fastjson.Parser
+	v, err := p.ParseBytes(data)
+	if err != nil {
+		return nil
+	}
+	trace := v.Get("trace")
+	if trace == nil {
+		return nil
+	}
+	return trace.MarshalTo(nil)
+}
+
+// parseMatrixResponse parses data generated by VectorQueryRangeResponse.
+func parseMatrixResponse(data []byte) ([]netstorage.Result, error) {
+	var p fastjson.Parser
+	v, err := p.ParseBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	if status := string(v.GetStringBytes("status")); status != "success" {
+		return nil, fmt.Errorf("unexpected status; got %q; want %q", status, "success")
+	}
+	if resultType := string(v.GetStringBytes("data", "resultType")); resultType != "matrix" {
+		return nil, fmt.Errorf("unexpected resultType; got %q; want %q", resultType, "matrix")
+	}
+	items := v.GetArray("data", "result")
+	rs := make([]netstorage.Result, len(items))
+	for i, item := range items {
+		r := &rs[i]
+		metric, err := item.Get("metric").Object()
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse `metric`: %w", err)
+		}
+		metric.Visit(func(key []byte, v *fastjson.Value) {
+			value := v.GetStringBytes()
+			if string(key) == "__name__" {
+				r.MetricName.MetricGroup = append(r.MetricName.MetricGroup[:0], value...)
+				return
+			}
+			r.MetricName.AddTagBytes(key, value)
+		})
+		for _, point := range item.GetArray("values") {
+			pair := point.GetArray()
+			if len(pair) != 2 {
+				return nil, fmt.Errorf("unexpected number of items in `values` entry; got %d; want 2", len(pair))
+			}
+			ts, err := pair[0].Float64()
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse timestamp: %w", err)
+			}
+			value, err := strconv.ParseFloat(string(pair[1].GetStringBytes()), 64)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse value: %w", err)
+			}
+			r.Timestamps = append(r.Timestamps, int64(math.Round(ts*1e3)))
+			r.Values = append(r.Values, value)
+		}
+	}
+	return rs, nil
+}
+
+// getShard returns shard index and shards count from `shard` arg in the form `<shardIdx>_of_<shardsCount>`.
+//
+// Zero shards count is returned if the arg is missing.
+func getShard(r *http.Request) (int, int, error) {
+	s := r.FormValue("shard")
+	if s == "" {
+		return 0, 0, nil
+	}
+	n := strings.Index(s, "_of_")
+	if n < 0 {
+		return 0, 0, fmt.Errorf("cannot parse `shard` arg %q; it must be in the form `<shardIdx>_of_<shardsCount>`", s)
+	}
+	shardIdx, err := strconv.Atoi(s[:n])
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot parse shard index from `shard` arg %q: %w", s, err)
+	}
+	shardsCount, err := strconv.Atoi(s[n+len("_of_"):])
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot parse shards count from `shard` arg %q: %w", s, err)
+	}
+	if shardsCount <= 0 || shardIdx < 0 || shardIdx >= shardsCount {
+		return 0, 0, fmt.Errorf("invalid `shard` arg %q; shard index must be in the range [0 ... %d)", s, shardsCount)
+	}
+	return shardIdx, shardsCount, nil
+}
@@ -0,0 +1,117 @@
+package loki
+
+import (
+	"math"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
+)
+
+func TestGetShardSuccess(t *testing.T) {
+	f := func(s string, shardIdxExpected, shardsCountExpected int) {
+		t.Helper()
+		r := &http.Request{
+			Form: url.Values{},
+		}
+		if s != "" {
+			r.Form.Set("shard", s)
+		}
+		shardIdx, shardsCount, err := getShard(r)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if shardIdx != shardIdxExpected || shardsCount != shardsCountExpected {
+			t.Fatalf("unexpected shard for %q; got %d_of_%d; want %d_of_%d", s, shardIdx, shardsCount, shardIdxExpected, shardsCountExpected)
+		}
+	}
+
+	f("", 0, 0)
+	f("0_of_1", 0, 1)
+	f("3_of_16", 3, 16)
+}
+
+func TestGetShardFailure(t *testing.T) {
+	f := func(s string) {
+		t.Helper()
+		r := &http.Request{
+			Form: url.Values{
+				"shard": {s},
+			},
+		}
+		if _, _, err := getShard(r); err == nil {
+			t.Fatalf("expecting non-nil error for %q", s)
+		}
+	}
+
+	f("foo")
+	f("1of2")
+	f("a_of_2")
+	f("1_of_b")
+	f("2_of_2")
+	f("-1_of_2")
+	f("0_of_0")
+}
+
+func TestParseMatrixResponseSuccess(t *testing.T) {
+	data := `{"status":"success","data":{"resultType":"matrix","result":[
+{"metric":{"__name__":"foo","app":"bar"},"values":[[1.5,"1"],[1.501,"NaN"]]},
+{"metric":{},"values":[[2,"-3.25"]]}
+],"stats":{}}}`
+	rs, err := parseMatrixResponse([]byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(rs) != 2 {
+		t.Fatalf("unexpected number of results; got %d; want 2", len(rs))
+	}
+	if string(rs[0].MetricName.MetricGroup) != "foo" || len(rs[0].MetricName.Tags) != 1 || string(rs[0].MetricName.Tags[0].Key) != "app" || string(rs[0].MetricName.Tags[0].Value) != "bar" {
+		t.Fatalf("unexpected metric name; got %s; want foo{app=\"bar\"}", rs[0].MetricName.String())
+	}
+	if !reflect.DeepEqual(rs[0].Timestamps, []int64{1500, 1501}) {
+		t.Fatalf("unexpected timestamps; got %v; want %v", rs[0].Timestamps, []int64{1500, 1501})
+	}
+	if len(rs[0].Values) != 2 || rs[0].Values[0] != 1 || !math.IsNaN(rs[0].Values[1]) {
+		t.Fatalf("unexpected values; got %v; want [1 NaN]", rs[0].Values)
+	}
+	expected := netstorage.Result{
+		Timestamps: []int64{2000},
+		Values:     []float64{-3.25},
+	}
+	if !reflect.DeepEqual(rs[1], expected) {
+		t.Fatalf("unexpected result; got %+v; want %+v", rs[1], expected)
+	}
+}
+
+func TestParseMatrixResponseFailure(t *testing.T) {
+	f := func(data string) {
+		t.Helper()
+		if _, err := parseMatrixResponse([]byte(data)); err == nil {
+			t.Fatalf("expecting non-nil error for %s", data)
+		}
+	}
+
+	f(`foobar`)
+	f(`{"status":"error","error":"foo"}`)
+	f(`{"status":"success","data":{"resultType":"streams","result":[]}}`)
+	f(`{"status":"success","data":{"resultType":"matrix","result":[{"metric":1,"values":[]}]}}`)
+	f(`{"status":"success","data":{"resultType":"matrix","result":[{"metric":{},"values":[[1]]}]}}`)
+	f(`{"status":"success","data":{"resultType":"matrix","result":[{"metric":{},"values":[["a","1"]]}]}}`)
+	f(`{"status":"success","data":{"resultType":"matrix","result":[{"metric":{},"values":[[1,"a"]]}]}}`)
+}
+
+func TestGetQueryTrace(t *testing.T) {
+	f := func(data, traceExpected string) {
+		t.Helper()
+		trace := getQueryTrace([]byte(data))
+		if string(trace) != traceExpected {
+			t.Fatalf("unexpected trace for %s; got %s; want %s", data, trace, traceExpected)
+		}
+	}
+
+	f(`foobar`, ``)
+	f(`{"status":"success","data":{"resultType":"matrix","result":[]}}`, ``)
+	f(`{"status":"success","data":{"resultType":"matrix","result":[]},"trace":{"duration_msec":1.5,"message":"foo"}}`, `{"duration_msec":1.5,"message":"foo"}`)
+}
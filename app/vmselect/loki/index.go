@@ -0,0 +1,228 @@
+package loki
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/querier"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/searchutils"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vmselect/bufferedwriter"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// IndexStatsHandler processes /loki/api/v1/index/stats request.
+//
+// See https://grafana.com/docs/loki/latest/reference/loki-http-api/#query-log-statistics
+func IndexStatsHandler(startTime time.Time, at *auth.Token, w http.ResponseWriter, r *http.Request) error {
+	sss, _, _, err := getStreamStats(startTime, at, r)
+	if err != nil {
+		return err
+	}
+	var blocks, rows, size uint64
+	for i := range sss {
+		ss := &sss[i]
+		blocks += ss.Blocks
+		rows += ss.Rows
+		size += ss.Size
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	bw := bufferedwriter.Get(w)
+	defer bufferedwriter.Put(bw)
+	WriteIndexStatsResponse(bw, uint64(len(sss)), blocks, size, rows)
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	indexStatsDuration.UpdateDuration(startTime)
+	return nil
+}
+
+var indexStatsDuration = metrics.NewSummary(`vm_request_duration_seconds{path="/loki/api/v1/index/stats"}`)
+
+// Default limit for /loki/api/v1/index/volume if not set.
+const defaultVolumeLimit = 100
+
+// IndexVolumeHandler processes /loki/api/v1/index/volume request.
+//
+// See https://grafana.com/docs/loki/latest/reference/loki-http-api/#query-log-volume
+func IndexVolumeHandler(startTime time.Time, at *auth.Token, w http.ResponseWriter, r *http.Request) error {
+	sss, tfs, end, err := getStreamStats(startTime, at, r)
+	if err != nil {
+		return err
+	}
+	limit, err := searchutils.GetInt64(r, "limit", defaultVolumeLimit)
+	if err != nil {
+		return err
+	}
+	var aggregateByLabels bool
+	switch aggregateBy := searchutils.GetString(r, "aggregateBy", "series"); aggregateBy {
+	case "series":
+	case "labels":
+		aggregateByLabels = true
+	default:
+		return fmt.Errorf("unsupported `aggregateBy` arg %q; supported values: series, labels", aggregateBy)
+	}
+	var targetLabels []string
+	if s := r.FormValue("targetLabels"); len(s) > 0 {
+		targetLabels = strings.Split(s, ",")
+	} else if !aggregateByLabels {
+		// Aggregate by labels from the stream selector like Loki does.
+		for _, tf := range tfs {
+			if len(tf.Key) > 0 {
+				targetLabels = append(targetLabels, string(tf.Key))
+			}
+		}
+	}
+	volumes, err := getVolumes(sss, targetLabels, aggregateByLabels, int(limit))
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	bw := bufferedwriter.Get(w)
+	defer bufferedwriter.Put(bw)
+	WriteIndexVolumeResponse(bw, volumes, end)
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	indexVolumeDuration.UpdateDuration(startTime)
+	return nil
+}
+
+var indexVolumeDuration = metrics.NewSummary(`vm_request_duration_seconds{path="/loki/api/v1/index/volume"}`)
+
+// getStreamStats returns stats for streams matching `query` arg on the time range from `start` and `end` args.
+//
+// It also returns tag filters from the query and the end of the time range.
+func getStreamStats(startTime time.Time, at *auth.Token, r *http.Request) ([]storage.StreamStats, []storage.TagFilter, int64, error) {
+	ct := startTime.UnixNano() / 1e6
+	query := r.FormValue("query")
+	etfs := searchutils.GetEnforcedTagFilters(r)
+	if len(query) == 0 && len(etfs) == 0 {
+		return nil, nil, 0, fmt.Errorf("missing `query` arg")
+	}
+	end, err := searchutils.GetTime(r, "end", ct)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	start, err := searchutils.GetTime(r, "start", end-defaultStep)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if start >= end {
+		end = start + defaultStep
+	}
+	deadline := searchutils.GetDeadlineForQuery(r, startTime)
+
+	var tfs []storage.TagFilter
+	if len(query) > 0 {
+		tfs, err = querier.ParseMetricSelector(query)
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("cannot parse stream selector from `query` arg: %w", err)
+		}
+	}
+	sq := &storage.SearchQuery{
+		AccountID:    at.AccountID,
+		ProjectID:    at.ProjectID,
+		MinTimestamp: start,
+		MaxTimestamp: end,
+		TagFilterss:  searchutils.JoinTagFilterss([][]storage.TagFilter{tfs}, etfs),
+		FetchData:    storage.NotFetch,
+	}
+	sss, isPartial, err := netstorage.GetStreamStats(at, sq, deadline)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("cannot obtain stream stats for %q: %w", sq, err)
+	}
+	if isPartial && searchutils.GetDenyPartialResponse(r) {
+		return nil, nil, 0, fmt.Errorf("cannot return full response, since some of vmstorage nodes are unavailable")
+	}
+	return sss, tfs, end, nil
+}
+
+// indexVolume is the size of compressed blocks for streams with the given labels.
+type indexVolume struct {
+	mn    storage.MetricName
+	bytes uint64
+}
+
+// getVolumes aggregates sizes from sss and returns up to limit volumes with the biggest sizes.
+//
+// If aggregateByLabels is false, then the sizes are aggregated by targetLabels values.
+// Otherwise the sizes are aggregated by label names. All the label names are used
+// if targetLabels is empty.
+func getVolumes(sss []storage.StreamStats, targetLabels []string, aggregateByLabels bool, limit int) ([]*indexVolume, error) {
+	isTarget := func(key []byte) bool {
+		if len(targetLabels) == 0 {
+			return true
+		}
+		for _, label := range targetLabels {
+			if string(key) == label {
+				return true
+			}
+		}
+		return false
+	}
+	m := make(map[string]*indexVolume)
+	var mn, mnKey storage.MetricName
+	var key []byte
+	for i := range sss {
+		ss := &sss[i]
+		if err := mn.Unmarshal(ss.MetricName); err != nil {
+			return nil, fmt.Errorf("cannot unmarshal MetricName for stream stats: %w", err)
+		}
+		if aggregateByLabels {
+			for j := range mn.Tags {
+				tag := &mn.Tags[j]
+				if !isTarget(tag.Key) {
+					continue
+				}
+				v := m[string(tag.Key)]
+				if v == nil {
+					v = &indexVolume{}
+					v.mn.AddTagBytes(tag.Key, nil)
+					m[string(tag.Key)] = v
+				}
+				v.bytes += ss.Size
+			}
+			continue
+		}
+		// Tags in the unmarshaled mn are sorted, so the key is canonical.
+		mnKey.Reset()
+		for j := range mn.Tags {
+			tag := &mn.Tags[j]
+			if len(targetLabels) > 0 && isTarget(tag.Key) {
+				mnKey.AddTagBytes(tag.Key, tag.Value)
+			}
+		}
+		key = mnKey.Marshal(key[:0])
+		v := m[string(key)]
+		if v == nil {
+			v = &indexVolume{}
+			v.mn.CopyFrom(&mnKey)
+			m[string(key)] = v
+		}
+		v.bytes += ss.Size
+	}
+
+	volumes := make([]*indexVolume, 0, len(m))
+	for _, v := range m {
+		volumes = append(volumes, v)
+	}
+	sort.Slice(volumes, func(i, j int) bool {
+		a, b := volumes[i], volumes[j]
+		if a.bytes != b.bytes {
+			return a.bytes > b.bytes
+		}
+		return a.mn.String() < b.mn.String()
+	})
+	if limit > 0 && len(volumes) > limit {
+		volumes = volumes[:limit]
+	}
+	return volumes, nil
+}
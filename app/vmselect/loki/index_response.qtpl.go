@@ -0,0 +1,130 @@
+// Code generated by qtc from "index_response.qtpl". DO NOT EDIT.
+// See https://github.com/valyala/quicktemplate for details.
+
+// IndexStatsResponse generates response for /loki/api/v1/index/stats .
+
+//line app/vmselect/loki/index_response.qtpl:3
+package loki
+
+//line app/vmselect/loki/index_response.qtpl:3
+import (
+	qtio422016 "io"
+
+	qt422016 "github.com/valyala/quicktemplate"
+)
+
+//line app/vmselect/loki/index_response.qtpl:3
+var (
+	_ = qtio422016.Copy
+	_ = qt422016.AcquireByteBuffer
+)
+
+//line app/vmselect/loki/index_response.qtpl:3
+func StreamIndexStatsResponse(qw422016 *qt422016.Writer, streams, chunks, bytes, entries uint64) {
+//line app/vmselect/loki/index_response.qtpl:3
+	qw422016.N().S(`{"streams":`)
+//line app/vmselect/loki/index_response.qtpl:5
+	qw422016.N().DUL(streams)
+//line app/vmselect/loki/index_response.qtpl:5
+	qw422016.N().S(`,"chunks":`)
+//line app/vmselect/loki/index_response.qtpl:6
+	qw422016.N().DUL(chunks)
+//line app/vmselect/loki/index_response.qtpl:6
+	qw422016.N().S(`,"bytes":`)
+//line app/vmselect/loki/index_response.qtpl:7
+	qw422016.N().DUL(bytes)
+//line app/vmselect/loki/index_response.qtpl:7
+	qw422016.N().S(`,"entries":`)
+//line app/vmselect/loki/index_response.qtpl:8
+	qw422016.N().DUL(entries)
+//line app/vmselect/loki/index_response.qtpl:8
+	qw422016.N().S(`}`)
+//line app/vmselect/loki/index_response.qtpl:10
+}
+
+//line app/vmselect/loki/index_response.qtpl:10
+func WriteIndexStatsResponse(qq422016 qtio422016.Writer, streams, chunks, bytes, entries uint64) {
+//line app/vmselect/loki/index_response.qtpl:10
+	qw422016 := qt422016.AcquireWriter(qq422016)
+//line app/vmselect/loki/index_response.qtpl:10
+	StreamIndexStatsResponse(qw422016, streams, chunks, bytes, entries)
+//line app/vmselect/loki/index_response.qtpl:10
+	qt422016.ReleaseWriter(qw422016)
+//line app/vmselect/loki/index_response.qtpl:10
+}
+
+//line app/vmselect/loki/index_response.qtpl:10
+func IndexStatsResponse(streams, chunks, bytes, entries uint64) string {
+//line app/vmselect/loki/index_response.qtpl:10
+	qb422016 := qt422016.AcquireByteBuffer()
+//line app/vmselect/loki/index_response.qtpl:10
+	WriteIndexStatsResponse(qb422016, streams, chunks, bytes, entries)
+//line app/vmselect/loki/index_response.qtpl:10
+	qs422016 := string(qb422016.B)
+//line app/vmselect/loki/index_response.qtpl:10
+	qt422016.ReleaseByteBuffer(qb422016)
+//line app/vmselect/loki/index_response.qtpl:10
+	return qs422016
+//line app/vmselect/loki/index_response.qtpl:10
+}
+
+// IndexVolumeResponse generates response for /loki/api/v1/index/volume .
+
+//line app/vmselect/loki/index_response.qtpl:13
+func StreamIndexVolumeResponse(qw422016 *qt422016.Writer, volumes []*indexVolume, timestamp int64) {
+//line app/vmselect/loki/index_response.qtpl:13
+	qw422016.N().S(`{"status":"success","data":{"resultType":"vector","result":[`)
+//line app/vmselect/loki/index_response.qtpl:19
+	for i, v := range volumes {
+//line app/vmselect/loki/index_response.qtpl:19
+		qw422016.N().S(`{"metric":`)
+//line app/vmselect/loki/index_response.qtpl:21
+		streammetricNameObject(qw422016, &v.mn)
+//line app/vmselect/loki/index_response.qtpl:21
+		qw422016.N().S(`,"value": [`)
+//line app/vmselect/loki/index_response.qtpl:22
+		qw422016.N().F(float64(timestamp) / 1e3)
+//line app/vmselect/loki/index_response.qtpl:22
+		qw422016.N().S(`,"`)
+//line app/vmselect/loki/index_response.qtpl:22
+		qw422016.N().DUL(v.bytes)
+//line app/vmselect/loki/index_response.qtpl:22
+		qw422016.N().S(`"]}`)
+//line app/vmselect/loki/index_response.qtpl:24
+		if i+1 < len(volumes) {
+//line app/vmselect/loki/index_response.qtpl:24
+			qw422016.N().S(`,`)
+//line app/vmselect/loki/index_response.qtpl:24
+		}
+//line app/vmselect/loki/index_response.qtpl:25
+	}
+//line app/vmselect/loki/index_response.qtpl:25
+	qw422016.N().S(`]}}`)
+//line app/vmselect/loki/index_response.qtpl:29
+}
+
+//line app/vmselect/loki/index_response.qtpl:29
+func WriteIndexVolumeResponse(qq422016 qtio422016.Writer, volumes []*indexVolume, timestamp int64) {
+//line app/vmselect/loki/index_response.qtpl:29
+	qw422016 := qt422016.AcquireWriter(qq422016)
+//line app/vmselect/loki/index_response.qtpl:29
+	StreamIndexVolumeResponse(qw422016, volumes, timestamp)
+//line app/vmselect/loki/index_response.qtpl:29
+	qt422016.ReleaseWriter(qw422016)
+//line app/vmselect/loki/index_response.qtpl:29
+}
+
+//line app/vmselect/loki/index_response.qtpl:29
+func IndexVolumeResponse(volumes []*indexVolume, timestamp int64) string {
+//line app/vmselect/loki/index_response.qtpl:29
+	qb422016 := qt422016.AcquireByteBuffer()
+//line app/vmselect/loki/index_response.qtpl:29
+	WriteIndexVolumeResponse(qb422016, volumes, timestamp)
+//line app/vmselect/loki/index_response.qtpl:29
+	qs422016 := string(qb422016.B)
+//line app/vmselect/loki/index_response.qtpl:29
+	qt422016.ReleaseByteBuffer(qb422016)
+//line app/vmselect/loki/index_response.qtpl:29
+	return qs422016
+//line app/vmselect/loki/index_response.qtpl:29
+}
@@ -0,0 +1,66 @@
+package loki
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+)
+
+func TestGetVolumes(t *testing.T) {
+	newStreamStats := func(size uint64, labels ...string) storage.StreamStats {
+		// Labels must be passed in sorted order.
+		var mn storage.MetricName
+		for i := 0; i < len(labels); i += 2 {
+			mn.AddTag(labels[i], labels[i+1])
+		}
+		return storage.StreamStats{
+			MetricName: mn.Marshal(nil),
+			Blocks:     1,
+			Rows:       1,
+			Size:       size,
+		}
+	}
+	sss := []storage.StreamStats{
+		newStreamStats(10, "app", "foo", "pod", "1", "team", "a"),
+		newStreamStats(20, "app", "foo", "pod", "2", "team", "a"),
+		newStreamStats(5, "app", "bar", "team", "b"),
+		newStreamStats(1, "app", "baz"),
+	}
+	f := func(targetLabels []string, aggregateByLabels bool, limit int, resultExpected []string) {
+		t.Helper()
+		volumes, err := getVolumes(sss, targetLabels, aggregateByLabels, limit)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var result []string
+		for _, v := range volumes {
+			result = append(result, fmt.Sprintf("%s=%d", metricNameObject(&v.mn), v.bytes))
+		}
+		if !reflect.DeepEqual(result, resultExpected) {
+			t.Fatalf("unexpected volumes\ngot\n%q\nwant\n%q", result, resultExpected)
+		}
+	}
+
+	// Aggregate by series
+	f([]string{"team"}, false, 0, []string{
+		`{"team":"a"}=30`,
+		`{"team":"b"}=5`,
+		`{}=1`,
+	})
+	f([]string{"app", "team"}, false, 2, []string{
+		`{"app":"foo","team":"a"}=30`,
+		`{"app":"bar","team":"b"}=5`,
+	})
+
+	// Aggregate by labels
+	f(nil, true, 0, []string{
+		`{"app":""}=36`,
+		`{"team":""}=35`,
+		`{"pod":""}=30`,
+	})
+	f([]string{"pod"}, true, 0, []string{
+		`{"pod":""}=30`,
+	})
+}
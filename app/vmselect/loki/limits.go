@@ -0,0 +1,159 @@
+package loki
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/searchutils"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/overrides"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/tenant"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// getLimits returns the strictest limits from -overrides.config across the queried tenants.
+//
+// Limits for at are returned if tenants are empty.
+func getLimits(at *auth.Token, tenants []*tenant.Tenant) *overrides.Limits {
+	if len(tenants) == 0 {
+		return overrides.GetLimits(at)
+	}
+	ls := make([]*overrides.Limits, 0, len(tenants))
+	for _, t := range tenants {
+		ls = append(ls, overrides.GetLimits(t.AuthToken))
+	}
+	return getStrictestLimits(ls)
+}
+
+// getStrictestLimits returns the minimum non-zero value for every limit in ls.
+func getStrictestLimits(ls []*overrides.Limits) *overrides.Limits {
+	var limits overrides.Limits
+	for _, l := range ls {
+		limits.MaxQuerySeries = int(minLimit(int64(limits.MaxQuerySeries), int64(l.MaxQuerySeries)))
+		limits.MaxQueryBytesRead = minLimit(limits.MaxQueryBytesRead, l.MaxQueryBytesRead)
+		limits.MaxEntriesLimitPerQuery = int(minLimit(int64(limits.MaxEntriesLimitPerQuery), int64(l.MaxEntriesLimitPerQuery)))
+		limits.MaxQueryLength = time.Duration(minLimit(int64(limits.MaxQueryLength), int64(l.MaxQueryLength)))
+		limits.MaxConcurrentQueries = int(minLimit(int64(limits.MaxConcurrentQueries), int64(l.MaxConcurrentQueries)))
+	}
+	return &limits
+}
+
+// minLimit returns the minimum of a and b, where zero means no limit.
+func minLimit(a, b int64) int64 {
+	if a <= 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// newQueryStats returns QueryStats with the strictest max_query_series and max_query_bytes_read limits
+// for the queried tenants from -overrides.config.
+//
+// The time spent by r in the query scheduler queue is registered in the returned QueryStats.
+func newQueryStats(startTime time.Time, at *auth.Token, tenants []*tenant.Tenant, r *http.Request) *netstorage.QueryStats {
+	limits := getLimits(at, tenants)
+	qs := netstorage.NewQueryStats(startTime)
+	qs.SetLimits(limits.MaxQuerySeries, limits.MaxQueryBytesRead)
+	qs.SetQueueDuration(searchutils.GetQueueDuration(r))
+	return qs
+}
+
+// checkEntriesLimit verifies `limit` arg against the strictest max_entries_limit_per_query for the queried tenants from -overrides.config.
+func checkEntriesLimit(at *auth.Token, tenants []*tenant.Tenant, limit int64) error {
+	maxEntries := getLimits(at, tenants).MaxEntriesLimitPerQuery
+	if maxEntries <= 0 || limit <= int64(maxEntries) {
+		return nil
+	}
+	maxEntriesLimitExceeded.Inc()
+	return &httpserver.ErrorWithStatusCode{
+		Err:        fmt.Errorf("max entries limit per query exceeded, limit > max_entries_limit (%d > %d)", limit, maxEntries),
+		StatusCode: http.StatusBadRequest,
+	}
+}
+
+// checkQueryLength verifies the time range [start ... end] against the strictest max_query_length for the queried tenants from -overrides.config.
+func checkQueryLength(at *auth.Token, tenants []*tenant.Tenant, start, end int64) error {
+	maxLength := getLimits(at, tenants).MaxQueryLength
+	length := time.Duration(end-start) * time.Millisecond
+	if maxLength <= 0 || length <= maxLength {
+		return nil
+	}
+	maxQueryLengthExceeded.Inc()
+	return &httpserver.ErrorWithStatusCode{
+		Err:        fmt.Errorf("the query time range exceeds the limit (query length: %s, limit: %s)", length, maxLength),
+		StatusCode: http.StatusBadRequest,
+	}
+}
+
+// acquireQuerySlot acquires a slot for concurrent query for every queried tenant according to max_concurrent_queries from -overrides.config.
+//
+// The returned func must be called when the query is finished.
+func acquireQuerySlot(at *auth.Token, tenants []*tenant.Tenant) (func(), error) {
+	ats := []*auth.Token{at}
+	if len(tenants) > 0 {
+		ats = ats[:0]
+		seen := make(map[auth.Token]bool, len(tenants))
+		for _, t := range tenants {
+			if !seen[*t.AuthToken] {
+				seen[*t.AuthToken] = true
+				ats = append(ats, t.AuthToken)
+			}
+		}
+	}
+	var releases []func()
+	releaseAll := func() {
+		for _, release := range releases {
+			release()
+		}
+	}
+	for _, at := range ats {
+		release, err := acquireTenantQuerySlot(at)
+		if err != nil {
+			releaseAll()
+			return nil, err
+		}
+		releases = append(releases, release)
+	}
+	return releaseAll, nil
+}
+
+func acquireTenantQuerySlot(at *auth.Token) (func(), error) {
+	maxConcurrent := overrides.GetLimits(at).MaxConcurrentQueries
+	if maxConcurrent <= 0 {
+		return func() {}, nil
+	}
+	k := *at
+	concurrentQueriesLock.Lock()
+	defer concurrentQueriesLock.Unlock()
+	n := concurrentQueries[k]
+	if n >= maxConcurrent {
+		maxConcurrentQueriesExceeded.Inc()
+		return nil, &httpserver.ErrorWithStatusCode{
+			Err:        fmt.Errorf("max concurrent queries limit exceeded, count > limit (%d > %d)", n+1, maxConcurrent),
+			StatusCode: http.StatusTooManyRequests,
+		}
+	}
+	concurrentQueries[k] = n + 1
+	return func() {
+		concurrentQueriesLock.Lock()
+		if concurrentQueries[k]--; concurrentQueries[k] <= 0 {
+			delete(concurrentQueries, k)
+		}
+		concurrentQueriesLock.Unlock()
+	}, nil
+}
+
+var (
+	concurrentQueriesLock sync.Mutex
+	concurrentQueries     = make(map[auth.Token]int)
+)
+
+var (
+	maxEntriesLimitExceeded      = metrics.NewCounter(`vm_query_limit_exceeded_total{limit="max_entries_limit_per_query"}`)
+	maxQueryLengthExceeded       = metrics.NewCounter(`vm_query_limit_exceeded_total{limit="max_query_length"}`)
+	maxConcurrentQueriesExceeded = metrics.NewCounter(`vm_query_limit_exceeded_total{limit="max_concurrent_queries"}`)
+)
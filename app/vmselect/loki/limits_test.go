@@ -0,0 +1,53 @@
+package loki
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/overrides"
+)
+
+func TestGetStrictestLimits(t *testing.T) {
+	f := func(ls []*overrides.Limits, limitsExpected *overrides.Limits) {
+		t.Helper()
+		limits := getStrictestLimits(ls)
+		if !reflect.DeepEqual(limits, limitsExpected) {
+			t.Fatalf("unexpected limits\ngot\n%+v\nwant\n%+v", limits, limitsExpected)
+		}
+	}
+	f(nil, &overrides.Limits{})
+	f([]*overrides.Limits{{
+		MaxQuerySeries: 100,
+		MaxQueryLength: time.Hour,
+	}}, &overrides.Limits{
+		MaxQuerySeries: 100,
+		MaxQueryLength: time.Hour,
+	})
+
+	// Zero limits are ignored.
+	f([]*overrides.Limits{
+		{
+			MaxQuerySeries:       100,
+			MaxQueryLength:       24 * time.Hour,
+			MaxConcurrentQueries: 5,
+		},
+		{
+			MaxQuerySeries:          50,
+			MaxQueryBytesRead:       1e9,
+			MaxEntriesLimitPerQuery: 5000,
+			MaxQueryLength:          time.Hour,
+		},
+		{
+			MaxQueryBytesRead:       2e9,
+			MaxEntriesLimitPerQuery: 1000,
+			MaxConcurrentQueries:    10,
+		},
+	}, &overrides.Limits{
+		MaxQuerySeries:          50,
+		MaxQueryBytesRead:       1e9,
+		MaxEntriesLimitPerQuery: 1000,
+		MaxQueryLength:          time.Hour,
+		MaxConcurrentQueries:    5,
+	})
+}
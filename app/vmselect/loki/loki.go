@@ -15,9 +15,10 @@ import (
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/querier"
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/searchutils"
-	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/websocket"
 	"github.com/VictoriaMetrics/VictoriaLogs/lib/logql"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/querytracer"
 	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/tenant"
 	"github.com/VictoriaMetrics/VictoriaMetrics/app/vmselect/bufferedwriter"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
@@ -77,7 +78,7 @@ func FederateHandler(startTime time.Time, at *auth.Token, w http.ResponseWriter,
 	if start >= end {
 		start = end - defaultStep
 	}
-	tagFilterss, err := getTagFilterssFromMatches(matches)
+	tagFilterss, err := getTagFilterssFromMatches(matches, searchutils.GetEnforcedTagFilters(r))
 	if err != nil {
 		return err
 	}
@@ -89,7 +90,7 @@ func FederateHandler(startTime time.Time, at *auth.Token, w http.ResponseWriter,
 		TagFilterss:  tagFilterss,
 		FetchData:    storage.FetchAll,
 	}
-	rss, isPartial, err := netstorage.ProcessSearchQuery(at, sq, deadline)
+	rss, isPartial, err := netstorage.ProcessSearchQuery(nil, at, sq, newQueryStats(startTime, at, nil, r), deadline)
 	if err != nil {
 		return fmt.Errorf("cannot fetch data for %q: %w", sq, err)
 	}
@@ -146,8 +147,11 @@ func ExportNativeHandler(startTime time.Time, at *auth.Token, w http.ResponseWri
 	if err != nil {
 		return err
 	}
+	if err := checkQueryLength(at, nil, start, end); err != nil {
+		return err
+	}
 	deadline := searchutils.GetDeadlineForExport(r, startTime)
-	tagFilterss, err := getTagFilterssFromMatches(matches)
+	tagFilterss, err := getTagFilterssFromMatches(matches, searchutils.GetEnforcedTagFilters(r))
 	if err != nil {
 		return err
 	}
@@ -245,6 +249,9 @@ func ExportHandler(startTime time.Time, at *auth.Token, w http.ResponseWriter, r
 	if start >= end {
 		end = start + defaultStep
 	}
+	if err := checkQueryLength(at, nil, start, end); err != nil {
+		return err
+	}
 	if err := exportHandler(at, w, r, matches, start, end, format, maxRowsPerLine, reduceMemUsage, deadline); err != nil {
 		return fmt.Errorf("error when exporting data for queries=%q on the time range (start=%d, end=%d): %w", matches, start, end, err)
 	}
@@ -308,7 +315,7 @@ func exportHandler(at *auth.Token, w http.ResponseWriter, r *http.Request, match
 		}
 	}
 
-	tagFilterss, err := getTagFilterssFromMatches(matches)
+	tagFilterss, err := getTagFilterssFromMatches(matches, searchutils.GetEnforcedTagFilters(r))
 	if err != nil {
 		return err
 	}
@@ -327,7 +334,7 @@ func exportHandler(at *auth.Token, w http.ResponseWriter, r *http.Request, match
 	resultsCh := make(chan *quicktemplate.ByteBuffer, runtime.GOMAXPROCS(-1))
 	doneCh := make(chan error)
 	if !reduceMemUsage {
-		rss, isPartial, err := netstorage.ProcessSearchQuery(at, sq, deadline)
+		rss, isPartial, err := netstorage.ProcessSearchQuery(nil, at, sq, newQueryStats(time.Now(), at, nil, r), deadline)
 		if err != nil {
 			return fmt.Errorf("cannot fetch data for %q: %w", sq, err)
 		}
@@ -430,7 +437,7 @@ func DeleteHandler(startTime time.Time, at *auth.Token, r *http.Request) error {
 		return fmt.Errorf("missing `match[]` arg")
 	}
 	deadline := searchutils.GetDeadlineForQuery(r, startTime)
-	tagFilterss, err := getTagFilterssFromMatches(matches)
+	tagFilterss, err := getTagFilterssFromMatches(matches, searchutils.GetEnforcedTagFilters(r))
 	if err != nil {
 		return err
 	}
@@ -492,16 +499,25 @@ var httpClient = &http.Client{
 // See https://prometheus.io/docs/prometheus/latest/querying/api/#querying-label-values
 func LabelValuesHandler(startTime time.Time, at *auth.Token, labelName string, w http.ResponseWriter, r *http.Request) error {
 	deadline := searchutils.GetDeadlineForQuery(r, startTime)
+	etfs := searchutils.GetEnforcedTagFilters(r)
 	if err := r.ParseForm(); err != nil {
 		return fmt.Errorf("cannot parse form values: %w", err)
 	}
 	var labelValues []string
 	var isPartial bool
-	if len(r.Form["match[]"]) == 0 && len(r.Form["start"]) == 0 && len(r.Form["end"]) == 0 {
-		var err error
-		labelValues, isPartial, err = netstorage.GetLabelValues(at, labelName, deadline)
+	if len(r.Form["match[]"]) == 0 {
+		tr, err := getLabelsTimeRange(r, startTime)
 		if err != nil {
-			return fmt.Errorf(`cannot obtain label values for %q: %w`, labelName, err)
+			return err
+		}
+		if len(etfs) > 0 {
+			// The index cannot be limited to streams allowed for the user, so obtain label values from the allowed streams.
+			labelValues, isPartial, err = labelValuesWithMatches(at, labelName, nil, etfs, tr.MinTimestamp, tr.MaxTimestamp, deadline)
+		} else {
+			labelValues, isPartial, err = netstorage.GetLabelValues(at, tr, labelName, deadline)
+		}
+		if err != nil {
+			return fmt.Errorf(`cannot obtain label values for %q on %s: %w`, labelName, tr.String(), err)
 		}
 	} else {
 		// Extended functionality that allows filtering by label filters and time range
@@ -509,9 +525,6 @@ func LabelValuesHandler(startTime time.Time, at *auth.Token, labelName string, w
 		// is equivalent to `label_values(foobar{baz="abc"}, foo)` call on the selected
 		// time range in Grafana templating.
 		matches := r.Form["match[]"]
-		if len(matches) == 0 {
-			matches = []string{fmt.Sprintf("{%s!=''}", labelName)}
-		}
 		ct := startTime.UnixNano() / 1e6
 		end, err := searchutils.GetTime(r, "end", ct)
 		if err != nil {
@@ -521,7 +534,7 @@ func LabelValuesHandler(startTime time.Time, at *auth.Token, labelName string, w
 		if err != nil {
 			return err
 		}
-		labelValues, isPartial, err = labelValuesWithMatches(at, labelName, matches, start, end, deadline)
+		labelValues, isPartial, err = labelValuesWithMatches(at, labelName, matches, etfs, start, end, deadline)
 		if err != nil {
 			return fmt.Errorf("cannot obtain label values for %q, match[]=%q, start=%d, end=%d: %w", labelName, matches, start, end, err)
 		}
@@ -541,11 +554,11 @@ func LabelValuesHandler(startTime time.Time, at *auth.Token, labelName string, w
 	return nil
 }
 
-func labelValuesWithMatches(at *auth.Token, labelName string, matches []string, start, end int64, deadline searchutils.Deadline) ([]string, bool, error) {
-	if len(matches) == 0 {
-		logger.Panicf("BUG: matches must be non-empty")
+func labelValuesWithMatches(at *auth.Token, labelName string, matches []string, etfs []storage.TagFilter, start, end int64, deadline searchutils.Deadline) ([]string, bool, error) {
+	if len(matches) == 0 && len(etfs) == 0 {
+		logger.Panicf("BUG: matches or etfs must be non-empty")
 	}
-	tagFilterss, err := getTagFilterssFromMatches(matches)
+	tagFilterss, err := getTagFilterssFromMatches(matches, etfs)
 	if err != nil {
 		return nil, false, err
 	}
@@ -573,7 +586,7 @@ func labelValuesWithMatches(at *auth.Token, labelName string, matches []string,
 		TagFilterss:  tagFilterss,
 		FetchData:    storage.NotFetch,
 	}
-	rss, isPartial, err := netstorage.ProcessSearchQuery(at, sq, deadline)
+	rss, isPartial, err := netstorage.ProcessSearchQuery(nil, at, sq, nil, deadline)
 	if err != nil {
 		return nil, false, fmt.Errorf("cannot fetch data for %q: %w", sq, err)
 	}
@@ -679,6 +692,29 @@ func TSDBStatusHandler(startTime time.Time, at *auth.Token, w http.ResponseWrite
 	return nil
 }
 
+// CancelActiveQueryHandler processes /loki/api/v1/status/active_queries/cancel request.
+//
+// It cancels the active query for the tenant at with the `qid` id listed at /loki/api/v1/status/active_queries.
+func CancelActiveQueryHandler(at *auth.Token, w http.ResponseWriter, r *http.Request) error {
+	qidStr := r.FormValue("qid")
+	if len(qidStr) == 0 {
+		return fmt.Errorf("missing `qid` arg")
+	}
+	qid, err := strconv.ParseUint(qidStr, 16, 64)
+	if err != nil {
+		return fmt.Errorf("cannot parse `qid` arg %q: it must contain hex id from /loki/api/v1/status/active_queries", qidStr)
+	}
+	if !querier.CancelActiveQuery(at, qid) {
+		return &httpserver.ErrorWithStatusCode{
+			Err:        fmt.Errorf("cannot find active query with qid=%016X; it may be already finished", qid),
+			StatusCode: http.StatusNotFound,
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"status":"success"}`)
+	return nil
+}
+
 var tsdbStatusDuration = metrics.NewSummary(`vm_request_duration_seconds{path="/api/v1/status/tsdb"}`)
 
 // LabelsHandler processes /api/v1/labels request.
@@ -686,24 +722,30 @@ var tsdbStatusDuration = metrics.NewSummary(`vm_request_duration_seconds{path="/
 // See https://prometheus.io/docs/prometheus/latest/querying/api/#getting-label-names
 func LabelsHandler(startTime time.Time, at *auth.Token, w http.ResponseWriter, r *http.Request) error {
 	deadline := searchutils.GetDeadlineForQuery(r, startTime)
+	etfs := searchutils.GetEnforcedTagFilters(r)
 	if err := r.ParseForm(); err != nil {
 		return fmt.Errorf("cannot parse form values: %w", err)
 	}
 	var labels []string
 	var isPartial bool
-	if len(r.Form["match[]"]) == 0 && len(r.Form["start"]) == 0 && len(r.Form["end"]) == 0 {
-		var err error
-		labels, isPartial, err = netstorage.GetLabels(at, deadline)
+	if len(r.Form["match[]"]) == 0 {
+		tr, err := getLabelsTimeRange(r, startTime)
 		if err != nil {
-			return fmt.Errorf("cannot obtain labels: %w", err)
+			return err
+		}
+		if len(etfs) > 0 {
+			// The index cannot be limited to streams allowed for the user, so obtain labels from the allowed streams.
+			labels, isPartial, err = labelsWithMatches(at, nil, etfs, tr.MinTimestamp, tr.MaxTimestamp, deadline)
+		} else {
+			labels, isPartial, err = netstorage.GetLabels(at, tr, deadline)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot obtain labels on %s: %w", tr.String(), err)
 		}
 	} else {
 		// Extended functionality that allows filtering by label filters and time range
 		// i.e. /api/v1/labels?match[]=foobar{baz="abc"}&start=...&end=...
 		matches := r.Form["match[]"]
-		if len(matches) == 0 {
-			matches = []string{"{__name__!=''}"}
-		}
 		ct := startTime.UnixNano() / 1e6
 		end, err := searchutils.GetTime(r, "end", ct)
 		if err != nil {
@@ -713,7 +755,7 @@ func LabelsHandler(startTime time.Time, at *auth.Token, w http.ResponseWriter, r
 		if err != nil {
 			return err
 		}
-		labels, isPartial, err = labelsWithMatches(at, matches, start, end, deadline)
+		labels, isPartial, err = labelsWithMatches(at, matches, etfs, start, end, deadline)
 		if err != nil {
 			return fmt.Errorf("cannot obtain labels for match[]=%q, start=%d, end=%d: %w", matches, start, end, err)
 		}
@@ -733,11 +775,39 @@ func LabelsHandler(startTime time.Time, at *auth.Token, w http.ResponseWriter, r
 	return nil
 }
 
-func labelsWithMatches(at *auth.Token, matches []string, start, end int64, deadline searchutils.Deadline) ([]string, bool, error) {
-	if len(matches) == 0 {
-		logger.Panicf("BUG: matches must be non-empty")
+// getLabelsTimeRange returns time range for labels and label values requests from `start` and `end` query args.
+//
+// The whole retention is covered if both args are missing.
+func getLabelsTimeRange(r *http.Request, startTime time.Time) (storage.TimeRange, error) {
+	ct := startTime.UnixNano() / 1e6
+	if len(r.Form["start"]) == 0 && len(r.Form["end"]) == 0 {
+		return storage.TimeRange{
+			MinTimestamp: 0,
+			MaxTimestamp: ct,
+		}, nil
+	}
+	end, err := searchutils.GetTime(r, "end", ct)
+	if err != nil {
+		return storage.TimeRange{}, err
+	}
+	start, err := searchutils.GetTime(r, "start", end-defaultStep)
+	if err != nil {
+		return storage.TimeRange{}, err
+	}
+	if start >= end {
+		end = start + defaultStep
+	}
+	return storage.TimeRange{
+		MinTimestamp: start,
+		MaxTimestamp: end,
+	}, nil
+}
+
+func labelsWithMatches(at *auth.Token, matches []string, etfs []storage.TagFilter, start, end int64, deadline searchutils.Deadline) ([]string, bool, error) {
+	if len(matches) == 0 && len(etfs) == 0 {
+		logger.Panicf("BUG: matches or etfs must be non-empty")
 	}
-	tagFilterss, err := getTagFilterssFromMatches(matches)
+	tagFilterss, err := getTagFilterssFromMatches(matches, etfs)
 	if err != nil {
 		return nil, false, err
 	}
@@ -752,7 +822,7 @@ func labelsWithMatches(at *auth.Token, matches []string, start, end int64, deadl
 		TagFilterss:  tagFilterss,
 		FetchData:    storage.NotFetch,
 	}
-	rss, isPartial, err := netstorage.ProcessSearchQuery(at, sq, deadline)
+	rss, isPartial, err := netstorage.ProcessSearchQuery(nil, at, sq, nil, deadline)
 	if err != nil {
 		return nil, false, fmt.Errorf("cannot fetch data for %q: %w", sq, err)
 	}
@@ -835,13 +905,16 @@ func SeriesHandler(startTime time.Time, at *auth.Token, w http.ResponseWriter, r
 	}
 	deadline := searchutils.GetDeadlineForQuery(r, startTime)
 
-	tagFilterss, err := getTagFilterssFromMatches(matches)
+	tagFilterss, err := getTagFilterssFromMatches(matches, searchutils.GetEnforcedTagFilters(r))
 	if err != nil {
 		return err
 	}
 	if start >= end {
 		end = start + defaultStep
 	}
+	if err := checkQueryLength(at, nil, start, end); err != nil {
+		return err
+	}
 	sq := &storage.SearchQuery{
 		AccountID:    at.AccountID,
 		ProjectID:    at.ProjectID,
@@ -850,7 +923,7 @@ func SeriesHandler(startTime time.Time, at *auth.Token, w http.ResponseWriter, r
 		TagFilterss:  tagFilterss,
 		FetchData:    storage.NotFetch,
 	}
-	rss, isPartial, err := netstorage.ProcessSearchQuery(at, sq, deadline)
+	rss, isPartial, err := netstorage.ProcessSearchQuery(nil, at, sq, newQueryStats(startTime, at, nil, r), deadline)
 	if err != nil {
 		return fmt.Errorf("cannot fetch data for %q: %w", sq, err)
 	}
@@ -894,8 +967,10 @@ var seriesDuration = metrics.NewSummary(`vm_request_duration_seconds{path="/api/
 
 // QueryHandler processes /api/v1/query request.
 //
+// The query is executed over all the tenants if len(tenants) > 1 or if tenantLabel isn't empty.
+//
 // See https://prometheus.io/docs/prometheus/latest/querying/api/#instant-queries
-func QueryHandler(startTime time.Time, at *auth.Token, w http.ResponseWriter, r *http.Request) error {
+func QueryHandler(startTime time.Time, at *auth.Token, tenants []*tenant.Tenant, tenantLabel string, w http.ResponseWriter, r *http.Request) error {
 	ct := startTime.UnixNano() / 1e6
 	query := r.FormValue("query")
 	if len(query) == 0 {
@@ -920,14 +995,26 @@ func QueryHandler(startTime time.Time, at *auth.Token, w http.ResponseWriter, r
 	if err != nil {
 		return err
 	}
+	if err := checkEntriesLimit(at, tenants, limit); err != nil {
+		return err
+	}
+	// Range selectors and subqueries read data on the time range preceding the query time.
+	if err := checkQueryLength(at, tenants, start-querier.GetQueryLookback(query, step), start); err != nil {
+		return err
+	}
 	forward := searchutils.GetString(r, "direction", "backward") == "forward"
+	releaseQuerySlot, err := acquireQuerySlot(at, tenants)
+	if err != nil {
+		return err
+	}
+	defer releaseQuerySlot()
 
 	deadline := searchutils.GetDeadlineForQuery(r, startTime)
 
 	if len(query) > maxQueryLen.N {
 		return fmt.Errorf("too long query; got %d bytes; mustn't exceed `-search.maxQueryLen=%d` bytes", len(query), maxQueryLen.N)
 	}
-	if childQuery, windowStr, offsetStr := querier.IsMetricSelectorWithRollup(query); childQuery != "" {
+	if childQuery, windowStr, offsetStr := querier.IsMetricSelectorWithRollup(query); childQuery != "" && len(tenants) <= 1 && tenantLabel == "" {
 		window, err := parsePositiveDuration(windowStr, step)
 		if err != nil {
 			return fmt.Errorf("cannot parse window: %w", err)
@@ -966,7 +1053,7 @@ func QueryHandler(startTime time.Time, at *auth.Token, w http.ResponseWriter, r
 		start = end - window
 
 		w.Header().Set("Content-Type", "application/json")
-		if _, err := queryRangeHandler(startTime, at, w, childQuery, start, end, step, limit, forward, r, ct, false, nil); err != nil {
+		if _, err := queryRangeHandler(startTime, at, tenants, tenantLabel, w, childQuery, start, end, step, limit, forward, r, ct, false, nil, nil); err != nil {
 			return fmt.Errorf("error when executing query=%q on the time range (start=%d, end=%d, step=%d): %w", childQuery, start, end, step, err)
 		}
 
@@ -994,11 +1081,17 @@ func QueryHandler(startTime time.Time, at *auth.Token, w http.ResponseWriter, r
 		LookbackDelta:    lookbackDelta,
 
 		DenyPartialResponse: searchutils.GetDenyPartialResponse(r),
+		Tenants:             tenants,
+		TenantLabel:         tenantLabel,
+		QueryStats:          newQueryStats(startTime, at, tenants, r),
+		EnforcedTagFilters:  searchutils.GetEnforcedTagFilters(r),
+		Tracer:              querytracer.New(searchutils.GetBool(r, "trace"), "%s: query=%s, time=%d, step=%d", r.URL.Path, query, start, step),
 	}
 	result, e, err := querier.Exec(&ec, query, true)
 	if err != nil {
 		return fmt.Errorf("error when executing query=%q for (time=%d, step=%d): %w", query, start, step, err)
 	}
+	ec.QueryStats.FinishEval()
 	if queryOffset > 0 {
 		for i := range result {
 			timestamps := result[i].Timestamps
@@ -1014,9 +1107,9 @@ func QueryHandler(startTime time.Time, at *auth.Token, w http.ResponseWriter, r
 
 	switch e.(type) {
 	case *logql.BinaryOpExpr, *logql.MetricExpr:
-		WriteStreamsQueryResponse(bw, result)
+		WriteStreamsQueryResponse(bw, result, ec.QueryStats, ec.Tracer)
 	default:
-		WriteVectorQueryResponse(bw, result)
+		WriteVectorQueryResponse(bw, result, ec.QueryStats, ec.Tracer)
 	}
 
 	if err := bw.Flush(); err != nil {
@@ -1047,8 +1140,10 @@ const defaultLimit = 1000
 
 // QueryRangeHandler processes /api/v1/query_range request.
 //
+// The query is executed over all the tenants if len(tenants) > 1 or if tenantLabel isn't empty.
+//
 // See https://prometheus.io/docs/prometheus/latest/querying/api/#range-queries
-func QueryRangeHandler(startTime time.Time, at *auth.Token, w http.ResponseWriter, r *http.Request) error {
+func QueryRangeHandler(startTime time.Time, at *auth.Token, tenants []*tenant.Tenant, tenantLabel string, w http.ResponseWriter, r *http.Request) error {
 	ct := startTime.UnixNano() / 1e6
 	query := r.FormValue("query")
 	if len(query) == 0 {
@@ -1070,72 +1165,35 @@ func QueryRangeHandler(startTime time.Time, at *auth.Token, w http.ResponseWrite
 	if err != nil {
 		return err
 	}
-	forward := searchutils.GetString(r, "direction", "backward") == "forward"
-
-	w.Header().Set("Content-Type", "application/json")
-
-	if _, err := queryRangeHandler(startTime, at, w, query, start, end, step, limit, forward, r, ct, false, nil); err != nil {
-		return fmt.Errorf("error when executing query=%q on the time range (start=%d, end=%d, step=%d): %w", query, start, end, step, err)
-	}
-	queryRangeDuration.UpdateDuration(startTime)
-	return nil
-}
-
-func TailHandler(startTime time.Time, at *auth.Token, w http.ResponseWriter, r *http.Request) error {
-	ct := startTime.UnixNano() / 1e6
-	query := r.FormValue("query")
-	if len(query) == 0 {
-		return fmt.Errorf("missing `query` arg")
-	}
-	start, err := searchutils.GetTime(r, "start", ct-defaultStep)
-	if err != nil {
+	if err := checkEntriesLimit(at, tenants, limit); err != nil {
 		return err
 	}
-	limit, err := searchutils.GetInt64(r, "limit", defaultLimit)
+	forward := searchutils.GetString(r, "direction", "backward") == "forward"
+	releaseQuerySlot, err := acquireQuerySlot(at, tenants)
 	if err != nil {
 		return err
 	}
-
-	conn, err := websocket.TryUpgrade(w, r)
-	if err != nil {
-		return err
+	defer releaseQuerySlot()
+	var pagination querier.Pagination
+	if cursor := r.FormValue("cursor"); len(cursor) > 0 {
+		c, err := querier.ParseCursor(cursor, query, forward)
+		if err != nil {
+			return fmt.Errorf("cannot parse `cursor` arg: %w", err)
+		}
+		pagination.Cursor = c
 	}
 
-	ticker := time.NewTicker(time.Second)
-	defer ticker.Stop()
-
-	var lastTs, end int64
-	filter := make(map[uint64]int64)
+	w.Header().Set("Content-Type", "application/json")
 
-	for ; true; <-ticker.C {
-		startTime = time.Now()
-		end = startTime.UnixNano() / 1e6
-		result, err := queryRangeHandler(startTime, at, conn, query, start, end, 60, limit, false, r, end, true, filter)
-		if err != nil {
-			return fmt.Errorf("error when executing query=%q on the time range (start=%d, end=%d, limit=%d): %w", query, start, end, limit, err)
-		}
-		for _, rs := range result {
-			lastTs = rs.Timestamps[len(rs.Timestamps)-1]
-			if lastTs > start {
-				start = lastTs
-			}
-			filter[rs.MetricNameHash] = lastTs
-			limit -= int64(len(rs.Timestamps))
-		}
-		for hashKey, lastTs := range filter {
-			if end-lastTs > defaultStep {
-				delete(filter, hashKey)
-			}
-		}
-		if limit <= 0 {
-			break
-		}
+	if _, err := queryRangeHandler(startTime, at, tenants, tenantLabel, w, query, start, end, step, limit, forward, r, ct, false, nil, &pagination); err != nil {
+		return fmt.Errorf("error when executing query=%q on the time range (start=%d, end=%d, step=%d): %w", query, start, end, step, err)
 	}
+	queryRangeDuration.UpdateDuration(startTime)
 	return nil
 }
 
-func queryRangeHandler(startTime time.Time, at *auth.Token, w io.Writer, query string, start, end, step, limit int64,
-	forward bool, r *http.Request, ct int64, tail bool, filter map[uint64]int64) ([]netstorage.Result, error) {
+func queryRangeHandler(startTime time.Time, at *auth.Token, tenants []*tenant.Tenant, tenantLabel string, w io.Writer, query string, start, end, step, limit int64,
+	forward bool, r *http.Request, ct int64, tail bool, filter map[uint64]int64, pagination *querier.Pagination) ([]netstorage.Result, error) {
 	deadline := searchutils.GetDeadlineForQuery(r, startTime)
 	mayCache := !searchutils.GetBool(r, "nocache")
 	lookbackDelta, err := getMaxLookback(r)
@@ -1150,6 +1208,9 @@ func queryRangeHandler(startTime time.Time, at *auth.Token, w io.Writer, query s
 	if start > end {
 		end = start + defaultStep
 	}
+	if err := checkQueryLength(at, tenants, start, end); err != nil {
+		return nil, err
+	}
 	if err := querier.ValidateMaxPointsPerTimeseries(start, end, step); err != nil {
 		return nil, err
 	}
@@ -1170,11 +1231,28 @@ func queryRangeHandler(startTime time.Time, at *auth.Token, w io.Writer, query s
 		LookbackDelta:    lookbackDelta,
 
 		DenyPartialResponse: searchutils.GetDenyPartialResponse(r),
+		Tenants:             tenants,
+		TenantLabel:         tenantLabel,
+		QueryStats:          newQueryStats(startTime, at, tenants, r),
+		Pagination:          pagination,
+		EnforcedTagFilters:  searchutils.GetEnforcedTagFilters(r),
+		Tracer:              querytracer.New(searchutils.GetBool(r, "trace") && !tail, "%s: query=%s, start=%d, end=%d, step=%d", r.URL.Path, query, start, end, step),
+	}
+	ec.ShardIdx, ec.ShardsCount, err = getShard(r)
+	if err != nil {
+		return nil, err
+	}
+	var result []netstorage.Result
+	var e logql.Expr
+	if isFrontendQuery(r, tenants, tenantLabel, tail, pagination) {
+		result, e, err = execFrontend(&ec, query, r)
+	} else {
+		result, e, err = querier.Exec(&ec, query, false)
 	}
-	result, e, err := querier.Exec(&ec, query, false)
 	if err != nil {
 		return nil, fmt.Errorf("cannot execute query: %w", err)
 	}
+	ec.QueryStats.FinishEval()
 
 	bw := bufferedwriter.Get(w)
 	defer bufferedwriter.Put(bw)
@@ -1190,7 +1268,11 @@ func queryRangeHandler(startTime time.Time, at *auth.Token, w io.Writer, query s
 				WriteTailQueryRangeResponse(bw, result)
 			}
 		} else {
-			WriteStreamsQueryRangeResponse(bw, result)
+			nextCursor := ""
+			if pagination != nil && pagination.NextCursor != nil {
+				nextCursor = pagination.NextCursor.Marshal(query, forward)
+			}
+			WriteStreamsQueryRangeResponse(bw, result, ec.QueryStats, nextCursor, ec.Tracer)
 		}
 	default:
 		queryOffset := getLatencyOffsetMilliseconds()
@@ -1202,7 +1284,7 @@ func queryRangeHandler(startTime time.Time, at *auth.Token, w io.Writer, query s
 		// See https://github.com/VictoriaMetrics/VictoriaMetrics/issues/153
 		result = removeFilteredValuesAndTimeseries(result, filter)
 
-		WriteVectorQueryRangeResponse(bw, result)
+		WriteVectorQueryRangeResponse(bw, result, ec.QueryStats, ec.Tracer)
 	}
 
 	if err := bw.Flush(); err != nil {
@@ -1303,7 +1385,10 @@ func getMaxLookback(r *http.Request) (int64, error) {
 	return searchutils.GetDuration(r, "max_lookback", d)
 }
 
-func getTagFilterssFromMatches(matches []string) ([][]storage.TagFilter, error) {
+// getTagFilterssFromMatches returns tag filters for the given matches with etfs added to every match.
+//
+// See searchutils.GetEnforcedTagFilters for details on etfs.
+func getTagFilterssFromMatches(matches []string, etfs []storage.TagFilter) ([][]storage.TagFilter, error) {
 	tagFilterss := make([][]storage.TagFilter, 0, len(matches))
 	for _, match := range matches {
 		tagFilters, err := querier.ParseMetricSelector(match)
@@ -1312,7 +1397,7 @@ func getTagFilterssFromMatches(matches []string) ([][]storage.TagFilter, error)
 		}
 		tagFilterss = append(tagFilterss, tagFilters)
 	}
-	return tagFilterss, nil
+	return searchutils.JoinTagFilterss(tagFilterss, etfs), nil
 }
 
 func getLatencyOffsetMilliseconds() int64 {
@@ -0,0 +1,101 @@
+package loki
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/patterns"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/querier"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/searchutils"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vmselect/bufferedwriter"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+var (
+	patternsSampleRowsPerBlock = flag.Int("search.patternsSampleRowsPerBlock", 100, "The maximum number of log lines sampled by vmstorage per each block for /loki/api/v1/patterns. "+
+		"Bigger values improve patterns accuracy at the cost of higher resource usage for big streams")
+	maxPatterns = flag.Int("search.maxPatterns", 300, "The maximum number of patterns detected per each /loki/api/v1/patterns request. "+
+		"Log lines not matching the detected patterns are ignored after reaching the limit")
+)
+
+// PatternsHandler processes /loki/api/v1/patterns request.
+//
+// See https://grafana.com/docs/loki/latest/reference/loki-http-api/#patterns-detection
+func PatternsHandler(startTime time.Time, at *auth.Token, w http.ResponseWriter, r *http.Request) error {
+	ct := startTime.UnixNano() / 1e6
+	query := r.FormValue("query")
+	if len(query) == 0 {
+		return fmt.Errorf("missing `query` arg")
+	}
+	end, err := searchutils.GetTime(r, "end", ct)
+	if err != nil {
+		return err
+	}
+	start, err := searchutils.GetTime(r, "start", end-defaultStep)
+	if err != nil {
+		return err
+	}
+	if start >= end {
+		end = start + defaultStep
+	}
+	step, err := searchutils.GetDuration(r, "step", defaultStep)
+	if err != nil {
+		return err
+	}
+	deadline := searchutils.GetDeadlineForQuery(r, startTime)
+
+	tq, ok, err := querier.ParseTailQuery(query)
+	if err != nil {
+		return fmt.Errorf("cannot parse `query` arg: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("`query` arg must contain a log stream selector with optional structured metadata and pattern filters; got %q", query)
+	}
+	sq := &storage.SearchQuery{
+		AccountID:    at.AccountID,
+		ProjectID:    at.ProjectID,
+		MinTimestamp: start,
+		MaxTimestamp: end,
+		TagFilterss:  searchutils.JoinTagFilterss([][]storage.TagFilter{tq.TagFilters}, searchutils.GetEnforcedTagFilters(r)),
+		FetchData:    storage.FetchAll,
+	}
+	cfg := patterns.DefaultConfig
+	cfg.MaxClusters = *maxPatterns
+	m := patterns.NewMiner(cfg, step)
+	isPartial, err := netstorage.SampleRows(at, sq, *patternsSampleRowsPerBlock, deadline, func(sb *storage.SampledBlock) error {
+		for i, data := range sb.Values {
+			if !tq.MatchData(data) {
+				continue
+			}
+			line, _, err := storage.UnmarshalLineWithMetadata(nil, data)
+			if err != nil {
+				continue
+			}
+			m.Add(string(line), sb.Timestamps[i], sb.Weights[i])
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot sample log lines for %q: %w", sq, err)
+	}
+	if isPartial && searchutils.GetDenyPartialResponse(r) {
+		return fmt.Errorf("cannot return full response, since some of vmstorage nodes are unavailable")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	bw := bufferedwriter.Get(w)
+	defer bufferedwriter.Put(bw)
+	WritePatternsResponse(bw, m.Patterns())
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	patternsDuration.UpdateDuration(startTime)
+	return nil
+}
+
+var patternsDuration = metrics.NewSummary(`vm_request_duration_seconds{path="/loki/api/v1/patterns"}`)
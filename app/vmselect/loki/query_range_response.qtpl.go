@@ -7,257 +7,300 @@ package loki
 //line app/vmselect/loki/query_range_response.qtpl:1
 import (
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/querytracer"
 )
 
 // QueryRangeResponse generates response for /api/v1/query_range.See https://prometheus.io/docs/prometheus/latest/querying/api/#range-queries
 
-//line app/vmselect/loki/query_range_response.qtpl:8
+//line app/vmselect/loki/query_range_response.qtpl:9
 import (
 	qtio422016 "io"
 
 	qt422016 "github.com/valyala/quicktemplate"
 )
 
-//line app/vmselect/loki/query_range_response.qtpl:8
+//line app/vmselect/loki/query_range_response.qtpl:9
 var (
 	_ = qtio422016.Copy
 	_ = qt422016.AcquireByteBuffer
 )
 
-//line app/vmselect/loki/query_range_response.qtpl:8
-func StreamVectorQueryRangeResponse(qw422016 *qt422016.Writer, rs []netstorage.Result) {
-//line app/vmselect/loki/query_range_response.qtpl:8
-	qw422016.N().S(`{"status":"success","data":{"resultType":"matrix","result":[`)
+//line app/vmselect/loki/query_range_response.qtpl:9
+func StreamVectorQueryRangeResponse(qw422016 *qt422016.Writer, rs []netstorage.Result, qs *netstorage.QueryStats, qt *querytracer.Tracer) {
+//line app/vmselect/loki/query_range_response.qtpl:9
+	qw422016.N().S(`{"status":"success","data":{"resultType":"matrix",`)
 //line app/vmselect/loki/query_range_response.qtpl:14
+	rsAll := rs
+
+//line app/vmselect/loki/query_range_response.qtpl:14
+	qw422016.N().S(`"result":[`)
+//line app/vmselect/loki/query_range_response.qtpl:16
 	if len(rs) > 0 {
-//line app/vmselect/loki/query_range_response.qtpl:15
+//line app/vmselect/loki/query_range_response.qtpl:17
 		streamvectorQueryRangeLine(qw422016, &rs[0])
-//line app/vmselect/loki/query_range_response.qtpl:16
+//line app/vmselect/loki/query_range_response.qtpl:18
 		rs = rs[1:]
 
-//line app/vmselect/loki/query_range_response.qtpl:17
+//line app/vmselect/loki/query_range_response.qtpl:19
 		for i := range rs {
-//line app/vmselect/loki/query_range_response.qtpl:17
+//line app/vmselect/loki/query_range_response.qtpl:19
 			qw422016.N().S(`,`)
-//line app/vmselect/loki/query_range_response.qtpl:18
+//line app/vmselect/loki/query_range_response.qtpl:20
 			streamvectorQueryRangeLine(qw422016, &rs[i])
-//line app/vmselect/loki/query_range_response.qtpl:19
+//line app/vmselect/loki/query_range_response.qtpl:21
 		}
-//line app/vmselect/loki/query_range_response.qtpl:20
+//line app/vmselect/loki/query_range_response.qtpl:22
 	}
-//line app/vmselect/loki/query_range_response.qtpl:20
-	qw422016.N().S(`]}}`)
+//line app/vmselect/loki/query_range_response.qtpl:22
+	qw422016.N().S(`],"stats":`)
 //line app/vmselect/loki/query_range_response.qtpl:24
+	streamqueryStats(qw422016, qs, rsAll)
+//line app/vmselect/loki/query_range_response.qtpl:24
+	qw422016.N().S(`}`)
+//line app/vmselect/loki/query_range_response.qtpl:26
+	qt.Printf("generate response: series=%d", len(rsAll))
+
+//line app/vmselect/loki/query_range_response.qtpl:27
+	streamdumpQueryTrace(qw422016, qt)
+//line app/vmselect/loki/query_range_response.qtpl:27
+	qw422016.N().S(`}`)
+//line app/vmselect/loki/query_range_response.qtpl:29
 }
 
-//line app/vmselect/loki/query_range_response.qtpl:24
-func WriteVectorQueryRangeResponse(qq422016 qtio422016.Writer, rs []netstorage.Result) {
-//line app/vmselect/loki/query_range_response.qtpl:24
+//line app/vmselect/loki/query_range_response.qtpl:29
+func WriteVectorQueryRangeResponse(qq422016 qtio422016.Writer, rs []netstorage.Result, qs *netstorage.QueryStats, qt *querytracer.Tracer) {
+//line app/vmselect/loki/query_range_response.qtpl:29
 	qw422016 := qt422016.AcquireWriter(qq422016)
-//line app/vmselect/loki/query_range_response.qtpl:24
-	StreamVectorQueryRangeResponse(qw422016, rs)
-//line app/vmselect/loki/query_range_response.qtpl:24
+//line app/vmselect/loki/query_range_response.qtpl:29
+	StreamVectorQueryRangeResponse(qw422016, rs, qs, qt)
+//line app/vmselect/loki/query_range_response.qtpl:29
 	qt422016.ReleaseWriter(qw422016)
-//line app/vmselect/loki/query_range_response.qtpl:24
+//line app/vmselect/loki/query_range_response.qtpl:29
 }
 
-//line app/vmselect/loki/query_range_response.qtpl:24
-func VectorQueryRangeResponse(rs []netstorage.Result) string {
-//line app/vmselect/loki/query_range_response.qtpl:24
+//line app/vmselect/loki/query_range_response.qtpl:29
+func VectorQueryRangeResponse(rs []netstorage.Result, qs *netstorage.QueryStats, qt *querytracer.Tracer) string {
+//line app/vmselect/loki/query_range_response.qtpl:29
 	qb422016 := qt422016.AcquireByteBuffer()
-//line app/vmselect/loki/query_range_response.qtpl:24
-	WriteVectorQueryRangeResponse(qb422016, rs)
-//line app/vmselect/loki/query_range_response.qtpl:24
+//line app/vmselect/loki/query_range_response.qtpl:29
+	WriteVectorQueryRangeResponse(qb422016, rs, qs, qt)
+//line app/vmselect/loki/query_range_response.qtpl:29
 	qs422016 := string(qb422016.B)
-//line app/vmselect/loki/query_range_response.qtpl:24
+//line app/vmselect/loki/query_range_response.qtpl:29
 	qt422016.ReleaseByteBuffer(qb422016)
-//line app/vmselect/loki/query_range_response.qtpl:24
+//line app/vmselect/loki/query_range_response.qtpl:29
 	return qs422016
-//line app/vmselect/loki/query_range_response.qtpl:24
+//line app/vmselect/loki/query_range_response.qtpl:29
 }
 
-//line app/vmselect/loki/query_range_response.qtpl:26
+//line app/vmselect/loki/query_range_response.qtpl:31
 func streamvectorQueryRangeLine(qw422016 *qt422016.Writer, r *netstorage.Result) {
-//line app/vmselect/loki/query_range_response.qtpl:26
+//line app/vmselect/loki/query_range_response.qtpl:31
 	qw422016.N().S(`{"metric":`)
-//line app/vmselect/loki/query_range_response.qtpl:28
+//line app/vmselect/loki/query_range_response.qtpl:33
 	streammetricNameObject(qw422016, &r.MetricName)
-//line app/vmselect/loki/query_range_response.qtpl:28
+//line app/vmselect/loki/query_range_response.qtpl:33
 	qw422016.N().S(`,"values":`)
-//line app/vmselect/loki/query_range_response.qtpl:29
+//line app/vmselect/loki/query_range_response.qtpl:34
 	streamvaluesWithTimestamps(qw422016, r.Values, r.Timestamps)
-//line app/vmselect/loki/query_range_response.qtpl:29
+//line app/vmselect/loki/query_range_response.qtpl:34
 	qw422016.N().S(`}`)
-//line app/vmselect/loki/query_range_response.qtpl:31
+//line app/vmselect/loki/query_range_response.qtpl:36
 }
 
-//line app/vmselect/loki/query_range_response.qtpl:31
+//line app/vmselect/loki/query_range_response.qtpl:36
 func writevectorQueryRangeLine(qq422016 qtio422016.Writer, r *netstorage.Result) {
-//line app/vmselect/loki/query_range_response.qtpl:31
+//line app/vmselect/loki/query_range_response.qtpl:36
 	qw422016 := qt422016.AcquireWriter(qq422016)
-//line app/vmselect/loki/query_range_response.qtpl:31
+//line app/vmselect/loki/query_range_response.qtpl:36
 	streamvectorQueryRangeLine(qw422016, r)
-//line app/vmselect/loki/query_range_response.qtpl:31
+//line app/vmselect/loki/query_range_response.qtpl:36
 	qt422016.ReleaseWriter(qw422016)
-//line app/vmselect/loki/query_range_response.qtpl:31
+//line app/vmselect/loki/query_range_response.qtpl:36
 }
 
-//line app/vmselect/loki/query_range_response.qtpl:31
+//line app/vmselect/loki/query_range_response.qtpl:36
 func vectorQueryRangeLine(r *netstorage.Result) string {
-//line app/vmselect/loki/query_range_response.qtpl:31
+//line app/vmselect/loki/query_range_response.qtpl:36
 	qb422016 := qt422016.AcquireByteBuffer()
-//line app/vmselect/loki/query_range_response.qtpl:31
+//line app/vmselect/loki/query_range_response.qtpl:36
 	writevectorQueryRangeLine(qb422016, r)
-//line app/vmselect/loki/query_range_response.qtpl:31
+//line app/vmselect/loki/query_range_response.qtpl:36
 	qs422016 := string(qb422016.B)
-//line app/vmselect/loki/query_range_response.qtpl:31
+//line app/vmselect/loki/query_range_response.qtpl:36
 	qt422016.ReleaseByteBuffer(qb422016)
-//line app/vmselect/loki/query_range_response.qtpl:31
+//line app/vmselect/loki/query_range_response.qtpl:36
 	return qs422016
-//line app/vmselect/loki/query_range_response.qtpl:31
+//line app/vmselect/loki/query_range_response.qtpl:36
 }
 
-//line app/vmselect/loki/query_range_response.qtpl:33
-func StreamStreamsQueryRangeResponse(qw422016 *qt422016.Writer, rs []netstorage.Result) {
-//line app/vmselect/loki/query_range_response.qtpl:33
-	qw422016.N().S(`{"status":"success","data":{"resultType":"streams","result":[`)
-//line app/vmselect/loki/query_range_response.qtpl:39
-	if len(rs) > 0 {
+// StreamsQueryRangeResponse generates response for log queries.nextCursor is the cursor for the next page if it isn't empty.
+
 //line app/vmselect/loki/query_range_response.qtpl:40
+func StreamStreamsQueryRangeResponse(qw422016 *qt422016.Writer, rs []netstorage.Result, qs *netstorage.QueryStats, nextCursor string, qt *querytracer.Tracer) {
+//line app/vmselect/loki/query_range_response.qtpl:40
+	qw422016.N().S(`{"status":"success","data":{"resultType":"streams",`)
+//line app/vmselect/loki/query_range_response.qtpl:45
+	rsAll := rs
+
+//line app/vmselect/loki/query_range_response.qtpl:45
+	qw422016.N().S(`"result":[`)
+//line app/vmselect/loki/query_range_response.qtpl:47
+	if len(rs) > 0 {
+//line app/vmselect/loki/query_range_response.qtpl:48
 		streamstreamsQueryRangeLine(qw422016, &rs[0])
-//line app/vmselect/loki/query_range_response.qtpl:41
+//line app/vmselect/loki/query_range_response.qtpl:49
 		rs = rs[1:]
 
-//line app/vmselect/loki/query_range_response.qtpl:42
+//line app/vmselect/loki/query_range_response.qtpl:50
 		for i := range rs {
-//line app/vmselect/loki/query_range_response.qtpl:42
+//line app/vmselect/loki/query_range_response.qtpl:50
 			qw422016.N().S(`,`)
-//line app/vmselect/loki/query_range_response.qtpl:43
+//line app/vmselect/loki/query_range_response.qtpl:51
 			streamstreamsQueryRangeLine(qw422016, &rs[i])
-//line app/vmselect/loki/query_range_response.qtpl:44
+//line app/vmselect/loki/query_range_response.qtpl:52
 		}
-//line app/vmselect/loki/query_range_response.qtpl:45
+//line app/vmselect/loki/query_range_response.qtpl:53
 	}
-//line app/vmselect/loki/query_range_response.qtpl:45
-	qw422016.N().S(`]}}`)
-//line app/vmselect/loki/query_range_response.qtpl:49
+//line app/vmselect/loki/query_range_response.qtpl:53
+	qw422016.N().S(`],"stats":`)
+//line app/vmselect/loki/query_range_response.qtpl:55
+	streamqueryStats(qw422016, qs, rsAll)
+//line app/vmselect/loki/query_range_response.qtpl:56
+	if nextCursor != "" {
+//line app/vmselect/loki/query_range_response.qtpl:56
+		qw422016.N().S(`,"nextCursor":`)
+//line app/vmselect/loki/query_range_response.qtpl:57
+		qw422016.N().Q(nextCursor)
+//line app/vmselect/loki/query_range_response.qtpl:58
+	}
+//line app/vmselect/loki/query_range_response.qtpl:58
+	qw422016.N().S(`}`)
+//line app/vmselect/loki/query_range_response.qtpl:60
+	qt.Printf("generate response: series=%d", len(rsAll))
+
+//line app/vmselect/loki/query_range_response.qtpl:61
+	streamdumpQueryTrace(qw422016, qt)
+//line app/vmselect/loki/query_range_response.qtpl:61
+	qw422016.N().S(`}`)
+//line app/vmselect/loki/query_range_response.qtpl:63
 }
 
-//line app/vmselect/loki/query_range_response.qtpl:49
-func WriteStreamsQueryRangeResponse(qq422016 qtio422016.Writer, rs []netstorage.Result) {
-//line app/vmselect/loki/query_range_response.qtpl:49
+//line app/vmselect/loki/query_range_response.qtpl:63
+func WriteStreamsQueryRangeResponse(qq422016 qtio422016.Writer, rs []netstorage.Result, qs *netstorage.QueryStats, nextCursor string, qt *querytracer.Tracer) {
+//line app/vmselect/loki/query_range_response.qtpl:63
 	qw422016 := qt422016.AcquireWriter(qq422016)
-//line app/vmselect/loki/query_range_response.qtpl:49
-	StreamStreamsQueryRangeResponse(qw422016, rs)
-//line app/vmselect/loki/query_range_response.qtpl:49
+//line app/vmselect/loki/query_range_response.qtpl:63
+	StreamStreamsQueryRangeResponse(qw422016, rs, qs, nextCursor, qt)
+//line app/vmselect/loki/query_range_response.qtpl:63
 	qt422016.ReleaseWriter(qw422016)
-//line app/vmselect/loki/query_range_response.qtpl:49
+//line app/vmselect/loki/query_range_response.qtpl:63
 }
 
-//line app/vmselect/loki/query_range_response.qtpl:49
-func StreamsQueryRangeResponse(rs []netstorage.Result) string {
-//line app/vmselect/loki/query_range_response.qtpl:49
+//line app/vmselect/loki/query_range_response.qtpl:63
+func StreamsQueryRangeResponse(rs []netstorage.Result, qs *netstorage.QueryStats, nextCursor string, qt *querytracer.Tracer) string {
+//line app/vmselect/loki/query_range_response.qtpl:63
 	qb422016 := qt422016.AcquireByteBuffer()
-//line app/vmselect/loki/query_range_response.qtpl:49
-	WriteStreamsQueryRangeResponse(qb422016, rs)
-//line app/vmselect/loki/query_range_response.qtpl:49
+//line app/vmselect/loki/query_range_response.qtpl:63
+	WriteStreamsQueryRangeResponse(qb422016, rs, qs, nextCursor, qt)
+//line app/vmselect/loki/query_range_response.qtpl:63
 	qs422016 := string(qb422016.B)
-//line app/vmselect/loki/query_range_response.qtpl:49
+//line app/vmselect/loki/query_range_response.qtpl:63
 	qt422016.ReleaseByteBuffer(qb422016)
-//line app/vmselect/loki/query_range_response.qtpl:49
+//line app/vmselect/loki/query_range_response.qtpl:63
 	return qs422016
-//line app/vmselect/loki/query_range_response.qtpl:49
+//line app/vmselect/loki/query_range_response.qtpl:63
 }
 
-//line app/vmselect/loki/query_range_response.qtpl:51
+//line app/vmselect/loki/query_range_response.qtpl:65
 func StreamTailQueryRangeResponse(qw422016 *qt422016.Writer, rs []netstorage.Result) {
-//line app/vmselect/loki/query_range_response.qtpl:51
+//line app/vmselect/loki/query_range_response.qtpl:65
 	qw422016.N().S(`{"streams":[`)
-//line app/vmselect/loki/query_range_response.qtpl:54
+//line app/vmselect/loki/query_range_response.qtpl:68
 	if len(rs) > 0 {
-//line app/vmselect/loki/query_range_response.qtpl:55
+//line app/vmselect/loki/query_range_response.qtpl:69
 		streamstreamsQueryRangeLine(qw422016, &rs[0])
-//line app/vmselect/loki/query_range_response.qtpl:56
+//line app/vmselect/loki/query_range_response.qtpl:70
 		rs = rs[1:]
 
-//line app/vmselect/loki/query_range_response.qtpl:57
+//line app/vmselect/loki/query_range_response.qtpl:71
 		for i := range rs {
-//line app/vmselect/loki/query_range_response.qtpl:57
+//line app/vmselect/loki/query_range_response.qtpl:71
 			qw422016.N().S(`,`)
-//line app/vmselect/loki/query_range_response.qtpl:58
+//line app/vmselect/loki/query_range_response.qtpl:72
 			streamstreamsQueryRangeLine(qw422016, &rs[i])
-//line app/vmselect/loki/query_range_response.qtpl:59
+//line app/vmselect/loki/query_range_response.qtpl:73
 		}
-//line app/vmselect/loki/query_range_response.qtpl:60
+//line app/vmselect/loki/query_range_response.qtpl:74
 	}
-//line app/vmselect/loki/query_range_response.qtpl:60
+//line app/vmselect/loki/query_range_response.qtpl:74
 	qw422016.N().S(`]}`)
-//line app/vmselect/loki/query_range_response.qtpl:63
+//line app/vmselect/loki/query_range_response.qtpl:77
 }
 
-//line app/vmselect/loki/query_range_response.qtpl:63
+//line app/vmselect/loki/query_range_response.qtpl:77
 func WriteTailQueryRangeResponse(qq422016 qtio422016.Writer, rs []netstorage.Result) {
-//line app/vmselect/loki/query_range_response.qtpl:63
+//line app/vmselect/loki/query_range_response.qtpl:77
 	qw422016 := qt422016.AcquireWriter(qq422016)
-//line app/vmselect/loki/query_range_response.qtpl:63
+//line app/vmselect/loki/query_range_response.qtpl:77
 	StreamTailQueryRangeResponse(qw422016, rs)
-//line app/vmselect/loki/query_range_response.qtpl:63
+//line app/vmselect/loki/query_range_response.qtpl:77
 	qt422016.ReleaseWriter(qw422016)
-//line app/vmselect/loki/query_range_response.qtpl:63
+//line app/vmselect/loki/query_range_response.qtpl:77
 }
 
-//line app/vmselect/loki/query_range_response.qtpl:63
+//line app/vmselect/loki/query_range_response.qtpl:77
 func TailQueryRangeResponse(rs []netstorage.Result) string {
-//line app/vmselect/loki/query_range_response.qtpl:63
+//line app/vmselect/loki/query_range_response.qtpl:77
 	qb422016 := qt422016.AcquireByteBuffer()
-//line app/vmselect/loki/query_range_response.qtpl:63
+//line app/vmselect/loki/query_range_response.qtpl:77
 	WriteTailQueryRangeResponse(qb422016, rs)
-//line app/vmselect/loki/query_range_response.qtpl:63
+//line app/vmselect/loki/query_range_response.qtpl:77
 	qs422016 := string(qb422016.B)
-//line app/vmselect/loki/query_range_response.qtpl:63
+//line app/vmselect/loki/query_range_response.qtpl:77
 	qt422016.ReleaseByteBuffer(qb422016)
-//line app/vmselect/loki/query_range_response.qtpl:63
+//line app/vmselect/loki/query_range_response.qtpl:77
 	return qs422016
-//line app/vmselect/loki/query_range_response.qtpl:63
+//line app/vmselect/loki/query_range_response.qtpl:77
 }
 
-//line app/vmselect/loki/query_range_response.qtpl:65
+//line app/vmselect/loki/query_range_response.qtpl:79
 func streamstreamsQueryRangeLine(qw422016 *qt422016.Writer, r *netstorage.Result) {
-//line app/vmselect/loki/query_range_response.qtpl:65
+//line app/vmselect/loki/query_range_response.qtpl:79
 	qw422016.N().S(`{"stream":`)
-//line app/vmselect/loki/query_range_response.qtpl:67
+//line app/vmselect/loki/query_range_response.qtpl:81
 	streammetricNameObject(qw422016, &r.MetricName)
-//line app/vmselect/loki/query_range_response.qtpl:67
+//line app/vmselect/loki/query_range_response.qtpl:81
 	qw422016.N().S(`,"values":`)
-//line app/vmselect/loki/query_range_response.qtpl:68
+//line app/vmselect/loki/query_range_response.qtpl:82
 	streamdatasWithTimestamps(qw422016, r.Datas, r.Timestamps)
-//line app/vmselect/loki/query_range_response.qtpl:68
+//line app/vmselect/loki/query_range_response.qtpl:82
 	qw422016.N().S(`}`)
-//line app/vmselect/loki/query_range_response.qtpl:70
+//line app/vmselect/loki/query_range_response.qtpl:84
 }
 
-//line app/vmselect/loki/query_range_response.qtpl:70
+//line app/vmselect/loki/query_range_response.qtpl:84
 func writestreamsQueryRangeLine(qq422016 qtio422016.Writer, r *netstorage.Result) {
-//line app/vmselect/loki/query_range_response.qtpl:70
+//line app/vmselect/loki/query_range_response.qtpl:84
 	qw422016 := qt422016.AcquireWriter(qq422016)
-//line app/vmselect/loki/query_range_response.qtpl:70
+//line app/vmselect/loki/query_range_response.qtpl:84
 	streamstreamsQueryRangeLine(qw422016, r)
-//line app/vmselect/loki/query_range_response.qtpl:70
+//line app/vmselect/loki/query_range_response.qtpl:84
 	qt422016.ReleaseWriter(qw422016)
-//line app/vmselect/loki/query_range_response.qtpl:70
+//line app/vmselect/loki/query_range_response.qtpl:84
 }
 
-//line app/vmselect/loki/query_range_response.qtpl:70
+//line app/vmselect/loki/query_range_response.qtpl:84
 func streamsQueryRangeLine(r *netstorage.Result) string {
-//line app/vmselect/loki/query_range_response.qtpl:70
+//line app/vmselect/loki/query_range_response.qtpl:84
 	qb422016 := qt422016.AcquireByteBuffer()
-//line app/vmselect/loki/query_range_response.qtpl:70
+//line app/vmselect/loki/query_range_response.qtpl:84
 	writestreamsQueryRangeLine(qb422016, r)
-//line app/vmselect/loki/query_range_response.qtpl:70
+//line app/vmselect/loki/query_range_response.qtpl:84
 	qs422016 := string(qb422016.B)
-//line app/vmselect/loki/query_range_response.qtpl:70
+//line app/vmselect/loki/query_range_response.qtpl:84
 	qt422016.ReleaseByteBuffer(qb422016)
-//line app/vmselect/loki/query_range_response.qtpl:70
+//line app/vmselect/loki/query_range_response.qtpl:84
 	return qs422016
-//line app/vmselect/loki/query_range_response.qtpl:70
+//line app/vmselect/loki/query_range_response.qtpl:84
 }
@@ -0,0 +1,178 @@
+// Code generated by qtc from "query_stats.qtpl". DO NOT EDIT.
+// See https://github.com/valyala/quicktemplate for details.
+
+//line app/vmselect/loki/query_stats.qtpl:1
+package loki
+
+//line app/vmselect/loki/query_stats.qtpl:1
+import (
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
+)
+
+// queryStats generates `data.stats` object for /api/v1/query and /api/v1/query_range responses.See https://grafana.com/docs/loki/latest/reference/loki-http-api/#statistics
+
+//line app/vmselect/loki/query_stats.qtpl:8
+import (
+	qtio422016 "io"
+
+	qt422016 "github.com/valyala/quicktemplate"
+)
+
+//line app/vmselect/loki/query_stats.qtpl:8
+var (
+	_ = qtio422016.Copy
+	_ = qt422016.AcquireByteBuffer
+)
+
+//line app/vmselect/loki/query_stats.qtpl:8
+func streamqueryStats(qw422016 *qt422016.Writer, qs *netstorage.QueryStats, rs []netstorage.Result) {
+//line app/vmselect/loki/query_stats.qtpl:10
+	s := qs.Snapshot()
+	execTime := s.ExecDuration.Seconds()
+	bytesPerSecond := 0.0
+	linesPerSecond := 0.0
+	if execTime > 0 {
+		bytesPerSecond = float64(s.BytesProcessed) / execTime
+		linesPerSecond = float64(s.LinesProcessed) / execTime
+	}
+	entriesReturned := 0
+	for i := range rs {
+		entriesReturned += len(rs[i].Timestamps)
+	}
+
+//line app/vmselect/loki/query_stats.qtpl:22
+	qw422016.N().S(`{"summary":{"bytesProcessedPerSecond":`)
+//line app/vmselect/loki/query_stats.qtpl:25
+	qw422016.N().D(int(bytesPerSecond))
+//line app/vmselect/loki/query_stats.qtpl:25
+	qw422016.N().S(`,"linesProcessedPerSecond":`)
+//line app/vmselect/loki/query_stats.qtpl:26
+	qw422016.N().D(int(linesPerSecond))
+//line app/vmselect/loki/query_stats.qtpl:26
+	qw422016.N().S(`,"totalBytesProcessed":`)
+//line app/vmselect/loki/query_stats.qtpl:27
+	qw422016.N().DUL(s.BytesProcessed)
+//line app/vmselect/loki/query_stats.qtpl:27
+	qw422016.N().S(`,"totalLinesProcessed":`)
+//line app/vmselect/loki/query_stats.qtpl:28
+	qw422016.N().DUL(s.LinesProcessed)
+//line app/vmselect/loki/query_stats.qtpl:28
+	qw422016.N().S(`,"totalPostFilterLines":`)
+//line app/vmselect/loki/query_stats.qtpl:29
+	qw422016.N().DUL(s.LinesPostFilter)
+//line app/vmselect/loki/query_stats.qtpl:29
+	qw422016.N().S(`,"totalEntriesReturned":`)
+//line app/vmselect/loki/query_stats.qtpl:30
+	qw422016.N().D(entriesReturned)
+//line app/vmselect/loki/query_stats.qtpl:30
+	qw422016.N().S(`,"execTime":`)
+//line app/vmselect/loki/query_stats.qtpl:31
+	qw422016.N().F(execTime)
+//line app/vmselect/loki/query_stats.qtpl:31
+	qw422016.N().S(`,"queueTime":`)
+//line app/vmselect/loki/query_stats.qtpl:32
+	qw422016.N().F(s.QueueDuration.Seconds())
+//line app/vmselect/loki/query_stats.qtpl:32
+	qw422016.N().S(`,"subqueries":`)
+//line app/vmselect/loki/query_stats.qtpl:33
+	qw422016.N().DUL(s.Subqueries)
+//line app/vmselect/loki/query_stats.qtpl:33
+	qw422016.N().S(`},"querier":{"store":{"totalChunksRef":`)
+//line app/vmselect/loki/query_stats.qtpl:37
+	qw422016.N().DUL(s.BlocksFetched)
+//line app/vmselect/loki/query_stats.qtpl:37
+	qw422016.N().S(`,"totalChunksDownloaded":`)
+//line app/vmselect/loki/query_stats.qtpl:38
+	qw422016.N().DUL(s.BlocksFetched)
+//line app/vmselect/loki/query_stats.qtpl:38
+	qw422016.N().S(`,"chunksDownloadTime":`)
+//line app/vmselect/loki/query_stats.qtpl:39
+	qw422016.N().DL(int64(s.FetchDuration))
+//line app/vmselect/loki/query_stats.qtpl:39
+	qw422016.N().S(`,"chunk":{"compressedBytes":`)
+//line app/vmselect/loki/query_stats.qtpl:41
+	qw422016.N().DUL(s.BytesFetched)
+//line app/vmselect/loki/query_stats.qtpl:41
+	qw422016.N().S(`,"decompressedBytes":`)
+//line app/vmselect/loki/query_stats.qtpl:42
+	qw422016.N().DUL(s.BytesProcessed)
+//line app/vmselect/loki/query_stats.qtpl:42
+	qw422016.N().S(`,"decompressedLines":`)
+//line app/vmselect/loki/query_stats.qtpl:43
+	qw422016.N().DUL(s.RowsFetched)
+//line app/vmselect/loki/query_stats.qtpl:43
+	qw422016.N().S(`,"postFilterLines":`)
+//line app/vmselect/loki/query_stats.qtpl:44
+	qw422016.N().DUL(s.LinesPostFilter)
+//line app/vmselect/loki/query_stats.qtpl:44
+	qw422016.N().S(`}},"storageNodes":[`)
+//line app/vmselect/loki/query_stats.qtpl:48
+	for i, sns := range s.StorageNodes {
+//line app/vmselect/loki/query_stats.qtpl:48
+		qw422016.N().S(`{"addr":`)
+//line app/vmselect/loki/query_stats.qtpl:50
+		qw422016.N().Q(sns.Addr)
+//line app/vmselect/loki/query_stats.qtpl:50
+		qw422016.N().S(`,"blocksFetched":`)
+//line app/vmselect/loki/query_stats.qtpl:51
+		qw422016.N().DUL(sns.BlocksFetched)
+//line app/vmselect/loki/query_stats.qtpl:51
+		qw422016.N().S(`,"rowsFetched":`)
+//line app/vmselect/loki/query_stats.qtpl:52
+		qw422016.N().DUL(sns.RowsFetched)
+//line app/vmselect/loki/query_stats.qtpl:52
+		qw422016.N().S(`,"bytesFetched":`)
+//line app/vmselect/loki/query_stats.qtpl:53
+		qw422016.N().DUL(sns.BytesFetched)
+//line app/vmselect/loki/query_stats.qtpl:53
+		qw422016.N().S(`}`)
+//line app/vmselect/loki/query_stats.qtpl:55
+		if i+1 < len(s.StorageNodes) {
+//line app/vmselect/loki/query_stats.qtpl:55
+			qw422016.N().S(`,`)
+//line app/vmselect/loki/query_stats.qtpl:55
+		}
+//line app/vmselect/loki/query_stats.qtpl:56
+	}
+//line app/vmselect/loki/query_stats.qtpl:56
+	qw422016.N().S(`],"stages":{"fetchTime":`)
+//line app/vmselect/loki/query_stats.qtpl:59
+	qw422016.N().F(s.FetchDuration.Seconds())
+//line app/vmselect/loki/query_stats.qtpl:59
+	qw422016.N().S(`,"evalTime":`)
+//line app/vmselect/loki/query_stats.qtpl:60
+	qw422016.N().F(s.EvalDuration.Seconds())
+//line app/vmselect/loki/query_stats.qtpl:60
+	qw422016.N().S(`,"renderTime":`)
+//line app/vmselect/loki/query_stats.qtpl:61
+	qw422016.N().F(s.RenderDuration.Seconds())
+//line app/vmselect/loki/query_stats.qtpl:61
+	qw422016.N().S(`}}}`)
+//line app/vmselect/loki/query_stats.qtpl:65
+}
+
+//line app/vmselect/loki/query_stats.qtpl:65
+func writequeryStats(qq422016 qtio422016.Writer, qs *netstorage.QueryStats, rs []netstorage.Result) {
+//line app/vmselect/loki/query_stats.qtpl:65
+	qw422016 := qt422016.AcquireWriter(qq422016)
+//line app/vmselect/loki/query_stats.qtpl:65
+	streamqueryStats(qw422016, qs, rs)
+//line app/vmselect/loki/query_stats.qtpl:65
+	qt422016.ReleaseWriter(qw422016)
+//line app/vmselect/loki/query_stats.qtpl:65
+}
+
+//line app/vmselect/loki/query_stats.qtpl:65
+func queryStats(qs *netstorage.QueryStats, rs []netstorage.Result) string {
+//line app/vmselect/loki/query_stats.qtpl:65
+	qb422016 := qt422016.AcquireByteBuffer()
+//line app/vmselect/loki/query_stats.qtpl:65
+	writequeryStats(qb422016, qs, rs)
+//line app/vmselect/loki/query_stats.qtpl:65
+	qs422016 := string(qb422016.B)
+//line app/vmselect/loki/query_stats.qtpl:65
+	qt422016.ReleaseByteBuffer(qb422016)
+//line app/vmselect/loki/query_stats.qtpl:65
+	return qs422016
+//line app/vmselect/loki/query_stats.qtpl:65
+}
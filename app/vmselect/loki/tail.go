@@ -0,0 +1,477 @@
+package loki
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/querier"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/searchutils"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/websocket"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/tenant"
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vmselect/bufferedwriter"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/cespare/xxhash/v2"
+)
+
+var (
+	maxTailDuration    = flag.Duration("search.maxTailDuration", time.Hour, "The maximum duration for live tail requests to /loki/api/v1/tail")
+	maxTailPendingRows = flag.Int("search.maxTailPendingRows", 10000, "The maximum number of rows per each live tail request, which wait for sending to the client. "+
+		"The remaining rows are dropped and are reported to the client in dropped_entries")
+)
+
+// maxTailDelayFor is the maximum value for `delay_for` arg in seconds. Loki uses the same limit.
+const maxTailDelayFor = 5
+
+// tailFlushInterval is the interval for sending pending rows to live tail clients.
+const tailFlushInterval = 100 * time.Millisecond
+
+// maxTailMetricNames is the maximum number of cached stream names per live tail request.
+const maxTailMetricNames = 100000
+
+// TailHandler processes /loki/api/v1/tail request.
+//
+// Rows for log stream selectors are pushed by vmstorage nodes as soon as they are ingested.
+// Other queries are re-evaluated every second.
+//
+// See https://grafana.com/docs/loki/latest/reference/loki-http-api/#stream-logs
+func TailHandler(startTime time.Time, at *auth.Token, tenants []*tenant.Tenant, tenantLabel string, w http.ResponseWriter, r *http.Request) error {
+	ct := startTime.UnixNano() / 1e6
+	query := r.FormValue("query")
+	if len(query) == 0 {
+		return fmt.Errorf("missing `query` arg")
+	}
+	start, err := searchutils.GetTime(r, "start", ct-defaultStep)
+	if err != nil {
+		return err
+	}
+	limit, err := searchutils.GetInt64(r, "limit", defaultLimit)
+	if err != nil {
+		return err
+	}
+	if err := checkEntriesLimit(at, tenants, limit); err != nil {
+		return err
+	}
+	delayFor, err := searchutils.GetInt64(r, "delay_for", 0)
+	if err != nil {
+		return err
+	}
+	if delayFor < 0 || delayFor > maxTailDelayFor {
+		return fmt.Errorf("`delay_for` arg must be in the range [0...%d] seconds; got %d", maxTailDelayFor, delayFor)
+	}
+	tq, ok, err := querier.ParseTailQuery(query)
+	if err != nil {
+		return fmt.Errorf("cannot parse `query` arg: %w", err)
+	}
+	if etfs := searchutils.GetEnforcedTagFilters(r); ok && len(etfs) > 0 {
+		tq.TagFilters = searchutils.JoinTagFilterss([][]storage.TagFilter{tq.TagFilters}, etfs)[0]
+	}
+
+	conn, err := websocket.TryUpgrade(w, r)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	if !ok {
+		return tailPolling(at, tenants, tenantLabel, conn, query, start, limit, r)
+	}
+
+	// Subscribe to new rows before querying the already ingested rows, so rows aren't lost between these steps.
+	if len(tenants) == 0 {
+		tenants = []*tenant.Tenant{{
+			AuthToken: at,
+		}}
+	}
+	ts := newTailState(tq, tenants, tenantLabel, time.Duration(delayFor)*time.Second)
+	deadline := searchutils.NewDeadline(startTime, *maxTailDuration, "-search.maxTailDuration")
+	stopCh := make(chan struct{})
+	subscribedCh := make(chan struct{})
+	tailDoneCh := make(chan error, 1)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		tailDoneCh <- ts.run(deadline, stopCh, subscribedCh, searchutils.GetDenyPartialResponse(r))
+	}()
+	defer wg.Wait()
+	defer close(stopCh)
+
+	// Detect when the client closes the connection.
+	clientGoneCh := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(ioutil.Discard, conn)
+		close(clientGoneCh)
+	}()
+
+	// Wait until vmstorage nodes subscribe to new rows.
+	select {
+	case <-subscribedCh:
+	case <-clientGoneCh:
+		return nil
+	}
+
+	// Send the already ingested rows in ascending order of timestamps.
+	end := time.Now().UnixNano() / 1e6
+	result, err := queryRangeHandler(time.Now(), at, tenants, tenantLabel, conn, query, start, end, 60, limit, true, r, end, true, nil, nil)
+	if err != nil {
+		return fmt.Errorf("error when executing query=%q on the time range (start=%d, end=%d, limit=%d): %w", query, start, end, limit, err)
+	}
+	for i := range result {
+		rs := &result[i]
+		ts.addSentRows(&rs.MetricName, rs.Timestamps, rs.Datas)
+		limit -= int64(len(rs.Timestamps))
+	}
+
+	// Send rows pushed by vmstorage nodes.
+	ticker := time.NewTicker(tailFlushInterval)
+	defer ticker.Stop()
+	for limit > 0 {
+		var tailErr error
+		tailDone := false
+		select {
+		case <-clientGoneCh:
+			return nil
+		case tailErr = <-tailDoneCh:
+			tailDone = true
+		case <-ticker.C:
+		}
+		n, err := ts.send(conn, time.Now(), tailDone, limit)
+		if err != nil {
+			return fmt.Errorf("cannot send tail response to the client: %w", err)
+		}
+		limit -= int64(n)
+		if tailDone {
+			return tailErr
+		}
+	}
+	return nil
+}
+
+var tailDroppedEntries = metrics.NewCounter(`vm_tail_dropped_entries_total{name="vmselect"}`)
+
+// tailState contains rows pushed by vmstorage nodes during live tail, which wait for sending to the client.
+type tailState struct {
+	tq       *querier.TailQuery
+	tenants  []*tenant.Tenant
+	delayFor time.Duration
+
+	// labelName is the name of the label with tenant org id. It is empty if the label mustn't be added.
+	labelName string
+
+	// sentRows contains the already ingested rows sent to the client.
+	// Pushed rows are skipped if they are already sent in order to avoid duplicates.
+	sentRows map[tailRowKey]struct{}
+
+	mu sync.Mutex
+
+	// metricNames contains stream names with tenant label per each pushed stream name.
+	metricNames map[string]string
+
+	pending []tailEntry
+	dropped []tailEntry
+
+	mn storage.MetricName
+}
+
+type tailEntry struct {
+	metricName string
+	timestamp  int64
+	data       []byte
+	receivedAt time.Time
+}
+
+// tailRowKey identifies a row sent to the client.
+//
+// Rows with identical timestamps in the same stream are distinguished by the hash of their data.
+type tailRowKey struct {
+	metricName string
+	timestamp  int64
+	dataHash   uint64
+}
+
+type tailDroppedEntry struct {
+	mn        storage.MetricName
+	timestamp int64
+}
+
+func newTailState(tq *querier.TailQuery, tenants []*tenant.Tenant, tenantLabel string, delayFor time.Duration) *tailState {
+	ts := &tailState{
+		tq:          tq,
+		tenants:     tenants,
+		delayFor:    delayFor,
+		sentRows:    make(map[tailRowKey]struct{}),
+		metricNames: make(map[string]string),
+	}
+	if len(tenants) > 1 || tenantLabel != "" {
+		ts.labelName = tenantLabel
+		if ts.labelName == "" {
+			ts.labelName = tenant.LabelName
+		}
+	}
+	return ts
+}
+
+// run passes rows pushed by vmstorage nodes for all the tenants to ts until the deadline or until stopCh is closed.
+//
+// subscribedCh is closed when vmstorage nodes subscribe to new rows for all the tenants.
+func (ts *tailState) run(deadline searchutils.Deadline, stopCh <-chan struct{}, subscribedCh chan<- struct{}, denyPartialResponse bool) error {
+	var subscribedWG sync.WaitGroup
+	subscribedWG.Add(len(ts.tenants))
+	go func() {
+		subscribedWG.Wait()
+		close(subscribedCh)
+	}()
+	errCh := make(chan error, len(ts.tenants))
+	for _, t := range ts.tenants {
+		go func(t *tenant.Tenant) {
+			sq := &storage.SearchQuery{
+				AccountID:   t.AuthToken.AccountID,
+				ProjectID:   t.AuthToken.ProjectID,
+				TagFilterss: [][]storage.TagFilter{ts.tq.TagFilters},
+				FetchData:   storage.FetchAll,
+			}
+			isPartial, err := netstorage.Tail(t.AuthToken, sq, deadline, stopCh, subscribedWG.Done, func(rows, dropped []storage.TailRow) error {
+				ts.add(t, rows, dropped)
+				return nil
+			})
+			if err == nil && isPartial && denyPartialResponse {
+				err = fmt.Errorf("cannot return full response, since some of vmstorage nodes are unavailable")
+			}
+			if err != nil {
+				err = fmt.Errorf("cannot tail rows for tenant %q: %w", t.OrgID, err)
+			}
+			errCh <- err
+		}(t)
+	}
+	var firstErr error
+	for range ts.tenants {
+		if err := <-errCh; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// addSentRows registers the already ingested rows for the stream mn sent to the client.
+func (ts *tailState) addSentRows(mn *storage.MetricName, timestamps []int64, datas [][]byte) {
+	if len(timestamps) == 0 {
+		return
+	}
+	metricName := string(mn.Marshal(nil))
+	for i, timestamp := range timestamps {
+		ts.sentRows[newTailRowKey(metricName, timestamp, datas[i])] = struct{}{}
+	}
+}
+
+func newTailRowKey(metricName string, timestamp int64, data []byte) tailRowKey {
+	return tailRowKey{
+		metricName: metricName,
+		timestamp:  timestamp,
+		dataHash:   xxhash.Sum64(data),
+	}
+}
+
+func (ts *tailState) add(t *tenant.Tenant, rows, dropped []storage.TailRow) {
+	receivedAt := time.Now()
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	for i := range rows {
+		row := &rows[i]
+		if !ts.tq.MatchData(row.Value) {
+			continue
+		}
+		metricName := ts.getMetricName(t, row.MetricName)
+		if len(ts.pending) >= *maxTailPendingRows {
+			ts.addDropped(metricName, row.Timestamp)
+			continue
+		}
+		ts.pending = append(ts.pending, tailEntry{
+			metricName: metricName,
+			timestamp:  row.Timestamp,
+			data:       append([]byte{}, row.Value...),
+			receivedAt: receivedAt,
+		})
+	}
+
+	// Rows dropped by vmstorage nodes have no data, so they cannot be checked against structured metadata filters.
+	// Report all of them to the client.
+	for i := range dropped {
+		row := &dropped[i]
+		metricName := ts.getMetricName(t, row.MetricName)
+		ts.addDropped(metricName, row.Timestamp)
+	}
+}
+
+func (ts *tailState) addDropped(metricName string, timestamp int64) {
+	tailDroppedEntries.Inc()
+	if len(ts.dropped) >= *maxTailPendingRows {
+		return
+	}
+	ts.dropped = append(ts.dropped, tailEntry{
+		metricName: metricName,
+		timestamp:  timestamp,
+	})
+}
+
+// getMetricName returns marshaled stream name for the marshaled metricName pushed for the tenant t.
+//
+// The tenant label is added to the returned name if needed.
+func (ts *tailState) getMetricName(t *tenant.Tenant, metricName []byte) string {
+	if ts.labelName == "" {
+		return string(metricName)
+	}
+	if s, ok := ts.metricNames[string(metricName)]; ok {
+		return s
+	}
+	if err := ts.mn.Unmarshal(metricName); err != nil {
+		// This shouldn't happen, since metricName is obtained from vmstorage.
+		return string(metricName)
+	}
+	ts.mn.RemoveTag(ts.labelName)
+	ts.mn.AddTag(ts.labelName, t.OrgID)
+	s := string(ts.mn.Marshal(nil))
+	if len(ts.metricNames) >= maxTailMetricNames {
+		ts.metricNames = make(map[string]string)
+	}
+	ts.metricNames[string(metricName)] = s
+	return s
+}
+
+// send sends up to limit pending rows received before now-ts.delayFor to w.
+//
+// All the pending rows are sent if flushAll is set. Dropped rows are sent if they exist.
+// The number of sent rows is returned.
+func (ts *tailState) send(w io.Writer, now time.Time, flushAll bool, limit int64) (int, error) {
+	ts.mu.Lock()
+	n := len(ts.pending)
+	if !flushAll {
+		// Pending rows are sorted by receivedAt.
+		maxReceivedAt := now.Add(-ts.delayFor)
+		n = sort.Search(len(ts.pending), func(i int) bool {
+			return ts.pending[i].receivedAt.After(maxReceivedAt)
+		})
+	}
+	entries := append([]tailEntry{}, ts.pending[:n]...)
+	ts.pending = append(ts.pending[:0], ts.pending[n:]...)
+	dropped := ts.dropped
+	ts.dropped = nil
+	ts.mu.Unlock()
+
+	rs, err := ts.getResults(entries, limit)
+	if err != nil {
+		return 0, err
+	}
+	des := make([]tailDroppedEntry, len(dropped))
+	for i := range dropped {
+		de := &des[i]
+		if err := de.mn.Unmarshal([]byte(dropped[i].metricName)); err != nil {
+			return 0, fmt.Errorf("cannot unmarshal stream name for dropped entry: %w", err)
+		}
+		de.timestamp = dropped[i].timestamp
+	}
+	if len(rs) == 0 && len(des) == 0 {
+		return 0, nil
+	}
+
+	bw := bufferedwriter.Get(w)
+	defer bufferedwriter.Put(bw)
+	WriteTailResponse(bw, rs, des)
+	if err := bw.Flush(); err != nil {
+		return 0, err
+	}
+	rowsCount := 0
+	for i := range rs {
+		rowsCount += len(rs[i].Timestamps)
+	}
+	return rowsCount, nil
+}
+
+// getResults groups up to limit entries by streams.
+//
+// Entries already sent to the client with the ingested rows are skipped.
+func (ts *tailState) getResults(entries []tailEntry, limit int64) ([]netstorage.Result, error) {
+	dst := entries[:0]
+	for _, e := range entries {
+		if len(ts.sentRows) > 0 {
+			if _, ok := ts.sentRows[newTailRowKey(e.metricName, e.timestamp, e.data)]; ok {
+				continue
+			}
+		}
+		dst = append(dst, e)
+	}
+	entries = dst
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].timestamp < entries[j].timestamp
+	})
+	if int64(len(entries)) > limit {
+		entries = entries[:limit]
+	}
+
+	var rs []netstorage.Result
+	m := make(map[string]int)
+	for _, e := range entries {
+		idx, ok := m[e.metricName]
+		if !ok {
+			idx = len(rs)
+			m[e.metricName] = idx
+			rs = append(rs, netstorage.Result{})
+			if err := rs[idx].MetricName.Unmarshal([]byte(e.metricName)); err != nil {
+				return nil, fmt.Errorf("cannot unmarshal stream name: %w", err)
+			}
+		}
+		r := &rs[idx]
+		r.Timestamps = append(r.Timestamps, e.timestamp)
+		r.Values = append(r.Values, 1)
+		r.Datas = append(r.Datas, e.data)
+	}
+	return rs, nil
+}
+
+// tailPolling sends results for the query to w every second.
+//
+// It is used for queries, which cannot be evaluated on rows pushed by vmstorage nodes.
+func tailPolling(at *auth.Token, tenants []*tenant.Tenant, tenantLabel string, w io.Writer, query string, start, limit int64, r *http.Request) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var lastTs, end int64
+	filter := make(map[uint64]int64)
+
+	for ; true; <-ticker.C {
+		startTime := time.Now()
+		end = startTime.UnixNano() / 1e6
+		result, err := queryRangeHandler(startTime, at, tenants, tenantLabel, w, query, start, end, 60, limit, true, r, end, true, filter, nil)
+		if err != nil {
+			return fmt.Errorf("error when executing query=%q on the time range (start=%d, end=%d, limit=%d): %w", query, start, end, limit, err)
+		}
+		for _, rs := range result {
+			lastTs = rs.Timestamps[len(rs.Timestamps)-1]
+			if lastTs > start {
+				start = lastTs
+			}
+			filter[rs.MetricNameHash] = lastTs
+			limit -= int64(len(rs.Timestamps))
+		}
+		for hashKey, lastTs := range filter {
+			if end-lastTs > defaultStep {
+				delete(filter, hashKey)
+			}
+		}
+		if limit <= 0 {
+			break
+		}
+	}
+	return nil
+}
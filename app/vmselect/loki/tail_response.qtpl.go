@@ -0,0 +1,105 @@
+// Code generated by qtc from "tail_response.qtpl". DO NOT EDIT.
+// See https://github.com/valyala/quicktemplate for details.
+
+//line app/vmselect/loki/tail_response.qtpl:1
+package loki
+
+//line app/vmselect/loki/tail_response.qtpl:1
+import (
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
+)
+
+// TailResponse generates response for /loki/api/v1/tail with rows pushed by vmstorage nodes.See https://grafana.com/docs/loki/latest/reference/loki-http-api/#stream-logs
+
+//line app/vmselect/loki/tail_response.qtpl:8
+import (
+	qtio422016 "io"
+
+	qt422016 "github.com/valyala/quicktemplate"
+)
+
+//line app/vmselect/loki/tail_response.qtpl:8
+var (
+	_ = qtio422016.Copy
+	_ = qt422016.AcquireByteBuffer
+)
+
+//line app/vmselect/loki/tail_response.qtpl:8
+func StreamTailResponse(qw422016 *qt422016.Writer, rs []netstorage.Result, dropped []tailDroppedEntry) {
+//line app/vmselect/loki/tail_response.qtpl:8
+	qw422016.N().S(`{"streams":[`)
+//line app/vmselect/loki/tail_response.qtpl:11
+	for i := range rs {
+//line app/vmselect/loki/tail_response.qtpl:12
+		streamstreamsQueryRangeLine(qw422016, &rs[i])
+//line app/vmselect/loki/tail_response.qtpl:13
+		if i+1 < len(rs) {
+//line app/vmselect/loki/tail_response.qtpl:13
+			qw422016.N().S(`,`)
+//line app/vmselect/loki/tail_response.qtpl:13
+		}
+//line app/vmselect/loki/tail_response.qtpl:14
+	}
+//line app/vmselect/loki/tail_response.qtpl:14
+	qw422016.N().S(`]`)
+//line app/vmselect/loki/tail_response.qtpl:16
+	if len(dropped) > 0 {
+//line app/vmselect/loki/tail_response.qtpl:16
+		qw422016.N().S(`,"dropped_entries":[`)
+//line app/vmselect/loki/tail_response.qtpl:18
+		for i := range dropped {
+//line app/vmselect/loki/tail_response.qtpl:19
+			de := &dropped[i]
+
+//line app/vmselect/loki/tail_response.qtpl:19
+			qw422016.N().S(`{"labels":`)
+//line app/vmselect/loki/tail_response.qtpl:21
+			streammetricNameObject(qw422016, &de.mn)
+//line app/vmselect/loki/tail_response.qtpl:21
+			qw422016.N().S(`,"timestamp":"`)
+//line app/vmselect/loki/tail_response.qtpl:22
+			qw422016.N().DL(de.timestamp * 1e6)
+//line app/vmselect/loki/tail_response.qtpl:22
+			qw422016.N().S(`"}`)
+//line app/vmselect/loki/tail_response.qtpl:24
+			if i+1 < len(dropped) {
+//line app/vmselect/loki/tail_response.qtpl:24
+				qw422016.N().S(`,`)
+//line app/vmselect/loki/tail_response.qtpl:24
+			}
+//line app/vmselect/loki/tail_response.qtpl:25
+		}
+//line app/vmselect/loki/tail_response.qtpl:25
+		qw422016.N().S(`]`)
+//line app/vmselect/loki/tail_response.qtpl:27
+	}
+//line app/vmselect/loki/tail_response.qtpl:27
+	qw422016.N().S(`}`)
+//line app/vmselect/loki/tail_response.qtpl:29
+}
+
+//line app/vmselect/loki/tail_response.qtpl:29
+func WriteTailResponse(qq422016 qtio422016.Writer, rs []netstorage.Result, dropped []tailDroppedEntry) {
+//line app/vmselect/loki/tail_response.qtpl:29
+	qw422016 := qt422016.AcquireWriter(qq422016)
+//line app/vmselect/loki/tail_response.qtpl:29
+	StreamTailResponse(qw422016, rs, dropped)
+//line app/vmselect/loki/tail_response.qtpl:29
+	qt422016.ReleaseWriter(qw422016)
+//line app/vmselect/loki/tail_response.qtpl:29
+}
+
+//line app/vmselect/loki/tail_response.qtpl:29
+func TailResponse(rs []netstorage.Result, dropped []tailDroppedEntry) string {
+//line app/vmselect/loki/tail_response.qtpl:29
+	qb422016 := qt422016.AcquireByteBuffer()
+//line app/vmselect/loki/tail_response.qtpl:29
+	WriteTailResponse(qb422016, rs, dropped)
+//line app/vmselect/loki/tail_response.qtpl:29
+	qs422016 := string(qb422016.B)
+//line app/vmselect/loki/tail_response.qtpl:29
+	qt422016.ReleaseByteBuffer(qb422016)
+//line app/vmselect/loki/tail_response.qtpl:29
+	return qs422016
+//line app/vmselect/loki/tail_response.qtpl:29
+}
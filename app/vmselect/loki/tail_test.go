@@ -0,0 +1,87 @@
+package loki
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/querier"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/tenant"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+)
+
+func TestTailStateSend(t *testing.T) {
+	tq, ok, err := querier.ParseTailQuery(`{app="foo"}`)
+	if err != nil {
+		t.Fatalf("cannot parse tail query: %s", err)
+	}
+	if !ok {
+		t.Fatalf("expecting tail query to be evaluated on pushed rows")
+	}
+	tenants := []*tenant.Tenant{
+		{OrgID: "team-a", AuthToken: &auth.Token{AccountID: 1}},
+		{OrgID: "team-b", AuthToken: &auth.Token{AccountID: 2}},
+	}
+	ts := newTailState(tq, tenants, "", time.Second)
+
+	newRow := func(at *auth.Token, timestamp int64, line string) storage.TailRow {
+		mn := storage.MetricName{
+			AccountID: at.AccountID,
+			ProjectID: at.ProjectID,
+		}
+		mn.AddTag("app", "foo")
+		return storage.TailRow{
+			MetricName: mn.Marshal(nil),
+			Timestamp:  timestamp,
+			Value:      []byte(line),
+		}
+	}
+
+	// The already ingested rows sent to the client must be skipped,
+	// while distinct rows with the same timestamp must be sent.
+	var mn storage.MetricName
+	mn.AccountID = 1
+	mn.AddTag("app", "foo")
+	mn.AddTag(tenant.LabelName, "team-a")
+	ts.addSentRows(&mn, []int64{1000, 2000}, [][]byte{[]byte("old"), []byte("duplicate")})
+
+	ts.add(tenants[0], []storage.TailRow{
+		newRow(tenants[0].AuthToken, 2000, "duplicate"),
+		newRow(tenants[0].AuthToken, 2000, "same-ms"),
+		newRow(tenants[0].AuthToken, 3000, "a1"),
+	}, nil)
+	ts.add(tenants[1], []storage.TailRow{
+		newRow(tenants[1].AuthToken, 2500, "b1"),
+	}, []storage.TailRow{
+		newRow(tenants[1].AuthToken, 2600, ""),
+	})
+
+	f := func(now time.Time, flushAll bool, limit int64, resultExpected string) {
+		t.Helper()
+		var bb bytes.Buffer
+		if _, err := ts.send(&bb, now, flushAll, limit); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if result := bb.String(); result != resultExpected {
+			t.Fatalf("unexpected response\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	// Pending rows are delayed for delay_for, while dropped rows are sent immediately.
+	f(time.Now(), false, 100, `{"streams":[],"dropped_entries":[{"labels":{"app":"foo","__tenant_id__":"team-b"},"timestamp":"2600000000"}]}`)
+
+	// Rows are sorted by timestamps and are grouped by streams.
+	f(time.Now().Add(time.Second), false, 100, `{"streams":[{"stream":{"app":"foo","__tenant_id__":"team-a"},"values":[["2000000000","same-ms"],["3000000000","a1"]]},`+
+		`{"stream":{"app":"foo","__tenant_id__":"team-b"},"values":[["2500000000","b1"]]}]}`)
+
+	// Nothing to send.
+	f(time.Now().Add(time.Second), true, 100, ``)
+
+	// Limit is applied to the sent rows.
+	ts.add(tenants[0], []storage.TailRow{
+		newRow(tenants[0].AuthToken, 4000, "a2"),
+		newRow(tenants[0].AuthToken, 5000, "a3"),
+	}, nil)
+	f(time.Now(), true, 1, `{"streams":[{"stream":{"app":"foo","__tenant_id__":"team-a"},"values":[["4000000000","a2"]]}]}`)
+}
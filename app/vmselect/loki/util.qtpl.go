@@ -6,200 +6,190 @@ package loki
 
 //line app/vmselect/loki/util.qtpl:1
 import (
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/querytracer"
 	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
 )
 
-//line app/vmselect/loki/util.qtpl:7
+//line app/vmselect/loki/util.qtpl:8
 import (
 	qtio422016 "io"
 
 	qt422016 "github.com/valyala/quicktemplate"
 )
 
-//line app/vmselect/loki/util.qtpl:7
+//line app/vmselect/loki/util.qtpl:8
 var (
 	_ = qtio422016.Copy
 	_ = qt422016.AcquireByteBuffer
 )
 
-//line app/vmselect/loki/util.qtpl:7
+//line app/vmselect/loki/util.qtpl:8
 func streammetricNameObject(qw422016 *qt422016.Writer, mn *storage.MetricName) {
-//line app/vmselect/loki/util.qtpl:7
+//line app/vmselect/loki/util.qtpl:8
 	qw422016.N().S(`{`)
-//line app/vmselect/loki/util.qtpl:9
+//line app/vmselect/loki/util.qtpl:10
 	if len(mn.MetricGroup) > 0 {
-//line app/vmselect/loki/util.qtpl:9
-		qw422016.N().S(`"__name__":`)
 //line app/vmselect/loki/util.qtpl:10
+		qw422016.N().S(`"__name__":`)
+//line app/vmselect/loki/util.qtpl:11
 		qw422016.N().QZ(mn.MetricGroup)
-//line app/vmselect/loki/util.qtpl:10
+//line app/vmselect/loki/util.qtpl:11
 		if len(mn.Tags) > 0 {
-//line app/vmselect/loki/util.qtpl:10
+//line app/vmselect/loki/util.qtpl:11
 			qw422016.N().S(`,`)
-//line app/vmselect/loki/util.qtpl:10
-		}
 //line app/vmselect/loki/util.qtpl:11
-	}
+		}
 //line app/vmselect/loki/util.qtpl:12
-	for j := range mn.Tags {
+	}
 //line app/vmselect/loki/util.qtpl:13
+	for j := range mn.Tags {
+//line app/vmselect/loki/util.qtpl:14
 		tag := &mn.Tags[j]
 
-//line app/vmselect/loki/util.qtpl:14
+//line app/vmselect/loki/util.qtpl:15
 		qw422016.N().QZ(tag.Key)
-//line app/vmselect/loki/util.qtpl:14
+//line app/vmselect/loki/util.qtpl:15
 		qw422016.N().S(`:`)
-//line app/vmselect/loki/util.qtpl:14
+//line app/vmselect/loki/util.qtpl:15
 		qw422016.N().QZ(tag.Value)
-//line app/vmselect/loki/util.qtpl:14
+//line app/vmselect/loki/util.qtpl:15
 		if j+1 < len(mn.Tags) {
-//line app/vmselect/loki/util.qtpl:14
+//line app/vmselect/loki/util.qtpl:15
 			qw422016.N().S(`,`)
-//line app/vmselect/loki/util.qtpl:14
-		}
 //line app/vmselect/loki/util.qtpl:15
+		}
+//line app/vmselect/loki/util.qtpl:16
 	}
-//line app/vmselect/loki/util.qtpl:15
+//line app/vmselect/loki/util.qtpl:16
 	qw422016.N().S(`}`)
-//line app/vmselect/loki/util.qtpl:17
+//line app/vmselect/loki/util.qtpl:18
 }
 
-//line app/vmselect/loki/util.qtpl:17
+//line app/vmselect/loki/util.qtpl:18
 func writemetricNameObject(qq422016 qtio422016.Writer, mn *storage.MetricName) {
-//line app/vmselect/loki/util.qtpl:17
+//line app/vmselect/loki/util.qtpl:18
 	qw422016 := qt422016.AcquireWriter(qq422016)
-//line app/vmselect/loki/util.qtpl:17
+//line app/vmselect/loki/util.qtpl:18
 	streammetricNameObject(qw422016, mn)
-//line app/vmselect/loki/util.qtpl:17
+//line app/vmselect/loki/util.qtpl:18
 	qt422016.ReleaseWriter(qw422016)
-//line app/vmselect/loki/util.qtpl:17
+//line app/vmselect/loki/util.qtpl:18
 }
 
-//line app/vmselect/loki/util.qtpl:17
+//line app/vmselect/loki/util.qtpl:18
 func metricNameObject(mn *storage.MetricName) string {
-//line app/vmselect/loki/util.qtpl:17
+//line app/vmselect/loki/util.qtpl:18
 	qb422016 := qt422016.AcquireByteBuffer()
-//line app/vmselect/loki/util.qtpl:17
+//line app/vmselect/loki/util.qtpl:18
 	writemetricNameObject(qb422016, mn)
-//line app/vmselect/loki/util.qtpl:17
+//line app/vmselect/loki/util.qtpl:18
 	qs422016 := string(qb422016.B)
-//line app/vmselect/loki/util.qtpl:17
+//line app/vmselect/loki/util.qtpl:18
 	qt422016.ReleaseByteBuffer(qb422016)
-//line app/vmselect/loki/util.qtpl:17
+//line app/vmselect/loki/util.qtpl:18
 	return qs422016
-//line app/vmselect/loki/util.qtpl:17
+//line app/vmselect/loki/util.qtpl:18
 }
 
-//line app/vmselect/loki/util.qtpl:19
-func streamvaluesWithTimestamps(qw422016 *qt422016.Writer, values []float64, timestamps []int64) {
 //line app/vmselect/loki/util.qtpl:20
+func streamvaluesWithTimestamps(qw422016 *qt422016.Writer, values []float64, timestamps []int64) {
+//line app/vmselect/loki/util.qtpl:21
 	if len(values) == 0 {
-//line app/vmselect/loki/util.qtpl:20
+//line app/vmselect/loki/util.qtpl:21
 		qw422016.N().S(`[]`)
-//line app/vmselect/loki/util.qtpl:22
-		return
 //line app/vmselect/loki/util.qtpl:23
+		return
+//line app/vmselect/loki/util.qtpl:24
 	}
-//line app/vmselect/loki/util.qtpl:23
+//line app/vmselect/loki/util.qtpl:24
 	qw422016.N().S(`[`)
-//line app/vmselect/loki/util.qtpl:25
+//line app/vmselect/loki/util.qtpl:26
 	/* inline metricRow call here for the sake of performance optimization */
 
-//line app/vmselect/loki/util.qtpl:25
-	qw422016.N().S(`[`)
 //line app/vmselect/loki/util.qtpl:26
+	qw422016.N().S(`[`)
+//line app/vmselect/loki/util.qtpl:27
 	qw422016.N().F(float64(timestamps[0]) / 1e3)
-//line app/vmselect/loki/util.qtpl:26
+//line app/vmselect/loki/util.qtpl:27
 	qw422016.N().S(`,"`)
-//line app/vmselect/loki/util.qtpl:26
+//line app/vmselect/loki/util.qtpl:27
 	qw422016.N().F(values[0])
-//line app/vmselect/loki/util.qtpl:26
+//line app/vmselect/loki/util.qtpl:27
 	qw422016.N().S(`"]`)
-//line app/vmselect/loki/util.qtpl:28
+//line app/vmselect/loki/util.qtpl:29
 	timestamps = timestamps[1:]
 	values = values[1:]
 
-//line app/vmselect/loki/util.qtpl:31
+//line app/vmselect/loki/util.qtpl:32
 	if len(values) > 0 {
-//line app/vmselect/loki/util.qtpl:33
+//line app/vmselect/loki/util.qtpl:34
 		// Remove bounds check inside the loop below
 		_ = timestamps[len(values)-1]
 
-//line app/vmselect/loki/util.qtpl:36
-		for i, v := range values {
 //line app/vmselect/loki/util.qtpl:37
+		for i, v := range values {
+//line app/vmselect/loki/util.qtpl:38
 			/* inline metricRow call here for the sake of performance optimization */
 
-//line app/vmselect/loki/util.qtpl:37
-			qw422016.N().S(`,[`)
 //line app/vmselect/loki/util.qtpl:38
+			qw422016.N().S(`,[`)
+//line app/vmselect/loki/util.qtpl:39
 			qw422016.N().F(float64(timestamps[i]) / 1e3)
-//line app/vmselect/loki/util.qtpl:38
+//line app/vmselect/loki/util.qtpl:39
 			qw422016.N().S(`,"`)
-//line app/vmselect/loki/util.qtpl:38
+//line app/vmselect/loki/util.qtpl:39
 			qw422016.N().F(v)
-//line app/vmselect/loki/util.qtpl:38
-			qw422016.N().S(`"]`)
 //line app/vmselect/loki/util.qtpl:39
-		}
+			qw422016.N().S(`"]`)
 //line app/vmselect/loki/util.qtpl:40
+		}
+//line app/vmselect/loki/util.qtpl:41
 	}
-//line app/vmselect/loki/util.qtpl:40
+//line app/vmselect/loki/util.qtpl:41
 	qw422016.N().S(`]`)
-//line app/vmselect/loki/util.qtpl:42
+//line app/vmselect/loki/util.qtpl:43
 }
 
-//line app/vmselect/loki/util.qtpl:42
+//line app/vmselect/loki/util.qtpl:43
 func writevaluesWithTimestamps(qq422016 qtio422016.Writer, values []float64, timestamps []int64) {
-//line app/vmselect/loki/util.qtpl:42
+//line app/vmselect/loki/util.qtpl:43
 	qw422016 := qt422016.AcquireWriter(qq422016)
-//line app/vmselect/loki/util.qtpl:42
+//line app/vmselect/loki/util.qtpl:43
 	streamvaluesWithTimestamps(qw422016, values, timestamps)
-//line app/vmselect/loki/util.qtpl:42
+//line app/vmselect/loki/util.qtpl:43
 	qt422016.ReleaseWriter(qw422016)
-//line app/vmselect/loki/util.qtpl:42
+//line app/vmselect/loki/util.qtpl:43
 }
 
-//line app/vmselect/loki/util.qtpl:42
+//line app/vmselect/loki/util.qtpl:43
 func valuesWithTimestamps(values []float64, timestamps []int64) string {
-//line app/vmselect/loki/util.qtpl:42
+//line app/vmselect/loki/util.qtpl:43
 	qb422016 := qt422016.AcquireByteBuffer()
-//line app/vmselect/loki/util.qtpl:42
+//line app/vmselect/loki/util.qtpl:43
 	writevaluesWithTimestamps(qb422016, values, timestamps)
-//line app/vmselect/loki/util.qtpl:42
+//line app/vmselect/loki/util.qtpl:43
 	qs422016 := string(qb422016.B)
-//line app/vmselect/loki/util.qtpl:42
+//line app/vmselect/loki/util.qtpl:43
 	qt422016.ReleaseByteBuffer(qb422016)
-//line app/vmselect/loki/util.qtpl:42
+//line app/vmselect/loki/util.qtpl:43
 	return qs422016
-//line app/vmselect/loki/util.qtpl:42
+//line app/vmselect/loki/util.qtpl:43
 }
 
-//line app/vmselect/loki/util.qtpl:44
-func streamdatasWithTimestamps(qw422016 *qt422016.Writer, values [][]byte, timestamps []int64) {
 //line app/vmselect/loki/util.qtpl:45
+func streamdatasWithTimestamps(qw422016 *qt422016.Writer, values [][]byte, timestamps []int64) {
+//line app/vmselect/loki/util.qtpl:46
 	if len(values) == 0 {
-//line app/vmselect/loki/util.qtpl:45
+//line app/vmselect/loki/util.qtpl:46
 		qw422016.N().S(`[]`)
-//line app/vmselect/loki/util.qtpl:47
-		return
 //line app/vmselect/loki/util.qtpl:48
+		return
+//line app/vmselect/loki/util.qtpl:49
 	}
-//line app/vmselect/loki/util.qtpl:48
+//line app/vmselect/loki/util.qtpl:49
 	qw422016.N().S(`[`)
-//line app/vmselect/loki/util.qtpl:50
-	/* inline metricRow call here for the sake of performance optimization */
-
-//line app/vmselect/loki/util.qtpl:50
-	qw422016.N().S(`["`)
-//line app/vmselect/loki/util.qtpl:51
-	qw422016.N().DL(timestamps[0] * 1e6)
 //line app/vmselect/loki/util.qtpl:51
-	qw422016.N().S(`",`)
-//line app/vmselect/loki/util.qtpl:51
-	qw422016.N().QZ(values[0])
-//line app/vmselect/loki/util.qtpl:51
-	qw422016.N().S(`]`)
+	streamlineWithTimestamp(qw422016, values[0], timestamps[0])
 //line app/vmselect/loki/util.qtpl:53
 	timestamps = timestamps[1:]
 	values = values[1:]
@@ -212,50 +202,167 @@ func streamdatasWithTimestamps(qw422016 *qt422016.Writer, values [][]byte, times
 
 //line app/vmselect/loki/util.qtpl:61
 		for i, v := range values {
+//line app/vmselect/loki/util.qtpl:61
+			qw422016.N().S(`,`)
 //line app/vmselect/loki/util.qtpl:62
-			/* inline metricRow call here for the sake of performance optimization */
-
-//line app/vmselect/loki/util.qtpl:62
-			qw422016.N().S(`,["`)
-//line app/vmselect/loki/util.qtpl:63
-			qw422016.N().DL(timestamps[i] * 1e6)
-//line app/vmselect/loki/util.qtpl:63
-			qw422016.N().S(`",`)
+			streamlineWithTimestamp(qw422016, v, timestamps[i])
 //line app/vmselect/loki/util.qtpl:63
-			qw422016.N().QZ(v)
-//line app/vmselect/loki/util.qtpl:63
-			qw422016.N().S(`]`)
-//line app/vmselect/loki/util.qtpl:64
 		}
-//line app/vmselect/loki/util.qtpl:65
+//line app/vmselect/loki/util.qtpl:64
 	}
-//line app/vmselect/loki/util.qtpl:65
+//line app/vmselect/loki/util.qtpl:64
 	qw422016.N().S(`]`)
-//line app/vmselect/loki/util.qtpl:67
+//line app/vmselect/loki/util.qtpl:66
 }
 
-//line app/vmselect/loki/util.qtpl:67
+//line app/vmselect/loki/util.qtpl:66
 func writedatasWithTimestamps(qq422016 qtio422016.Writer, values [][]byte, timestamps []int64) {
-//line app/vmselect/loki/util.qtpl:67
+//line app/vmselect/loki/util.qtpl:66
 	qw422016 := qt422016.AcquireWriter(qq422016)
-//line app/vmselect/loki/util.qtpl:67
+//line app/vmselect/loki/util.qtpl:66
 	streamdatasWithTimestamps(qw422016, values, timestamps)
-//line app/vmselect/loki/util.qtpl:67
+//line app/vmselect/loki/util.qtpl:66
 	qt422016.ReleaseWriter(qw422016)
-//line app/vmselect/loki/util.qtpl:67
+//line app/vmselect/loki/util.qtpl:66
 }
 
-//line app/vmselect/loki/util.qtpl:67
+//line app/vmselect/loki/util.qtpl:66
 func datasWithTimestamps(values [][]byte, timestamps []int64) string {
-//line app/vmselect/loki/util.qtpl:67
+//line app/vmselect/loki/util.qtpl:66
 	qb422016 := qt422016.AcquireByteBuffer()
-//line app/vmselect/loki/util.qtpl:67
+//line app/vmselect/loki/util.qtpl:66
 	writedatasWithTimestamps(qb422016, values, timestamps)
-//line app/vmselect/loki/util.qtpl:67
+//line app/vmselect/loki/util.qtpl:66
+	qs422016 := string(qb422016.B)
+//line app/vmselect/loki/util.qtpl:66
+	qt422016.ReleaseByteBuffer(qb422016)
+//line app/vmselect/loki/util.qtpl:66
+	return qs422016
+//line app/vmselect/loki/util.qtpl:66
+}
+
+//line app/vmselect/loki/util.qtpl:68
+func streamlineWithTimestamp(qw422016 *qt422016.Writer, value []byte, timestamp int64) {
+//line app/vmselect/loki/util.qtpl:69
+	line, metadata, err := storage.UnmarshalLineWithMetadata(nil, value)
+
+//line app/vmselect/loki/util.qtpl:70
+	if err != nil {
+//line app/vmselect/loki/util.qtpl:72
+		line = value
+		metadata = nil
+
+//line app/vmselect/loki/util.qtpl:75
+	}
+//line app/vmselect/loki/util.qtpl:75
+	qw422016.N().S(`["`)
+//line app/vmselect/loki/util.qtpl:76
+	qw422016.N().DL(timestamp * 1e6)
+//line app/vmselect/loki/util.qtpl:76
+	qw422016.N().S(`",`)
+//line app/vmselect/loki/util.qtpl:76
+	qw422016.N().QZ(line)
+//line app/vmselect/loki/util.qtpl:77
+	if len(metadata) > 0 {
+//line app/vmselect/loki/util.qtpl:77
+		qw422016.N().S(`,{"structuredMetadata":{`)
+//line app/vmselect/loki/util.qtpl:79
+		for j := range metadata {
+//line app/vmselect/loki/util.qtpl:80
+			label := &metadata[j]
+
+//line app/vmselect/loki/util.qtpl:81
+			qw422016.N().QZ(label.Name)
+//line app/vmselect/loki/util.qtpl:81
+			qw422016.N().S(`:`)
+//line app/vmselect/loki/util.qtpl:81
+			qw422016.N().QZ(label.Value)
+//line app/vmselect/loki/util.qtpl:81
+			if j+1 < len(metadata) {
+//line app/vmselect/loki/util.qtpl:81
+				qw422016.N().S(`,`)
+//line app/vmselect/loki/util.qtpl:81
+			}
+//line app/vmselect/loki/util.qtpl:82
+		}
+//line app/vmselect/loki/util.qtpl:82
+		qw422016.N().S(`}}`)
+//line app/vmselect/loki/util.qtpl:84
+	}
+//line app/vmselect/loki/util.qtpl:84
+	qw422016.N().S(`]`)
+//line app/vmselect/loki/util.qtpl:86
+}
+
+//line app/vmselect/loki/util.qtpl:86
+func writelineWithTimestamp(qq422016 qtio422016.Writer, value []byte, timestamp int64) {
+//line app/vmselect/loki/util.qtpl:86
+	qw422016 := qt422016.AcquireWriter(qq422016)
+//line app/vmselect/loki/util.qtpl:86
+	streamlineWithTimestamp(qw422016, value, timestamp)
+//line app/vmselect/loki/util.qtpl:86
+	qt422016.ReleaseWriter(qw422016)
+//line app/vmselect/loki/util.qtpl:86
+}
+
+//line app/vmselect/loki/util.qtpl:86
+func lineWithTimestamp(value []byte, timestamp int64) string {
+//line app/vmselect/loki/util.qtpl:86
+	qb422016 := qt422016.AcquireByteBuffer()
+//line app/vmselect/loki/util.qtpl:86
+	writelineWithTimestamp(qb422016, value, timestamp)
+//line app/vmselect/loki/util.qtpl:86
+	qs422016 := string(qb422016.B)
+//line app/vmselect/loki/util.qtpl:86
+	qt422016.ReleaseByteBuffer(qb422016)
+//line app/vmselect/loki/util.qtpl:86
+	return qs422016
+//line app/vmselect/loki/util.qtpl:86
+}
+
+// dumpQueryTrace finishes qt and writes its JSON representation if query tracing is enabled via `trace=1` arg.
+
+//line app/vmselect/loki/util.qtpl:89
+func streamdumpQueryTrace(qw422016 *qt422016.Writer, qt *querytracer.Tracer) {
+//line app/vmselect/loki/util.qtpl:90
+	qt.Done()
+
+//line app/vmselect/loki/util.qtpl:91
+	traceJSON := qt.ToJSON()
+
+//line app/vmselect/loki/util.qtpl:92
+	if traceJSON != "" {
+//line app/vmselect/loki/util.qtpl:92
+		qw422016.N().S(`,"trace":`)
+//line app/vmselect/loki/util.qtpl:93
+		qw422016.N().S(traceJSON)
+//line app/vmselect/loki/util.qtpl:94
+	}
+//line app/vmselect/loki/util.qtpl:95
+}
+
+//line app/vmselect/loki/util.qtpl:95
+func writedumpQueryTrace(qq422016 qtio422016.Writer, qt *querytracer.Tracer) {
+//line app/vmselect/loki/util.qtpl:95
+	qw422016 := qt422016.AcquireWriter(qq422016)
+//line app/vmselect/loki/util.qtpl:95
+	streamdumpQueryTrace(qw422016, qt)
+//line app/vmselect/loki/util.qtpl:95
+	qt422016.ReleaseWriter(qw422016)
+//line app/vmselect/loki/util.qtpl:95
+}
+
+//line app/vmselect/loki/util.qtpl:95
+func dumpQueryTrace(qt *querytracer.Tracer) string {
+//line app/vmselect/loki/util.qtpl:95
+	qb422016 := qt422016.AcquireByteBuffer()
+//line app/vmselect/loki/util.qtpl:95
+	writedumpQueryTrace(qb422016, qt)
+//line app/vmselect/loki/util.qtpl:95
 	qs422016 := string(qb422016.B)
-//line app/vmselect/loki/util.qtpl:67
+//line app/vmselect/loki/util.qtpl:95
 	qt422016.ReleaseByteBuffer(qb422016)
-//line app/vmselect/loki/util.qtpl:67
+//line app/vmselect/loki/util.qtpl:95
 	return qs422016
-//line app/vmselect/loki/util.qtpl:67
+//line app/vmselect/loki/util.qtpl:95
 }
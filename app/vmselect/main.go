@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/subtle"
 	"errors"
 	"flag"
 	"fmt"
@@ -13,8 +14,13 @@ import (
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/loki"
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/querier"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/scheduler"
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/searchutils"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/clustertls"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/httpauth"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/overrides"
 	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/tenant"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/buildinfo"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/cgroup"
@@ -24,7 +30,6 @@ import (
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/procutil"
-	"github.com/VictoriaMetrics/VictoriaMetrics/lib/timerpool"
 	"github.com/VictoriaMetrics/metrics"
 )
 
@@ -35,9 +40,15 @@ var (
 		"It shouldn't be high, since a single request can saturate all the CPU cores. See also -search.maxQueueDuration")
 	maxQueueDuration = flag.Duration("search.maxQueueDuration", 10*time.Second, "The maximum time the request waits for execution when -search.maxConcurrentRequests "+
 		"limit is reached; see also -search.maxQueryDuration")
+	maxQueuedRequestsPerTenant = flag.Int("search.maxQueuedRequestsPerTenant", 100, "The maximum number of requests per tenant waiting for execution "+
+		"when -search.maxConcurrentRequests limit is reached. Queued requests are executed in round-robin order over tenants. "+
+		"It may be overridden per tenant with max_queued_requests in -overrides.config")
 	minScrapeInterval = flag.Duration("dedup.minScrapeInterval", 0, "Remove superflouos samples from time series if they are located closer to each other than this duration. "+
 		"This may be useful for reducing overhead when multiple identically configured Prometheus instances write data to the same VictoriaMetrics. "+
 		"Deduplication is disabled if the -dedup.minScrapeInterval is 0")
+	crossTenantAuthKey = flag.String("search.crossTenantAuthKey", "", "authKey, which must be passed in query string to /select/multitenant/loki/api/v1/{query,query_range,tail}. "+
+		"These endpoints execute the query over the tenants from `tenant` query args or over all the tenants and add __tenant__ label to the results. "+
+		"Cross-tenant queries are disabled if the flag is empty")
 	storageNodes = flagutil.NewArray("storageNode", "Addresses of vmstorage nodes; usage: -storageNode=vmstorage-host1:8401 -storageNode=vmstorage-host2:8401")
 )
 
@@ -69,6 +80,7 @@ func main() {
 	if len(*storageNodes) == 0 {
 		logger.Fatalf("missing -storageNode arg")
 	}
+	clustertls.Init()
 	netstorage.InitStorageNodes(*storageNodes)
 	logger.Infof("started netstorage in %.3f seconds", time.Since(startTime).Seconds())
 
@@ -79,7 +91,10 @@ func main() {
 	} else {
 		netstorage.InitTmpBlocksDir("")
 	}
-	concurrencyCh = make(chan struct{}, *maxConcurrentRequests)
+	querySched = scheduler.New(*maxConcurrentRequests)
+	tenant.Init()
+	httpauth.Init()
+	overrides.Init()
 
 	go func() {
 		httpserver.Serve(*httpListenAddr, requestHandler)
@@ -106,17 +121,20 @@ func main() {
 	logger.Infof("the vmselect has been stopped")
 }
 
-var concurrencyCh chan struct{}
+var querySched *scheduler.Scheduler
 
 var (
-	concurrencyLimitReached = metrics.NewCounter(`vm_concurrent_select_limit_reached_total`)
-	concurrencyLimitTimeout = metrics.NewCounter(`vm_concurrent_select_limit_timeout_total`)
+	concurrencyLimitTimeout   = metrics.NewCounter(`vm_concurrent_select_limit_timeout_total`)
+	concurrencyLimitQueueFull = metrics.NewCounter(`vm_concurrent_select_queue_full_total`)
 
 	_ = metrics.NewGauge(`vm_concurrent_select_capacity`, func() float64 {
-		return float64(cap(concurrencyCh))
+		return float64(querySched.Capacity())
 	})
 	_ = metrics.NewGauge(`vm_concurrent_select_current`, func() float64 {
-		return float64(len(concurrencyCh))
+		return float64(querySched.Running())
+	})
+	_ = metrics.NewGauge(`vm_concurrent_select_queued`, func() float64 {
+		return float64(querySched.Queued())
 	})
 )
 
@@ -126,44 +144,30 @@ func requestHandler(w http.ResponseWriter, r *http.Request) bool {
 		return true
 	}
 	startTime := time.Now()
-	// Limit the number of concurrent queries.
-	select {
-	case concurrencyCh <- struct{}{}:
-		defer func() { <-concurrencyCh }()
-	default:
-		// Sleep for a while until giving up. This should resolve short bursts in requests.
-		concurrencyLimitReached.Inc()
-		d := searchutils.GetMaxQueryDuration(r)
-		if d > *maxQueueDuration {
-			d = *maxQueueDuration
-		}
-		t := timerpool.Get(d)
-		select {
-		case concurrencyCh <- struct{}{}:
-			timerpool.Put(t)
-			defer func() { <-concurrencyCh }()
-		case <-t.C:
-			timerpool.Put(t)
-			concurrencyLimitTimeout.Inc()
-			err := &httpserver.ErrorWithStatusCode{
-				Err: fmt.Errorf("cannot handle more than %d concurrent search requests during %s; possible solutions: "+
-					"increase `-search.maxQueueDuration`; increase `-search.maxQueryDuration`; increase `-search.maxConcurrentRequests`; "+
-					"increase server capacity",
-					*maxConcurrentRequests, d),
-				StatusCode: http.StatusServiceUnavailable,
-			}
-			httpserver.Errorf(w, r, "%s", err)
+	path := strings.Replace(r.URL.Path, "//", "/", -1)
+
+	if strings.HasPrefix(path, "/loki/") {
+		// Loki path without tenant in it. The tenant is read from X-Scope-OrgID header.
+		tenants, err := tenant.GetTenants(r)
+		if err != nil {
+			sendPrometheusError(w, r, err)
 			return true
 		}
+		p := &httpserver.Path{
+			Prefix: "select",
+			Suffix: path[1:],
+		}
+		return selectHandler(startTime, w, r, p, tenants[0].AuthToken, tenants, "")
 	}
 
-	path := strings.Replace(r.URL.Path, "//", "/", -1)
-
 	p, err := httpserver.ParsePath(path)
 	if err != nil {
 		httpserver.Errorf(w, r, "cannot parse path %q: %s", path, err)
 		return true
 	}
+	if p.Prefix == "select" && p.AuthToken == "multitenant" {
+		return crossTenantHandler(startTime, w, r, p)
+	}
 	at, err := auth.NewToken(p.AuthToken)
 	if err != nil {
 		httpserver.Errorf(w, r, "auth error: %s", err)
@@ -171,7 +175,7 @@ func requestHandler(w http.ResponseWriter, r *http.Request) bool {
 	}
 	switch p.Prefix {
 	case "select":
-		return selectHandler(startTime, w, r, p, at)
+		return selectHandler(startTime, w, r, p, at, nil, "")
 	case "delete":
 		return deleteHandler(startTime, w, r, p, at)
 	default:
@@ -180,7 +184,80 @@ func requestHandler(w http.ResponseWriter, r *http.Request) bool {
 	}
 }
 
-func selectHandler(startTime time.Time, w http.ResponseWriter, r *http.Request, p *httpserver.Path, at *auth.Token) bool {
+// multiTenantPaths contains paths, which may be executed over multiple tenants.
+var multiTenantPaths = map[string]bool{
+	"loki/api/v1/query":       true,
+	"loki/api/v1/query_range": true,
+	"loki/api/v1/tail":        true,
+}
+
+// crossTenantHandler processes /select/multitenant/ requests, which are executed over multiple tenants.
+//
+// Tenants are passed via `tenant` args. All the tenants are queried if `tenant` args are missing.
+func crossTenantHandler(startTime time.Time, w http.ResponseWriter, r *http.Request, p *httpserver.Path) bool {
+	if !multiTenantPaths[p.Suffix] {
+		// This is not our link
+		return false
+	}
+	if len(*crossTenantAuthKey) == 0 {
+		err := &httpserver.ErrorWithStatusCode{
+			Err:        fmt.Errorf("cross-tenant queries are disabled; set -search.crossTenantAuthKey command-line flag in order to enable them"),
+			StatusCode: http.StatusForbidden,
+		}
+		sendPrometheusError(w, r, err)
+		return true
+	}
+	if subtle.ConstantTimeCompare([]byte(r.FormValue("authKey")), []byte(*crossTenantAuthKey)) != 1 {
+		err := &httpserver.ErrorWithStatusCode{
+			Err:        fmt.Errorf("the provided authKey doesn't match -search.crossTenantAuthKey"),
+			StatusCode: http.StatusUnauthorized,
+		}
+		sendPrometheusError(w, r, err)
+		return true
+	}
+	crossTenantRequests.Inc()
+	tenants, err := loki.GetCrossTenants(startTime, r)
+	if err != nil {
+		crossTenantErrors.Inc()
+		sendPrometheusError(w, r, err)
+		return true
+	}
+	var at auth.Token
+	if len(tenants) > 0 {
+		at = *tenants[0].AuthToken
+	}
+	return selectHandler(startTime, w, r, p, &at, tenants, tenant.CrossTenantLabelName)
+}
+
+func selectHandler(startTime time.Time, w http.ResponseWriter, r *http.Request, p *httpserver.Path, at *auth.Token,
+	tenants []*tenant.Tenant, tenantLabel string) bool {
+	if len(tenants) > 1 && !multiTenantPaths[p.Suffix] {
+		err := &httpserver.ErrorWithStatusCode{
+			Err:        fmt.Errorf("multiple tenants in %s header aren't supported for %q", tenant.OrgIDHeader, r.URL.Path),
+			StatusCode: http.StatusBadRequest,
+		}
+		sendPrometheusError(w, r, err)
+		return true
+	}
+	if err := authorizeSelect(r, p, at, tenants); err != nil {
+		sendPrometheusError(w, r, err)
+		return true
+	}
+	r, err := withStreamFilter(r, p)
+	if err != nil {
+		sendPrometheusError(w, r, err)
+		return true
+	}
+	if !schedulerExemptPaths[p.Suffix] {
+		queueStartTime := time.Now()
+		release, err := acquireSchedulerSlot(r, at)
+		if err != nil {
+			sendPrometheusError(w, r, err)
+			return true
+		}
+		defer release()
+		r = searchutils.WithQueueDuration(r, time.Since(queueStartTime))
+	}
 	if strings.HasPrefix(p.Suffix, "loki/api/v1/label/") {
 		s := p.Suffix[len("loki/api/v1/label/"):]
 		if strings.HasSuffix(s, "/values") {
@@ -200,7 +277,7 @@ func selectHandler(startTime time.Time, w http.ResponseWriter, r *http.Request,
 	case "loki/api/v1/query":
 		queryRequests.Inc()
 		httpserver.EnableCORS(w, r)
-		if err := loki.QueryHandler(startTime, at, w, r); err != nil {
+		if err := loki.QueryHandler(startTime, at, tenants, tenantLabel, w, r); err != nil {
 			queryErrors.Inc()
 			sendPrometheusError(w, r, err)
 			return true
@@ -209,7 +286,7 @@ func selectHandler(startTime time.Time, w http.ResponseWriter, r *http.Request,
 	case "loki/api/v1/query_range":
 		queryRangeRequests.Inc()
 		httpserver.EnableCORS(w, r)
-		if err := loki.QueryRangeHandler(startTime, at, w, r); err != nil {
+		if err := loki.QueryRangeHandler(startTime, at, tenants, tenantLabel, w, r); err != nil {
 			queryRangeErrors.Inc()
 			sendPrometheusError(w, r, err)
 			return true
@@ -218,7 +295,7 @@ func selectHandler(startTime time.Time, w http.ResponseWriter, r *http.Request,
 	case "loki/api/v1/tail":
 		tailRequests.Inc()
 		httpserver.EnableCORS(w, r)
-		if err := loki.TailHandler(startTime, at, w, r); err != nil {
+		if err := loki.TailHandler(startTime, at, tenants, tenantLabel, w, r); err != nil {
 			tailErrors.Inc()
 			sendPrometheusError(w, r, err)
 			return true
@@ -260,6 +337,51 @@ func selectHandler(startTime time.Time, w http.ResponseWriter, r *http.Request,
 			return true
 		}
 		return true
+	case "loki/api/v1/index/stats":
+		indexStatsRequests.Inc()
+		httpserver.EnableCORS(w, r)
+		if err := loki.IndexStatsHandler(startTime, at, w, r); err != nil {
+			indexStatsErrors.Inc()
+			sendPrometheusError(w, r, err)
+			return true
+		}
+		return true
+	case "loki/api/v1/index/volume":
+		indexVolumeRequests.Inc()
+		httpserver.EnableCORS(w, r)
+		if err := loki.IndexVolumeHandler(startTime, at, w, r); err != nil {
+			indexVolumeErrors.Inc()
+			sendPrometheusError(w, r, err)
+			return true
+		}
+		return true
+	case "loki/api/v1/patterns":
+		patternsRequests.Inc()
+		httpserver.EnableCORS(w, r)
+		if err := loki.PatternsHandler(startTime, at, w, r); err != nil {
+			patternsErrors.Inc()
+			sendPrometheusError(w, r, err)
+			return true
+		}
+		return true
+	case "loki/api/v1/detected_fields":
+		detectedFieldsRequests.Inc()
+		httpserver.EnableCORS(w, r)
+		if err := loki.DetectedFieldsHandler(startTime, at, w, r); err != nil {
+			detectedFieldsErrors.Inc()
+			sendPrometheusError(w, r, err)
+			return true
+		}
+		return true
+	case "loki/api/v1/detected_labels":
+		detectedLabelsRequests.Inc()
+		httpserver.EnableCORS(w, r)
+		if err := loki.DetectedLabelsHandler(startTime, at, w, r); err != nil {
+			detectedLabelsErrors.Inc()
+			sendPrometheusError(w, r, err)
+			return true
+		}
+		return true
 	case "loki/api/v1/status/tsdb":
 		statusTSDBRequests.Inc()
 		if err := loki.TSDBStatusHandler(startTime, at, w, r); err != nil {
@@ -270,7 +392,15 @@ func selectHandler(startTime time.Time, w http.ResponseWriter, r *http.Request,
 		return true
 	case "loki/api/v1/status/active_queries":
 		statusActiveQueriesRequests.Inc()
-		querier.WriteActiveQueries(w)
+		querier.WriteActiveQueries(w, at)
+		return true
+	case "loki/api/v1/status/active_queries/cancel":
+		cancelActiveQueryRequests.Inc()
+		if err := loki.CancelActiveQueryHandler(at, w, r); err != nil {
+			cancelActiveQueryErrors.Inc()
+			sendPrometheusError(w, r, err)
+			return true
+		}
 		return true
 	case "loki/api/v1/export":
 		exportRequests.Inc()
@@ -301,9 +431,123 @@ func selectHandler(startTime time.Time, w http.ResponseWriter, r *http.Request,
 	}
 }
 
+// authorizeSelect verifies whether r is allowed for the given tenants according to -auth.config.
+func authorizeSelect(r *http.Request, p *httpserver.Path, at *auth.Token, tenants []*tenant.Tenant) error {
+	ats := []*auth.Token{at}
+	if len(tenants) > 0 {
+		ats = ats[:0]
+		for _, t := range tenants {
+			ats = append(ats, t.AuthToken)
+		}
+	}
+	op := httpauth.OpQuery
+	switch p.Suffix {
+	case "loki/api/v1/tail":
+		op = httpauth.OpTail
+	case "loki/api/v1/export", "loki/api/v1/export/native", "loki/federate":
+		op = httpauth.OpExport
+	}
+	return httpauth.Authorize(r, op, ats)
+}
+
+// schedulerExemptPaths contains paths, which aren't limited by -search.maxConcurrentRequests.
+//
+// These paths must remain available when all the concurrency slots are occupied by heavy queries,
+// so the queries could be inspected and canceled.
+var schedulerExemptPaths = map[string]bool{
+	"loki/api/v1/status/active_queries":        true,
+	"loki/api/v1/status/active_queries/cancel": true,
+}
+
+// acquireSchedulerSlot waits until the request for at may be executed according to -search.maxConcurrentRequests.
+//
+// Requests exceeding the limit are queued per tenant and are started in weighted round-robin order over tenants.
+// The returned func must be called when the request is finished.
+func acquireSchedulerSlot(r *http.Request, at *auth.Token) (func(), error) {
+	limits := overrides.GetLimits(at)
+	maxQueueLen := *maxQueuedRequestsPerTenant
+	if limits.MaxQueuedRequests > 0 {
+		maxQueueLen = limits.MaxQueuedRequests
+	}
+	d := searchutils.GetMaxQueryDuration(r)
+	if d > *maxQueueDuration {
+		d = *maxQueueDuration
+	}
+	tenantID := fmt.Sprintf("%d:%d", at.AccountID, at.ProjectID)
+	err := querySched.Acquire(tenantID, limits.SchedulerWeight, maxQueueLen, d)
+	switch err {
+	case nil:
+		return querySched.Release, nil
+	case scheduler.ErrQueueFull:
+		concurrencyLimitQueueFull.Inc()
+		return nil, &httpserver.ErrorWithStatusCode{
+			Err: fmt.Errorf("cannot queue more than %d search requests for tenant %s; possible solutions: "+
+				"increase `-search.maxQueuedRequestsPerTenant` or `max_queued_requests` in `-overrides.config`; increase `-search.maxConcurrentRequests`; "+
+				"increase server capacity", maxQueueLen, tenantID),
+			StatusCode: http.StatusTooManyRequests,
+		}
+	default:
+		concurrencyLimitTimeout.Inc()
+		return nil, &httpserver.ErrorWithStatusCode{
+			Err: fmt.Errorf("cannot handle more than %d concurrent search requests during %s; possible solutions: "+
+				"increase `-search.maxQueueDuration`; increase `-search.maxQueryDuration`; increase `-search.maxConcurrentRequests`; "+
+				"increase server capacity",
+				*maxConcurrentRequests, d),
+			StatusCode: http.StatusServiceUnavailable,
+		}
+	}
+}
+
+// streamFilterDeniedPaths contains paths, which cannot be limited to streams from stream_filter of -auth.config users.
+//
+// These paths expose stats or queries for all the tenant streams, so they are denied for users with stream_filter.
+var streamFilterDeniedPaths = map[string]bool{
+	"loki/api/v1/series/count":                 true,
+	"loki/api/v1/labels/count":                 true,
+	"loki/api/v1/status/tsdb":                  true,
+	"loki/api/v1/status/active_queries":        true,
+	"loki/api/v1/status/active_queries/cancel": true,
+}
+
+// withStreamFilter returns r with enforced tag filters from stream_filter of the -auth.config user for r.
+//
+// See searchutils.GetEnforcedTagFilters.
+func withStreamFilter(r *http.Request, p *httpserver.Path) (*http.Request, error) {
+	sf := httpauth.GetStreamFilter(r)
+	if len(sf) == 0 {
+		return r, nil
+	}
+	if streamFilterDeniedPaths[p.Suffix] {
+		return r, &httpserver.ErrorWithStatusCode{
+			Err:        fmt.Errorf("%q isn't available for users with stream_filter in -auth.config", r.URL.Path),
+			StatusCode: http.StatusForbidden,
+		}
+	}
+	etfs, err := querier.ParseMetricSelector(sf)
+	if err != nil {
+		return r, fmt.Errorf("cannot parse stream_filter=%q from -auth.config: %w", sf, err)
+	}
+	return searchutils.WithEnforcedTagFilters(r, etfs), nil
+}
+
 func deleteHandler(startTime time.Time, w http.ResponseWriter, r *http.Request, p *httpserver.Path, at *auth.Token) bool {
 	switch p.Suffix {
 	case "prometheus/api/v1/admin/tsdb/delete_series":
+		if err := httpauth.Authorize(r, httpauth.OpDelete, []*auth.Token{at}); err != nil {
+			httpserver.Errorf(w, r, "%s", err)
+			return true
+		}
+		r, err := withStreamFilter(r, p)
+		if err != nil {
+			httpserver.Errorf(w, r, "%s", err)
+			return true
+		}
+		release, err := acquireSchedulerSlot(r, at)
+		if err != nil {
+			httpserver.Errorf(w, r, "%s", err)
+			return true
+		}
+		defer release()
 		deleteRequests.Inc()
 		if err := loki.DeleteHandler(startTime, at, r); err != nil {
 			deleteErrors.Inc()
@@ -355,11 +599,29 @@ var (
 	labelsCountRequests = metrics.NewCounter(`vm_http_requests_total{path="/select/{}/loki/api/v1/label/count"}`)
 	labelsCountErrors   = metrics.NewCounter(`vm_http_request_errors_total{path="/select/{}/loki/api/v1/label/count"}`)
 
+	indexStatsRequests = metrics.NewCounter(`vm_http_requests_total{path="/select/{}/loki/api/v1/index/stats"}`)
+	indexStatsErrors   = metrics.NewCounter(`vm_http_request_errors_total{path="/select/{}/loki/api/v1/index/stats"}`)
+
+	indexVolumeRequests = metrics.NewCounter(`vm_http_requests_total{path="/select/{}/loki/api/v1/index/volume"}`)
+	indexVolumeErrors   = metrics.NewCounter(`vm_http_request_errors_total{path="/select/{}/loki/api/v1/index/volume"}`)
+
+	patternsRequests = metrics.NewCounter(`vm_http_requests_total{path="/select/{}/loki/api/v1/patterns"}`)
+	patternsErrors   = metrics.NewCounter(`vm_http_request_errors_total{path="/select/{}/loki/api/v1/patterns"}`)
+
+	detectedFieldsRequests = metrics.NewCounter(`vm_http_requests_total{path="/select/{}/loki/api/v1/detected_fields"}`)
+	detectedFieldsErrors   = metrics.NewCounter(`vm_http_request_errors_total{path="/select/{}/loki/api/v1/detected_fields"}`)
+
+	detectedLabelsRequests = metrics.NewCounter(`vm_http_requests_total{path="/select/{}/loki/api/v1/detected_labels"}`)
+	detectedLabelsErrors   = metrics.NewCounter(`vm_http_request_errors_total{path="/select/{}/loki/api/v1/detected_labels"}`)
+
 	statusTSDBRequests = metrics.NewCounter(`vm_http_requests_total{path="/select/{}/v1/api/v1/status/tsdb"}`)
 	statusTSDBErrors   = metrics.NewCounter(`vm_http_request_errors_total{path="/select/{}/v1/api/v1/status/tsdb"}`)
 
 	statusActiveQueriesRequests = metrics.NewCounter(`vm_http_requests_total{path="/select/{}v1/api/v1/status/active_queries"}`)
 
+	cancelActiveQueryRequests = metrics.NewCounter(`vm_http_requests_total{path="/select/{}/loki/api/v1/status/active_queries/cancel"}`)
+	cancelActiveQueryErrors   = metrics.NewCounter(`vm_http_request_errors_total{path="/select/{}/loki/api/v1/status/active_queries/cancel"}`)
+
 	deleteRequests = metrics.NewCounter(`vm_http_requests_total{path="/delete/{}/v1/api/v1/admin/tsdb/delete_series"}`)
 	deleteErrors   = metrics.NewCounter(`vm_http_request_errors_total{path="/delete/{}/v1/api/v1/admin/tsdb/delete_series"}`)
 
@@ -371,4 +633,7 @@ var (
 
 	federateRequests = metrics.NewCounter(`vm_http_requests_total{path="/select/{}/v1/federate"}`)
 	federateErrors   = metrics.NewCounter(`vm_http_request_errors_total{path="/select/{}/v1/federate"}`)
+
+	crossTenantRequests = metrics.NewCounter(`vm_http_requests_total{path="/select/multitenant/loki/api/v1/{}"}`)
+	crossTenantErrors   = metrics.NewCounter(`vm_http_request_errors_total{path="/select/multitenant/loki/api/v1/{}"}`)
 )
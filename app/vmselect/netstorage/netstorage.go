@@ -14,6 +14,9 @@ import (
 	"time"
 
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/searchutils"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/clustertls"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/encodingext"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/querytracer"
 	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
@@ -62,6 +65,12 @@ type Results struct {
 
 	tbf *tmpBlocksFile
 
+	// qs is updated with the unpacked rows.
+	qs *QueryStats
+
+	// fieldNames contains log fields to unpack instead of log lines. See UnpackFields.
+	fieldNames []string
+
 	packedTimeseries []packedTimeseries
 }
 
@@ -70,6 +79,17 @@ func (rss *Results) Len() int {
 	return len(rss.packedTimeseries)
 }
 
+// UnpackFields makes RunParallel to pass values for the given log fields in Result.Datas instead of log lines.
+//
+// Every Datas item contains the field values as structured metadata with empty log line,
+// so they may be read with storage.UnmarshalLineWithMetadata. Only the columns for the given fields
+// are read from blocks with log fields split into columns. See storage.Block.UnmarshalFields.
+//
+// rss must be obtained via ProcessSearchQuery with storage.FetchAll.
+func (rss *Results) UnpackFields(names []string) {
+	rss.fieldNames = names
+}
+
 // Cancel cancels rss work.
 func (rss *Results) Cancel() {
 	putTmpBlocksFile(rss.tbf)
@@ -107,10 +127,11 @@ func timeseriesWorker(workerID uint) {
 			tsw.doneCh <- nil
 			continue
 		}
-		if err := tsw.pts.Unpack(rss.tbf, &rs, rss.tr, rss.fetchData, rss.at); err != nil {
+		if err := tsw.pts.Unpack(rss.tbf, &rs, rss.tr, rss.fetchData, rss.fieldNames, rss.at); err != nil {
 			tsw.doneCh <- fmt.Errorf("error during time series unpacking: %w", err)
 			continue
 		}
+		rss.qs.addProcessedResult(&rs)
 		if len(rs.Timestamps) > 0 || rss.fetchData == 0 {
 			if err := tsw.f(&rs, workerID); err != nil {
 				tsw.doneCh <- err
@@ -195,11 +216,12 @@ type unpackWorkItem struct {
 }
 
 type unpackWork struct {
-	ws     []unpackWorkItem
-	tbf    *tmpBlocksFile
-	at     *auth.Token
-	sbs    []*sortBlock
-	doneCh chan error
+	ws         []unpackWorkItem
+	tbf        *tmpBlocksFile
+	fieldNames []string
+	at         *auth.Token
+	sbs        []*sortBlock
+	doneCh     chan error
 }
 
 func (upw *unpackWork) reset() {
@@ -211,6 +233,7 @@ func (upw *unpackWork) reset() {
 	}
 	upw.ws = upw.ws[:0]
 	upw.tbf = nil
+	upw.fieldNames = nil
 	upw.at = nil
 	sbs := upw.sbs
 	for i := range sbs {
@@ -225,7 +248,7 @@ func (upw *unpackWork) reset() {
 func (upw *unpackWork) unpack(tmpBlock *storage.Block) {
 	for _, w := range upw.ws {
 		sb := getSortBlock()
-		if err := sb.unpackFrom(tmpBlock, upw.tbf, w.addr, w.tr, upw.at); err != nil {
+		if err := sb.unpackFrom(tmpBlock, upw.tbf, w.addr, w.tr, upw.fieldNames, upw.at); err != nil {
 			putSortBlock(sb)
 			upw.doneCh <- fmt.Errorf("cannot unpack block: %w", err)
 			return
@@ -271,7 +294,9 @@ func unpackWorker() {
 var unpackBatchSize = 8 * runtime.GOMAXPROCS(-1)
 
 // Unpack unpacks pts to dst.
-func (pts *packedTimeseries) Unpack(tbf *tmpBlocksFile, dst *Result, tr storage.TimeRange, fetchData uint8, at *auth.Token) error {
+//
+// Values for fieldNames are unpacked instead of log lines if fieldNames isn't empty. See Results.UnpackFields.
+func (pts *packedTimeseries) Unpack(tbf *tmpBlocksFile, dst *Result, tr storage.TimeRange, fetchData uint8, fieldNames []string, at *auth.Token) error {
 	dst.reset()
 	if err := dst.MetricName.Unmarshal(bytesutil.ToUnsafeBytes(pts.metricName)); err != nil {
 		return fmt.Errorf("cannot unmarshal metricName %q: %w", pts.metricName, err)
@@ -286,6 +311,7 @@ func (pts *packedTimeseries) Unpack(tbf *tmpBlocksFile, dst *Result, tr storage.
 	upws := make([]*unpackWork, 0, 1+addrsLen/unpackBatchSize)
 	upw := getUnpackWork()
 	upw.tbf = tbf
+	upw.fieldNames = fieldNames
 	upw.at = at
 	for _, addr := range pts.addrs {
 		if len(upw.ws) >= unpackBatchSize {
@@ -293,6 +319,7 @@ func (pts *packedTimeseries) Unpack(tbf *tmpBlocksFile, dst *Result, tr storage.
 			upws = append(upws, upw)
 			upw = getUnpackWork()
 			upw.tbf = tbf
+			upw.fieldNames = fieldNames
 			upw.at = at
 		}
 		upw.ws = append(upw.ws, unpackWorkItem{
@@ -365,9 +392,7 @@ func mergeSortBlocks(dst *Result, sbh sortBlocksHeap) {
 		heap.Pop(&sbh)
 		if len(sbh) == 0 {
 			timestamps := top.Timestamps[top.NextIdx:]
-			for i := 0; i < len(timestamps); i++ {
-				dst.Values = append(dst.Values, 1)
-			}
+			dst.Values = appendRowValues(dst.Values, top.Values[top.NextIdx:])
 			dst.Timestamps = append(dst.Timestamps, timestamps...)
 			dst.Datas = append(dst.Datas, top.Values[top.NextIdx:]...)
 			putSortBlock(top)
@@ -383,9 +408,7 @@ func mergeSortBlocks(dst *Result, sbh sortBlocksHeap) {
 			}
 		}
 		timestamps := top.Timestamps[top.NextIdx:idxNext]
-		for i := 0; i < len(timestamps); i++ {
-			dst.Values = append(dst.Values, 1)
-		}
+		dst.Values = appendRowValues(dst.Values, top.Values[top.NextIdx:idxNext])
 		dst.Timestamps = append(dst.Timestamps, timestamps...)
 		dst.Datas = append(dst.Datas, top.Values[top.NextIdx:idxNext]...)
 		if idxNext < len(top.Timestamps) {
@@ -405,8 +428,33 @@ func mergeSortBlocks(dst *Result, sbh sortBlocksHeap) {
 	dst.Datas = datas
 }
 
+// appendRowValues appends numeric values for the given row values to dst and returns the result.
+//
+// Log lines have value 1, so rollup functions count them, while numeric samples written by recording rules have their own values.
+func appendRowValues(dst []float64, rowValues [][]byte) []float64 {
+	for _, rowValue := range rowValues {
+		v, ok := storage.UnmarshalNumericValue(rowValue)
+		if !ok {
+			v = 1
+		}
+		dst = append(dst, v)
+	}
+	return dst
+}
+
 var dedupsDuringSelect = metrics.NewCounter(`vm_deduplicated_samples_total{type="select"}`)
 
+var (
+	_ = metrics.NewGauge(`vm_columnar_blocks_reads_total{type="lines"}`, func() float64 {
+		linesReads, _ := storage.GetColumnarBlockReads()
+		return float64(linesReads)
+	})
+	_ = metrics.NewGauge(`vm_columnar_blocks_reads_total{type="fields"}`, func() float64 {
+		_, fieldReads := storage.GetColumnarBlockReads()
+		return float64(fieldReads)
+	})
+)
+
 type sortBlock struct {
 	Timestamps []int64
 	Values     [][]byte
@@ -419,10 +467,14 @@ func (sb *sortBlock) reset() {
 	sb.NextIdx = 0
 }
 
-func (sb *sortBlock) unpackFrom(tmpBlock *storage.Block, tbf *tmpBlocksFile, addr tmpBlockAddr, tr storage.TimeRange, at *auth.Token) error {
+func (sb *sortBlock) unpackFrom(tmpBlock *storage.Block, tbf *tmpBlocksFile, addr tmpBlockAddr, tr storage.TimeRange, fieldNames []string, at *auth.Token) error {
 	tmpBlock.Reset()
 	tbf.MustReadBlockAt(tmpBlock, addr)
-	if err := tmpBlock.UnmarshalData(false); err != nil {
+	if len(fieldNames) > 0 {
+		if err := tmpBlock.UnmarshalFields(fieldNames); err != nil {
+			return fmt.Errorf("cannot unmarshal fields from block: %w", err)
+		}
+	} else if err := tmpBlock.UnmarshalData(false); err != nil {
 		return fmt.Errorf("cannot unmarshal block: %w", err)
 	}
 	sb.Timestamps, sb.Values = tmpBlock.AppendRowsWithTimeRangeFilter(sb.Timestamps[:0], sb.Values[:0], tr)
@@ -502,8 +554,8 @@ func DeleteSeries(at *auth.Token, sq *storage.SearchQuery, deadline searchutils.
 	return deletedTotal, nil
 }
 
-// GetLabels returns labels until the given deadline.
-func GetLabels(at *auth.Token, deadline searchutils.Deadline) ([]string, bool, error) {
+// GetLabels returns labels for streams on the given tr until the given deadline.
+func GetLabels(at *auth.Token, tr storage.TimeRange, deadline searchutils.Deadline) ([]string, bool, error) {
 	if deadline.Exceeded() {
 		return nil, false, fmt.Errorf("timeout exceeded before starting the query processing: %s", deadline.String())
 	}
@@ -516,7 +568,7 @@ func GetLabels(at *auth.Token, deadline searchutils.Deadline) ([]string, bool, e
 	for _, sn := range storageNodes {
 		go func(sn *storageNode) {
 			sn.labelsRequests.Inc()
-			labels, err := sn.getLabels(at.AccountID, at.ProjectID, deadline)
+			labels, err := sn.getLabels(at.AccountID, at.ProjectID, tr, deadline)
 			if err != nil {
 				sn.labelsRequestErrors.Inc()
 				err = fmt.Errorf("cannot get labels from vmstorage %s: %w", sn.connPool.Addr(), err)
@@ -573,9 +625,327 @@ func GetLabels(at *auth.Token, deadline searchutils.Deadline) ([]string, bool, e
 	return labels, isPartialResult, nil
 }
 
-// GetLabelValues returns label values for the given labelName
+// GetTenants returns all the tenants from vmstorage nodes until the given deadline.
+//
+// Tenants are returned in the form `accountID:projectID`.
+func GetTenants(deadline searchutils.Deadline) ([]string, bool, error) {
+	if deadline.Exceeded() {
+		return nil, false, fmt.Errorf("timeout exceeded before starting the query processing: %s", deadline.String())
+	}
+	// Send the query to all the storage nodes in parallel.
+	type nodeResult struct {
+		tenants []string
+		err     error
+	}
+	resultsCh := make(chan nodeResult, len(storageNodes))
+	for _, sn := range storageNodes {
+		go func(sn *storageNode) {
+			sn.tenantsRequests.Inc()
+			tenants, err := sn.getTenants(deadline)
+			if err != nil {
+				sn.tenantsRequestErrors.Inc()
+				err = fmt.Errorf("cannot get tenants from vmstorage %s: %w", sn.connPool.Addr(), err)
+			}
+			resultsCh <- nodeResult{
+				tenants: tenants,
+				err:     err,
+			}
+		}(sn)
+	}
+
+	// Collect results
+	var tenants []string
+	var errors []error
+	for i := 0; i < len(storageNodes); i++ {
+		// There is no need in timer here, since all the goroutines executing
+		// sn.getTenants must be finished until the deadline.
+		nr := <-resultsCh
+		if nr.err != nil {
+			errors = append(errors, nr.err)
+			continue
+		}
+		tenants = append(tenants, nr.tenants...)
+	}
+	isPartialResult := false
+	if len(errors) > 0 {
+		if len(errors) == len(storageNodes) {
+			// Return only the first error, since it has no sense in returning all errors.
+			return nil, true, fmt.Errorf("error occured during fetching tenants: %w", errors[0])
+		}
+
+		// Just log errors and return partial results.
+		// This allows gracefully degrade vmselect in the case
+		// if certain storageNodes are temporarily unavailable.
+		partialTenantsResults.Inc()
+		// Log only the first error, since it has no sense in returning all errors.
+		logger.Errorf("certain storageNodes are unhealthy when fetching tenants: %s", errors[0])
+		isPartialResult = true
+	}
+
+	tenants = deduplicateStrings(tenants)
+	sort.Strings(tenants)
+
+	return tenants, isPartialResult, nil
+}
+
+// GetStreamStats returns stats per stream for streams matching the given sq until the given deadline.
+//
+// The stats are calculated by vmstorage nodes from block headers without reading block data.
+func GetStreamStats(at *auth.Token, sq *storage.SearchQuery, deadline searchutils.Deadline) ([]storage.StreamStats, bool, error) {
+	if deadline.Exceeded() {
+		return nil, false, fmt.Errorf("timeout exceeded before starting the query processing: %s", deadline.String())
+	}
+	requestData := sq.Marshal(nil)
+
+	// Send the query to all the storage nodes in parallel.
+	type nodeResult struct {
+		sss []storage.StreamStats
+		err error
+	}
+	resultsCh := make(chan nodeResult, len(storageNodes))
+	for _, sn := range storageNodes {
+		go func(sn *storageNode) {
+			sn.streamStatsRequests.Inc()
+			sss, err := sn.getStreamStats(requestData, deadline)
+			if err != nil {
+				sn.streamStatsRequestErrors.Inc()
+				err = fmt.Errorf("cannot get stream stats from vmstorage %s: %w", sn.connPool.Addr(), err)
+			}
+			resultsCh <- nodeResult{
+				sss: sss,
+				err: err,
+			}
+		}(sn)
+	}
+
+	// Collect results. The same stream may be located on multiple vmstorage nodes,
+	// so merge stats by MetricName.
+	m := make(map[string]*storage.StreamStats)
+	var orderedMetricNames []string
+	var errors []error
+	for i := 0; i < len(storageNodes); i++ {
+		// There is no need in timer here, since all the goroutines executing
+		// sn.getStreamStats must be finished until the deadline.
+		nr := <-resultsCh
+		if nr.err != nil {
+			errors = append(errors, nr.err)
+			continue
+		}
+		for i := range nr.sss {
+			ss := &nr.sss[i]
+			if dst := m[string(ss.MetricName)]; dst != nil {
+				dst.Add(ss)
+				continue
+			}
+			m[string(ss.MetricName)] = ss
+			orderedMetricNames = append(orderedMetricNames, string(ss.MetricName))
+		}
+	}
+	isPartialResult := false
+	if len(errors) > 0 {
+		if len(errors) == len(storageNodes) {
+			// Return only the first error, since it has no sense in returning all errors.
+			return nil, true, fmt.Errorf("error occured during fetching stream stats: %w", errors[0])
+		}
+
+		// Just log errors and return partial results.
+		// This allows gracefully degrade vmselect in the case
+		// if certain storageNodes are temporarily unavailable.
+		partialStreamStatsResults.Inc()
+		// Log only the first error, since it has no sense in returning all errors.
+		logger.Errorf("certain storageNodes are unhealthy when fetching stream stats: %s", errors[0])
+		isPartialResult = true
+	}
+
+	sss := make([]storage.StreamStats, 0, len(orderedMetricNames))
+	for _, metricName := range orderedMetricNames {
+		sss = append(sss, *m[metricName])
+	}
+	return sss, isPartialResult, nil
+}
+
+// SampleRows passes rows sampled by vmstorage nodes from blocks matching sq to f until the given deadline.
+//
+// Every vmstorage node returns up to maxRowsPerBlock rows per each block, so the amount of transferred data is bounded for big streams.
+// f is called serially. sb mustn't be used after f returns.
+func SampleRows(at *auth.Token, sq *storage.SearchQuery, maxRowsPerBlock int, deadline searchutils.Deadline, f func(sb *storage.SampledBlock) error) (bool, error) {
+	if deadline.Exceeded() {
+		return false, fmt.Errorf("timeout exceeded before starting the query processing: %s", deadline.String())
+	}
+	requestData := sq.Marshal(nil)
+	requestData = encoding.MarshalUint32(requestData, uint32(maxRowsPerBlock))
+
+	// Send the query to all the storage nodes in parallel.
+	var fLock sync.Mutex
+	fSerial := func(sb *storage.SampledBlock) error {
+		fLock.Lock()
+		defer fLock.Unlock()
+		return f(sb)
+	}
+	resultsCh := make(chan error, len(storageNodes))
+	for _, sn := range storageNodes {
+		go func(sn *storageNode) {
+			sn.sampleRowsRequests.Inc()
+			err := sn.sampleRows(requestData, deadline, fSerial)
+			if err != nil {
+				sn.sampleRowsRequestErrors.Inc()
+				err = fmt.Errorf("cannot sample rows from vmstorage %s: %w", sn.connPool.Addr(), err)
+			}
+			resultsCh <- err
+		}(sn)
+	}
+
+	// Collect results.
+	var errors []error
+	for i := 0; i < len(storageNodes); i++ {
+		// There is no need in timer here, since all the goroutines executing
+		// sn.sampleRows must be finished until the deadline.
+		if err := <-resultsCh; err != nil {
+			errors = append(errors, err)
+		}
+	}
+	isPartialResult := false
+	if len(errors) > 0 {
+		if len(errors) == len(storageNodes) {
+			// Return only the first error, since it has no sense in returning all errors.
+			return true, fmt.Errorf("error occured during sampling rows: %w", errors[0])
+		}
+
+		// Just log errors and return partial results.
+		// This allows gracefully degrade vmselect in the case
+		// if certain storageNodes are temporarily unavailable.
+		partialSampleRowsResults.Inc()
+		// Log only the first error, since it has no sense in returning all errors.
+		logger.Errorf("certain storageNodes are unhealthy when sampling rows: %s", errors[0])
+		isPartialResult = true
+	}
+	return isPartialResult, nil
+}
+
+// errTailStopped is returned when live tail is stopped via stopCh.
+var errTailStopped = errors.New("tail has been stopped")
+
+// Tail passes rows matching sq to f as soon as they are added to vmstorage nodes.
+//
+// Rows dropped by vmstorage nodes due to slow processing are passed to f as dropped.
+// f is called serially. rows and dropped mustn't be used after f returns.
+//
+// onSubscribed is called exactly once when all the vmstorage nodes have subscribed to the added rows or failed to subscribe,
+// so all the rows added after this call are passed to f.
+//
+// Tail returns when the deadline is reached, stopCh is closed or f returns an error.
+// The time range from sq isn't used.
+func Tail(at *auth.Token, sq *storage.SearchQuery, deadline searchutils.Deadline, stopCh <-chan struct{}, onSubscribed func(),
+	f func(rows, dropped []storage.TailRow) error) (bool, error) {
+	if deadline.Exceeded() {
+		onSubscribed()
+		return false, fmt.Errorf("timeout exceeded before starting the query processing: %s", deadline.String())
+	}
+	requestData := sq.Marshal(nil)
+
+	// tailStopCh is closed when stopCh is closed or when f returns an error.
+	tailStopCh := make(chan struct{})
+	var stopOnce sync.Once
+	stopTail := func() {
+		stopOnce.Do(func() {
+			close(tailStopCh)
+		})
+	}
+	defer stopTail()
+	go func() {
+		select {
+		case <-stopCh:
+			stopTail()
+		case <-tailStopCh:
+		}
+	}()
+
+	var fLock sync.Mutex
+	var fErr error
+	fLocked := func(rows, dropped []storage.TailRow) error {
+		fLock.Lock()
+		defer fLock.Unlock()
+		if fErr != nil {
+			return errTailStopped
+		}
+		if len(rows) == 0 && len(dropped) == 0 {
+			return nil
+		}
+		if err := f(rows, dropped); err != nil {
+			// Stop the tail on all the storage nodes and return the error to the caller.
+			fErr = err
+			stopTail()
+			return errTailStopped
+		}
+		return nil
+	}
+
+	var subscribedWG sync.WaitGroup
+	subscribedWG.Add(len(storageNodes))
+	go func() {
+		subscribedWG.Wait()
+		onSubscribed()
+	}()
+
+	// Send the query to all the storage nodes in parallel.
+	errorsCh := make(chan error, len(storageNodes))
+	for _, sn := range storageNodes {
+		go func(sn *storageNode) {
+			sn.tailRequests.Inc()
+			var subscribedOnce sync.Once
+			subscribed := func() {
+				subscribedOnce.Do(subscribedWG.Done)
+			}
+			err := sn.tail(requestData, deadline, tailStopCh, subscribed, fLocked)
+			// Mark the node as subscribed if it failed before the subscription.
+			subscribed()
+			if errors.Is(err, errTailStopped) {
+				err = nil
+			}
+			if err != nil {
+				sn.tailRequestErrors.Inc()
+				err = fmt.Errorf("cannot tail rows from vmstorage %s: %w", sn.connPool.Addr(), err)
+			}
+			errorsCh <- err
+		}(sn)
+	}
+
+	// Wait until all the storage nodes stop the tail.
+	var errors []error
+	for i := 0; i < len(storageNodes); i++ {
+		// There is no need in timer here, since all the goroutines executing
+		// sn.tail must be finished until the deadline.
+		if err := <-errorsCh; err != nil {
+			errors = append(errors, err)
+		}
+	}
+	fLock.Lock()
+	err := fErr
+	fLock.Unlock()
+	if err != nil {
+		return false, err
+	}
+	isPartialResult := false
+	if len(errors) > 0 {
+		if len(errors) == len(storageNodes) {
+			// Return only the first error, since it has no sense in returning all errors.
+			return true, fmt.Errorf("error occured during tail: %w", errors[0])
+		}
+
+		// Just log errors and return partial results.
+		// This allows gracefully degrade vmselect in the case
+		// if certain storageNodes are temporarily unavailable.
+		partialTailResults.Inc()
+		// Log only the first error, since it has no sense in returning all errors.
+		logger.Errorf("certain storageNodes are unhealthy during tail: %s", errors[0])
+		isPartialResult = true
+	}
+	return isPartialResult, nil
+}
+
+// GetLabelValues returns label values for the given labelName for streams on the given tr
 // until the given deadline.
-func GetLabelValues(at *auth.Token, labelName string, deadline searchutils.Deadline) ([]string, bool, error) {
+func GetLabelValues(at *auth.Token, tr storage.TimeRange, labelName string, deadline searchutils.Deadline) ([]string, bool, error) {
 	if deadline.Exceeded() {
 		return nil, false, fmt.Errorf("timeout exceeded before starting the query processing: %s", deadline.String())
 	}
@@ -592,7 +962,7 @@ func GetLabelValues(at *auth.Token, labelName string, deadline searchutils.Deadl
 	for _, sn := range storageNodes {
 		go func(sn *storageNode) {
 			sn.labelValuesRequests.Inc()
-			labelValues, err := sn.getLabelValues(at.AccountID, at.ProjectID, labelName, deadline)
+			labelValues, err := sn.getLabelValues(at.AccountID, at.ProjectID, tr, labelName, deadline)
 			if err != nil {
 				sn.labelValuesRequestErrors.Inc()
 				err = fmt.Errorf("cannot get label values from vmstorage %s: %w", sn.connPool.Addr(), err)
@@ -981,44 +1351,73 @@ func GetSeriesCount(at *auth.Token, deadline searchutils.Deadline) (uint64, bool
 type tmpBlocksFileWrapper struct {
 	mu                 sync.Mutex
 	tbf                *tmpBlocksFile
+	qs                 *QueryStats
 	m                  map[string][]tmpBlockAddr
 	orderedMetricNames []string
+
+	// limitErr is set when the query exceeds limits set via QueryStats.SetLimits.
+	limitErr error
+
+	// blocksCount and bytesCount contain the number of blocks and bytes written to tbf.
+	blocksCount int
+	bytesCount  int
 }
 
-func (tbfw *tmpBlocksFileWrapper) RegisterEmptyBlock(mb *storage.MetricBlock) {
+func (tbfw *tmpBlocksFileWrapper) RegisterEmptyBlock(mb *storage.MetricBlock) error {
 	metricName := mb.MetricName
 	tbfw.mu.Lock()
+	defer tbfw.mu.Unlock()
 	if addrs := tbfw.m[string(metricName)]; addrs == nil {
 		// An optimization for big number of time series with long names: store only a single copy of metricNameStr
 		// in both tbfw.orderedMetricNames and tbfw.m.
 		tbfw.orderedMetricNames = append(tbfw.orderedMetricNames, string(metricName))
 		tbfw.m[tbfw.orderedMetricNames[len(tbfw.orderedMetricNames)-1]] = []tmpBlockAddr{{}}
+		return tbfw.checkLimitLocked(tbfw.qs.checkSeries(len(tbfw.orderedMetricNames)))
 	}
-	tbfw.mu.Unlock()
+	return tbfw.limitErr
 }
 
 func (tbfw *tmpBlocksFileWrapper) RegisterAndWriteBlock(mb *storage.MetricBlock) error {
 	bb := tmpBufPool.Get()
 	bb.B = storage.MarshalBlock(bb.B[:0], &mb.Block)
+	tbfw.qs.addFetchedBlock(mb.Block.RowsCount(), len(bb.B))
 	tbfw.mu.Lock()
+	defer tbfw.mu.Unlock()
+	if err := tbfw.checkLimitLocked(tbfw.qs.checkBytesRead()); err != nil {
+		tmpBufPool.Put(bb)
+		return err
+	}
 	addr, err := tbfw.tbf.WriteBlockData(bb.B)
+	size := len(bb.B)
 	tmpBufPool.Put(bb)
-	if err == nil {
-		metricName := mb.MetricName
-		addrs := tbfw.m[string(metricName)]
-		addrs = append(addrs, addr)
-		if len(addrs) > 1 {
-			// An optimization: avoid memory allocation and copy for already existing metricName key in tbfw.m.
-			tbfw.m[string(metricName)] = addrs
-		} else {
-			// An optimization for big number of time series with long names: store only a single copy of metricNameStr
-			// in both tbfw.orderedMetricNames and tbfw.m.
-			tbfw.orderedMetricNames = append(tbfw.orderedMetricNames, string(metricName))
-			tbfw.m[tbfw.orderedMetricNames[len(tbfw.orderedMetricNames)-1]] = addrs
-		}
+	if err != nil {
+		return err
 	}
-	tbfw.mu.Unlock()
-	return err
+	tbfw.blocksCount++
+	tbfw.bytesCount += size
+	metricName := mb.MetricName
+	addrs := tbfw.m[string(metricName)]
+	addrs = append(addrs, addr)
+	if len(addrs) > 1 {
+		// An optimization: avoid memory allocation and copy for already existing metricName key in tbfw.m.
+		tbfw.m[string(metricName)] = addrs
+		return nil
+	}
+	// An optimization for big number of time series with long names: store only a single copy of metricNameStr
+	// in both tbfw.orderedMetricNames and tbfw.m.
+	tbfw.orderedMetricNames = append(tbfw.orderedMetricNames, string(metricName))
+	tbfw.m[tbfw.orderedMetricNames[len(tbfw.orderedMetricNames)-1]] = addrs
+	return tbfw.checkLimitLocked(tbfw.qs.checkSeries(len(tbfw.orderedMetricNames)))
+}
+
+// checkLimitLocked remembers the first non-nil err from limit checks and returns it.
+//
+// The remembered error is returned from ProcessSearchQuery instead of partial results from the remaining vmstorage nodes.
+func (tbfw *tmpBlocksFileWrapper) checkLimitLocked(err error) error {
+	if err != nil && tbfw.limitErr == nil {
+		tbfw.limitErr = err
+	}
+	return tbfw.limitErr
 }
 
 var metricNamePool = &sync.Pool{
@@ -1053,17 +1452,48 @@ func ExportBlocks(at *auth.Token, sq *storage.SearchQuery, deadline searchutils.
 		metricNamePool.Put(mn)
 		return nil
 	}
-	isPartialResult, err := processSearchQuery(at, sq, processBlock, deadline)
+	isPartialResult, err := processSearchQuery(nil, at, sq, nil, processBlock, deadline)
 	if err != nil {
 		return true, fmt.Errorf("error occured during export: %w", err)
 	}
 	return isPartialResult, nil
 }
 
+// CountNumericBlocks returns the number of blocks with numeric samples and the total number of blocks for series matching sq.
+//
+// Only block headers are inspected, so block data isn't read at vmstorage nodes.
+func CountNumericBlocks(qt *querytracer.Tracer, at *auth.Token, sq *storage.SearchQuery, deadline searchutils.Deadline) (uint64, uint64, bool, error) {
+	qt = qt.NewChild("count blocks with numeric samples: %s", sq)
+	defer qt.Done()
+	if deadline.Exceeded() {
+		return 0, 0, false, fmt.Errorf("timeout exceeded before starting the query processing: %s", deadline.String())
+	}
+	sqCopy := *sq
+	sqCopy.FetchData = storage.NotFetch
+	var numericBlocks, blocks uint64
+	processBlock := func(mb *storage.MetricBlock) error {
+		if mb.Block.IsNumeric() {
+			atomic.AddUint64(&numericBlocks, 1)
+		}
+		atomic.AddUint64(&blocks, 1)
+		return nil
+	}
+	isPartialResult, err := processSearchQuery(qt, at, &sqCopy, nil, processBlock, deadline)
+	if err != nil {
+		return 0, 0, true, fmt.Errorf("error occured during search: %w", err)
+	}
+	qt.Printf("found %d blocks with numeric samples out of %d blocks", numericBlocks, blocks)
+	return numericBlocks, blocks, isPartialResult, nil
+}
+
 // ProcessSearchQuery performs sq until the given deadline.
 //
+// Query statistics are collected into qs if it isn't nil. The query trace with sub-traces from vmstorage nodes is added to qt.
+//
 // Results.RunParallel or Results.Cancel must be called on the returned Results.
-func ProcessSearchQuery(at *auth.Token, sq *storage.SearchQuery, deadline searchutils.Deadline) (*Results, bool, error) {
+func ProcessSearchQuery(qt *querytracer.Tracer, at *auth.Token, sq *storage.SearchQuery, qs *QueryStats, deadline searchutils.Deadline) (*Results, bool, error) {
+	qt = qt.NewChild("fetch matching series: %s", sq)
+	defer qt.Done()
 	if deadline.Exceeded() {
 		return nil, false, fmt.Errorf("timeout exceeded before starting the query processing: %s", deadline.String())
 	}
@@ -1073,19 +1503,26 @@ func ProcessSearchQuery(at *auth.Token, sq *storage.SearchQuery, deadline search
 	}
 	tbfw := &tmpBlocksFileWrapper{
 		tbf: getTmpBlocksFile(),
+		qs:  qs,
 		m:   make(map[string][]tmpBlockAddr),
 	}
 	processBlock := func(mb *storage.MetricBlock) error {
 		if sq.FetchData == storage.NotFetch {
-			tbfw.RegisterEmptyBlock(mb)
-			return nil
+			return tbfw.RegisterEmptyBlock(mb)
 		}
 		if err := tbfw.RegisterAndWriteBlock(mb); err != nil {
 			return fmt.Errorf("cannot write MetricBlock to temporary blocks file: %w", err)
 		}
 		return nil
 	}
-	isPartialResult, err := processSearchQuery(at, sq, processBlock, deadline)
+	startTime := time.Now()
+	isPartialResult, err := processSearchQuery(qt, at, sq, qs, processBlock, deadline)
+	qs.addFetchDuration(time.Since(startTime))
+	if tbfw.limitErr != nil {
+		// It is safe reading tbfw.limitErr without the lock, since processSearchQuery waits for all the vmstorage nodes.
+		putTmpBlocksFile(tbfw.tbf)
+		return nil, false, tbfw.limitErr
+	}
 	if err != nil {
 		putTmpBlocksFile(tbfw.tbf)
 		return nil, true, fmt.Errorf("error occured during search: %w", err)
@@ -1101,6 +1538,7 @@ func ProcessSearchQuery(at *auth.Token, sq *storage.SearchQuery, deadline search
 	rss.fetchData = sq.FetchData
 	rss.deadline = deadline
 	rss.tbf = tbfw.tbf
+	rss.qs = qs
 	pts := make([]packedTimeseries, len(tbfw.orderedMetricNames))
 	for i, metricName := range tbfw.orderedMetricNames {
 		pts[i] = packedTimeseries{
@@ -1109,18 +1547,23 @@ func ProcessSearchQuery(at *auth.Token, sq *storage.SearchQuery, deadline search
 		}
 	}
 	rss.packedTimeseries = pts
+	qt.Printf("fetched %d series, %d blocks, %d bytes", len(pts), tbfw.blocksCount, tbfw.bytesCount)
 	return &rss, isPartialResult, nil
 }
 
-func processSearchQuery(at *auth.Token, sq *storage.SearchQuery, processBlock func(mb *storage.MetricBlock) error, deadline searchutils.Deadline) (bool, error) {
+func processSearchQuery(qt *querytracer.Tracer, at *auth.Token, sq *storage.SearchQuery, qs *QueryStats, processBlock func(mb *storage.MetricBlock) error, deadline searchutils.Deadline) (bool, error) {
 	requestData := sq.Marshal(nil)
 
 	// Send the query to all the storage nodes in parallel.
 	resultsCh := make(chan error, len(storageNodes))
+	qs.addPendingStorageNodes(len(storageNodes))
 	for _, sn := range storageNodes {
 		go func(sn *storageNode) {
 			sn.searchRequests.Inc()
-			err := sn.processSearchQuery(requestData, processBlock, deadline)
+			qtChild := qt.NewChild("rpc search at vmstorage %s", sn.connPool.Addr())
+			err := sn.processSearchQuery(qtChild, requestData, qs, processBlock, deadline)
+			qtChild.Done()
+			qs.addPendingStorageNodes(-1)
 			if err != nil {
 				sn.searchRequestErrors.Inc()
 				err = fmt.Errorf("cannot perform search on vmstorage %s: %w", sn.connPool.Addr(), err)
@@ -1176,6 +1619,30 @@ type storageNode struct {
 	// The number of errors during requests to labels.
 	labelsRequestErrors *metrics.Counter
 
+	// The number of requests to tenants.
+	tenantsRequests *metrics.Counter
+
+	// The number of errors during requests to tenants.
+	tenantsRequestErrors *metrics.Counter
+
+	// The number of requests to streamStats.
+	streamStatsRequests *metrics.Counter
+
+	// The number of errors during requests to streamStats.
+	streamStatsRequestErrors *metrics.Counter
+
+	// The number of requests to sampleRows.
+	sampleRowsRequests *metrics.Counter
+
+	// The number of errors during requests to sampleRows.
+	sampleRowsRequestErrors *metrics.Counter
+
+	// The number of requests to tail.
+	tailRequests *metrics.Counter
+
+	// The number of errors during requests to tail.
+	tailRequestErrors *metrics.Counter
+
 	// The number of requests to labelValues.
 	labelValuesRequests *metrics.Counter
 
@@ -1206,12 +1673,21 @@ type storageNode struct {
 	// The number of errors during requests to seriesCount.
 	seriesCountRequestErrors *metrics.Counter
 
+	// The number of requests to valuesDict.
+	valuesDictRequests *metrics.Counter
+
+	// The number of errors during requests to valuesDict.
+	valuesDictRequestErrors *metrics.Counter
+
 	// The number of search requests to storageNode.
 	searchRequests *metrics.Counter
 
 	// The number of search request errors to storageNode.
 	searchRequestErrors *metrics.Counter
 
+	// The number of requests to storageNode canceled via /loki/api/v1/status/active_queries/cancel.
+	canceledRequests *metrics.Counter
+
 	// The number of metric blocks read.
 	metricBlocksRead *metrics.Counter
 
@@ -1229,50 +1705,108 @@ func (sn *storageNode) deleteMetrics(requestData []byte, deadline searchutils.De
 		deletedCount += n
 		return nil
 	}
-	if err := sn.execOnConn("deleteMetrics_v3", f, deadline); err != nil {
+	if err := sn.execOnConn("deleteMetrics_v4", f, deadline); err != nil {
 		// Try again before giving up.
 		// There is no need in zeroing deletedCount.
-		if err = sn.execOnConn("deleteMetrics_v3", f, deadline); err != nil {
+		if err = sn.execOnConn("deleteMetrics_v4", f, deadline); err != nil {
 			return deletedCount, err
 		}
 	}
 	return deletedCount, nil
 }
 
-func (sn *storageNode) getLabels(accountID, projectID uint32, deadline searchutils.Deadline) ([]string, error) {
+func (sn *storageNode) getLabels(accountID, projectID uint32, tr storage.TimeRange, deadline searchutils.Deadline) ([]string, error) {
 	var labels []string
 	f := func(bc *handshake.BufferedConn) error {
-		ls, err := sn.getLabelsOnConn(bc, accountID, projectID)
+		ls, err := sn.getLabelsOnConn(bc, accountID, projectID, tr)
 		if err != nil {
 			return err
 		}
 		labels = ls
 		return nil
 	}
-	if err := sn.execOnConn("labels_v2", f, deadline); err != nil {
+	if err := sn.execOnConn("labels_v3", f, deadline); err != nil {
 		// Try again before giving up.
 		labels = nil
-		if err = sn.execOnConn("labels_v2", f, deadline); err != nil {
+		if err = sn.execOnConn("labels_v3", f, deadline); err != nil {
 			return nil, err
 		}
 	}
 	return labels, nil
 }
 
-func (sn *storageNode) getLabelValues(accountID, projectID uint32, labelName string, deadline searchutils.Deadline) ([]string, error) {
+func (sn *storageNode) getTenants(deadline searchutils.Deadline) ([]string, error) {
+	var tenants []string
+	f := func(bc *handshake.BufferedConn) error {
+		ts, err := sn.getTenantsOnConn(bc)
+		if err != nil {
+			return err
+		}
+		tenants = ts
+		return nil
+	}
+	if err := sn.execOnConn("tenants_v1", f, deadline); err != nil {
+		// Try again before giving up.
+		tenants = nil
+		if err = sn.execOnConn("tenants_v1", f, deadline); err != nil {
+			return nil, err
+		}
+	}
+	return tenants, nil
+}
+
+func (sn *storageNode) getStreamStats(requestData []byte, deadline searchutils.Deadline) ([]storage.StreamStats, error) {
+	var sss []storage.StreamStats
+	f := func(bc *handshake.BufferedConn) error {
+		result, err := sn.getStreamStatsOnConn(bc, requestData)
+		if err != nil {
+			return err
+		}
+		sss = result
+		return nil
+	}
+	if err := sn.execOnConn("streamStats_v2", f, deadline); err != nil {
+		// Try again before giving up.
+		sss = nil
+		if err = sn.execOnConn("streamStats_v2", f, deadline); err != nil {
+			return nil, err
+		}
+	}
+	return sss, nil
+}
+
+func (sn *storageNode) sampleRows(requestData []byte, deadline searchutils.Deadline, f func(sb *storage.SampledBlock) error) error {
+	fOnConn := func(bc *handshake.BufferedConn) error {
+		return sn.sampleRowsOnConn(bc, requestData, f)
+	}
+	// Do not retry on errors, since some rows could be already passed to f.
+	return sn.execOnConn("sampleRows_v2", fOnConn, deadline)
+}
+
+func (sn *storageNode) tail(requestData []byte, deadline searchutils.Deadline, stopCh <-chan struct{}, subscribed func(),
+	f func(rows, dropped []storage.TailRow) error) error {
+	fOnConn := func(bc *handshake.BufferedConn) error {
+		return sn.tailOnConn(bc, requestData, stopCh, subscribed, f)
+	}
+	// Tail requests aren't limited by concurrentQueriesCh and -search.storageTimeout, since they may last for long time.
+	// Do not retry on errors, since some rows could be already passed to f.
+	return sn.execOnConnWithStorageTimeout("tail_v2", fOnConn, deadline, 0)
+}
+
+func (sn *storageNode) getLabelValues(accountID, projectID uint32, tr storage.TimeRange, labelName string, deadline searchutils.Deadline) ([]string, error) {
 	var labelValues []string
 	f := func(bc *handshake.BufferedConn) error {
-		lvs, err := sn.getLabelValuesOnConn(bc, accountID, projectID, labelName)
+		lvs, err := sn.getLabelValuesOnConn(bc, accountID, projectID, tr, labelName)
 		if err != nil {
 			return err
 		}
 		labelValues = lvs
 		return nil
 	}
-	if err := sn.execOnConn("labelValues_v2", f, deadline); err != nil {
+	if err := sn.execOnConn("labelValues_v3", f, deadline); err != nil {
 		// Try again before giving up.
 		labelValues = nil
-		if err = sn.execOnConn("labelValues_v2", f, deadline); err != nil {
+		if err = sn.execOnConn("labelValues_v3", f, deadline); err != nil {
 			return nil, err
 		}
 	}
@@ -1360,22 +1894,53 @@ func (sn *storageNode) getSeriesCount(accountID, projectID uint32, deadline sear
 	return n, nil
 }
 
-func (sn *storageNode) processSearchQuery(requestData []byte, processBlock func(mb *storage.MetricBlock) error, deadline searchutils.Deadline) error {
+func (sn *storageNode) processSearchQuery(qt *querytracer.Tracer, requestData []byte, qs *QueryStats, processBlock func(mb *storage.MetricBlock) error,
+	deadline searchutils.Deadline) error {
 	var blocksRead int
 	f := func(bc *handshake.BufferedConn) error {
-		n, err := sn.processSearchQueryOnConn(bc, requestData, processBlock)
+		n, err := sn.processSearchQueryOnConn(qt, bc, requestData, qs, processBlock, deadline)
 		if err != nil {
 			return err
 		}
 		blocksRead = n
 		return nil
 	}
-	if err := sn.execOnConn("search_v5", f, deadline); err != nil && blocksRead == 0 {
+	if err := sn.execOnConn("search_v8", f, deadline); err != nil && blocksRead == 0 {
 		// Try again before giving up if zero blocks read on the previous attempt.
-		if err = sn.execOnConn("search_v5", f, deadline); err != nil {
+		if err = sn.execOnConn("search_v8", f, deadline); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadValuesDict obtains the dictionary with the given id from sn and registers it via storage.AddValuesDict.
+func (sn *storageNode) loadValuesDict(id uint64, deadline searchutils.Deadline) error {
+	sn.valuesDictRequests.Inc()
+	var data []byte
+	f := func(bc *handshake.BufferedConn) error {
+		buf, err := sn.getValuesDictOnConn(bc, id)
+		if err != nil {
 			return err
 		}
+		data = buf
+		return nil
 	}
+	// The request isn't limited by concurrentQueriesCh, since it is executed while the search request holds the slot.
+	if err := sn.execOnConnWithStorageTimeout("valuesDict_v1", f, deadline, *searchutils.StorageTimeout); err != nil {
+		sn.valuesDictRequestErrors.Inc()
+		return err
+	}
+	d, err := encodingext.NewDict(data)
+	if err != nil {
+		sn.valuesDictRequestErrors.Inc()
+		return fmt.Errorf("cannot load values dictionary with id=%016X from vmstorage %s: %w", id, sn.connPool.Addr(), err)
+	}
+	if d.ID() != id {
+		sn.valuesDictRequestErrors.Inc()
+		return fmt.Errorf("unexpected values dictionary obtained from vmstorage %s; got id=%016X; want id=%016X", sn.connPool.Addr(), d.ID(), id)
+	}
+	storage.AddValuesDict(d)
 	return nil
 }
 
@@ -1389,10 +1954,17 @@ func (sn *storageNode) execOnConn(rpcName string, f func(bc *handshake.BufferedC
 		<-sn.concurrentQueriesCh
 	}()
 
+	return sn.execOnConnWithStorageTimeout(rpcName, f, deadline, *searchutils.StorageTimeout)
+}
+
+// execOnConnWithStorageTimeout executes f on a connection to sn without limiting the number of concurrent queries.
+//
+// The remote deadline is limited to storageTimeout if it is positive.
+func (sn *storageNode) execOnConnWithStorageTimeout(rpcName string, f func(bc *handshake.BufferedConn) error, deadline searchutils.Deadline,
+	storageTimeout time.Duration) error {
 	d := time.Unix(int64(deadline.Deadline()), 0)
 	nowSecs := fasttime.UnixTimestamp()
 	currentTime := time.Unix(int64(nowSecs), 0)
-	storageTimeout := *searchutils.StorageTimeout
 	if storageTimeout > 0 {
 		dd := currentTime.Add(storageTimeout)
 		if dd.Sub(d) < 0 {
@@ -1408,6 +1980,9 @@ func (sn *storageNode) execOnConn(rpcName string, f func(bc *handshake.BufferedC
 	if timeout <= 0 {
 		return fmt.Errorf("request timeout reached: %s or -search.storageTimeout=%s", deadline.String(), storageTimeout.String())
 	}
+	if deadline.Canceled() {
+		return fmt.Errorf("cannot execute rpcName=%q: %s", rpcName, deadline.String())
+	}
 	bc, err := sn.connPool.Get()
 	if err != nil {
 		return fmt.Errorf("cannot obtain connection from a pool: %w", err)
@@ -1435,7 +2010,15 @@ func (sn *storageNode) execOnConn(rpcName string, f func(bc *handshake.BufferedC
 		return fmt.Errorf("cannot send timeout=%d for rpcName=%q to the server: %w", timeout, rpcName, err)
 	}
 
-	if err := f(bc); err != nil {
+	cw := startConnCancelWatcher(bc, deadline.CancelCh())
+	err = f(bc)
+	if cw.stop() {
+		// The query has been canceled while f was running. Close the connection, so vmstorage stops processing the request.
+		_ = bc.Close()
+		sn.canceledRequests.Inc()
+		return fmt.Errorf("cannot execute rpcName=%q on vmstorage %q: %s", rpcName, bc.RemoteAddr(), deadline.String())
+	}
+	if err != nil {
 		remoteAddr := bc.RemoteAddr()
 		var er *errRemote
 		if errors.As(err, &er) {
@@ -1454,6 +2037,46 @@ func (sn *storageNode) execOnConn(rpcName string, f func(bc *handshake.BufferedC
 	return nil
 }
 
+// connCancelWatcher interrupts reads and writes on the connection when the query is canceled.
+type connCancelWatcher struct {
+	canceled uint32
+	stopCh   chan struct{}
+	wg       sync.WaitGroup
+}
+
+// startConnCancelWatcher starts watching for cancelCh closing while bc is in use.
+//
+// connCancelWatcher.stop must be called when bc is no longer in use.
+func startConnCancelWatcher(bc *handshake.BufferedConn, cancelCh <-chan struct{}) *connCancelWatcher {
+	cw := &connCancelWatcher{}
+	if cancelCh == nil {
+		return cw
+	}
+	cw.stopCh = make(chan struct{})
+	cw.wg.Add(1)
+	go func() {
+		defer cw.wg.Done()
+		select {
+		case <-cancelCh:
+			atomic.StoreUint32(&cw.canceled, 1)
+			// Unblock the pending read or write on bc.
+			_ = bc.SetDeadline(time.Now())
+		case <-cw.stopCh:
+		}
+	}()
+	return cw
+}
+
+// stop stops cw and returns true if the query has been canceled while the connection was in use.
+func (cw *connCancelWatcher) stop() bool {
+	if cw.stopCh == nil {
+		return false
+	}
+	close(cw.stopCh)
+	cw.wg.Wait()
+	return atomic.LoadUint32(&cw.canceled) != 0
+}
+
 type errRemote struct {
 	msg string
 }
@@ -1503,11 +2126,14 @@ func (sn *storageNode) deleteMetricsOnConn(bc *handshake.BufferedConn, requestDa
 
 const maxLabelSize = 16 * 1024 * 1024
 
-func (sn *storageNode) getLabelsOnConn(bc *handshake.BufferedConn, accountID, projectID uint32) ([]string, error) {
+func (sn *storageNode) getLabelsOnConn(bc *handshake.BufferedConn, accountID, projectID uint32, tr storage.TimeRange) ([]string, error) {
 	// Send the request to sn.
 	if err := sendAccountIDProjectID(bc, accountID, projectID); err != nil {
 		return nil, err
 	}
+	if err := sendTimeRange(bc, tr); err != nil {
+		return nil, err
+	}
 	if err := bc.Flush(); err != nil {
 		return nil, fmt.Errorf("cannot flush request to conn: %w", err)
 	}
@@ -1536,13 +2162,233 @@ func (sn *storageNode) getLabelsOnConn(bc *handshake.BufferedConn, accountID, pr
 	}
 }
 
+// maxStreamStatsSize is the maximum size of marshaled storage.StreamStats.
+const maxStreamStatsSize = 1024 * 1024
+
+func (sn *storageNode) getStreamStatsOnConn(bc *handshake.BufferedConn, requestData []byte) ([]storage.StreamStats, error) {
+	// Send the request to sn.
+	if err := writeBytes(bc, requestData); err != nil {
+		return nil, fmt.Errorf("cannot write requestData: %w", err)
+	}
+	if err := bc.Flush(); err != nil {
+		return nil, fmt.Errorf("cannot flush request to conn: %w", err)
+	}
+
+	// Read response error.
+	buf, err := readBytes(nil, bc, maxErrorMessageSize)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read error message: %w", err)
+	}
+	if len(buf) > 0 {
+		return nil, newErrRemote(buf)
+	}
+
+	// Read response
+	var sss []storage.StreamStats
+	for {
+		buf, err = readBytes(buf[:0], bc, maxStreamStatsSize)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read StreamStats: %w", err)
+		}
+		if len(buf) == 0 {
+			// Reached the end of the response
+			return sss, nil
+		}
+		sss = append(sss, storage.StreamStats{})
+		ss := &sss[len(sss)-1]
+		tail, err := ss.Unmarshal(buf)
+		if err != nil {
+			return nil, fmt.Errorf("cannot unmarshal StreamStats: %w", err)
+		}
+		if len(tail) > 0 {
+			return nil, fmt.Errorf("non-empty tail after unmarshaling StreamStats: (len=%d) %q", len(tail), tail)
+		}
+	}
+}
+
+// maxSampledBlockSize is the maximum size of marshaled storage.SampledBlock.
+const maxSampledBlockSize = 64 * 1024 * 1024
+
+func (sn *storageNode) sampleRowsOnConn(bc *handshake.BufferedConn, requestData []byte, f func(sb *storage.SampledBlock) error) error {
+	// Send the request to sn.
+	if err := writeBytes(bc, requestData); err != nil {
+		return fmt.Errorf("cannot write requestData: %w", err)
+	}
+	if err := bc.Flush(); err != nil {
+		return fmt.Errorf("cannot flush request to conn: %w", err)
+	}
+
+	// Read response error.
+	buf, err := readBytes(nil, bc, maxErrorMessageSize)
+	if err != nil {
+		return fmt.Errorf("cannot read error message: %w", err)
+	}
+	if len(buf) > 0 {
+		return newErrRemote(buf)
+	}
+
+	// Read response
+	var sb storage.SampledBlock
+	for {
+		buf, err = readBytes(buf[:0], bc, maxSampledBlockSize)
+		if err != nil {
+			return fmt.Errorf("cannot read SampledBlock: %w", err)
+		}
+		if len(buf) == 0 {
+			// Reached the end of the response
+			return nil
+		}
+		tail, err := sb.Unmarshal(buf)
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal SampledBlock: %w", err)
+		}
+		if len(tail) > 0 {
+			return fmt.Errorf("non-empty tail after unmarshaling SampledBlock: (len=%d) %q", len(tail), tail)
+		}
+		sn.metricBlocksRead.Inc()
+		sn.metricRowsRead.Add(sb.RowsCount())
+		if err := f(&sb); err != nil {
+			return err
+		}
+	}
+}
+
+// maxTailBatchSize is the maximum size of a batch of tail rows.
+const maxTailBatchSize = 256 * 1024 * 1024
+
+func (sn *storageNode) tailOnConn(bc *handshake.BufferedConn, requestData []byte, stopCh <-chan struct{}, subscribed func(),
+	f func(rows, dropped []storage.TailRow) error) error {
+	// Send the request to sn.
+	if err := writeBytes(bc, requestData); err != nil {
+		return fmt.Errorf("cannot write requestData: %w", err)
+	}
+	if err := bc.Flush(); err != nil {
+		return fmt.Errorf("cannot flush request to conn: %w", err)
+	}
+
+	// Read response error.
+	buf, err := readBytes(nil, bc, maxErrorMessageSize)
+	if err != nil {
+		return fmt.Errorf("cannot read error message: %w", err)
+	}
+	if len(buf) > 0 {
+		return newErrRemote(buf)
+	}
+	// vmstorage sends empty error message after subscribing to the added rows.
+	subscribed()
+
+	// Interrupt reading the response when stopCh is closed.
+	// The connection is closed by the caller after that, so vmstorage stops the tail.
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	go func() {
+		select {
+		case <-stopCh:
+			_ = bc.SetReadDeadline(time.Now())
+		case <-doneCh:
+		}
+	}()
+
+	// Read response
+	var rows, dropped []storage.TailRow
+	for {
+		buf, err = readBytes(buf[:0], bc, maxTailBatchSize)
+		select {
+		case <-stopCh:
+			return errTailStopped
+		default:
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read tail rows: %w", err)
+		}
+		if len(buf) == 0 {
+			// Reached the end of the response
+			return nil
+		}
+		rows, dropped, err = unmarshalTailBatch(rows[:0], dropped[:0], buf)
+		if err != nil {
+			return err
+		}
+		if err := f(rows, dropped); err != nil {
+			return err
+		}
+	}
+}
+
+func unmarshalTailBatch(dstRows, dstDropped []storage.TailRow, src []byte) ([]storage.TailRow, []storage.TailRow, error) {
+	var err error
+	dstRows, src, err = unmarshalTailRows(dstRows, src)
+	if err != nil {
+		return dstRows, dstDropped, fmt.Errorf("cannot unmarshal tail rows: %w", err)
+	}
+	dstDropped, src, err = unmarshalTailRows(dstDropped, src)
+	if err != nil {
+		return dstRows, dstDropped, fmt.Errorf("cannot unmarshal dropped tail rows: %w", err)
+	}
+	if len(src) > 0 {
+		return dstRows, dstDropped, fmt.Errorf("non-empty tail after unmarshaling tail rows: (len=%d) %q", len(src), src)
+	}
+	return dstRows, dstDropped, nil
+}
+
+func unmarshalTailRows(dst []storage.TailRow, src []byte) ([]storage.TailRow, []byte, error) {
+	tail, n, err := encoding.UnmarshalVarUint64(src)
+	if err != nil {
+		return dst, tail, fmt.Errorf("cannot unmarshal rows count: %w", err)
+	}
+	for i := uint64(0); i < n; i++ {
+		dst = append(dst, storage.TailRow{})
+		tail, err = dst[len(dst)-1].Unmarshal(tail)
+		if err != nil {
+			return dst, tail, err
+		}
+	}
+	return dst, tail, nil
+}
+
+// maxTenantSize is the maximum size of `accountID:projectID` string.
+const maxTenantSize = 64
+
+func (sn *storageNode) getTenantsOnConn(bc *handshake.BufferedConn) ([]string, error) {
+	// Send the request to sn.
+	if err := bc.Flush(); err != nil {
+		return nil, fmt.Errorf("cannot flush request to conn: %w", err)
+	}
+
+	// Read response error.
+	buf, err := readBytes(nil, bc, maxErrorMessageSize)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read error message: %w", err)
+	}
+	if len(buf) > 0 {
+		return nil, newErrRemote(buf)
+	}
+
+	// Read response
+	var tenants []string
+	for {
+		buf, err = readBytes(buf[:0], bc, maxTenantSize)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read tenants: %w", err)
+		}
+		if len(buf) == 0 {
+			// Reached the end of the response
+			return tenants, nil
+		}
+		tenants = append(tenants, string(buf))
+	}
+}
+
 const maxLabelValueSize = 16 * 1024 * 1024
 
-func (sn *storageNode) getLabelValuesOnConn(bc *handshake.BufferedConn, accountID, projectID uint32, labelName string) ([]string, error) {
+func (sn *storageNode) getLabelValuesOnConn(bc *handshake.BufferedConn, accountID, projectID uint32, tr storage.TimeRange, labelName string) ([]string, error) {
 	// Send the request to sn.
 	if err := sendAccountIDProjectID(bc, accountID, projectID); err != nil {
 		return nil, err
 	}
+	if err := sendTimeRange(bc, tr); err != nil {
+		return nil, err
+	}
 	if err := writeBytes(bc, []byte(labelName)); err != nil {
 		return nil, fmt.Errorf("cannot send labelName=%q to conn: %w", labelName, err)
 	}
@@ -1589,11 +2435,8 @@ func (sn *storageNode) getTagValueSuffixesOnConn(bc *handshake.BufferedConn, acc
 	if err := sendAccountIDProjectID(bc, accountID, projectID); err != nil {
 		return nil, err
 	}
-	if err := writeUint64(bc, uint64(tr.MinTimestamp)); err != nil {
-		return nil, fmt.Errorf("cannot send minTimestamp=%d to conn: %w", tr.MinTimestamp, err)
-	}
-	if err := writeUint64(bc, uint64(tr.MaxTimestamp)); err != nil {
-		return nil, fmt.Errorf("cannot send maxTimestamp=%d to conn: %w", tr.MaxTimestamp, err)
+	if err := sendTimeRange(bc, tr); err != nil {
+		return nil, err
 	}
 	if err := writeBytes(bc, []byte(tagKey)); err != nil {
 		return nil, fmt.Errorf("cannot send tagKey=%q to conn: %w", tagKey, err)
@@ -1776,12 +2619,55 @@ func (sn *storageNode) getSeriesCountOnConn(bc *handshake.BufferedConn, accountI
 // maxMetricBlockSize is the maximum size of serialized MetricBlock.
 const maxMetricBlockSize = 1024 * 1024
 
+// maxQueryTraceSize is the maximum size of query trace returned from vmstorage.
+const maxQueryTraceSize = 1024 * 1024
+
 // maxErrorMessageSize is the maximum size of error message received
 // from vmstorage.
 const maxErrorMessageSize = 64 * 1024
 
-func (sn *storageNode) processSearchQueryOnConn(bc *handshake.BufferedConn, requestData []byte, processBlock func(mb *storage.MetricBlock) error) (int, error) {
+// maxValuesDictSize is the maximum size of values dictionary received from vmstorage.
+const maxValuesDictSize = 16 * 1024 * 1024
+
+func (sn *storageNode) getValuesDictOnConn(bc *handshake.BufferedConn, id uint64) ([]byte, error) {
 	// Send the request to sn.
+	if err := writeUint64(bc, id); err != nil {
+		return nil, fmt.Errorf("cannot send id=%016X to conn: %w", id, err)
+	}
+	if err := bc.Flush(); err != nil {
+		return nil, fmt.Errorf("cannot flush valuesDict args to conn: %w", err)
+	}
+
+	// Read response error.
+	buf, err := readBytes(nil, bc, maxErrorMessageSize)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read error message: %w", err)
+	}
+	if len(buf) > 0 {
+		return nil, newErrRemote(buf)
+	}
+
+	// Read response
+	buf, err = readBytes(buf[:0], bc, maxValuesDictSize)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read values dictionary: %w", err)
+	}
+	return buf, nil
+}
+
+// processSearchQueryOnConn executes the search query on bc and passes the received blocks to processBlock.
+//
+// Stats for the received blocks are registered in qs.
+func (sn *storageNode) processSearchQueryOnConn(qt *querytracer.Tracer, bc *handshake.BufferedConn, requestData []byte, qs *QueryStats,
+	processBlock func(mb *storage.MetricBlock) error, deadline searchutils.Deadline) (int, error) {
+	// Send the request to sn.
+	traceEnabled := byte(0)
+	if qt.Enabled() {
+		traceEnabled = 1
+	}
+	if err := writeByte(bc, traceEnabled); err != nil {
+		return 0, fmt.Errorf("cannot write trace flag: %w", err)
+	}
 	if err := writeBytes(bc, requestData); err != nil {
 		return 0, fmt.Errorf("cannot write requestData: %w", err)
 	}
@@ -1803,6 +2689,11 @@ func (sn *storageNode) processSearchQueryOnConn(bc *handshake.BufferedConn, requ
 
 	// Read response. It may consist of multiple MetricBlocks.
 	blocksRead := 0
+	rowsRead := 0
+	bytesRead := 0
+	defer func() {
+		qs.addStorageNodeStats(sn.connPool.Addr(), uint64(blocksRead), uint64(rowsRead), uint64(bytesRead))
+	}()
 	var mb storage.MetricBlock
 	for {
 		buf, err = readBytes(buf[:0], bc, maxMetricBlockSize)
@@ -1810,17 +2701,37 @@ func (sn *storageNode) processSearchQueryOnConn(bc *handshake.BufferedConn, requ
 			return blocksRead, fmt.Errorf("cannot read MetricBlock #%d: %w", blocksRead, err)
 		}
 		if len(buf) == 0 {
-			// Reached the end of the response
+			// Reached the end of the response. Read the query trace.
+			buf, err = readBytes(buf[:0], bc, maxQueryTraceSize)
+			if err != nil {
+				return blocksRead, fmt.Errorf("cannot read query trace: %w", err)
+			}
+			if err := qt.AddJSON(buf); err != nil {
+				return blocksRead, fmt.Errorf("cannot parse query trace: %w", err)
+			}
 			return blocksRead, nil
 		}
 		tail, err := mb.Unmarshal(buf)
 		if err != nil {
 			return blocksRead, fmt.Errorf("cannot unmarshal MetricBlock #%d: %w", blocksRead, err)
 		}
+		if id := mb.Block.MissingValuesDictID(); id != 0 {
+			// The block values are compressed with the dictionary, which isn't known yet.
+			// Obtain it from sn and unmarshal the block again.
+			if err := sn.loadValuesDict(id, deadline); err != nil {
+				return blocksRead, fmt.Errorf("cannot obtain values dictionary for MetricBlock #%d: %w", blocksRead, err)
+			}
+			tail, err = mb.Unmarshal(buf)
+			if err != nil {
+				return blocksRead, fmt.Errorf("cannot unmarshal MetricBlock #%d: %w", blocksRead, err)
+			}
+		}
 		if len(tail) != 0 {
 			return blocksRead, fmt.Errorf("non-empty tail after unmarshaling MetricBlock #%d: (len=%d) %q", blocksRead, len(tail), tail)
 		}
 		blocksRead++
+		rowsRead += mb.Block.RowsCount()
+		bytesRead += len(buf)
 		sn.metricBlocksRead.Inc()
 		sn.metricRowsRead.Add(mb.Block.RowsCount())
 		if err := processBlock(&mb); err != nil {
@@ -1867,6 +2778,16 @@ func sendAccountIDProjectID(bc *handshake.BufferedConn, accountID, projectID uin
 	return nil
 }
 
+func sendTimeRange(bc *handshake.BufferedConn, tr storage.TimeRange) error {
+	if err := writeUint64(bc, uint64(tr.MinTimestamp)); err != nil {
+		return fmt.Errorf("cannot send minTimestamp=%d to conn: %w", tr.MinTimestamp, err)
+	}
+	if err := writeUint64(bc, uint64(tr.MaxTimestamp)); err != nil {
+		return fmt.Errorf("cannot send maxTimestamp=%d to conn: %w", tr.MaxTimestamp, err)
+	}
+	return nil
+}
+
 func readBytes(buf []byte, bc *handshake.BufferedConn, maxDataSize int) ([]byte, error) {
 	buf = bytesutil.Resize(buf, 8)
 	if n, err := io.ReadFull(bc, buf); err != nil {
@@ -1906,7 +2827,7 @@ func InitStorageNodes(addrs []string) {
 	for _, addr := range addrs {
 		sn := &storageNode{
 			// There is no need in requests compression, since they are usually very small.
-			connPool: netutil.NewConnPool("vmselect", addr, handshake.VMSelectClient, 0),
+			connPool: netutil.NewConnPool("vmselect", addr, clustertls.ClientHandshakeFunc(addr, handshake.VMSelectClient), 0),
 
 			concurrentQueriesCh: make(chan struct{}, maxConcurrentQueriesPerStorageNode),
 
@@ -1914,6 +2835,14 @@ func InitStorageNodes(addrs []string) {
 			deleteSeriesRequestErrors:     metrics.NewCounter(fmt.Sprintf(`vm_request_errors_total{action="deleteSeries", type="rpcClient", name="vmselect", addr=%q}`, addr)),
 			labelsRequests:                metrics.NewCounter(fmt.Sprintf(`vm_requests_total{action="labels", type="rpcClient", name="vmselect", addr=%q}`, addr)),
 			labelsRequestErrors:           metrics.NewCounter(fmt.Sprintf(`vm_request_errors_total{action="labels", type="rpcClient", name="vmselect", addr=%q}`, addr)),
+			tenantsRequests:               metrics.NewCounter(fmt.Sprintf(`vm_requests_total{action="tenants", type="rpcClient", name="vmselect", addr=%q}`, addr)),
+			tenantsRequestErrors:          metrics.NewCounter(fmt.Sprintf(`vm_request_errors_total{action="tenants", type="rpcClient", name="vmselect", addr=%q}`, addr)),
+			streamStatsRequests:           metrics.NewCounter(fmt.Sprintf(`vm_requests_total{action="streamStats", type="rpcClient", name="vmselect", addr=%q}`, addr)),
+			streamStatsRequestErrors:      metrics.NewCounter(fmt.Sprintf(`vm_request_errors_total{action="streamStats", type="rpcClient", name="vmselect", addr=%q}`, addr)),
+			sampleRowsRequests:            metrics.NewCounter(fmt.Sprintf(`vm_requests_total{action="sampleRows", type="rpcClient", name="vmselect", addr=%q}`, addr)),
+			sampleRowsRequestErrors:       metrics.NewCounter(fmt.Sprintf(`vm_request_errors_total{action="sampleRows", type="rpcClient", name="vmselect", addr=%q}`, addr)),
+			tailRequests:                  metrics.NewCounter(fmt.Sprintf(`vm_requests_total{action="tail", type="rpcClient", name="vmselect", addr=%q}`, addr)),
+			tailRequestErrors:             metrics.NewCounter(fmt.Sprintf(`vm_request_errors_total{action="tail", type="rpcClient", name="vmselect", addr=%q}`, addr)),
 			labelValuesRequests:           metrics.NewCounter(fmt.Sprintf(`vm_requests_total{action="labelValues", type="rpcClient", name="vmselect", addr=%q}`, addr)),
 			labelValuesRequestErrors:      metrics.NewCounter(fmt.Sprintf(`vm_request_errors_total{action="labelValues", type="rpcClient", name="vmselect", addr=%q}`, addr)),
 			labelEntriesRequests:          metrics.NewCounter(fmt.Sprintf(`vm_requests_total{action="labelEntries", type="rpcClient", name="vmselect", addr=%q}`, addr)),
@@ -1924,8 +2853,11 @@ func InitStorageNodes(addrs []string) {
 			tsdbStatusRequestErrors:       metrics.NewCounter(fmt.Sprintf(`vm_request_errors_total{action="tsdbStatus", type="rpcClient", name="vmselect", addr=%q}`, addr)),
 			seriesCountRequests:           metrics.NewCounter(fmt.Sprintf(`vm_requests_total{action="seriesCount", type="rpcClient", name="vmselect", addr=%q}`, addr)),
 			seriesCountRequestErrors:      metrics.NewCounter(fmt.Sprintf(`vm_request_errors_total{action="seriesCount", type="rpcClient", name="vmselect", addr=%q}`, addr)),
+			valuesDictRequests:            metrics.NewCounter(fmt.Sprintf(`vm_requests_total{action="valuesDict", type="rpcClient", name="vmselect", addr=%q}`, addr)),
+			valuesDictRequestErrors:       metrics.NewCounter(fmt.Sprintf(`vm_request_errors_total{action="valuesDict", type="rpcClient", name="vmselect", addr=%q}`, addr)),
 			searchRequests:                metrics.NewCounter(fmt.Sprintf(`vm_requests_total{action="search", type="rpcClient", name="vmselect", addr=%q}`, addr)),
 			searchRequestErrors:           metrics.NewCounter(fmt.Sprintf(`vm_request_errors_total{action="search", type="rpcClient", name="vmselect", addr=%q}`, addr)),
+			canceledRequests:              metrics.NewCounter(fmt.Sprintf(`vm_canceled_requests_total{type="rpcClient", name="vmselect", addr=%q}`, addr)),
 			metricBlocksRead:              metrics.NewCounter(fmt.Sprintf(`vm_metric_blocks_read_total{name="vmselect", addr=%q}`, addr)),
 			metricRowsRead:                metrics.NewCounter(fmt.Sprintf(`vm_metric_rows_read_total{name="vmselect", addr=%q}`, addr)),
 		}
@@ -1943,6 +2875,10 @@ func Stop() {
 
 var (
 	partialLabelsResults       = metrics.NewCounter(`vm_partial_labels_results_total{name="vmselect"}`)
+	partialTenantsResults      = metrics.NewCounter(`vm_partial_tenants_results_total{name="vmselect"}`)
+	partialStreamStatsResults  = metrics.NewCounter(`vm_partial_stream_stats_results_total{name="vmselect"}`)
+	partialSampleRowsResults   = metrics.NewCounter(`vm_partial_sample_rows_results_total{name="vmselect"}`)
+	partialTailResults         = metrics.NewCounter(`vm_partial_tail_results_total{name="vmselect"}`)
 	partialLabelValuesResults  = metrics.NewCounter(`vm_partial_label_values_results_total{name="vmselect"}`)
 	partialLabelEntriesResults = metrics.NewCounter(`vm_partial_label_entries_results_total{name="vmselect"}`)
 	partialTSDBStatusResults   = metrics.NewCounter(`vm_partial_tsdb_status_results_total{name="vmselect"}`)
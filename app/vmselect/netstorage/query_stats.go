@@ -0,0 +1,276 @@
+package netstorage
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// QueryStats contains per-query execution statistics.
+//
+// QueryStats may be updated concurrently. All the methods may be called on nil QueryStats,
+// so the caller may pass nil QueryStats if it doesn't need query statistics.
+type QueryStats struct {
+	// Atomic counters must go at the top of the structure in order to properly align by 8 bytes on 32-bit archs.
+
+	// blocksFetched is the number of blocks received from vmstorage nodes.
+	blocksFetched uint64
+
+	// rowsFetched is the number of rows in the blocks received from vmstorage nodes.
+	rowsFetched uint64
+
+	// bytesFetched is the size of compressed blocks received from vmstorage nodes.
+	bytesFetched uint64
+
+	// fetchDuration is the duration in nanoseconds spent on fetching blocks from vmstorage nodes.
+	fetchDuration uint64
+
+	// linesProcessed is the number of unpacked rows in the selected time range.
+	linesProcessed uint64
+
+	// bytesProcessed is the size of unpacked rows in the selected time range.
+	bytesProcessed uint64
+
+	// linesPostFilter is the number of rows left after applying structured metadata filters.
+	linesPostFilter uint64
+
+	// linesFilteredOut is the number of rows dropped by line filters after applying structured metadata filters.
+	linesFilteredOut uint64
+
+	// subqueries is the number of ProcessSearchQuery calls for the query.
+	subqueries uint64
+
+	// storageNodesPending is the number of in-flight search requests to vmstorage nodes.
+	storageNodesPending int64
+
+	startTime     time.Time
+	evalDuration  time.Duration
+	queueDuration time.Duration
+
+	// storageNodesStats contains stats for blocks fetched from every vmstorage node.
+	storageNodesStatsLock sync.Mutex
+	storageNodesStats     map[string]*StorageNodeStats
+
+	// maxSeries and maxBytesRead are set via SetLimits.
+	maxSeries    int
+	maxBytesRead int64
+}
+
+// NewQueryStats returns new QueryStats for the query started at startTime.
+func NewQueryStats(startTime time.Time) *QueryStats {
+	return &QueryStats{
+		startTime: startTime,
+	}
+}
+
+// SetLimits sets limits for the query.
+//
+// maxSeries limits the number of streams matched by every ProcessSearchQuery call for the query,
+// while maxBytesRead limits the size of blocks fetched from vmstorage nodes by all the ProcessSearchQuery calls for the query.
+// Zero values disable the corresponding limits. SetLimits must be called before passing qs to ProcessSearchQuery.
+func (qs *QueryStats) SetLimits(maxSeries int, maxBytesRead int64) {
+	qs.maxSeries = maxSeries
+	qs.maxBytesRead = maxBytesRead
+}
+
+// SetQueueDuration sets the duration spent by the query in the query scheduler queue.
+//
+// SetQueueDuration must be called before passing qs to ProcessSearchQuery.
+func (qs *QueryStats) SetQueueDuration(d time.Duration) {
+	qs.queueDuration = d
+}
+
+// checkSeries returns an error if the number of streams matched by a single search exceeds the limit for the query.
+func (qs *QueryStats) checkSeries(n int) error {
+	if qs == nil || qs.maxSeries <= 0 || n <= qs.maxSeries {
+		return nil
+	}
+	maxQuerySeriesExceeded.Inc()
+	return &httpserver.ErrorWithStatusCode{
+		Err: fmt.Errorf("maximum number of series (%d) reached for a single query; "+
+			"consider adding more specific stream selectors or reducing the time range of the query", qs.maxSeries),
+		StatusCode: http.StatusBadRequest,
+	}
+}
+
+// checkBytesRead returns an error if the size of blocks fetched for the query exceeds the limit for the query.
+func (qs *QueryStats) checkBytesRead() error {
+	if qs == nil || qs.maxBytesRead <= 0 {
+		return nil
+	}
+	n := atomic.LoadUint64(&qs.bytesFetched)
+	if n <= uint64(qs.maxBytesRead) {
+		return nil
+	}
+	maxQueryBytesReadExceeded.Inc()
+	return &httpserver.ErrorWithStatusCode{
+		Err: fmt.Errorf("the query would read too many bytes (query: %d bytes, limit: %d bytes); "+
+			"consider adding more specific stream selectors or reducing the time range of the query", n, qs.maxBytesRead),
+		StatusCode: http.StatusBadRequest,
+	}
+}
+
+var (
+	maxQuerySeriesExceeded    = metrics.NewCounter(`vm_query_limit_exceeded_total{limit="max_query_series"}`)
+	maxQueryBytesReadExceeded = metrics.NewCounter(`vm_query_limit_exceeded_total{limit="max_query_bytes_read"}`)
+)
+
+func (qs *QueryStats) addFetchedBlock(rowsCount, size int) {
+	if qs == nil {
+		return
+	}
+	atomic.AddUint64(&qs.blocksFetched, 1)
+	atomic.AddUint64(&qs.rowsFetched, uint64(rowsCount))
+	atomic.AddUint64(&qs.bytesFetched, uint64(size))
+}
+
+func (qs *QueryStats) addStorageNodeStats(addr string, blocksFetched, rowsFetched, bytesFetched uint64) {
+	if qs == nil || blocksFetched == 0 {
+		return
+	}
+	qs.storageNodesStatsLock.Lock()
+	sns := qs.storageNodesStats[addr]
+	if sns == nil {
+		if qs.storageNodesStats == nil {
+			qs.storageNodesStats = make(map[string]*StorageNodeStats)
+		}
+		sns = &StorageNodeStats{
+			Addr: addr,
+		}
+		qs.storageNodesStats[addr] = sns
+	}
+	sns.BlocksFetched += blocksFetched
+	sns.RowsFetched += rowsFetched
+	sns.BytesFetched += bytesFetched
+	qs.storageNodesStatsLock.Unlock()
+}
+
+func (qs *QueryStats) addFetchDuration(d time.Duration) {
+	if qs == nil {
+		return
+	}
+	atomic.AddUint64(&qs.subqueries, 1)
+	atomic.AddUint64(&qs.fetchDuration, uint64(d))
+}
+
+func (qs *QueryStats) addPendingStorageNodes(n int) {
+	if qs == nil {
+		return
+	}
+	atomic.AddInt64(&qs.storageNodesPending, int64(n))
+}
+
+func (qs *QueryStats) addProcessedResult(rs *Result) {
+	if qs == nil {
+		return
+	}
+	n := 0
+	for _, data := range rs.Datas {
+		n += len(data)
+	}
+	atomic.AddUint64(&qs.linesProcessed, uint64(len(rs.Timestamps)))
+	atomic.AddUint64(&qs.bytesProcessed, uint64(n))
+}
+
+// AddPostFilterLines adds n lines left after filtering to qs.
+func (qs *QueryStats) AddPostFilterLines(n int) {
+	if qs == nil {
+		return
+	}
+	atomic.AddUint64(&qs.linesPostFilter, uint64(n))
+}
+
+// AddFilteredOutLines adds n lines dropped by line filters such as `|=` or `|~` to qs.
+//
+// Such lines are excluded from the lines left after filtering.
+func (qs *QueryStats) AddFilteredOutLines(n int) {
+	if qs == nil {
+		return
+	}
+	atomic.AddUint64(&qs.linesFilteredOut, uint64(n))
+}
+
+// FinishEval must be called when the query evaluation is finished and the response rendering starts.
+func (qs *QueryStats) FinishEval() {
+	if qs == nil {
+		return
+	}
+	qs.evalDuration = time.Since(qs.startTime)
+}
+
+// StorageNodeStats contains stats for blocks fetched from a single vmstorage node.
+type StorageNodeStats struct {
+	Addr          string
+	BlocksFetched uint64
+	RowsFetched   uint64
+	BytesFetched  uint64
+}
+
+// QueryStatsSnapshot is a point-in-time copy of QueryStats.
+type QueryStatsSnapshot struct {
+	BlocksFetched   uint64
+	RowsFetched     uint64
+	BytesFetched    uint64
+	LinesProcessed  uint64
+	BytesProcessed  uint64
+	LinesPostFilter uint64
+	Subqueries      uint64
+
+	// StorageNodesPending is the number of in-flight search requests to vmstorage nodes.
+	StorageNodesPending int64
+
+	// StorageNodes contains stats for blocks fetched from every vmstorage node sorted by Addr.
+	StorageNodes []StorageNodeStats
+
+	QueueDuration  time.Duration
+	FetchDuration  time.Duration
+	EvalDuration   time.Duration
+	RenderDuration time.Duration
+	ExecDuration   time.Duration
+}
+
+// Snapshot returns the current state of qs.
+//
+// The time passed since FinishEval call is accounted as RenderDuration.
+func (qs *QueryStats) Snapshot() *QueryStatsSnapshot {
+	var s QueryStatsSnapshot
+	if qs == nil {
+		return &s
+	}
+	s.BlocksFetched = atomic.LoadUint64(&qs.blocksFetched)
+	s.RowsFetched = atomic.LoadUint64(&qs.rowsFetched)
+	s.BytesFetched = atomic.LoadUint64(&qs.bytesFetched)
+	s.LinesProcessed = atomic.LoadUint64(&qs.linesProcessed)
+	s.BytesProcessed = atomic.LoadUint64(&qs.bytesProcessed)
+	s.LinesPostFilter = atomic.LoadUint64(&qs.linesPostFilter)
+	if n := atomic.LoadUint64(&qs.linesFilteredOut); n < s.LinesPostFilter {
+		s.LinesPostFilter -= n
+	} else {
+		s.LinesPostFilter = 0
+	}
+	s.Subqueries = atomic.LoadUint64(&qs.subqueries)
+	s.StorageNodesPending = atomic.LoadInt64(&qs.storageNodesPending)
+	s.FetchDuration = time.Duration(atomic.LoadUint64(&qs.fetchDuration))
+	s.QueueDuration = qs.queueDuration
+	qs.storageNodesStatsLock.Lock()
+	for _, sns := range qs.storageNodesStats {
+		s.StorageNodes = append(s.StorageNodes, *sns)
+	}
+	qs.storageNodesStatsLock.Unlock()
+	sort.Slice(s.StorageNodes, func(i, j int) bool {
+		return s.StorageNodes[i].Addr < s.StorageNodes[j].Addr
+	})
+	s.ExecDuration = time.Since(qs.startTime)
+	s.EvalDuration = qs.evalDuration
+	if s.EvalDuration == 0 {
+		s.EvalDuration = s.ExecDuration
+	}
+	s.RenderDuration = s.ExecDuration - s.EvalDuration
+	return &s
+}
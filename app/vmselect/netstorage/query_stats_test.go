@@ -0,0 +1,138 @@
+package netstorage
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+)
+
+func TestQueryStats(t *testing.T) {
+	// All the methods must work on nil QueryStats.
+	var qsNil *QueryStats
+	qsNil.addFetchedBlock(10, 100)
+	qsNil.addFetchDuration(time.Second)
+	qsNil.addProcessedResult(&Result{})
+	qsNil.AddPostFilterLines(1)
+	qsNil.AddFilteredOutLines(1)
+	qsNil.addStorageNodeStats("foo:8401", 1, 10, 100)
+	qsNil.FinishEval()
+	if s := qsNil.Snapshot(); !reflect.DeepEqual(s, &QueryStatsSnapshot{}) {
+		t.Fatalf("unexpected non-empty snapshot for nil QueryStats: %+v", s)
+	}
+
+	qs := NewQueryStats(time.Now().Add(-time.Second))
+	qs.SetQueueDuration(time.Millisecond)
+	qs.addFetchedBlock(10, 100)
+	qs.addFetchedBlock(5, 50)
+	qs.addFetchDuration(time.Millisecond)
+	qs.addProcessedResult(&Result{
+		Timestamps: []int64{1, 2, 3},
+		Datas:      [][]byte{[]byte("foo"), []byte("bar"), []byte("baz")},
+	})
+	qs.AddPostFilterLines(2)
+	qs.addStorageNodeStats("b:8401", 1, 5, 50)
+	qs.addStorageNodeStats("a:8401", 1, 10, 100)
+	qs.addStorageNodeStats("b:8401", 0, 0, 0)
+	qs.FinishEval()
+
+	s := qs.Snapshot()
+	if s.BlocksFetched != 2 {
+		t.Fatalf("unexpected BlocksFetched; got %d; want 2", s.BlocksFetched)
+	}
+	if s.RowsFetched != 15 {
+		t.Fatalf("unexpected RowsFetched; got %d; want 15", s.RowsFetched)
+	}
+	if s.BytesFetched != 150 {
+		t.Fatalf("unexpected BytesFetched; got %d; want 150", s.BytesFetched)
+	}
+	if s.LinesProcessed != 3 {
+		t.Fatalf("unexpected LinesProcessed; got %d; want 3", s.LinesProcessed)
+	}
+	if s.BytesProcessed != 9 {
+		t.Fatalf("unexpected BytesProcessed; got %d; want 9", s.BytesProcessed)
+	}
+	if s.LinesPostFilter != 2 {
+		t.Fatalf("unexpected LinesPostFilter; got %d; want 2", s.LinesPostFilter)
+	}
+	if s.Subqueries != 1 {
+		t.Fatalf("unexpected Subqueries; got %d; want 1", s.Subqueries)
+	}
+	storageNodesExpected := []StorageNodeStats{
+		{Addr: "a:8401", BlocksFetched: 1, RowsFetched: 10, BytesFetched: 100},
+		{Addr: "b:8401", BlocksFetched: 1, RowsFetched: 5, BytesFetched: 50},
+	}
+	if !reflect.DeepEqual(s.StorageNodes, storageNodesExpected) {
+		t.Fatalf("unexpected StorageNodes\ngot\n%+v\nwant\n%+v", s.StorageNodes, storageNodesExpected)
+	}
+	if s.QueueDuration != time.Millisecond {
+		t.Fatalf("unexpected QueueDuration; got %s; want %s", s.QueueDuration, time.Millisecond)
+	}
+	if s.FetchDuration != time.Millisecond {
+		t.Fatalf("unexpected FetchDuration; got %s; want %s", s.FetchDuration, time.Millisecond)
+	}
+	if s.EvalDuration < time.Second {
+		t.Fatalf("EvalDuration must be at least 1s; got %s", s.EvalDuration)
+	}
+	if s.ExecDuration != s.EvalDuration+s.RenderDuration {
+		t.Fatalf("ExecDuration must be equal to EvalDuration+RenderDuration; got %s vs %s+%s", s.ExecDuration, s.EvalDuration, s.RenderDuration)
+	}
+}
+
+func TestQueryStatsLimits(t *testing.T) {
+	expectLimitError := func(err error) {
+		t.Helper()
+		var esc *httpserver.ErrorWithStatusCode
+		if !errors.As(err, &esc) || esc.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expecting limit error with status code 400; got %v", err)
+		}
+	}
+
+	// Limits are disabled by default
+	var qsNil *QueryStats
+	if err := qsNil.checkSeries(1e6); err != nil {
+		t.Fatalf("unexpected error for nil QueryStats: %s", err)
+	}
+	qs := NewQueryStats(time.Now())
+	qs.addFetchedBlock(10, 1e6)
+	if err := qs.checkSeries(1e6); err != nil {
+		t.Fatalf("unexpected error without limits: %s", err)
+	}
+	if err := qs.checkBytesRead(); err != nil {
+		t.Fatalf("unexpected error without limits: %s", err)
+	}
+
+	// Bytes read limit
+	qs = NewQueryStats(time.Now())
+	qs.SetLimits(0, 100)
+	qs.addFetchedBlock(10, 100)
+	if err := qs.checkBytesRead(); err != nil {
+		t.Fatalf("unexpected error when reaching the limit: %s", err)
+	}
+	qs.addFetchedBlock(1, 1)
+	expectLimitError(qs.checkBytesRead())
+
+	// Series limit
+	qs = NewQueryStats(time.Now())
+	qs.SetLimits(2, 0)
+	tbfw := &tmpBlocksFileWrapper{
+		qs: qs,
+		m:  make(map[string][]tmpBlockAddr),
+	}
+	for _, metricName := range []string{"a", "b", "a", "b"} {
+		if err := tbfw.RegisterEmptyBlock(&storage.MetricBlock{MetricName: []byte(metricName)}); err != nil {
+			t.Fatalf("unexpected error for %d streams: %s", len(tbfw.m), err)
+		}
+	}
+	expectLimitError(tbfw.RegisterEmptyBlock(&storage.MetricBlock{MetricName: []byte("c")}))
+	if tbfw.limitErr == nil {
+		t.Fatalf("limitErr must be set after exceeding the limit")
+	}
+
+	// The first limit error is returned for the subsequent blocks
+	expectLimitError(tbfw.RegisterEmptyBlock(&storage.MetricBlock{MetricName: []byte("a")}))
+}
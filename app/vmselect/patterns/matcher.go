@@ -0,0 +1,53 @@
+package patterns
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Matcher matches log lines against a pattern with Placeholder tokens.
+//
+// Every Placeholder in the pattern matches arbitrary text including empty text.
+type Matcher struct {
+	// parts contains literal parts of the pattern between placeholders.
+	//
+	// The first and the last parts are empty if the pattern starts or ends with Placeholder.
+	parts [][]byte
+}
+
+// NewMatcher returns Matcher for the given pattern.
+func NewMatcher(pattern string) (*Matcher, error) {
+	if len(pattern) == 0 {
+		return nil, fmt.Errorf("pattern cannot be empty")
+	}
+	var parts [][]byte
+	for _, part := range strings.Split(pattern, Placeholder) {
+		parts = append(parts, []byte(part))
+	}
+	return &Matcher{
+		parts: parts,
+	}, nil
+}
+
+// Match returns true if line matches pm.
+func (pm *Matcher) Match(line []byte) bool {
+	parts := pm.parts
+	if len(parts) == 1 {
+		return bytes.Equal(line, parts[0])
+	}
+	prefix := parts[0]
+	suffix := parts[len(parts)-1]
+	if len(line) < len(prefix)+len(suffix) || !bytes.HasPrefix(line, prefix) || !bytes.HasSuffix(line, suffix) {
+		return false
+	}
+	line = line[len(prefix) : len(line)-len(suffix)]
+	for _, part := range parts[1 : len(parts)-1] {
+		n := bytes.Index(line, part)
+		if n < 0 {
+			return false
+		}
+		line = line[n+len(part):]
+	}
+	return true
+}
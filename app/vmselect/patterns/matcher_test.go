@@ -0,0 +1,35 @@
+package patterns
+
+import (
+	"testing"
+)
+
+func TestMatcher(t *testing.T) {
+	f := func(pattern, line string, resultExpected bool) {
+		t.Helper()
+		pm, err := NewMatcher(pattern)
+		if err != nil {
+			t.Fatalf("cannot create matcher for %q: %s", pattern, err)
+		}
+		if result := pm.Match([]byte(line)); result != resultExpected {
+			t.Fatalf("unexpected result for pattern=%q, line=%q; got %v; want %v", pattern, line, result, resultExpected)
+		}
+	}
+	f("foo bar", "foo bar", true)
+	f("foo bar", "foo baz", false)
+	f("<_>", "", true)
+	f("<_>", "anything", true)
+	f("GET <_> 200", "GET /api/users 200", true)
+	f("GET <_> 200", "GET /api/users 500", false)
+	f("GET <_> 200", "POST /api 200", false)
+	f("<_> error <_>", "fatal error occurred", true)
+	f("<_> error <_>", "fatal warning occurred", false)
+	f("a<_>a", "a", false)
+	f("a<_>a", "aa", true)
+	f("<_> x <_> y", "1 x 2 x 3 y", true)
+	f("<_> x <_> y", "1 x 2", false)
+
+	if _, err := NewMatcher(""); err == nil {
+		t.Fatalf("expecting non-nil error for empty pattern")
+	}
+}
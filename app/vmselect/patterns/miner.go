@@ -0,0 +1,269 @@
+package patterns
+
+import (
+	"sort"
+	"strings"
+)
+
+// Placeholder is the pattern token matching arbitrary text.
+const Placeholder = "<_>"
+
+// Config contains Miner settings.
+type Config struct {
+	// Depth is the depth of the parse tree including the root and leaf levels.
+	//
+	// Depth-2 leading tokens of a log line are used for selecting clusters in the tree.
+	Depth int
+
+	// SimilarityThreshold is the minimum share of equal tokens for adding a log line to an existing pattern.
+	SimilarityThreshold float64
+
+	// MaxChildren is the maximum number of children per tree node.
+	MaxChildren int
+
+	// MaxClusters is the maximum number of patterns. Log lines not matching existing patterns are dropped after reaching the limit.
+	MaxClusters int
+}
+
+// DefaultConfig is the default config for Miner.
+var DefaultConfig = Config{
+	Depth:               4,
+	SimilarityThreshold: 0.3,
+	MaxChildren:         100,
+	MaxClusters:         300,
+}
+
+// Miner groups log lines into patterns with Placeholder tokens for variable parts.
+//
+// It implements Drain algorithm - see https://jiemingzhu.github.io/pub/pjhe_icws2017.pdf
+//
+// Miner isn't safe for concurrent use.
+type Miner struct {
+	cfg  Config
+	step int64
+	root map[int]*node
+
+	clusters []*cluster
+}
+
+// node is a node of the parse tree.
+type node struct {
+	children map[string]*node
+	clusters []*cluster
+}
+
+// cluster is a group of log lines with the same pattern.
+type cluster struct {
+	tokens  []string
+	samples map[int64]uint64
+	total   uint64
+}
+
+// Sample is the number of log lines for a pattern on the time bucket.
+type Sample struct {
+	// Timestamp is the start of the time bucket in milliseconds.
+	Timestamp int64
+
+	// Count is the number of log lines on the time bucket.
+	Count uint64
+}
+
+// Pattern is a pattern returned by Miner.
+type Pattern struct {
+	// Pattern is the pattern with Placeholder tokens for variable parts.
+	Pattern string
+
+	// Samples contains the number of log lines per time bucket sorted by Timestamp.
+	Samples []Sample
+
+	// Total is the total number of log lines for the pattern.
+	Total uint64
+}
+
+// NewMiner returns new Miner with the given cfg, which counts log lines per time buckets with the given step in milliseconds.
+func NewMiner(cfg Config, step int64) *Miner {
+	if cfg.Depth < 3 {
+		cfg.Depth = 3
+	}
+	if cfg.MaxChildren < 2 {
+		cfg.MaxChildren = 2
+	}
+	if step <= 0 {
+		step = 1
+	}
+	return &Miner{
+		cfg:  cfg,
+		step: step,
+		root: make(map[int]*node),
+	}
+}
+
+// Add adds the given line with the given timestamp in milliseconds to m.
+//
+// weight is the number of log lines represented by the line.
+func (m *Miner) Add(line string, timestamp int64, weight uint64) {
+	tokens := strings.Split(line, " ")
+	c := m.treeSearch(tokens)
+	if c == nil {
+		if len(m.clusters) >= m.cfg.MaxClusters {
+			return
+		}
+		c = &cluster{
+			tokens:  tokens,
+			samples: make(map[int64]uint64),
+		}
+		m.addToTree(c)
+		m.clusters = append(m.clusters, c)
+	} else {
+		c.merge(tokens)
+	}
+	bucket := timestamp - timestamp%m.step
+	if timestamp < 0 && timestamp%m.step != 0 {
+		bucket -= m.step
+	}
+	c.samples[bucket] += weight
+	c.total += weight
+}
+
+// Patterns returns patterns collected by m sorted by the number of log lines in descending order.
+func (m *Miner) Patterns() []Pattern {
+	ps := make([]Pattern, 0, len(m.clusters))
+	for _, c := range m.clusters {
+		samples := make([]Sample, 0, len(c.samples))
+		for timestamp, count := range c.samples {
+			samples = append(samples, Sample{
+				Timestamp: timestamp,
+				Count:     count,
+			})
+		}
+		sort.Slice(samples, func(i, j int) bool {
+			return samples[i].Timestamp < samples[j].Timestamp
+		})
+		ps = append(ps, Pattern{
+			Pattern: strings.Join(c.tokens, " "),
+			Samples: samples,
+			Total:   c.total,
+		})
+	}
+	sort.SliceStable(ps, func(i, j int) bool {
+		if ps[i].Total != ps[j].Total {
+			return ps[i].Total > ps[j].Total
+		}
+		return ps[i].Pattern < ps[j].Pattern
+	})
+	return ps
+}
+
+func (m *Miner) treeSearch(tokens []string) *cluster {
+	n := m.root[len(tokens)]
+	if n == nil {
+		return nil
+	}
+	for i := 0; i < m.cfg.Depth-2 && i < len(tokens); i++ {
+		child := n.children[tokens[i]]
+		if child == nil {
+			child = n.children[Placeholder]
+		}
+		if child == nil {
+			return nil
+		}
+		n = child
+	}
+	return m.fastMatch(n.clusters, tokens)
+}
+
+// fastMatch returns the cluster from cs with the highest similarity to tokens.
+//
+// nil is returned if the similarity is below the threshold.
+func (m *Miner) fastMatch(cs []*cluster, tokens []string) *cluster {
+	var best *cluster
+	bestSim := -1.0
+	bestPlaceholders := -1
+	for _, c := range cs {
+		sim, placeholders := c.similarity(tokens)
+		if sim > bestSim || sim == bestSim && placeholders > bestPlaceholders {
+			best = c
+			bestSim = sim
+			bestPlaceholders = placeholders
+		}
+	}
+	if bestSim < m.cfg.SimilarityThreshold {
+		return nil
+	}
+	return best
+}
+
+func (m *Miner) addToTree(c *cluster) {
+	tokens := c.tokens
+	n := m.root[len(tokens)]
+	if n == nil {
+		n = newNode()
+		m.root[len(tokens)] = n
+	}
+	for i := 0; i < m.cfg.Depth-2 && i < len(tokens); i++ {
+		token := tokens[i]
+		if hasDigits(token) {
+			// Tokens with digits are likely variable, so they mustn't produce separate tree branches.
+			token = Placeholder
+		}
+		child := n.children[token]
+		if child == nil {
+			if token != Placeholder && len(n.children) >= m.cfg.MaxChildren-1 {
+				// Reserve the last child for Placeholder.
+				token = Placeholder
+				child = n.children[token]
+			}
+			if child == nil {
+				child = newNode()
+				n.children[token] = child
+			}
+		}
+		n = child
+	}
+	n.clusters = append(n.clusters, c)
+}
+
+func newNode() *node {
+	return &node{
+		children: make(map[string]*node),
+	}
+}
+
+// similarity returns the share of tokens equal to c tokens and the number of placeholders in c.
+//
+// tokens must have the same length as c.tokens.
+func (c *cluster) similarity(tokens []string) (float64, int) {
+	if len(tokens) == 0 {
+		return 1, 0
+	}
+	equal := 0
+	placeholders := 0
+	for i, token := range c.tokens {
+		if token == Placeholder {
+			placeholders++
+			continue
+		}
+		if token == tokens[i] {
+			equal++
+		}
+	}
+	return float64(equal) / float64(len(tokens)), placeholders
+}
+
+// merge replaces c tokens differing from the given tokens with Placeholder.
+func (c *cluster) merge(tokens []string) {
+	for i, token := range c.tokens {
+		if token != tokens[i] {
+			c.tokens[i] = Placeholder
+		}
+	}
+}
+
+func hasDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= '0' && s[i] <= '9' {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package patterns
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMiner(t *testing.T) {
+	m := NewMiner(DefaultConfig, 10)
+	m.Add("GET /api/users 200 12ms", 1, 1)
+	m.Add("GET /api/users 404 7ms", 5, 1)
+	m.Add("GET /api/orders 200 7ms", 8, 1)
+	m.Add("GET /api/users 500 1034ms", 12, 3)
+	m.Add("user 42 logged in", 15, 1)
+	m.Add("user 1337 logged in", 25, 1)
+	m.Add("connection reset", 26, 2)
+
+	ps := m.Patterns()
+	psExpected := []Pattern{
+		{
+			Pattern: "GET /api/users <_> <_>",
+			Samples: []Sample{{Timestamp: 0, Count: 2}, {Timestamp: 10, Count: 3}},
+			Total:   5,
+		},
+		{
+			Pattern: "connection reset",
+			Samples: []Sample{{Timestamp: 20, Count: 2}},
+			Total:   2,
+		},
+		{
+			Pattern: "user <_> logged in",
+			Samples: []Sample{{Timestamp: 10, Count: 1}, {Timestamp: 20, Count: 1}},
+			Total:   2,
+		},
+		{
+			// The second token is used for selecting patterns in the parse tree, so it isn't merged.
+			Pattern: "GET /api/orders 200 7ms",
+			Samples: []Sample{{Timestamp: 0, Count: 1}},
+			Total:   1,
+		},
+	}
+	if !reflect.DeepEqual(ps, psExpected) {
+		t.Fatalf("unexpected patterns\ngot\n%+v\nwant\n%+v", ps, psExpected)
+	}
+
+	// Every line must match the pattern it was added to.
+	for _, line := range []string{"GET /api/users 500 1034ms", "user 42 logged in", "connection reset"} {
+		matched := false
+		for _, p := range ps {
+			pm, err := NewMatcher(p.Pattern)
+			if err != nil {
+				t.Fatalf("cannot create matcher for %q: %s", p.Pattern, err)
+			}
+			if pm.Match([]byte(line)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			t.Fatalf("line %q doesn't match any pattern", line)
+		}
+	}
+}
+
+func TestMinerMaxClusters(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.MaxClusters = 1
+	m := NewMiner(cfg, 1)
+	m.Add("foo bar", 0, 1)
+	m.Add("a b c", 0, 1)
+	ps := m.Patterns()
+	if len(ps) != 1 || ps[0].Pattern != "foo bar" {
+		t.Fatalf("unexpected patterns: %+v", ps)
+	}
+}
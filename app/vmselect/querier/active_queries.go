@@ -7,24 +7,42 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
 )
 
-// WriteActiveQueries writes active queries to w.
+// WriteActiveQueries writes active queries for the tenant at to w.
 //
 // The written active queries are sorted in descending order of their exeuction duration.
-func WriteActiveQueries(w io.Writer) {
-	aqes := activeQueriesV.GetAll()
+// Every query contains its progress: blocks and bytes read from vmstorage nodes so far
+// and the number of pending requests to vmstorage nodes.
+//
+// Multi-tenant queries are written for every tenant they touch.
+func WriteActiveQueries(w io.Writer, at *auth.Token) {
+	aqes := activeQueriesV.GetAll(at)
 	sort.Slice(aqes, func(i, j int) bool {
 		return aqes[i].startTime.Sub(aqes[j].startTime) < 0
 	})
 	now := time.Now()
 	for _, aqe := range aqes {
 		d := now.Sub(aqe.startTime)
-		fmt.Fprintf(w, "\tduration: %.3fs, id=%016X, remote_addr=%s, accountID=%d, projectID=%d, query=%q, start=%d, end=%d, step=%d\n",
-			d.Seconds(), aqe.qid, aqe.quotedRemoteAddr, aqe.accountID, aqe.projectID, aqe.q, aqe.start, aqe.end, aqe.step)
+		s := aqe.qs.Snapshot()
+		fmt.Fprintf(w, "\tduration: %.3fs, id=%016X, remote_addr=%s, accountID=%d, projectID=%d, query=%q, start=%d, end=%d, step=%d, "+
+			"blocks_read=%d, bytes_read=%d, storage_nodes_pending=%d, canceled=%v\n",
+			d.Seconds(), aqe.qid, aqe.quotedRemoteAddr, aqe.ats[0].AccountID, aqe.ats[0].ProjectID, aqe.q, aqe.start, aqe.end, aqe.step,
+			s.BlocksFetched, s.BytesFetched, s.StorageNodesPending, aqe.canceled)
 	}
 }
 
+// CancelActiveQuery cancels the active query with the given qid for the tenant at.
+//
+// The query stops with an error, while in-flight requests to vmstorage nodes are aborted.
+// false is returned if there is no active query with the given qid for the tenant at.
+func CancelActiveQuery(at *auth.Token, qid uint64) bool {
+	return activeQueriesV.Cancel(at, qid)
+}
+
 var activeQueriesV = newActiveQueries()
 
 type activeQueries struct {
@@ -33,8 +51,8 @@ type activeQueries struct {
 }
 
 type activeQueryEntry struct {
-	accountID        uint32
-	projectID        uint32
+	// ats contains tenants for the query. It contains multiple tenants for multi-tenant queries.
+	ats              []*auth.Token
 	start            int64
 	end              int64
 	step             int64
@@ -42,6 +60,9 @@ type activeQueryEntry struct {
 	quotedRemoteAddr string
 	q                string
 	startTime        time.Time
+	qs               *netstorage.QueryStats
+	cancelCh         chan struct{}
+	canceled         bool
 }
 
 func newActiveQueries() *activeQueries {
@@ -50,10 +71,18 @@ func newActiveQueries() *activeQueries {
 	}
 }
 
+// Add registers the query q with the given ec and returns its id.
+//
+// ec.Deadline is updated, so the query may be canceled via Cancel.
 func (aq *activeQueries) Add(ec *EvalConfig, q string) uint64 {
 	var aqe activeQueryEntry
-	aqe.accountID = ec.AuthToken.AccountID
-	aqe.projectID = ec.AuthToken.ProjectID
+	if len(ec.Tenants) > 0 {
+		for _, t := range ec.Tenants {
+			aqe.ats = append(aqe.ats, t.AuthToken)
+		}
+	} else {
+		aqe.ats = []*auth.Token{ec.AuthToken}
+	}
 	aqe.start = ec.Start
 	aqe.end = ec.End
 	aqe.step = ec.Step
@@ -61,6 +90,9 @@ func (aq *activeQueries) Add(ec *EvalConfig, q string) uint64 {
 	aqe.quotedRemoteAddr = ec.QuotedRemoteAddr
 	aqe.q = q
 	aqe.startTime = time.Now()
+	aqe.qs = ec.QueryStats
+	aqe.cancelCh = make(chan struct{})
+	ec.Deadline = ec.Deadline.WithCancel(aqe.cancelCh)
 
 	aq.mu.Lock()
 	aq.m[aqe.qid] = aqe
@@ -74,14 +106,41 @@ func (aq *activeQueries) Remove(qid uint64) {
 	aq.mu.Unlock()
 }
 
-func (aq *activeQueries) GetAll() []activeQueryEntry {
+func (aq *activeQueries) Cancel(at *auth.Token, qid uint64) bool {
 	aq.mu.Lock()
-	aqes := make([]activeQueryEntry, 0, len(aq.m))
+	defer aq.mu.Unlock()
+	aqe, ok := aq.m[qid]
+	if !ok || !aqe.hasTenant(at) {
+		return false
+	}
+	if !aqe.canceled {
+		close(aqe.cancelCh)
+		aqe.canceled = true
+		aq.m[qid] = aqe
+	}
+	return true
+}
+
+// GetAll returns active queries for the tenant at.
+func (aq *activeQueries) GetAll(at *auth.Token) []activeQueryEntry {
+	aq.mu.Lock()
+	var aqes []activeQueryEntry
 	for _, aqe := range aq.m {
-		aqes = append(aqes, aqe)
+		if aqe.hasTenant(at) {
+			aqes = append(aqes, aqe)
+		}
 	}
 	aq.mu.Unlock()
 	return aqes
 }
 
+func (aqe *activeQueryEntry) hasTenant(at *auth.Token) bool {
+	for _, x := range aqe.ats {
+		if x.AccountID == at.AccountID && x.ProjectID == at.ProjectID {
+			return true
+		}
+	}
+	return false
+}
+
 var nextActiveQueryID = uint64(time.Now().UnixNano())
@@ -0,0 +1,110 @@
+package querier
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/searchutils"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/tenant"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+)
+
+func TestActiveQueriesCancel(t *testing.T) {
+	aq := newActiveQueries()
+	at := &auth.Token{AccountID: 1, ProjectID: 2}
+	ec := &EvalConfig{
+		AuthToken:  at,
+		Deadline:   searchutils.NewDeadline(time.Now(), time.Minute, ""),
+		QueryStats: &netstorage.QueryStats{},
+	}
+	qid := aq.Add(ec, `{app="foo"}`)
+	if ec.Deadline.Canceled() {
+		t.Fatalf("the query mustn't be canceled before Cancel call")
+	}
+	if aq.Cancel(at, qid+1) {
+		t.Fatalf("expecting false when canceling unknown query")
+	}
+	if aq.Cancel(&auth.Token{AccountID: 1}, qid) {
+		t.Fatalf("expecting false when canceling query from another tenant")
+	}
+	if ec.Deadline.Canceled() {
+		t.Fatalf("the query mustn't be canceled by another tenant")
+	}
+	if !aq.Cancel(at, qid) {
+		t.Fatalf("expecting true when canceling active query")
+	}
+	if !ec.Deadline.Canceled() {
+		t.Fatalf("the query must be canceled after Cancel call")
+	}
+	// Repeated cancel mustn't panic on closing the channel twice.
+	if !aq.Cancel(at, qid) {
+		t.Fatalf("expecting true when canceling already canceled query")
+	}
+	aqes := aq.GetAll(at)
+	if len(aqes) != 1 || !aqes[0].canceled {
+		t.Fatalf("expecting a single canceled query; got %+v", aqes)
+	}
+	if aqes := aq.GetAll(&auth.Token{AccountID: 1}); len(aqes) != 0 {
+		t.Fatalf("expecting no queries for another tenant; got %+v", aqes)
+	}
+
+	aq.Remove(qid)
+	if aq.Cancel(at, qid) {
+		t.Fatalf("expecting false when canceling removed query")
+	}
+}
+
+func TestWriteActiveQueriesProgress(t *testing.T) {
+	ec := &EvalConfig{
+		AuthToken:  &auth.Token{},
+		Deadline:   searchutils.NewDeadline(time.Now(), time.Minute, ""),
+		QueryStats: &netstorage.QueryStats{},
+	}
+	qid := activeQueriesV.Add(ec, `{app="foo"}`)
+	defer activeQueriesV.Remove(qid)
+
+	var bb bytes.Buffer
+	WriteActiveQueries(&bb, ec.AuthToken)
+	s := bb.String()
+	for _, substr := range []string{`query="{app=\"foo\"}"`, "blocks_read=0", "storage_nodes_pending=0", "canceled=false"} {
+		if !strings.Contains(s, substr) {
+			t.Fatalf("missing %q in active queries output %q", substr, s)
+		}
+	}
+
+	// Queries from other tenants mustn't be written.
+	bb.Reset()
+	WriteActiveQueries(&bb, &auth.Token{AccountID: 1})
+	if bb.Len() > 0 {
+		t.Fatalf("unexpected active queries for another tenant: %q", bb.String())
+	}
+}
+
+func TestActiveQueriesMultiTenant(t *testing.T) {
+	aq := newActiveQueries()
+	ec := &EvalConfig{
+		AuthToken: &auth.Token{AccountID: 1},
+		Tenants: []*tenant.Tenant{
+			{AuthToken: &auth.Token{AccountID: 1}},
+			{AuthToken: &auth.Token{AccountID: 2}},
+		},
+		Deadline:   searchutils.NewDeadline(time.Now(), time.Minute, ""),
+		QueryStats: &netstorage.QueryStats{},
+	}
+	qid := aq.Add(ec, `{app="foo"}`)
+	defer aq.Remove(qid)
+	for _, accountID := range []uint32{1, 2} {
+		if aqes := aq.GetAll(&auth.Token{AccountID: accountID}); len(aqes) != 1 {
+			t.Fatalf("expecting a single query for accountID=%d; got %+v", accountID, aqes)
+		}
+	}
+	if aq.Cancel(&auth.Token{AccountID: 3}, qid) {
+		t.Fatalf("expecting false when canceling query from the tenant it doesn't touch")
+	}
+	if !aq.Cancel(&auth.Token{AccountID: 2}, qid) {
+		t.Fatalf("expecting true when canceling query from one of its tenants")
+	}
+}
@@ -51,6 +51,7 @@ func getBinaryOpFunc(op string) binaryOpFunc {
 }
 
 type binaryOpFuncArg struct {
+	ec        *EvalConfig
 	be        *logql.BinaryOpExpr
 	leftExpr  logql.Expr
 	left      []*timeseries
@@ -368,124 +369,73 @@ func binaryOpUnless(bfa *binaryOpFuncArg) ([]*timeseries, error) {
 }
 
 func binaryOpContains(bfa *binaryOpFuncArg) ([]*timeseries, error) {
-	var rvs []*timeseries
-
 	se, ok := bfa.rightExpr.(*logql.StringExpr)
 	if !ok {
-		return rvs, nil
+		return nil, nil
 	}
-
-	mLeft, _ := createTimeseriesMapByTagSet(bfa.be, bfa.left, bfa.right)
 	keyword := []byte(se.S)
-
-	for _, tssLeft := range mLeft {
-		for _, tsLeft := range tssLeft {
-			valuesLeft := tsLeft.Values
-			datasLeft := tsLeft.Datas
-			for i, v := range datasLeft {
-				if len(v) != 0 {
-					if !bytes.Contains(v, keyword) {
-						valuesLeft[i] = nan
-						datasLeft[i] = nanByes
-					}
-				}
-			}
-		}
-		tssLeft = removeNaNs(tssLeft)
-		rvs = append(rvs, tssLeft...)
-	}
-	return rvs, nil
+	return filterLines(bfa, se, func(line []byte) bool {
+		return bytes.Contains(line, keyword)
+	}), nil
 }
 
 var binaryOpNeqFunc = newBinaryOpCmpFunc(binaryop.Neq)
 
 func binaryOpNotContains(bfa *binaryOpFuncArg) ([]*timeseries, error) {
-	var rvs []*timeseries
-
 	se, ok := bfa.rightExpr.(*logql.StringExpr)
 	if !ok {
 		// backward compatible `!=` operator
 		return binaryOpNeqFunc(bfa)
 	}
-
-	mLeft, _ := createTimeseriesMapByTagSet(bfa.be, bfa.left, bfa.right)
 	keyword := []byte(se.S)
-
-	for _, tssLeft := range mLeft {
-		for _, tsLeft := range tssLeft {
-			valuesLeft := tsLeft.Values
-			datasLeft := tsLeft.Datas
-			for i, v := range datasLeft {
-				if len(v) != 0 {
-					if bytes.Contains(v, keyword) {
-						valuesLeft[i] = nan
-						datasLeft[i] = nanByes
-					}
-				}
-			}
-		}
-		tssLeft = removeNaNs(tssLeft)
-		rvs = append(rvs, tssLeft...)
-	}
-	return rvs, nil
+	return filterLines(bfa, se, func(line []byte) bool {
+		return !bytes.Contains(line, keyword)
+	}), nil
 }
 
 func binaryOpMatch(bfa *binaryOpFuncArg) ([]*timeseries, error) {
-	var rvs []*timeseries
-
 	se, ok := bfa.rightExpr.(*logql.StringExpr)
 	if !ok {
-		return rvs, nil
+		return nil, nil
 	}
-
-	mLeft, _ := createTimeseriesMapByTagSet(bfa.be, bfa.left, bfa.right)
 	re, err := logql.CompileRegexp(se.S)
 	if err != nil {
 		return nil, err
 	}
-
-	for _, tssLeft := range mLeft {
-		for _, tsLeft := range tssLeft {
-			valuesLeft := tsLeft.Values
-			datasLeft := tsLeft.Datas
-			for i, v := range datasLeft {
-				if len(v) != 0 {
-					if !re.Match(v) {
-						valuesLeft[i] = nan
-						datasLeft[i] = nanByes
-					}
-				}
-			}
-		}
-		tssLeft = removeNaNs(tssLeft)
-		rvs = append(rvs, tssLeft...)
-	}
-	return rvs, nil
+	return filterLines(bfa, se, re.Match), nil
 }
 
 func binaryOpNotMatch(bfa *binaryOpFuncArg) ([]*timeseries, error) {
-	var rvs []*timeseries
-
 	se, ok := bfa.rightExpr.(*logql.StringExpr)
 	if !ok {
-		return rvs, nil
+		return nil, nil
 	}
-
-	mLeft, _ := createTimeseriesMapByTagSet(bfa.be, bfa.left, bfa.right)
 	re, err := logql.CompileRegexp(se.S)
 	if err != nil {
 		return nil, err
 	}
+	return filterLines(bfa, se, func(line []byte) bool {
+		return !re.Match(line)
+	}), nil
+}
 
+// filterLines drops lines from bfa.left, which don't match the line filter `bfa.be.Op se`.
+//
+// The number of dropped lines is registered in query stats and in query trace.
+func filterLines(bfa *binaryOpFuncArg, se *logql.StringExpr, keepLine func(line []byte) bool) []*timeseries {
+	var rvs []*timeseries
+	linesDropped := 0
+	mLeft, _ := createTimeseriesMapByTagSet(bfa.be, bfa.left, bfa.right)
 	for _, tssLeft := range mLeft {
 		for _, tsLeft := range tssLeft {
 			valuesLeft := tsLeft.Values
 			datasLeft := tsLeft.Datas
 			for i, v := range datasLeft {
 				if len(v) != 0 {
-					if re.Match(v) {
+					if !keepLine(storage.GetLine(v)) {
 						valuesLeft[i] = nan
 						datasLeft[i] = nanByes
+						linesDropped++
 					}
 				}
 			}
@@ -493,7 +443,9 @@ func binaryOpNotMatch(bfa *binaryOpFuncArg) ([]*timeseries, error) {
 		tssLeft = removeNaNs(tssLeft)
 		rvs = append(rvs, tssLeft...)
 	}
-	return rvs, nil
+	bfa.ec.QueryStats.AddFilteredOutLines(linesDropped)
+	bfa.ec.Tracer.Printf("filter `%s %s` dropped %d rows", bfa.be.Op, se.AppendString(nil), linesDropped)
+	return rvs
 }
 
 func createTimeseriesMapByTagSet(be *logql.BinaryOpExpr, left, right []*timeseries) (map[string][]*timeseries, map[string][]*timeseries) {
@@ -0,0 +1,72 @@
+package querier
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logql"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/querytracer"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+)
+
+func TestBinaryOpLineFilters(t *testing.T) {
+	f := func(q string, linesExpected []string, traceMsgExpected string) {
+		t.Helper()
+		e, err := logql.Parse(q)
+		if err != nil {
+			t.Fatalf("cannot parse query %q: %s", q, err)
+		}
+		be := e.(*logql.BinaryOpExpr)
+		ts := &timeseries{}
+		for i, line := range []string{"GET /a", "GET /b", "POST /a", "PUT /c"} {
+			ts.Timestamps = append(ts.Timestamps, int64(i))
+			ts.Values = append(ts.Values, 0)
+			ts.Datas = append(ts.Datas, storage.MarshalLineWithMetadata(nil, []byte(line), nil))
+		}
+		qs := netstorage.NewQueryStats(time.Now())
+		qs.AddPostFilterLines(len(ts.Timestamps))
+		qt := querytracer.New(true, "test")
+		bfa := &binaryOpFuncArg{
+			ec: &EvalConfig{
+				QueryStats: qs,
+				Tracer:     qt,
+			},
+			be:        be,
+			leftExpr:  be.Left,
+			left:      []*timeseries{ts},
+			rightExpr: be.Right,
+		}
+		tss, err := getBinaryOpFunc(be.Op)(bfa)
+		if err != nil {
+			t.Fatalf("cannot evaluate %q: %s", q, err)
+		}
+		qt.Done()
+
+		var lines []string
+		for _, ts := range tss {
+			for i, data := range ts.Datas {
+				if math.IsNaN(ts.Values[i]) {
+					// The line has been dropped by the filter.
+					continue
+				}
+				lines = append(lines, string(storage.GetLine(data)))
+			}
+		}
+		if strings.Join(lines, ",") != strings.Join(linesExpected, ",") {
+			t.Fatalf("unexpected lines for %q; got %q; want %q", q, lines, linesExpected)
+		}
+		if n := qs.Snapshot().LinesPostFilter; n != uint64(len(linesExpected)) {
+			t.Fatalf("unexpected lines post filter for %q; got %d; want %d", q, n, len(linesExpected))
+		}
+		if s := qt.String(); !strings.Contains(s, traceMsgExpected) {
+			t.Fatalf("missing %q in the trace:\n%s", traceMsgExpected, s)
+		}
+	}
+	f(`{app="foo"} |= "GET"`, []string{"GET /a", "GET /b"}, "filter `|= \"GET\"` dropped 2 rows")
+	f(`{app="foo"} != "GET"`, []string{"POST /a", "PUT /c"}, "filter `!= \"GET\"` dropped 2 rows")
+	f(`{app="foo"} |~ "/[ab]"`, []string{"GET /a", "GET /b", "POST /a"}, "filter `|~ \"/[ab]\"` dropped 1 rows")
+	f(`{app="foo"} !~ "^P"`, []string{"GET /a", "GET /b"}, "filter `!~ \"^P\"` dropped 2 rows")
+}
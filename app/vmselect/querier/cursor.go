@@ -0,0 +1,270 @@
+package querier
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"sort"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/cespare/xxhash/v2"
+)
+
+// cursorVersion is the version of marshaled Cursor.
+//
+// It must be incremented when the Cursor format changes.
+const cursorVersion = 1
+
+// Cursor is a position in log query results, which allows resuming the query from the next line.
+//
+// Lines with the same timestamp are distinguished by the number of already returned lines per each stream,
+// so pages don't lose or duplicate lines even if many lines share a timestamp.
+type Cursor struct {
+	// Timestamp is the timestamp in milliseconds of the last returned line.
+	Timestamp int64
+
+	// Positions contains the number of already returned lines with Timestamp per each stream hash.
+	Positions map[uint64]int
+}
+
+// Pagination holds cursors for log query evaluation.
+type Pagination struct {
+	// Cursor is the position to resume the query from. It is nil for the first page.
+	Cursor *Cursor
+
+	// NextCursor is set during query evaluation to the position for the next page.
+	//
+	// It is nil if there are no more lines to return.
+	NextCursor *Cursor
+}
+
+// Marshal returns opaque string representation of c for the given query and direction.
+func (c *Cursor) Marshal(query string, forward bool) string {
+	dst := []byte{cursorVersion}
+	if forward {
+		dst = append(dst, 1)
+	} else {
+		dst = append(dst, 0)
+	}
+	dst = encoding.MarshalUint64(dst, xxhash.Sum64String(query))
+	dst = encoding.MarshalVarInt64(dst, c.Timestamp)
+	hashes := make([]uint64, 0, len(c.Positions))
+	for h := range c.Positions {
+		hashes = append(hashes, h)
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+	dst = encoding.MarshalVarUint64(dst, uint64(len(hashes)))
+	for _, h := range hashes {
+		dst = encoding.MarshalUint64(dst, h)
+		dst = encoding.MarshalVarUint64(dst, uint64(c.Positions[h]))
+	}
+	return base64.RawURLEncoding.EncodeToString(dst)
+}
+
+// ParseCursor parses cursor s obtained via Cursor.Marshal for the given query and direction.
+func ParseCursor(s, query string, forward bool) (*Cursor, error) {
+	src, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode cursor: %w", err)
+	}
+	if len(src) < 10 {
+		return nil, fmt.Errorf("too short cursor; got %d bytes; want at least 10 bytes", len(src))
+	}
+	if src[0] != cursorVersion {
+		return nil, fmt.Errorf("unsupported cursor version %d; want %d", src[0], cursorVersion)
+	}
+	if (src[1] == 1) != forward {
+		return nil, fmt.Errorf("cursor was obtained for another direction")
+	}
+	if encoding.UnmarshalUint64(src[2:]) != xxhash.Sum64String(query) {
+		return nil, fmt.Errorf("cursor was obtained for another query")
+	}
+	src = src[10:]
+	tail, timestamp, err := encoding.UnmarshalVarInt64(src)
+	if err != nil {
+		return nil, fmt.Errorf("cannot unmarshal cursor timestamp: %w", err)
+	}
+	src = tail
+	tail, n, err := encoding.UnmarshalVarUint64(src)
+	if err != nil {
+		return nil, fmt.Errorf("cannot unmarshal the number of cursor positions: %w", err)
+	}
+	src = tail
+	c := &Cursor{
+		Timestamp: timestamp,
+		Positions: make(map[uint64]int),
+	}
+	for i := uint64(0); i < n; i++ {
+		if len(src) < 8 {
+			return nil, fmt.Errorf("cannot unmarshal stream hash for cursor position #%d", i)
+		}
+		h := encoding.UnmarshalUint64(src)
+		tail, pos, err := encoding.UnmarshalVarUint64(src[8:])
+		if err != nil {
+			return nil, fmt.Errorf("cannot unmarshal cursor position #%d: %w", i, err)
+		}
+		src = tail
+		c.Positions[h] = int(pos)
+	}
+	if len(src) > 0 {
+		return nil, fmt.Errorf("unexpected non-empty tail left after unmarshaling cursor: %d bytes", len(src))
+	}
+	return c, nil
+}
+
+// maxPosition returns the maximum position across streams in c.
+func (c *Cursor) maxPosition() int {
+	n := 0
+	for _, pos := range c.Positions {
+		if pos > n {
+			n = pos
+		}
+	}
+	return n
+}
+
+type logLineRef struct {
+	ts        *timeseries
+	hash      uint64
+	idx       int
+	timestamp int64
+}
+
+// selectLogLines returns up to ec.Limit lines from tss in the ec.Forward direction starting after ec.Pagination.Cursor.
+//
+// Lines in the returned time series are sorted in the ec.Forward direction. ec.Pagination.NextCursor is set
+// if tss contain more lines than returned.
+func selectLogLines(ec *EvalConfig, tss []*timeseries) []*timeseries {
+	var c *Cursor
+	if ec.Pagination != nil {
+		c = ec.Pagination.Cursor
+	}
+	var refs []logLineRef
+	bb := bbPool.Get()
+	for _, ts := range tss {
+		bb.B = marshalMetricNameSorted(bb.B[:0], &ts.MetricName)
+		h := xxhash.Sum64(bb.B)
+		skip := 0
+		if c != nil {
+			skip = c.Positions[h]
+		}
+		timestamps := ts.Timestamps
+		for j := range timestamps {
+			idx := j
+			if !ec.Forward {
+				idx = len(timestamps) - 1 - j
+			}
+			timestamp := timestamps[idx]
+			if c != nil {
+				if ec.Forward && timestamp < c.Timestamp || !ec.Forward && timestamp > c.Timestamp {
+					continue
+				}
+				if timestamp == c.Timestamp && skip > 0 {
+					skip--
+					continue
+				}
+			}
+			refs = append(refs, logLineRef{
+				ts:        ts,
+				hash:      h,
+				idx:       idx,
+				timestamp: timestamp,
+			})
+		}
+	}
+	bbPool.Put(bb)
+
+	sort.Slice(refs, func(i, j int) bool {
+		a, b := &refs[i], &refs[j]
+		if a.timestamp != b.timestamp {
+			return a.timestamp < b.timestamp == ec.Forward
+		}
+		if a.hash != b.hash {
+			return a.hash < b.hash
+		}
+		return a.idx < b.idx == ec.Forward
+	})
+	if int64(len(refs)) > ec.Limit {
+		limit := int(ec.Limit)
+		if limit < 0 {
+			limit = 0
+		}
+		if ec.Pagination != nil && limit > 0 {
+			ec.Pagination.NextCursor = newNextCursor(c, refs[:limit])
+		}
+		refs = refs[:limit]
+	}
+
+	m := make(map[*timeseries]*timeseries)
+	var rvs []*timeseries
+	for i := range refs {
+		ref := &refs[i]
+		dst := m[ref.ts]
+		if dst == nil {
+			dst = &timeseries{}
+			dst.MetricName.CopyFrom(&ref.ts.MetricName)
+			dst.denyReuse = true
+			m[ref.ts] = dst
+			rvs = append(rvs, dst)
+		}
+		dst.Datas = append(dst.Datas, ref.ts.Datas[ref.idx])
+		dst.Values = append(dst.Values, ref.ts.Values[ref.idx])
+		dst.Timestamps = append(dst.Timestamps, ref.timestamp)
+	}
+	return rvs
+}
+
+// newNextCursor returns the cursor pointing after the last line from refs, which are returned after prev cursor.
+func newNextCursor(prev *Cursor, refs []logLineRef) *Cursor {
+	timestamp := refs[len(refs)-1].timestamp
+	c := &Cursor{
+		Timestamp: timestamp,
+		Positions: make(map[uint64]int),
+	}
+	if prev != nil && prev.Timestamp == timestamp {
+		// Lines skipped by prev cursor precede the returned lines with the same timestamp.
+		for h, pos := range prev.Positions {
+			c.Positions[h] = pos
+		}
+	}
+	for i := len(refs) - 1; i >= 0 && refs[i].timestamp == timestamp; i-- {
+		c.Positions[refs[i].hash]++
+	}
+	return c
+}
+
+// sortLinesWithEqualTimestamps sorts lines with equal timestamps by their contents.
+//
+// The order of such lines depends on the order of blocks received from vmstorage nodes,
+// so it must be made deterministic in order to resume the query from the Cursor position.
+func sortLinesWithEqualTimestamps(rs *netstorage.Result) {
+	timestamps := rs.Timestamps
+	for i := 0; i < len(timestamps); {
+		j := i + 1
+		for j < len(timestamps) && timestamps[j] == timestamps[i] {
+			j++
+		}
+		if j-i > 1 {
+			sort.Sort(&linesSorter{
+				values: rs.Values[i:j],
+				datas:  rs.Datas[i:j],
+			})
+		}
+		i = j
+	}
+}
+
+type linesSorter struct {
+	values []float64
+	datas  [][]byte
+}
+
+func (ls *linesSorter) Len() int { return len(ls.datas) }
+func (ls *linesSorter) Less(i, j int) bool {
+	return bytes.Compare(ls.datas[i], ls.datas[j]) < 0
+}
+func (ls *linesSorter) Swap(i, j int) {
+	ls.values[i], ls.values[j] = ls.values[j], ls.values[i]
+	ls.datas[i], ls.datas[j] = ls.datas[j], ls.datas[i]
+}
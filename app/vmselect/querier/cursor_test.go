@@ -0,0 +1,126 @@
+package querier
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCursorMarshalParse(t *testing.T) {
+	c := &Cursor{
+		Timestamp: 1234567,
+		Positions: map[uint64]int{
+			1:  2,
+			42: 1,
+		},
+	}
+	s := c.Marshal(`{app="foo"}`, true)
+	c2, err := ParseCursor(s, `{app="foo"}`, true)
+	if err != nil {
+		t.Fatalf("cannot parse cursor: %s", err)
+	}
+	if !reflect.DeepEqual(c, c2) {
+		t.Fatalf("unexpected cursor after parsing\ngot\n%+v\nwant\n%+v", c2, c)
+	}
+
+	f := func(s, query string, forward bool) {
+		t.Helper()
+		if _, err := ParseCursor(s, query, forward); err == nil {
+			t.Fatalf("expecting non-nil error when parsing cursor %q", s)
+		}
+	}
+	f("", `{app="foo"}`, true)
+	f("foo-bar", `{app="foo"}`, true)
+	f(s, `{app="bar"}`, true)
+	f(s, `{app="foo"}`, false)
+	f(s[:len(s)-2], `{app="foo"}`, true)
+}
+
+func TestSelectLogLinesPagination(t *testing.T) {
+	newTimeseries := func(app string, timestamps ...int64) *timeseries {
+		ts := &timeseries{}
+		ts.MetricName.AddTag("app", app)
+		for _, timestamp := range timestamps {
+			ts.Timestamps = append(ts.Timestamps, timestamp)
+			ts.Values = append(ts.Values, 1)
+			ts.Datas = append(ts.Datas, []byte(app))
+		}
+		return ts
+	}
+	tss := []*timeseries{
+		newTimeseries("foo", 10, 20, 20, 20, 30),
+		newTimeseries("bar", 20, 20, 25),
+		newTimeseries("baz", 5),
+	}
+	const linesCount = 9
+
+	f := func(forward bool, limit int64) {
+		t.Helper()
+		var pagination Pagination
+		ec := &EvalConfig{
+			Limit:      limit,
+			Forward:    forward,
+			Pagination: &pagination,
+		}
+		counts := make(map[string]int)
+		n := 0
+		prevTimestamp := int64(-1)
+		for {
+			for _, ts := range selectLogLines(ec, tss) {
+				for _, timestamp := range ts.Timestamps {
+					if prevTimestamp >= 0 && (forward && timestamp < prevTimestamp || !forward && timestamp > prevTimestamp) {
+						t.Fatalf("unexpected timestamp order on page; got %d after %d", timestamp, prevTimestamp)
+					}
+				}
+				counts[string(ts.MetricName.GetTagValue("app"))] += len(ts.Timestamps)
+				n += len(ts.Timestamps)
+			}
+			if pagination.NextCursor == nil {
+				break
+			}
+			prevTimestamp = pagination.NextCursor.Timestamp
+			pagination.Cursor = pagination.NextCursor
+			pagination.NextCursor = nil
+			if n > linesCount {
+				t.Fatalf("too many lines returned: %d; want %d", n, linesCount)
+			}
+		}
+		countsExpected := map[string]int{
+			"foo": 5,
+			"bar": 3,
+			"baz": 1,
+		}
+		if !reflect.DeepEqual(counts, countsExpected) {
+			t.Fatalf("unexpected lines returned for forward=%v, limit=%d\ngot\n%v\nwant\n%v", forward, limit, counts, countsExpected)
+		}
+	}
+	for _, limit := range []int64{1, 2, 3, 4, 9, 100} {
+		f(true, limit)
+		f(false, limit)
+	}
+}
+
+func TestSelectLogLinesDirection(t *testing.T) {
+	ts := &timeseries{}
+	ts.MetricName.AddTag("app", "foo")
+	ts.Timestamps = []int64{1, 2, 3}
+	ts.Values = []float64{1, 1, 1}
+	ts.Datas = [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+
+	f := func(forward bool, timestampsExpected []int64) {
+		t.Helper()
+		ec := &EvalConfig{
+			Limit:   2,
+			Forward: forward,
+		}
+		tss := selectLogLines(ec, []*timeseries{ts})
+		if len(tss) != 1 {
+			t.Fatalf("unexpected number of time series; got %d; want 1", len(tss))
+		}
+		if !reflect.DeepEqual(tss[0].Timestamps, timestampsExpected) {
+			t.Fatalf("unexpected timestamps for forward=%v; got %v; want %v", forward, tss[0].Timestamps, timestampsExpected)
+		}
+	}
+	// Backward queries return the newest lines first like Loki does.
+	f(false, []int64{3, 2})
+	f(true, []int64{1, 2})
+}
@@ -10,7 +10,9 @@ import (
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/searchutils"
 	"github.com/VictoriaMetrics/VictoriaLogs/lib/logql"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/querytracer"
 	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/tenant"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
@@ -102,6 +104,43 @@ type EvalConfig struct {
 
 	DenyPartialResponse bool
 
+	// Tenants contains tenants for multi-tenant query.
+	//
+	// The query is evaluated for each tenant if it contains more than one tenant.
+	// Time series from each tenant get tenant.LabelName label with the tenant org id.
+	Tenants []*tenant.Tenant
+
+	// TenantLabel overrides tenant.LabelName for multi-tenant query.
+	//
+	// The query is evaluated for each tenant from Tenants even if it contains a single tenant when TenantLabel is set.
+	TenantLabel string
+
+	// QueryStats is updated with the query execution statistics if it isn't nil.
+	QueryStats *netstorage.QueryStats
+
+	// Pagination contains cursors for resuming log queries limited by Limit.
+	//
+	// It may be nil if the query doesn't need cursors.
+	Pagination *Pagination
+
+	// EnforcedTagFilters are added to all the stream selectors of the query.
+	//
+	// They are used for limiting the query to streams allowed for the user.
+	EnforcedTagFilters []storage.TagFilter
+
+	// ShardIdx and ShardsCount limit the query to streams from the shard ShardIdx out of ShardsCount shards.
+	//
+	// They are set for sub-queries sent by the query frontend. See ExecFrontend.
+	ShardIdx    int
+	ShardsCount int
+
+	// Tracer collects the query trace if it is enabled.
+	Tracer *querytracer.Tracer
+
+	// disableSplit is set for sub-queries obtained by splitting the query by -search.splitQueriesByInterval,
+	// so they aren't split again.
+	disableSplit bool
+
 	timestamps     []int64
 	timestampsOnce sync.Once
 }
@@ -119,6 +158,15 @@ func newEvalConfig(src *EvalConfig) *EvalConfig {
 	ec.MayCache = src.MayCache
 	ec.LookbackDelta = src.LookbackDelta
 	ec.DenyPartialResponse = src.DenyPartialResponse
+	ec.Tenants = src.Tenants
+	ec.TenantLabel = src.TenantLabel
+	ec.QueryStats = src.QueryStats
+	ec.Pagination = src.Pagination
+	ec.EnforcedTagFilters = src.EnforcedTagFilters
+	ec.ShardIdx = src.ShardIdx
+	ec.ShardsCount = src.ShardsCount
+	ec.Tracer = src.Tracer
+	ec.disableSplit = src.disableSplit
 
 	// do not copy src.timestamps - they must be generated again.
 	return &ec
@@ -181,6 +229,25 @@ func getTimestamps(start, end, step int64) []int64 {
 }
 
 func evalExpr(ec *EvalConfig, e logql.Expr, isRoot bool) ([]*timeseries, error) {
+	if !ec.Tracer.Enabled() {
+		return evalExprInternal(ec, e, isRoot)
+	}
+	ecNode := newEvalConfig(ec)
+	ecNode.Tracer = ec.Tracer.NewChild("eval: query=%s, timeRange=[%d..%d], step=%d", e.AppendString(nil), ec.Start, ec.End, ec.Step)
+	rv, err := evalExprInternal(ecNode, e, isRoot)
+	ecNode.Tracer.Donef("series=%d, points=%d", len(rv), getPointsCount(rv))
+	return rv, err
+}
+
+func getPointsCount(tss []*timeseries) int {
+	n := 0
+	for _, ts := range tss {
+		n += len(ts.Timestamps)
+	}
+	return n
+}
+
+func evalExprInternal(ec *EvalConfig, e logql.Expr, isRoot bool) ([]*timeseries, error) {
 	if me, ok := e.(*logql.MetricExpr); ok {
 		if isRoot {
 			return evalMetricExpr(ec, me)
@@ -238,7 +305,9 @@ func evalExpr(ec *EvalConfig, e logql.Expr, isRoot bool) ([]*timeseries, error)
 		return rv, nil
 	}
 	if ae, ok := e.(*logql.AggrFuncExpr); ok {
-		if callbacks := getIncrementalAggrFuncCallbacks(ae.Name); callbacks != nil {
+		// Incremental aggregation isn't applied to multi-tenant queries, since time series
+		// from distinct tenants must be aggregated together.
+		if callbacks := getIncrementalAggrFuncCallbacks(ae.Name); callbacks != nil && !ec.isMultiTenant() {
 			fe, nrf := tryGetArgRollupFuncWithMetricExpr(ae)
 			if fe != nil {
 				// There is an optimized path for calculating metricsql.AggrFuncExpr over rollupFunc over metricsql.MetricExpr.
@@ -288,6 +357,7 @@ func evalExpr(ec *EvalConfig, e logql.Expr, isRoot bool) ([]*timeseries, error)
 			return nil, fmt.Errorf(`unknown binary op %q`, be.Op)
 		}
 		bfa := &binaryOpFuncArg{
+			ec:        ec,
 			be:        be,
 			leftExpr:  be.Left,
 			left:      left,
@@ -625,88 +695,198 @@ var (
 	rollupResultCacheMiss        = metrics.NewCounter(`vm_rollup_result_cache_miss_total`)
 )
 
-var (
-	errReachedLimit = fmt.Errorf("reached limit")
-)
-
 func evalMetricExpr(ec *EvalConfig, me *logql.MetricExpr) ([]*timeseries, error) {
 	if me.IsEmpty() {
 		return evalNumber(ec, nan), nil
 	}
+	if me.Unwrap != "" {
+		return nil, fmt.Errorf("`| unwrap %s` stage may be used only inside rollup functions such as sum_over_time(); got %q", me.Unwrap, me.AppendString(nil))
+	}
+	var tss []*timeseries
+	var err error
+	if ec.isMultiTenant() {
+		tss, err = evalMultiTenant(ec, func(ecTenant *EvalConfig) ([]*timeseries, error) {
+			return evalLogStreams(ecTenant, me)
+		})
+	} else {
+		tss, err = evalLogStreams(ec, me)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return selectLogLines(ec, tss), nil
+}
 
+// hasOnlyNumericStreams returns true if all the streams matching me on the ec time range contain numeric samples
+// written by recording rules.
+//
+// Such streams are detected by block headers, so the query data is fetched only once.
+func hasOnlyNumericStreams(ec *EvalConfig, me *logql.MetricExpr) (bool, error) {
+	if me.IsEmpty() {
+		return false, nil
+	}
+	ats := []*auth.Token{ec.AuthToken}
+	if ec.isMultiTenant() {
+		ats = ats[:0]
+		for _, t := range ec.Tenants {
+			ats = append(ats, t.AuthToken)
+		}
+	}
 	tfs := toTagFilters(me.LabelFilters)
+	var numericBlocks, blocks uint64
+	for _, at := range ats {
+		sq := &storage.SearchQuery{
+			AccountID:    at.AccountID,
+			ProjectID:    at.ProjectID,
+			MinTimestamp: ec.Start,
+			MaxTimestamp: ec.End,
+			TagFilterss:  searchutils.JoinTagFilterss([][]storage.TagFilter{tfs}, ec.EnforcedTagFilters),
+			Limit:        ec.Limit,
+		}
+		n, total, isPartial, err := netstorage.CountNumericBlocks(ec.Tracer, at, sq, ec.Deadline)
+		if err != nil {
+			return false, err
+		}
+		if isPartial && ec.DenyPartialResponse {
+			return false, fmt.Errorf("cannot return full response, since some of vmstorage nodes are unavailable")
+		}
+		numericBlocks += n
+		blocks += total
+	}
+	return numericBlocks > 0 && numericBlocks == blocks, nil
+}
+
+// evalLogStreams returns log streams matching me.
+//
+// Every returned stream contains only lines, which may be selected by selectLogLines.
+func evalLogStreams(ec *EvalConfig, me *logql.MetricExpr) ([]*timeseries, error) {
+	tr := storage.TimeRange{
+		MinTimestamp: ec.Start,
+		MaxTimestamp: ec.End,
+	}
+	// Every stream may contain up to ec.Limit lines after the lines already returned for ec.Pagination.Cursor.
+	maxLines := ec.Limit + 1
+	if ec.Pagination != nil && ec.Pagination.Cursor != nil {
+		c := ec.Pagination.Cursor
+		if ec.Forward {
+			if c.Timestamp > tr.MinTimestamp {
+				tr.MinTimestamp = c.Timestamp
+			}
+		} else {
+			if c.Timestamp < tr.MaxTimestamp {
+				tr.MaxTimestamp = c.Timestamp
+			}
+		}
+		maxLines += int64(c.maxPosition())
+	}
+	if tr.MinTimestamp > tr.MaxTimestamp {
+		return nil, nil
+	}
 
+	interval := ec.splitInterval()
+	trs := splitTimeRange(tr.MinTimestamp, tr.MaxTimestamp, 1, interval)
+	if len(trs) == 1 {
+		tss, _, err := evalLogStreamsOnTimeRange(ec, me, tr, maxLines)
+		return tss, err
+	}
+	tssList := make([][]*timeseries, len(trs))
+	err := runParallelTimeRanges(trs, func(i int, tr storage.TimeRange) error {
+		mayCache := ec.mayCacheLogStreams(tr, interval)
+		if mayCache {
+			if tss, ok := logResultCacheV.Get(ec, me, tr, maxLines); ok {
+				tssList[i] = tss
+				return nil
+			}
+		}
+		tss, isPartial, err := evalLogStreamsOnTimeRange(ec, me, tr, maxLines)
+		if err != nil {
+			return err
+		}
+		if mayCache && !isPartial {
+			logResultCacheV.Put(ec, me, tr, maxLines, tss)
+		}
+		tssList[i] = tss
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mergeLogStreams(tssList, maxLines, ec.Forward), nil
+}
+
+// evalLogStreamsOnTimeRange returns log streams matching me on the given tr.
+//
+// Every returned stream contains up to maxLines lines in the ec.Forward direction.
+func evalLogStreamsOnTimeRange(ec *EvalConfig, me *logql.MetricExpr, tr storage.TimeRange, maxLines int64) ([]*timeseries, bool, error) {
+	tfs := toTagFilters(me.LabelFilters)
+	rfs, err := newRowFilters(me.MetadataFilters, me.PatternFilters, me.FieldFilters)
+	if err != nil {
+		return nil, false, err
+	}
 	sq := &storage.SearchQuery{
 		AccountID:    ec.AuthToken.AccountID,
 		ProjectID:    ec.AuthToken.ProjectID,
-		MinTimestamp: ec.Start,
-		MaxTimestamp: ec.End,
-		TagFilterss:  [][]storage.TagFilter{tfs},
+		MinTimestamp: tr.MinTimestamp,
+		MaxTimestamp: tr.MaxTimestamp,
+		TagFilterss:  searchutils.JoinTagFilterss([][]storage.TagFilter{tfs}, ec.EnforcedTagFilters),
 		Limit:        ec.Limit,
 		Forward:      ec.Forward,
 		FetchData:    storage.FetchAll,
 	}
-	rss, isPartial, err := netstorage.ProcessSearchQuery(ec.AuthToken, sq, ec.Deadline)
+	rss, isPartial, err := netstorage.ProcessSearchQuery(ec.Tracer, ec.AuthToken, sq, ec.QueryStats, ec.Deadline)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	if isPartial && ec.DenyPartialResponse {
 		rss.Cancel()
-		return nil, fmt.Errorf("cannot return full response, since some of vmstorage nodes are unavailable")
+		return nil, false, fmt.Errorf("cannot return full response, since some of vmstorage nodes are unavailable")
 	}
 	rssLen := rss.Len()
 	if rssLen == 0 {
 		rss.Cancel()
-		return nil, nil
+		return nil, isPartial, nil
 	}
 
 	var tss []*timeseries
-	var tssLock sync.RWMutex
-	var count int64
-
+	var tssLock sync.Mutex
 	err = rss.RunParallel(func(rs *netstorage.Result, workerID uint) error {
-		tssLock.Lock()
-
-		var ts timeseries
-
-		if !ec.Forward {
-			for i := 0; i < len(rs.Timestamps); i++ {
-				count++
-				if count > ec.Limit {
-					break
-				}
-				currTimestamp, currValue, currData := rs.Timestamps[i], rs.Values[i], rs.Datas[i]
-				ts.Datas = append(ts.Datas, currData)
-				ts.Values = append(ts.Values, currValue)
-				ts.Timestamps = append(ts.Timestamps, currTimestamp)
-			}
-		} else {
-			for i := len(rs.Timestamps) - 1; i >= 0; i-- {
-				count++
-				if count > ec.Limit {
-					break
-				}
-				currTimestamp, currValue, currData := rs.Timestamps[i], rs.Values[i], rs.Datas[i]
-				ts.Datas = append(ts.Datas, currData)
-				ts.Values = append(ts.Values, currValue)
-				ts.Timestamps = append(ts.Timestamps, currTimestamp)
-			}
-		}
-
-		if len(ts.Timestamps) > 0 {
-			ts.MetricName.CopyFrom(&rs.MetricName)
-			ts.denyReuse = true
-			tss = append(tss, &ts)
+		filterResultByRowFilters(rs, rfs)
+		ec.QueryStats.AddPostFilterLines(len(rs.Timestamps))
+		sortLinesWithEqualTimestamps(rs)
+		start, end := getLogLinesRange(len(rs.Timestamps), maxLines, ec.Forward)
+		if start >= end {
+			return nil
 		}
+		ts := &timeseries{}
+		ts.MetricName.CopyFrom(&rs.MetricName)
+		ts.Datas = append(ts.Datas, rs.Datas[start:end]...)
+		ts.Values = append(ts.Values, rs.Values[start:end]...)
+		ts.Timestamps = append(ts.Timestamps, rs.Timestamps[start:end]...)
+		ts.denyReuse = true
 
+		tssLock.Lock()
+		tss = append(tss, ts)
 		tssLock.Unlock()
 		return nil
 	})
-	if err != errReachedLimit && err != nil {
-		return nil, err
+	if err != nil {
+		return nil, false, err
 	}
+	traceRowFilters(ec.Tracer, rfs)
+	return tss, isPartial, nil
+}
 
-	return tss, nil
+// getLogLinesRange returns the range for the first maxLines lines out of n lines sorted by timestamps in the forward direction.
+func getLogLinesRange(n int, maxLines int64, forward bool) (int, int) {
+	start, end := 0, n
+	if int64(n) > maxLines {
+		if forward {
+			end = int(maxLines)
+		} else {
+			start = n - int(maxLines)
+		}
+	}
+	return start, end
 }
 
 func evalRollupFuncWithMetricExpr(ec *EvalConfig, name string, rf rollupFunc,
@@ -714,6 +894,24 @@ func evalRollupFuncWithMetricExpr(ec *EvalConfig, name string, rf rollupFunc,
 	if me.IsEmpty() {
 		return evalNumber(ec, nan), nil
 	}
+	if ec.isMultiTenant() {
+		if iafc != nil {
+			logger.Panicf("BUG: iafc must be nil for multi-tenant rollup %q", name)
+		}
+		return evalMultiTenant(ec, func(ecTenant *EvalConfig) ([]*timeseries, error) {
+			return evalRollupFuncWithMetricExpr(ecTenant, name, rf, expr, me, nil, windowStr)
+		})
+	}
+	if trs := splitTimeRange(ec.Start, ec.End, ec.Step, ec.splitInterval()); len(trs) > 1 && (iafc == nil || iafc.ae.Limit <= 0) {
+		return evalSplitRollup(ec, trs, func(ecSub *EvalConfig) ([]*timeseries, error) {
+			var iafcSub *incrementalAggrFuncContext
+			if iafc != nil {
+				// Aggregates are calculated independently per each timestamp, so they can be calculated per each sub-query.
+				iafcSub = newIncrementalAggrFuncContext(iafc.ae, iafc.callbacks)
+			}
+			return evalRollupFuncWithMetricExpr(ecSub, name, rf, expr, me, iafcSub, windowStr)
+		})
+	}
 	var window int64
 	if len(windowStr) > 0 {
 		var err error
@@ -728,10 +926,12 @@ func evalRollupFuncWithMetricExpr(ec *EvalConfig, name string, rf rollupFunc,
 	if start > ec.End {
 		// The result is fully cached.
 		rollupResultCacheFullHits.Inc()
+		ec.Tracer.Printf("the result is fully cached in rollup result cache")
 		return tssCached, nil
 	}
 	if start > ec.Start {
 		rollupResultCachePartialHits.Inc()
+		ec.Tracer.Printf("the result is partially cached in rollup result cache; fetching the remaining data starting from %d", start)
 	} else {
 		rollupResultCacheMiss.Inc()
 	}
@@ -744,8 +944,18 @@ func evalRollupFuncWithMetricExpr(ec *EvalConfig, name string, rf rollupFunc,
 		return nil, err
 	}
 
+	rrf, err := newRollupRowsFilter(me)
+	if err != nil {
+		return nil, err
+	}
+
 	// Fetch the remaining part of the result.
 	tfs := toTagFilters(me.LabelFilters)
+	var fetchData storage.FetchDataOption = storage.OnlyFetchTime
+	if len(rrf.rfs) > 0 || rrf.unwrap != "" {
+		// Log lines or fields are needed for filtering by structured metadata, patterns and fields.
+		fetchData = storage.FetchAll
+	}
 	minTimestamp := start - maxSilenceInterval
 	if window > ec.Step {
 		minTimestamp -= window
@@ -757,10 +967,12 @@ func evalRollupFuncWithMetricExpr(ec *EvalConfig, name string, rf rollupFunc,
 		ProjectID:    ec.AuthToken.ProjectID,
 		MinTimestamp: minTimestamp,
 		MaxTimestamp: ec.End,
-		TagFilterss:  [][]storage.TagFilter{tfs},
-		FetchData:    storage.OnlyFetchTime,
+		TagFilterss:  searchutils.JoinTagFilterss([][]storage.TagFilter{tfs}, ec.EnforcedTagFilters),
+		FetchData:    fetchData,
+		ShardIdx:     ec.ShardIdx,
+		ShardsCount:  ec.ShardsCount,
 	}
-	rss, isPartial, err := netstorage.ProcessSearchQuery(ec.AuthToken, sq, ec.Deadline)
+	rss, isPartial, err := netstorage.ProcessSearchQuery(ec.Tracer, ec.AuthToken, sq, ec.QueryStats, ec.Deadline)
 	if err != nil {
 		return nil, err
 	}
@@ -768,6 +980,9 @@ func evalRollupFuncWithMetricExpr(ec *EvalConfig, name string, rf rollupFunc,
 		rss.Cancel()
 		return nil, fmt.Errorf("cannot return full response, since some of vmstorage nodes are unavailable")
 	}
+	if len(rrf.fieldNames) > 0 {
+		rss.UnpackFields(rrf.fieldNames)
+	}
 	rssLen := rss.Len()
 	if rssLen == 0 {
 		rss.Cancel()
@@ -821,13 +1036,14 @@ func evalRollupFuncWithMetricExpr(ec *EvalConfig, name string, rf rollupFunc,
 	removeMetricGroup := !rollupFuncsKeepMetricGroup[name]
 	var tss []*timeseries
 	if iafc != nil {
-		tss, err = evalRollupWithIncrementalAggregate(name, iafc, rss, rcs, preFunc, sharedTimestamps, removeMetricGroup)
+		tss, err = evalRollupWithIncrementalAggregate(name, iafc, rss, rcs, rrf, ec.QueryStats, preFunc, sharedTimestamps, removeMetricGroup)
 	} else {
-		tss, err = evalRollupNoIncrementalAggregate(name, rss, rcs, preFunc, sharedTimestamps, removeMetricGroup)
+		tss, err = evalRollupNoIncrementalAggregate(name, rss, rcs, rrf, ec.QueryStats, preFunc, sharedTimestamps, removeMetricGroup)
 	}
 	if err != nil {
 		return nil, err
 	}
+	traceRowFilters(ec.Tracer, rrf.rfs)
 	tss = mergeTimeseries(tssCached, tss, start, ec)
 	if !isPartial {
 		rollupResultCacheV.Put(ec, expr, window, tss)
@@ -847,9 +1063,11 @@ func getRollupMemoryLimiter() *memoryLimiter {
 	return &rollupMemoryLimiter
 }
 
-func evalRollupWithIncrementalAggregate(name string, iafc *incrementalAggrFuncContext, rss *netstorage.Results, rcs []*rollupConfig,
+func evalRollupWithIncrementalAggregate(name string, iafc *incrementalAggrFuncContext, rss *netstorage.Results, rcs []*rollupConfig, rrf *rollupRowsFilter, qs *netstorage.QueryStats,
 	preFunc func(values []float64, timestamps []int64), sharedTimestamps []int64, removeMetricGroup bool) ([]*timeseries, error) {
 	err := rss.RunParallel(func(rs *netstorage.Result, workerID uint) error {
+		rrf.apply(rs)
+		qs.AddPostFilterLines(len(rs.Timestamps))
 		preFunc(rs.Values, rs.Timestamps)
 		ts := getTimeseries()
 		defer putTimeseries(ts)
@@ -878,11 +1096,13 @@ func evalRollupWithIncrementalAggregate(name string, iafc *incrementalAggrFuncCo
 	return tss, nil
 }
 
-func evalRollupNoIncrementalAggregate(name string, rss *netstorage.Results, rcs []*rollupConfig,
+func evalRollupNoIncrementalAggregate(name string, rss *netstorage.Results, rcs []*rollupConfig, rrf *rollupRowsFilter, qs *netstorage.QueryStats,
 	preFunc func(values []float64, timestamps []int64), sharedTimestamps []int64, removeMetricGroup bool) ([]*timeseries, error) {
 	tss := make([]*timeseries, 0, rss.Len()*len(rcs))
 	var tssLock sync.Mutex
 	err := rss.RunParallel(func(rs *netstorage.Result, workerID uint) error {
+		rrf.apply(rs)
+		qs.AddPostFilterLines(len(rs.Timestamps))
 		preFunc(rs.Values, rs.Timestamps)
 		for _, rc := range rcs {
 			if tsm := newTimeseriesMap(name, sharedTimestamps, &rs.MetricName); tsm != nil {
@@ -18,7 +18,10 @@ import (
 
 var logSlowQueryDuration = flag.Duration("search.logSlowQueryDuration", 5*time.Second, "Log queries with execution time exceeding this value. Zero disables slow query logging")
 
-var slowQueries = metrics.NewCounter(`vm_slow_queries_total`)
+var (
+	slowQueries     = metrics.NewCounter(`vm_slow_queries_total`)
+	queriesCanceled = metrics.NewCounter(`vm_canceled_queries_total`)
+)
 
 // Exec executes q for the given ec.
 func Exec(ec *EvalConfig, q string, isFirstPointOnly bool) ([]netstorage.Result, logql.Expr, error) {
@@ -36,14 +39,35 @@ func Exec(ec *EvalConfig, q string, isFirstPointOnly bool) ([]netstorage.Result,
 
 	ec.validate()
 
+	qtParse := ec.Tracer.NewChild("parse query %q", q)
 	e, err := parsePromQLWithCache(q)
+	qtParse.Done()
 	if err != nil {
 		return nil, e, err
 	}
 
 	qid := activeQueriesV.Add(ec, q)
-	rv, err := evalExpr(ec, e, true)
+	var rv []*timeseries
+	if me, ok := e.(*logql.MetricExpr); ok {
+		var isNumeric bool
+		isNumeric, err = hasOnlyNumericStreams(ec, me)
+		if err == nil && isNumeric {
+			// The selector matches series with numeric samples written by recording rules.
+			// Evaluate it as a metric query, so it returns values at ec.Step like Prometheus does.
+			e = &logql.RollupExpr{
+				Expr: me,
+			}
+		}
+	}
+	if err == nil {
+		rv, err = evalExpr(ec, e, true)
+	}
 	activeQueriesV.Remove(qid)
+	if ec.Deadline.Canceled() {
+		// Do not return partial results obtained before the cancellation.
+		queriesCanceled.Inc()
+		return nil, e, fmt.Errorf("the query with id=%016X has been canceled", qid)
+	}
 	if err != nil {
 		return nil, e, err
 	}
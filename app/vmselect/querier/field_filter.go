@@ -0,0 +1,130 @@
+package querier
+
+import (
+	"strconv"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logql"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+)
+
+// fieldFilter is a filter on log fields from `| name="value"` stage following `| json` or `| logfmt` stage.
+//
+// The field value is extracted from the log line. Missing field is treated as field with empty value.
+type fieldFilter struct {
+	*metadataFilter
+}
+
+func newFieldFilter(lf *logql.LabelFilter) (*fieldFilter, error) {
+	mf, err := newMetadataFilter(lf)
+	if err != nil {
+		return nil, err
+	}
+	return &fieldFilter{mf}, nil
+}
+
+// match returns true if ff matches the field from the given line.
+func (ff *fieldFilter) match(line []byte, metadata []storage.Label) bool {
+	return ff.matchValue(storage.GetLineFieldValue(line, ff.name))
+}
+
+// getUnpackFieldNames returns log fields, which must be unpacked instead of log lines for rollup over me.
+//
+// nil is returned if me needs log lines, i.e. if it has no field filters and unwrap stage
+// or if it has structured metadata filters or pattern filters.
+//
+// Only the columns for the returned fields are read from blocks with log fields split into columns,
+// so lines aren't re-assembled for such blocks. See netstorage.Results.UnpackFields.
+func getUnpackFieldNames(me *logql.MetricExpr) []string {
+	if len(me.MetadataFilters) > 0 || len(me.PatternFilters) > 0 {
+		return nil
+	}
+	var names []string
+	m := make(map[string]bool)
+	for i := range me.FieldFilters {
+		name := me.FieldFilters[i].Label
+		if !m[name] {
+			m[name] = true
+			names = append(names, name)
+		}
+	}
+	if me.Unwrap != "" && !m[me.Unwrap] {
+		names = append(names, me.Unwrap)
+	}
+	return names
+}
+
+// unwrapResult sets rs values to numeric values for the given field and drops rows without numeric value for the field.
+//
+// Field values are read from structured metadata if fieldsUnpacked is set (see netstorage.Results.UnpackFields),
+// otherwise they are extracted from log lines.
+func unwrapResult(rs *netstorage.Result, name string, fieldsUnpacked bool) {
+	var metadata []storage.Label
+	dstTimestamps := rs.Timestamps[:0]
+	dstValues := rs.Values[:0]
+	dstDatas := rs.Datas[:0]
+	for i, data := range rs.Datas {
+		line, md, err := storage.UnmarshalLineWithMetadata(metadata[:0], data)
+		if err != nil {
+			// Skip rows with invalid metadata.
+			continue
+		}
+		metadata = md
+		var value []byte
+		if fieldsUnpacked {
+			value = getMetadataValue(metadata, name)
+		} else {
+			value = storage.GetLineFieldValue(line, name)
+		}
+		v, err := strconv.ParseFloat(string(value), 64)
+		if err != nil {
+			continue
+		}
+		dstTimestamps = append(dstTimestamps, rs.Timestamps[i])
+		dstValues = append(dstValues, v)
+		dstDatas = append(dstDatas, data)
+	}
+	rs.Timestamps = dstTimestamps
+	rs.Values = dstValues
+	rs.Datas = dstDatas
+}
+
+// rollupRowsFilter filters rows fetched for rollup over a stream selector and sets their values.
+type rollupRowsFilter struct {
+	rfs []rowFilter
+
+	// unwrap is the field name from `| unwrap` stage. Rows get numeric values for the field if it is set.
+	unwrap string
+
+	// fieldNames contains log fields to unpack instead of log lines. See getUnpackFieldNames.
+	fieldNames []string
+}
+
+func newRollupRowsFilter(me *logql.MetricExpr) (*rollupRowsFilter, error) {
+	fieldNames := getUnpackFieldNames(me)
+	var rfs []rowFilter
+	var err error
+	if len(fieldNames) > 0 {
+		// Unpacked fields are passed as structured metadata, so field filters are applied as metadata filters.
+		rfs, err = newRowFilters(me.FieldFilters, nil, nil)
+	} else {
+		rfs, err = newRowFilters(me.MetadataFilters, me.PatternFilters, me.FieldFilters)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rrf := &rollupRowsFilter{
+		rfs:        rfs,
+		unwrap:     me.Unwrap,
+		fieldNames: fieldNames,
+	}
+	return rrf, nil
+}
+
+// apply removes rows from rs, which don't match rrf, and sets values for the remaining rows.
+func (rrf *rollupRowsFilter) apply(rs *netstorage.Result) {
+	filterResultByRowFilters(rs, rrf.rfs)
+	if rrf.unwrap != "" {
+		unwrapResult(rs, rrf.unwrap, len(rrf.fieldNames) > 0)
+	}
+}
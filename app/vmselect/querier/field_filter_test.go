@@ -0,0 +1,140 @@
+package querier
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logql"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+)
+
+func TestRollupRowsFilterColumnarFields(t *testing.T) {
+	storage.SetColumnarLogFields(true)
+	defer storage.SetColumnarLogFields(false)
+
+	lines := []string{
+		`{"level":"error","msg":"foo","duration":12.5}`,
+		`{"level":"info","msg":"bar","duration":3}`,
+		`{"level":"error","msg":"baz"}`,
+		`{"level":"error","msg":"qwe","duration":"7"}`,
+		`{"msg":"no level","duration":1}`,
+		`{"level":"error","duration":2}`,
+	}
+	var values [][]byte
+	var timestamps []int64
+	for i, line := range lines {
+		var metadata []storage.Label
+		if i == len(lines)-1 {
+			// Lines with structured metadata aren't split into columns.
+			metadata = []storage.Label{{Name: []byte("trace_id"), Value: []byte("abc")}}
+		}
+		values = append(values, storage.MarshalLineWithMetadata(nil, []byte(line), metadata))
+		timestamps = append(timestamps, int64(i)*1000)
+	}
+	var b storage.Block
+	b.Init(&storage.TSID{MetricID: 1}, timestamps, values, 64)
+	b.MarshalData(0, 0)
+	data := storage.MarshalBlock(nil, &b)
+
+	getRows := func(rrf *rollupRowsFilter) *netstorage.Result {
+		t.Helper()
+		var b storage.Block
+		if _, err := storage.UnmarshalBlock(&b, data); err != nil {
+			t.Fatalf("cannot unmarshal block: %s", err)
+		}
+		if len(rrf.fieldNames) > 0 {
+			if err := b.UnmarshalFields(rrf.fieldNames); err != nil {
+				t.Fatalf("cannot unmarshal fields %q: %s", rrf.fieldNames, err)
+			}
+		} else if err := b.UnmarshalData(false); err != nil {
+			t.Fatalf("cannot unmarshal block data: %s", err)
+		}
+		var rs netstorage.Result
+		tr := storage.TimeRange{
+			MinTimestamp: 0,
+			MaxTimestamp: timestamps[len(timestamps)-1],
+		}
+		rs.Timestamps, rs.Datas = b.AppendRowsWithTimeRangeFilter(nil, nil, tr)
+		for range rs.Timestamps {
+			rs.Values = append(rs.Values, 1)
+		}
+		rrf.apply(&rs)
+		return &rs
+	}
+
+	f := func(q string, fieldNamesExpected []string, timestampsExpected []int64, valuesExpected []float64) {
+		t.Helper()
+		e, err := logql.Parse(q)
+		if err != nil {
+			t.Fatalf("cannot parse %q: %s", q, err)
+		}
+		me := e.(*logql.FuncExpr).Args[0].(*logql.RollupExpr).Expr.(*logql.MetricExpr)
+		rrf, err := newRollupRowsFilter(me)
+		if err != nil {
+			t.Fatalf("cannot create rows filter for %q: %s", q, err)
+		}
+		if !reflect.DeepEqual(rrf.fieldNames, fieldNamesExpected) {
+			t.Fatalf("unexpected fields to unpack for %q; got %q; want %q", q, rrf.fieldNames, fieldNamesExpected)
+		}
+
+		// Only the columns for the needed fields must be read, without re-assembling the lines.
+		linesReadsPrev, fieldReadsPrev := storage.GetColumnarBlockReads()
+		rs := getRows(rrf)
+		linesReads, fieldReads := storage.GetColumnarBlockReads()
+		if n := linesReads - linesReadsPrev; n != 0 {
+			t.Fatalf("unexpected lines re-assembled for %q from %d blocks", q, n)
+		}
+		if n := fieldReads - fieldReadsPrev; n != uint64(len(fieldNamesExpected)) {
+			t.Fatalf("unexpected number of column reads for %q; got %d; want %d", q, n, len(fieldNamesExpected))
+		}
+		if !reflect.DeepEqual(rs.Timestamps, timestampsExpected) {
+			t.Fatalf("unexpected timestamps for %q; got %d; want %d", q, rs.Timestamps, timestampsExpected)
+		}
+		if !reflect.DeepEqual(rs.Values, valuesExpected) {
+			t.Fatalf("unexpected values for %q; got %v; want %v", q, rs.Values, valuesExpected)
+		}
+
+		// The same rows must be returned when fields are extracted from re-assembled lines.
+		rrfLines := &rollupRowsFilter{
+			unwrap: rrf.unwrap,
+		}
+		rrfLines.rfs, err = newRowFilters(nil, nil, me.FieldFilters)
+		if err != nil {
+			t.Fatalf("cannot create field filters for %q: %s", q, err)
+		}
+		rsLines := getRows(rrfLines)
+		if !reflect.DeepEqual(rsLines.Timestamps, rs.Timestamps) || !reflect.DeepEqual(rsLines.Values, rs.Values) {
+			t.Fatalf("unexpected rows from lines for %q; got %d, %v; want %d, %v", q, rsLines.Timestamps, rsLines.Values, rs.Timestamps, rs.Values)
+		}
+	}
+
+	f(`count_over_time({app="foo"} | json | level="error"[5m])`, []string{"level"},
+		[]int64{0, 2000, 3000, 5000}, []float64{1, 1, 1, 1})
+	f(`count_over_time({app="foo"} | json | level=""[5m])`, []string{"level"},
+		[]int64{4000}, []float64{1})
+	f(`sum_over_time({app="foo"} | json | unwrap duration[5m])`, []string{"duration"},
+		[]int64{0, 1000, 3000, 4000, 5000}, []float64{12.5, 3, 7, 1, 2})
+	f(`sum_over_time({app="foo"} | json | level="error" | unwrap duration[5m])`, []string{"level", "duration"},
+		[]int64{0, 3000, 5000}, []float64{12.5, 7, 2})
+	f(`sum_over_time({app="foo"} | logfmt | level=~"err.+" | msg!="foo" | unwrap duration[5m])`, []string{"level", "msg", "duration"},
+		[]int64{3000, 5000}, []float64{7, 2})
+}
+
+func TestRollupRowsFilterNeedsLines(t *testing.T) {
+	f := func(q string) {
+		t.Helper()
+		e, err := logql.Parse(q)
+		if err != nil {
+			t.Fatalf("cannot parse %q: %s", q, err)
+		}
+		me := e.(*logql.FuncExpr).Args[0].(*logql.RollupExpr).Expr.(*logql.MetricExpr)
+		if names := getUnpackFieldNames(me); len(names) > 0 {
+			t.Fatalf("unexpected fields to unpack for %q: %q", q, names)
+		}
+	}
+	f(`count_over_time({app="foo"}[5m])`)
+	f(`count_over_time({app="foo"} | json[5m])`)
+	f(`count_over_time({app="foo"} | trace_id="abc" | json | level="error"[5m])`)
+	f(`sum_over_time({app="foo"} |> "<_> error" | json | unwrap duration[5m])`)
+}
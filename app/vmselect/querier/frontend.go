@@ -0,0 +1,347 @@
+package querier
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logql"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/querytracer"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// Subquery is a part of the query, which is executed by a downstream vmselect in query frontend mode.
+//
+// See ExecFrontend.
+type Subquery struct {
+	// Query is the query to execute.
+	Query string
+
+	// Start, End and Step define points in milliseconds for the query.
+	Start int64
+	End   int64
+	Step  int64
+
+	// ShardIdx and ShardsCount limit the query to streams from the shard ShardIdx out of ShardsCount shards.
+	//
+	// See EvalConfig.ShardIdx.
+	ShardIdx    int
+	ShardsCount int
+
+	// Tracer collects the trace of the sub-query if it is enabled.
+	Tracer *querytracer.Tracer
+}
+
+// ExecFrontend executes range query q for ec by splitting it into sub-queries and merging their results.
+//
+// The query is split into sub-queries on time ranges aligned to splitInterval if this doesn't change the query result.
+// Sub-queries for shardable aggregates are additionally split into shardsCount stream shards. See IsShardableExpr.
+// Every sub-query is executed via f. Up to -search.maxParallelSubqueries sub-queries are executed in parallel.
+func ExecFrontend(ec *EvalConfig, q string, splitInterval int64, shardsCount int, f func(sq *Subquery) ([]netstorage.Result, error)) ([]netstorage.Result, logql.Expr, error) {
+	ec.validate()
+
+	qtParse := ec.Tracer.NewChild("parse query %q", q)
+	e, err := parsePromQLWithCache(q)
+	qtParse.Done()
+	if err != nil {
+		return nil, e, err
+	}
+	switch e.(type) {
+	case *logql.BinaryOpExpr, *logql.MetricExpr:
+		// Log queries are executed locally, since they are limited by ec.Limit and may be paginated.
+		return Exec(ec, q, false)
+	}
+	if !isTimeSplittableExpr(e) {
+		splitInterval = 0
+	}
+	if shardsCount <= 1 || !IsShardableExpr(e) {
+		shardsCount = 1
+	}
+	trs := splitTimeRange(ec.Start, ec.End, ec.Step, splitInterval)
+
+	// Every time range is repeated shardsCount times - a time range per each shard.
+	subTrs := make([]storage.TimeRange, 0, len(trs)*shardsCount)
+	for _, tr := range trs {
+		for i := 0; i < shardsCount; i++ {
+			subTrs = append(subTrs, tr)
+		}
+	}
+	subResults := make([][]netstorage.Result, len(subTrs))
+	qid := activeQueriesV.Add(ec, q)
+	err = runParallelTimeRanges(subTrs, func(i int, tr storage.TimeRange) error {
+		sq := &Subquery{
+			Query:       q,
+			Start:       tr.MinTimestamp,
+			End:         tr.MaxTimestamp,
+			Step:        ec.Step,
+			ShardIdx:    i % shardsCount,
+			ShardsCount: shardsCount,
+		}
+		sq.Tracer = ec.Tracer.NewChild("sub-query on the time range %s, shard %d of %d", tr.String(), sq.ShardIdx, sq.ShardsCount)
+		rs, err := f(sq)
+		sq.Tracer.Done()
+		if err != nil {
+			return err
+		}
+		subResults[i] = rs
+		return nil
+	})
+	activeQueriesV.Remove(qid)
+	frontendSubqueries.Add(len(subTrs))
+	if ec.Deadline.Canceled() {
+		queriesCanceled.Inc()
+		return nil, e, fmt.Errorf("the query with id=%016X has been canceled", qid)
+	}
+	if err != nil {
+		return nil, e, err
+	}
+
+	// Merge shards per each time range and then concatenate time ranges per each time series.
+	var rv []*timeseries
+	m := make(map[string]*timeseries)
+	bb := bbPool.Get()
+	defer bbPool.Put(bb)
+	for i, tr := range trs {
+		rss := subResults[i*shardsCount : (i+1)*shardsCount]
+		var tss []*timeseries
+		if shardsCount > 1 {
+			ecSub := newEvalConfig(ec)
+			ecSub.Start = tr.MinTimestamp
+			ecSub.End = tr.MaxTimestamp
+			tss, err = mergeShards(ecSub, e.(*logql.AggrFuncExpr), rss)
+			if err != nil {
+				return nil, e, err
+			}
+		} else {
+			tss = resultsToTimeseries(rss[0])
+		}
+		for _, ts := range tss {
+			bb.B = marshalMetricNameSorted(bb.B[:0], &ts.MetricName)
+			dst := m[string(bb.B)]
+			if dst == nil {
+				m[string(bb.B)] = ts
+				rv = append(rv, ts)
+				continue
+			}
+			dst.Timestamps = append(dst.Timestamps, ts.Timestamps...)
+			dst.Values = append(dst.Values, ts.Values...)
+		}
+	}
+
+	result, err := timeseriesToResult(rv, maySortResults(e, rv))
+	if err != nil {
+		return nil, e, err
+	}
+	return result, e, nil
+}
+
+var frontendSubqueries = metrics.NewCounter(`vm_frontend_subqueries_total`)
+
+// shardableAggrFuncs contains aggregate functions, which may be calculated independently per each stream shard.
+//
+// The value is the aggregate function for merging per-shard results.
+var shardableAggrFuncs = map[string]string{
+	"sum":   "sum",
+	"count": "sum",
+	"max":   "max",
+	"min":   "min",
+	"topk":  "topk",
+}
+
+// crossSeriesFuncs contains functions, which calculate the result from multiple time series,
+// so they cannot be calculated independently per each stream shard.
+var crossSeriesFuncs = map[string]bool{
+	"absent":             true,
+	"absent_over_time":   true,
+	"scalar":             true,
+	"histogram_quantile": true,
+	"histogram_share":    true,
+	"prometheus_buckets": true,
+	"buckets_limit":      true,
+	"union":              true,
+	"":                   true,
+}
+
+// IsShardableExpr returns true if e is an aggregate, which may be calculated independently per each stream shard
+// with the subsequent merge of per-shard results.
+//
+// The following aggregates are shardable: sum, count, max, min and topk, if their arg selects streams
+// and calculates every output time series from a single stream.
+func IsShardableExpr(e logql.Expr) bool {
+	ae, ok := e.(*logql.AggrFuncExpr)
+	if !ok || ae.Limit > 0 {
+		return false
+	}
+	name := strings.ToLower(ae.Name)
+	if _, ok := shardableAggrFuncs[name]; !ok {
+		return false
+	}
+	if name == "topk" {
+		if len(ae.Args) != 2 {
+			return false
+		}
+		if _, ok := ae.Args[0].(*logql.NumberExpr); !ok {
+			return false
+		}
+	} else if len(ae.Args) != 1 {
+		return false
+	}
+	hasSelector := false
+	isShardable := true
+	logql.VisitAll(ae.Args[len(ae.Args)-1], func(expr logql.Expr) {
+		switch t := expr.(type) {
+		case *logql.MetricExpr:
+			hasSelector = true
+		case *logql.AggrFuncExpr, *logql.BinaryOpExpr:
+			isShardable = false
+		case *logql.FuncExpr:
+			if crossSeriesFuncs[strings.ToLower(t.Name)] {
+				isShardable = false
+			}
+		}
+	})
+	return hasSelector && isShardable
+}
+
+// timeDependentFuncs contains functions, which calculate every output point from multiple points on the query time range,
+// so they cannot be calculated independently per each time range.
+var timeDependentFuncs = map[string]bool{
+	"keep_last_value":    true,
+	"keep_next_value":    true,
+	"interpolate":        true,
+	"start":              true,
+	"end":                true,
+	"running_sum":        true,
+	"running_max":        true,
+	"running_min":        true,
+	"running_avg":        true,
+	"range_sum":          true,
+	"range_max":          true,
+	"range_min":          true,
+	"range_avg":          true,
+	"range_first":        true,
+	"range_last":         true,
+	"range_quantile":     true,
+	"smooth_exponential": true,
+	"remove_resets":      true,
+	"topk_min":           true,
+	"topk_max":           true,
+	"topk_avg":           true,
+	"topk_median":        true,
+	"bottomk_min":        true,
+	"bottomk_max":        true,
+	"bottomk_avg":        true,
+	"bottomk_median":     true,
+	"any":                true,
+	"outliersk":          true,
+}
+
+// isTimeSplittableExpr returns true if e may be calculated independently per each time range.
+func isTimeSplittableExpr(e logql.Expr) bool {
+	isSplittable := true
+	logql.VisitAll(e, func(expr logql.Expr) {
+		switch t := expr.(type) {
+		case *logql.FuncExpr:
+			if timeDependentFuncs[strings.ToLower(t.Name)] {
+				isSplittable = false
+			}
+		case *logql.AggrFuncExpr:
+			// The limit selects output time series on the whole time range.
+			if t.Limit > 0 || timeDependentFuncs[strings.ToLower(t.Name)] {
+				isSplittable = false
+			}
+		}
+	})
+	return isSplittable
+}
+
+// mergeShards merges per-shard results rss for ae on ec time range.
+func mergeShards(ec *EvalConfig, ae *logql.AggrFuncExpr, rss [][]netstorage.Result) ([]*timeseries, error) {
+	sharedTimestamps := ec.getSharedTimestamps()
+	var tss []*timeseries
+	for _, rs := range rss {
+		for i := range rs {
+			tss = append(tss, resultToSharedTimeseries(&rs[i], ec.Start, ec.Step, sharedTimestamps))
+		}
+	}
+	mergeAE := *ae
+	mergeAE.Name = shardableAggrFuncs[strings.ToLower(ae.Name)]
+	args, err := evalExprs(ec, ae.Args[:len(ae.Args)-1])
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, tss)
+	afa := &aggrFuncArg{
+		ae:   &mergeAE,
+		args: args,
+		ec:   ec,
+	}
+	rv, err := getAggrFunc(mergeAE.Name)(afa)
+	if err != nil {
+		return nil, fmt.Errorf("cannot merge shards for %q: %w", ae.AppendString(nil), err)
+	}
+	return removeNaNPoints(rv), nil
+}
+
+// removeNaNPoints removes points with NaN values from tss, so they may be concatenated with time series from other time ranges.
+func removeNaNPoints(tss []*timeseries) []*timeseries {
+	rv := tss[:0]
+	for _, ts := range tss {
+		var timestamps []int64
+		var values []float64
+		for i, v := range ts.Values {
+			if !math.IsNaN(v) {
+				timestamps = append(timestamps, ts.Timestamps[i])
+				values = append(values, v)
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		ts.Timestamps = timestamps
+		ts.Values = values
+		rv = append(rv, ts)
+	}
+	return rv
+}
+
+// resultToSharedTimeseries converts rs to time series with sharedTimestamps, which start at start with the given step.
+//
+// Missing points are filled with NaNs.
+func resultToSharedTimeseries(rs *netstorage.Result, start, step int64, sharedTimestamps []int64) *timeseries {
+	ts := &timeseries{
+		Timestamps: sharedTimestamps,
+		Values:     make([]float64, len(sharedTimestamps)),
+		denyReuse:  true,
+	}
+	ts.MetricName.CopyFrom(&rs.MetricName)
+	for i := range ts.Values {
+		ts.Values[i] = nan
+	}
+	for i, timestamp := range rs.Timestamps {
+		n := timestamp - start
+		if n < 0 || n%step != 0 || n/step >= int64(len(ts.Values)) {
+			// Skip points outside the shared timestamps.
+			continue
+		}
+		ts.Values[n/step] = rs.Values[i]
+	}
+	return ts
+}
+
+// resultsToTimeseries converts rss to time series.
+func resultsToTimeseries(rss []netstorage.Result) []*timeseries {
+	tss := make([]*timeseries, len(rss))
+	for i := range rss {
+		rs := &rss[i]
+		ts := &timeseries{
+			Timestamps: append([]int64{}, rs.Timestamps...),
+			Values:     append([]float64{}, rs.Values...),
+		}
+		ts.MetricName.CopyFrom(&rs.MetricName)
+		tss[i] = ts
+	}
+	return tss
+}
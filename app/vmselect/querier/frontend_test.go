@@ -0,0 +1,210 @@
+package querier
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/searchutils"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logql"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+)
+
+func TestIsShardableExpr(t *testing.T) {
+	f := func(q string, resultExpected bool) {
+		t.Helper()
+		e, err := logql.Parse(q)
+		if err != nil {
+			t.Fatalf("cannot parse %q: %s", q, err)
+		}
+		result := IsShardableExpr(e)
+		if result != resultExpected {
+			t.Fatalf("unexpected IsShardableExpr(%q); got %v; want %v", q, result, resultExpected)
+		}
+	}
+
+	f(`sum(rate({app="foo"}[5m]))`, true)
+	f(`sum by (host) (count_over_time({app="foo"} |> "<_> error"[5m]))`, true)
+	f(`count without (host) (rate({app="foo"}[5m]))`, true)
+	f(`max(rate({app="foo"}[5m]))`, true)
+	f(`min(rate({app="foo"}[5m]))`, true)
+	f(`topk(3, rate({app="foo"}[5m]))`, true)
+	f(`sum(label_replace(rate({app="foo"}[5m]), "x", "$1", "host", "(.+)"))`, true)
+
+	// Not an aggregate
+	f(`rate({app="foo"}[5m])`, false)
+
+	// Non-shardable aggregates
+	f(`avg(rate({app="foo"}[5m]))`, false)
+	f(`quantile(0.5, rate({app="foo"}[5m]))`, false)
+	f(`sum(rate({app="foo"}[5m])) limit 10`, false)
+
+	// Non-constant k
+	f(`topk(scalar(sum(rate({app="bar"}[5m]))), rate({app="foo"}[5m]))`, false)
+
+	// Nested aggregates and binary operations
+	f(`sum(max by (host) (rate({app="foo"}[5m])))`, false)
+	f(`sum(rate({app="foo"}[5m]) / rate({app="bar"}[5m]))`, false)
+
+	// Cross-series functions
+	f(`sum(absent_over_time({app="foo"}[5m]))`, false)
+	f(`sum(histogram_quantile(0.9, rate({app="foo"}[5m])))`, false)
+
+	// Missing stream selectors
+	f(`sum(time())`, false)
+}
+
+func TestIsTimeSplittableExpr(t *testing.T) {
+	f := func(q string, resultExpected bool) {
+		t.Helper()
+		e, err := logql.Parse(q)
+		if err != nil {
+			t.Fatalf("cannot parse %q: %s", q, err)
+		}
+		result := isTimeSplittableExpr(e)
+		if result != resultExpected {
+			t.Fatalf("unexpected isTimeSplittableExpr(%q); got %v; want %v", q, result, resultExpected)
+		}
+	}
+
+	f(`rate({app="foo"}[5m])`, true)
+	f(`avg(rate({app="foo"}[5m]))`, true)
+	f(`topk(3, rate({app="foo"}[5m]))`, true)
+
+	f(`running_sum(rate({app="foo"}[5m]))`, false)
+	f(`topk_max(3, rate({app="foo"}[5m]))`, false)
+	f(`sum(rate({app="foo"}[5m])) by (host) limit 10`, false)
+}
+
+func TestExecFrontend(t *testing.T) {
+	newResult := func(label, value string, timestamps []int64, v float64) netstorage.Result {
+		var rs netstorage.Result
+		rs.MetricName.AddTag(label, value)
+		rs.Timestamps = timestamps
+		for range timestamps {
+			rs.Values = append(rs.Values, v)
+		}
+		return rs
+	}
+	getTimestamps := func(sq *Subquery) []int64 {
+		var timestamps []int64
+		for ts := sq.Start; ts <= sq.End; ts += sq.Step {
+			timestamps = append(timestamps, ts)
+		}
+		return timestamps
+	}
+	f := func(q string, shardsCount int, subqueryFunc func(sq *Subquery) []netstorage.Result, sqsExpected []Subquery, resultExpected []netstorage.Result) {
+		t.Helper()
+		ec := &EvalConfig{
+			AuthToken:  &auth.Token{},
+			Start:      0,
+			End:        50,
+			Step:       10,
+			Deadline:   searchutils.NewDeadline(time.Now(), time.Minute, ""),
+			QueryStats: &netstorage.QueryStats{},
+		}
+		var sqs []Subquery
+		var sqsLock sync.Mutex
+		result, _, err := ExecFrontend(ec, q, 30, shardsCount, func(sq *Subquery) ([]netstorage.Result, error) {
+			sqsLock.Lock()
+			sqs = append(sqs, *sq)
+			sqsLock.Unlock()
+			return subqueryFunc(sq), nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(sqs) != len(sqsExpected) {
+			t.Fatalf("unexpected number of sub-queries; got %d; want %d", len(sqs), len(sqsExpected))
+		}
+		for _, sqExpected := range sqsExpected {
+			found := false
+			for _, sq := range sqs {
+				if sq == sqExpected {
+					found = true
+					break
+				}
+			}
+			if !found {
+				t.Fatalf("missing sub-query %+v in %+v", sqExpected, sqs)
+			}
+		}
+		if len(result) != len(resultExpected) {
+			t.Fatalf("unexpected number of time series; got %d; want %d", len(result), len(resultExpected))
+		}
+		for i := range result {
+			rs := &result[i]
+			rsExpected := &resultExpected[i]
+			if rs.MetricName.String() != rsExpected.MetricName.String() {
+				t.Fatalf("unexpected metric name #%d; got %s; want %s", i, rs.MetricName.String(), rsExpected.MetricName.String())
+			}
+			if !reflect.DeepEqual(rs.Timestamps, rsExpected.Timestamps) {
+				t.Fatalf("unexpected timestamps for %s; got %v; want %v", rs.MetricName.String(), rs.Timestamps, rsExpected.Timestamps)
+			}
+			if !reflect.DeepEqual(rs.Values, rsExpected.Values) {
+				t.Fatalf("unexpected values for %s; got %v; want %v", rs.MetricName.String(), rs.Values, rsExpected.Values)
+			}
+		}
+	}
+
+	// Shardable aggregate is split by time and by shards.
+	q := `sum by (app) (rate({job="foo"}[1m]))`
+	f(q, 2, func(sq *Subquery) []netstorage.Result {
+		timestamps := getTimestamps(sq)
+		rss := []netstorage.Result{newResult("app", "a", timestamps, float64(sq.ShardIdx+1))}
+		if sq.ShardIdx == 1 {
+			rss = append(rss, newResult("app", "b", timestamps[:1], 5))
+		}
+		return rss
+	}, []Subquery{
+		{Query: q, Start: 0, End: 20, Step: 10, ShardIdx: 0, ShardsCount: 2},
+		{Query: q, Start: 0, End: 20, Step: 10, ShardIdx: 1, ShardsCount: 2},
+		{Query: q, Start: 30, End: 50, Step: 10, ShardIdx: 0, ShardsCount: 2},
+		{Query: q, Start: 30, End: 50, Step: 10, ShardIdx: 1, ShardsCount: 2},
+	}, []netstorage.Result{
+		newResult("app", "a", []int64{0, 10, 20, 30, 40, 50}, 3),
+		newResult("app", "b", []int64{0, 30}, 5),
+	})
+
+	// topk selects top time series among all the shards.
+	q = `topk(1, rate({job="foo"}[1m]))`
+	f(q, 2, func(sq *Subquery) []netstorage.Result {
+		timestamps := getTimestamps(sq)
+		if sq.ShardIdx == 0 {
+			return []netstorage.Result{newResult("host", "a", timestamps, 1)}
+		}
+		return []netstorage.Result{newResult("host", "b", timestamps, 2)}
+	}, []Subquery{
+		{Query: q, Start: 0, End: 20, Step: 10, ShardIdx: 0, ShardsCount: 2},
+		{Query: q, Start: 0, End: 20, Step: 10, ShardIdx: 1, ShardsCount: 2},
+		{Query: q, Start: 30, End: 50, Step: 10, ShardIdx: 0, ShardsCount: 2},
+		{Query: q, Start: 30, End: 50, Step: 10, ShardIdx: 1, ShardsCount: 2},
+	}, []netstorage.Result{
+		newResult("host", "b", []int64{0, 10, 20, 30, 40, 50}, 2),
+	})
+
+	// Non-shardable aggregate is split only by time.
+	q = `avg(rate({job="foo"}[1m]))`
+	f(q, 2, func(sq *Subquery) []netstorage.Result {
+		return []netstorage.Result{newResult("job", "foo", getTimestamps(sq), float64(sq.Start))}
+	}, []Subquery{
+		{Query: q, Start: 0, End: 20, Step: 10, ShardIdx: 0, ShardsCount: 1},
+		{Query: q, Start: 30, End: 50, Step: 10, ShardIdx: 0, ShardsCount: 1},
+	}, []netstorage.Result{{
+		MetricName: newResult("job", "foo", nil, 0).MetricName,
+		Timestamps: []int64{0, 10, 20, 30, 40, 50},
+		Values:     []float64{0, 0, 0, 30, 30, 30},
+	}})
+
+	// Time-dependent function isn't split.
+	q = `running_sum(rate({job="foo"}[1m]))`
+	f(q, 1, func(sq *Subquery) []netstorage.Result {
+		return []netstorage.Result{newResult("job", "foo", getTimestamps(sq), 1)}
+	}, []Subquery{
+		{Query: q, Start: 0, End: 50, Step: 10, ShardIdx: 0, ShardsCount: 1},
+	}, []netstorage.Result{
+		newResult("job", "foo", []int64{0, 10, 20, 30, 40, 50}, 1),
+	})
+}
@@ -0,0 +1,176 @@
+package querier
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logql"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// logResultCacheV caches log streams for historical time ranges obtained by splitting log queries with -search.splitQueriesByInterval.
+//
+// It shares the underlying cache with rollupResultCacheV, so it is persisted and reset together with rollupResultCacheV.
+var logResultCacheV = &logResultCache{}
+
+type logResultCache struct{}
+
+var (
+	logResultCacheHits   = metrics.NewCounter(`vm_log_result_cache_hits_total`)
+	logResultCacheMisses = metrics.NewCounter(`vm_log_result_cache_miss_total`)
+	tooBigLogResults     = metrics.NewCounter(`vm_too_big_log_results_total`)
+)
+
+// mayCacheLogStreams returns true if log streams on tr may be cached.
+//
+// Only time ranges covering the whole interval older than -search.cacheTimestampOffset may be cached,
+// since newer log lines may be ingested later.
+func (ec *EvalConfig) mayCacheLogStreams(tr storage.TimeRange, interval int64) bool {
+	if *disableCache || !ec.MayCache || interval <= 0 {
+		return false
+	}
+	if tr.MinTimestamp%interval != 0 || tr.MaxTimestamp != tr.MinTimestamp+interval-1 {
+		return false
+	}
+	deadline := (time.Now().UnixNano() / 1e6) - cacheTimestampOffset.Milliseconds()
+	return tr.MaxTimestamp <= deadline
+}
+
+// Get returns cached log streams for the given args.
+func (lrc *logResultCache) Get(ec *EvalConfig, me *logql.MetricExpr, tr storage.TimeRange, maxLines int64) ([]*timeseries, bool) {
+	bb := bbPool.Get()
+	defer bbPool.Put(bb)
+	bb.B = marshalLogResultCacheKey(bb.B[:0], ec, me, tr, maxLines)
+
+	compressedResultBuf := resultBufPool.Get()
+	defer resultBufPool.Put(compressedResultBuf)
+	compressedResultBuf.B = rollupResultCacheV.c.GetBig(compressedResultBuf.B[:0], bb.B)
+	if len(compressedResultBuf.B) == 0 {
+		logResultCacheMisses.Inc()
+		return nil, false
+	}
+	// Decompress into newly allocated byte slice, since tss returned from unmarshalLogStreams
+	// refers to the byte slice, so it cannot be returned to the resultBufPool.
+	resultBuf, err := encoding.DecompressZSTD(nil, compressedResultBuf.B)
+	if err != nil {
+		logger.Panicf("BUG: cannot decompress resultBuf from logResultCache: %s; it looks like it was improperly saved", err)
+	}
+	tss, err := unmarshalLogStreams(resultBuf)
+	if err != nil {
+		logger.Panicf("BUG: cannot unmarshal log streams from logResultCache: %s; it looks like it was improperly saved", err)
+	}
+	logResultCacheHits.Inc()
+	return tss, true
+}
+
+// Put stores tss for the given args in the cache.
+func (lrc *logResultCache) Put(ec *EvalConfig, me *logql.MetricExpr, tr storage.TimeRange, maxLines int64, tss []*timeseries) {
+	maxMarshaledSize := getRollupResultCacheSize() / 4
+	resultBuf := resultBufPool.Get()
+	defer resultBufPool.Put(resultBuf)
+	resultBuf.B = marshalLogStreams(resultBuf.B[:0], tss)
+	if len(resultBuf.B) > maxMarshaledSize {
+		tooBigLogResults.Inc()
+		return
+	}
+	compressedResultBuf := resultBufPool.Get()
+	defer resultBufPool.Put(compressedResultBuf)
+	compressedResultBuf.B = encoding.CompressZSTDLevel(compressedResultBuf.B[:0], resultBuf.B, 1)
+
+	bb := bbPool.Get()
+	defer bbPool.Put(bb)
+	bb.B = marshalLogResultCacheKey(bb.B[:0], ec, me, tr, maxLines)
+	rollupResultCacheV.c.SetBig(bb.B, compressedResultBuf.B)
+}
+
+// logResultCacheKeyPrefix distinguishes logResultCache keys from rollupResultCache keys, which start with rollupResultCacheVersion.
+const logResultCacheKeyPrefix = "logs"
+
+// Increment this value every time the format of the cache changes.
+const logResultCacheVersion = 2
+
+func marshalLogResultCacheKey(dst []byte, ec *EvalConfig, me *logql.MetricExpr, tr storage.TimeRange, maxLines int64) []byte {
+	dst = append(dst, logResultCacheKeyPrefix...)
+	dst = append(dst, logResultCacheVersion)
+	dst = encoding.MarshalUint32(dst, ec.AuthToken.AccountID)
+	dst = encoding.MarshalUint32(dst, ec.AuthToken.ProjectID)
+	dst = marshalTagFilters(dst, ec.EnforcedTagFilters)
+	dst = encoding.MarshalInt64(dst, tr.MinTimestamp)
+	dst = encoding.MarshalInt64(dst, tr.MaxTimestamp)
+	dst = encoding.MarshalInt64(dst, maxLines)
+	if ec.Forward {
+		dst = append(dst, 1)
+	} else {
+		dst = append(dst, 0)
+	}
+	dst = me.AppendString(dst)
+	return dst
+}
+
+func marshalLogStreams(dst []byte, tss []*timeseries) []byte {
+	dst = encoding.MarshalVarUint64(dst, uint64(len(tss)))
+	for _, ts := range tss {
+		dst = encoding.MarshalBytes(dst, ts.MetricName.Marshal(nil))
+		dst = encoding.MarshalVarUint64(dst, uint64(len(ts.Timestamps)))
+		for i, timestamp := range ts.Timestamps {
+			dst = encoding.MarshalInt64(dst, timestamp)
+			dst = encoding.MarshalUint64(dst, math.Float64bits(ts.Values[i]))
+			dst = encoding.MarshalBytes(dst, ts.Datas[i])
+		}
+	}
+	return dst
+}
+
+// unmarshalLogStreams unmarshals log streams from src.
+//
+// The returned log streams refer to src, so src mustn't be modified while they are in use.
+func unmarshalLogStreams(src []byte) ([]*timeseries, error) {
+	tail, n, err := encoding.UnmarshalVarUint64(src)
+	if err != nil {
+		return nil, fmt.Errorf("cannot unmarshal the number of log streams: %w", err)
+	}
+	src = tail
+	tss := make([]*timeseries, 0, n)
+	for i := uint64(0); i < n; i++ {
+		ts := &timeseries{}
+		ts.denyReuse = true
+		tail, metricName, err := encoding.UnmarshalBytes(src)
+		if err != nil {
+			return nil, fmt.Errorf("cannot unmarshal MetricName: %w", err)
+		}
+		if err := ts.MetricName.Unmarshal(metricName); err != nil {
+			return nil, fmt.Errorf("cannot unmarshal MetricName %q: %w", metricName, err)
+		}
+		src = tail
+		tail, linesCount, err := encoding.UnmarshalVarUint64(src)
+		if err != nil {
+			return nil, fmt.Errorf("cannot unmarshal the number of lines for %s: %w", &ts.MetricName, err)
+		}
+		src = tail
+		ts.Timestamps = make([]int64, 0, linesCount)
+		ts.Values = make([]float64, 0, linesCount)
+		ts.Datas = make([][]byte, 0, linesCount)
+		for j := uint64(0); j < linesCount; j++ {
+			if len(src) < 16 {
+				return nil, fmt.Errorf("cannot unmarshal timestamp and value from %d bytes; need at least 16 bytes", len(src))
+			}
+			ts.Timestamps = append(ts.Timestamps, encoding.UnmarshalInt64(src))
+			ts.Values = append(ts.Values, math.Float64frombits(encoding.UnmarshalUint64(src[8:])))
+			tail, data, err := encoding.UnmarshalBytes(src[16:])
+			if err != nil {
+				return nil, fmt.Errorf("cannot unmarshal line data: %w", err)
+			}
+			ts.Datas = append(ts.Datas, data)
+			src = tail
+		}
+		tss = append(tss, ts)
+	}
+	if len(src) > 0 {
+		return nil, fmt.Errorf("unexpected non-empty tail left after unmarshaling log streams: %d bytes", len(src))
+	}
+	return tss, nil
+}
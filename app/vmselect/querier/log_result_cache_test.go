@@ -0,0 +1,113 @@
+package querier
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logql"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+)
+
+func TestLogStreamsMarshalUnmarshal(t *testing.T) {
+	f := func(tss []*timeseries) {
+		t.Helper()
+		data := marshalLogStreams(nil, tss)
+		tss2, err := unmarshalLogStreams(data)
+		if err != nil {
+			t.Fatalf("cannot unmarshal log streams: %s", err)
+		}
+		if len(tss2) != len(tss) {
+			t.Fatalf("unexpected number of log streams; got %d; want %d", len(tss2), len(tss))
+		}
+		for i := range tss {
+			ts, ts2 := tss[i], tss2[i]
+			if ts.MetricName.String() != ts2.MetricName.String() {
+				t.Fatalf("unexpected MetricName; got %s; want %s", &ts2.MetricName, &ts.MetricName)
+			}
+			if !reflect.DeepEqual(ts.Timestamps, ts2.Timestamps) {
+				t.Fatalf("unexpected timestamps; got %v; want %v", ts2.Timestamps, ts.Timestamps)
+			}
+			if !reflect.DeepEqual(ts.Values, ts2.Values) {
+				t.Fatalf("unexpected values; got %v; want %v", ts2.Values, ts.Values)
+			}
+			if !reflect.DeepEqual(ts.Datas, ts2.Datas) {
+				t.Fatalf("unexpected datas; got %q; want %q", ts2.Datas, ts.Datas)
+			}
+		}
+	}
+	f(nil)
+
+	var ts1, ts2 timeseries
+	ts1.MetricName.AccountID = 1
+	ts1.MetricName.AddTag("app", "foo")
+	ts1.Timestamps = []int64{10, 20}
+	ts1.Values = []float64{1, 1}
+	ts1.Datas = [][]byte{[]byte("line1"), []byte("line2")}
+	ts2.MetricName.AddTag("app", "bar")
+	ts2.MetricName.AddTag("job", "baz")
+	ts2.Timestamps = []int64{15}
+	ts2.Values = []float64{1}
+	ts2.Datas = [][]byte{{}}
+	f([]*timeseries{&ts1, &ts2})
+}
+
+func TestLogResultCache(t *testing.T) {
+	ec := &EvalConfig{
+		AuthToken: &auth.Token{AccountID: 1},
+		MayCache:  true,
+	}
+	e, err := parsePromQLWithCache(`{app="foo"}`)
+	if err != nil {
+		t.Fatalf("cannot parse query: %s", err)
+	}
+	me := e.(*logql.MetricExpr)
+	const interval = 3600e3
+	tr := storage.TimeRange{
+		MinTimestamp: 10 * interval,
+		MaxTimestamp: 11*interval - 1,
+	}
+	if !ec.mayCacheLogStreams(tr, interval) {
+		t.Fatalf("expecting cacheable time range %s", &tr)
+	}
+	if _, ok := logResultCacheV.Get(ec, me, tr, 10); ok {
+		t.Fatalf("unexpected cache hit for empty cache")
+	}
+	var ts timeseries
+	ts.MetricName.AddTag("app", "foo")
+	ts.Timestamps = []int64{tr.MinTimestamp}
+	ts.Values = []float64{1}
+	ts.Datas = [][]byte{[]byte("line")}
+	logResultCacheV.Put(ec, me, tr, 10, []*timeseries{&ts})
+	tss, ok := logResultCacheV.Get(ec, me, tr, 10)
+	if !ok {
+		t.Fatalf("expecting cache hit")
+	}
+	if len(tss) != 1 || !reflect.DeepEqual(tss[0].Datas, ts.Datas) {
+		t.Fatalf("unexpected cached log streams: %v", tss)
+	}
+	// Cache entries depend on the limit.
+	if _, ok := logResultCacheV.Get(ec, me, tr, 11); ok {
+		t.Fatalf("unexpected cache hit for another limit")
+	}
+
+	// Time ranges, which don't cover the whole interval, mustn't be cached.
+	trPartial := storage.TimeRange{
+		MinTimestamp: tr.MinTimestamp + 1,
+		MaxTimestamp: tr.MaxTimestamp,
+	}
+	if ec.mayCacheLogStreams(trPartial, interval) {
+		t.Fatalf("unexpected cacheable time range %s", &trPartial)
+	}
+
+	// Recent time ranges mustn't be cached.
+	now := time.Now().UnixNano() / 1e6
+	trRecent := storage.TimeRange{
+		MinTimestamp: now - now%interval,
+		MaxTimestamp: now - now%interval + interval - 1,
+	}
+	if ec.mayCacheLogStreams(trRecent, interval) {
+		t.Fatalf("unexpected cacheable time range %s", &trRecent)
+	}
+}
@@ -0,0 +1,204 @@
+package querier
+
+import (
+	"fmt"
+	"regexp"
+	"sync/atomic"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logql"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/querytracer"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+)
+
+// rowFilter is a filter applied to the fetched log lines and their structured metadata.
+type rowFilter interface {
+	// String returns LogQL representation of the filter.
+	String() string
+
+	// match returns true if the filter matches the given line and metadata.
+	match(line []byte, metadata []storage.Label) bool
+
+	addRowsDropped(n uint64)
+	getRowsDropped() uint64
+}
+
+// rowFilterStats holds the number of rows dropped by a rowFilter. It is reported in query trace.
+type rowFilterStats struct {
+	rowsDropped uint64
+}
+
+func (fs *rowFilterStats) addRowsDropped(n uint64) {
+	atomic.AddUint64(&fs.rowsDropped, n)
+}
+
+func (fs *rowFilterStats) getRowsDropped() uint64 {
+	return atomic.LoadUint64(&fs.rowsDropped)
+}
+
+// newRowFilters returns filters for the given structured metadata filters, `|> "pattern"` stages and log field filters.
+func newRowFilters(lfs []logql.LabelFilter, pfs []string, ffs []logql.LabelFilter) ([]rowFilter, error) {
+	if len(lfs) == 0 && len(pfs) == 0 && len(ffs) == 0 {
+		return nil, nil
+	}
+	rfs := make([]rowFilter, 0, len(lfs)+len(pfs)+len(ffs))
+	for i := range lfs {
+		mf, err := newMetadataFilter(&lfs[i])
+		if err != nil {
+			return nil, err
+		}
+		rfs = append(rfs, mf)
+	}
+	for _, pattern := range pfs {
+		pf, err := newPatternFilter(pattern)
+		if err != nil {
+			return nil, err
+		}
+		rfs = append(rfs, pf)
+	}
+	for i := range ffs {
+		ff, err := newFieldFilter(&ffs[i])
+		if err != nil {
+			return nil, err
+		}
+		rfs = append(rfs, ff)
+	}
+	return rfs, nil
+}
+
+// metadataFilter is a filter on structured metadata of log lines.
+//
+// Structured metadata isn't indexed, so the filter is applied to the fetched lines.
+type metadataFilter struct {
+	rowFilterStats
+
+	name       string
+	value      string
+	re         *regexp.Regexp
+	isNegative bool
+}
+
+func newMetadataFilter(lf *logql.LabelFilter) (*metadataFilter, error) {
+	mf := &metadataFilter{
+		name:       lf.Label,
+		value:      lf.Value,
+		isNegative: lf.IsNegative,
+	}
+	if lf.IsRegexp {
+		re, err := logql.CompileRegexpAnchored(lf.Value)
+		if err != nil {
+			return nil, err
+		}
+		mf.re = re
+	}
+	return mf, nil
+}
+
+// String returns LogQL representation of mf.
+func (mf *metadataFilter) String() string {
+	var op string
+	switch {
+	case mf.re != nil && mf.isNegative:
+		op = "!~"
+	case mf.re != nil:
+		op = "=~"
+	case mf.isNegative:
+		op = "!="
+	default:
+		op = "="
+	}
+	return fmt.Sprintf("| %s%s%q", mf.name, op, mf.value)
+}
+
+// match returns true if mf matches the given metadata.
+//
+// Missing metadata label is treated as label with empty value.
+func (mf *metadataFilter) match(line []byte, metadata []storage.Label) bool {
+	return mf.matchValue(getMetadataValue(metadata, mf.name))
+}
+
+// matchValue returns true if mf matches the given label value.
+func (mf *metadataFilter) matchValue(value []byte) bool {
+	var ok bool
+	if mf.re != nil {
+		ok = mf.re.Match(value)
+	} else {
+		ok = string(value) == mf.value
+	}
+	return ok != mf.isNegative
+}
+
+// getMetadataValue returns the value for the label with the given name from metadata.
+//
+// nil is returned if metadata doesn't contain the label.
+func getMetadataValue(metadata []storage.Label, name string) []byte {
+	for i := range metadata {
+		if string(metadata[i].Name) == name {
+			return metadata[i].Value
+		}
+	}
+	return nil
+}
+
+func matchRowFilters(rfs []rowFilter, line []byte, metadata []storage.Label) bool {
+	return getMismatchedRowFilter(rfs, line, metadata) < 0
+}
+
+// getMismatchedRowFilter returns the index of the first filter in rfs, which doesn't match the given line and metadata.
+//
+// -1 is returned if all the filters match.
+func getMismatchedRowFilter(rfs []rowFilter, line []byte, metadata []storage.Label) int {
+	for i, rf := range rfs {
+		if !rf.match(line, metadata) {
+			return i
+		}
+	}
+	return -1
+}
+
+// traceRowFilters adds the number of rows dropped by every filter in rfs to qt.
+func traceRowFilters(qt *querytracer.Tracer, rfs []rowFilter) {
+	for _, rf := range rfs {
+		qt.Printf("filter `%s` dropped %d rows", rf, rf.getRowsDropped())
+	}
+}
+
+// filterResultByRowFilters removes rows from rs, which don't match rfs.
+//
+// rs must contain Datas.
+func filterResultByRowFilters(rs *netstorage.Result, rfs []rowFilter) {
+	if len(rfs) == 0 {
+		return
+	}
+	var rowsDropped []uint64
+	var metadata []storage.Label
+	dstTimestamps := rs.Timestamps[:0]
+	dstValues := rs.Values[:0]
+	dstDatas := rs.Datas[:0]
+	for i, data := range rs.Datas {
+		line, md, err := storage.UnmarshalLineWithMetadata(metadata[:0], data)
+		if err != nil {
+			// Skip rows with invalid metadata.
+			continue
+		}
+		metadata = md
+		if n := getMismatchedRowFilter(rfs, line, metadata); n >= 0 {
+			if rowsDropped == nil {
+				rowsDropped = make([]uint64, len(rfs))
+			}
+			rowsDropped[n]++
+			continue
+		}
+		dstTimestamps = append(dstTimestamps, rs.Timestamps[i])
+		dstValues = append(dstValues, rs.Values[i])
+		dstDatas = append(dstDatas, data)
+	}
+	rs.Timestamps = dstTimestamps
+	rs.Values = dstValues
+	rs.Datas = dstDatas
+	for i, n := range rowsDropped {
+		if n > 0 {
+			rfs[i].addRowsDropped(n)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package querier
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/netstorage"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logql"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/querytracer"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+)
+
+func TestFilterResultByRowFilters(t *testing.T) {
+	f := func(s string, timestampsExpected []int64) {
+		t.Helper()
+		e, err := logql.Parse(s)
+		if err != nil {
+			t.Fatalf("cannot parse %q: %s", s, err)
+		}
+		me := e.(*logql.MetricExpr)
+		rfs, err := newRowFilters(me.MetadataFilters, me.PatternFilters, me.FieldFilters)
+		if err != nil {
+			t.Fatalf("cannot create row filters: %s", err)
+		}
+		var rs netstorage.Result
+		lines := []string{"GET /a 200", "GET /b 500", "POST /a 200", "GET /c 200"}
+		for i, traceID := range []string{"", "abc", "abd", "\xff"} {
+			metadata := []storage.Label{{Name: []byte("trace_id"), Value: []byte(traceID)}}
+			rs.Timestamps = append(rs.Timestamps, int64(i))
+			rs.Values = append(rs.Values, 0)
+			rs.Datas = append(rs.Datas, storage.MarshalLineWithMetadata(nil, []byte(lines[i]), metadata))
+		}
+		filterResultByRowFilters(&rs, rfs)
+		if !reflect.DeepEqual(rs.Timestamps, timestampsExpected) {
+			t.Fatalf("unexpected timestamps for %q; got %d; want %d", s, rs.Timestamps, timestampsExpected)
+		}
+		if len(rs.Values) != len(rs.Timestamps) || len(rs.Datas) != len(rs.Timestamps) {
+			t.Fatalf("unexpected number of values and datas for %q; got %d and %d; want %d", s, len(rs.Values), len(rs.Datas), len(rs.Timestamps))
+		}
+	}
+	f(`{app="foo"}`, []int64{0, 1, 2, 3})
+	f(`{app="foo"} | trace_id="abc"`, []int64{1})
+	f(`{app="foo"} | trace_id!="abc"`, []int64{0, 2, 3})
+	f(`{app="foo"} | trace_id=""`, []int64{0})
+	f(`{app="foo"} | trace_id=~"ab."`, []int64{1, 2})
+	f(`{app="foo"} | trace_id=~"ab." | trace_id!~".+c"`, []int64{2})
+	f(`{app="foo"} | user="x"`, []int64{})
+	f(`{app="foo"} |> "GET <_> 200"`, []int64{0, 3})
+	f(`{app="foo"} |> "<_> /a <_>"`, []int64{0, 2})
+	f(`{app="foo"} | trace_id=~"ab." |> "<_> 200"`, []int64{2})
+	f(`{app="foo"} |> "DELETE <_>"`, []int64{})
+}
+
+func TestTraceRowFilters(t *testing.T) {
+	e, err := logql.Parse(`{app="foo"} | trace_id=~"ab." | trace_id!="abc" |> "GET <_>"`)
+	if err != nil {
+		t.Fatalf("cannot parse query: %s", err)
+	}
+	me := e.(*logql.MetricExpr)
+	rfs, err := newRowFilters(me.MetadataFilters, me.PatternFilters, me.FieldFilters)
+	if err != nil {
+		t.Fatalf("cannot create row filters: %s", err)
+	}
+	lines := []string{"GET /a", "GET /b", "POST /a", "GET /c", "GET /d"}
+	traceIDs := []string{"", "abc", "abd", "abe", "xyz"}
+	for n := 0; n < 2; n++ {
+		var rs netstorage.Result
+		for i, traceID := range traceIDs {
+			metadata := []storage.Label{{Name: []byte("trace_id"), Value: []byte(traceID)}}
+			rs.Timestamps = append(rs.Timestamps, int64(i))
+			rs.Values = append(rs.Values, 0)
+			rs.Datas = append(rs.Datas, storage.MarshalLineWithMetadata(nil, []byte(lines[i]), metadata))
+		}
+		filterResultByRowFilters(&rs, rfs)
+	}
+
+	qt := querytracer.New(true, "test")
+	traceRowFilters(qt, rfs)
+	qt.Done()
+	s := qt.String()
+	for _, msg := range []string{
+		"filter `| trace_id=~\"ab.\"` dropped 4 rows",
+		"filter `| trace_id!=\"abc\"` dropped 2 rows",
+		"filter `|> \"GET <_>\"` dropped 2 rows",
+	} {
+		if !strings.Contains(s, msg) {
+			t.Fatalf("missing %q in the trace:\n%s", msg, s)
+		}
+	}
+}
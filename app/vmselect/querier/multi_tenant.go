@@ -0,0 +1,46 @@
+package querier
+
+import (
+	"fmt"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/tenant"
+)
+
+// isMultiTenant returns true if ec contains multiple tenants to query.
+func (ec *EvalConfig) isMultiTenant() bool {
+	return len(ec.Tenants) > 1 || ec.TenantLabel != ""
+}
+
+func (ec *EvalConfig) tenantLabel() string {
+	if ec.TenantLabel != "" {
+		return ec.TenantLabel
+	}
+	return tenant.LabelName
+}
+
+// evalMultiTenant evaluates f for each tenant from ec.Tenants and merges the results.
+//
+// The label returned by ec.tenantLabel with the tenant org id is added to the returned time series,
+// so time series with identical labels from distinct tenants remain distinct.
+func evalMultiTenant(ec *EvalConfig, f func(ecTenant *EvalConfig) ([]*timeseries, error)) ([]*timeseries, error) {
+	labelName := ec.tenantLabel()
+	var tss []*timeseries
+	for _, t := range ec.Tenants {
+		ecTenant := newEvalConfig(ec)
+		ecTenant.AuthToken = t.AuthToken
+		ecTenant.Tenants = nil
+		ecTenant.TenantLabel = ""
+		ecTenant.Tracer = ec.Tracer.NewChild("tenant %s", t.OrgID)
+		tssTenant, err := f(ecTenant)
+		ecTenant.Tracer.Done()
+		if err != nil {
+			return nil, fmt.Errorf("cannot evaluate query for tenant %q: %w", t.OrgID, err)
+		}
+		for _, ts := range tssTenant {
+			ts.MetricName.RemoveTag(labelName)
+			ts.MetricName.AddTag(labelName, t.OrgID)
+		}
+		tss = append(tss, tssTenant...)
+	}
+	return tss, nil
+}
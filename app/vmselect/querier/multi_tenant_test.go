@@ -0,0 +1,56 @@
+package querier
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/tenant"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+)
+
+func TestEvalMultiTenant(t *testing.T) {
+	f := func(ec *EvalConfig, labelName string, valuesExpected []string) {
+		t.Helper()
+		var ats []auth.Token
+		tss, err := evalMultiTenant(ec, func(ecTenant *EvalConfig) ([]*timeseries, error) {
+			if ecTenant.isMultiTenant() {
+				return nil, fmt.Errorf("unexpected multi-tenant EvalConfig for a single tenant")
+			}
+			ats = append(ats, *ecTenant.AuthToken)
+			var ts timeseries
+			ts.MetricName.AddTag("app", "foo")
+			ts.MetricName.AddTag(labelName, "must be overwritten")
+			return []*timeseries{&ts}, nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var atsExpected []auth.Token
+		for _, tn := range ec.Tenants {
+			atsExpected = append(atsExpected, *tn.AuthToken)
+		}
+		if !reflect.DeepEqual(ats, atsExpected) {
+			t.Fatalf("unexpected tenants\ngot\n%v\nwant\n%v", ats, atsExpected)
+		}
+		var values []string
+		for _, ts := range tss {
+			values = append(values, string(ts.MetricName.GetTagValue(labelName)))
+		}
+		if !reflect.DeepEqual(values, valuesExpected) {
+			t.Fatalf("unexpected %s values\ngot\n%q\nwant\n%q", labelName, values, valuesExpected)
+		}
+	}
+
+	tenants := []*tenant.Tenant{
+		{OrgID: "team-a", AuthToken: &auth.Token{AccountID: 1}},
+		{OrgID: "team-b", AuthToken: &auth.Token{AccountID: 2, ProjectID: 3}},
+	}
+	f(&EvalConfig{Tenants: tenants}, tenant.LabelName, []string{"team-a", "team-b"})
+
+	// Cross-tenant query over a single tenant
+	crossTenants := []*tenant.Tenant{
+		tenant.NewCrossTenant(&auth.Token{AccountID: 2, ProjectID: 3}),
+	}
+	f(&EvalConfig{Tenants: crossTenants, TenantLabel: tenant.CrossTenantLabelName}, tenant.CrossTenantLabelName, []string{"2:3"})
+}
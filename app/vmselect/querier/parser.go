@@ -44,6 +44,36 @@ func IsMetricSelectorWithRollup(s string) (childQuery string, window, offset str
 	return string(wrappedQuery), re.Window, re.Offset
 }
 
+// GetQueryLookback returns the duration in milliseconds covered by range selectors and subqueries in s before the query time.
+//
+// Windows of nested subqueries are summed up. Zero is returned if s cannot be parsed.
+func GetQueryLookback(s string, step int64) int64 {
+	expr, err := parsePromQLWithCache(s)
+	if err != nil {
+		return 0
+	}
+	return getExprLookback(expr, step)
+}
+
+func getExprLookback(e logql.Expr, step int64) int64 {
+	var lookback int64
+	logql.VisitAll(e, func(expr logql.Expr) {
+		re, ok := expr.(*logql.RollupExpr)
+		if !ok || len(re.Window) == 0 {
+			return
+		}
+		window, err := logql.PositiveDurationValue(re.Window, step)
+		if err != nil {
+			// The error is returned during query execution.
+			return
+		}
+		if n := window + getExprLookback(re.Expr, step); n > lookback {
+			lookback = n
+		}
+	})
+	return lookback
+}
+
 // ParseMetricSelector parses s containing PromQL metric selector
 // and returns the corresponding LabelFilters.
 func ParseMetricSelector(s string) ([]storage.TagFilter, error) {
@@ -4,6 +4,22 @@ import (
 	"testing"
 )
 
+func TestGetQueryLookback(t *testing.T) {
+	f := func(s string, lookbackExpected int64) {
+		t.Helper()
+		lookback := GetQueryLookback(s, 60e3)
+		if lookback != lookbackExpected {
+			t.Fatalf("unexpected lookback for %q; got %d; want %d", s, lookback, lookbackExpected)
+		}
+	}
+	f(`{app="foo"}`, 0)
+	f(`count_over_time({app="foo"}[5m])`, 300e3)
+	f(`count_over_time({app="foo"}[5m] offset 1h)`, 300e3)
+	f(`sum(rate({app="foo"}[1m])) / sum(rate({app="foo"}[30d]))`, 30*24*3600e3)
+	f(`max_over_time(sum(rate({app="foo"}[5m]))[1h:1m])`, 3600e3+300e3)
+	f(`{app="foo"`, 0)
+}
+
 func TestParseMetricSelectorSuccess(t *testing.T) {
 	f := func(s string) {
 		t.Helper()
@@ -0,0 +1,38 @@
+package querier
+
+import (
+	"fmt"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmselect/patterns"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+)
+
+// patternFilter is a filter on log lines from `|> "pattern"` stage.
+type patternFilter struct {
+	rowFilterStats
+
+	pattern string
+	pm      *patterns.Matcher
+}
+
+func newPatternFilter(pattern string) (*patternFilter, error) {
+	pm, err := patterns.NewMatcher(pattern)
+	if err != nil {
+		return nil, err
+	}
+	pf := &patternFilter{
+		pattern: pattern,
+		pm:      pm,
+	}
+	return pf, nil
+}
+
+// String returns LogQL representation of pf.
+func (pf *patternFilter) String() string {
+	return fmt.Sprintf("|> %q", pf.pattern)
+}
+
+// match returns true if the line matches pf.
+func (pf *patternFilter) match(line []byte, metadata []storage.Label) bool {
+	return pf.pm.Match(line)
+}
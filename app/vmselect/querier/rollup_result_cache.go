@@ -9,7 +9,7 @@ import (
 	"time"
 
 	"github.com/VictoriaMetrics/VictoriaLogs/lib/logql"
-	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
@@ -149,7 +149,7 @@ func (rrc *rollupResultCache) Get(ec *EvalConfig, expr logql.Expr, window int64)
 	bb := bbPool.Get()
 	defer bbPool.Put(bb)
 
-	bb.B = marshalRollupResultCacheKey(bb.B[:0], ec.AuthToken, expr, window, ec.Step)
+	bb.B = marshalRollupResultCacheKey(bb.B[:0], ec, expr, window)
 	metainfoBuf := rrc.c.Get(nil, bb.B)
 	if len(metainfoBuf) == 0 {
 		return nil, ec.Start
@@ -169,7 +169,7 @@ func (rrc *rollupResultCache) Get(ec *EvalConfig, expr logql.Expr, window int64)
 	if len(compressedResultBuf.B) == 0 {
 		mi.RemoveKey(key)
 		metainfoBuf = mi.Marshal(metainfoBuf[:0])
-		bb.B = marshalRollupResultCacheKey(bb.B[:0], ec.AuthToken, expr, window, ec.Step)
+		bb.B = marshalRollupResultCacheKey(bb.B[:0], ec, expr, window)
 		rrc.c.Set(bb.B, metainfoBuf)
 		return nil, ec.Start
 	}
@@ -272,7 +272,7 @@ func (rrc *rollupResultCache) Put(ec *EvalConfig, expr logql.Expr, window int64,
 	bb.B = key.Marshal(bb.B[:0])
 	rrc.c.SetBig(bb.B, compressedResultBuf.B)
 
-	bb.B = marshalRollupResultCacheKey(bb.B[:0], ec.AuthToken, expr, window, ec.Step)
+	bb.B = marshalRollupResultCacheKey(bb.B[:0], ec, expr, window)
 	metainfoBuf := rrc.c.Get(nil, bb.B)
 	var mi rollupResultCacheMetainfo
 	if len(metainfoBuf) > 0 {
@@ -300,18 +300,29 @@ var (
 var tooBigRollupResults = metrics.NewCounter("vm_too_big_rollup_results_total")
 
 // Increment this value every time the format of the cache changes.
-const rollupResultCacheVersion = 7
+const rollupResultCacheVersion = 9
 
-func marshalRollupResultCacheKey(dst []byte, at *auth.Token, expr logql.Expr, window, step int64) []byte {
+func marshalRollupResultCacheKey(dst []byte, ec *EvalConfig, expr logql.Expr, window int64) []byte {
 	dst = append(dst, rollupResultCacheVersion)
-	dst = encoding.MarshalUint32(dst, at.AccountID)
-	dst = encoding.MarshalUint32(dst, at.ProjectID)
+	dst = encoding.MarshalUint32(dst, ec.AuthToken.AccountID)
+	dst = encoding.MarshalUint32(dst, ec.AuthToken.ProjectID)
+	dst = marshalTagFilters(dst, ec.EnforcedTagFilters)
+	dst = encoding.MarshalVarUint64(dst, uint64(ec.ShardIdx))
+	dst = encoding.MarshalVarUint64(dst, uint64(ec.ShardsCount))
 	dst = encoding.MarshalInt64(dst, window)
-	dst = encoding.MarshalInt64(dst, step)
+	dst = encoding.MarshalInt64(dst, ec.Step)
 	dst = expr.AppendString(dst)
 	return dst
 }
 
+func marshalTagFilters(dst []byte, tfs []storage.TagFilter) []byte {
+	dst = encoding.MarshalVarUint64(dst, uint64(len(tfs)))
+	for i := range tfs {
+		dst = tfs[i].Marshal(dst)
+	}
+	return dst
+}
+
 // mergeTimeseries concatenates b with a and returns the result.
 //
 // Preconditions:
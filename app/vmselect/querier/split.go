@@ -0,0 +1,173 @@
+package querier
+
+import (
+	"flag"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+)
+
+var (
+	splitQueriesByInterval = flag.Duration("search.splitQueriesByInterval", time.Hour, "Log and metric range queries are split into sub-queries on time ranges aligned to this interval. "+
+		"Sub-queries are executed in parallel, while their results on historical time ranges are cached. See also -search.cacheTimestampOffset. "+
+		"Sub-queries share the -search.maxConcurrentRequests slot of the original query, so every query may use up to -search.maxParallelSubqueries vmstorage connections. "+
+		"Zero disables query splitting")
+	maxParallelSubqueries = flag.Int("search.maxParallelSubqueries", 8, "The maximum number of sub-queries executed in parallel per each query split by -search.splitQueriesByInterval")
+)
+
+// splitInterval returns the interval in milliseconds for splitting ec time range into sub-queries.
+//
+// Zero is returned if ec mustn't be split.
+func (ec *EvalConfig) splitInterval() int64 {
+	if ec.disableSplit {
+		// Sub-queries mustn't be split again.
+		return 0
+	}
+	return splitQueriesByInterval.Milliseconds()
+}
+
+// splitTimeRange splits points start, start+step, ... up to end into time ranges aligned to interval.
+//
+// Every returned time range starts and ends at the points. A single time range is returned if interval <= 0.
+func splitTimeRange(start, end, step, interval int64) []storage.TimeRange {
+	if interval <= 0 {
+		return []storage.TimeRange{{
+			MinTimestamp: start,
+			MaxTimestamp: end,
+		}}
+	}
+	var trs []storage.TimeRange
+	for start <= end {
+		trEnd := start - start%interval + interval - 1
+		if trEnd > end {
+			trEnd = end
+		}
+		// Align trEnd to the last point in the time range.
+		trEnd -= (trEnd - start) % step
+		trs = append(trs, storage.TimeRange{
+			MinTimestamp: start,
+			MaxTimestamp: trEnd,
+		})
+		start = trEnd + step
+	}
+	return trs
+}
+
+// runParallelTimeRanges calls f for every time range from trs in parallel.
+//
+// Up to -search.maxParallelSubqueries calls are executed concurrently.
+// The first error returned from f is returned.
+func runParallelTimeRanges(trs []storage.TimeRange, f func(i int, tr storage.TimeRange) error) error {
+	concurrency := *maxParallelSubqueries
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	concurrencyCh := make(chan struct{}, concurrency)
+	errs := make([]error, len(trs))
+	var wg sync.WaitGroup
+	for i := range trs {
+		concurrencyCh <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-concurrencyCh
+				wg.Done()
+			}()
+			errs[i] = f(i, trs[i])
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// evalSplitRollup evaluates f on ec time range split into sub-queries by trs and merges the results.
+func evalSplitRollup(ec *EvalConfig, trs []storage.TimeRange, f func(ecSub *EvalConfig) ([]*timeseries, error)) ([]*timeseries, error) {
+	tssList := make([][]*timeseries, len(trs))
+	err := runParallelTimeRanges(trs, func(i int, tr storage.TimeRange) error {
+		ecSub := newEvalConfig(ec)
+		ecSub.Start = tr.MinTimestamp
+		ecSub.End = tr.MaxTimestamp
+		ecSub.disableSplit = true
+		ecSub.Tracer = ec.Tracer.NewChild("sub-query on the time range %s", tr.String())
+		tss, err := f(ecSub)
+		ecSub.Tracer.Done()
+		if err != nil {
+			return err
+		}
+		tssList[i] = tss
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mergeSplitTimeseries(ec, trs, tssList), nil
+}
+
+// mergeSplitTimeseries merges tssList evaluated on the corresponding trs into time series on the ec time range.
+//
+// Missing values are filled with NaNs.
+func mergeSplitTimeseries(ec *EvalConfig, trs []storage.TimeRange, tssList [][]*timeseries) []*timeseries {
+	sharedTimestamps := ec.getSharedTimestamps()
+	m := make(map[string]*timeseries)
+	var rvs []*timeseries
+	bb := bbPool.Get()
+	defer bbPool.Put(bb)
+	for i, tss := range tssList {
+		offset := int((trs[i].MinTimestamp - ec.Start) / ec.Step)
+		for _, ts := range tss {
+			bb.B = marshalMetricNameSorted(bb.B[:0], &ts.MetricName)
+			dst := m[string(bb.B)]
+			if dst == nil {
+				dst = &timeseries{}
+				dst.MetricName.CopyFrom(&ts.MetricName)
+				dst.Timestamps = sharedTimestamps
+				dst.Values = make([]float64, len(sharedTimestamps))
+				for j := range dst.Values {
+					dst.Values[j] = nan
+				}
+				dst.denyReuse = true
+				m[string(bb.B)] = dst
+				rvs = append(rvs, dst)
+			}
+			copy(dst.Values[offset:], ts.Values)
+		}
+	}
+	return rvs
+}
+
+// mergeLogStreams merges log streams from tssList evaluated on consecutive time ranges.
+//
+// Every returned stream contains up to maxLines lines in the forward direction.
+func mergeLogStreams(tssList [][]*timeseries, maxLines int64, forward bool) []*timeseries {
+	m := make(map[string]*timeseries)
+	var rvs []*timeseries
+	bb := bbPool.Get()
+	for _, tss := range tssList {
+		for _, ts := range tss {
+			bb.B = marshalMetricNameSorted(bb.B[:0], &ts.MetricName)
+			dst := m[string(bb.B)]
+			if dst == nil {
+				m[string(bb.B)] = ts
+				rvs = append(rvs, ts)
+				continue
+			}
+			dst.Datas = append(dst.Datas, ts.Datas...)
+			dst.Values = append(dst.Values, ts.Values...)
+			dst.Timestamps = append(dst.Timestamps, ts.Timestamps...)
+		}
+	}
+	bbPool.Put(bb)
+	for _, ts := range rvs {
+		start, end := getLogLinesRange(len(ts.Timestamps), maxLines, forward)
+		ts.Datas = ts.Datas[start:end]
+		ts.Values = ts.Values[start:end]
+		ts.Timestamps = ts.Timestamps[start:end]
+	}
+	return rvs
+}
@@ -0,0 +1,119 @@
+package querier
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+)
+
+func TestSplitTimeRange(t *testing.T) {
+	f := func(start, end, step, interval int64, trsExpected []storage.TimeRange) {
+		t.Helper()
+		trs := splitTimeRange(start, end, step, interval)
+		if !reflect.DeepEqual(trs, trsExpected) {
+			t.Fatalf("unexpected time ranges for splitTimeRange(%d, %d, %d, %d)\ngot\n%v\nwant\n%v", start, end, step, interval, trs, trsExpected)
+		}
+	}
+
+	// Splitting is disabled
+	f(5, 250, 10, 0, []storage.TimeRange{{MinTimestamp: 5, MaxTimestamp: 250}})
+
+	// Time range within a single interval
+	f(110, 190, 10, 100, []storage.TimeRange{{MinTimestamp: 110, MaxTimestamp: 190}})
+
+	// Log queries
+	f(50, 250, 1, 100, []storage.TimeRange{
+		{MinTimestamp: 50, MaxTimestamp: 99},
+		{MinTimestamp: 100, MaxTimestamp: 199},
+		{MinTimestamp: 200, MaxTimestamp: 250},
+	})
+
+	// Metric queries with points not aligned to interval
+	f(5, 250, 30, 100, []storage.TimeRange{
+		{MinTimestamp: 5, MaxTimestamp: 95},
+		{MinTimestamp: 125, MaxTimestamp: 185},
+		{MinTimestamp: 215, MaxTimestamp: 245},
+	})
+
+	// Step exceeding interval
+	f(0, 300, 150, 100, []storage.TimeRange{
+		{MinTimestamp: 0, MaxTimestamp: 0},
+		{MinTimestamp: 150, MaxTimestamp: 150},
+		{MinTimestamp: 300, MaxTimestamp: 300},
+	})
+}
+
+func TestMergeSplitTimeseries(t *testing.T) {
+	ec := &EvalConfig{
+		Start: 0,
+		End:   50,
+		Step:  10,
+	}
+	trs := splitTimeRange(ec.Start, ec.End, ec.Step, 30)
+	newTimeseries := func(app string, values ...float64) *timeseries {
+		ts := &timeseries{}
+		ts.MetricName.AddTag("app", app)
+		ts.Values = values
+		return ts
+	}
+	tss := mergeSplitTimeseries(ec, trs, [][]*timeseries{
+		{newTimeseries("foo", 1, 2, 3)},
+		{newTimeseries("bar", 4, 5, 6), newTimeseries("foo", 7, 8, 9)},
+	})
+	if len(tss) != 2 {
+		t.Fatalf("unexpected number of time series; got %d; want 2", len(tss))
+	}
+	f := func(ts *timeseries, app string, valuesExpected []float64) {
+		t.Helper()
+		if s := string(ts.MetricName.GetTagValue("app")); s != app {
+			t.Fatalf("unexpected app; got %q; want %q", s, app)
+		}
+		if !reflect.DeepEqual(ts.Timestamps, []int64{0, 10, 20, 30, 40, 50}) {
+			t.Fatalf("unexpected timestamps: %v", ts.Timestamps)
+		}
+		for i, v := range valuesExpected {
+			if math.IsNaN(v) != math.IsNaN(ts.Values[i]) || !math.IsNaN(v) && v != ts.Values[i] {
+				t.Fatalf("unexpected values for app=%q; got %v; want %v", app, ts.Values, valuesExpected)
+			}
+		}
+	}
+	f(tss[0], "foo", []float64{1, 2, 3, 7, 8, 9})
+	f(tss[1], "bar", []float64{nan, nan, nan, 4, 5, 6})
+}
+
+func TestMergeLogStreams(t *testing.T) {
+	newTimeseries := func(app string, timestamps ...int64) *timeseries {
+		ts := &timeseries{}
+		ts.MetricName.AddTag("app", app)
+		for _, timestamp := range timestamps {
+			ts.Timestamps = append(ts.Timestamps, timestamp)
+			ts.Values = append(ts.Values, 1)
+			ts.Datas = append(ts.Datas, []byte(app))
+		}
+		return ts
+	}
+	f := func(forward bool, fooExpected, barExpected []int64) {
+		t.Helper()
+		tssList := [][]*timeseries{
+			{newTimeseries("foo", 1, 2)},
+			{newTimeseries("bar", 10), newTimeseries("foo", 11, 12)},
+		}
+		tss := mergeLogStreams(tssList, 3, forward)
+		if len(tss) != 2 {
+			t.Fatalf("unexpected number of streams; got %d; want 2", len(tss))
+		}
+		if !reflect.DeepEqual(tss[0].Timestamps, fooExpected) {
+			t.Fatalf("unexpected timestamps for foo stream; got %v; want %v", tss[0].Timestamps, fooExpected)
+		}
+		if !reflect.DeepEqual(tss[1].Timestamps, barExpected) {
+			t.Fatalf("unexpected timestamps for bar stream; got %v; want %v", tss[1].Timestamps, barExpected)
+		}
+		if len(tss[0].Datas) != len(tss[0].Timestamps) || len(tss[0].Values) != len(tss[0].Timestamps) {
+			t.Fatalf("unexpected number of datas and values for foo stream")
+		}
+	}
+	f(true, []int64{1, 2, 11}, []int64{10})
+	f(false, []int64{2, 11, 12}, []int64{10})
+}
@@ -0,0 +1,51 @@
+package querier
+
+import (
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logql"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+)
+
+// TailQuery is a log stream query, which may be evaluated on rows pushed by vmstorage during live tail.
+type TailQuery struct {
+	// TagFilters contains tag filters for the stream selector.
+	TagFilters []storage.TagFilter
+
+	rfs []rowFilter
+}
+
+// ParseTailQuery parses the given query for live tail.
+//
+// false is returned if the query isn't a log stream selector with optional structured metadata, pattern and field filters,
+// so it cannot be evaluated on pushed rows.
+func ParseTailQuery(query string) (*TailQuery, bool, error) {
+	e, err := parsePromQLWithCache(query)
+	if err != nil {
+		return nil, false, err
+	}
+	me, ok := e.(*logql.MetricExpr)
+	if !ok || len(me.LabelFilters) == 0 || me.Unwrap != "" {
+		return nil, false, nil
+	}
+	rfs, err := newRowFilters(me.MetadataFilters, me.PatternFilters, me.FieldFilters)
+	if err != nil {
+		return nil, false, err
+	}
+	tq := &TailQuery{
+		TagFilters: toTagFilters(me.LabelFilters),
+		rfs:        rfs,
+	}
+	return tq, true, nil
+}
+
+// MatchData returns true if the given raw row data matches structured metadata, pattern and field filters from tq.
+func (tq *TailQuery) MatchData(data []byte) bool {
+	if len(tq.rfs) == 0 {
+		return true
+	}
+	line, metadata, err := storage.UnmarshalLineWithMetadata(nil, data)
+	if err != nil {
+		// Skip rows with invalid metadata like filterResultByRowFilters does.
+		return false
+	}
+	return matchRowFilters(tq.rfs, line, metadata)
+}
@@ -0,0 +1,218 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/timerpool"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// ErrQueueFull is returned from Scheduler.Acquire when the tenant queue is full.
+var ErrQueueFull = errors.New("the queue is full")
+
+// ErrQueueTimeout is returned from Scheduler.Acquire when the request couldn't be started during the given timeout.
+var ErrQueueTimeout = errors.New("timeout while waiting in the queue")
+
+// Scheduler limits the number of concurrently executed requests.
+//
+// Requests exceeding the limit are put in per-tenant queues. Queued requests are started
+// in weighted round-robin order over tenants, so a single tenant cannot starve the remaining tenants.
+type Scheduler struct {
+	maxConcurrent int
+
+	mu      sync.Mutex
+	running int
+	queued  int
+	queues  map[string]*tenantQueue
+
+	// ring contains tenants with non-empty queues in round-robin order.
+	ring []*tenantQueue
+
+	// next is the index of the tenant in ring, which is served next.
+	next int
+}
+
+type tenantQueue struct {
+	tenant  string
+	waiters []*waiter
+
+	// credits is the number of requests, which may be started for the tenant before switching to the next tenant in ring.
+	credits int
+	weight  int
+
+	waitDuration *metrics.Summary
+}
+
+type waiter struct {
+	// ch is closed when the request may be started.
+	ch chan struct{}
+}
+
+// New returns new Scheduler, which executes up to maxConcurrent requests concurrently.
+func New(maxConcurrent int) *Scheduler {
+	if maxConcurrent <= 0 {
+		maxConcurrent = 1
+	}
+	return &Scheduler{
+		maxConcurrent: maxConcurrent,
+		queues:        make(map[string]*tenantQueue),
+	}
+}
+
+// Acquire waits until the request for the given tenant may be started.
+//
+// weight is the number of queued requests started for the tenant in a row before switching to the next tenant.
+// maxQueueLen limits the number of queued requests for the tenant. ErrQueueFull is returned if the queue is full.
+// ErrQueueTimeout is returned if the request cannot be started during the timeout.
+//
+// Release must be called when the started request is finished.
+func (s *Scheduler) Acquire(tenant string, weight, maxQueueLen int, timeout time.Duration) error {
+	if weight <= 0 {
+		weight = 1
+	}
+	s.mu.Lock()
+	if s.running < s.maxConcurrent && s.queued == 0 {
+		s.running++
+		s.mu.Unlock()
+		return nil
+	}
+	tq := s.queues[tenant]
+	if tq == nil {
+		tq = &tenantQueue{
+			tenant:       tenant,
+			waitDuration: metrics.GetOrCreateSummary(fmt.Sprintf(`vm_select_queue_wait_duration_seconds{tenant=%q}`, tenant)),
+		}
+		s.queues[tenant] = tq
+	}
+	if maxQueueLen > 0 && len(tq.waiters) >= maxQueueLen {
+		s.mu.Unlock()
+		return ErrQueueFull
+	}
+	tq.weight = weight
+	w := &waiter{
+		ch: make(chan struct{}),
+	}
+	if len(tq.waiters) == 0 {
+		s.ring = append(s.ring, tq)
+	}
+	tq.waiters = append(tq.waiters, w)
+	s.queued++
+	s.mu.Unlock()
+	concurrencyLimitReached.Inc()
+
+	startTime := time.Now()
+	t := timerpool.Get(timeout)
+	defer timerpool.Put(t)
+	select {
+	case <-w.ch:
+		tq.waitDuration.UpdateDuration(startTime)
+		return nil
+	case <-t.C:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-w.ch:
+		// The request has been started concurrently with the timeout.
+		tq.waitDuration.UpdateDuration(startTime)
+		return nil
+	default:
+	}
+	s.removeWaiterLocked(tq, w)
+	tq.waitDuration.UpdateDuration(startTime)
+	return ErrQueueTimeout
+}
+
+// Release must be called when the request started via Acquire is finished.
+func (s *Scheduler) Release() {
+	s.mu.Lock()
+	s.running--
+	for s.running < s.maxConcurrent && s.queued > 0 {
+		s.startNextLocked()
+	}
+	s.mu.Unlock()
+}
+
+// startNextLocked starts the next queued request in weighted round-robin order.
+func (s *Scheduler) startNextLocked() {
+	if s.next >= len(s.ring) {
+		s.next = 0
+	}
+	tq := s.ring[s.next]
+	if tq.credits <= 0 {
+		tq.credits = tq.weight
+	}
+	w := tq.waiters[0]
+	tq.waiters[0] = nil
+	tq.waiters = tq.waiters[1:]
+	tq.credits--
+	s.queued--
+	s.running++
+	close(w.ch)
+
+	if len(tq.waiters) == 0 {
+		// The removed tenant is replaced by the next tenant at s.next.
+		s.removeFromRingLocked(s.next)
+		return
+	}
+	if tq.credits <= 0 {
+		s.next++
+	}
+}
+
+func (s *Scheduler) removeWaiterLocked(tq *tenantQueue, w *waiter) {
+	for i, x := range tq.waiters {
+		if x != w {
+			continue
+		}
+		tq.waiters = append(tq.waiters[:i], tq.waiters[i+1:]...)
+		s.queued--
+		break
+	}
+	if len(tq.waiters) > 0 {
+		return
+	}
+	for i, x := range s.ring {
+		if x == tq {
+			s.removeFromRingLocked(i)
+			if i < s.next {
+				s.next--
+			}
+			return
+		}
+	}
+}
+
+func (s *Scheduler) removeFromRingLocked(i int) {
+	tq := s.ring[i]
+	tq.credits = 0
+	s.ring = append(s.ring[:i], s.ring[i+1:]...)
+	delete(s.queues, tq.tenant)
+}
+
+var concurrencyLimitReached = metrics.NewCounter(`vm_concurrent_select_limit_reached_total`)
+
+// Capacity returns the maximum number of concurrently executed requests.
+func (s *Scheduler) Capacity() int {
+	return s.maxConcurrent
+}
+
+// Running returns the number of currently executed requests.
+func (s *Scheduler) Running() int {
+	s.mu.Lock()
+	n := s.running
+	s.mu.Unlock()
+	return n
+}
+
+// Queued returns the number of queued requests.
+func (s *Scheduler) Queued() int {
+	s.mu.Lock()
+	n := s.queued
+	s.mu.Unlock()
+	return n
+}
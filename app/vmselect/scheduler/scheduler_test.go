@@ -0,0 +1,113 @@
+package scheduler
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSchedulerRoundRobin(t *testing.T) {
+	f := func(requests []string, weights map[string]int, orderExpected []string) {
+		t.Helper()
+		s := New(1)
+		if err := s.Acquire("busy", 1, 0, time.Second); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		startedCh := make(chan string, len(requests))
+		for i, tenant := range requests {
+			go func(tenant string) {
+				if err := s.Acquire(tenant, weights[tenant], 0, 10*time.Second); err != nil {
+					panic(err)
+				}
+				startedCh <- tenant
+			}(tenant)
+			waitForQueued(t, s, i+1)
+		}
+		var order []string
+		for range requests {
+			s.Release()
+			order = append(order, <-startedCh)
+		}
+		s.Release()
+		if !reflect.DeepEqual(order, orderExpected) {
+			t.Fatalf("unexpected order of started requests\ngot\n%q\nwant\n%q", order, orderExpected)
+		}
+		if n := s.Running(); n != 0 {
+			t.Fatalf("unexpected number of running requests; got %d; want 0", n)
+		}
+	}
+
+	// A single tenant
+	f([]string{"a", "a"}, nil, []string{"a", "a"})
+
+	// A tenant with many queued requests doesn't starve the remaining tenants
+	f([]string{"a", "a", "a", "b", "c"}, nil, []string{"a", "b", "c", "a", "a"})
+
+	// Weighted round-robin
+	f([]string{"a", "a", "a", "a", "b", "b"}, map[string]int{"a": 2}, []string{"a", "a", "b", "a", "a", "b"})
+}
+
+func TestSchedulerQueueFull(t *testing.T) {
+	s := New(1)
+	if err := s.Acquire("a", 1, 1, time.Second); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	doneCh := make(chan error, 1)
+	go func() {
+		doneCh <- s.Acquire("a", 1, 1, 10*time.Second)
+	}()
+	waitForQueued(t, s, 1)
+
+	if err := s.Acquire("a", 1, 1, time.Second); err != ErrQueueFull {
+		t.Fatalf("expecting ErrQueueFull; got %v", err)
+	}
+
+	// Other tenants have their own queues.
+	go func() {
+		doneCh <- s.Acquire("b", 1, 1, 10*time.Second)
+	}()
+	waitForQueued(t, s, 2)
+
+	s.Release()
+	s.Release()
+	for i := 0; i < 2; i++ {
+		if err := <-doneCh; err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	s.Release()
+	if n := s.Running(); n != 0 {
+		t.Fatalf("unexpected number of running requests; got %d; want 0", n)
+	}
+}
+
+func TestSchedulerTimeout(t *testing.T) {
+	s := New(1)
+	if err := s.Acquire("a", 1, 0, time.Second); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := s.Acquire("b", 1, 0, 10*time.Millisecond); err != ErrQueueTimeout {
+		t.Fatalf("expecting ErrQueueTimeout; got %v", err)
+	}
+	if n := s.Queued(); n != 0 {
+		t.Fatalf("timed out request must be removed from the queue; got %d queued requests", n)
+	}
+	s.Release()
+
+	// The scheduler must accept new requests after the timeout.
+	if err := s.Acquire("b", 1, 0, time.Second); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	s.Release()
+}
+
+func waitForQueued(t *testing.T, s *Scheduler, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Queued() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout while waiting for %d queued requests; got %d", n, s.Queued())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -1,6 +1,7 @@
 package searchutils
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"math"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	"github.com/VictoriaMetrics/VictoriaLogs/lib/logql"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
 )
 
@@ -192,12 +194,70 @@ func GetDenyPartialResponse(r *http.Request) bool {
 	return GetBool(r, "deny_partial_response")
 }
 
+type enforcedTagFiltersKey struct{}
+
+// WithEnforcedTagFilters returns a copy of r with etfs, which must be added to all the tag filters built for r.
+//
+// See GetEnforcedTagFilters and JoinTagFilterss.
+func WithEnforcedTagFilters(r *http.Request, etfs []storage.TagFilter) *http.Request {
+	if len(etfs) == 0 {
+		return r
+	}
+	ctx := context.WithValue(r.Context(), enforcedTagFiltersKey{}, etfs)
+	return r.WithContext(ctx)
+}
+
+// GetEnforcedTagFilters returns tag filters, which must be added to all the tag filters built for r.
+func GetEnforcedTagFilters(r *http.Request) []storage.TagFilter {
+	etfs, _ := r.Context().Value(enforcedTagFiltersKey{}).([]storage.TagFilter)
+	return etfs
+}
+
+type queueDurationKey struct{}
+
+// WithQueueDuration returns a copy of r with the duration d spent by r in the query scheduler queue.
+//
+// See GetQueueDuration.
+func WithQueueDuration(r *http.Request, d time.Duration) *http.Request {
+	ctx := context.WithValue(r.Context(), queueDurationKey{}, d)
+	return r.WithContext(ctx)
+}
+
+// GetQueueDuration returns the duration spent by r in the query scheduler queue.
+func GetQueueDuration(r *http.Request) time.Duration {
+	d, _ := r.Context().Value(queueDurationKey{}).(time.Duration)
+	return d
+}
+
+// JoinTagFilterss adds etfs to every item in src and returns the result.
+//
+// src isn't modified. [etfs] is returned if src is empty.
+func JoinTagFilterss(src [][]storage.TagFilter, etfs []storage.TagFilter) [][]storage.TagFilter {
+	if len(etfs) == 0 {
+		return src
+	}
+	if len(src) == 0 {
+		src = [][]storage.TagFilter{nil}
+	}
+	dst := make([][]storage.TagFilter, 0, len(src))
+	for _, tfs := range src {
+		tfsNew := make([]storage.TagFilter, 0, len(tfs)+len(etfs))
+		tfsNew = append(tfsNew, tfs...)
+		tfsNew = append(tfsNew, etfs...)
+		dst = append(dst, tfsNew)
+	}
+	return dst
+}
+
 // Deadline contains deadline with the corresponding timeout for pretty error messages.
 type Deadline struct {
 	deadline uint64
 
 	timeout  time.Duration
 	flagHint string
+
+	// cancelCh is closed when the query is canceled. It is nil for queries, which cannot be canceled.
+	cancelCh <-chan struct{}
 }
 
 // NewDeadline returns deadline for the given timeout.
@@ -212,9 +272,51 @@ func NewDeadline(startTime time.Time, timeout time.Duration, flagHint string) De
 	}
 }
 
-// Exceeded returns true if deadline is exceeded.
+// WithCancel returns a copy of d, which is exceeded when cancelCh is closed.
+func (d Deadline) WithCancel(cancelCh <-chan struct{}) Deadline {
+	d.cancelCh = cancelCh
+	return d
+}
+
+// Exceeded returns true if deadline is exceeded or the query has been canceled.
 func (d *Deadline) Exceeded() bool {
-	return fasttime.UnixTimestamp() > d.deadline
+	return fasttime.UnixTimestamp() > d.deadline || d.Canceled()
+}
+
+// Canceled returns true if the query has been canceled.
+func (d *Deadline) Canceled() bool {
+	select {
+	case <-d.cancelCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// CancelCh returns channel, which is closed when the query is canceled.
+//
+// nil is returned if the query cannot be canceled.
+func (d *Deadline) CancelCh() <-chan struct{} {
+	return d.cancelCh
+}
+
+// Context returns a copy of parent, which is canceled when d is exceeded or the query is canceled.
+//
+// The returned cancel func must be called when the context is no longer needed.
+func (d *Deadline) Context(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithDeadline(parent, time.Unix(int64(d.deadline), 0))
+	if d.cancelCh == nil {
+		return ctx, cancel
+	}
+	cancelCh := d.cancelCh
+	go func() {
+		select {
+		case <-cancelCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
 }
 
 // Deadline returns deadline in unix timestamp seconds.
@@ -224,5 +326,8 @@ func (d *Deadline) Deadline() uint64 {
 
 // String returns human-readable string representation for d.
 func (d *Deadline) String() string {
+	if d.Canceled() {
+		return "the query has been canceled"
+	}
 	return fmt.Sprintf("%.3f seconds; the timeout can be adjusted with `%s` command-line flag", d.timeout.Seconds(), d.flagHint)
 }
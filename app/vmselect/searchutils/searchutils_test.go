@@ -1,10 +1,15 @@
 package searchutils
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/url"
+	"reflect"
 	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
 )
 
 func TestGetTimeSuccess(t *testing.T) {
@@ -76,3 +81,115 @@ func TestGetTimeError(t *testing.T) {
 	f("-292273086-05-16T16:47:07Z")
 	f("292277025-08-18T07:12:54.999999998Z")
 }
+
+func TestDeadlineWithCancel(t *testing.T) {
+	d := NewDeadline(time.Now(), time.Hour, "-search.maxQueryDuration")
+	if d.Canceled() {
+		t.Fatalf("deadline without cancelCh mustn't be canceled")
+	}
+	if d.CancelCh() != nil {
+		t.Fatalf("expecting nil CancelCh for deadline without cancelCh")
+	}
+
+	cancelCh := make(chan struct{})
+	dc := d.WithCancel(cancelCh)
+	if dc.Exceeded() || dc.Canceled() {
+		t.Fatalf("deadline mustn't be exceeded before the cancel")
+	}
+	close(cancelCh)
+	if !dc.Canceled() {
+		t.Fatalf("deadline must be canceled after closing cancelCh")
+	}
+	if !dc.Exceeded() {
+		t.Fatalf("canceled deadline must be exceeded")
+	}
+	if s := dc.String(); s != "the query has been canceled" {
+		t.Fatalf("unexpected String() for canceled deadline: %q", s)
+	}
+	if d.Canceled() {
+		t.Fatalf("the original deadline mustn't be canceled")
+	}
+}
+
+func TestDeadlineContext(t *testing.T) {
+	d := NewDeadline(time.Now(), time.Hour, "-search.maxQueryDuration")
+	ctx, cancel := d.Context(context.Background())
+	if ctx.Err() != nil {
+		t.Fatalf("unexpected error for the context of not exceeded deadline: %s", ctx.Err())
+	}
+	cancel()
+
+	cancelCh := make(chan struct{})
+	dc := d.WithCancel(cancelCh)
+	ctx, cancel = dc.Context(context.Background())
+	defer cancel()
+	if ctx.Err() != nil {
+		t.Fatalf("unexpected error for the context before the cancel: %s", ctx.Err())
+	}
+	close(cancelCh)
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the context must be canceled after closing cancelCh")
+	}
+}
+
+func TestJoinTagFilterss(t *testing.T) {
+	tf := func(key, value string, isNegative bool) storage.TagFilter {
+		return storage.TagFilter{
+			Key:        []byte(key),
+			Value:      []byte(value),
+			IsNegative: isNegative,
+		}
+	}
+	f := func(src, resultExpected [][]storage.TagFilter, etfs []storage.TagFilter) {
+		t.Helper()
+		result := JoinTagFilterss(src, etfs)
+		if !reflect.DeepEqual(result, resultExpected) {
+			t.Fatalf("unexpected result; got %v; want %v", result, resultExpected)
+		}
+	}
+	app := tf("app", "foo", false)
+	job := tf("job", "bar", false)
+	ns := tf("namespace", "payments", true)
+
+	// Without enforced filters
+	f(nil, nil, nil)
+	f([][]storage.TagFilter{{app}}, [][]storage.TagFilter{{app}}, nil)
+
+	// Enforced filters without src
+	f(nil, [][]storage.TagFilter{{ns}}, []storage.TagFilter{ns})
+
+	// Enforced filters are added to every src item
+	f([][]storage.TagFilter{{app}, {job}}, [][]storage.TagFilter{{app, ns}, {job, ns}}, []storage.TagFilter{ns})
+
+	// src mustn't be modified
+	src := [][]storage.TagFilter{make([]storage.TagFilter, 1, 2)}
+	src[0][0] = app
+	f(src, [][]storage.TagFilter{{app, ns}}, []storage.TagFilter{ns})
+	if len(src[0]) != 1 || src[0][:2][1].Key != nil {
+		t.Fatalf("src mustn't be modified")
+	}
+}
+
+func TestEnforcedTagFilters(t *testing.T) {
+	r, err := http.NewRequest("GET", "http://foo.bar/baz", nil)
+	if err != nil {
+		t.Fatalf("unexpected error in NewRequest: %s", err)
+	}
+	if etfs := GetEnforcedTagFilters(r); etfs != nil {
+		t.Fatalf("expecting nil enforced tag filters; got %v", etfs)
+	}
+	etfs := []storage.TagFilter{{
+		Key:        []byte("namespace"),
+		Value:      []byte("payments"),
+		IsNegative: true,
+	}}
+	r2 := WithEnforcedTagFilters(r, etfs)
+	if result := GetEnforcedTagFilters(r2); !reflect.DeepEqual(result, etfs) {
+		t.Fatalf("unexpected enforced tag filters; got %v; want %v", result, etfs)
+	}
+	if result := GetEnforcedTagFilters(r); result != nil {
+		t.Fatalf("the original request mustn't be modified; got %v", result)
+	}
+}
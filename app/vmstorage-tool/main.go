@@ -0,0 +1,235 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logql"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/buildinfo"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/envflag"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+var (
+	storageDataPath = flag.String("storageDataPath", "vmstorage-data", "Path to vmstorage data to inspect. The data is never modified by vmstorage-tool")
+	partition       = flag.String("partition", "", "Optional partition name in the form YYYY_MM to limit `list` and `verify` commands to")
+
+	match     = flag.String("match", "", "Stream selector for the `dump` command, e.g. '{job=\"foo\"}'")
+	accountID = flag.Uint("accountID", 0, "AccountID for the `dump` command")
+	projectID = flag.Uint("projectID", 0, "ProjectID for the `dump` command")
+	start     = flag.String("start", "", "Start time for the `dump` command in RFC3339 or unix seconds. Defaults to -end minus one hour")
+	end       = flag.String("end", "", "End time for the `dump` command in RFC3339 or unix seconds. Defaults to the current time")
+	limit     = flag.Int("limit", 1000, "The maximum number of streams to dump")
+
+	maxErrorsPerPart = flag.Int("maxErrorsPerPart", 10, "The maximum number of errors to report per part in the `verify` command")
+)
+
+func main() {
+	// Write flags and help message to stdout, since it is easier to grep or pipe.
+	flag.CommandLine.SetOutput(os.Stdout)
+	flag.Usage = usage
+	envflag.Parse()
+	buildinfo.Init()
+	logger.Init()
+
+	if flag.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+	var err error
+	switch cmd := flag.Arg(0); cmd {
+	case "list":
+		err = listParts()
+	case "verify":
+		var corruptedParts int
+		corruptedParts, err = verifyParts()
+		if err == nil && corruptedParts > 0 {
+			logger.Errorf("found %d corrupted parts", corruptedParts)
+			os.Exit(1)
+		}
+	case "dump":
+		err = dumpBlocks()
+	default:
+		usage()
+		logger.Fatalf("unsupported command %q", cmd)
+	}
+	if err != nil {
+		logger.Fatalf("%s", err)
+	}
+}
+
+func listParts() error {
+	pis, err := storage.ListParts(*storageDataPath)
+	if err != nil {
+		return fmt.Errorf("cannot list parts at %q: %w", *storageDataPath, err)
+	}
+	fmt.Printf("partition\ttype\trows\tblocks\tminTime\tmaxTime\tsizeBytes\tpath\n")
+	for _, pi := range pis {
+		if *partition != "" && pi.Partition != *partition {
+			continue
+		}
+		fmt.Printf("%s\t%s\t%d\t%d\t%s\t%s\t%d\t%s\n", pi.Partition, partType(pi.IsBig), pi.RowsCount, pi.BlocksCount,
+			timestampToString(pi.MinTimestamp), timestampToString(pi.MaxTimestamp), pi.SizeBytes, pi.Path)
+	}
+	return nil
+}
+
+func verifyParts() (int, error) {
+	pis, err := storage.ListParts(*storageDataPath)
+	if err != nil {
+		return 0, fmt.Errorf("cannot list parts at %q: %w", *storageDataPath, err)
+	}
+	corruptedParts := 0
+	for _, pi := range pis {
+		if *partition != "" && pi.Partition != *partition {
+			continue
+		}
+		startTime := time.Now()
+		pvr := storage.VerifyPart(pi.Path, *maxErrorsPerPart)
+		if !pvr.IsCorrupted() {
+			logger.Infof("part %q is ok; verified %d blocks with %d rows in %.3f seconds", pi.Path, pvr.BlocksCount, pvr.RowsCount, time.Since(startTime).Seconds())
+			continue
+		}
+		corruptedParts++
+		logger.Errorf("part %q is corrupted; found %d errors:", pi.Path, len(pvr.Errors))
+		for _, err := range pvr.Errors {
+			logger.Errorf("  %s", err)
+		}
+		quarantinePath := filepath.Join(*storageDataPath, "quarantine", partType(pi.IsBig), pi.Partition)
+		logger.Errorf("stop vmstorage and move the part to quarantine in order to exclude it from queries and merges: "+
+			"mkdir -p %q && mv %q %q", quarantinePath, pi.Path, quarantinePath+"/")
+	}
+	return corruptedParts, nil
+}
+
+func dumpBlocks() error {
+	if *match == "" {
+		return fmt.Errorf("missing -match flag for the `dump` command")
+	}
+	tagFilters, err := parseSelector(*match)
+	if err != nil {
+		return fmt.Errorf("cannot parse -match=%q: %w", *match, err)
+	}
+	tr, err := getTimeRange()
+	if err != nil {
+		return err
+	}
+
+	tfs := storage.NewTagFilters(uint32(*accountID), uint32(*projectID))
+	for i := range tagFilters {
+		tf := &tagFilters[i]
+		if err := tfs.Add(tf.Key, tf.Value, tf.IsNegative, tf.IsRegexp); err != nil {
+			return fmt.Errorf("cannot parse tag filter %s: %w", tf, err)
+		}
+	}
+	tfss := append([]*storage.TagFilters{tfs}, tfs.Finalize()...)
+
+	var mn storage.MetricName
+	err = storage.DumpRows(*storageDataPath, tfss, tr, *limit, func(metricName []byte, timestamps []int64, values [][]byte) error {
+		if err := mn.Unmarshal(metricName); err != nil {
+			return fmt.Errorf("cannot unmarshal metric name: %w", err)
+		}
+		streamName := mn.String()
+		for i, ts := range timestamps {
+			fmt.Printf("%s\t%s\t%s\n", timestampToString(ts), streamName, values[i])
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot dump rows from %q: %w", *storageDataPath, err)
+	}
+	return nil
+}
+
+func parseSelector(s string) ([]storage.TagFilter, error) {
+	expr, err := logql.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	me, ok := expr.(*logql.MetricExpr)
+	if !ok {
+		return nil, fmt.Errorf("expecting stream selector; got %q", expr.AppendString(nil))
+	}
+	if len(me.LabelFilters) == 0 {
+		return nil, fmt.Errorf("labelFilters cannot be empty")
+	}
+	tfs := make([]storage.TagFilter, len(me.LabelFilters))
+	for i, lf := range me.LabelFilters {
+		if lf.Label != "__name__" {
+			tfs[i].Key = []byte(lf.Label)
+		}
+		tfs[i].Value = []byte(lf.Value)
+		tfs[i].IsRegexp = lf.IsRegexp
+		tfs[i].IsNegative = lf.IsNegative
+	}
+	return tfs, nil
+}
+
+func getTimeRange() (storage.TimeRange, error) {
+	endTime := time.Now()
+	if *end != "" {
+		t, err := parseTime(*end)
+		if err != nil {
+			return storage.TimeRange{}, fmt.Errorf("cannot parse -end=%q: %w", *end, err)
+		}
+		endTime = t
+	}
+	startTime := endTime.Add(-time.Hour)
+	if *start != "" {
+		t, err := parseTime(*start)
+		if err != nil {
+			return storage.TimeRange{}, fmt.Errorf("cannot parse -start=%q: %w", *start, err)
+		}
+		startTime = t
+	}
+	if startTime.After(endTime) {
+		return storage.TimeRange{}, fmt.Errorf("-start=%q cannot exceed -end=%q", *start, *end)
+	}
+	return storage.TimeRange{
+		MinTimestamp: startTime.UnixNano() / 1e6,
+		MaxTimestamp: endTime.UnixNano() / 1e6,
+	}, nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Unix(0, int64(secs*1e9)), nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+func partType(isBig bool) string {
+	if isBig {
+		return "big"
+	}
+	return "small"
+}
+
+func timestampToString(timestamp int64) string {
+	return time.Unix(0, timestamp*1e6).UTC().Format(time.RFC3339Nano)
+}
+
+func usage() {
+	const s = `
+vmstorage-tool inspects vmstorage data offline.
+
+Usage: vmstorage-tool [flags] <command>
+
+Commands:
+  list    - list partitions and parts with their rows, blocks, time range and size
+  verify  - verify that all the blocks in all the parts can be read and decompressed,
+            and that metaindex and index entries are consistent. Corrupted parts are reported
+            together with a suggested quarantine command
+  dump    - dump log lines for the stream selector from -match on the time range [-start ... -end].
+            Lines are printed per block, so they aren't sorted across blocks and parts.
+            Lines, which weren't flushed to disk yet by a running vmstorage, aren't dumped
+
+`
+	fmt.Fprintf(flag.CommandLine.Output(), "%s", s)
+	flag.PrintDefaults()
+}
@@ -1,15 +1,18 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vmstorage/transport"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/clustertls"
 	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/buildinfo"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/cgroup"
@@ -23,13 +26,14 @@ import (
 )
 
 var (
-	retentionPeriod   = flagutil.NewDuration("retentionPeriod", 1, "Data with timestamps outside the retentionPeriod is automatically deleted")
-	httpListenAddr    = flag.String("httpListenAddr", ":8482", "Address to listen for http connections")
-	storageDataPath   = flag.String("storageDataPath", "vmstorage-data", "Path to storage data")
-	vminsertAddr      = flag.String("vminsertAddr", ":8400", "TCP address to accept connections from vminsert services")
-	vmselectAddr      = flag.String("vmselectAddr", ":8401", "TCP address to accept connections from vmselect services")
-	snapshotAuthKey   = flag.String("snapshotAuthKey", "", "authKey, which must be passed in query string to /snapshot* pages")
-	forceMergeAuthKey = flag.String("forceMergeAuthKey", "", "authKey, which must be passed in query string to /internal/force_merge pages")
+	retentionPeriod    = flagutil.NewDuration("retentionPeriod", 1, "Data with timestamps outside the retentionPeriod is automatically deleted")
+	httpListenAddr     = flag.String("httpListenAddr", ":8482", "Address to listen for http connections")
+	storageDataPath    = flag.String("storageDataPath", "vmstorage-data", "Path to storage data")
+	vminsertAddr       = flag.String("vminsertAddr", ":8400", "TCP address to accept connections from vminsert services")
+	vmselectAddr       = flag.String("vmselectAddr", ":8401", "TCP address to accept connections from vmselect services")
+	snapshotAuthKey    = flag.String("snapshotAuthKey", "", "authKey, which must be passed in query string to /snapshot* pages")
+	forceMergeAuthKey  = flag.String("forceMergeAuthKey", "", "authKey, which must be passed in query string to /internal/force_merge pages")
+	streamChurnAuthKey = flag.String("streamChurnAuthKey", "", "authKey, which must be passed in query string to /internal/stream_churn pages")
 
 	finalMergeDelay = flag.Duration("finalMergeDelay", 30*time.Second, "The delay before starting final merge for per-month partition after no new data is ingested into it. "+
 		"Query speed and disk space usage is usually reduced after the final merge is complete. Too low delay for final merge may result in increased "+
@@ -39,6 +43,18 @@ var (
 	minScrapeInterval     = flag.Duration("dedup.minScrapeInterval", 0, "Remove superflouos samples from time series if they are located closer to each other than this duration. "+
 		"This may be useful for reducing overhead when multiple identically configured Prometheus instances write data to the same VictoriaMetrics. "+
 		"Deduplication is disabled if the -dedup.minScrapeInterval is 0")
+	valuesDictSize = flag.Int("valuesDictSize", 0, "The size in bytes of zstd dictionary trained per partition during big merges for compressing log lines. "+
+		"Dictionaries usually improve compression ratio for small blocks with repetitive log lines at the cost of higher CPU usage during big merges. "+
+		"Dictionaries aren't trained if set to 0. Dictionary training requires vmstorage built with CGO_ENABLED=1")
+	columnarLogFields = flag.Bool("columnarLogFields", false, "Whether to split compact JSON and logfmt log lines into per-field columns inside blocks. "+
+		"Lines are re-assembled on read, while reading a single field doesn't require decompressing the whole lines. "+
+		"Lines, which cannot be split into columns, are stored as is")
+	maxNewStreamsPerHour = flag.Int("storage.maxNewStreamsPerHour", 0, "The maximum number of new streams, which may be created per tenant during the current hour. "+
+		"Rows for streams exceeding the limit are dropped. There is no limit if set to 0. See also /internal/stream_churn page")
+	maxNewStreamsPerDay = flag.Int("storage.maxNewStreamsPerDay", 0, "The maximum number of new streams, which may be created per tenant during the current day. "+
+		"Rows for streams exceeding the limit are dropped. There is no limit if set to 0. See also /internal/stream_churn page")
+	maxActiveStreams = flag.Int("storage.maxActiveStreams", 0, "The maximum number of active streams per tenant. A stream is active if it received rows during the last hour. "+
+		"Rows for streams exceeding the limit are dropped. There is no limit if set to 0")
 )
 
 func main() {
@@ -53,6 +69,9 @@ func main() {
 	storage.SetFinalMergeDelay(*finalMergeDelay)
 	storage.SetBigMergeWorkersCount(*bigMergeConcurrency)
 	storage.SetSmallMergeWorkersCount(*smallMergeConcurrency)
+	storage.SetValuesDictSize(*valuesDictSize)
+	storage.SetColumnarLogFields(*columnarLogFields)
+	storage.SetStreamLimits(*maxNewStreamsPerHour, *maxNewStreamsPerDay, *maxActiveStreams)
 
 	logger.Infof("opening storage at %q with -retentionPeriod=%s", *storageDataPath, retentionPeriod)
 	startTime := time.Now()
@@ -73,6 +92,7 @@ func main() {
 
 	registerStorageMetrics(strg)
 
+	clustertls.Init()
 	transport.StartUnmarshalWorkers()
 	srv, err := transport.NewServer(*vminsertAddr, *vmselectAddr, strg)
 	if err != nil {
@@ -146,6 +166,30 @@ func requestHandler(w http.ResponseWriter, r *http.Request, strg *storage.Storag
 		}()
 		return true
 	}
+	if path == "/internal/stream_churn" {
+		authKey := r.FormValue("authKey")
+		if authKey != *streamChurnAuthKey {
+			httpserver.Errorf(w, r, "invalid authKey %q. It must match the value from -streamChurnAuthKey command line flag", authKey)
+			return true
+		}
+		topN := 10
+		if s := r.FormValue("top"); len(s) > 0 {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				httpserver.Errorf(w, r, "cannot parse `top` arg %q: %s", s, err)
+				return true
+			}
+			topN = n
+		}
+		w.Header().Set("Content-Type", "application/json")
+		data, err := json.Marshal(strg.GetStreamChurn(topN))
+		if err != nil {
+			jsonResponseError(w, fmt.Errorf("cannot marshal stream churn: %w", err))
+			return true
+		}
+		fmt.Fprintf(w, `{"status":"ok","tenants":%s}`, data)
+		return true
+	}
 	if !strings.HasPrefix(path, "/snapshot") {
 		return false
 	}
@@ -436,6 +480,30 @@ func registerStorageMetrics(strg *storage.Storage) {
 		return float64(m().TimestampsBytesSaved)
 	})
 
+	metrics.NewGauge(`vm_values_dicts_trained_total`, func() float64 {
+		return float64(m().ValuesDictsTrained)
+	})
+	metrics.NewGauge(`vm_values_dict_train_failures_total`, func() float64 {
+		return float64(m().ValuesDictTrainFailures)
+	})
+
+	metrics.NewGauge(`vm_stream_limit_rejected_rows_total{limit="new_per_hour"}`, func() float64 {
+		return float64(m().NewStreamsPerHourLimitRejectedRows)
+	})
+	metrics.NewGauge(`vm_stream_limit_rejected_rows_total{limit="new_per_day"}`, func() float64 {
+		return float64(m().NewStreamsPerDayLimitRejectedRows)
+	})
+	metrics.NewGauge(`vm_stream_limit_rejected_rows_total{limit="active"}`, func() float64 {
+		return float64(m().ActiveStreamsLimitRejectedRows)
+	})
+
+	metrics.NewGauge(`vm_tail_subscriptions`, func() float64 {
+		return float64(m().TailSubscriptions)
+	})
+	metrics.NewGauge(`vm_tail_dropped_rows_total`, func() float64 {
+		return float64(m().TailDroppedRows)
+	})
+
 	metrics.NewGauge(`vm_rows{type="storage/big"}`, func() float64 {
 		return float64(tm().BigRowsCount)
 	})
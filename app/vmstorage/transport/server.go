@@ -10,8 +10,11 @@ import (
 	"runtime"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/clustertls"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/querytracer"
 	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/consts"
@@ -29,6 +32,11 @@ var (
 	maxTagValuesPerSearch        = flag.Int("search.maxTagValues", 100e3, "The maximum number of tag values returned per search")
 	maxTagValueSuffixesPerSearch = flag.Int("search.maxTagValueSuffixesPerSearch", 100e3, "The maximum number of tag value suffixes returned from /metrics/find")
 	maxMetricsPerSearch          = flag.Int("search.maxUniqueTimeseries", 300e3, "The maximum number of unique time series each search can scan")
+	maxTailBufferedRows          = flag.Int("search.maxTailBufferedRows", 10000, "The maximum number of rows buffered per each live tail request until they are sent to vmselect. "+
+		"The remaining rows are dropped and are reported to the client as dropped entries")
+	maxSampledBlocksPerStream = flag.Int("search.maxSampledBlocksPerStream", 16, "The number of blocks per stream, which are read when sampling log lines for /loki/api/v1/patterns and /loki/api/v1/detected_fields. "+
+		"Only a part of the remaining blocks is read, so the number of read blocks grows logarithmically with the number of stream blocks on the queried time range. "+
+		"Zero means that all the blocks are read")
 
 	precisionBits         = flag.Int("precisionBits", 64, "The number of precision bits to store per each value. Lower precision bits improves data compression at the cost of precision loss")
 	disableRPCCompression = flag.Bool(`rpc.disableCompression`, false, "Disable compression of RPC traffic. This reduces CPU usage at the cost of higher network bandwidth usage")
@@ -94,6 +102,9 @@ func (cm *connsMap) CloseAll() {
 
 // NewServer returns new Server.
 func NewServer(vminsertAddr, vmselectAddr string, storage *storage.Storage) (*Server, error) {
+	if err := clustertls.CheckServerConfig(); err != nil {
+		return nil, err
+	}
 	vminsertLN, err := netutil.NewTCPListener("vminsert", vminsertAddr)
 	if err != nil {
 		return nil, fmt.Errorf("unable to listen vminsertAddr %s: %w", vminsertAddr, err)
@@ -149,7 +160,17 @@ func (s *Server) RunVMInsert() {
 			// There is no need in response compression, since
 			// vmstorage sends only small packets to vminsert.
 			compressionLevel := 0
-			bc, err := handshake.VMInsertServer(c, compressionLevel)
+			tc, err := clustertls.Server(c)
+			if err != nil {
+				if s.isStopping() {
+					// c is stopped inside Server.MustClose
+					return
+				}
+				logger.Errorf("cannot perform TLS handshake with vminsert client %q: %s", c.RemoteAddr(), err)
+				_ = c.Close()
+				return
+			}
+			bc, err := handshake.VMInsertServer(tc, compressionLevel)
 			if err != nil {
 				if s.isStopping() {
 					// c is stopped inside Server.MustClose
@@ -222,7 +243,17 @@ func (s *Server) RunVMSelect() {
 			if *disableRPCCompression {
 				compressionLevel = 0
 			}
-			bc, err := handshake.VMSelectServer(c, compressionLevel)
+			tc, err := clustertls.Server(c)
+			if err != nil {
+				if s.isStopping() {
+					// c is closed inside Server.MustClose
+					return
+				}
+				logger.Errorf("cannot perform TLS handshake with vmselect client %q: %s", c.RemoteAddr(), err)
+				_ = c.Close()
+				return
+			}
+			bc, err := handshake.VMSelectServer(tc, compressionLevel)
 			if err != nil {
 				if s.isStopping() {
 					// c is closed inside Server.MustClose
@@ -245,6 +276,11 @@ func (s *Server) RunVMSelect() {
 				if s.isStopping() {
 					return
 				}
+				if isClosedByClient(err) {
+					// vmselect closes the connection when the query is canceled.
+					vmselectConnsClosedByClient.Inc()
+					return
+				}
 				vmselectConnErrors.Inc()
 				logger.Errorf("cannot process vmselect conn %s: %s", c.RemoteAddr(), err)
 			}
@@ -253,10 +289,16 @@ func (s *Server) RunVMSelect() {
 }
 
 var (
-	vmselectConns      = metrics.NewCounter("vm_vmselect_conns")
-	vmselectConnErrors = metrics.NewCounter("vm_vmselect_conn_errors_total")
+	vmselectConns               = metrics.NewCounter("vm_vmselect_conns")
+	vmselectConnErrors          = metrics.NewCounter("vm_vmselect_conn_errors_total")
+	vmselectConnsClosedByClient = metrics.NewCounter("vm_vmselect_conns_closed_by_client_total")
 )
 
+// isClosedByClient returns true if err is caused by the connection closed at vmselect side.
+func isClosedByClient(err error) bool {
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET)
+}
+
 // MustClose gracefully closes the server,
 // so it no longer touches s.storage after returning.
 func (s *Server) MustClose() {
@@ -479,6 +521,8 @@ type vmselectRequestCtx struct {
 	tfss []*storage.TagFilters
 	sr   storage.Search
 	mb   storage.MetricBlock
+	ss   storage.StreamStats
+	sb   storage.SampledBlock
 
 	// timeout in seconds for the current request
 	timeout uint64
@@ -523,6 +567,21 @@ func (ctx *vmselectRequestCtx) readAccountIDProjectID() (uint32, uint32, error)
 	return accountID, projectID, nil
 }
 
+func (ctx *vmselectRequestCtx) readTimeRange() (storage.TimeRange, error) {
+	var tr storage.TimeRange
+	minTimestamp, err := ctx.readUint64()
+	if err != nil {
+		return tr, fmt.Errorf("cannot read minTimestamp: %w", err)
+	}
+	maxTimestamp, err := ctx.readUint64()
+	if err != nil {
+		return tr, fmt.Errorf("cannot read maxTimestamp: %w", err)
+	}
+	tr.MinTimestamp = int64(minTimestamp)
+	tr.MaxTimestamp = int64(maxTimestamp)
+	return tr, nil
+}
+
 func (ctx *vmselectRequestCtx) readDataBufBytes(maxDataSize int) error {
 	ctx.sizeBuf = bytesutil.Resize(ctx.sizeBuf, 8)
 	if _, err := io.ReadFull(ctx.bc, ctx.sizeBuf); err != nil {
@@ -635,21 +694,31 @@ func (s *Server) processVMSelectRequest(ctx *vmselectRequestCtx) error {
 	ctx.deadline = fasttime.UnixTimestamp() + uint64(timeout)
 
 	switch rpcName {
-	case "search_v5":
+	case "search_v8":
 		return s.processVMSelectSearchQuery(ctx)
-	case "labelValues_v2":
+	case "valuesDict_v1":
+		return s.processVMSelectValuesDict(ctx)
+	case "streamStats_v2":
+		return s.processVMSelectStreamStats(ctx)
+	case "sampleRows_v2":
+		return s.processVMSelectSampleRows(ctx)
+	case "tail_v2":
+		return s.processVMSelectTail(ctx)
+	case "labelValues_v3":
 		return s.processVMSelectLabelValues(ctx)
 	case "tagValueSuffixes_v1":
 		return s.processVMSelectTagValueSuffixes(ctx)
 	case "labelEntries_v2":
 		return s.processVMSelectLabelEntries(ctx)
-	case "labels_v2":
+	case "labels_v3":
 		return s.processVMSelectLabels(ctx)
+	case "tenants_v1":
+		return s.processVMSelectTenants(ctx)
 	case "seriesCount_v2":
 		return s.processVMSelectSeriesCount(ctx)
 	case "tsdbStatus_v2":
 		return s.processVMSelectTSDBStatus(ctx)
-	case "deleteMetrics_v3":
+	case "deleteMetrics_v4":
 		return s.processVMSelectDeleteMetrics(ctx)
 	default:
 		return fmt.Errorf("unsupported rpcName: %q", ctx.dataBuf)
@@ -703,9 +772,13 @@ func (s *Server) processVMSelectLabels(ctx *vmselectRequestCtx) error {
 	if err != nil {
 		return err
 	}
+	tr, err := ctx.readTimeRange()
+	if err != nil {
+		return err
+	}
 
 	// Search for tag keys
-	labels, err := s.storage.SearchTagKeys(accountID, projectID, *maxTagKeysPerSearch, ctx.deadline)
+	labels, err := s.storage.SearchTagKeys(accountID, projectID, tr, *maxTagKeysPerSearch, ctx.deadline)
 	if err != nil {
 		return ctx.writeErrorMessage(err)
 	}
@@ -733,6 +806,34 @@ func (s *Server) processVMSelectLabels(ctx *vmselectRequestCtx) error {
 	return nil
 }
 
+func (s *Server) processVMSelectTenants(ctx *vmselectRequestCtx) error {
+	vmselectTenantsRequests.Inc()
+
+	// Search for tenants
+	tenants, err := s.storage.SearchTenants(ctx.deadline)
+	if err != nil {
+		return ctx.writeErrorMessage(err)
+	}
+
+	// Send an empty error message to vmselect.
+	if err := ctx.writeString(""); err != nil {
+		return fmt.Errorf("cannot send empty error message: %w", err)
+	}
+
+	// Send tenants to vmselect
+	for _, tenant := range tenants {
+		if err := ctx.writeString(tenant); err != nil {
+			return fmt.Errorf("cannot write tenant %q: %w", tenant, err)
+		}
+	}
+
+	// Send 'end of response' marker
+	if err := ctx.writeString(""); err != nil {
+		return fmt.Errorf("cannot send 'end of response' marker")
+	}
+	return nil
+}
+
 const maxLabelValueSize = 16 * 1024
 
 func (s *Server) processVMSelectLabelValues(ctx *vmselectRequestCtx) error {
@@ -743,13 +844,17 @@ func (s *Server) processVMSelectLabelValues(ctx *vmselectRequestCtx) error {
 	if err != nil {
 		return err
 	}
+	tr, err := ctx.readTimeRange()
+	if err != nil {
+		return err
+	}
 	if err := ctx.readDataBufBytes(maxLabelValueSize); err != nil {
 		return fmt.Errorf("cannot read labelName: %w", err)
 	}
 	labelName := ctx.dataBuf
 
 	// Search for tag values
-	labelValues, err := s.storage.SearchTagValues(accountID, projectID, labelName, *maxTagValuesPerSearch, ctx.deadline)
+	labelValues, err := s.storage.SearchTagValues(accountID, projectID, tr, labelName, *maxTagValuesPerSearch, ctx.deadline)
 	if err != nil {
 		return ctx.writeErrorMessage(err)
 	}
@@ -770,13 +875,9 @@ func (s *Server) processVMSelectTagValueSuffixes(ctx *vmselectRequestCtx) error
 	if err != nil {
 		return err
 	}
-	minTimestamp, err := ctx.readUint64()
-	if err != nil {
-		return fmt.Errorf("cannot read minTimestamp: %w", err)
-	}
-	maxTimestamp, err := ctx.readUint64()
+	tr, err := ctx.readTimeRange()
 	if err != nil {
-		return fmt.Errorf("cannot read maxTimestamp: %w", err)
+		return err
 	}
 	if err := ctx.readDataBufBytes(maxLabelValueSize); err != nil {
 		return fmt.Errorf("cannot read tagKey: %w", err)
@@ -792,10 +893,6 @@ func (s *Server) processVMSelectTagValueSuffixes(ctx *vmselectRequestCtx) error
 	}
 
 	// Search for tag value suffixes
-	tr := storage.TimeRange{
-		MinTimestamp: int64(minTimestamp),
-		MaxTimestamp: int64(maxTimestamp),
-	}
 	suffixes, err := s.storage.SearchTagValueSuffixes(accountID, projectID, tr, tagKey, tagValuePrefix, delimiter, *maxTagValueSuffixesPerSearch, ctx.deadline)
 	if err != nil {
 		return ctx.writeErrorMessage(err)
@@ -906,6 +1003,34 @@ func (s *Server) processVMSelectSeriesCount(ctx *vmselectRequestCtx) error {
 	return nil
 }
 
+func (s *Server) processVMSelectValuesDict(ctx *vmselectRequestCtx) error {
+	vmselectValuesDictRequests.Inc()
+
+	// Read request
+	id, err := ctx.readUint64()
+	if err != nil {
+		return fmt.Errorf("cannot read dictionary id: %w", err)
+	}
+
+	// Execute the request
+	d := s.storage.GetValuesDict(id)
+	if d == nil {
+		return ctx.writeErrorMessage(fmt.Errorf("cannot find values dictionary with id=%016X", id))
+	}
+
+	// Send an empty error message to vmselect.
+	if err := ctx.writeString(""); err != nil {
+		return fmt.Errorf("cannot send empty error message: %w", err)
+	}
+
+	// Send dictionary data to vmselect.
+	ctx.dataBuf = append(ctx.dataBuf[:0], d.Data()...)
+	if err := ctx.writeDataBufBytes(); err != nil {
+		return fmt.Errorf("cannot send values dictionary to vmselect: %w", err)
+	}
+	return nil
+}
+
 func (s *Server) processVMSelectTSDBStatus(ctx *vmselectRequestCtx) error {
 	vmselectTSDBStatusRequests.Inc()
 
@@ -968,6 +1093,12 @@ const maxSearchQuerySize = 1024 * 1024
 func (s *Server) processVMSelectSearchQuery(ctx *vmselectRequestCtx) error {
 	vmselectSearchQueryRequests.Inc()
 
+	// Read trace flag.
+	traceEnabled, err := ctx.readByte()
+	if err != nil {
+		return fmt.Errorf("cannot read trace flag: %w", err)
+	}
+
 	// Read search query.
 	if err := ctx.readDataBufBytes(maxSearchQuerySize); err != nil {
 		return fmt.Errorf("cannot read searchQuery: %w", err)
@@ -991,7 +1122,14 @@ func (s *Server) processVMSelectSearchQuery(ctx *vmselectRequestCtx) error {
 	if err := checkTimeRange(s.storage, tr); err != nil {
 		return ctx.writeErrorMessage(err)
 	}
-	ctx.sr.Init(s.storage, ctx.tfss, tr, int(ctx.sq.Limit), *maxMetricsPerSearch, ctx.deadline)
+	qt := querytracer.New(traceEnabled != 0, "vmstorage: search for tagFilters=%s on the time range %s", ctx.tfss, tr.String())
+	qtChild := qt.NewChild("search for TSIDs in indexDB")
+	tsidsFound := ctx.sr.InitShard(s.storage, ctx.tfss, tr, int(ctx.sq.Limit), *maxMetricsPerSearch, ctx.sq.ShardIdx, ctx.sq.ShardsCount, ctx.deadline)
+	if ctx.sq.ShardsCount > 1 {
+		qtChild.Donef("found %d TSIDs in the shard %d of %d", tsidsFound, ctx.sq.ShardIdx, ctx.sq.ShardsCount)
+	} else {
+		qtChild.Donef("found %d TSIDs", tsidsFound)
+	}
 	defer ctx.sr.MustClose()
 	if err := ctx.sr.Error(); err != nil {
 		return ctx.writeErrorMessage(err)
@@ -1003,14 +1141,21 @@ func (s *Server) processVMSelectSearchQuery(ctx *vmselectRequestCtx) error {
 	}
 
 	// Send found blocks to vmselect.
+	qtChild = qt.NewChild("read and send blocks to vmselect")
+	blocksRead := 0
+	rowsRead := 0
+	bytesSent := 0
 	for ctx.sr.NextMetricBlock() {
 		ctx.mb.MetricName = ctx.sr.MetricBlockRef.MetricName
 		ctx.sr.MetricBlockRef.BlockRef.MustReadBlock(&ctx.mb.Block, ctx.sq.FetchData)
 
 		vmselectMetricBlocksRead.Inc()
 		vmselectMetricRowsRead.Add(ctx.mb.Block.RowsCount())
+		blocksRead++
+		rowsRead += ctx.mb.Block.RowsCount()
 
 		ctx.dataBuf = ctx.mb.Marshal(ctx.dataBuf[:0])
+		bytesSent += len(ctx.dataBuf)
 		if err := ctx.writeDataBufBytes(); err != nil {
 			return fmt.Errorf("cannot send MetricBlock: %w", err)
 		}
@@ -1018,6 +1163,81 @@ func (s *Server) processVMSelectSearchQuery(ctx *vmselectRequestCtx) error {
 	if err := ctx.sr.Error(); err != nil {
 		return fmt.Errorf("search error: %w", err)
 	}
+	qtChild.Donef("blocks=%d, rows=%d, bytes=%d", blocksRead, rowsRead, bytesSent)
+
+	// Send 'end of response' marker
+	if err := ctx.writeString(""); err != nil {
+		return fmt.Errorf("cannot send 'end of response' marker")
+	}
+
+	// Send the trace. It is empty if tracing is disabled.
+	qt.Done()
+	if err := ctx.writeString(qt.ToJSON()); err != nil {
+		return fmt.Errorf("cannot send query trace: %w", err)
+	}
+	return nil
+}
+
+func (s *Server) processVMSelectStreamStats(ctx *vmselectRequestCtx) error {
+	vmselectStreamStatsRequests.Inc()
+
+	// Read search query.
+	if err := ctx.readDataBufBytes(maxSearchQuerySize); err != nil {
+		return fmt.Errorf("cannot read searchQuery: %w", err)
+	}
+	tail, err := ctx.sq.Unmarshal(ctx.dataBuf)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal SearchQuery: %w", err)
+	}
+	if len(tail) > 0 {
+		return fmt.Errorf("unexpected non-zero tail left after unmarshaling SearchQuery: (len=%d) %q", len(tail), tail)
+	}
+
+	// Setup search.
+	if err := ctx.setupTfss(); err != nil {
+		return ctx.writeErrorMessage(err)
+	}
+	tr := storage.TimeRange{
+		MinTimestamp: ctx.sq.MinTimestamp,
+		MaxTimestamp: ctx.sq.MaxTimestamp,
+	}
+	if err := checkTimeRange(s.storage, tr); err != nil {
+		return ctx.writeErrorMessage(err)
+	}
+	ctx.sr.Init(s.storage, ctx.tfss, tr, *maxMetricsPerSearch, *maxMetricsPerSearch, ctx.deadline)
+	defer ctx.sr.MustClose()
+	if err := ctx.sr.Error(); err != nil {
+		return ctx.writeErrorMessage(err)
+	}
+
+	// Send empty error message to vmselect.
+	if err := ctx.writeString(""); err != nil {
+		return fmt.Errorf("cannot send empty error message: %w", err)
+	}
+
+	// Send stats per stream to vmselect.
+	// Block data isn't read, since the stats are calculated from block headers.
+	// Blocks for the same stream are returned one after another, so stats for the stream
+	// are sent when the next stream is found.
+	ss := &ctx.ss
+	ss.Reset()
+	for ctx.sr.NextMetricBlock() {
+		mbr := &ctx.sr.MetricBlockRef
+		if string(mbr.MetricName) != string(ss.MetricName) {
+			if err := ctx.writeStreamStats(); err != nil {
+				return err
+			}
+			ss.Reset()
+			ss.MetricName = append(ss.MetricName, mbr.MetricName...)
+		}
+		ss.AddBlockRef(mbr.BlockRef)
+	}
+	if err := ctx.sr.Error(); err != nil {
+		return fmt.Errorf("search error: %w", err)
+	}
+	if err := ctx.writeStreamStats(); err != nil {
+		return err
+	}
 
 	// Send 'end of response' marker
 	if err := ctx.writeString(""); err != nil {
@@ -1026,6 +1246,219 @@ func (s *Server) processVMSelectSearchQuery(ctx *vmselectRequestCtx) error {
 	return nil
 }
 
+func (s *Server) processVMSelectSampleRows(ctx *vmselectRequestCtx) error {
+	vmselectSampleRowsRequests.Inc()
+
+	// Read request args.
+	if err := ctx.readDataBufBytes(maxSearchQuerySize); err != nil {
+		return fmt.Errorf("cannot read searchQuery: %w", err)
+	}
+	tail, err := ctx.sq.Unmarshal(ctx.dataBuf)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal SearchQuery: %w", err)
+	}
+	if len(tail) > 0 {
+		return fmt.Errorf("unexpected non-zero tail left after unmarshaling SearchQuery: (len=%d) %q", len(tail), tail)
+	}
+	maxRowsPerBlock, err := ctx.readUint32()
+	if err != nil {
+		return fmt.Errorf("cannot read maxRowsPerBlock: %w", err)
+	}
+
+	// Setup search.
+	if err := ctx.setupTfss(); err != nil {
+		return ctx.writeErrorMessage(err)
+	}
+	tr := storage.TimeRange{
+		MinTimestamp: ctx.sq.MinTimestamp,
+		MaxTimestamp: ctx.sq.MaxTimestamp,
+	}
+	if err := checkTimeRange(s.storage, tr); err != nil {
+		return ctx.writeErrorMessage(err)
+	}
+	ctx.sr.Init(s.storage, ctx.tfss, tr, int(ctx.sq.Limit), *maxMetricsPerSearch, ctx.deadline)
+	defer ctx.sr.MustClose()
+	if err := ctx.sr.Error(); err != nil {
+		return ctx.writeErrorMessage(err)
+	}
+
+	// Send empty error message to vmselect.
+	if err := ctx.writeString(""); err != nil {
+		return fmt.Errorf("cannot send empty error message: %w", err)
+	}
+
+	// Send rows sampled from the found blocks to vmselect.
+	// Sampling is performed at vmstorage in order to reduce the amount of data sent over the network for big streams.
+	// Blocks of big streams are sampled too in order to reduce disk reads and decompression. Rows from skipped blocks
+	// are accounted in weights of rows sampled from the previous block of the stream, so the pending block is sent
+	// only after the next block is read.
+	var sbNext storage.SampledBlock
+	var prevMetricName []byte
+	streamBlocks := 0
+	ctx.sb.Reset()
+	for ctx.sr.NextMetricBlock() {
+		mbr := &ctx.sr.MetricBlockRef
+		if string(mbr.MetricName) != string(prevMetricName) {
+			prevMetricName = append(prevMetricName[:0], mbr.MetricName...)
+			streamBlocks = 0
+		}
+		blockIdx := streamBlocks
+		streamBlocks++
+		if ctx.sb.RowsCount() > 0 && mbr.BlockRef.IsInTimeRange(tr) && !shouldSampleBlock(blockIdx, *maxSampledBlocksPerStream) {
+			ctx.sb.AddWeight(uint64(mbr.BlockRef.RowsCount()))
+			vmselectSampledBlocksSkipped.Inc()
+			continue
+		}
+
+		mbr.BlockRef.MustReadBlock(&ctx.mb.Block, storage.FetchAll)
+
+		vmselectMetricBlocksRead.Inc()
+		vmselectMetricRowsRead.Add(ctx.mb.Block.RowsCount())
+
+		if err := sbNext.Init(mbr.MetricName, &ctx.mb.Block, tr, int(maxRowsPerBlock)); err != nil {
+			return fmt.Errorf("cannot sample rows for %q: %w", mbr.MetricName, err)
+		}
+		if sbNext.RowsCount() == 0 {
+			continue
+		}
+		if err := ctx.writeSampledBlock(); err != nil {
+			return err
+		}
+		ctx.sb, sbNext = sbNext, ctx.sb
+	}
+	if err := ctx.sr.Error(); err != nil {
+		return fmt.Errorf("search error: %w", err)
+	}
+	if err := ctx.writeSampledBlock(); err != nil {
+		return err
+	}
+
+	// Send 'end of response' marker
+	if err := ctx.writeString(""); err != nil {
+		return fmt.Errorf("cannot send 'end of response' marker")
+	}
+	return nil
+}
+
+// shouldSampleBlock returns true if the block with the given blockIdx in the stream must be read for sampling.
+//
+// The first maxBlocks blocks are always read, while only every (blockIdx/maxBlocks+1)-th block is read after that.
+func shouldSampleBlock(blockIdx, maxBlocks int) bool {
+	if maxBlocks <= 0 || blockIdx < maxBlocks {
+		return true
+	}
+	return blockIdx%(blockIdx/maxBlocks+1) == 0
+}
+
+func (ctx *vmselectRequestCtx) writeSampledBlock() error {
+	if ctx.sb.RowsCount() == 0 {
+		return nil
+	}
+	ctx.dataBuf = ctx.sb.Marshal(ctx.dataBuf[:0])
+	ctx.sb.Reset()
+	if err := ctx.writeDataBufBytes(); err != nil {
+		return fmt.Errorf("cannot send SampledBlock: %w", err)
+	}
+	return nil
+}
+
+func (ctx *vmselectRequestCtx) writeStreamStats() error {
+	if ctx.ss.Blocks == 0 {
+		return nil
+	}
+	ctx.dataBuf = ctx.ss.Marshal(ctx.dataBuf[:0])
+	if err := ctx.writeDataBufBytes(); err != nil {
+		return fmt.Errorf("cannot send StreamStats: %w", err)
+	}
+	return nil
+}
+
+// tailHeartbeatInterval is the interval for sending empty batches to vmselect during live tail.
+//
+// This allows detecting closed connections when there are no new rows for the tail.
+const tailHeartbeatInterval = 5 * time.Second
+
+func (s *Server) processVMSelectTail(ctx *vmselectRequestCtx) error {
+	vmselectTailRequests.Inc()
+
+	// Read search query.
+	if err := ctx.readDataBufBytes(maxSearchQuerySize); err != nil {
+		return fmt.Errorf("cannot read searchQuery: %w", err)
+	}
+	tail, err := ctx.sq.Unmarshal(ctx.dataBuf)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal SearchQuery: %w", err)
+	}
+	if len(tail) > 0 {
+		return fmt.Errorf("unexpected non-zero tail left after unmarshaling SearchQuery: (len=%d) %q", len(tail), tail)
+	}
+
+	// Setup subscription.
+	if err := ctx.setupTfss(); err != nil {
+		return ctx.writeErrorMessage(err)
+	}
+	ts := s.storage.SubscribeTail(ctx.sq.AccountID, ctx.sq.ProjectID, ctx.tfss, *maxTailBufferedRows)
+	defer ts.Unsubscribe()
+
+	// Send empty error message to vmselect.
+	if err := ctx.writeString(""); err != nil {
+		return fmt.Errorf("cannot send empty error message: %w", err)
+	}
+	if err := ctx.bc.Flush(); err != nil {
+		return fmt.Errorf("cannot flush empty error message: %w", err)
+	}
+
+	// Send batches with the added rows to vmselect until the deadline.
+	// The connection is closed by vmselect if the client stops the tail.
+	d := time.Until(time.Unix(int64(ctx.deadline), 0))
+	if d < 0 {
+		d = 0
+	}
+	deadlineTimer := time.NewTimer(d)
+	defer deadlineTimer.Stop()
+	heartbeatTicker := time.NewTicker(tailHeartbeatInterval)
+	defer heartbeatTicker.Stop()
+	for {
+		select {
+		case <-ts.NotifyCh():
+		case <-heartbeatTicker.C:
+		case <-deadlineTimer.C:
+			// Send 'end of response' marker
+			if err := ctx.writeString(""); err != nil {
+				return fmt.Errorf("cannot send 'end of response' marker")
+			}
+			return nil
+		}
+		if s.isStopping() {
+			return fmt.Errorf("cannot send tail rows, since vmstorage is stopping")
+		}
+		rows, dropped := ts.Read()
+		vmselectTailRowsSent.Add(len(rows))
+		ctx.dataBuf = marshalTailBatch(ctx.dataBuf[:0], rows, dropped)
+		if err := ctx.writeDataBufBytes(); err != nil {
+			return fmt.Errorf("cannot send tail rows: %w", err)
+		}
+		if err := ctx.bc.Flush(); err != nil {
+			return fmt.Errorf("cannot flush tail rows: %w", err)
+		}
+	}
+}
+
+// marshalTailBatch appends marshaled rows and dropped rows to dst and returns the result.
+//
+// The batch is never empty, so it cannot be confused with 'end of response' marker.
+func marshalTailBatch(dst []byte, rows, dropped []storage.TailRow) []byte {
+	dst = encoding.MarshalVarUint64(dst, uint64(len(rows)))
+	for i := range rows {
+		dst = rows[i].Marshal(dst)
+	}
+	dst = encoding.MarshalVarUint64(dst, uint64(len(dropped)))
+	for i := range dropped {
+		dst = dropped[i].Marshal(dst)
+	}
+	return dst
+}
+
 // checkTimeRange returns true if the given tr is denied for querying.
 func checkTimeRange(s *storage.Storage, tr storage.TimeRange) error {
 	if !*denyQueriesOutsideRetention {
@@ -1045,13 +1478,20 @@ func checkTimeRange(s *storage.Storage, tr storage.TimeRange) error {
 var (
 	vmselectDeleteMetricsRequests    = metrics.NewCounter("vm_vmselect_delete_metrics_requests_total")
 	vmselectLabelsRequests           = metrics.NewCounter("vm_vmselect_labels_requests_total")
+	vmselectTenantsRequests          = metrics.NewCounter("vm_vmselect_tenants_requests_total")
+	vmselectStreamStatsRequests      = metrics.NewCounter("vm_vmselect_stream_stats_requests_total")
+	vmselectSampleRowsRequests       = metrics.NewCounter("vm_vmselect_sample_rows_requests_total")
+	vmselectTailRequests             = metrics.NewCounter("vm_vmselect_tail_requests_total")
+	vmselectTailRowsSent             = metrics.NewCounter("vm_vmselect_tail_rows_sent_total")
 	vmselectLabelValuesRequests      = metrics.NewCounter("vm_vmselect_label_values_requests_total")
 	vmselectTagValueSuffixesRequests = metrics.NewCounter("vm_vmselect_tag_value_suffixes_requests_total")
 	vmselectLabelEntriesRequests     = metrics.NewCounter("vm_vmselect_label_entries_requests_total")
 	vmselectSeriesCountRequests      = metrics.NewCounter("vm_vmselect_series_count_requests_total")
 	vmselectTSDBStatusRequests       = metrics.NewCounter("vm_vmselect_tsdb_status_requests_total")
+	vmselectValuesDictRequests       = metrics.NewCounter("vm_vmselect_values_dict_requests_total")
 	vmselectSearchQueryRequests      = metrics.NewCounter("vm_vmselect_search_query_requests_total")
 	vmselectMetricBlocksRead         = metrics.NewCounter("vm_vmselect_metric_blocks_read_total")
+	vmselectSampledBlocksSkipped     = metrics.NewCounter("vm_vmselect_sampled_blocks_skipped_total")
 	vmselectMetricRowsRead           = metrics.NewCounter("vm_vmselect_metric_rows_read_total")
 )
 
@@ -0,0 +1,280 @@
+package clustertls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/handshake"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/procutil"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+var (
+	enableTLS = flag.Bool("cluster.tls", false, "Whether to use TLS for RPC connections between vminsert, vmselect and vmstorage. "+
+		"It must be set at all the cluster components. See also -cluster.tlsCertFile, -cluster.tlsKeyFile and -cluster.tlsCAFile")
+	certFile = flag.String("cluster.tlsCertFile", "", "Path to TLS certificate file for RPC connections if -cluster.tls is set. "+
+		"It is required at vmstorage and is optional at vminsert and vmselect, where it is used as a client certificate. The file is re-read on SIGHUP")
+	keyFile = flag.String("cluster.tlsKeyFile", "", "Path to TLS key file for -cluster.tlsCertFile. The file is re-read on SIGHUP")
+	caFile  = flag.String("cluster.tlsCAFile", "", "Optional path to CA file for verifying certificates of the other side of RPC connections if -cluster.tls is set. "+
+		"vmstorage requires and verifies client certificates from vminsert and vmselect if it is set. "+
+		"vminsert and vmselect verify vmstorage certificates with the system CA if it isn't set. The file is re-read on SIGHUP")
+	serverName = flag.String("cluster.tlsServerName", "", "Optional server name for verifying vmstorage certificates at vminsert and vmselect. "+
+		"The host from -storageNode is used by default")
+	insecureSkipVerify = flag.Bool("cluster.tlsInsecureSkipVerify", false, "Whether to skip verification of vmstorage certificates at vminsert and vmselect")
+)
+
+// handshakeTimeout is the maximum duration for TLS handshake.
+const handshakeTimeout = 10 * time.Second
+
+// tlsRecordTypeHandshake is the first byte of TLS ClientHello message.
+const tlsRecordTypeHandshake = 0x16
+
+// Init must be called after flag.Parse and before using the clustertls package.
+func Init() {
+	cfg, err := loadConfig()
+	if err != nil {
+		logger.Fatalf("cannot load TLS config for RPC connections: %s", err)
+	}
+	cfgGlobal.Store(cfg)
+	if !*enableTLS || (len(*certFile) == 0 && len(*caFile) == 0) {
+		return
+	}
+	sighupCh := procutil.NewSighupChan()
+	go func() {
+		for range sighupCh {
+			logger.Infof("received SIGHUP; reloading -cluster.tlsCertFile=%q, -cluster.tlsKeyFile=%q and -cluster.tlsCAFile=%q...", *certFile, *keyFile, *caFile)
+			cfg, err := loadConfig()
+			if err != nil {
+				reloadErrors.Inc()
+				logger.Errorf("cannot reload TLS config for RPC connections: %s; preserving the previous config", err)
+				continue
+			}
+			cfgGlobal.Store(cfg)
+			reloads.Inc()
+			logger.Infof("successfully reloaded TLS config for RPC connections")
+		}
+	}()
+}
+
+// CheckServerConfig verifies whether the config is valid for accepting RPC connections.
+func CheckServerConfig() error {
+	if !*enableTLS {
+		return nil
+	}
+	if cfg := getConfig(); cfg == nil || cfg.cert == nil {
+		return fmt.Errorf("-cluster.tlsCertFile and -cluster.tlsKeyFile must be set when -cluster.tls is set")
+	}
+	return nil
+}
+
+type config struct {
+	cert   *tls.Certificate
+	caPool *x509.CertPool
+}
+
+var cfgGlobal atomic.Value
+
+func getConfig() *config {
+	cfg, _ := cfgGlobal.Load().(*config)
+	return cfg
+}
+
+func loadConfig() (*config, error) {
+	if !*enableTLS {
+		return &config{}, nil
+	}
+	var cfg config
+	if len(*certFile) > 0 || len(*keyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load -cluster.tlsCertFile=%q and -cluster.tlsKeyFile=%q: %w", *certFile, *keyFile, err)
+		}
+		cfg.cert = &cert
+	}
+	if len(*caFile) > 0 {
+		data, err := ioutil.ReadFile(*caFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read -cluster.tlsCAFile=%q: %w", *caFile, err)
+		}
+		cfg.caPool = x509.NewCertPool()
+		if !cfg.caPool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("cannot find PEM-encoded certificates in -cluster.tlsCAFile=%q", *caFile)
+		}
+	}
+	return &cfg, nil
+}
+
+// Server performs server-side TLS handshake on c if -cluster.tls is set.
+//
+// The returned conn must be used instead of c for the VictoriaMetrics handshake.
+// An error is returned if the client uses TLS while -cluster.tls isn't set and vice versa.
+func Server(c net.Conn) (net.Conn, error) {
+	return serverHandshake(c, *enableTLS, getConfig())
+}
+
+func serverHandshake(c net.Conn, enabled bool, cfg *config) (net.Conn, error) {
+	if err := c.SetDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		return nil, fmt.Errorf("cannot set deadline for handshake: %w", err)
+	}
+	pc, isTLS, err := peekIsTLS(c)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		if isTLS {
+			handshakeErrors.Inc()
+			return nil, fmt.Errorf("the client uses TLS, while -cluster.tls isn't set; set -cluster.tls at vmstorage or remove it at vminsert and vmselect")
+		}
+		if err := c.SetDeadline(time.Time{}); err != nil {
+			return nil, fmt.Errorf("cannot reset deadline after handshake: %w", err)
+		}
+		return pc, nil
+	}
+	if !isTLS {
+		handshakeErrors.Inc()
+		return nil, fmt.Errorf("the client doesn't use TLS, while -cluster.tls is set; set -cluster.tls at vminsert and vmselect or remove it at vmstorage")
+	}
+	tc := tls.Server(pc, newServerTLSConfig(cfg))
+	if err := tc.Handshake(); err != nil {
+		handshakeErrors.Inc()
+		return nil, fmt.Errorf("cannot perform TLS handshake: %w", err)
+	}
+	if err := c.SetDeadline(time.Time{}); err != nil {
+		return nil, fmt.Errorf("cannot reset deadline after handshake: %w", err)
+	}
+	return tc, nil
+}
+
+func newServerTLSConfig(cfg *config) *tls.Config {
+	tlsCfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if cfg.cert != nil {
+		tlsCfg.Certificates = []tls.Certificate{*cfg.cert}
+	}
+	if cfg.caPool != nil {
+		tlsCfg.ClientCAs = cfg.caPool
+		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsCfg
+}
+
+// Client performs client-side TLS handshake on c connected to addr if -cluster.tls is set.
+//
+// The returned conn must be used instead of c for the VictoriaMetrics handshake.
+func Client(c net.Conn, addr string) (net.Conn, error) {
+	return clientHandshake(c, addr, *enableTLS, getConfig())
+}
+
+func clientHandshake(c net.Conn, addr string, enabled bool, cfg *config) (net.Conn, error) {
+	if !enabled {
+		return c, nil
+	}
+	if err := c.SetDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		return nil, fmt.Errorf("cannot set deadline for handshake: %w", err)
+	}
+	tc := tls.Client(c, newClientTLSConfig(cfg, addr))
+	if err := tc.Handshake(); err != nil {
+		handshakeErrors.Inc()
+		if isNotTLSServerError(err) {
+			return nil, fmt.Errorf("cannot perform TLS handshake: %w; make sure -cluster.tls is set at vmstorage", err)
+		}
+		return nil, fmt.Errorf("cannot perform TLS handshake: %w", err)
+	}
+	if err := c.SetDeadline(time.Time{}); err != nil {
+		return nil, fmt.Errorf("cannot reset deadline after handshake: %w", err)
+	}
+	return tc, nil
+}
+
+func newClientTLSConfig(cfg *config, addr string) *tls.Config {
+	tlsCfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		RootCAs:            cfg.caPool,
+		ServerName:         *serverName,
+		InsecureSkipVerify: *insecureSkipVerify,
+	}
+	if len(tlsCfg.ServerName) == 0 {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		tlsCfg.ServerName = host
+	}
+	if cfg.cert != nil {
+		tlsCfg.Certificates = []tls.Certificate{*cfg.cert}
+	}
+	return tlsCfg
+}
+
+// ClientHandshakeFunc returns handshake func, which performs TLS handshake with addr before hf.
+func ClientHandshakeFunc(addr string, hf handshake.Func) handshake.Func {
+	return func(c net.Conn, compressionLevel int) (*handshake.BufferedConn, error) {
+		return clientHandshakeWithFunc(c, compressionLevel, addr, hf, *enableTLS, getConfig())
+	}
+}
+
+func clientHandshakeWithFunc(c net.Conn, compressionLevel int, addr string, hf handshake.Func, enabled bool, cfg *config) (*handshake.BufferedConn, error) {
+	tc, err := clientHandshake(c, addr, enabled, cfg)
+	if err != nil {
+		return nil, err
+	}
+	bc, err := hf(tc, compressionLevel)
+	if err != nil && !enabled && isConnClosedError(err) {
+		// vmstorage closes connections without TLS if -cluster.tls is set there.
+		return nil, fmt.Errorf("%w; make sure -cluster.tls is set either at all the cluster components or at none of them", err)
+	}
+	return bc, err
+}
+
+func isNotTLSServerError(err error) bool {
+	var rhe tls.RecordHeaderError
+	return isConnClosedError(err) || errors.As(err, &rhe)
+}
+
+func isConnClosedError(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET)
+}
+
+func peekIsTLS(c net.Conn) (net.Conn, bool, error) {
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(c, b); err != nil {
+		return nil, false, fmt.Errorf("cannot read the first byte from the client: %w", err)
+	}
+	pc := &peekedConn{
+		Conn: c,
+		b:    b,
+	}
+	return pc, b[0] == tlsRecordTypeHandshake, nil
+}
+
+// peekedConn is a net.Conn with the first bytes already read from it.
+type peekedConn struct {
+	net.Conn
+	b []byte
+}
+
+func (pc *peekedConn) Read(p []byte) (int, error) {
+	if len(pc.b) > 0 {
+		n := copy(p, pc.b)
+		pc.b = pc.b[n:]
+		return n, nil
+	}
+	return pc.Conn.Read(p)
+}
+
+var (
+	handshakeErrors = metrics.NewCounter(`vm_cluster_tls_handshake_errors_total`)
+	reloads         = metrics.NewCounter(`vm_cluster_tls_config_reloads_total`)
+	reloadErrors    = metrics.NewCounter(`vm_cluster_tls_config_reload_errors_total`)
+)
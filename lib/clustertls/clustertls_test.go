@@ -0,0 +1,267 @@
+package clustertls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/handshake"
+)
+
+type testCA struct {
+	cert    *x509.Certificate
+	certPEM []byte
+	key     *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate CA key: %s", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("cannot create CA certificate: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("cannot parse CA certificate: %s", err)
+	}
+	return &testCA{
+		cert:    cert,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		key:     key,
+	}
+}
+
+func (ca *testCA) pool() *x509.CertPool {
+	p := x509.NewCertPool()
+	p.AddCert(ca.cert)
+	return p
+}
+
+func (ca *testCA) issue(t *testing.T, serial int64) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate key: %s", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "vmstorage"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("cannot create certificate: %s", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("cannot marshal key: %s", err)
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM
+}
+
+func (ca *testCA) newConfig(t *testing.T, serial int64) *config {
+	t.Helper()
+	certPEM, keyPEM := ca.issue(t, serial)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("cannot load key pair: %s", err)
+	}
+	return &config{
+		cert:   &cert,
+		caPool: ca.pool(),
+	}
+}
+
+// runHandshake performs TLS and vmselect handshakes between client and server with the given settings.
+func runHandshake(t *testing.T, serverEnabled bool, serverCfg *config, clientEnabled bool, clientCfg *config) (serverErr, clientErr error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	defer func() {
+		_ = ln.Close()
+	}()
+	serverErrCh := make(chan error, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			serverErrCh <- err
+			return
+		}
+		defer func() {
+			_ = c.Close()
+		}()
+		tc, err := serverHandshake(c, serverEnabled, serverCfg)
+		if err != nil {
+			serverErrCh <- err
+			return
+		}
+		_, err = handshake.VMSelectServer(tc, 0)
+		serverErrCh <- err
+	}()
+
+	addr := ln.Addr().String()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("cannot dial %s: %s", addr, err)
+	}
+	_, clientErr = clientHandshakeWithFunc(c, 0, addr, handshake.VMSelectClient, clientEnabled, clientCfg)
+	_ = c.Close()
+	return <-serverErrCh, clientErr
+}
+
+func TestHandshakeSuccess(t *testing.T) {
+	ca := newTestCA(t)
+
+	// Plain connection
+	serverErr, clientErr := runHandshake(t, false, &config{}, false, &config{})
+	if serverErr != nil || clientErr != nil {
+		t.Fatalf("unexpected errors for plain connection; server: %v; client: %v", serverErr, clientErr)
+	}
+
+	// Mutual TLS
+	serverErr, clientErr = runHandshake(t, true, ca.newConfig(t, 2), true, ca.newConfig(t, 3))
+	if serverErr != nil || clientErr != nil {
+		t.Fatalf("unexpected errors for mutual TLS; server: %v; client: %v", serverErr, clientErr)
+	}
+
+	// TLS without client certificate verification
+	serverCfg := ca.newConfig(t, 4)
+	serverCfg.caPool = nil
+	serverErr, clientErr = runHandshake(t, true, serverCfg, true, &config{caPool: ca.pool()})
+	if serverErr != nil || clientErr != nil {
+		t.Fatalf("unexpected errors for TLS; server: %v; client: %v", serverErr, clientErr)
+	}
+}
+
+func TestHandshakeError(t *testing.T) {
+	ca := newTestCA(t)
+	f := func(serverEnabled bool, serverCfg *config, clientEnabled bool, clientCfg *config, serverErrSubstr, clientErrSubstr string) {
+		t.Helper()
+		serverErr, clientErr := runHandshake(t, serverEnabled, serverCfg, clientEnabled, clientCfg)
+		if serverErr == nil {
+			t.Fatalf("expecting non-nil server error")
+		}
+		if clientErr == nil {
+			t.Fatalf("expecting non-nil client error")
+		}
+		if !strings.Contains(serverErr.Error(), serverErrSubstr) {
+			t.Fatalf("server error %q must contain %q", serverErr, serverErrSubstr)
+		}
+		if !strings.Contains(clientErr.Error(), clientErrSubstr) {
+			t.Fatalf("client error %q must contain %q", clientErr, clientErrSubstr)
+		}
+	}
+
+	// TLS client and plain server
+	f(false, &config{}, true, ca.newConfig(t, 2), "the client uses TLS, while -cluster.tls isn't set",
+		"make sure -cluster.tls is set at vmstorage")
+
+	// Plain client and TLS server
+	f(true, ca.newConfig(t, 3), false, &config{}, "the client doesn't use TLS, while -cluster.tls is set",
+		"make sure -cluster.tls is set either at all the cluster components or at none of them")
+
+	// Missing client certificate
+	f(true, ca.newConfig(t, 4), true, &config{caPool: ca.pool()}, "cannot perform TLS handshake", "certificate required")
+
+	// Client certificate from unknown CA
+	f(true, ca.newConfig(t, 5), true, newTestCA(t).newConfig(t, 6), "cannot perform TLS handshake", "")
+}
+
+func TestLoadConfigReload(t *testing.T) {
+	ca := newTestCA(t)
+	dir, err := ioutil.TempDir("", "clustertls")
+	if err != nil {
+		t.Fatalf("cannot create temporary dir: %s", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	writeFile := func(name string, data []byte) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			t.Fatalf("cannot write %q: %s", path, err)
+		}
+		return path
+	}
+	setFlags := func(enabled bool, cert, key, ca string) {
+		*enableTLS = enabled
+		*certFile = cert
+		*keyFile = key
+		*caFile = ca
+	}
+	defer setFlags(false, "", "", "")
+
+	certPEM, keyPEM := ca.issue(t, 2)
+	setFlags(true, writeFile("cert.pem", certPEM), writeFile("key.pem", keyPEM), writeFile("ca.pem", ca.certPEM))
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cfg.cert == nil || cfg.caPool == nil {
+		t.Fatalf("expecting non-nil cert and caPool")
+	}
+
+	// Rotate the certificate
+	certPEM, keyPEM = ca.issue(t, 3)
+	writeFile("cert.pem", certPEM)
+	writeFile("key.pem", keyPEM)
+	cfgNew, err := loadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(cfgNew.cert.Certificate[0]) == string(cfg.cert.Certificate[0]) {
+		t.Fatalf("the certificate must be reloaded")
+	}
+
+	// Invalid files
+	writeFile("bad.pem", []byte("foobar"))
+	setFlags(true, *certFile, *keyFile, filepath.Join(dir, "bad.pem"))
+	if _, err := loadConfig(); err == nil {
+		t.Fatalf("expecting non-nil error for invalid -cluster.tlsCAFile")
+	}
+	setFlags(true, filepath.Join(dir, "bad.pem"), *keyFile, "")
+	if _, err := loadConfig(); err == nil {
+		t.Fatalf("expecting non-nil error for invalid -cluster.tlsCertFile")
+	}
+
+	// Disabled TLS ignores the files
+	setFlags(false, filepath.Join(dir, "missing.pem"), "", "")
+	if _, err := loadConfig(); err != nil {
+		t.Fatalf("unexpected error when TLS is disabled: %s", err)
+	}
+}
@@ -0,0 +1,126 @@
+package encodingext
+
+import (
+	"fmt"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/decimalext"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/cespare/xxhash/v2"
+)
+
+// MinDictSamplesSize is the minimum size of samples required for TrainDict.
+const MinDictSamplesSize = 128 * 1024
+
+// Dict is a trained zstd dictionary used for compressing values
+// with MarshalTypeZSTDDictBytesArray.
+//
+// Dict may be used concurrently from multiple goroutines.
+type Dict struct {
+	data []byte
+	id   uint64
+
+	c *dictCodec
+}
+
+// NewDict returns new Dict for the given data.
+//
+// data must be obtained from TrainDict or Dict.Data.
+func NewDict(data []byte) (*Dict, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("dictionary data cannot be empty")
+	}
+	data = append([]byte(nil), data...)
+	c, err := newDictCodec(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot initialize zstd dictionary: %w", err)
+	}
+	return &Dict{
+		data: data,
+		id:   xxhash.Sum64(data),
+		c:    c,
+	}, nil
+}
+
+// Data returns the raw dictionary data, which may be passed to NewDict.
+func (d *Dict) Data() []byte {
+	return d.data
+}
+
+// ID returns the dictionary id, which is unique for the dictionary contents.
+func (d *Dict) ID() uint64 {
+	return d.id
+}
+
+// SameDict returns true if a and b contain identical dictionaries.
+//
+// nil a or b means no dictionary.
+func SameDict(a, b *Dict) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.id == b.id
+}
+
+// TrainDict trains zstd dictionary with the size close to dictSize from the given samples.
+//
+// nil is returned if the dictionary cannot be trained, for example, if samples are too small
+// or the dictionary training isn't supported by the build (i.e. pure Go build).
+func TrainDict(samples [][]byte, dictSize int) []byte {
+	samplesSize := 0
+	for _, sample := range samples {
+		samplesSize += len(sample)
+	}
+	if samplesSize < MinDictSamplesSize {
+		return nil
+	}
+	return trainDict(samples, dictSize)
+}
+
+// MarshalValuesWithDict marshals values with the given dictionary d, appends the marshaled result to dst
+// and returns the dst.
+//
+// MarshalValues is used if d is nil.
+func MarshalValuesWithDict(dst []byte, values [][]byte, d *Dict) (result []byte, mt MarshalType) {
+	if d == nil {
+		return MarshalValues(dst, values)
+	}
+	if len(values) == 0 {
+		logger.Panicf("BUG: values must contain at least one item")
+	}
+	bb := bbPool.Get()
+	for i := 0; i < len(values); i++ {
+		bb.B = encoding.MarshalBytes(bb.B, values[i])
+	}
+	dst = d.c.compress(dst, bb.B)
+	bbPool.Put(bb)
+	return dst, MarshalTypeZSTDDictBytesArray
+}
+
+// UnmarshalValuesWithDict unmarshals values from src, appends them to dst and returns
+// the resulting dst.
+//
+// d must contain the dictionary used for marshaling values with MarshalTypeZSTDDictBytesArray.
+// d is ignored for other marshal types, so blocks marshaled without dictionary are unmarshaled as usual.
+func UnmarshalValuesWithDict(dst [][]byte, src []byte, mt MarshalType, itemsCount int, d *Dict) ([][]byte, error) {
+	if mt != MarshalTypeZSTDDictBytesArray {
+		return UnmarshalValues(dst, src, mt, itemsCount)
+	}
+	if d == nil {
+		return nil, fmt.Errorf("cannot unmarshal %d values from len(src)=%d bytes: missing zstd dictionary", itemsCount, len(src))
+	}
+	bb := bbPool.Get()
+	defer bbPool.Put(bb)
+
+	var err error
+	bb.B, err = d.c.decompress(bb.B[:0], src)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decompress zstd data with dictionary %016X: %w", d.id, err)
+	}
+	dst = decimalext.ExtendBytesArrayCapacity(dst, itemsCount)
+	dst, err = unmarshalRawBytesArray(dst, bb.B, itemsCount)
+	if err != nil {
+		return nil, fmt.Errorf("cannot unmarshal %d values from len(src)=%d bytes: %w", itemsCount, len(src), err)
+	}
+	return dst, nil
+}
@@ -0,0 +1,45 @@
+// +build cgo
+
+package encodingext
+
+import (
+	"github.com/valyala/gozstd"
+)
+
+// dictCompressLevel is the compression level used for compressing values with dictionary.
+//
+// Dictionary compression works well for small blocks even at low compression levels.
+const dictCompressLevel = 3
+
+type dictCodec struct {
+	cd *gozstd.CDict
+	dd *gozstd.DDict
+}
+
+func newDictCodec(data []byte) (*dictCodec, error) {
+	cd, err := gozstd.NewCDictLevel(data, dictCompressLevel)
+	if err != nil {
+		return nil, err
+	}
+	dd, err := gozstd.NewDDict(data)
+	if err != nil {
+		cd.Release()
+		return nil, err
+	}
+	return &dictCodec{
+		cd: cd,
+		dd: dd,
+	}, nil
+}
+
+func (dc *dictCodec) compress(dst, src []byte) []byte {
+	return gozstd.CompressDict(dst, src, dc.cd)
+}
+
+func (dc *dictCodec) decompress(dst, src []byte) ([]byte, error) {
+	return gozstd.DecompressDict(dst, src, dc.dd)
+}
+
+func trainDict(samples [][]byte, dictSize int) []byte {
+	return gozstd.BuildDict(samples, dictSize)
+}
@@ -0,0 +1,51 @@
+// +build !cgo
+
+package encodingext
+
+import (
+	"github.com/klauspost/compress/zstd"
+)
+
+// dictCompressLevel is the compression level used for compressing values with dictionary.
+//
+// Dictionary compression works well for small blocks even at low compression levels.
+const dictCompressLevel = 3
+
+type dictCodec struct {
+	e *zstd.Encoder
+	d *zstd.Decoder
+}
+
+func newDictCodec(data []byte) (*dictCodec, error) {
+	e, err := zstd.NewWriter(nil,
+		zstd.WithEncoderCRC(false), // Disable CRC for performance reasons.
+		zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(dictCompressLevel)),
+		zstd.WithEncoderDict(data))
+	if err != nil {
+		return nil, err
+	}
+	d, err := zstd.NewReader(nil, zstd.WithDecoderDicts(data))
+	if err != nil {
+		return nil, err
+	}
+	return &dictCodec{
+		e: e,
+		d: d,
+	}, nil
+}
+
+func (dc *dictCodec) compress(dst, src []byte) []byte {
+	return dc.e.EncodeAll(src, dst)
+}
+
+func (dc *dictCodec) decompress(dst, src []byte) ([]byte, error) {
+	return dc.d.DecodeAll(src, dst)
+}
+
+// trainDict returns nil, since the pure Go zstd implementation doesn't support dictionary training.
+//
+// Values are compressed without dictionary in this case. Blocks compressed with dictionaries
+// by cgo builds can be still read.
+func trainDict(samples [][]byte, dictSize int) []byte {
+	return nil
+}
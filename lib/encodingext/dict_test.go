@@ -0,0 +1,68 @@
+package encodingext
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestMarshalUnmarshalValuesWithDict(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 20000; i++ {
+		samples = append(samples, []byte(fmt.Sprintf(`level=info component=parser msg="processed request" request_id=%d duration=%dms`, i, i%100)))
+	}
+	data := TrainDict(samples, 16*1024)
+	if len(data) == 0 {
+		t.Skipf("dictionary training isn't supported by the build")
+	}
+	d, err := NewDict(data)
+	if err != nil {
+		t.Fatalf("cannot create dictionary: %s", err)
+	}
+
+	values := samples[:100]
+	result, mt := MarshalValuesWithDict(nil, values, d)
+	if mt != MarshalTypeZSTDDictBytesArray {
+		t.Fatalf("unexpected marshal type; got %d; want %d", mt, MarshalTypeZSTDDictBytesArray)
+	}
+	valuesUnmarshaled, err := UnmarshalValuesWithDict(nil, result, mt, len(values), d)
+	if err != nil {
+		t.Fatalf("cannot unmarshal values: %s", err)
+	}
+	if !reflect.DeepEqual(valuesUnmarshaled, values) {
+		t.Fatalf("unexpected values unmarshaled\ngot\n%q\nwant\n%q", valuesUnmarshaled, values)
+	}
+
+	// Values marshaled with dictionary cannot be unmarshaled without dictionary.
+	if _, err := UnmarshalValuesWithDict(nil, result, mt, len(values), nil); err == nil {
+		t.Fatalf("expecting non-nil error when unmarshaling values without dictionary")
+	}
+
+	// Values marshaled without dictionary must be unmarshaled regardless of the dictionary.
+	result, mt = MarshalValues(nil, values)
+	valuesUnmarshaled, err = UnmarshalValuesWithDict(nil, result, mt, len(values), d)
+	if err != nil {
+		t.Fatalf("cannot unmarshal values: %s", err)
+	}
+	if !reflect.DeepEqual(valuesUnmarshaled, values) {
+		t.Fatalf("unexpected values unmarshaled\ngot\n%q\nwant\n%q", valuesUnmarshaled, values)
+	}
+
+	// Verify dictionary identity.
+	d2, err := NewDict(d.Data())
+	if err != nil {
+		t.Fatalf("cannot create dictionary: %s", err)
+	}
+	if !SameDict(d, d2) {
+		t.Fatalf("dictionaries with identical data must be equal")
+	}
+	if SameDict(d, nil) || !SameDict(nil, nil) {
+		t.Fatalf("unexpected SameDict result for nil dictionary")
+	}
+}
+
+func TestTrainDictTooSmallSamples(t *testing.T) {
+	if data := TrainDict([][]byte{[]byte("foo"), []byte("bar")}, 16*1024); data != nil {
+		t.Fatalf("expecting nil dictionary for too small samples; got %d bytes", len(data))
+	}
+}
@@ -15,12 +15,22 @@ type MarshalType = encoding.MarshalType
 const (
 	// MarshalTypeZSTDBytesArray is used for marshaling bytes array
 	MarshalTypeZSTDBytesArray = MarshalType(7)
+
+	// MarshalTypeZSTDDictBytesArray is used for marshaling bytes array with trained zstd dictionary.
+	//
+	// See MarshalValuesWithDict.
+	MarshalTypeZSTDDictBytesArray = MarshalType(8)
+
+	// MarshalTypeColumnarBytesArray is used for marshaling structured log lines split into per-field columns.
+	//
+	// The columns layout is stored outside the marshaled data, so such values are unmarshaled by lib/storage.
+	MarshalTypeColumnarBytesArray = MarshalType(9)
 )
 
 // CheckMarshalType verifies whether the mt is valid.
 func CheckMarshalType(mt MarshalType) error {
-	if mt < 0 || mt > 8 {
-		return fmt.Errorf("MarshalType should be in range [0..8]; got %d", mt)
+	if mt < 0 || mt > 9 {
+		return fmt.Errorf("MarshalType should be in range [0..9]; got %d", mt)
 	}
 	return nil
 }
@@ -77,18 +87,26 @@ func unmarshalBytesArray(dst [][]byte, src []byte, mt MarshalType, itemsCount in
 			return nil, fmt.Errorf("cannot decompress zstd data: %w", err)
 		}
 
-		var b []byte
-		for i := 0; i < itemsCount; i++ {
-			bb.B, b, err = encoding.UnmarshalBytes(bb.B)
-			if err != nil {
-				return nil, err
-			}
-			dst = append(dst, append([]byte(nil), b...))
-		}
+		return unmarshalRawBytesArray(dst, bb.B, itemsCount)
+	case MarshalTypeZSTDDictBytesArray:
+		return nil, fmt.Errorf("missing zstd dictionary for MarshalType=%d", mt)
+	case MarshalTypeColumnarBytesArray:
+		return nil, fmt.Errorf("missing columns layout for MarshalType=%d", mt)
 	default:
 		return nil, fmt.Errorf("unknown MarshalType=%d", mt)
 	}
+}
 
+func unmarshalRawBytesArray(dst [][]byte, src []byte, itemsCount int) ([][]byte, error) {
+	var b []byte
+	var err error
+	for i := 0; i < itemsCount; i++ {
+		src, b, err = encoding.UnmarshalBytes(src)
+		if err != nil {
+			return nil, err
+		}
+		dst = append(dst, append([]byte(nil), b...))
+	}
 	return dst, nil
 }
 
@@ -0,0 +1,348 @@
+package httpauth
+
+import (
+	"crypto/subtle"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logql"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/tenant"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/procutil"
+	"github.com/VictoriaMetrics/metrics"
+	"gopkg.in/yaml.v2"
+)
+
+var configFile = flag.String("auth.config", "", "Optional path to YAML file with users allowed to access HTTP APIs. "+
+	"Every user is identified by bearer token or by basic auth username and password and is allowed to perform the given operations "+
+	"over the given tenants. All the requests are allowed if the flag is empty. The file is re-read on SIGHUP")
+
+// Operation is an operation, which may be allowed to users.
+type Operation string
+
+// Operations, which may be allowed to users.
+const (
+	// OpPush is writing logs via /loki/api/v1/push.
+	OpPush Operation = "push"
+
+	// OpQuery is reading logs and metadata via query, label, series and stats APIs.
+	OpQuery Operation = "query"
+
+	// OpTail is reading logs via /loki/api/v1/tail.
+	OpTail Operation = "tail"
+
+	// OpDelete is deleting logs.
+	OpDelete Operation = "delete"
+
+	// OpExport is exporting raw logs via export and federate APIs.
+	OpExport Operation = "export"
+)
+
+// allTenants matches all the tenants in `tenants` list of user config.
+const allTenants = "*"
+
+// allOperations matches all the operations in `operations` list of user config.
+const allOperations = "*"
+
+// Config is the config for -auth.config file.
+type Config struct {
+	Users []UserConfig `yaml:"users"`
+}
+
+// UserConfig is a user from -auth.config file.
+type UserConfig struct {
+	// Name is used in audit logs. It defaults to Username.
+	Name string `yaml:"name,omitempty"`
+
+	BearerToken string `yaml:"bearer_token,omitempty"`
+	Username    string `yaml:"username,omitempty"`
+	Password    string `yaml:"password,omitempty"`
+
+	// Tenants contains X-Scope-OrgID values or `*` for all the tenants.
+	Tenants []string `yaml:"tenants"`
+
+	// Operations contains allowed operations or `*` for all the operations.
+	Operations []Operation `yaml:"operations"`
+
+	// StreamFilter is an optional stream selector such as `{namespace!="payments"}`.
+	//
+	// Its label matchers are added to every stream selector of the user requests,
+	// so streams outside the selector cannot be read or deleted by the user.
+	StreamFilter string `yaml:"stream_filter,omitempty"`
+}
+
+// Init must be called after flag.Parse and tenant.Init and before using the httpauth package.
+func Init() {
+	ac, err := loadConfig()
+	if err != nil {
+		logger.Fatalf("cannot load -auth.config: %s", err)
+	}
+	configGlobal.Store(ac)
+	if len(*configFile) == 0 {
+		return
+	}
+	sighupCh := procutil.NewSighupChan()
+	go func() {
+		for range sighupCh {
+			logger.Infof("received SIGHUP; reloading -auth.config=%q...", *configFile)
+			ac, err := loadConfig()
+			if err != nil {
+				logger.Errorf("cannot load the updated -auth.config: %s; preserving the previous config", err)
+				continue
+			}
+			configGlobal.Store(ac)
+			logger.Infof("successfully reloaded -auth.config=%q", *configFile)
+		}
+	}()
+}
+
+var configGlobal atomic.Value
+
+// authConfig contains users from -auth.config indexed by their credentials.
+type authConfig struct {
+	byBearerToken map[string]*UserConfig
+	byUsername    map[string]*UserConfig
+}
+
+func loadConfig() (*authConfig, error) {
+	if len(*configFile) == 0 {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(*configFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read -auth.config=%q: %w", *configFile, err)
+	}
+	ac, err := parseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse -auth.config=%q: %w", *configFile, err)
+	}
+	return ac, nil
+}
+
+func parseConfig(data []byte) (*authConfig, error) {
+	var cfg Config
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, err
+	}
+	ac := &authConfig{
+		byBearerToken: make(map[string]*UserConfig),
+		byUsername:    make(map[string]*UserConfig),
+	}
+	for i := range cfg.Users {
+		uc := &cfg.Users[i]
+		if err := validateUserConfig(uc); err != nil {
+			return nil, fmt.Errorf("invalid user #%d: %w", i+1, err)
+		}
+		if len(uc.BearerToken) > 0 {
+			if ac.byBearerToken[uc.BearerToken] != nil {
+				return nil, fmt.Errorf("duplicate bearer_token for user %q", uc.Name)
+			}
+			ac.byBearerToken[uc.BearerToken] = uc
+			continue
+		}
+		if ac.byUsername[uc.Username] != nil {
+			return nil, fmt.Errorf("duplicate username %q", uc.Username)
+		}
+		ac.byUsername[uc.Username] = uc
+	}
+	return ac, nil
+}
+
+func validateUserConfig(uc *UserConfig) error {
+	if len(uc.BearerToken) > 0 && len(uc.Username) > 0 {
+		return fmt.Errorf("bearer_token and username cannot be set simultaneously")
+	}
+	if len(uc.BearerToken) == 0 && len(uc.Username) == 0 {
+		return fmt.Errorf("either bearer_token or username must be set")
+	}
+	if len(uc.Username) > 0 && len(uc.Password) == 0 {
+		return fmt.Errorf("missing password for username %q", uc.Username)
+	}
+	if len(uc.Name) == 0 {
+		uc.Name = uc.Username
+	}
+	if len(uc.Tenants) == 0 {
+		return fmt.Errorf("missing tenants for user %q", uc.Name)
+	}
+	for _, s := range uc.Tenants {
+		if s == allTenants {
+			continue
+		}
+		if _, err := tenant.ParseOrgIDs(s); err != nil {
+			return fmt.Errorf("invalid tenant %q for user %q: %w", s, uc.Name, err)
+		}
+	}
+	if len(uc.Operations) == 0 {
+		return fmt.Errorf("missing operations for user %q", uc.Name)
+	}
+	for _, op := range uc.Operations {
+		switch op {
+		case allOperations, OpPush, OpQuery, OpTail, OpDelete, OpExport:
+		default:
+			return fmt.Errorf("unknown operation %q for user %q; supported operations: %s, %s, %s, %s, %s or %s",
+				op, uc.Name, OpPush, OpQuery, OpTail, OpDelete, OpExport, allOperations)
+		}
+	}
+	if len(uc.StreamFilter) > 0 {
+		if err := validateStreamFilter(uc.StreamFilter); err != nil {
+			return fmt.Errorf("invalid stream_filter=%q for user %q: %w", uc.StreamFilter, uc.Name, err)
+		}
+	}
+	return nil
+}
+
+func validateStreamFilter(s string) error {
+	expr, err := logql.Parse(s)
+	if err != nil {
+		return err
+	}
+	me, ok := expr.(*logql.MetricExpr)
+	if !ok || len(me.MetadataFilters) > 0 || len(me.PatternFilters) > 0 {
+		return fmt.Errorf("expecting stream selector such as {label!=\"value\"}")
+	}
+	if len(me.LabelFilters) == 0 {
+		return fmt.Errorf("stream selector cannot be empty")
+	}
+	return nil
+}
+
+// Authorize verifies whether r is allowed to perform op over the given tenants.
+//
+// nil tenants means the request isn't limited to particular tenants, so it must be allowed for all the tenants.
+// Denied requests are written to the audit log. The returned error contains http status code for the response.
+func Authorize(r *http.Request, op Operation, tenants []*auth.Token) error {
+	ac, _ := configGlobal.Load().(*authConfig)
+	if ac == nil {
+		return nil
+	}
+	uc := ac.getUser(r)
+	if uc == nil {
+		unauthorizedRequests.Inc()
+		auditDenied(r, op, "", tenants, "missing or invalid credentials")
+		return &httpserver.ErrorWithStatusCode{
+			Err:        fmt.Errorf("missing or invalid credentials; pass bearer token or basic auth credentials from -auth.config"),
+			StatusCode: http.StatusUnauthorized,
+		}
+	}
+	if !uc.hasOperation(op) {
+		forbiddenRequests.Inc()
+		auditDenied(r, op, uc.Name, tenants, "the operation isn't allowed")
+		return &httpserver.ErrorWithStatusCode{
+			Err:        fmt.Errorf("%q operation isn't allowed for user %q", op, uc.Name),
+			StatusCode: http.StatusForbidden,
+		}
+	}
+	if tenants == nil {
+		if !uc.hasAllTenants() {
+			forbiddenRequests.Inc()
+			auditDenied(r, op, uc.Name, tenants, "access to all the tenants isn't allowed")
+			return &httpserver.ErrorWithStatusCode{
+				Err:        fmt.Errorf("the request requires access to all the tenants, which isn't allowed for user %q", uc.Name),
+				StatusCode: http.StatusForbidden,
+			}
+		}
+		return nil
+	}
+	for _, at := range tenants {
+		if !uc.hasTenant(at) {
+			forbiddenRequests.Inc()
+			auditDenied(r, op, uc.Name, tenants, fmt.Sprintf("tenant %d:%d isn't allowed", at.AccountID, at.ProjectID))
+			return &httpserver.ErrorWithStatusCode{
+				Err:        fmt.Errorf("tenant %d:%d isn't allowed for user %q", at.AccountID, at.ProjectID, uc.Name),
+				StatusCode: http.StatusForbidden,
+			}
+		}
+	}
+	return nil
+}
+
+// GetStreamFilter returns stream_filter for the user authenticated by r.
+//
+// An empty string is returned if r isn't limited by stream_filter.
+func GetStreamFilter(r *http.Request) string {
+	ac, _ := configGlobal.Load().(*authConfig)
+	if ac == nil {
+		return ""
+	}
+	uc := ac.getUser(r)
+	if uc == nil {
+		return ""
+	}
+	return uc.StreamFilter
+}
+
+func (ac *authConfig) getUser(r *http.Request) *UserConfig {
+	if username, password, ok := r.BasicAuth(); ok {
+		uc := ac.byUsername[username]
+		if uc == nil || subtle.ConstantTimeCompare([]byte(password), []byte(uc.Password)) != 1 {
+			return nil
+		}
+		return uc
+	}
+	s := r.Header.Get("Authorization")
+	if !strings.HasPrefix(s, "Bearer ") {
+		return nil
+	}
+	return ac.byBearerToken[s[len("Bearer "):]]
+}
+
+func (uc *UserConfig) hasOperation(op Operation) bool {
+	for _, x := range uc.Operations {
+		if x == allOperations || x == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (uc *UserConfig) hasAllTenants() bool {
+	for _, s := range uc.Tenants {
+		if s == allTenants {
+			return true
+		}
+	}
+	return false
+}
+
+func (uc *UserConfig) hasTenant(at *auth.Token) bool {
+	for _, s := range uc.Tenants {
+		if s == allTenants {
+			return true
+		}
+		// Resolve org ids on every call, so updates for -tenant.orgIDMapFile are applied without re-reading -auth.config.
+		tenants, err := tenant.ParseOrgIDs(s)
+		if err != nil {
+			continue
+		}
+		for _, t := range tenants {
+			if *t.AuthToken == *at {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func auditDenied(r *http.Request, op Operation, user string, tenants []*auth.Token, reason string) {
+	var a []string
+	for _, at := range tenants {
+		a = append(a, fmt.Sprintf("%d:%d", at.AccountID, at.ProjectID))
+	}
+	tenantsStr := "*"
+	if tenants != nil {
+		tenantsStr = strings.Join(a, ",")
+	}
+	logger.Warnf("auth audit: denied %s request to %q from %s; user=%q, tenants=%s: %s",
+		op, r.URL.Path, httpserver.GetQuotedRemoteAddr(r), user, tenantsStr, reason)
+}
+
+var (
+	unauthorizedRequests = metrics.NewCounter(`vm_http_auth_denied_requests_total{reason="unauthorized"}`)
+	forbiddenRequests    = metrics.NewCounter(`vm_http_auth_denied_requests_total{reason="forbidden"}`)
+)
@@ -0,0 +1,206 @@
+package httpauth
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+)
+
+func TestParseConfigSuccess(t *testing.T) {
+	data := `
+users:
+- name: grafana
+  bearer_token: abc
+  tenants: ["1", "2:3"]
+  operations: [query, tail]
+- username: promtail
+  password: secret
+  tenants: ["*"]
+  operations: [push]
+- name: support
+  bearer_token: def
+  tenants: ["1"]
+  operations: [query]
+  stream_filter: '{namespace!="payments", env=~"prod|staging"}'
+`
+	ac, err := parseConfig([]byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if uc := ac.byBearerToken["def"]; uc == nil || uc.StreamFilter != `{namespace!="payments", env=~"prod|staging"}` {
+		t.Fatalf("cannot find user with stream_filter; got %+v", uc)
+	}
+	if uc := ac.byBearerToken["abc"]; uc == nil || uc.Name != "grafana" {
+		t.Fatalf("cannot find user by bearer token; got %+v", uc)
+	}
+	if uc := ac.byUsername["promtail"]; uc == nil || uc.Name != "promtail" {
+		t.Fatalf("cannot find user by username; got %+v", uc)
+	}
+}
+
+func TestParseConfigFailure(t *testing.T) {
+	f := func(data string) {
+		t.Helper()
+		if _, err := parseConfig([]byte(data)); err == nil {
+			t.Fatalf("expecting non-nil error for config %q", data)
+		}
+	}
+
+	// Unknown field
+	f(`users: [{bearer_token: abc, tenants: ["1"], operations: [query], foo: bar}]`)
+
+	// Missing credentials
+	f(`users: [{tenants: ["1"], operations: [query]}]`)
+
+	// Both bearer token and username
+	f(`users: [{bearer_token: abc, username: foo, password: bar, tenants: ["1"], operations: [query]}]`)
+
+	// Missing password
+	f(`users: [{username: foo, tenants: ["1"], operations: [query]}]`)
+
+	// Missing tenants
+	f(`users: [{bearer_token: abc, operations: [query]}]`)
+
+	// Unknown tenant
+	f(`users: [{bearer_token: abc, tenants: ["team-a"], operations: [query]}]`)
+
+	// Missing operations
+	f(`users: [{bearer_token: abc, tenants: ["1"]}]`)
+
+	// Unknown operation
+	f(`users: [{bearer_token: abc, tenants: ["1"], operations: [write]}]`)
+
+	// Duplicate credentials
+	f(`users: [{bearer_token: abc, tenants: ["1"], operations: [query]}, {bearer_token: abc, tenants: ["2"], operations: [query]}]`)
+	f(`users: [{username: foo, password: a, tenants: ["1"], operations: [query]}, {username: foo, password: b, tenants: ["2"], operations: [query]}]`)
+
+	// Invalid stream_filter
+	f(`users: [{bearer_token: abc, tenants: ["1"], operations: [query], stream_filter: 'foo{'}]`)
+	f(`users: [{bearer_token: abc, tenants: ["1"], operations: [query], stream_filter: '{}'}]`)
+	f(`users: [{bearer_token: abc, tenants: ["1"], operations: [query], stream_filter: 'rate({app="foo"}[5m])'}]`)
+	f(`users: [{bearer_token: abc, tenants: ["1"], operations: [query], stream_filter: '{app="foo"} |> "<_> error"'}]`)
+}
+
+func TestAuthorize(t *testing.T) {
+	data := `
+users:
+- name: grafana
+  bearer_token: abc
+  tenants: ["1", "2:3"]
+  operations: [query, tail]
+- username: admin
+  password: secret
+  tenants: ["*"]
+  operations: ["*"]
+`
+	ac, err := parseConfig([]byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	configGlobal.Store(ac)
+	defer configGlobal.Store((*authConfig)(nil))
+
+	f := func(setAuth func(r *http.Request), op Operation, tenants []*auth.Token, statusCodeExpected int) {
+		t.Helper()
+		r, err := http.NewRequest("GET", "http://foo/loki/api/v1/query", nil)
+		if err != nil {
+			t.Fatalf("unexpected error in NewRequest: %s", err)
+		}
+		setAuth(r)
+		err = Authorize(r, op, tenants)
+		if statusCodeExpected == 0 {
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			return
+		}
+		var esc *httpserver.ErrorWithStatusCode
+		if !errors.As(err, &esc) {
+			t.Fatalf("expecting ErrorWithStatusCode; got %v", err)
+		}
+		if esc.StatusCode != statusCodeExpected {
+			t.Fatalf("unexpected status code; got %d; want %d", esc.StatusCode, statusCodeExpected)
+		}
+	}
+	bearer := func(token string) func(r *http.Request) {
+		return func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	basic := func(username, password string) func(r *http.Request) {
+		return func(r *http.Request) {
+			r.SetBasicAuth(username, password)
+		}
+	}
+	noAuth := func(r *http.Request) {}
+	tenant1 := &auth.Token{AccountID: 1}
+	tenant23 := &auth.Token{AccountID: 2, ProjectID: 3}
+	tenant4 := &auth.Token{AccountID: 4}
+
+	// Allowed requests
+	f(bearer("abc"), OpQuery, []*auth.Token{tenant1}, 0)
+	f(bearer("abc"), OpTail, []*auth.Token{tenant1, tenant23}, 0)
+	f(basic("admin", "secret"), OpDelete, []*auth.Token{tenant4}, 0)
+	f(basic("admin", "secret"), OpQuery, nil, 0)
+
+	// Missing or invalid credentials
+	f(noAuth, OpQuery, []*auth.Token{tenant1}, http.StatusUnauthorized)
+	f(bearer("foo"), OpQuery, []*auth.Token{tenant1}, http.StatusUnauthorized)
+	f(basic("admin", "bad"), OpQuery, []*auth.Token{tenant1}, http.StatusUnauthorized)
+	f(basic("grafana", ""), OpQuery, []*auth.Token{tenant1}, http.StatusUnauthorized)
+
+	// Disallowed operation
+	f(bearer("abc"), OpPush, []*auth.Token{tenant1}, http.StatusForbidden)
+
+	// Disallowed tenants
+	f(bearer("abc"), OpQuery, []*auth.Token{tenant4}, http.StatusForbidden)
+	f(bearer("abc"), OpQuery, []*auth.Token{tenant1, tenant4}, http.StatusForbidden)
+	f(bearer("abc"), OpQuery, nil, http.StatusForbidden)
+}
+
+func TestAuthorizeDisabled(t *testing.T) {
+	r, err := http.NewRequest("GET", "http://foo/loki/api/v1/push", nil)
+	if err != nil {
+		t.Fatalf("unexpected error in NewRequest: %s", err)
+	}
+	if err := Authorize(r, OpPush, []*auth.Token{{AccountID: 1}}); err != nil {
+		t.Fatalf("unexpected error when -auth.config isn't set: %s", err)
+	}
+}
+
+func TestGetStreamFilter(t *testing.T) {
+	data := `
+users:
+- bearer_token: abc
+  tenants: ["1"]
+  operations: [query]
+  stream_filter: '{namespace!="payments"}'
+- bearer_token: def
+  tenants: ["1"]
+  operations: [query]
+`
+	ac, err := parseConfig([]byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	configGlobal.Store(ac)
+	defer configGlobal.Store((*authConfig)(nil))
+
+	f := func(token, streamFilterExpected string) {
+		t.Helper()
+		r, err := http.NewRequest("GET", "http://foo/loki/api/v1/query", nil)
+		if err != nil {
+			t.Fatalf("unexpected error in NewRequest: %s", err)
+		}
+		r.Header.Set("Authorization", "Bearer "+token)
+		if sf := GetStreamFilter(r); sf != streamFilterExpected {
+			t.Fatalf("unexpected stream filter; got %q; want %q", sf, streamFilterExpected)
+		}
+	}
+	f("abc", `{namespace!="payments"}`)
+	f("def", "")
+	f("unknown", "")
+}
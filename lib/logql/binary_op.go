@@ -136,6 +136,16 @@ func IsBinaryOpCmp(op string) bool {
 	}
 }
 
+// IsLineFilterOp returns true if op is Loki line filter such as '|=', '!=', '|~' or '!~'.
+func IsLineFilterOp(op string) bool {
+	switch op {
+	case "|=", "!=", "|~", "!~":
+		return true
+	default:
+		return false
+	}
+}
+
 func isBinaryOpLogicalSet(op string) bool {
 	op = strings.ToLower(op)
 	switch op {
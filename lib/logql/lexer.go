@@ -83,6 +83,13 @@ again:
 	case '{', '}', '[', ']', '(', ')', ',':
 		token = s[:1]
 		goto tokenFoundLabel
+	case '|':
+		if len(s) == 1 || (s[1] != '=' && s[1] != '~') {
+			// Bare `|` starts a pipeline stage such as `| label="value"` or `|> "pattern"`.
+			// `|=` and `|~` line filters are scanned as binary ops below.
+			token = s[:1]
+			goto tokenFoundLabel
+		}
 	}
 	if isIdentPrefix(s) {
 		token = scanIdent(s)
@@ -374,7 +374,12 @@ func (p *parser) parseExpr() (Expr, error) {
 				}
 			}
 		}
-		e2, err := p.parseSingleExpr()
+		var e2 Expr
+		if IsLineFilterOp(be.Op) {
+			e2, err = p.parseLineFilterArg(e)
+		} else {
+			e2, err = p.parseSingleExpr()
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -760,7 +765,7 @@ func expandWithExpr(was []*withArgExpr, e Expr) (Expr, error) {
 		}
 		return eNew, nil
 	case *MetricExpr:
-		if len(t.LabelFilters) > 0 {
+		if len(t.LabelFilters) > 0 || len(t.MetadataFilters) > 0 || len(t.PatternFilters) > 0 || len(t.FieldFilters) > 0 {
 			// Already expanded.
 			return t, nil
 		}
@@ -806,6 +811,23 @@ func expandWithExpr(was []*withArgExpr, e Expr) (Expr, error) {
 				me.LabelFilters = append(me.LabelFilters, *lf)
 			}
 			me.LabelFilters = removeDuplicateLabelFilters(me.LabelFilters)
+			mfs, err := expandMetadataFilters(was, t.metadataFilters)
+			if err != nil {
+				return nil, err
+			}
+			me.MetadataFilters = mfs
+			pfs, err := expandPatternFilters(was, t.patternFilters)
+			if err != nil {
+				return nil, err
+			}
+			me.PatternFilters = pfs
+			ffs, err := expandMetadataFilters(was, t.fieldFilters)
+			if err != nil {
+				return nil, err
+			}
+			me.FieldFilters = ffs
+			me.Parser = t.Parser
+			me.Unwrap = t.Unwrap
 			t = &me
 		}
 		if !t.hasNonEmptyMetricGroup() {
@@ -841,6 +863,20 @@ func expandWithExpr(was []*withArgExpr, e Expr) (Expr, error) {
 		me.LabelFilters = append(me.LabelFilters, wme.LabelFilters...)
 		me.LabelFilters = append(me.LabelFilters, t.LabelFilters[1:]...)
 		me.LabelFilters = removeDuplicateLabelFilters(me.LabelFilters)
+		me.MetadataFilters = append(me.MetadataFilters, wme.MetadataFilters...)
+		me.MetadataFilters = append(me.MetadataFilters, t.MetadataFilters...)
+		me.PatternFilters = append(me.PatternFilters, wme.PatternFilters...)
+		me.PatternFilters = append(me.PatternFilters, t.PatternFilters...)
+		me.Parser = wme.Parser
+		if t.Parser != "" {
+			me.Parser = t.Parser
+		}
+		me.FieldFilters = append(me.FieldFilters, wme.FieldFilters...)
+		me.FieldFilters = append(me.FieldFilters, t.FieldFilters...)
+		me.Unwrap = wme.Unwrap
+		if t.Unwrap != "" {
+			me.Unwrap = t.Unwrap
+		}
 
 		if re == nil {
 			return &me, nil
@@ -973,6 +1009,39 @@ func extractStringValue(token string) (string, error) {
 	return s, nil
 }
 
+func expandPatternFilters(was []*withArgExpr, ses []*StringExpr) ([]string, error) {
+	var pfs []string
+	for _, se := range ses {
+		eNew, err := expandWithExpr(was, se)
+		if err != nil {
+			return nil, err
+		}
+		pfs = append(pfs, eNew.(*StringExpr).S)
+	}
+	return pfs, nil
+}
+
+func expandMetadataFilters(was []*withArgExpr, lfes []*labelFilterExpr) ([]LabelFilter, error) {
+	var lfs []LabelFilter
+	for _, lfe := range lfes {
+		se, err := expandWithExpr(was, lfe.Value)
+		if err != nil {
+			return nil, err
+		}
+		var lfeNew labelFilterExpr
+		lfeNew.Label = lfe.Label
+		lfeNew.Value = se.(*StringExpr)
+		lfeNew.IsNegative = lfe.IsNegative
+		lfeNew.IsRegexp = lfe.IsRegexp
+		lf, err := lfeNew.toLabelFilter()
+		if err != nil {
+			return nil, err
+		}
+		lfs = append(lfs, *lf)
+	}
+	return lfs, nil
+}
+
 func removeDuplicateLabelFilters(lfs []LabelFilter) []LabelFilter {
 	lfsm := make(map[string]bool, len(lfs))
 	lfsNew := lfs[:0]
@@ -1153,11 +1222,17 @@ func (p *parser) parseLabelFilterExpr() (*labelFilterExpr, error) {
 	if !isIdentPrefix(p.lex.Token) {
 		return nil, fmt.Errorf(`labelFilterExpr: unexpected token %q; want "ident"`, p.lex.Token)
 	}
-	var lfe labelFilterExpr
-	lfe.Label = unescapeIdent(p.lex.Token)
+	label := unescapeIdent(p.lex.Token)
 	if err := p.lex.Next(); err != nil {
 		return nil, err
 	}
+	return p.parseLabelFilterExprWithLabel(label)
+}
+
+// parseLabelFilterExprWithLabel parses the remaining part of `label <op> "value"` expression after the label.
+func (p *parser) parseLabelFilterExprWithLabel(label string) (*labelFilterExpr, error) {
+	var lfe labelFilterExpr
+	lfe.Label = label
 
 	switch p.lex.Token {
 	case "=":
@@ -1369,9 +1444,131 @@ func (p *parser) parseMetricExpr() (*MetricExpr, error) {
 		return nil, err
 	}
 	me.labelFilters = append(me.labelFilters, lfes...)
+	if err := p.parseStages(&me); err != nil {
+		return nil, err
+	}
 	return &me, nil
 }
 
+// parseStages parses `| name="value"`, `|> "pattern"`, `| json`, `| logfmt` and `| unwrap name` stages and adds them to me.
+//
+// `| name="value"` stages following `| json` or `| logfmt` stage are filters on log fields instead of structured metadata.
+func (p *parser) parseStages(me *MetricExpr) error {
+	for p.lex.Token == "|" {
+		if me.Unwrap != "" {
+			return fmt.Errorf("unwrap: the stage must be the last one")
+		}
+		if err := p.lex.Next(); err != nil {
+			return err
+		}
+		if p.lex.Token == ">" {
+			// Pattern filter `|> "pattern"`.
+			if err := p.lex.Next(); err != nil {
+				return err
+			}
+			se, err := p.parseStringExpr()
+			if err != nil {
+				return fmt.Errorf("patternFilter: %w", err)
+			}
+			me.patternFilters = append(me.patternFilters, se)
+			continue
+		}
+		if !isIdentPrefix(p.lex.Token) {
+			return fmt.Errorf(`stage: unexpected token %q after "|"; want label name or ">"`, p.lex.Token)
+		}
+		label := unescapeIdent(p.lex.Token)
+		if err := p.lex.Next(); err != nil {
+			return err
+		}
+		if !isLabelFilterOp(p.lex.Token) {
+			switch label {
+			case "json", "logfmt":
+				if me.Parser != "" {
+					return fmt.Errorf("%s: duplicate parser stage; %q is already set", label, me.Parser)
+				}
+				me.Parser = label
+				continue
+			case "unwrap":
+				if me.Parser == "" {
+					return fmt.Errorf(`unwrap: the stage must follow "| json" or "| logfmt" stage`)
+				}
+				if !isIdentPrefix(p.lex.Token) {
+					return fmt.Errorf(`unwrap: unexpected token %q; want field name`, p.lex.Token)
+				}
+				me.Unwrap = unescapeIdent(p.lex.Token)
+				if err := p.lex.Next(); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		lfe, err := p.parseLabelFilterExprWithLabel(label)
+		if err != nil {
+			return err
+		}
+		if lfe.Value == nil {
+			return fmt.Errorf(`metadataFilter: unexpected token %q; want "=", "!=", "=~", "!~"`, p.lex.Token)
+		}
+		if me.Parser != "" {
+			me.fieldFilters = append(me.fieldFilters, lfe)
+		} else {
+			me.metadataFilters = append(me.metadataFilters, lfe)
+		}
+	}
+	return nil
+}
+
+func isLabelFilterOp(s string) bool {
+	switch s {
+	case "=", "!=", "=~", "!~":
+		return true
+	default:
+		return false
+	}
+}
+
+// parseLineFilterArg parses the arg for `left |= "arg"` line filter together with the stages following it.
+//
+// The stages are added to the stream selector the line filter is applied to, since they don't depend on the order of filters.
+func (p *parser) parseLineFilterArg(left Expr) (Expr, error) {
+	e, err := p.parseSingleExprWithoutRollupSuffix()
+	if err != nil {
+		return nil, err
+	}
+	if p.lex.Token == "|" {
+		me := getLineFilterMetricExpr(left)
+		if me == nil {
+			return nil, fmt.Errorf(`stage: "|" must follow a stream selector or a line filter`)
+		}
+		if err := p.parseStages(me); err != nil {
+			return nil, err
+		}
+	}
+	if p.lex.Token != "[" && !isOffset(p.lex.Token) {
+		return e, nil
+	}
+	return p.parseRollupExpr(e)
+}
+
+// getLineFilterMetricExpr returns the stream selector for the chain of line filters e.
+//
+// nil is returned if e isn't a stream selector with line filters.
+func getLineFilterMetricExpr(e Expr) *MetricExpr {
+	for {
+		switch t := e.(type) {
+		case *MetricExpr:
+			return t
+		case *BinaryOpExpr:
+			if !IsLineFilterOp(t.Op) {
+				return nil
+			}
+			e = t.Left
+		default:
+			return nil
+		}
+	}
+}
+
 func (p *parser) parseRollupExpr(arg Expr) (Expr, error) {
 	var re RollupExpr
 	re.Expr = arg
@@ -1736,6 +1933,41 @@ type MetricExpr struct {
 
 	// labelFilters must be expanded to LabelFilters by expandWithExpr.
 	labelFilters []*labelFilterExpr
+
+	// MetadataFilters contains a list of structured metadata filters from `| name="value"` stages.
+	//
+	// Structured metadata isn't indexed, so these filters are applied to the selected log lines.
+	MetadataFilters []LabelFilter
+
+	// metadataFilters must be expanded to MetadataFilters by expandWithExpr.
+	metadataFilters []*labelFilterExpr
+
+	// PatternFilters contains a list of log patterns from `|> "pattern"` stages.
+	//
+	// Every `<_>` placeholder in the pattern matches arbitrary text. Log lines not matching the pattern are dropped.
+	PatternFilters []string
+
+	// patternFilters must be expanded to PatternFilters by expandWithExpr.
+	patternFilters []*StringExpr
+
+	// Parser contains the name of the parser stage such as `| json` or `| logfmt`.
+	//
+	// Both parsers extract top-level fields from JSON and logfmt log lines.
+	// It is empty if the stream selector has no parser stage.
+	Parser string
+
+	// FieldFilters contains a list of filters on log fields from `| name="value"` stages following the Parser stage.
+	//
+	// Missing field is treated as field with empty value.
+	FieldFilters []LabelFilter
+
+	// fieldFilters must be expanded to FieldFilters by expandWithExpr.
+	fieldFilters []*labelFilterExpr
+
+	// Unwrap contains the field name from `| unwrap name` stage.
+	//
+	// Rollup functions use numeric values for the field instead of counting log lines if it is set.
+	Unwrap string
 }
 
 // AppendString appends string representation of me to dst and returns the result.
@@ -1760,6 +1992,26 @@ func (me *MetricExpr) AppendString(dst []byte) []byte {
 	} else if len(me.LabelFilters) == 0 {
 		dst = append(dst, "{}"...)
 	}
+	for i := range me.MetadataFilters {
+		dst = append(dst, " | "...)
+		dst = me.MetadataFilters[i].AppendString(dst)
+	}
+	for _, pf := range me.PatternFilters {
+		dst = append(dst, " |> "...)
+		dst = strconv.AppendQuote(dst, pf)
+	}
+	if me.Parser != "" {
+		dst = append(dst, " | "...)
+		dst = append(dst, me.Parser...)
+	}
+	for i := range me.FieldFilters {
+		dst = append(dst, " | "...)
+		dst = me.FieldFilters[i].AppendString(dst)
+	}
+	if me.Unwrap != "" {
+		dst = append(dst, " | unwrap "...)
+		dst = appendEscapedIdent(dst, me.Unwrap)
+	}
 	return dst
 }
 
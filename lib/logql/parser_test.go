@@ -48,6 +48,37 @@ func TestParseSuccess(t *testing.T) {
 	same(`{foo="bar"} offset 10y`)
 	same(`{foo="bar"} offset -10y`)
 	same(`{foo="bar"}[5m] offset 10y`)
+	same(`{foo="bar"} | trace_id="abc"`)
+	same(`{foo="bar"} | trace_id="abc" | user!~"x.+"[5m]`)
+	another(`{foo="bar"}|trace_id="a" + "bc"`, `{foo="bar"} | trace_id="abc"`)
+	another(`with (x = "abc") {foo="bar"} | trace_id=x`, `{foo="bar"} | trace_id="abc"`)
+	same(`count_over_time({foo="bar"} | trace_id="abc"[5m])`)
+	same(`{foo="bar"} |> "GET <_> 200"`)
+	same(`{foo="bar"} | trace_id="abc" |> "<_> error <_>" |> "<_> timeout"`)
+	another(`{foo="bar"}|>"a <_>" + " b"`, `{foo="bar"} |> "a <_> b"`)
+	another(`with (p = "<_> error") {foo="bar"} |> p`, `{foo="bar"} |> "<_> error"`)
+	same(`count_over_time({foo="bar"} |> "<_> error"[5m])`)
+	same(`{foo="bar"} | json`)
+	same(`{foo="bar"} | json | level="error" | msg=~"time.+"`)
+	same(`{foo="bar"} | trace_id="abc" | logfmt | level!="debug"`)
+	same(`{foo="bar"} | json="abc"`)
+	same(`sum_over_time({foo="bar"} | json | level="error" | unwrap duration[5m])`)
+	another(`with (x = "error") {foo="bar"} | json | level=x`, `{foo="bar"} | json | level="error"`)
+	another(`{foo="bar"} | json | level="error" |= "abc"`, `{foo="bar"} | json | level="error" |= "abc"`)
+	same(`{foo="bar"} |= "abc"`)
+	same(`{foo="bar"} |~ "a.c"`)
+	same(`{foo="bar"} != "abc"`)
+	same(`{foo="bar"} !~ "a.c"`)
+	another(`{foo="bar"}|="abc"`, `{foo="bar"} |= "abc"`)
+	another(`{foo="bar"}|~"a.c"`, `{foo="bar"} |~ "a.c"`)
+	another(`count_over_time({foo="bar"} |~ "a.c" [1m])`, `count_over_time({foo="bar"} |~ "a.c"[1m])`)
+	another(`{foo="bar"} |= "abc" | trace_id="x"`, `{foo="bar"} | trace_id="x" |= "abc"`)
+	another(`{foo="bar"} |~ "a.c" | trace_id=~"x.+"`, `{foo="bar"} | trace_id=~"x.+" |~ "a.c"`)
+	another(`{foo="bar"} != "abc" | trace_id!="x"`, `{foo="bar"} | trace_id!="x" != "abc"`)
+	another(`{foo="bar"} !~ "a.c" | trace_id!~"x"`, `{foo="bar"} | trace_id!~"x" !~ "a.c"`)
+	another(`{foo="bar"} |= "abc" | trace_id="x" |> "<_> error"`, `{foo="bar"} | trace_id="x" |> "<_> error" |= "abc"`)
+	another(`{foo="bar"} |= "a" |~ "b" | trace_id="x"`, `({foo="bar"} | trace_id="x" |= "a") |~ "b"`)
+	another(`count_over_time({foo="bar"} |= "abc" | trace_id="x"[5m])`, `count_over_time({foo="bar"} | trace_id="x" |= "abc"[5m])`)
 	same(`{foo="bar"}[5m:3s] offset 10y`)
 	another(`{foo="bar"}[5m] oFFSEt 10y`, `{foo="bar"}[5m] offset 10y`)
 	same("METRIC")
@@ -450,6 +481,20 @@ func TestParseError(t *testing.T) {
 	f(`foo{bar`)
 	f(`foo{bar=`)
 	f(`foo{bar="baz"`)
+	f(`{foo="bar"} |`)
+	f(`{foo="bar"} | trace_id`)
+	f(`{foo="bar"} | trace_id=`)
+	f(`{foo="bar"} | trace_id=~"["`)
+	f(`{foo="bar"} |>`)
+	f(`{foo="bar"} |> 123`)
+	f(`{foo="bar"} | "abc"`)
+	f(`{foo="bar"} | json | json`)
+	f(`{foo="bar"} | unwrap duration`)
+	f(`{foo="bar"} | json | unwrap`)
+	f(`{foo="bar"} | json | unwrap duration | level="error"`)
+	f(`{foo="bar"} |= "abc" |`)
+	f(`{foo="bar"} |= "abc" | trace_id`)
+	f(`sum(rate({foo="bar"}[5m])) |= "abc" | trace_id="x"`)
 	f(`foo{bar="baz",  `)
 	f(`foo{123="23"}`)
 	f(`foo{foo}`)
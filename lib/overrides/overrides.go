@@ -0,0 +1,192 @@
+package overrides
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/tenant"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/procutil"
+	"gopkg.in/yaml.v2"
+)
+
+var configFile = flag.String("overrides.config", "", "Optional path to YAML file with per-tenant query limits. "+
+	"Limits from `defaults` section are applied to tenants missing in `tenants` section. The file is re-read on SIGHUP")
+
+// Limits contains per-tenant query limits.
+//
+// Zero value means the corresponding limit is disabled.
+type Limits struct {
+	// MaxQuerySeries is the maximum number of streams a single query may match.
+	MaxQuerySeries int `yaml:"max_query_series,omitempty"`
+
+	// MaxQueryBytesRead is the maximum number of bytes a single query may read from vmstorage nodes.
+	MaxQueryBytesRead int64 `yaml:"max_query_bytes_read,omitempty"`
+
+	// MaxEntriesLimitPerQuery is the maximum value for `limit` arg of log queries.
+	MaxEntriesLimitPerQuery int `yaml:"max_entries_limit_per_query,omitempty"`
+
+	// MaxQueryLength is the maximum time range for a single query.
+	MaxQueryLength time.Duration `yaml:"max_query_length,omitempty"`
+
+	// MaxConcurrentQueries is the maximum number of concurrently executed queries per tenant.
+	MaxConcurrentQueries int `yaml:"max_concurrent_queries,omitempty"`
+
+	// MaxQueuedRequests is the maximum number of requests waiting for execution in vmselect per tenant.
+	// It overrides -search.maxQueuedRequestsPerTenant.
+	MaxQueuedRequests int `yaml:"max_queued_requests,omitempty"`
+
+	// SchedulerWeight is the number of queued requests started for the tenant in a row
+	// before switching to the next tenant. It defaults to 1.
+	SchedulerWeight int `yaml:"scheduler_weight,omitempty"`
+}
+
+// Config is the config for -overrides.config file.
+type Config struct {
+	// Defaults contains limits for tenants missing in Tenants.
+	Defaults Limits `yaml:"defaults,omitempty"`
+
+	// Tenants contains limits per X-Scope-OrgID value. Missing limits are taken from Defaults.
+	Tenants map[string]yaml.MapSlice `yaml:"tenants,omitempty"`
+}
+
+// Init must be called after flag.Parse and tenant.Init and before using the overrides package.
+func Init() {
+	oc, err := loadConfig()
+	if err != nil {
+		logger.Fatalf("cannot load -overrides.config: %s", err)
+	}
+	configGlobal.Store(oc)
+	if len(*configFile) == 0 {
+		return
+	}
+	sighupCh := procutil.NewSighupChan()
+	go func() {
+		for range sighupCh {
+			logger.Infof("received SIGHUP; reloading -overrides.config=%q...", *configFile)
+			oc, err := loadConfig()
+			if err != nil {
+				logger.Errorf("cannot load the updated -overrides.config: %s; preserving the previous config", err)
+				continue
+			}
+			configGlobal.Store(oc)
+			logger.Infof("successfully reloaded -overrides.config=%q", *configFile)
+		}
+	}()
+}
+
+var configGlobal atomic.Value
+
+// overridesConfig contains parsed limits from -overrides.config.
+type overridesConfig struct {
+	defaults *Limits
+
+	// tenants contains limits per X-Scope-OrgID value.
+	tenants map[string]*Limits
+}
+
+func loadConfig() (*overridesConfig, error) {
+	if len(*configFile) == 0 {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(*configFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read -overrides.config=%q: %w", *configFile, err)
+	}
+	oc, err := parseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse -overrides.config=%q: %w", *configFile, err)
+	}
+	return oc, nil
+}
+
+func parseConfig(data []byte) (*overridesConfig, error) {
+	var cfg Config
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Defaults.validate(); err != nil {
+		return nil, fmt.Errorf("invalid defaults: %w", err)
+	}
+	oc := &overridesConfig{
+		defaults: &cfg.Defaults,
+		tenants:  make(map[string]*Limits, len(cfg.Tenants)),
+	}
+	for orgID, ms := range cfg.Tenants {
+		if _, err := tenant.ParseOrgIDs(orgID); err != nil {
+			return nil, fmt.Errorf("invalid tenant %q: %w", orgID, err)
+		}
+		// Unmarshal tenant limits on top of defaults, so missing limits are inherited from defaults.
+		tenantData, err := yaml.Marshal(ms)
+		if err != nil {
+			return nil, fmt.Errorf("cannot marshal limits for tenant %q: %w", orgID, err)
+		}
+		limits := cfg.Defaults
+		if err := yaml.UnmarshalStrict(tenantData, &limits); err != nil {
+			return nil, fmt.Errorf("cannot parse limits for tenant %q: %w", orgID, err)
+		}
+		if err := limits.validate(); err != nil {
+			return nil, fmt.Errorf("invalid limits for tenant %q: %w", orgID, err)
+		}
+		oc.tenants[orgID] = &limits
+	}
+	return oc, nil
+}
+
+func (l *Limits) validate() error {
+	if l.MaxQuerySeries < 0 {
+		return fmt.Errorf("max_query_series cannot be negative; got %d", l.MaxQuerySeries)
+	}
+	if l.MaxQueryBytesRead < 0 {
+		return fmt.Errorf("max_query_bytes_read cannot be negative; got %d", l.MaxQueryBytesRead)
+	}
+	if l.MaxEntriesLimitPerQuery < 0 {
+		return fmt.Errorf("max_entries_limit_per_query cannot be negative; got %d", l.MaxEntriesLimitPerQuery)
+	}
+	if l.MaxQueryLength < 0 {
+		return fmt.Errorf("max_query_length cannot be negative; got %s", l.MaxQueryLength)
+	}
+	if l.MaxConcurrentQueries < 0 {
+		return fmt.Errorf("max_concurrent_queries cannot be negative; got %d", l.MaxConcurrentQueries)
+	}
+	if l.MaxQueuedRequests < 0 {
+		return fmt.Errorf("max_queued_requests cannot be negative; got %d", l.MaxQueuedRequests)
+	}
+	if l.SchedulerWeight < 0 {
+		return fmt.Errorf("scheduler_weight cannot be negative; got %d", l.SchedulerWeight)
+	}
+	return nil
+}
+
+var noLimits = &Limits{}
+
+// GetLimits returns limits for the given tenant.
+//
+// The returned limits mustn't be modified.
+func GetLimits(at *auth.Token) *Limits {
+	oc, _ := configGlobal.Load().(*overridesConfig)
+	if oc == nil {
+		return noLimits
+	}
+	return oc.getLimits(at)
+}
+
+func (oc *overridesConfig) getLimits(at *auth.Token) *Limits {
+	for orgID, limits := range oc.tenants {
+		// Resolve org ids on every call, so updates for -tenant.orgIDMapFile are applied without re-reading -overrides.config.
+		tenants, err := tenant.ParseOrgIDs(orgID)
+		if err != nil {
+			continue
+		}
+		for _, t := range tenants {
+			if *t.AuthToken == *at {
+				return limits
+			}
+		}
+	}
+	return oc.defaults
+}
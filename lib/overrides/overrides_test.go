@@ -0,0 +1,94 @@
+package overrides
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+)
+
+func TestParseConfigSuccess(t *testing.T) {
+	data := `
+defaults:
+  max_query_series: 500
+  max_entries_limit_per_query: 5000
+  max_query_length: 721h
+tenants:
+  "1":
+    max_query_series: 1000
+    max_query_bytes_read: 1000000
+  "2:3|4":
+    max_concurrent_queries: 2
+    max_query_length: 24h
+`
+	oc, err := parseConfig([]byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	f := func(at *auth.Token, limitsExpected *Limits) {
+		t.Helper()
+		limits := oc.getLimits(at)
+		if !reflect.DeepEqual(limits, limitsExpected) {
+			t.Fatalf("unexpected limits for %d:%d\ngot\n%+v\nwant\n%+v", at.AccountID, at.ProjectID, limits, limitsExpected)
+		}
+	}
+
+	// Tenant limits are applied on top of defaults
+	f(&auth.Token{AccountID: 1}, &Limits{
+		MaxQuerySeries:          1000,
+		MaxQueryBytesRead:       1000000,
+		MaxEntriesLimitPerQuery: 5000,
+		MaxQueryLength:          721 * time.Hour,
+	})
+	f(&auth.Token{AccountID: 2, ProjectID: 3}, &Limits{
+		MaxQuerySeries:          500,
+		MaxEntriesLimitPerQuery: 5000,
+		MaxQueryLength:          24 * time.Hour,
+		MaxConcurrentQueries:    2,
+	})
+	f(&auth.Token{AccountID: 4}, &Limits{
+		MaxQuerySeries:          500,
+		MaxEntriesLimitPerQuery: 5000,
+		MaxQueryLength:          24 * time.Hour,
+		MaxConcurrentQueries:    2,
+	})
+
+	// Defaults are applied to unknown tenants
+	f(&auth.Token{AccountID: 5}, &Limits{
+		MaxQuerySeries:          500,
+		MaxEntriesLimitPerQuery: 5000,
+		MaxQueryLength:          721 * time.Hour,
+	})
+}
+
+func TestParseConfigFailure(t *testing.T) {
+	f := func(data string) {
+		t.Helper()
+		if _, err := parseConfig([]byte(data)); err == nil {
+			t.Fatalf("expecting non-nil error for config %q", data)
+		}
+	}
+
+	// Unknown fields
+	f(`foo: bar`)
+	f(`defaults: {max_series: 10}`)
+	f(`tenants: {"1": {max_series: 10}}`)
+
+	// Invalid values
+	f(`defaults: {max_query_series: foo}`)
+	f(`defaults: {max_query_length: 1x}`)
+	f(`tenants: {"1": {max_entries_limit_per_query: -1}}`)
+	f(`defaults: {max_concurrent_queries: -1}`)
+	f(`tenants: {"1": {scheduler_weight: -1}}`)
+
+	// Invalid tenant
+	f(`tenants: {"team-a": {max_query_series: 10}}`)
+}
+
+func TestGetLimitsDisabled(t *testing.T) {
+	limits := GetLimits(&auth.Token{AccountID: 1})
+	if *limits != (Limits{}) {
+		t.Fatalf("expecting empty limits when -overrides.config isn't set; got %+v", limits)
+	}
+}
@@ -0,0 +1,209 @@
+package querytracer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracer represents query tracer.
+//
+// It must be created via New call.
+// Each created tracer must be finalized via Done or Donef call.
+//
+// Tracer may contain sub-tracers (branches) in order to build tree-like execution order.
+// Call Tracer.NewChild func for adding sub-tracer. It is safe calling NewChild from concurrently running goroutines.
+//
+// All the Tracer methods are no-op on nil Tracer, so it is safe passing nil Tracer when tracing is disabled.
+type Tracer struct {
+	// startTime is the time when Tracer was created
+	startTime time.Time
+
+	// doneTime is the time when Done or Donef was called
+	doneTime time.Time
+
+	// message is the message generated by New, NewChild, Printf, Done or Donef call.
+	message string
+
+	// mu protects children.
+	mu sync.Mutex
+
+	// children is a list of children Tracer objects
+	children []*Tracer
+
+	// span contains span for the given Tracer. It is added via Tracer.AddJSON().
+	// If span is non-nil, then the remaining fields aren't used.
+	span *span
+}
+
+// New creates a new instance of the tracer with the given fmt.Sprintf(format, args...) message.
+//
+// If enabled isn't set, then all function calls to the returned object will be no-op.
+//
+// Done or Donef must be called when the tracer should be finished.
+func New(enabled bool, format string, args ...interface{}) *Tracer {
+	if !enabled {
+		return nil
+	}
+	return &Tracer{
+		message:   fmt.Sprintf(format, args...),
+		startTime: time.Now(),
+	}
+}
+
+// Enabled returns true if the t is enabled.
+func (t *Tracer) Enabled() bool {
+	return t != nil
+}
+
+// NewChild adds a new child Tracer to t with the given fmt.Sprintf(format, args...) message.
+//
+// It is safe calling NewChild from concurrent goroutines.
+func (t *Tracer) NewChild(format string, args ...interface{}) *Tracer {
+	if t == nil {
+		return nil
+	}
+	child := &Tracer{
+		message:   fmt.Sprintf(format, args...),
+		startTime: time.Now(),
+	}
+	t.addChild(child)
+	return child
+}
+
+// Done finishes t.
+//
+// Done cannot be called multiple times.
+// Other Tracer functions cannot be called after Done call.
+func (t *Tracer) Done() {
+	if t == nil {
+		return
+	}
+	t.doneTime = time.Now()
+}
+
+// Donef appends the given fmt.Sprintf(format, args..) message to t and finished it.
+//
+// Donef cannot be called multiple times.
+// Other Tracer functions cannot be called after Donef call.
+func (t *Tracer) Donef(format string, args ...interface{}) {
+	if t == nil {
+		return
+	}
+	t.message += ": " + fmt.Sprintf(format, args...)
+	t.doneTime = time.Now()
+}
+
+// Printf adds new fmt.Sprintf(format, args...) message to t.
+func (t *Tracer) Printf(format string, args ...interface{}) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	child := &Tracer{
+		startTime: now,
+		doneTime:  now,
+		message:   fmt.Sprintf(format, args...),
+	}
+	t.addChild(child)
+}
+
+// AddJSON adds a sub-trace to t.
+//
+// The jsonTrace must be encoded with ToJSON. It is used for adding traces obtained from vmstorage nodes.
+func (t *Tracer) AddJSON(jsonTrace []byte) error {
+	if t == nil {
+		return nil
+	}
+	if len(jsonTrace) == 0 {
+		return nil
+	}
+	var s *span
+	if err := json.Unmarshal(jsonTrace, &s); err != nil {
+		return fmt.Errorf("cannot unmarshal json trace: %w", err)
+	}
+	child := &Tracer{
+		span: s,
+	}
+	t.addChild(child)
+	return nil
+}
+
+func (t *Tracer) addChild(child *Tracer) {
+	t.mu.Lock()
+	t.children = append(t.children, child)
+	t.mu.Unlock()
+}
+
+// String returns string representation of t.
+//
+// String must be called when t methods aren't called by other goroutines.
+func (t *Tracer) String() string {
+	if t == nil {
+		return ""
+	}
+	s := t.toSpan()
+	var bb bytes.Buffer
+	s.writePlaintextWithIndent(&bb, 0)
+	return bb.String()
+}
+
+// ToJSON returns JSON representation of t.
+//
+// ToJSON must be called when t methods aren't called by other goroutines.
+func (t *Tracer) ToJSON() string {
+	if t == nil {
+		return ""
+	}
+	s := t.toSpan()
+	data, err := json.Marshal(s)
+	if err != nil {
+		panic(fmt.Errorf("BUG: unexpected error from json.Marshal: %w", err))
+	}
+	return string(data)
+}
+
+func (t *Tracer) toSpan() *span {
+	if t.span != nil {
+		return t.span
+	}
+	s := &span{
+		Message: t.message,
+	}
+	if t.doneTime.IsZero() {
+		// The tracer may be unfinished if the query has been canceled or failed.
+		s.Message += ": missing Tracer.Done() call"
+	} else {
+		s.DurationMsec = float64(t.doneTime.Sub(t.startTime)) / float64(time.Millisecond)
+	}
+	t.mu.Lock()
+	children := append([]*Tracer{}, t.children...)
+	t.mu.Unlock()
+	for _, child := range children {
+		s.Children = append(s.Children, child.toSpan())
+	}
+	return s
+}
+
+// span represents a single trace span
+type span struct {
+	// DurationMsec is the duration for the current trace span in milliseconds.
+	DurationMsec float64 `json:"duration_msec"`
+
+	// Message is a trace message
+	Message string `json:"message"`
+
+	// Children contains children spans
+	Children []*span `json:"children,omitempty"`
+}
+
+func (s *span) writePlaintextWithIndent(w *bytes.Buffer, indent int) {
+	prefix := strings.Repeat("| ", indent) + "- "
+	fmt.Fprintf(w, "%s%.03fms: %s\n", prefix, s.DurationMsec, s.Message)
+	for _, sChild := range s.Children {
+		sChild.writePlaintextWithIndent(w, indent+1)
+	}
+}
@@ -0,0 +1,106 @@
+package querytracer
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestTracerDisabled(t *testing.T) {
+	qt := New(false, "test")
+	if qt.Enabled() {
+		t.Fatalf("query tracer must be disabled")
+	}
+	qtChild := qt.NewChild("child done %d", 456)
+	if qtChild.Enabled() {
+		t.Fatalf("query tracer must be disabled")
+	}
+	qtChild.Printf("foo %d", 123)
+	qtChild.Donef("child")
+	qt.Printf("parent %d", 789)
+	if err := qt.AddJSON([]byte(`{"duration_msec":1,"message":"foo"}`)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	qt.Done()
+	if s := qt.String(); s != "" {
+		t.Fatalf("unexpected non-empty string for disabled tracer: %q", s)
+	}
+	if s := qt.ToJSON(); s != "" {
+		t.Fatalf("unexpected non-empty JSON for disabled tracer: %q", s)
+	}
+}
+
+func TestTracerEnabled(t *testing.T) {
+	qt := New(true, "test")
+	if !qt.Enabled() {
+		t.Fatalf("query tracer must be enabled")
+	}
+	qtChild := qt.NewChild("child %d", 456)
+	qtChild.Printf("foo %d", 123)
+	qtChild.Donef("rows=%d", 10)
+	qt.Printf("parent %d", 789)
+	qt.Done()
+
+	s := qt.String()
+	sExpected := `- 0.000ms: test
+| - 0.000ms: child 456: rows=10
+| | - 0.000ms: foo 123
+| - 0.000ms: parent 789
+`
+	if got := zeroDurationsInString(s); got != sExpected {
+		t.Fatalf("unexpected output;\ngot\n%s\nwant\n%s", got, sExpected)
+	}
+
+	jsonExpected := `{"duration_msec":0,"message":"test","children":[` +
+		`{"duration_msec":0,"message":"child 456: rows=10","children":[{"duration_msec":0,"message":"foo 123"}]},` +
+		`{"duration_msec":0,"message":"parent 789"}]}`
+	if got := zeroDurationsInJSON(qt.ToJSON()); got != jsonExpected {
+		t.Fatalf("unexpected JSON;\ngot\n%s\nwant\n%s", got, jsonExpected)
+	}
+}
+
+func TestTracerAddJSON(t *testing.T) {
+	qtRemote := New(true, "vmstorage")
+	qtRemote.Printf("found %d series", 3)
+	qtRemote.Done()
+	jsonRemote := qtRemote.ToJSON()
+
+	qt := New(true, "vmselect")
+	qtChild := qt.NewChild("rpc")
+	if err := qtChild.AddJSON([]byte(jsonRemote)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	qtChild.Done()
+	qt.Done()
+
+	sExpected := `- 0.000ms: vmselect
+| - 0.000ms: rpc
+| | - 0.000ms: vmstorage
+| | | - 0.000ms: found 3 series
+`
+	if got := zeroDurationsInString(qt.String()); got != sExpected {
+		t.Fatalf("unexpected output;\ngot\n%s\nwant\n%s", got, sExpected)
+	}
+
+	if err := qt.AddJSON([]byte("foobar")); err == nil {
+		t.Fatalf("expecting non-nil error for invalid JSON")
+	}
+}
+
+func TestTracerMissingDone(t *testing.T) {
+	qt := New(true, "test")
+	qt.NewChild("child")
+	qt.Done()
+	s := zeroDurationsInString(qt.String())
+	if !strings.Contains(s, "child: missing Tracer.Done() call") {
+		t.Fatalf("missing unfinished child in the output:\n%s", s)
+	}
+}
+
+func zeroDurationsInString(s string) string {
+	return regexp.MustCompile(`[0-9]+\.[0-9]{3}ms`).ReplaceAllString(s, "0.000ms")
+}
+
+func zeroDurationsInJSON(s string) string {
+	return regexp.MustCompile(`"duration_msec":[0-9.e-]+`).ReplaceAllString(s, `"duration_msec":0`)
+}
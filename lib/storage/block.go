@@ -37,6 +37,16 @@ type Block struct {
 
 	// Marshaled representation of values.
 	valuesData []byte
+
+	// dict is the dictionary for valuesData marshaled with encodingext.MarshalTypeZSTDDictBytesArray.
+	//
+	// It is also used for marshaling values in MarshalData.
+	dict *encodingext.Dict
+
+	// dictID is the id of the dictionary for valuesData obtained via UnmarshalBlock.
+	//
+	// dict is nil if the dictionary with dictID hasn't been registered via AddValuesDict.
+	dictID uint64
 }
 
 // Reset resets b.
@@ -49,6 +59,8 @@ func (b *Block) Reset() {
 	b.headerData = b.headerData[:0]
 	b.timestampsData = b.timestampsData[:0]
 	b.valuesData = b.valuesData[:0]
+	b.dict = nil
+	b.dictID = 0
 }
 
 // CopyFrom copies src to b.
@@ -61,6 +73,8 @@ func (b *Block) CopyFrom(src *Block) {
 	b.headerData = append(b.headerData[:0], src.headerData...)
 	b.timestampsData = append(b.timestampsData[:0], src.timestampsData...)
 	b.valuesData = append(b.valuesData[:0], src.valuesData...)
+	b.dict = src.dict
+	b.dictID = src.dictID
 }
 
 func getBlock() *Block {
@@ -208,7 +222,10 @@ func (b *Block) MarshalData(timestampsBlockOffset, valuesBlockOffset uint64) ([]
 		logger.Panicf("BUG: the number of values must match the number of timestamps; got %d vs %d", len(values), len(timestamps))
 	}
 
-	b.valuesData, b.bh.ValuesMarshalType = encodingext.MarshalValues(b.valuesData[:0], values)
+	b.valuesData, b.bh.ValuesMarshalType, b.bh.ColumnsFormat, b.bh.Columns = marshalBlockValues(b.valuesData[:0], values, b.dict)
+	// Unmarshaled values are always in valuesFormatTagged. See UnmarshalData.
+	// Blocks with only numeric samples are marked with valuesFormatNumeric.
+	b.bh.ValuesFormat = getValuesFormat(values)
 	b.bh.ValuesBlockOffset = valuesBlockOffset
 	b.bh.ValuesBlockSize = uint32(len(b.valuesData))
 	b.values = b.values[:0]
@@ -242,29 +259,27 @@ func (b *Block) UnmarshalData(checkValues bool) error {
 		return nil
 	}
 
-	if b.bh.RowsCount <= 0 {
-		return fmt.Errorf("RowsCount must be greater than 0; got %d", b.bh.RowsCount)
-	}
-
-	var err error
-
-	b.timestamps, err = encoding.UnmarshalTimestamps(b.timestamps[:0], b.timestampsData, b.bh.TimestampsMarshalType, b.bh.MinTimestamp, int(b.bh.RowsCount))
-	if err != nil {
+	if err := b.unmarshalTimestamps(); err != nil {
 		return err
 	}
-	if b.bh.PrecisionBits < 64 {
-		// Recover timestamps order after lossy compression.
-		encoding.EnsureNonDecreasingSequence(b.timestamps, b.bh.MinTimestamp, b.bh.MaxTimestamp)
-	}
-	b.timestampsData = b.timestampsData[:0]
 
+	var err error
 	if len(b.valuesData) > 0 {
-		b.values, err = encodingext.UnmarshalValues(b.values[:0], b.valuesData, b.bh.ValuesMarshalType, int(b.bh.RowsCount))
+		if b.bh.ValuesMarshalType == encodingext.MarshalTypeColumnarBytesArray {
+			atomic.AddUint64(&columnarLinesReads, 1)
+			b.values, err = unmarshalColumnarValues(b.values[:0], b.valuesData, &b.bh)
+		} else {
+			b.values, err = encodingext.UnmarshalValuesWithDict(b.values[:0], b.valuesData, b.bh.ValuesMarshalType, int(b.bh.RowsCount), b.dict)
+		}
 		if err != nil {
 			return err
 		}
 		b.valuesData = b.valuesData[:0]
 	}
+	if b.bh.ValuesFormat == valuesFormatLegacy {
+		escapeLegacyValues(b.values)
+		b.bh.ValuesFormat = valuesFormatTagged
+	}
 
 	if checkValues {
 		if len(b.timestamps) != len(b.values) {
@@ -277,6 +292,75 @@ func (b *Block) UnmarshalData(checkValues bool) error {
 	return nil
 }
 
+// UnmarshalFields unmarshals block timestamps and replaces row values with the values for the given log fields.
+//
+// Every row value contains the fields as structured metadata with empty line, so they may be read
+// with UnmarshalLineWithMetadata. Missing fields are skipped. Only the columns for the given fields
+// are read from blocks with log fields split into columns, so lines aren't re-assembled for such blocks.
+//
+// Blocks with numeric samples are unmarshaled as usual, since they have no fields.
+func (b *Block) UnmarshalFields(names []string) error {
+	if len(b.values) > 0 || len(b.valuesData) == 0 || b.IsNumeric() {
+		return b.UnmarshalData(false)
+	}
+	fieldValues := make([][][]byte, len(names))
+	for i, name := range names {
+		var err error
+		fieldValues[i], err = b.AppendFieldValues(nil, name)
+		if err != nil {
+			return fmt.Errorf("cannot read values for field %q: %w", name, err)
+		}
+	}
+	if err := b.unmarshalTimestamps(); err != nil {
+		return err
+	}
+
+	var buf []byte
+	var metadata []Label
+	rowsCount := int(b.bh.RowsCount)
+	ends := make([]int, 0, rowsCount)
+	for row := 0; row < rowsCount; row++ {
+		metadata = metadata[:0]
+		for i, name := range names {
+			if v := fieldValues[i][row]; v != nil {
+				metadata = append(metadata, Label{
+					Name:  []byte(name),
+					Value: v,
+				})
+			}
+		}
+		buf = MarshalLineWithMetadata(buf, nil, metadata)
+		ends = append(ends, len(buf))
+	}
+	b.values = b.values[:0]
+	start := 0
+	for _, end := range ends {
+		b.values = append(b.values, buf[start:end:end])
+		start = end
+	}
+	b.valuesData = b.valuesData[:0]
+	b.bh.ValuesFormat = valuesFormatTagged
+	b.nextIdx = 0
+	return nil
+}
+
+func (b *Block) unmarshalTimestamps() error {
+	if b.bh.RowsCount <= 0 {
+		return fmt.Errorf("RowsCount must be greater than 0; got %d", b.bh.RowsCount)
+	}
+	var err error
+	b.timestamps, err = encoding.UnmarshalTimestamps(b.timestamps[:0], b.timestampsData, b.bh.TimestampsMarshalType, b.bh.MinTimestamp, int(b.bh.RowsCount))
+	if err != nil {
+		return err
+	}
+	if b.bh.PrecisionBits < 64 {
+		// Recover timestamps order after lossy compression.
+		encoding.EnsureNonDecreasingSequence(b.timestamps, b.bh.MinTimestamp, b.bh.MaxTimestamp)
+	}
+	b.timestampsData = b.timestampsData[:0]
+	return nil
+}
+
 // AppendRowsWithTimeRangeFilter filters samples from b according to tr and appends them to dst*.
 //
 // It is expected that UnmarshalData has been already called on b.
@@ -311,16 +395,58 @@ func (b *Block) filterTimestamps(tr TimeRange) ([]int64, [][]byte) {
 	return timestamps[i:j], b.values[i:j]
 }
 
+// IsNumeric returns true if all the rows in b contain numeric samples marshaled with MarshalNumericValue.
+//
+// It relies only on the block header, so it may be called without reading b data.
+func (b *Block) IsNumeric() bool {
+	return b.bh.ValuesFormat == valuesFormatNumeric
+}
+
+// mustRemoveDict re-marshals b values without dictionary, since the portable format
+// has no room for dictionaries.
+func (b *Block) mustRemoveDict() {
+	dict := b.dict
+	b.dict = nil
+	b.dictID = 0
+	if len(b.values) > 0 || !hasValuesDict(b) {
+		return
+	}
+	values, err := encodingext.UnmarshalValuesWithDict(nil, b.valuesData, b.bh.ValuesMarshalType, int(b.bh.RowsCount), dict)
+	if err != nil {
+		logger.Panicf("FATAL: cannot unmarshal values for TSID %+v: %s", &b.bh.TSID, err)
+	}
+	b.valuesData, b.bh.ValuesMarshalType = encodingext.MarshalValues(b.valuesData[:0], values)
+	b.bh.ValuesBlockSize = uint32(len(b.valuesData))
+}
+
+// mustRemoveColumns re-marshals b values split into columns as plain lines, since the portable
+// format has no room for columns layout.
+func (b *Block) mustRemoveColumns() {
+	if len(b.values) > 0 || b.bh.ValuesMarshalType != encodingext.MarshalTypeColumnarBytesArray {
+		return
+	}
+	values, err := unmarshalColumnarValues(nil, b.valuesData, &b.bh)
+	if err != nil {
+		logger.Panicf("FATAL: cannot unmarshal values for TSID %+v: %s", &b.bh.TSID, err)
+	}
+	b.valuesData, b.bh.ValuesMarshalType = encodingext.MarshalValues(b.valuesData[:0], values)
+	b.bh.ValuesBlockSize = uint32(len(b.valuesData))
+	b.bh.ColumnsFormat = columnsFormatNone
+	b.bh.Columns = nil
+}
+
 // MarshalPortable marshals b to dst, so it could be portably migrated to other VictoriaMetrics instance.
 //
 // The marshaled value must be unmarshaled with UnmarshalPortable function.
 func (b *Block) MarshalPortable(dst []byte) []byte {
 	b.MarshalData(0, 0)
+	b.mustRemoveDict()
+	b.mustRemoveColumns()
 
 	dst = encoding.MarshalVarInt64(dst, b.bh.MinTimestamp)
 	dst = encoding.MarshalVarUint64(dst, uint64(b.bh.RowsCount))
 	dst = append(dst, byte(b.bh.TimestampsMarshalType))
-	dst = append(dst, byte(b.bh.ValuesMarshalType))
+	dst = append(dst, marshalValuesTypeAndFormat(b.bh.ValuesMarshalType, b.bh.ValuesFormat))
 	dst = encoding.MarshalBytes(dst, b.timestampsData)
 	dst = encoding.MarshalBytes(dst, b.valuesData)
 
@@ -355,7 +481,7 @@ func (b *Block) UnmarshalPortable(src []byte) ([]byte, error) {
 	if len(src) < 1 {
 		return src, fmt.Errorf("cannot unmarshal marshalType for values from %d bytes; need at least %d bytes", len(src), 1)
 	}
-	b.bh.ValuesMarshalType = encodingext.MarshalType(src[0])
+	b.bh.ValuesMarshalType, b.bh.ValuesFormat = unmarshalValuesTypeAndFormat(src[0])
 	src = src[1:]
 	b.bh.PrecisionBits = 64
 
@@ -0,0 +1,817 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/encodingext"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/valyala/fastjson"
+)
+
+// columnsFormat is the format of log lines split into columns.
+type columnsFormat uint8
+
+const (
+	columnsFormatNone   = columnsFormat(0)
+	columnsFormatJSON   = columnsFormat(1)
+	columnsFormatLogfmt = columnsFormat(2)
+)
+
+// maxColumnsPerBlock is the maximum number of field columns per block.
+//
+// Lines with fields exceeding the limit are stored as is.
+const maxColumnsPerBlock = 128
+
+// maxColumnNameLen is the maximum length of field name, which may be stored in a separate column.
+const maxColumnNameLen = 256
+
+var columnarLogFields = false
+
+// SetColumnarLogFields enables splitting JSON and logfmt log lines into per-field columns inside blocks.
+//
+// Lines are re-assembled on read, so this mode is transparent to queries, while the values
+// for a single field may be read with Block.AppendFieldValues without decompressing other fields.
+//
+// Blocks with columns remain readable when the mode is disabled.
+//
+// This function may be called only before Storage initialization.
+func SetColumnarLogFields(enabled bool) {
+	columnarLogFields = enabled
+}
+
+// columnHeader is a header for a single column in values block marshaled
+// with encodingext.MarshalTypeColumnarBytesArray.
+type columnHeader struct {
+	// Name is the field name for the column.
+	//
+	// It is empty for the column with lines, which couldn't be split into columns.
+	Name string
+
+	// MarshalType is the marshal type used for marshaling column values.
+	MarshalType encoding.MarshalType
+
+	// Size is the size in bytes of marshaled column values.
+	Size uint32
+}
+
+func (bh *blockHeader) marshalColumns(dst []byte) []byte {
+	dst = append(dst, byte(bh.ColumnsFormat))
+	dst = encoding.MarshalVarUint64(dst, uint64(len(bh.Columns)))
+	for i := range bh.Columns {
+		ch := &bh.Columns[i]
+		dst = encoding.MarshalBytes(dst, bytesutil.ToUnsafeBytes(ch.Name))
+		dst = append(dst, byte(ch.MarshalType))
+		dst = encoding.MarshalUint32(dst, ch.Size)
+	}
+	return dst
+}
+
+func (bh *blockHeader) unmarshalColumns(src []byte) ([]byte, error) {
+	if len(src) < 1 {
+		return src, fmt.Errorf("cannot unmarshal ColumnsFormat from empty data")
+	}
+	bh.ColumnsFormat = columnsFormat(src[0])
+	src = src[1:]
+	tail, columnsCount, err := encoding.UnmarshalVarUint64(src)
+	if err != nil {
+		return src, fmt.Errorf("cannot unmarshal columns count: %w", err)
+	}
+	src = tail
+	if columnsCount == 0 || columnsCount > maxColumnsPerBlock+1 {
+		return src, fmt.Errorf("unexpected number of columns; got %d; want [1..%d]", columnsCount, maxColumnsPerBlock+1)
+	}
+	columns := make([]columnHeader, columnsCount)
+	for i := range columns {
+		ch := &columns[i]
+		tail, name, err := encoding.UnmarshalBytes(src)
+		if err != nil {
+			return src, fmt.Errorf("cannot unmarshal name for column #%d: %w", i, err)
+		}
+		ch.Name = string(name)
+		src = tail
+		if len(src) < 5 {
+			return src, fmt.Errorf("too short data for column #%d; got %d bytes; want at least 5 bytes", i, len(src))
+		}
+		ch.MarshalType = encoding.MarshalType(src[0])
+		ch.Size = encoding.UnmarshalUint32(src[1:])
+		src = src[5:]
+	}
+	bh.Columns = columns
+	return src, nil
+}
+
+func (bh *blockHeader) validateColumns() error {
+	if bh.ColumnsFormat != columnsFormatJSON && bh.ColumnsFormat != columnsFormatLogfmt {
+		return fmt.Errorf("unsupported ColumnsFormat=%d", bh.ColumnsFormat)
+	}
+	if len(bh.Columns) == 0 {
+		return fmt.Errorf("Columns cannot be empty")
+	}
+	if bh.Columns[0].Name != "" {
+		return fmt.Errorf("the first column must have empty name; got %q", bh.Columns[0].Name)
+	}
+	size := uint64(0)
+	for i := range bh.Columns {
+		ch := &bh.Columns[i]
+		if i > 0 && (len(ch.Name) == 0 || len(ch.Name) > maxColumnNameLen) {
+			return fmt.Errorf("invalid name length for column #%d; got %d; want [1..%d]", i, len(ch.Name), maxColumnNameLen)
+		}
+		if ch.MarshalType != encodingext.MarshalTypeZSTDBytesArray {
+			return fmt.Errorf("unsupported MarshalType for column %q; got %d; want %d", ch.Name, ch.MarshalType, encodingext.MarshalTypeZSTDBytesArray)
+		}
+		size += uint64(ch.Size)
+	}
+	if size != uint64(bh.ValuesBlockSize) {
+		return fmt.Errorf("the total size of columns must match ValuesBlockSize; got %d vs %d", size, bh.ValuesBlockSize)
+	}
+	return nil
+}
+
+// marshalBlockValues marshals values and appends the result to dst.
+//
+// Values are split into per-field columns if columnarLogFields is set and the majority
+// of values may be split into columns. Otherwise values are marshaled with the given dictionary d.
+func marshalBlockValues(dst []byte, values [][]byte, d *encodingext.Dict) ([]byte, encodingext.MarshalType, columnsFormat, []columnHeader) {
+	if columnarLogFields {
+		cb := getColumnsBuilder()
+		cb.init(detectColumnsFormat(values))
+		columnarRows := 0
+		for _, v := range values {
+			if cb.addRow(v) {
+				columnarRows++
+			}
+		}
+		if len(cb.names) > 0 && 2*columnarRows >= len(values) {
+			var chs []columnHeader
+			format := cb.format
+			dst, chs = cb.marshal(dst)
+			putColumnsBuilder(cb)
+			return dst, encodingext.MarshalTypeColumnarBytesArray, format, chs
+		}
+		putColumnsBuilder(cb)
+	}
+	dst, mt := encodingext.MarshalValuesWithDict(dst, values, d)
+	return dst, mt, columnsFormatNone, nil
+}
+
+// unmarshalColumnarValues re-assembles lines from columns at src described by bh and appends them to dst.
+func unmarshalColumnarValues(dst [][]byte, src []byte, bh *blockHeader) ([][]byte, error) {
+	rowsCount := int(bh.RowsCount)
+	columns := make([][][]byte, len(bh.Columns))
+	for i := range bh.Columns {
+		ch := &bh.Columns[i]
+		var err error
+		columns[i], src, err = unmarshalColumn(src, ch, rowsCount)
+		if err != nil {
+			return dst, err
+		}
+	}
+
+	var buf []byte
+	ends := make([]int, 0, rowsCount)
+	for row := 0; row < rowsCount; row++ {
+		if raw := columns[0][row]; len(raw) > 0 {
+			buf = append(buf, raw[1:]...)
+		} else {
+			buf = appendLogLineStart(buf, bh.ColumnsFormat)
+			fieldsCount := 0
+			for i := 1; i < len(columns); i++ {
+				v := columns[i][row]
+				if len(v) == 0 {
+					// The field is missing in the row.
+					continue
+				}
+				buf = appendLogLineField(buf, bh.ColumnsFormat, fieldsCount, bh.Columns[i].Name, v[1:])
+				fieldsCount++
+			}
+			buf = appendLogLineEnd(buf, bh.ColumnsFormat)
+		}
+		ends = append(ends, len(buf))
+	}
+	start := 0
+	for _, end := range ends {
+		dst = append(dst, buf[start:end:end])
+		start = end
+	}
+	return dst, nil
+}
+
+// unmarshalColumn unmarshals rowsCount values for the column ch from the beginning of src.
+//
+// It returns the unmarshaled values and the remaining tail of src.
+func unmarshalColumn(src []byte, ch *columnHeader, rowsCount int) ([][]byte, []byte, error) {
+	if uint64(len(src)) < uint64(ch.Size) {
+		return nil, src, fmt.Errorf("too short data for column %q; got %d bytes; want at least %d bytes", ch.Name, len(src), ch.Size)
+	}
+	values, err := encodingext.UnmarshalValues(nil, src[:ch.Size], ch.MarshalType, rowsCount)
+	if err != nil {
+		return nil, src, fmt.Errorf("cannot unmarshal column %q: %w", ch.Name, err)
+	}
+	return values, src[ch.Size:], nil
+}
+
+// AppendFieldValues appends values for the field with the given name from all the rows in b to dst
+// and returns the result.
+//
+// nil is appended for rows without the field. JSON strings and quoted logfmt values are unquoted.
+//
+// Only the column for the given field is unmarshaled for blocks with log fields split into columns
+// (see SetColumnarLogFields), while all the lines are unmarshaled and parsed for other blocks.
+func (b *Block) AppendFieldValues(dst [][]byte, name string) ([][]byte, error) {
+	if len(b.values) > 0 {
+		// The block is already unmarshaled.
+		return appendLinesFieldValues(dst, b.values, b.bh.ValuesFormat, name), nil
+	}
+	if b.bh.RowsCount <= 0 {
+		return dst, fmt.Errorf("RowsCount must be greater than 0; got %d", b.bh.RowsCount)
+	}
+	rowsCount := int(b.bh.RowsCount)
+	if b.bh.ValuesMarshalType != encodingext.MarshalTypeColumnarBytesArray {
+		lines, err := encodingext.UnmarshalValuesWithDict(nil, b.valuesData, b.bh.ValuesMarshalType, rowsCount, b.dict)
+		if err != nil {
+			return dst, err
+		}
+		return appendLinesFieldValues(dst, lines, b.bh.ValuesFormat, name), nil
+	}
+
+	// Unmarshal only the column with lines, which couldn't be split into columns, and the column for the field.
+	src := b.valuesData
+	raw, tail, err := unmarshalColumn(src, &b.bh.Columns[0], rowsCount)
+	if err != nil {
+		return dst, err
+	}
+	src = tail
+	var column [][]byte
+	for i := 1; i < len(b.bh.Columns); i++ {
+		ch := &b.bh.Columns[i]
+		if ch.Name == name {
+			column, _, err = unmarshalColumn(src, ch, rowsCount)
+			if err != nil {
+				return dst, err
+			}
+			break
+		}
+		if uint64(len(src)) < uint64(ch.Size) {
+			return dst, fmt.Errorf("too short data for column %q; got %d bytes; want at least %d bytes", ch.Name, len(src), ch.Size)
+		}
+		src = src[ch.Size:]
+	}
+	atomic.AddUint64(&columnarFieldReads, 1)
+	var fields []logField
+	for row := 0; row < rowsCount; row++ {
+		if v := raw[row]; len(v) > 0 {
+			var value []byte
+			if line := getRowLine(v[1:], b.bh.ValuesFormat); line != nil {
+				value, fields = getLineFieldValue(fields[:0], line, name)
+			}
+			dst = append(dst, value)
+			continue
+		}
+		if column == nil || len(column[row]) == 0 {
+			dst = append(dst, nil)
+			continue
+		}
+		dst = append(dst, unquoteLogFieldValue(b.bh.ColumnsFormat, column[row][1:]))
+	}
+	return dst, nil
+}
+
+func appendLinesFieldValues(dst, values [][]byte, format valuesFormat, name string) [][]byte {
+	var fields []logField
+	for _, v := range values {
+		var value []byte
+		if line := getRowLine(v, format); line != nil {
+			value, fields = getLineFieldValue(fields[:0], line, name)
+		}
+		dst = append(dst, value)
+	}
+	return dst
+}
+
+// getRowLine returns log line without structured metadata from the row value v in the given format.
+//
+// nil is returned for numeric samples and for invalid values, since they have no fields.
+func getRowLine(v []byte, format valuesFormat) []byte {
+	if format == valuesFormatLegacy {
+		return v
+	}
+	if _, ok := UnmarshalNumericValue(v); ok {
+		return nil
+	}
+	line, _, err := UnmarshalLineWithMetadata(nil, v)
+	if err != nil {
+		return nil
+	}
+	return line
+}
+
+// GetLineFieldValue returns unquoted value for the top-level field with the given name from JSON or logfmt log line.
+//
+// nil is returned if the line doesn't contain the field.
+func GetLineFieldValue(line []byte, name string) []byte {
+	value, _ := getLineFieldValue(nil, line, name)
+	return value
+}
+
+var (
+	columnarLinesReads uint64
+	columnarFieldReads uint64
+)
+
+// GetColumnarBlockReads returns the number of reads for blocks with log fields split into columns
+// since the process start.
+//
+// linesReads is the number of reads with lines re-assembled from all the columns, while fieldReads
+// is the number of reads for a single field column via Block.AppendFieldValues.
+func GetColumnarBlockReads() (linesReads, fieldReads uint64) {
+	return atomic.LoadUint64(&columnarLinesReads), atomic.LoadUint64(&columnarFieldReads)
+}
+
+// getLineFieldValue returns unquoted value for the field with the given name from the line.
+//
+// nil is returned if the line doesn't contain the field. fields is used as a temporary buffer.
+func getLineFieldValue(fields []logField, line []byte, name string) ([]byte, []logField) {
+	format := detectLineFormat(line)
+	fields, ok := parseLogFields(fields, format, line)
+	if !ok {
+		if format == columnsFormatJSON {
+			// Slow path - the line isn't a compact JSON object.
+			return getJSONFieldValue(line, name), fields
+		}
+		return nil, fields
+	}
+	for _, f := range fields {
+		if string(f.name) == name {
+			return unquoteLogFieldValue(format, f.value), fields
+		}
+	}
+	return nil, fields
+}
+
+// unquoteLogFieldValue returns unquoted copy of the value for the field in the given format.
+//
+// The returned value is non-nil even if it is empty, so it could be distinguished from missing field.
+func unquoteLogFieldValue(format columnsFormat, value []byte) []byte {
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return append([]byte{}, value...)
+	}
+	if bytes.IndexByte(value, '\\') < 0 {
+		// Fast path - the value has no escape sequences.
+		return append([]byte{}, value[1:len(value)-1]...)
+	}
+	switch format {
+	case columnsFormatJSON:
+		p := jsonParserPool.Get()
+		v, err := p.ParseBytes(value)
+		if err == nil {
+			if sb, err := v.StringBytes(); err == nil {
+				s := append([]byte{}, sb...)
+				jsonParserPool.Put(p)
+				return s
+			}
+		}
+		jsonParserPool.Put(p)
+	case columnsFormatLogfmt:
+		if s, err := strconv.Unquote(string(value)); err == nil {
+			return append([]byte{}, s...)
+		}
+	}
+	// Return the value as is if it cannot be unquoted.
+	return append([]byte{}, value...)
+}
+
+// getJSONFieldValue returns value for the top-level field with the given name from arbitrary JSON object in line.
+func getJSONFieldValue(line []byte, name string) []byte {
+	p := jsonParserPool.Get()
+	defer jsonParserPool.Put(p)
+
+	v, err := p.ParseBytes(line)
+	if err != nil {
+		return nil
+	}
+	v = v.Get(name)
+	if v == nil {
+		return nil
+	}
+	if v.Type() == fastjson.TypeString {
+		return append([]byte{}, v.GetStringBytes()...)
+	}
+	return v.MarshalTo([]byte{})
+}
+
+var jsonParserPool fastjson.ParserPool
+
+// logField is a single top-level field in a structured log line.
+type logField struct {
+	name  []byte
+	value []byte
+}
+
+// detectColumnsFormat detects columns format for the given values.
+func detectColumnsFormat(values [][]byte) columnsFormat {
+	for _, v := range values {
+		if len(v) > 0 {
+			return detectLineFormat(v)
+		}
+	}
+	return columnsFormatLogfmt
+}
+
+func detectLineFormat(line []byte) columnsFormat {
+	if len(line) > 0 && line[0] == '{' {
+		return columnsFormatJSON
+	}
+	return columnsFormatLogfmt
+}
+
+// parseLogFields appends fields from the line in the given format to dst.
+//
+// Field values are returned as is, i.e. JSON strings and quoted logfmt values aren't unquoted.
+// false is returned if the line cannot be parsed.
+func parseLogFields(dst []logField, format columnsFormat, line []byte) ([]logField, bool) {
+	switch format {
+	case columnsFormatJSON:
+		return parseJSONFields(dst, line)
+	case columnsFormatLogfmt:
+		return parseLogfmtFields(dst, line)
+	default:
+		return dst, false
+	}
+}
+
+// parseJSONFields appends top-level fields from compact JSON object in s to dst.
+//
+// Nested objects and arrays are returned as field values.
+func parseJSONFields(dst []logField, s []byte) ([]logField, bool) {
+	if len(s) < 2 || s[0] != '{' || s[len(s)-1] != '}' {
+		return dst, false
+	}
+	s = s[1 : len(s)-1]
+	for len(s) > 0 {
+		if s[0] != '"' {
+			return dst, false
+		}
+		n := bytes.IndexByte(s[1:], '"')
+		if n < 0 {
+			return dst, false
+		}
+		name := s[1 : n+1]
+		if bytes.IndexByte(name, '\\') >= 0 {
+			// Field names with escape sequences aren't supported.
+			return dst, false
+		}
+		s = s[n+2:]
+		if len(s) == 0 || s[0] != ':' {
+			return dst, false
+		}
+		s = s[1:]
+		n = scanJSONValue(s)
+		if n <= 0 {
+			return dst, false
+		}
+		dst = append(dst, logField{
+			name:  name,
+			value: s[:n],
+		})
+		s = s[n:]
+		if len(s) > 0 {
+			if s[0] != ',' || len(s) == 1 {
+				return dst, false
+			}
+			s = s[1:]
+		}
+	}
+	return dst, true
+}
+
+// scanJSONValue returns the length of JSON value at the beginning of s.
+//
+// -1 is returned if the value cannot be found.
+func scanJSONValue(s []byte) int {
+	if len(s) == 0 {
+		return -1
+	}
+	switch s[0] {
+	case '"':
+		return scanJSONString(s)
+	case '{', '[':
+		depth := 0
+		for i := 0; i < len(s); i++ {
+			switch s[i] {
+			case '"':
+				n := scanJSONString(s[i:])
+				if n < 0 {
+					return -1
+				}
+				i += n - 1
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					return i + 1
+				}
+			}
+		}
+		return -1
+	default:
+		// Number, true, false or null.
+		n := 0
+		for n < len(s) && s[n] != ',' {
+			switch s[n] {
+			case ' ', '\t', '\r', '\n', '"', '{', '}', '[', ']', ':':
+				return -1
+			}
+			n++
+		}
+		return n
+	}
+}
+
+// scanJSONString returns the length of JSON string at the beginning of s.
+//
+// -1 is returned if the string isn't terminated.
+func scanJSONString(s []byte) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// parseLogfmtFields appends fields from logfmt line in s to dst.
+//
+// Fields must be delimited by a single space.
+func parseLogfmtFields(dst []logField, s []byte) ([]logField, bool) {
+	for len(s) > 0 {
+		n := bytes.IndexByte(s, '=')
+		if n <= 0 {
+			return dst, false
+		}
+		name := s[:n]
+		if bytes.IndexAny(name, " \"") >= 0 {
+			return dst, false
+		}
+		s = s[n+1:]
+		if len(s) > 0 && s[0] == '"' {
+			n = scanJSONString(s)
+			if n < 0 {
+				return dst, false
+			}
+		} else {
+			n = bytes.IndexByte(s, ' ')
+			if n < 0 {
+				n = len(s)
+			}
+		}
+		dst = append(dst, logField{
+			name:  name,
+			value: s[:n],
+		})
+		s = s[n:]
+		if len(s) > 0 {
+			if s[0] != ' ' || len(s) == 1 {
+				return dst, false
+			}
+			s = s[1:]
+		}
+	}
+	return dst, true
+}
+
+func appendLogLineStart(dst []byte, format columnsFormat) []byte {
+	if format == columnsFormatJSON {
+		dst = append(dst, '{')
+	}
+	return dst
+}
+
+func appendLogLineField(dst []byte, format columnsFormat, fieldIdx int, name string, value []byte) []byte {
+	switch format {
+	case columnsFormatJSON:
+		if fieldIdx > 0 {
+			dst = append(dst, ',')
+		}
+		dst = append(dst, '"')
+		dst = append(dst, name...)
+		dst = append(dst, '"', ':')
+	case columnsFormatLogfmt:
+		if fieldIdx > 0 {
+			dst = append(dst, ' ')
+		}
+		dst = append(dst, name...)
+		dst = append(dst, '=')
+	}
+	return append(dst, value...)
+}
+
+func appendLogLineEnd(dst []byte, format columnsFormat) []byte {
+	if format == columnsFormatJSON {
+		dst = append(dst, '}')
+	}
+	return dst
+}
+
+// itemRange is a range for a single column item in columnsBuilder.buf.
+//
+// Empty range means missing item.
+type itemRange struct {
+	start int
+	end   int
+}
+
+// columnsBuilder splits log lines into per-field columns.
+type columnsBuilder struct {
+	format columnsFormat
+
+	// names contains field names for columns[1:].
+	names    []string
+	nameIdxs map[string]int
+
+	// columns contains item ranges in buf for each row.
+	//
+	// columns[0] contains lines, which couldn't be split into columns.
+	// Non-empty items in buf start with a marker byte, so they could be distinguished from missing items.
+	columns [][]itemRange
+	buf     []byte
+
+	// temporary buffers used in addRow.
+	fields []logField
+	idxs   []int
+	line   []byte
+	items  [][]byte
+}
+
+func (cb *columnsBuilder) reset() {
+	cb.format = columnsFormatNone
+	cb.names = cb.names[:0]
+	for k := range cb.nameIdxs {
+		delete(cb.nameIdxs, k)
+	}
+	for i := range cb.columns {
+		cb.columns[i] = cb.columns[i][:0]
+	}
+	cb.columns = cb.columns[:0]
+	cb.buf = cb.buf[:0]
+	cb.fields = cb.fields[:0]
+	cb.idxs = cb.idxs[:0]
+	cb.line = cb.line[:0]
+	cb.items = cb.items[:0]
+}
+
+func (cb *columnsBuilder) init(format columnsFormat) {
+	cb.reset()
+	cb.format = format
+	if cb.nameIdxs == nil {
+		cb.nameIdxs = make(map[string]int)
+	}
+	cb.addColumn()
+}
+
+func (cb *columnsBuilder) addColumn() {
+	if cap(cb.columns) > len(cb.columns) {
+		cb.columns = cb.columns[:len(cb.columns)+1]
+	} else {
+		cb.columns = append(cb.columns, nil)
+	}
+	rowsCount := 0
+	if len(cb.columns) > 1 {
+		rowsCount = len(cb.columns[0])
+	}
+	column := cb.columns[len(cb.columns)-1][:0]
+	for i := 0; i < rowsCount; i++ {
+		column = append(column, itemRange{})
+	}
+	cb.columns[len(cb.columns)-1] = column
+}
+
+// addRow adds the given line to cb.
+//
+// It returns false if the line couldn't be split into columns. Such a line is stored as is.
+func (cb *columnsBuilder) addRow(line []byte) bool {
+	if !cb.addFields(line) {
+		cb.columns[0] = append(cb.columns[0], cb.addItem(line))
+		for i := 1; i < len(cb.columns); i++ {
+			cb.columns[i] = append(cb.columns[i], itemRange{})
+		}
+		return false
+	}
+	cb.columns[0] = append(cb.columns[0], itemRange{})
+	return true
+}
+
+func (cb *columnsBuilder) addFields(line []byte) bool {
+	var ok bool
+	cb.fields, ok = parseLogFields(cb.fields[:0], cb.format, line)
+	if !ok {
+		return false
+	}
+
+	// Verify the line may be re-assembled from fields.
+	cb.line = appendLogLineStart(cb.line[:0], cb.format)
+	for i, f := range cb.fields {
+		cb.line = appendLogLineField(cb.line, cb.format, i, bytesutil.ToUnsafeString(f.name), f.value)
+	}
+	cb.line = appendLogLineEnd(cb.line, cb.format)
+	if !bytes.Equal(cb.line, line) {
+		return false
+	}
+
+	// Map fields to columns. Fields must be ordered by column indexes, since lines are re-assembled
+	// by visiting columns in order.
+	cb.idxs = cb.idxs[:0]
+	prevIdx := 0
+	newColumns := 0
+	for i, f := range cb.fields {
+		idx, ok := cb.nameIdxs[string(f.name)]
+		if !ok {
+			if len(f.name) == 0 || len(f.name) > maxColumnNameLen || len(cb.names)+newColumns >= maxColumnsPerBlock {
+				return false
+			}
+			for _, prev := range cb.fields[:i] {
+				if bytes.Equal(prev.name, f.name) {
+					// Duplicate field.
+					return false
+				}
+			}
+			newColumns++
+			idx = len(cb.names) + newColumns
+		}
+		if idx <= prevIdx {
+			return false
+		}
+		prevIdx = idx
+		cb.idxs = append(cb.idxs, idx)
+	}
+
+	// Register new columns and add field values to columns.
+	for i := len(cb.fields) - newColumns; i < len(cb.fields); i++ {
+		name := string(cb.fields[i].name)
+		cb.names = append(cb.names, name)
+		cb.nameIdxs[name] = len(cb.names)
+		cb.addColumn()
+	}
+	n := 0
+	for i := 1; i < len(cb.columns); i++ {
+		r := itemRange{}
+		if n < len(cb.idxs) && cb.idxs[n] == i {
+			r = cb.addItem(cb.fields[n].value)
+			n++
+		}
+		cb.columns[i] = append(cb.columns[i], r)
+	}
+	return true
+}
+
+func (cb *columnsBuilder) addItem(data []byte) itemRange {
+	start := len(cb.buf)
+	cb.buf = append(cb.buf, 1)
+	cb.buf = append(cb.buf, data...)
+	return itemRange{
+		start: start,
+		end:   len(cb.buf),
+	}
+}
+
+// marshal appends marshaled columns to dst and returns the result together with column headers.
+func (cb *columnsBuilder) marshal(dst []byte) ([]byte, []columnHeader) {
+	chs := make([]columnHeader, len(cb.columns))
+	for i, column := range cb.columns {
+		cb.items = cb.items[:0]
+		for _, r := range column {
+			cb.items = append(cb.items, cb.buf[r.start:r.end])
+		}
+		dstLen := len(dst)
+		ch := &chs[i]
+		if i > 0 {
+			ch.Name = cb.names[i-1]
+		}
+		dst, ch.MarshalType = encodingext.MarshalValues(dst, cb.items)
+		ch.Size = uint32(len(dst) - dstLen)
+	}
+	return dst, chs
+}
+
+func getColumnsBuilder() *columnsBuilder {
+	v := columnsBuilderPool.Get()
+	if v == nil {
+		return &columnsBuilder{}
+	}
+	return v.(*columnsBuilder)
+}
+
+func putColumnsBuilder(cb *columnsBuilder) {
+	cb.reset()
+	columnsBuilderPool.Put(cb)
+}
+
+var columnsBuilderPool sync.Pool
@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/encodingext"
+)
+
+func TestBlockColumnarLogFields(t *testing.T) {
+	columnarLogFields = true
+	defer func() {
+		columnarLogFields = false
+	}()
+
+	f := func(lines []string, expectedFormat columnsFormat, fieldName string, fieldValues []string) {
+		t.Helper()
+		values := make([][]byte, len(lines))
+		timestamps := make([]int64, len(lines))
+		for i, line := range lines {
+			values[i] = []byte(line)
+			timestamps[i] = int64(i) * 1000
+		}
+		var b Block
+		b.Init(&TSID{MetricID: 1}, timestamps, values, 64)
+		b.MarshalData(0, 0)
+		if expectedFormat == columnsFormatNone {
+			if b.bh.ValuesMarshalType == encodingext.MarshalTypeColumnarBytesArray {
+				t.Fatalf("unexpected columnar block for lines %q", lines)
+			}
+		} else {
+			if b.bh.ValuesMarshalType != encodingext.MarshalTypeColumnarBytesArray {
+				t.Fatalf("unexpected ValuesMarshalType; got %d; want %d", b.bh.ValuesMarshalType, encodingext.MarshalTypeColumnarBytesArray)
+			}
+			if b.bh.ColumnsFormat != expectedFormat {
+				t.Fatalf("unexpected ColumnsFormat; got %d; want %d", b.bh.ColumnsFormat, expectedFormat)
+			}
+		}
+
+		// Pass the block through marshaling, so the block header extension is verified.
+		data := MarshalBlock(nil, &b)
+		var b2 Block
+		tail, err := UnmarshalBlock(&b2, data)
+		if err != nil {
+			t.Fatalf("cannot unmarshal block: %s", err)
+		}
+		if len(tail) > 0 {
+			t.Fatalf("unexpected non-empty tail after unmarshaling block: %X", tail)
+		}
+		if !reflect.DeepEqual(b2.bh, b.bh) {
+			t.Fatalf("unexpected block header\ngot\n%+v\nwant\n%+v", &b2.bh, &b.bh)
+		}
+
+		expectedFieldValues := make([][]byte, len(fieldValues))
+		for i, v := range fieldValues {
+			if v != "<missing>" {
+				expectedFieldValues[i] = []byte(v)
+			}
+		}
+		vs, err := b2.AppendFieldValues(nil, fieldName)
+		if err != nil {
+			t.Fatalf("cannot read values for field %q: %s", fieldName, err)
+		}
+		if !reflect.DeepEqual(vs, expectedFieldValues) {
+			t.Fatalf("unexpected values for field %q\ngot\n%q\nwant\n%q", fieldName, vs, expectedFieldValues)
+		}
+
+		// Fields are passed as structured metadata, while empty fields are skipped.
+		var bf Block
+		if _, err := UnmarshalBlock(&bf, data); err != nil {
+			t.Fatalf("cannot unmarshal block: %s", err)
+		}
+		if err := bf.UnmarshalFields([]string{fieldName}); err != nil {
+			t.Fatalf("cannot unmarshal fields: %s", err)
+		}
+		if !reflect.DeepEqual(bf.timestamps, timestamps) {
+			t.Fatalf("unexpected timestamps\ngot\n%d\nwant\n%d", bf.timestamps, timestamps)
+		}
+		for i, v := range bf.values {
+			line, metadata, err := UnmarshalLineWithMetadata(nil, v)
+			if err != nil {
+				t.Fatalf("cannot unmarshal fields for row #%d: %s", i, err)
+			}
+			if len(line) > 0 {
+				t.Fatalf("unexpected non-empty line for row #%d: %q", i, line)
+			}
+			var value []byte
+			if len(metadata) > 0 {
+				value = metadata[0].Value
+			}
+			if string(value) != string(expectedFieldValues[i]) {
+				t.Fatalf("unexpected value for field %q in row #%d; got %q; want %q", fieldName, i, value, expectedFieldValues[i])
+			}
+		}
+
+		if err := b2.UnmarshalData(true); err != nil {
+			t.Fatalf("cannot unmarshal block data: %s", err)
+		}
+		if !equalLines(b2.values, values) {
+			t.Fatalf("unexpected lines\ngot\n%q\nwant\n%q", b2.values, values)
+		}
+		vs, err = b2.AppendFieldValues(nil, fieldName)
+		if err != nil {
+			t.Fatalf("cannot read values for field %q from unmarshaled block: %s", fieldName, err)
+		}
+		if !reflect.DeepEqual(vs, expectedFieldValues) {
+			t.Fatalf("unexpected values for field %q from unmarshaled block\ngot\n%q\nwant\n%q", fieldName, vs, expectedFieldValues)
+		}
+
+		// Verify that the portable format doesn't contain columns.
+		b2.MarshalData(0, 0)
+		var b3 Block
+		if _, err := b3.UnmarshalPortable(b2.MarshalPortable(nil)); err != nil {
+			t.Fatalf("cannot unmarshal portable block: %s", err)
+		}
+		if !equalLines(b3.values, values) {
+			t.Fatalf("unexpected lines in portable block\ngot\n%q\nwant\n%q", b3.values, values)
+		}
+	}
+
+	// JSON lines
+	f([]string{
+		`{"level":"info","msg":"foo bar","duration":12.5}`,
+		`{"level":"error","msg":"escaped \"quotes\"","error":{"code":1,"text":"a}b"}}`,
+		`{"level":"warn","duration":3,"tags":["x","y"]}`,
+		`{"msg":"out of order","level":"debug"}`,
+		`{"level": "info", "msg": "spaces"}`,
+		`level=info msg=logfmt`,
+		`{}`,
+	}, columnsFormatJSON, "msg", []string{
+		`foo bar`,
+		`escaped "quotes"`,
+		`<missing>`,
+		`out of order`,
+		`spaces`,
+		`logfmt`,
+		`<missing>`,
+	})
+
+	// logfmt lines
+	f([]string{
+		`level=info msg="foo bar" duration=12ms`,
+		`level=error msg="escaped \"quotes\"" error=`,
+		`level=warn duration=3s`,
+		`msg=reordered level=debug`,
+		`level=info  msg=double_space`,
+		`not a logfmt line`,
+		``,
+	}, columnsFormatLogfmt, "error", []string{
+		`<missing>`,
+		``,
+		`<missing>`,
+		`<missing>`,
+		`<missing>`,
+		`<missing>`,
+		`<missing>`,
+	})
+
+	// Unstructured lines are stored as is.
+	f([]string{
+		`foo bar`,
+		`baz`,
+		`level=info msg=foo`,
+	}, columnsFormatNone, "level", []string{
+		`<missing>`,
+		`<missing>`,
+		`info`,
+	})
+}
+
+func equalLines(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if string(a[i]) != string(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestParseJSONFields(t *testing.T) {
+	f := func(s string, resultExpected []string) {
+		t.Helper()
+		fields, ok := parseJSONFields(nil, []byte(s))
+		if resultExpected == nil {
+			if ok {
+				t.Fatalf("expecting error when parsing %q", s)
+			}
+			return
+		}
+		if !ok {
+			t.Fatalf("cannot parse %q", s)
+		}
+		var result []string
+		for _, f := range fields {
+			result = append(result, string(f.name), string(f.value))
+		}
+		if !reflect.DeepEqual(result, resultExpected) {
+			t.Fatalf("unexpected fields for %q\ngot\n%q\nwant\n%q", s, result, resultExpected)
+		}
+	}
+	f(`{"a":1}`, []string{"a", "1"})
+	f(`{"a":"b,c","d":[1,{"e":"]"}],"f":null}`, []string{"a", `"b,c"`, "d", `[1,{"e":"]"}]`, "f", "null"})
+	f(`{"a":"x\"y"}`, []string{"a", `"x\"y"`})
+	f(``, nil)
+	f(`{"a":}`, nil)
+	f(`{"a":1,}`, nil)
+	f(`{"a" :1}`, nil)
+	f(`{"a\"b":1}`, nil)
+	f(`{"a":"unterminated}`, nil)
+}
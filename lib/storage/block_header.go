@@ -68,6 +68,27 @@ type blockHeader struct {
 	//
 	// Lower PrecisionBits give better block compression and speed.
 	PrecisionBits uint8
+
+	// ValuesFormat is the format of row values in the block.
+	//
+	// It is marshaled in the upper bits of ValuesMarshalType byte, so block headers
+	// written before the introduction of ValuesFormat are unmarshaled with valuesFormatLegacy.
+	ValuesFormat valuesFormat
+
+	// ColumnsFormat is the format of log lines split into Columns.
+	//
+	// It is set only if ValuesMarshalType is encodingext.MarshalTypeColumnarBytesArray.
+	ColumnsFormat columnsFormat
+
+	// Columns contains headers for columns stored in the block with values.
+	//
+	// The first column contains lines, which couldn't be split into columns.
+	// Other columns contain values for fields with the corresponding names.
+	//
+	// Columns are marshaled as block header extension only if ValuesMarshalType
+	// is encodingext.MarshalTypeColumnarBytesArray, so block headers for other blocks
+	// have fixed size.
+	Columns []columnHeader
 }
 
 // Less returns true if b is less than src.
@@ -81,7 +102,7 @@ func (bh *blockHeader) Less(src *blockHeader) bool {
 	return bh.TSID.Less(&src.TSID)
 }
 
-// marshaledBlockHeaderSize is the size of marshaled block header.
+// marshaledBlockHeaderSize is the size of marshaled block header without extensions.
 var marshaledBlockHeaderSize = func() int {
 	var bh blockHeader
 	data := bh.Marshal(nil)
@@ -98,7 +119,10 @@ func (bh *blockHeader) Marshal(dst []byte) []byte {
 	dst = encoding.MarshalUint32(dst, bh.TimestampsBlockSize)
 	dst = encoding.MarshalUint32(dst, bh.ValuesBlockSize)
 	dst = encoding.MarshalUint32(dst, bh.RowsCount)
-	dst = append(dst, byte(bh.TimestampsMarshalType), byte(bh.ValuesMarshalType), bh.PrecisionBits)
+	dst = append(dst, byte(bh.TimestampsMarshalType), marshalValuesTypeAndFormat(bh.ValuesMarshalType, bh.ValuesFormat), bh.PrecisionBits)
+	if bh.ValuesMarshalType == encodingext.MarshalTypeColumnarBytesArray {
+		dst = bh.marshalColumns(dst)
+	}
 	return dst
 }
 
@@ -130,11 +154,22 @@ func (bh *blockHeader) Unmarshal(src []byte) ([]byte, error) {
 	src = src[4:]
 	bh.TimestampsMarshalType = encoding.MarshalType(src[0])
 	src = src[1:]
-	bh.ValuesMarshalType = encoding.MarshalType(src[0])
+	bh.ValuesMarshalType, bh.ValuesFormat = unmarshalValuesTypeAndFormat(src[0])
 	src = src[1:]
 	bh.PrecisionBits = uint8(src[0])
 	src = src[1:]
 
+	// Columns must be always allocated from scratch, since bh may be copied by value after Unmarshal.
+	bh.ColumnsFormat = columnsFormatNone
+	bh.Columns = nil
+	if bh.ValuesMarshalType == encodingext.MarshalTypeColumnarBytesArray {
+		tail, err := bh.unmarshalColumns(src)
+		if err != nil {
+			return src, fmt.Errorf("cannot unmarshal columns: %w", err)
+		}
+		src = tail
+	}
+
 	err = bh.validate()
 	return src, err
 }
@@ -152,6 +187,9 @@ func (bh *blockHeader) validate() error {
 	if err := encodingext.CheckMarshalType(bh.ValuesMarshalType); err != nil {
 		return fmt.Errorf("unsupported ValuesMarshalType: %w", err)
 	}
+	if bh.ValuesFormat > valuesFormatNumeric {
+		return fmt.Errorf("unsupported ValuesFormat=%d", bh.ValuesFormat)
+	}
 	if err := encoding.CheckPrecisionBits(bh.PrecisionBits); err != nil {
 		return err
 	}
@@ -161,9 +199,44 @@ func (bh *blockHeader) validate() error {
 	if bh.ValuesBlockSize > 2*maxBlockSize {
 		return fmt.Errorf("too big ValuesBlockSize; got %d; cannot exceed %d", bh.ValuesBlockSize, 2*maxBlockSize)
 	}
+	if bh.ValuesMarshalType == encodingext.MarshalTypeColumnarBytesArray {
+		if err := bh.validateColumns(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// valuesFormat is the format of row values in the block.
+type valuesFormat uint8
+
+const (
+	// valuesFormatLegacy means that row values contain raw log lines.
+	//
+	// Blocks written before structured metadata support have this format. Lines starting
+	// with lineMetadataMarker or numericValueMarker are escaped when such blocks are unmarshaled.
+	valuesFormatLegacy = valuesFormat(0)
+
+	// valuesFormatTagged means that row values are marshaled with MarshalLineWithMetadata or MarshalNumericValue.
+	valuesFormatTagged = valuesFormat(1)
+
+	// valuesFormatNumeric is valuesFormatTagged with all the row values marshaled with MarshalNumericValue.
+	//
+	// It allows detecting series with numeric samples by block headers without reading row values.
+	valuesFormatNumeric = valuesFormat(2)
+)
+
+// valuesFormatShift is the bit offset for valuesFormat in the marshaled ValuesMarshalType byte.
+const valuesFormatShift = 6
+
+func marshalValuesTypeAndFormat(mt encoding.MarshalType, vf valuesFormat) byte {
+	return byte(mt) | byte(vf)<<valuesFormatShift
+}
+
+func unmarshalValuesTypeAndFormat(b byte) (encoding.MarshalType, valuesFormat) {
+	return encoding.MarshalType(b & (1<<valuesFormatShift - 1)), valuesFormat(b >> valuesFormatShift)
+}
+
 // unmarshalBlockHeaders unmarshals all the block headers from src,
 // appends them to dst and returns the appended result.
 //
@@ -31,6 +31,7 @@ func TestBlockHeaderMarshalUnmarshal(t *testing.T) {
 		bh.TimestampsMarshalType = encodingext.MarshalType((i + 10) % 7)
 		bh.ValuesMarshalType = encodingext.MarshalType((i + 11) % 7)
 		bh.PrecisionBits = 1 + uint8((i+12)%64)
+		bh.ValuesFormat = valuesFormat(i % 2)
 
 		testBlockHeaderMarshalUnmarshal(t, &bh)
 	}
@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"sync"
 
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/encodingext"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/filestream"
@@ -35,6 +36,9 @@ type blockStreamReader struct {
 
 	mrs []metaindexRow
 
+	// valuesDict is the dictionary for values blocks in the part.
+	valuesDict *encodingext.Dict
+
 	// Points the current mr from mrs.
 	mr *metaindexRow
 
@@ -77,6 +81,7 @@ func (bsr *blockStreamReader) reset() {
 
 	bsr.timestampsReader = nil
 	bsr.valuesReader = nil
+	bsr.valuesDict = nil
 	bsr.indexReader = nil
 
 	bsr.mrs = bsr.mrs[:0]
@@ -177,12 +182,20 @@ func (bsr *blockStreamReader) InitFromFilePart(path string) error {
 		indexFile.MustClose()
 		return fmt.Errorf("cannot unmarshal metaindex rows from inmemoryPart: %w", err)
 	}
+	valuesDict, err := readValuesDict(path)
+	if err != nil {
+		timestampsFile.MustClose()
+		valuesFile.MustClose()
+		indexFile.MustClose()
+		return err
+	}
 
 	bsr.path = path
 	bsr.timestampsReader = timestampsFile
 	bsr.valuesReader = valuesFile
 	bsr.indexReader = indexFile
 	bsr.mrs = mrs
+	bsr.valuesDict = valuesDict
 
 	bsr.assertWriteClosers()
 
@@ -215,6 +228,7 @@ func (bsr *blockStreamReader) NextBlock() bool {
 	}
 
 	bsr.Block.Reset()
+	bsr.Block.dict = bsr.valuesDict
 
 	err := bsr.readBlock()
 	if err == nil {
@@ -253,15 +267,14 @@ func (bsr *blockStreamReader) readBlock() error {
 		return fmt.Errorf("too short index data for reading block header at offset %d; got %d bytes; want %d bytes",
 			bsr.prevIndexBlockOffset(), len(bsr.indexCursor), marshaledBlockHeaderSize)
 	}
-	bsr.Block.headerData = append(bsr.Block.headerData[:0], bsr.indexCursor[:marshaledBlockHeaderSize]...)
-	bsr.indexCursor = bsr.indexCursor[marshaledBlockHeaderSize:]
-	tail, err := bsr.Block.bh.Unmarshal(bsr.Block.headerData)
+	// Block header may contain extensions, so its size is known only after unmarshaling.
+	tail, err := bsr.Block.bh.Unmarshal(bsr.indexCursor)
 	if err != nil {
 		return fmt.Errorf("cannot parse block header read from index data at offset %d: %w", bsr.prevIndexBlockOffset(), err)
 	}
-	if len(tail) > 0 {
-		return fmt.Errorf("non-empty tail left after parsing block header at offset %d: %x", bsr.prevIndexBlockOffset(), tail)
-	}
+	headerSize := len(bsr.indexCursor) - len(tail)
+	bsr.Block.headerData = append(bsr.Block.headerData[:0], bsr.indexCursor[:headerSize]...)
+	bsr.indexCursor = tail
 
 	bsr.blocksCount++
 	if bsr.blocksCount > bsr.ph.BlocksCount {
@@ -8,6 +8,7 @@ import (
 	"sync"
 	"sync/atomic"
 
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/encodingext"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/filestream"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
@@ -28,6 +29,11 @@ type blockStreamWriter struct {
 	indexWriter     filestream.WriteCloser
 	metaindexWriter filestream.WriteCloser
 
+	// valuesDict is the dictionary used for compressing values blocks.
+	//
+	// Values blocks are compressed without dictionary if it is nil.
+	valuesDict *encodingext.Dict
+
 	mr metaindexRow
 
 	timestampsBlockOffset uint64
@@ -62,6 +68,7 @@ func (bsw *blockStreamWriter) reset() {
 	bsw.valuesWriter = nil
 	bsw.indexWriter = nil
 	bsw.metaindexWriter = nil
+	bsw.valuesDict = nil
 
 	bsw.mr.Reset()
 
@@ -157,6 +164,17 @@ func (bsw *blockStreamWriter) InitFromFilePart(path string, nocache bool, compre
 	return nil
 }
 
+// SetValuesDict sets the dictionary for compressing values blocks written to bsw.
+//
+// The dictionary is stored in the part directory on MustClose, so the part could be read later.
+// It must be called before writing the first block to bsw initialized with InitFromFilePart.
+func (bsw *blockStreamWriter) SetValuesDict(d *encodingext.Dict) {
+	if bsw.path == "" {
+		logger.Panicf("BUG: values dictionary cannot be set for in-memory part")
+	}
+	bsw.valuesDict = d
+}
+
 // MustClose closes the bsw.
 //
 // It closes *Writer files passed to Init*.
@@ -174,6 +192,12 @@ func (bsw *blockStreamWriter) MustClose() {
 	bsw.indexWriter.MustClose()
 	bsw.metaindexWriter.MustClose()
 
+	if bsw.valuesDict != nil {
+		if err := writeValuesDict(bsw.path, bsw.valuesDict); err != nil {
+			logger.Panicf("FATAL: cannot store values dictionary to %q: %s", bsw.path, err)
+		}
+	}
+
 	// Sync bsw.path contents to make sure it doesn't disappear
 	// after system crash or power loss.
 	if bsw.path != "" {
@@ -90,6 +90,40 @@ func compareBlocksPortable(t *testing.T, b1, b2 *Block, bhExpected *blockHeader)
 	}
 }
 
+func TestBlockUnmarshalDataLegacyValues(t *testing.T) {
+	f := func(vf valuesFormat, valuesExpected []string) {
+		t.Helper()
+		var b Block
+		b.timestamps = []int64{1, 2, 3}
+		b.values = [][]byte{[]byte("foo"), []byte("\xffbar"), []byte("\xfe12345678")}
+		b.bh.PrecisionBits = 64
+		b.MarshalData(0, 0)
+		if b.bh.ValuesFormat != valuesFormatTagged {
+			t.Fatalf("unexpected ValuesFormat after MarshalData; got %d; want %d", b.bh.ValuesFormat, valuesFormatTagged)
+		}
+		b.bh.ValuesFormat = vf
+		if err := b.UnmarshalData(true); err != nil {
+			t.Fatalf("cannot unmarshal block: %s", err)
+		}
+		if b.bh.ValuesFormat != valuesFormatTagged {
+			t.Fatalf("unexpected ValuesFormat after UnmarshalData; got %d; want %d", b.bh.ValuesFormat, valuesFormatTagged)
+		}
+		var values []string
+		for _, v := range b.values {
+			values = append(values, string(v))
+		}
+		if !reflect.DeepEqual(values, valuesExpected) {
+			t.Fatalf("unexpected values; got %q; want %q", values, valuesExpected)
+		}
+	}
+
+	// Values in tagged blocks are returned as is.
+	f(valuesFormatTagged, []string{"foo", "\xffbar", "\xfe12345678"})
+
+	// Legacy lines starting with markers are escaped.
+	f(valuesFormatLegacy, []string{"foo", "\xff\x00\xffbar", "\xff\x00\xfe12345678"})
+}
+
 var letterRunes = []byte("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
 
 func getRandValues(rowsCount int) [][]byte {
@@ -29,7 +29,11 @@ func DeduplicateSamples(srcTimestamps []int64, srcValues []float64, srcDatas [][
 	tsNext := (srcTimestamps[0] - srcTimestamps[0]%minScrapeInterval) + minScrapeInterval
 	dstTimestamps := srcTimestamps[:1]
 	dstValues := srcValues[:1]
-	dstDatas := srcDatas[:1]
+	var dstDatas [][]byte
+	if srcDatas != nil {
+		// srcDatas is nil if only timestamps and values are fetched.
+		dstDatas = srcDatas[:1]
+	}
 	for i := 1; i < len(srcTimestamps); i++ {
 		ts := srcTimestamps[i]
 		if ts < tsNext {
@@ -37,7 +41,9 @@ func DeduplicateSamples(srcTimestamps []int64, srcValues []float64, srcDatas [][
 		}
 		dstTimestamps = append(dstTimestamps, ts)
 		dstValues = append(dstValues, srcValues[i])
-		dstDatas = append(dstDatas, srcDatas[i])
+		if srcDatas != nil {
+			dstDatas = append(dstDatas, srcDatas[i])
+		}
 
 		// Update tsNext
 		tsNext += minScrapeInterval
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"path/filepath"
 	"sort"
 	"sync"
@@ -540,11 +541,18 @@ type indexSearch struct {
 	tsidByNameSkips  int
 }
 
+// errTSIDCreationDenied is returned from GetOrCreateTSIDByName when mayCreate denies creating new TSID.
+var errTSIDCreationDenied = errors.New("creation of new TSID is denied")
+
 // GetOrCreateTSIDByName fills the dst with TSID for the given metricName.
-func (is *indexSearch) GetOrCreateTSIDByName(dst *TSID, metricName []byte) error {
+//
+// mayCreate is called before creating new TSID if it is non-nil.
+// errTSIDCreationDenied is returned if it returns false.
+func (is *indexSearch) GetOrCreateTSIDByName(dst *TSID, metricName []byte, mayCreate func(metricName []byte) bool) error {
 	// A hack: skip searching for the TSID after many serial misses.
 	// This should improve insertion performance for big batches
 	// of new time series.
+	searchSkipped := false
 	if is.tsidByNameMisses < 100 {
 		err := is.getTSIDByMetricName(dst, metricName)
 		if err == nil {
@@ -556,6 +564,7 @@ func (is *indexSearch) GetOrCreateTSIDByName(dst *TSID, metricName []byte) error
 		}
 		is.tsidByNameMisses++
 	} else {
+		searchSkipped = true
 		is.tsidByNameSkips++
 		if is.tsidByNameSkips > 10000 {
 			is.tsidByNameSkips = 0
@@ -563,6 +572,20 @@ func (is *indexSearch) GetOrCreateTSIDByName(dst *TSID, metricName []byte) error
 		}
 	}
 
+	if mayCreate != nil && !mayCreate(metricName) {
+		if searchSkipped {
+			// The TSID may exist, so it must be searched before denying the creation.
+			err := is.getTSIDByMetricName(dst, metricName)
+			if err == nil {
+				return nil
+			}
+			if err != io.EOF {
+				return fmt.Errorf("cannot search TSID by MetricName %q: %w", metricName, err)
+			}
+		}
+		return errTSIDCreationDenied
+	}
+
 	// TSID for the given name wasn't found. Create it.
 	// It is OK if duplicate TSID for mn is created by concurrent goroutines.
 	// Metric results will be merged by mn after TableSearch.
@@ -738,14 +761,85 @@ func putIndexItems(ii *indexItems) {
 
 var indexItemsPool sync.Pool
 
-// SearchTagKeys returns all the tag keys for the given accountID, projectID.
-func (db *indexDB) SearchTagKeys(accountID, projectID uint32, maxTagKeys int, deadline uint64) ([]string, error) {
+// SearchTenants returns all the tenants with streams in db.
+//
+// Tenants are returned in the form `accountID:projectID`.
+func (db *indexDB) SearchTenants(deadline uint64) ([]string, error) {
+	tenants := make(map[string]struct{})
+
+	is := db.getIndexSearch(0, 0, deadline)
+	err := is.searchTenants(tenants)
+	db.putIndexSearch(is)
+	if err != nil {
+		return nil, err
+	}
+
+	ok := db.doExtDB(func(extDB *indexDB) {
+		is := extDB.getIndexSearch(0, 0, deadline)
+		err = is.searchTenants(tenants)
+		extDB.putIndexSearch(is)
+	})
+	if ok && err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(tenants))
+	for tenant := range tenants {
+		result = append(result, tenant)
+	}
+	return result, nil
+}
+
+func (is *indexSearch) searchTenants(tenants map[string]struct{}) error {
+	ts := &is.ts
+	kb := &is.kb
+	nsPrefix := byte(nsPrefixMetricIDToTSID)
+	kb.B = append(kb.B[:0], nsPrefix)
+	ts.Seek(kb.B)
+	loopsPaceLimiter := 0
+	for ts.NextItem() {
+		if loopsPaceLimiter&paceLimiterFastIterationsMask == 0 {
+			if err := checkSearchDeadlineAndPace(is.deadline); err != nil {
+				return err
+			}
+		}
+		loopsPaceLimiter++
+		item := ts.Item
+		if len(item) == 0 || item[0] != nsPrefix {
+			break
+		}
+		_, _, accountID, projectID, err := unmarshalCommonPrefix(item)
+		if err != nil {
+			return err
+		}
+		tenants[fmt.Sprintf("%d:%d", accountID, projectID)] = struct{}{}
+
+		// Jump to the next tenant.
+		if projectID < math.MaxUint32 {
+			projectID++
+		} else if accountID < math.MaxUint32 {
+			accountID++
+			projectID = 0
+		} else {
+			break
+		}
+		kb.B = marshalCommonPrefix(kb.B[:0], nsPrefix, accountID, projectID)
+		ts.Seek(kb.B)
+	}
+	if err := ts.Error(); err != nil {
+		return fmt.Errorf("error during search for tenants: %w", err)
+	}
+	return nil
+}
+
+// SearchTagKeys returns all the tag keys for the given accountID, projectID on the given tr.
+func (db *indexDB) SearchTagKeys(accountID, projectID uint32, tr TimeRange, maxTagKeys int, deadline uint64) ([]string, error) {
 	// TODO: cache results?
 
 	tks := make(map[string]struct{})
 
 	is := db.getIndexSearch(accountID, projectID, deadline)
-	err := is.searchTagKeys(tks, maxTagKeys)
+	err := is.searchTagKeysOnTimeRange(tks, tr, maxTagKeys)
 	db.putIndexSearch(is)
 	if err != nil {
 		return nil, err
@@ -753,7 +847,7 @@ func (db *indexDB) SearchTagKeys(accountID, projectID uint32, maxTagKeys int, de
 
 	ok := db.doExtDB(func(extDB *indexDB) {
 		is := extDB.getIndexSearch(accountID, projectID, deadline)
-		err = is.searchTagKeys(tks, maxTagKeys)
+		err = is.searchTagKeysOnTimeRange(tks, tr, maxTagKeys)
 		extDB.putIndexSearch(is)
 	})
 	if ok && err != nil {
@@ -770,15 +864,74 @@ func (db *indexDB) SearchTagKeys(accountID, projectID uint32, maxTagKeys int, de
 	return keys, nil
 }
 
+func (is *indexSearch) searchTagKeysOnTimeRange(tks map[string]struct{}, tr TimeRange, maxTagKeys int) error {
+	minDate := uint64(tr.MinTimestamp) / msecPerDay
+	maxDate := uint64(tr.MaxTimestamp) / msecPerDay
+	if maxDate-minDate > maxDaysForDateMetricIDs {
+		return is.searchTagKeys(tks, maxTagKeys)
+	}
+	return is.searchOnDates(tks, minDate, maxDate, func(isLocal *indexSearch, tksLocal map[string]struct{}, date uint64) error {
+		return isLocal.searchTagKeysOnDate(tksLocal, date, maxTagKeys)
+	})
+}
+
+// searchOnDates calls f for each date in the range [minDate ... maxDate] in parallel and merges the results into dst.
+func (is *indexSearch) searchOnDates(dst map[string]struct{}, minDate, maxDate uint64,
+	f func(isLocal *indexSearch, dstLocal map[string]struct{}, date uint64) error) error {
+	var wg sync.WaitGroup
+	var errGlobal error
+	var mu sync.Mutex // protects dst + errGlobal from concurrent access below.
+	for minDate <= maxDate {
+		wg.Add(1)
+		go func(date uint64) {
+			defer wg.Done()
+			dstLocal := make(map[string]struct{})
+			isLocal := is.db.getIndexSearch(is.accountID, is.projectID, is.deadline)
+			defer is.db.putIndexSearch(isLocal)
+			err := f(isLocal, dstLocal, date)
+			mu.Lock()
+			defer mu.Unlock()
+			if errGlobal != nil {
+				return
+			}
+			if err != nil {
+				errGlobal = err
+				return
+			}
+			for k := range dstLocal {
+				dst[k] = struct{}{}
+			}
+		}(minDate)
+		minDate++
+	}
+	wg.Wait()
+	return errGlobal
+}
+
 func (is *indexSearch) searchTagKeys(tks map[string]struct{}, maxTagKeys int) error {
+	kb := &is.kb
+	nsPrefix := byte(nsPrefixTagToMetricIDs)
+	kb.B = is.marshalCommonPrefix(kb.B[:0], nsPrefix)
+	prefix := append([]byte(nil), kb.B...)
+	return is.searchTagKeysForPrefix(tks, nsPrefix, prefix, maxTagKeys)
+}
+
+func (is *indexSearch) searchTagKeysOnDate(tks map[string]struct{}, date uint64, maxTagKeys int) error {
+	kb := &is.kb
+	nsPrefix := byte(nsPrefixDateTagToMetricIDs)
+	kb.B = is.marshalCommonPrefix(kb.B[:0], nsPrefix)
+	kb.B = encoding.MarshalUint64(kb.B, date)
+	prefix := append([]byte(nil), kb.B...)
+	return is.searchTagKeysForPrefix(tks, nsPrefix, prefix, maxTagKeys)
+}
+
+func (is *indexSearch) searchTagKeysForPrefix(tks map[string]struct{}, nsPrefix byte, prefix []byte, maxTagKeys int) error {
 	ts := &is.ts
 	kb := &is.kb
 	mp := &is.mp
 	mp.Reset()
 	dmis := is.db.getDeletedMetricIDs()
 	loopsPaceLimiter := 0
-	kb.B = is.marshalCommonPrefix(kb.B[:0], nsPrefixTagToMetricIDs)
-	prefix := kb.B
 	ts.Seek(prefix)
 	for len(tks) < maxTagKeys && ts.NextItem() {
 		if loopsPaceLimiter&paceLimiterFastIterationsMask == 0 {
@@ -791,7 +944,7 @@ func (is *indexSearch) searchTagKeys(tks map[string]struct{}, maxTagKeys int) er
 		if !bytes.HasPrefix(item, prefix) {
 			break
 		}
-		if err := mp.Init(item, nsPrefixTagToMetricIDs); err != nil {
+		if err := mp.Init(item, nsPrefix); err != nil {
 			return err
 		}
 		if mp.IsDeletedTag(dmis) {
@@ -804,7 +957,7 @@ func (is *indexSearch) searchTagKeys(tks map[string]struct{}, maxTagKeys int) er
 		// Search for the next tag key.
 		// The last char in kb.B must be tagSeparatorChar.
 		// Just increment it in order to jump to the next tag key.
-		kb.B = is.marshalCommonPrefix(kb.B[:0], nsPrefixTagToMetricIDs)
+		kb.B = append(kb.B[:0], prefix...)
 		kb.B = marshalTagValue(kb.B, mp.Tag.Key)
 		kb.B[len(kb.B)-1]++
 		ts.Seek(kb.B)
@@ -815,20 +968,20 @@ func (is *indexSearch) searchTagKeys(tks map[string]struct{}, maxTagKeys int) er
 	return nil
 }
 
-// SearchTagValues returns all the tag values for the given tagKey
-func (db *indexDB) SearchTagValues(accountID, projectID uint32, tagKey []byte, maxTagValues int, deadline uint64) ([]string, error) {
+// SearchTagValues returns all the tag values for the given tagKey on the given tr.
+func (db *indexDB) SearchTagValues(accountID, projectID uint32, tr TimeRange, tagKey []byte, maxTagValues int, deadline uint64) ([]string, error) {
 	// TODO: cache results?
 
 	tvs := make(map[string]struct{})
 	is := db.getIndexSearch(accountID, projectID, deadline)
-	err := is.searchTagValues(tvs, tagKey, maxTagValues)
+	err := is.searchTagValuesOnTimeRange(tvs, tr, tagKey, maxTagValues)
 	db.putIndexSearch(is)
 	if err != nil {
 		return nil, err
 	}
 	ok := db.doExtDB(func(extDB *indexDB) {
 		is := extDB.getIndexSearch(accountID, projectID, deadline)
-		err = is.searchTagValues(tvs, tagKey, maxTagValues)
+		err = is.searchTagValuesOnTimeRange(tvs, tr, tagKey, maxTagValues)
 		extDB.putIndexSearch(is)
 	})
 	if ok && err != nil {
@@ -849,16 +1002,45 @@ func (db *indexDB) SearchTagValues(accountID, projectID uint32, tagKey []byte, m
 	return tagValues, nil
 }
 
+func (is *indexSearch) searchTagValuesOnTimeRange(tvs map[string]struct{}, tr TimeRange, tagKey []byte, maxTagValues int) error {
+	minDate := uint64(tr.MinTimestamp) / msecPerDay
+	maxDate := uint64(tr.MaxTimestamp) / msecPerDay
+	if maxDate-minDate > maxDaysForDateMetricIDs {
+		return is.searchTagValues(tvs, tagKey, maxTagValues)
+	}
+	return is.searchOnDates(tvs, minDate, maxDate, func(isLocal *indexSearch, tvsLocal map[string]struct{}, date uint64) error {
+		return isLocal.searchTagValuesOnDate(tvsLocal, date, tagKey, maxTagValues)
+	})
+}
+
 func (is *indexSearch) searchTagValues(tvs map[string]struct{}, tagKey []byte, maxTagValues int) error {
+	kb := &is.kb
+	nsPrefix := byte(nsPrefixTagToMetricIDs)
+	kb.B = is.marshalCommonPrefix(kb.B[:0], nsPrefix)
+	prefix := append([]byte(nil), kb.B...)
+	return is.searchTagValuesForPrefix(tvs, nsPrefix, prefix, tagKey, maxTagValues)
+}
+
+func (is *indexSearch) searchTagValuesOnDate(tvs map[string]struct{}, date uint64, tagKey []byte, maxTagValues int) error {
+	kb := &is.kb
+	nsPrefix := byte(nsPrefixDateTagToMetricIDs)
+	kb.B = is.marshalCommonPrefix(kb.B[:0], nsPrefix)
+	kb.B = encoding.MarshalUint64(kb.B, date)
+	prefix := append([]byte(nil), kb.B...)
+	return is.searchTagValuesForPrefix(tvs, nsPrefix, prefix, tagKey, maxTagValues)
+}
+
+// searchTagValuesForPrefix searches for values of tagKey in items starting with commonPrefix.
+func (is *indexSearch) searchTagValuesForPrefix(tvs map[string]struct{}, nsPrefix byte, commonPrefix, tagKey []byte, maxTagValues int) error {
 	ts := &is.ts
 	kb := &is.kb
 	mp := &is.mp
 	mp.Reset()
 	dmis := is.db.getDeletedMetricIDs()
 	loopsPaceLimiter := 0
-	kb.B = is.marshalCommonPrefix(kb.B[:0], nsPrefixTagToMetricIDs)
+	kb.B = append(kb.B[:0], commonPrefix...)
 	kb.B = marshalTagValue(kb.B, tagKey)
-	prefix := kb.B
+	prefix := append([]byte(nil), kb.B...)
 	ts.Seek(prefix)
 	for len(tvs) < maxTagValues && ts.NextItem() {
 		if loopsPaceLimiter&paceLimiterFastIterationsMask == 0 {
@@ -871,7 +1053,7 @@ func (is *indexSearch) searchTagValues(tvs map[string]struct{}, tagKey []byte, m
 		if !bytes.HasPrefix(item, prefix) {
 			break
 		}
-		if err := mp.Init(item, nsPrefixTagToMetricIDs); err != nil {
+		if err := mp.Init(item, nsPrefix); err != nil {
 			return err
 		}
 		if mp.IsDeletedTag(dmis) {
@@ -890,7 +1072,7 @@ func (is *indexSearch) searchTagValues(tvs map[string]struct{}, tagKey []byte, m
 		// Search for the next tag value.
 		// The last char in kb.B must be tagSeparatorChar.
 		// Just increment it in order to jump to the next tag value.
-		kb.B = is.marshalCommonPrefix(kb.B[:0], nsPrefixTagToMetricIDs)
+		kb.B = append(kb.B[:0], commonPrefix...)
 		kb.B = marshalTagValue(kb.B, mp.Tag.Key)
 		kb.B = marshalTagValue(kb.B, mp.Tag.Value)
 		kb.B[len(kb.B)-1]++
@@ -941,34 +1123,9 @@ func (is *indexSearch) searchTagValueSuffixesForTimeRange(tvss map[string]struct
 		return is.searchTagValueSuffixesAll(tvss, tagKey, tagValuePrefix, delimiter, maxTagValueSuffixes)
 	}
 	// Query over multiple days in parallel.
-	var wg sync.WaitGroup
-	var errGlobal error
-	var mu sync.Mutex // protects tvss + errGlobal from concurrent access below.
-	for minDate <= maxDate {
-		wg.Add(1)
-		go func(date uint64) {
-			defer wg.Done()
-			tvssLocal := make(map[string]struct{})
-			isLocal := is.db.getIndexSearch(is.accountID, is.projectID, is.deadline)
-			defer is.db.putIndexSearch(isLocal)
-			err := isLocal.searchTagValueSuffixesForDate(tvssLocal, date, tagKey, tagValuePrefix, delimiter, maxTagValueSuffixes)
-			mu.Lock()
-			defer mu.Unlock()
-			if errGlobal != nil {
-				return
-			}
-			if err != nil {
-				errGlobal = err
-				return
-			}
-			for k := range tvssLocal {
-				tvss[k] = struct{}{}
-			}
-		}(minDate)
-		minDate++
-	}
-	wg.Wait()
-	return errGlobal
+	return is.searchOnDates(tvss, minDate, maxDate, func(isLocal *indexSearch, tvssLocal map[string]struct{}, date uint64) error {
+		return isLocal.searchTagValueSuffixesForDate(tvssLocal, date, tagKey, tagValuePrefix, delimiter, maxTagValueSuffixes)
+	})
 }
 
 func (is *indexSearch) searchTagValueSuffixesAll(tvss map[string]struct{}, tagKey, tagValuePrefix []byte, delimiter byte, maxTagValueSuffixes int) error {
@@ -102,7 +102,7 @@ func benchmarkIndexDBAddTSIDs(db *indexDB, tsid *TSID, mn *MetricName, startOffs
 		}
 		mn.sortTags()
 		metricName = mn.Marshal(metricName[:0])
-		if err := is.GetOrCreateTSIDByName(tsid, metricName); err != nil {
+		if err := is.GetOrCreateTSIDByName(tsid, metricName, nil); err != nil {
 			panic(fmt.Errorf("cannot insert record: %w", err))
 		}
 	}
@@ -175,7 +175,7 @@ func BenchmarkHeadPostingForMatchers(b *testing.B) {
 			MaxTimestamp: timestampFromTime(time.Now()),
 		}
 		for i := 0; i < b.N; i++ {
-			metricIDs, err := is.searchMetricIDs(tfss, tr, 2e9)
+			metricIDs, err := is.searchMetricIDs(tfss, tr, 1e9, 2e9)
 			if err != nil {
 				b.Fatalf("unexpected error in searchMetricIDs: %s", err)
 			}
@@ -336,7 +336,7 @@ func BenchmarkIndexDBGetTSIDs(b *testing.B) {
 		mn.ProjectID = uint32(i % projectsCount)
 		mn.sortTags()
 		metricName = mn.Marshal(metricName[:0])
-		if err := is.GetOrCreateTSIDByName(&tsid, metricName); err != nil {
+		if err := is.GetOrCreateTSIDByName(&tsid, metricName, nil); err != nil {
 			b.Fatalf("cannot insert record: %s", err)
 		}
 	}
@@ -356,7 +356,7 @@ func BenchmarkIndexDBGetTSIDs(b *testing.B) {
 				mnLocal.ProjectID = uint32(i % projectsCount)
 				mnLocal.sortTags()
 				metricNameLocal = mnLocal.Marshal(metricNameLocal[:0])
-				if err := is.GetOrCreateTSIDByName(&tsidLocal, metricNameLocal); err != nil {
+				if err := is.GetOrCreateTSIDByName(&tsidLocal, metricNameLocal, nil); err != nil {
 					panic(fmt.Errorf("cannot obtain tsid: %w", err))
 				}
 			}
@@ -0,0 +1,348 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/encodingext"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+)
+
+// PartInfo contains information about a single part stored on disk.
+type PartInfo struct {
+	// Path is the filesystem path to the part.
+	Path string
+
+	// Partition is the name of the partition the part belongs to, i.e. YYYY_MM.
+	Partition string
+
+	// IsBig is set to true if the part is located in the big partitions directory.
+	IsBig bool
+
+	// RowsCount is the number of rows in the part according to its partHeader.
+	RowsCount uint64
+
+	// BlocksCount is the number of blocks in the part according to its partHeader.
+	BlocksCount uint64
+
+	// MinTimestamp is the minimum timestamp in the part according to its partHeader.
+	MinTimestamp int64
+
+	// MaxTimestamp is the maximum timestamp in the part according to its partHeader.
+	MaxTimestamp int64
+
+	// SizeBytes is the total size of part files.
+	SizeBytes uint64
+}
+
+// ListParts returns information about all the parts stored in the storage at the given path.
+//
+// ListParts only reads directory entries, so it is safe to call it
+// on the data of a running or a stopped vmstorage.
+func ListParts(path string) ([]PartInfo, error) {
+	var pis []PartInfo
+	var err error
+	pis, err = appendPartInfos(pis, path+"/data/small", false)
+	if err != nil {
+		return nil, err
+	}
+	pis, err = appendPartInfos(pis, path+"/data/big", true)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(pis, func(i, j int) bool {
+		if pis[i].Partition != pis[j].Partition {
+			return pis[i].Partition < pis[j].Partition
+		}
+		return pis[i].Path < pis[j].Path
+	})
+	return pis, nil
+}
+
+func appendPartInfos(dst []PartInfo, partitionsPath string, isBig bool) ([]PartInfo, error) {
+	ptNames := make(map[string]bool)
+	if err := populatePartitionNames(partitionsPath, ptNames); err != nil {
+		return dst, err
+	}
+	for ptName := range ptNames {
+		ptPath := partitionsPath + "/" + ptName
+		d, err := os.Open(ptPath)
+		if err != nil {
+			return dst, fmt.Errorf("cannot open partition directory %q: %w", ptPath, err)
+		}
+		fis, err := d.Readdir(-1)
+		fs.MustClose(d)
+		if err != nil {
+			return dst, fmt.Errorf("cannot read partition directory %q: %w", ptPath, err)
+		}
+		for _, fi := range fis {
+			if !fs.IsDirOrSymlink(fi) {
+				continue
+			}
+			fn := fi.Name()
+			if fn == "tmp" || fn == "txn" || fn == "snapshots" {
+				// Skip special dirs.
+				continue
+			}
+			partPath := ptPath + "/" + fn
+			var ph partHeader
+			if err := ph.ParseFromPath(partPath); err != nil {
+				return dst, fmt.Errorf("cannot parse part name %q: %w", partPath, err)
+			}
+			dst = append(dst, PartInfo{
+				Path:         partPath,
+				Partition:    ptName,
+				IsBig:        isBig,
+				RowsCount:    ph.RowsCount,
+				BlocksCount:  ph.BlocksCount,
+				MinTimestamp: ph.MinTimestamp,
+				MaxTimestamp: ph.MaxTimestamp,
+				SizeBytes:    getPartFilesSize(partPath),
+			})
+		}
+	}
+	return dst, nil
+}
+
+func getPartFilesSize(partPath string) uint64 {
+	n := uint64(0)
+	for _, names := range [][]string{partFileNames, optionalPartFileNames} {
+		for _, name := range names {
+			fi, err := os.Stat(partPath + "/" + name)
+			if err != nil {
+				// Missing files are reported by VerifyPart.
+				continue
+			}
+			n += uint64(fi.Size())
+		}
+	}
+	return n
+}
+
+var partFileNames = []string{"timestamps.bin", "values.bin", "index.bin", "metaindex.bin"}
+
+// optionalPartFileNames contains files, which may be missing in the part.
+//
+// values.dict is written only for parts with values compressed with dictionary.
+var optionalPartFileNames = []string{valuesDictFilename}
+
+// PartVerifyResult contains the result of VerifyPart call.
+type PartVerifyResult struct {
+	// Path is the path to the verified part.
+	Path string
+
+	// BlocksCount is the number of successfully verified blocks.
+	BlocksCount uint64
+
+	// RowsCount is the number of rows in successfully verified blocks.
+	RowsCount uint64
+
+	// Errors contains the errors found in the part.
+	Errors []error
+}
+
+// IsCorrupted returns true if errors were found in the part.
+func (pvr *PartVerifyResult) IsCorrupted() bool {
+	return len(pvr.Errors) > 0
+}
+
+func (pvr *PartVerifyResult) addError(format string, args ...interface{}) {
+	pvr.Errors = append(pvr.Errors, fmt.Errorf(format, args...))
+}
+
+// VerifyPart verifies the part at the given path.
+//
+// It checks that metaindex rows are consistent with index blocks, block headers
+// point to valid ranges in timestamps.bin and values.bin, all the blocks can be decompressed
+// and the totals match the partHeader encoded in the part name. Blocks compressed with values
+// dictionary are reported if the part has no values.dict file.
+//
+// The part is opened read-only. Verification stops after maxErrors errors are found.
+func VerifyPart(path string, maxErrors int) *PartVerifyResult {
+	path = filepath.Clean(path)
+	pvr := &PartVerifyResult{
+		Path: path,
+	}
+	for _, name := range partFileNames {
+		if !fs.IsPathExist(path + "/" + name) {
+			pvr.addError("missing file %q", path+"/"+name)
+		}
+	}
+	if pvr.IsCorrupted() {
+		return pvr
+	}
+	p, err := openFilePart(path)
+	if err != nil {
+		pvr.addError("cannot open part: %w", err)
+		return pvr
+	}
+	defer p.MustClose()
+
+	timestampsSize := fs.MustFileSize(path + "/timestamps.bin")
+	valuesSize := fs.MustFileSize(path + "/values.bin")
+	indexSize := fs.MustFileSize(path + "/index.bin")
+
+	var bhs []blockHeader
+	var b Block
+	for i := range p.metaindex {
+		if len(pvr.Errors) >= maxErrors {
+			return pvr
+		}
+		mr := &p.metaindex[i]
+		if mr.IndexBlockOffset+uint64(mr.IndexBlockSize) > indexSize {
+			pvr.addError("metaindexRow #%d points to index block [%d..%d) outside index.bin of size %d",
+				i, mr.IndexBlockOffset, mr.IndexBlockOffset+uint64(mr.IndexBlockSize), indexSize)
+			continue
+		}
+		bhs, err = p.readBlockHeaders(bhs[:0], mr)
+		if err != nil {
+			pvr.addError("cannot read block headers for metaindexRow #%d: %w", i, err)
+			continue
+		}
+		if bhs[0].TSID != mr.TSID {
+			pvr.addError("metaindexRow #%d TSID %+v doesn't match the first block header TSID %+v", i, &mr.TSID, &bhs[0].TSID)
+		}
+		for j := range bhs {
+			if len(pvr.Errors) >= maxErrors {
+				return pvr
+			}
+			bh := &bhs[j]
+			if bh.MinTimestamp < mr.MinTimestamp || bh.MaxTimestamp > mr.MaxTimestamp {
+				pvr.addError("block header #%d in metaindexRow #%d has time range [%d..%d] outside metaindexRow time range [%d..%d]",
+					j, i, bh.MinTimestamp, bh.MaxTimestamp, mr.MinTimestamp, mr.MaxTimestamp)
+				continue
+			}
+			if bh.MinTimestamp < p.ph.MinTimestamp || bh.MaxTimestamp > p.ph.MaxTimestamp {
+				pvr.addError("block header #%d in metaindexRow #%d has time range [%d..%d] outside part time range [%d..%d]",
+					j, i, bh.MinTimestamp, bh.MaxTimestamp, p.ph.MinTimestamp, p.ph.MaxTimestamp)
+				continue
+			}
+			if bh.TimestampsBlockOffset+uint64(bh.TimestampsBlockSize) > timestampsSize {
+				pvr.addError("block header #%d in metaindexRow #%d points to timestamps block outside timestamps.bin of size %d", j, i, timestampsSize)
+				continue
+			}
+			if bh.ValuesBlockOffset+uint64(bh.ValuesBlockSize) > valuesSize {
+				pvr.addError("block header #%d in metaindexRow #%d points to values block outside values.bin of size %d", j, i, valuesSize)
+				continue
+			}
+			if bh.ValuesMarshalType == encodingext.MarshalTypeZSTDDictBytesArray && p.valuesDict == nil {
+				pvr.addError("block header #%d in metaindexRow #%d refers to values dictionary, but the part has no %q file", j, i, valuesDictFilename)
+				continue
+			}
+			br := BlockRef{
+				p:  p,
+				bh: *bh,
+			}
+			br.MustReadBlock(&b, FetchAll)
+			if err := b.UnmarshalData(true); err != nil {
+				pvr.addError("cannot unmarshal block #%d in metaindexRow #%d for TSID %+v: %w", j, i, &bh.TSID, err)
+				continue
+			}
+			if len(b.timestamps) != int(bh.RowsCount) {
+				pvr.addError("block #%d in metaindexRow #%d contains %d rows; want %d rows", j, i, len(b.timestamps), bh.RowsCount)
+				continue
+			}
+			pvr.BlocksCount++
+			pvr.RowsCount += uint64(bh.RowsCount)
+		}
+	}
+	if pvr.IsCorrupted() {
+		return pvr
+	}
+	if pvr.BlocksCount != p.ph.BlocksCount {
+		pvr.addError("unexpected number of blocks; got %d; want %d according to part name", pvr.BlocksCount, p.ph.BlocksCount)
+	}
+	if pvr.RowsCount != p.ph.RowsCount {
+		pvr.addError("unexpected number of rows; got %d; want %d according to part name", pvr.RowsCount, p.ph.RowsCount)
+	}
+	return pvr
+}
+
+// DumpRows calls f for rows matching tfss on the given time range in the storage at the given path.
+//
+// Up to maxStreams streams are dumped if maxStreams > 0. f is called per block, so rows
+// for the same stream may be passed to f multiple times if they are stored in distinct blocks or parts.
+// Rows aren't sorted across blocks. f mustn't hold references to its args after returning.
+//
+// Both indexdb and data parts are opened read-only, so it is safe to call DumpRows
+// on the data of a running vmstorage. Rows, which weren't flushed to disk yet, aren't dumped.
+func DumpRows(path string, tfss []*TagFilters, tr TimeRange, maxStreams int, f func(metricName []byte, timestamps []int64, values [][]byte) error) error {
+	if len(tfss) == 0 {
+		return nil
+	}
+	metricNames, err := searchMetricNamesReadOnly(path+"/indexdb", tfss, maxStreams)
+	if err != nil {
+		return fmt.Errorf("cannot search streams in indexdb: %w", err)
+	}
+	if len(metricNames) == 0 {
+		return nil
+	}
+	pis, err := ListParts(path)
+	if err != nil {
+		return err
+	}
+	accountID := tfss[0].accountID
+	projectID := tfss[0].projectID
+	for i := range pis {
+		pi := &pis[i]
+		if pi.MaxTimestamp < tr.MinTimestamp || pi.MinTimestamp > tr.MaxTimestamp {
+			continue
+		}
+		if err := dumpPartRows(pi.Path, accountID, projectID, metricNames, tr, f); err != nil {
+			return fmt.Errorf("cannot dump rows from part %q: %w", pi.Path, err)
+		}
+	}
+	return nil
+}
+
+func dumpPartRows(path string, accountID, projectID uint32, metricNames map[uint64][]byte, tr TimeRange,
+	f func(metricName []byte, timestamps []int64, values [][]byte) error) error {
+	p, err := openFilePart(path)
+	if err != nil {
+		return err
+	}
+	defer p.MustClose()
+
+	var bhs []blockHeader
+	var b Block
+	var timestamps []int64
+	var values [][]byte
+	for i := range p.metaindex {
+		mr := &p.metaindex[i]
+		if mr.MaxTimestamp < tr.MinTimestamp || mr.MinTimestamp > tr.MaxTimestamp {
+			continue
+		}
+		bhs, err = p.readBlockHeaders(bhs[:0], mr)
+		if err != nil {
+			return fmt.Errorf("cannot read block headers for metaindexRow #%d: %w", i, err)
+		}
+		for j := range bhs {
+			bh := &bhs[j]
+			if bh.TSID.AccountID != accountID || bh.TSID.ProjectID != projectID {
+				continue
+			}
+			metricName := metricNames[bh.TSID.MetricID]
+			if metricName == nil || bh.MaxTimestamp < tr.MinTimestamp || bh.MinTimestamp > tr.MaxTimestamp {
+				continue
+			}
+			br := BlockRef{
+				p:  p,
+				bh: *bh,
+			}
+			br.MustReadBlock(&b, FetchAll)
+			if err := b.UnmarshalData(true); err != nil {
+				return fmt.Errorf("cannot unmarshal block #%d in metaindexRow #%d for TSID %+v: %w", j, i, &bh.TSID, err)
+			}
+			timestamps, values = b.AppendRowsWithTimeRangeFilter(timestamps[:0], values[:0], tr)
+			if len(timestamps) == 0 {
+				continue
+			}
+			if err := f(metricName, timestamps, values); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
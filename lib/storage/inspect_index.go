@@ -0,0 +1,374 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+)
+
+// This file contains read-only access to indexdb parts for offline inspection.
+//
+// lib/mergeset doesn't provide a way to read parts without opening the table,
+// which starts background merges, so the part format is decoded here.
+
+// Marshal types for mergeset blocks. See lib/mergeset/encoding.go.
+const (
+	indexItemsMarshalTypePlain = 0
+	indexItemsMarshalTypeZSTD  = 1
+)
+
+// searchMetricNamesReadOnly returns marshaled MetricName per metricID for streams matching tfss
+// in indexdb at idbPath.
+//
+// Deleted metricIDs are skipped. Up to maxMetrics metric names are returned if maxMetrics > 0.
+func searchMetricNamesReadOnly(idbPath string, tfss []*TagFilters, maxMetrics int) (map[uint64][]byte, error) {
+	if len(tfss) == 0 {
+		return nil, nil
+	}
+	partPaths, err := listIndexDBParts(idbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	deletedPrefix := []byte{nsPrefixDeletedMetricID}
+	deletedMetricIDs := make(map[uint64]bool)
+	for _, partPath := range partPaths {
+		err := readIndexItems(partPath, deletedPrefix, func(item []byte) error {
+			tail := item[len(deletedPrefix):]
+			if len(tail) != 8 {
+				return fmt.Errorf("unexpected deleted metricID item len; got %d bytes; want %d bytes", len(tail), 8)
+			}
+			deletedMetricIDs[encoding.UnmarshalUint64(tail)] = true
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cannot read deleted metricIDs from %q: %w", partPath, err)
+		}
+	}
+
+	tfsPtrss := make([][]*tagFilter, len(tfss))
+	for i, tfs := range tfss {
+		for j := range tfs.tfs {
+			tfsPtrss[i] = append(tfsPtrss[i], &tfs.tfs[j])
+		}
+	}
+	namePrefix := marshalCommonPrefix(nil, nsPrefixMetricIDToMetricName, tfss[0].accountID, tfss[0].projectID)
+	metricNames := make(map[uint64][]byte)
+	var mn MetricName
+	var kb bytesutil.ByteBuffer
+	for _, partPath := range partPaths {
+		err := readIndexItems(partPath, namePrefix, func(item []byte) error {
+			tail := item[len(namePrefix):]
+			if len(tail) < 8 {
+				return fmt.Errorf("cannot unmarshal metricID from %d bytes; need at least %d bytes", len(tail), 8)
+			}
+			metricID := encoding.UnmarshalUint64(tail)
+			metricName := tail[8:]
+			if deletedMetricIDs[metricID] || metricNames[metricID] != nil {
+				return nil
+			}
+			if maxMetrics > 0 && len(metricNames) >= maxMetrics {
+				return nil
+			}
+			if err := mn.Unmarshal(metricName); err != nil {
+				return fmt.Errorf("cannot unmarshal MetricName for metricID=%d: %w", metricID, err)
+			}
+			for _, tfsPtrs := range tfsPtrss {
+				ok, err := matchTagFilters(&mn, tfsPtrs, &kb)
+				if err != nil {
+					return fmt.Errorf("cannot match MetricName %s against tag filters: %w", &mn, err)
+				}
+				if ok {
+					metricNames[metricID] = append([]byte{}, metricName...)
+					break
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cannot read metric names from %q: %w", partPath, err)
+		}
+	}
+	return metricNames, nil
+}
+
+// listIndexDBParts returns paths to parts of all the indexdb generations at idbPath.
+func listIndexDBParts(idbPath string) ([]string, error) {
+	tableNames, err := readDirNames(idbPath)
+	if err != nil {
+		return nil, err
+	}
+	var partPaths []string
+	for _, tableName := range tableNames {
+		if tableName == "snapshots" {
+			continue
+		}
+		tablePath := idbPath + "/" + tableName
+		partNames, err := readDirNames(tablePath)
+		if err != nil {
+			return nil, err
+		}
+		for _, partName := range partNames {
+			if partName == "tmp" || partName == "txn" || partName == "snapshots" {
+				// Skip special dirs.
+				continue
+			}
+			partPaths = append(partPaths, tablePath+"/"+partName)
+		}
+	}
+	return partPaths, nil
+}
+
+// readDirNames returns names of sub-directories at path.
+func readDirNames(path string) ([]string, error) {
+	d, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open directory %q: %w", path, err)
+	}
+	fis, err := d.Readdir(-1)
+	fs.MustClose(d)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read directory %q: %w", path, err)
+	}
+	var names []string
+	for _, fi := range fis {
+		if fs.IsDirOrSymlink(fi) {
+			names = append(names, fi.Name())
+		}
+	}
+	return names, nil
+}
+
+// readIndexItems calls f for every item with the given prefix in the indexdb part at partPath.
+//
+// The part files are only read.
+func readIndexItems(partPath string, prefix []byte, f func(item []byte) error) error {
+	compressedMetaindex, err := ioutil.ReadFile(partPath + "/metaindex.bin")
+	if err != nil {
+		return err
+	}
+	metaindex, err := encoding.DecompressZSTD(nil, compressedMetaindex)
+	if err != nil {
+		return fmt.Errorf("cannot decompress metaindex: %w", err)
+	}
+	var mrs []indexMetaindexRow
+	for len(metaindex) > 0 {
+		var mr indexMetaindexRow
+		metaindex, err = mr.unmarshal(metaindex)
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal metaindex row #%d: %w", len(mrs), err)
+		}
+		mrs = append(mrs, mr)
+	}
+
+	indexFile, err := os.Open(partPath + "/index.bin")
+	if err != nil {
+		return err
+	}
+	defer fs.MustClose(indexFile)
+	itemsFile, err := os.Open(partPath + "/items.bin")
+	if err != nil {
+		return err
+	}
+	defer fs.MustClose(itemsFile)
+	lensFile, err := os.Open(partPath + "/lens.bin")
+	if err != nil {
+		return err
+	}
+	defer fs.MustClose(lensFile)
+
+	var bh indexBlockHeader
+	var items [][]byte
+	for i := range mrs {
+		// Metaindex rows are sorted by the first item, so the row may contain items with the prefix
+		// only if the next row starts at or after the prefix.
+		if i+1 < len(mrs) && string(mrs[i+1].firstItem) < string(prefix) {
+			continue
+		}
+		mr := &mrs[i]
+		if string(mr.firstItem) > string(prefix) && !bytes.HasPrefix(mr.firstItem, prefix) {
+			return nil
+		}
+		indexData, err := readAtZSTD(indexFile, mr.indexBlockOffset, mr.indexBlockSize)
+		if err != nil {
+			return fmt.Errorf("cannot read index block for metaindex row #%d: %w", i, err)
+		}
+		for j := uint32(0); j < mr.blockHeadersCount; j++ {
+			indexData, err = bh.unmarshal(indexData)
+			if err != nil {
+				return fmt.Errorf("cannot unmarshal block header #%d for metaindex row #%d: %w", j, i, err)
+			}
+			if string(bh.firstItem) > string(prefix) && !bytes.HasPrefix(bh.firstItem, prefix) {
+				return nil
+			}
+			items, err = readIndexBlockItems(items[:0], itemsFile, lensFile, &bh)
+			if err != nil {
+				return fmt.Errorf("cannot read items for block header #%d in metaindex row #%d: %w", j, i, err)
+			}
+			for _, item := range items {
+				if !bytes.HasPrefix(item, prefix) {
+					continue
+				}
+				if err := f(item); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// readIndexBlockItems appends items from the block referred by bh to dst.
+func readIndexBlockItems(dst [][]byte, itemsFile, lensFile *os.File, bh *indexBlockHeader) ([][]byte, error) {
+	itemsData := make([]byte, bh.itemsBlockSize)
+	if _, err := itemsFile.ReadAt(itemsData, int64(bh.itemsBlockOffset)); err != nil {
+		return dst, fmt.Errorf("cannot read items block: %w", err)
+	}
+	lensData := make([]byte, bh.lensBlockSize)
+	if _, err := lensFile.ReadAt(lensData, int64(bh.lensBlockOffset)); err != nil {
+		return dst, fmt.Errorf("cannot read lens block: %w", err)
+	}
+	itemsCount := int(bh.itemsCount)
+	lens := make([]uint64, itemsCount)
+	prefixLens := make([]uint64, itemsCount)
+	lens[0] = uint64(len(bh.firstItem) - len(bh.commonPrefix))
+
+	switch bh.marshalType {
+	case indexItemsMarshalTypePlain:
+		for i := 1; i < itemsCount; i++ {
+			if len(lensData) < 8 {
+				return dst, fmt.Errorf("too short lensData; got %d bytes; want at least %d bytes", len(lensData), 8)
+			}
+			lens[i] = encoding.UnmarshalUint64(lensData)
+			lensData = lensData[8:]
+		}
+	case indexItemsMarshalTypeZSTD:
+		data, err := encoding.DecompressZSTD(nil, lensData)
+		if err != nil {
+			return dst, fmt.Errorf("cannot decompress lensData: %w", err)
+		}
+		xs := make([]uint64, itemsCount-1)
+		data, err = encoding.UnmarshalVarUint64s(xs, data)
+		if err != nil {
+			return dst, fmt.Errorf("cannot unmarshal prefixLens: %w", err)
+		}
+		for i, x := range xs {
+			prefixLens[i+1] = x ^ prefixLens[i]
+		}
+		data, err = encoding.UnmarshalVarUint64s(xs, data)
+		if err != nil {
+			return dst, fmt.Errorf("cannot unmarshal lens: %w", err)
+		}
+		for i, x := range xs {
+			lens[i+1] = x ^ lens[i]
+		}
+		lensData = data
+		itemsData, err = encoding.DecompressZSTD(nil, itemsData)
+		if err != nil {
+			return dst, fmt.Errorf("cannot decompress itemsData: %w", err)
+		}
+	default:
+		return dst, fmt.Errorf("unknown marshalType=%d", bh.marshalType)
+	}
+	if len(lensData) > 0 {
+		return dst, fmt.Errorf("unexpected tail left after lensData with len %d", len(lensData))
+	}
+
+	dst = append(dst, append([]byte{}, bh.firstItem...))
+	prevSuffix := bh.firstItem[len(bh.commonPrefix):]
+	for i := 1; i < itemsCount; i++ {
+		// Items in plain blocks have zero prefixLens, so they are stored without the common part with the previous item.
+		suffixLen := lens[i] - prefixLens[i]
+		if prefixLens[i] > lens[i] || prefixLens[i] > uint64(len(prevSuffix)) || suffixLen > uint64(len(itemsData)) {
+			return dst, fmt.Errorf("invalid item #%d in block with %d items", i, itemsCount)
+		}
+		item := make([]byte, 0, len(bh.commonPrefix)+int(lens[i]))
+		item = append(item, bh.commonPrefix...)
+		item = append(item, prevSuffix[:prefixLens[i]]...)
+		item = append(item, itemsData[:suffixLen]...)
+		itemsData = itemsData[suffixLen:]
+		dst = append(dst, item)
+		prevSuffix = item[len(bh.commonPrefix):]
+	}
+	if len(itemsData) > 0 {
+		return dst, fmt.Errorf("unexpected tail left after itemsData with len %d", len(itemsData))
+	}
+	return dst, nil
+}
+
+func readAtZSTD(f *os.File, offset uint64, size uint32) ([]byte, error) {
+	compressedData := make([]byte, size)
+	if _, err := f.ReadAt(compressedData, int64(offset)); err != nil {
+		return nil, err
+	}
+	return encoding.DecompressZSTD(nil, compressedData)
+}
+
+// indexMetaindexRow is a metaindex row of indexdb part. See lib/mergeset/metaindex_row.go.
+type indexMetaindexRow struct {
+	firstItem         []byte
+	blockHeadersCount uint32
+	indexBlockOffset  uint64
+	indexBlockSize    uint32
+}
+
+func (mr *indexMetaindexRow) unmarshal(src []byte) ([]byte, error) {
+	tail, firstItem, err := encoding.UnmarshalBytes(src)
+	if err != nil {
+		return tail, fmt.Errorf("cannot unmarshal firstItem: %w", err)
+	}
+	if len(tail) < 16 {
+		return tail, fmt.Errorf("cannot unmarshal metaindex row from %d bytes; need at least %d bytes", len(tail), 16)
+	}
+	mr.firstItem = append(mr.firstItem[:0], firstItem...)
+	mr.blockHeadersCount = encoding.UnmarshalUint32(tail)
+	mr.indexBlockOffset = encoding.UnmarshalUint64(tail[4:])
+	mr.indexBlockSize = encoding.UnmarshalUint32(tail[12:])
+	return tail[16:], nil
+}
+
+// indexBlockHeader is a block header of indexdb part. See lib/mergeset/block_header.go.
+type indexBlockHeader struct {
+	commonPrefix     []byte
+	firstItem        []byte
+	marshalType      byte
+	itemsCount       uint32
+	itemsBlockOffset uint64
+	lensBlockOffset  uint64
+	itemsBlockSize   uint32
+	lensBlockSize    uint32
+}
+
+func (bh *indexBlockHeader) unmarshal(src []byte) ([]byte, error) {
+	tail, commonPrefix, err := encoding.UnmarshalBytes(src)
+	if err != nil {
+		return tail, fmt.Errorf("cannot unmarshal commonPrefix: %w", err)
+	}
+	tail, firstItem, err := encoding.UnmarshalBytes(tail)
+	if err != nil {
+		return tail, fmt.Errorf("cannot unmarshal firstItem: %w", err)
+	}
+	if len(tail) < 29 {
+		return tail, fmt.Errorf("cannot unmarshal block header from %d bytes; need at least %d bytes", len(tail), 29)
+	}
+	bh.commonPrefix = append(bh.commonPrefix[:0], commonPrefix...)
+	bh.firstItem = append(bh.firstItem[:0], firstItem...)
+	bh.marshalType = tail[0]
+	bh.itemsCount = encoding.UnmarshalUint32(tail[1:])
+	bh.itemsBlockOffset = encoding.UnmarshalUint64(tail[5:])
+	bh.lensBlockOffset = encoding.UnmarshalUint64(tail[13:])
+	bh.itemsBlockSize = encoding.UnmarshalUint32(tail[21:])
+	bh.lensBlockSize = encoding.UnmarshalUint32(tail[25:])
+	if bh.itemsCount == 0 {
+		return tail, fmt.Errorf("itemsCount must be bigger than 0")
+	}
+	if len(bh.firstItem) < len(bh.commonPrefix) {
+		return tail, fmt.Errorf("firstItem cannot be shorter than commonPrefix; got %d vs %d bytes", len(bh.firstItem), len(bh.commonPrefix))
+	}
+	return tail[29:], nil
+}
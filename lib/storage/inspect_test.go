@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/encodingext"
+)
+
+func TestListPartsAndVerifyPart(t *testing.T) {
+	path := "TestListPartsAndVerifyPart"
+	s, err := OpenStorage(path, 0)
+	if err != nil {
+		t.Fatalf("cannot open storage: %s", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(path); err != nil {
+			t.Fatalf("cannot remove %q: %s", path, err)
+		}
+	}()
+
+	const rowsCount = 1000
+	var mrs []MetricRow
+	var mn MetricName
+	mn.Tags = []Tag{
+		{[]byte("job"), []byte("webservice")},
+	}
+	for i := 0; i < rowsCount; i++ {
+		mn.MetricGroup = []byte(fmt.Sprintf("metric_%d", i%10))
+		mrs = append(mrs, MetricRow{
+			MetricNameRaw: mn.marshalRaw(nil),
+			Timestamp:     int64(i) * 1000,
+			Value:         []byte(fmt.Sprintf("line %d", i)),
+		})
+	}
+	if err := s.AddRows(mrs, defaultPrecisionBits); err != nil {
+		t.Fatalf("cannot add rows: %s", err)
+	}
+	s.MustClose()
+
+	pis, err := ListParts(path)
+	if err != nil {
+		t.Fatalf("cannot list parts: %s", err)
+	}
+	if len(pis) == 0 {
+		t.Fatalf("expecting non-empty list of parts")
+	}
+	rowsFound := uint64(0)
+	for _, pi := range pis {
+		rowsFound += pi.RowsCount
+		pvr := VerifyPart(pi.Path, 10)
+		if pvr.IsCorrupted() {
+			t.Fatalf("unexpected errors for part %q: %s", pi.Path, pvr.Errors)
+		}
+		if pvr.RowsCount != pi.RowsCount {
+			t.Fatalf("unexpected number of verified rows for part %q; got %d; want %d", pi.Path, pvr.RowsCount, pi.RowsCount)
+		}
+	}
+	if rowsFound != rowsCount {
+		t.Fatalf("unexpected number of rows in parts; got %d; want %d", rowsFound, rowsCount)
+	}
+
+	// Dump rows for a single stream.
+	tfs := NewTagFilters(0, 0)
+	if err := tfs.Add(nil, []byte("metric_1"), false, false); err != nil {
+		t.Fatalf("cannot add tag filter: %s", err)
+	}
+	tr := TimeRange{
+		MinTimestamp: 0,
+		MaxTimestamp: rowsCount * 1000,
+	}
+	rowsDumped := 0
+	err = DumpRows(path, []*TagFilters{tfs}, tr, 0, func(metricName []byte, timestamps []int64, values [][]byte) error {
+		if err := mn.Unmarshal(metricName); err != nil {
+			return err
+		}
+		if string(mn.MetricGroup) != "metric_1" {
+			return fmt.Errorf("unexpected stream %s", &mn)
+		}
+		for i, ts := range timestamps {
+			if want := fmt.Sprintf("line %d", ts/1000); string(values[i]) != want {
+				return fmt.Errorf("unexpected line at %d; got %q; want %q", ts, values[i], want)
+			}
+		}
+		rowsDumped += len(timestamps)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cannot dump rows: %s", err)
+	}
+	if rowsDumped != rowsCount/10 {
+		t.Fatalf("unexpected number of dumped rows; got %d; want %d", rowsDumped, rowsCount/10)
+	}
+
+	// Truncate values.bin and verify the part is detected as corrupted.
+	valuesPath := pis[0].Path + "/values.bin"
+	if err := os.Truncate(valuesPath, 1); err != nil {
+		t.Fatalf("cannot truncate %q: %s", valuesPath, err)
+	}
+	pvr := VerifyPart(pis[0].Path, 10)
+	if !pvr.IsCorrupted() {
+		t.Fatalf("expecting corrupted part %q", pis[0].Path)
+	}
+}
+
+func TestVerifyPartMissingValuesDict(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 20000; i++ {
+		samples = append(samples, []byte(fmt.Sprintf(`level=info component=parser msg="processed request" request_id=%d duration=%dms`, i, i%100)))
+	}
+	data := encodingext.TrainDict(samples, 16*1024)
+	if len(data) == 0 {
+		t.Skipf("dictionary training isn't supported by the build")
+	}
+	d, err := encodingext.NewDict(data)
+	if err != nil {
+		t.Fatalf("cannot create dictionary: %s", err)
+	}
+
+	path := "TestVerifyPartMissingValuesDict"
+	defer func() {
+		if err := os.RemoveAll(path); err != nil {
+			t.Fatalf("cannot remove %q: %s", path, err)
+		}
+	}()
+	tmpPartPath := path + "/tmp"
+	var bsw blockStreamWriter
+	if err := bsw.InitFromFilePart(tmpPartPath, false, 0); err != nil {
+		t.Fatalf("cannot create part: %s", err)
+	}
+	bsw.SetValuesDict(d)
+	values := samples[:100]
+	timestamps := make([]int64, len(values))
+	for i := range timestamps {
+		timestamps[i] = int64(i) * 1000
+	}
+	var b Block
+	b.Init(&TSID{MetricID: 1}, timestamps, values, 64)
+	b.dict = d
+	var ph partHeader
+	var rowsMerged uint64
+	bsw.WriteExternalBlock(&b, &ph, &rowsMerged)
+	bsw.MustClose()
+	partPath := ph.Path(path, 1)
+	if err := os.Rename(tmpPartPath, partPath); err != nil {
+		t.Fatalf("cannot rename %q to %q: %s", tmpPartPath, partPath, err)
+	}
+
+	pvr := VerifyPart(partPath, 10)
+	if pvr.IsCorrupted() {
+		t.Fatalf("unexpected errors for part %q: %s", partPath, pvr.Errors)
+	}
+	if pvr.RowsCount != uint64(len(values)) {
+		t.Fatalf("unexpected number of verified rows; got %d; want %d", pvr.RowsCount, len(values))
+	}
+
+	dictPath := partPath + "/" + valuesDictFilename
+	if size := getPartFilesSize(partPath); size <= uint64(len(d.Data())) {
+		t.Fatalf("part size %d must include %q of size %d", size, dictPath, len(d.Data()))
+	}
+	if err := os.Remove(dictPath); err != nil {
+		t.Fatalf("cannot remove %q: %s", dictPath, err)
+	}
+	pvr = VerifyPart(partPath, 10)
+	if !pvr.IsCorrupted() {
+		t.Fatalf("expecting corrupted part %q without %q", partPath, dictPath)
+	}
+	if err := pvr.Errors[0]; !strings.Contains(err.Error(), valuesDictFilename) {
+		t.Fatalf("unexpected error for part without %q: %s", dictPath, err)
+	}
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+)
+
+// lineMetadataMarker is the first byte of row values containing log line with structured metadata.
+//
+// Lines starting with the marker are always stored with (possibly empty) metadata,
+// so row values remain unambiguous.
+const lineMetadataMarker = 0xff
+
+// MarshalLineWithMetadata appends row value for the given log line with structured metadata to dst
+// and returns the result.
+//
+// Structured metadata is a set of per-line labels, which aren't part of the stream identity,
+// so they aren't indexed. The line is stored as is if metadata is empty.
+//
+// The result must be unmarshaled with UnmarshalLineWithMetadata.
+func MarshalLineWithMetadata(dst, line []byte, metadata []Label) []byte {
+	labelsCount := 0
+	for i := range metadata {
+		if len(metadata[i].Value) > 0 {
+			labelsCount++
+		}
+	}
+	if labelsCount == 0 && (len(line) == 0 || line[0] != lineMetadataMarker && line[0] != numericValueMarker) {
+		return append(dst, line...)
+	}
+	if labelsCount > maxLabelsPerTimeseries {
+		labelsCount = maxLabelsPerTimeseries
+	}
+	dst = append(dst, lineMetadataMarker)
+	dst = encoding.MarshalVarUint64(dst, uint64(labelsCount))
+	for i := range metadata {
+		if labelsCount == 0 {
+			break
+		}
+		label := &metadata[i]
+		if len(label.Value) == 0 {
+			// Skip labels without values, since they have no sense.
+			continue
+		}
+		name := label.Name
+		if len(name) > maxLabelNameLen {
+			name = name[:maxLabelNameLen]
+		}
+		value := label.Value
+		if len(value) > maxLabelValueLen {
+			value = value[:maxLabelValueLen]
+		}
+		dst = encoding.MarshalBytes(dst, name)
+		dst = encoding.MarshalBytes(dst, value)
+		labelsCount--
+	}
+	return append(dst, line...)
+}
+
+// UnmarshalLineWithMetadata unmarshals log line and structured metadata from row value
+// marshaled with MarshalLineWithMetadata.
+//
+// Metadata labels are appended to dst. The returned line and labels refer to value,
+// so value mustn't be modified while they are in use.
+//
+// Numeric samples marshaled with MarshalNumericValue are returned as text lines.
+func UnmarshalLineWithMetadata(dst []Label, value []byte) ([]byte, []Label, error) {
+	if v, ok := UnmarshalNumericValue(value); ok {
+		return strconv.AppendFloat(nil, v, 'g', -1, 64), dst, nil
+	}
+	if len(value) == 0 || value[0] != lineMetadataMarker {
+		// Fast path - the value contains only log line.
+		return value, dst, nil
+	}
+	tail, labelsCount, err := encoding.UnmarshalVarUint64(value[1:])
+	if err != nil {
+		return nil, dst, fmt.Errorf("cannot unmarshal the number of structured metadata labels: %w", err)
+	}
+	for i := uint64(0); i < labelsCount; i++ {
+		var name, v []byte
+		tail, name, err = encoding.UnmarshalBytes(tail)
+		if err != nil {
+			return nil, dst, fmt.Errorf("cannot unmarshal structured metadata label name: %w", err)
+		}
+		tail, v, err = encoding.UnmarshalBytes(tail)
+		if err != nil {
+			return nil, dst, fmt.Errorf("cannot unmarshal structured metadata label value: %w", err)
+		}
+		dst = append(dst, Label{
+			Name:  name,
+			Value: v,
+		})
+	}
+	return tail, dst, nil
+}
+
+// escapeLegacyValues converts values from blocks with valuesFormatLegacy to valuesFormatTagged.
+//
+// Legacy values contain raw log lines, so lines starting with lineMetadataMarker or numericValueMarker
+// are converted to lines with empty metadata. Other lines remain as is.
+func escapeLegacyValues(values [][]byte) {
+	for i, v := range values {
+		if len(v) > 0 && (v[0] == lineMetadataMarker || v[0] == numericValueMarker) {
+			values[i] = MarshalLineWithMetadata(nil, v, nil)
+		}
+	}
+}
+
+// GetLine returns log line from row value marshaled with MarshalLineWithMetadata.
+//
+// The value is returned as is if it cannot be unmarshaled.
+func GetLine(value []byte) []byte {
+	line, _, err := UnmarshalLineWithMetadata(nil, value)
+	if err != nil {
+		return value
+	}
+	return line
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMarshalUnmarshalLineWithMetadata(t *testing.T) {
+	f := func(line string, metadata []Label, metadataExpected []Label) {
+		t.Helper()
+		data := MarshalLineWithMetadata(nil, []byte(line), metadata)
+		lineResult, metadataResult, err := UnmarshalLineWithMetadata(nil, data)
+		if err != nil {
+			t.Fatalf("cannot unmarshal line with metadata: %s", err)
+		}
+		if string(lineResult) != line {
+			t.Fatalf("unexpected line; got %q; want %q", lineResult, line)
+		}
+		if !reflect.DeepEqual(metadataResult, metadataExpected) {
+			t.Fatalf("unexpected metadata\ngot\n%q\nwant\n%q", metadataResult, metadataExpected)
+		}
+		if string(GetLine(data)) != line {
+			t.Fatalf("unexpected GetLine result; got %q; want %q", GetLine(data), line)
+		}
+	}
+
+	// Lines without metadata are stored as is.
+	f("", nil, nil)
+	f("foo bar", nil, nil)
+	if data := MarshalLineWithMetadata(nil, []byte("foo bar"), nil); string(data) != "foo bar" {
+		t.Fatalf("unexpected marshaled line without metadata: %q", data)
+	}
+	f("foo bar", []Label{{Name: []byte("empty")}}, nil)
+
+	// Lines with metadata.
+	f("foo bar", []Label{
+		{Name: []byte("trace_id"), Value: []byte("abc")},
+		{Name: []byte("empty")},
+		{Name: []byte("user"), Value: []byte("x")},
+	}, []Label{
+		{Name: []byte("trace_id"), Value: []byte("abc")},
+		{Name: []byte("user"), Value: []byte("x")},
+	})
+	f("", []Label{{Name: []byte("a"), Value: []byte("b")}}, []Label{{Name: []byte("a"), Value: []byte("b")}})
+
+	// Lines starting with the marker must be escaped.
+	f("\xffbinary", nil, nil)
+	if data := MarshalLineWithMetadata(nil, []byte("\xffbinary"), nil); string(data) == "\xffbinary" {
+		t.Fatalf("line starting with the metadata marker must be escaped")
+	}
+
+	f("\xfebinary", nil, nil)
+	if data := MarshalLineWithMetadata(nil, []byte("\xfe12345678"), nil); IsNumericValue(data) {
+		t.Fatalf("line starting with the numeric value marker must be escaped")
+	}
+
+	// Invalid data.
+	if _, _, err := UnmarshalLineWithMetadata(nil, []byte{lineMetadataMarker, 1, 5, 'a'}); err == nil {
+		t.Fatalf("expecting non-nil error for truncated metadata")
+	}
+}
+
+func TestEscapeLegacyValues(t *testing.T) {
+	lines := []string{"", "foo bar", "\xffbinary", "\xfe12345678", "a\xff"}
+	values := make([][]byte, len(lines))
+	for i, line := range lines {
+		values[i] = []byte(line)
+	}
+	escapeLegacyValues(values)
+	for i, v := range values {
+		line, metadata, err := UnmarshalLineWithMetadata(nil, v)
+		if err != nil {
+			t.Fatalf("cannot unmarshal escaped value for line %q: %s", lines[i], err)
+		}
+		if string(line) != lines[i] {
+			t.Fatalf("unexpected line; got %q; want %q", line, lines[i])
+		}
+		if len(metadata) > 0 {
+			t.Fatalf("unexpected metadata for line %q: %q", lines[i], metadata)
+		}
+		if IsNumericValue(v) {
+			t.Fatalf("unexpected numeric value for line %q", lines[i])
+		}
+	}
+}
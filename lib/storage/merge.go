@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"sync"
 
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/encodingext"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/uint64set"
 )
@@ -92,14 +93,18 @@ func mergeBlockStreamsInternal(ph *partHeader, bsw *blockStreamWriter, bsm *bloc
 			if bsm.Block.bh.TSID.Less(&pendingBlock.bh.TSID) {
 				logger.Panicf("BUG: the next TSID=%+v is smaller than the current TSID=%+v", &bsm.Block.bh.TSID, &pendingBlock.bh.TSID)
 			}
-			bsw.WriteExternalBlock(pendingBlock, ph, rowsMerged)
+			if err := writeBlock(bsw, pendingBlock, ph, rowsMerged); err != nil {
+				return err
+			}
 			pendingBlock.CopyFrom(bsm.Block)
 			continue
 		}
 		if pendingBlock.tooBig() && pendingBlock.bh.MaxTimestamp <= bsm.Block.bh.MinTimestamp {
 			// Fast path - pendingBlock is too big and it doesn't overlap with bsm.Block.
 			// Write the pendingBlock and then deal with bsm.Block.
-			bsw.WriteExternalBlock(pendingBlock, ph, rowsMerged)
+			if err := writeBlock(bsw, pendingBlock, ph, rowsMerged); err != nil {
+				return err
+			}
 			pendingBlock.CopyFrom(bsm.Block)
 			continue
 		}
@@ -130,14 +135,32 @@ func mergeBlockStreamsInternal(ph *partHeader, bsw *blockStreamWriter, bsm *bloc
 		tmpBlock.timestamps = tmpBlock.timestamps[:maxRowsPerBlock]
 		tmpBlock.values = tmpBlock.values[:maxRowsPerBlock]
 		tmpBlock.fixupTimestamps()
-		bsw.WriteExternalBlock(tmpBlock, ph, rowsMerged)
+		if err := writeBlock(bsw, tmpBlock, ph, rowsMerged); err != nil {
+			return err
+		}
 	}
 	if err := bsm.Error(); err != nil {
 		return fmt.Errorf("cannot read block to be merged: %w", err)
 	}
 	if pendingBlock != nil {
-		bsw.WriteExternalBlock(pendingBlock, ph, rowsMerged)
+		if err := writeBlock(bsw, pendingBlock, ph, rowsMerged); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeBlock writes b to bsw.
+//
+// b values are re-compressed if they were compressed with a dictionary other than bsw.valuesDict.
+func writeBlock(bsw *blockStreamWriter, b *Block, ph *partHeader, rowsMerged *uint64) error {
+	if len(b.values) == 0 && b.bh.ValuesMarshalType == encodingext.MarshalTypeZSTDDictBytesArray && !encodingext.SameDict(b.dict, bsw.valuesDict) {
+		if err := b.UnmarshalData(true); err != nil {
+			return fmt.Errorf("cannot unmarshal block for re-compression: %w", err)
+		}
 	}
+	b.dict = bsw.valuesDict
+	bsw.WriteExternalBlock(b, ph, rowsMerged)
 	return nil
 }
 
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"math"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+)
+
+// numericValueMarker is the first byte of row values containing numeric sample instead of log line.
+//
+// Such values are written by recording rules. Log lines starting with the marker are stored
+// with (possibly empty) structured metadata by MarshalLineWithMetadata, so row values remain unambiguous.
+const numericValueMarker = 0xfe
+
+// numericValueSize is the size of row value with numeric sample.
+const numericValueSize = 9
+
+// MarshalNumericValue appends row value for the numeric sample v to dst and returns the result.
+//
+// The result must be unmarshaled with UnmarshalNumericValue.
+func MarshalNumericValue(dst []byte, v float64) []byte {
+	dst = append(dst, numericValueMarker)
+	return encoding.MarshalUint64(dst, math.Float64bits(v))
+}
+
+// UnmarshalNumericValue returns numeric sample from row value marshaled with MarshalNumericValue.
+//
+// false is returned if value contains log line.
+func UnmarshalNumericValue(value []byte) (float64, bool) {
+	if len(value) != numericValueSize || value[0] != numericValueMarker {
+		return 0, false
+	}
+	return math.Float64frombits(encoding.UnmarshalUint64(value[1:])), true
+}
+
+// IsNumericValue returns true if value contains numeric sample.
+func IsNumericValue(value []byte) bool {
+	return len(value) == numericValueSize && value[0] == numericValueMarker
+}
+
+// getValuesFormat returns the format for the given row values in valuesFormatTagged.
+func getValuesFormat(values [][]byte) valuesFormat {
+	for _, v := range values {
+		if !IsNumericValue(v) {
+			return valuesFormatTagged
+		}
+	}
+	return valuesFormatNumeric
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMarshalUnmarshalNumericValue(t *testing.T) {
+	f := func(v float64, lineExpected string) {
+		t.Helper()
+		data := MarshalNumericValue(nil, v)
+		if !IsNumericValue(data) {
+			t.Fatalf("IsNumericValue must return true for %v", v)
+		}
+		vResult, ok := UnmarshalNumericValue(data)
+		if !ok {
+			t.Fatalf("cannot unmarshal numeric value %v", v)
+		}
+		if math.Float64bits(vResult) != math.Float64bits(v) {
+			t.Fatalf("unexpected value; got %v; want %v", vResult, v)
+		}
+		if line := string(GetLine(data)); line != lineExpected {
+			t.Fatalf("unexpected line; got %q; want %q", line, lineExpected)
+		}
+	}
+	f(0, "0")
+	f(-1.5, "-1.5")
+	f(1e20, "1e+20")
+	f(math.Inf(1), "+Inf")
+
+	// Log lines aren't numeric values.
+	for _, line := range []string{"", "foo", "123", "\xfe12345678"} {
+		data := MarshalLineWithMetadata(nil, []byte(line), nil)
+		if _, ok := UnmarshalNumericValue(data); ok {
+			t.Fatalf("unexpected numeric value for line %q", line)
+		}
+	}
+}
+
+func TestBlockIsNumeric(t *testing.T) {
+	f := func(values [][]byte, isNumericExpected bool) {
+		t.Helper()
+		timestamps := make([]int64, len(values))
+		for i := range timestamps {
+			timestamps[i] = int64(i) * 1000
+		}
+		var b Block
+		b.Init(&TSID{MetricID: 1}, timestamps, values, 64)
+		b.MarshalData(0, 0)
+
+		// The marker must be available in the block header sent to vmselect.
+		var b2 Block
+		if _, err := UnmarshalBlock(&b2, MarshalBlock(nil, &b)); err != nil {
+			t.Fatalf("cannot unmarshal block: %s", err)
+		}
+		if b2.IsNumeric() != isNumericExpected {
+			t.Fatalf("unexpected IsNumeric; got %v; want %v", b2.IsNumeric(), isNumericExpected)
+		}
+		if err := b2.UnmarshalData(true); err != nil {
+			t.Fatalf("cannot unmarshal block data: %s", err)
+		}
+		if len(b2.values) != len(values) {
+			t.Fatalf("unexpected number of values; got %d; want %d", len(b2.values), len(values))
+		}
+	}
+	f([][]byte{MarshalNumericValue(nil, 1), MarshalNumericValue(nil, 2)}, true)
+	f([][]byte{MarshalLineWithMetadata(nil, []byte("foo"), nil)}, false)
+	f([][]byte{MarshalNumericValue(nil, 1), MarshalLineWithMetadata(nil, []byte("foo"), nil)}, false)
+}
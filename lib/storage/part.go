@@ -7,6 +7,8 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/encodingext"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/filestream"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
@@ -47,6 +49,11 @@ type part struct {
 
 	metaindex []metaindexRow
 
+	// valuesDict is the dictionary for values blocks marshaled with encodingext.MarshalTypeZSTDDictBytesArray.
+	//
+	// It is nil if the part has no dictionary.
+	valuesDict *encodingext.Dict
+
 	ibCache *indexBlockCache
 }
 
@@ -93,8 +100,22 @@ func openFilePart(path string) (*part, error) {
 	}
 	metaindexSize := fs.MustFileSize(metaindexPath)
 
+	valuesDict, err := readValuesDict(path)
+	if err != nil {
+		timestampsFile.MustClose()
+		valuesFile.MustClose()
+		indexFile.MustClose()
+		metaindexFile.MustClose()
+		return nil, err
+	}
+
 	size := timestampsSize + valuesSize + indexSize + metaindexSize
-	return newPart(&ph, path, size, metaindexFile, timestampsFile, valuesFile, indexFile)
+	p, err := newPart(&ph, path, size, metaindexFile, timestampsFile, valuesFile, indexFile)
+	if err != nil {
+		return nil, err
+	}
+	p.valuesDict = valuesDict
+	return p, nil
 }
 
 // newPart returns new part initialized with the given arguments.
@@ -130,6 +151,24 @@ func newPart(ph *partHeader, path string, size uint64, metaindexReader filestrea
 	return &p, nil
 }
 
+// readBlockHeaders reads block headers for the index block referred by mr and appends them to dst.
+//
+// This function is intended for reading part contents outside the search path,
+// so it doesn't use ibCache.
+func (p *part) readBlockHeaders(dst []blockHeader, mr *metaindexRow) ([]blockHeader, error) {
+	compressedIndexBuf := make([]byte, mr.IndexBlockSize)
+	p.indexFile.MustReadAt(compressedIndexBuf, int64(mr.IndexBlockOffset))
+	indexBuf, err := encoding.DecompressZSTD(nil, compressedIndexBuf)
+	if err != nil {
+		return dst, fmt.Errorf("cannot decompress index block: %w", err)
+	}
+	dst, err = unmarshalBlockHeaders(dst, indexBuf, int(mr.BlockHeadersCount))
+	if err != nil {
+		return dst, fmt.Errorf("cannot unmarshal index block: %w", err)
+	}
+	return dst, nil
+}
+
 // String returns human-readable representation of p.
 func (p *part) String() string {
 	if len(p.path) > 0 {
@@ -15,6 +15,7 @@ import (
 	"time"
 	"unsafe"
 
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/encodingext"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
@@ -159,6 +160,12 @@ type partition struct {
 	// rawRows aren't used in search for performance reasons.
 	rawRows rawRowsShards
 
+	// valuesDict is the dictionary for compressing values in big parts.
+	//
+	// It is trained during the first big merge if SetValuesDictSize is called with non-zero size.
+	valuesDict     *encodingext.Dict
+	valuesDictLock sync.Mutex
+
 	snapshotLock sync.RWMutex
 
 	stopCh chan struct{}
@@ -272,6 +279,14 @@ func openPartition(smallPartsPath, bigPartsPath string, getDeletedMetricIDs func
 	pt := newPartition(name, smallPartsPath, bigPartsPath, getDeletedMetricIDs, retentionMsecs)
 	pt.smallParts = smallParts
 	pt.bigParts = bigParts
+	for _, pw := range bigParts {
+		if pw.p.valuesDict != nil {
+			// Continue using the dictionary from the existing big parts,
+			// so their blocks aren't re-compressed during subsequent merges.
+			pt.valuesDict = pw.p.valuesDict
+			break
+		}
+	}
 	if err := pt.tr.fromPartitionName(name); err != nil {
 		return nil, fmt.Errorf("cannot obtain partition time range from smallPartsPath %q: %w", smallPartsPath, err)
 	}
@@ -1119,6 +1134,9 @@ func (pt *partition) mergeParts(pws []*partWrapper, stopCh <-chan struct{}) erro
 	if err := bsw.InitFromFilePart(tmpPartPath, nocache, compressLevel); err != nil {
 		return fmt.Errorf("cannot create destination part %q: %w", tmpPartPath, err)
 	}
+	if isBigPart {
+		bsw.SetValuesDict(pt.getValuesDictForBigMerge(pws))
+	}
 
 	// Merge parts.
 	dmis := pt.getDeletedMetricIDs()
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+)
+
+// SampledBlock contains log lines sampled from a single block.
+//
+// Sampling bounds the amount of data sent to vmselect for approximate queries such as log pattern detection.
+type SampledBlock struct {
+	// MetricName is the marshaled MetricName for the stream.
+	MetricName []byte
+
+	// Timestamps contains timestamps for the sampled rows.
+	Timestamps []int64
+
+	// Weights contains the number of rows in the block represented by every sampled row.
+	//
+	// The sum of weights equals to the number of block rows on the search time range.
+	Weights []uint64
+
+	// Values contains raw values for the sampled rows.
+	Values [][]byte
+}
+
+// Reset resets sb.
+func (sb *SampledBlock) Reset() {
+	sb.MetricName = sb.MetricName[:0]
+	sb.Timestamps = sb.Timestamps[:0]
+	sb.Weights = sb.Weights[:0]
+	sb.Values = sb.Values[:0]
+}
+
+// Init initializes sb with up to maxRows rows from b on the given tr.
+//
+// Rows are sampled evenly across the block. b must be read with FetchAll.
+func (sb *SampledBlock) Init(metricName []byte, b *Block, tr TimeRange, maxRows int) error {
+	sb.Reset()
+	sb.MetricName = append(sb.MetricName, metricName...)
+	if err := b.UnmarshalData(true); err != nil {
+		return fmt.Errorf("cannot unmarshal block: %w", err)
+	}
+	timestamps, values := b.filterTimestamps(tr)
+	n := len(timestamps)
+	if maxRows <= 0 || n <= maxRows {
+		maxRows = n
+	}
+	for i := 0; i < maxRows; i++ {
+		start := i * n / maxRows
+		end := (i + 1) * n / maxRows
+		sb.Timestamps = append(sb.Timestamps, timestamps[start])
+		sb.Weights = append(sb.Weights, uint64(end-start))
+		sb.Values = append(sb.Values, values[start])
+	}
+	return nil
+}
+
+// AddWeight spreads rowsCount rows evenly across weights of the sampled rows in sb.
+//
+// This allows accounting for rows from blocks, which weren't read during sampling.
+func (sb *SampledBlock) AddWeight(rowsCount uint64) {
+	n := uint64(len(sb.Weights))
+	for i := range sb.Weights {
+		start := uint64(i) * rowsCount / n
+		end := uint64(i+1) * rowsCount / n
+		sb.Weights[i] += end - start
+	}
+}
+
+// RowsCount returns the number of sampled rows in sb.
+func (sb *SampledBlock) RowsCount() int {
+	return len(sb.Timestamps)
+}
+
+// Marshal appends marshaled sb to dst and returns the result.
+func (sb *SampledBlock) Marshal(dst []byte) []byte {
+	dst = encoding.MarshalBytes(dst, sb.MetricName)
+	dst = encoding.MarshalVarUint64(dst, uint64(len(sb.Timestamps)))
+	for i, timestamp := range sb.Timestamps {
+		dst = encoding.MarshalVarInt64(dst, timestamp)
+		dst = encoding.MarshalVarUint64(dst, sb.Weights[i])
+		dst = encoding.MarshalBytes(dst, sb.Values[i])
+	}
+	return dst
+}
+
+// Unmarshal unmarshals sb from src and returns the tail.
+//
+// sb doesn't refer to src after returning.
+func (sb *SampledBlock) Unmarshal(src []byte) ([]byte, error) {
+	sb.Reset()
+	tail, metricName, err := encoding.UnmarshalBytes(src)
+	if err != nil {
+		return tail, fmt.Errorf("cannot unmarshal MetricName: %w", err)
+	}
+	sb.MetricName = append(sb.MetricName, metricName...)
+	tail, rowsCount, err := encoding.UnmarshalVarUint64(tail)
+	if err != nil {
+		return tail, fmt.Errorf("cannot unmarshal rows count: %w", err)
+	}
+	for i := uint64(0); i < rowsCount; i++ {
+		var timestamp int64
+		tail, timestamp, err = encoding.UnmarshalVarInt64(tail)
+		if err != nil {
+			return tail, fmt.Errorf("cannot unmarshal timestamp: %w", err)
+		}
+		var weight uint64
+		tail, weight, err = encoding.UnmarshalVarUint64(tail)
+		if err != nil {
+			return tail, fmt.Errorf("cannot unmarshal weight: %w", err)
+		}
+		var value []byte
+		tail, value, err = encoding.UnmarshalBytes(tail)
+		if err != nil {
+			return tail, fmt.Errorf("cannot unmarshal value: %w", err)
+		}
+		sb.Timestamps = append(sb.Timestamps, timestamp)
+		sb.Weights = append(sb.Weights, weight)
+		sb.Values = append(sb.Values, append([]byte{}, value...))
+	}
+	return tail, nil
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestSampledBlockInit(t *testing.T) {
+	var timestamps []int64
+	var values [][]byte
+	for i := 0; i < 10; i++ {
+		timestamps = append(timestamps, int64(i*10))
+		values = append(values, []byte(fmt.Sprintf("line %d", i)))
+	}
+	f := func(tr TimeRange, maxRows int, timestampsExpected []int64, weightsExpected []uint64) {
+		t.Helper()
+		var b Block
+		b.Init(&TSID{}, timestamps, values, 64)
+		var sb SampledBlock
+		if err := sb.Init([]byte("foo"), &b, tr, maxRows); err != nil {
+			t.Fatalf("cannot init SampledBlock: %s", err)
+		}
+		if !reflect.DeepEqual(sb.Timestamps, timestampsExpected) {
+			t.Fatalf("unexpected timestamps; got %v; want %v", sb.Timestamps, timestampsExpected)
+		}
+		if !reflect.DeepEqual(sb.Weights, weightsExpected) {
+			t.Fatalf("unexpected weights; got %v; want %v", sb.Weights, weightsExpected)
+		}
+		for i, timestamp := range sb.Timestamps {
+			if valueExpected := fmt.Sprintf("line %d", timestamp/10); string(sb.Values[i]) != valueExpected {
+				t.Fatalf("unexpected value for timestamp %d; got %q; want %q", timestamp, sb.Values[i], valueExpected)
+			}
+		}
+	}
+	trAll := TimeRange{MinTimestamp: 0, MaxTimestamp: 100}
+
+	// All the rows fit maxRows
+	f(trAll, 10, timestamps, []uint64{1, 1, 1, 1, 1, 1, 1, 1, 1, 1})
+	f(trAll, 0, timestamps, []uint64{1, 1, 1, 1, 1, 1, 1, 1, 1, 1})
+
+	// Sampled rows
+	f(trAll, 3, []int64{0, 30, 60}, []uint64{3, 3, 4})
+	f(trAll, 1, []int64{0}, []uint64{10})
+
+	// Sampled rows on the time range
+	f(TimeRange{MinTimestamp: 25, MaxTimestamp: 65}, 2, []int64{30, 50}, []uint64{2, 2})
+
+	// No rows on the time range
+	f(TimeRange{MinTimestamp: 200, MaxTimestamp: 300}, 2, nil, nil)
+}
+
+func TestSampledBlockAddWeight(t *testing.T) {
+	f := func(weights []uint64, rowsCount uint64, weightsExpected []uint64) {
+		t.Helper()
+		sb := &SampledBlock{
+			Weights: append([]uint64{}, weights...),
+		}
+		sb.AddWeight(rowsCount)
+		if !reflect.DeepEqual(sb.Weights, weightsExpected) {
+			t.Fatalf("unexpected weights; got %v; want %v", sb.Weights, weightsExpected)
+		}
+	}
+	f([]uint64{}, 10, []uint64{})
+	f([]uint64{3}, 10, []uint64{13})
+	f([]uint64{3, 3, 4}, 0, []uint64{3, 3, 4})
+	f([]uint64{3, 3, 4}, 10, []uint64{6, 6, 8})
+	f([]uint64{1, 1, 1}, 2, []uint64{1, 2, 2})
+}
+
+func TestSampledBlockMarshalUnmarshal(t *testing.T) {
+	sb := &SampledBlock{
+		MetricName: []byte("foobar"),
+		Timestamps: []int64{-5, 10, 1 << 40},
+		Weights:    []uint64{1, 20, 3},
+		Values:     [][]byte{[]byte("foo"), {}, []byte("bar")},
+	}
+	data := sb.Marshal(nil)
+	var sb2 SampledBlock
+	tail, err := sb2.Unmarshal(data)
+	if err != nil {
+		t.Fatalf("cannot unmarshal SampledBlock: %s", err)
+	}
+	if len(tail) > 0 {
+		t.Fatalf("unexpected non-empty tail: %X", tail)
+	}
+	if !reflect.DeepEqual(sb, &sb2) {
+		t.Fatalf("unexpected SampledBlock\ngot\n%+v\nwant\n%+v", &sb2, sb)
+	}
+
+	// Unmarshal truncated data
+	for i := 0; i < len(data); i++ {
+		if _, err := sb2.Unmarshal(data[:i]); err == nil {
+			t.Fatalf("expecting non-nil error when unmarshaling %d bytes out of %d", i, len(data))
+		}
+	}
+}
@@ -4,11 +4,13 @@ import (
 	"fmt"
 	"io"
 
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/encodingext"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/storagepacelimiter"
+	"github.com/cespare/xxhash/v2"
 )
 
 // BlockRef references a Block.
@@ -36,6 +38,7 @@ func (br *BlockRef) init(p *part, bh *blockHeader) {
 func (br *BlockRef) MustReadBlock(dst *Block, fetchData uint8) {
 	dst.Reset()
 	dst.bh = br.bh
+	dst.dict = br.p.valuesDict
 
 	switch fetchData {
 	case OnlyFetchTime:
@@ -51,6 +54,16 @@ func (br *BlockRef) MustReadBlock(dst *Block, fetchData uint8) {
 	}
 }
 
+// RowsCount returns the number of rows in the block referenced by br without reading the block.
+func (br *BlockRef) RowsCount() int {
+	return int(br.bh.RowsCount)
+}
+
+// IsInTimeRange returns true if all the rows of the block referenced by br belong to tr.
+func (br *BlockRef) IsInTimeRange(tr TimeRange) bool {
+	return br.bh.MinTimestamp >= tr.MinTimestamp && br.bh.MaxTimestamp <= tr.MaxTimestamp
+}
+
 // MetricBlockRef contains reference to time series block for a single metric.
 type MetricBlockRef struct {
 	// The metric name
@@ -78,10 +91,19 @@ func (mb *MetricBlock) Marshal(dst []byte) []byte {
 // MarshalBlock marshals b to dst.
 //
 // b.MarshalData must be called on b before calling MarshalBlock.
+// Values compressed with zstd dictionary are marshaled together with the dictionary id,
+// so vmselect could unmarshal them after obtaining the dictionary via AddValuesDict.
 func MarshalBlock(dst []byte, b *Block) []byte {
 	dst = b.bh.Marshal(dst)
 	dst = encoding.MarshalBytes(dst, b.timestampsData)
 	dst = encoding.MarshalBytes(dst, b.valuesData)
+	if hasValuesDict(b) {
+		dictID := b.dictID
+		if b.dict != nil {
+			dictID = b.dict.ID()
+		}
+		dst = encoding.MarshalUint64(dst, dictID)
+	}
 	return dst
 }
 
@@ -101,6 +123,7 @@ func (mb *MetricBlock) Unmarshal(src []byte) ([]byte, error) {
 // UnmarshalBlock unmarshal Block from src to dst.
 //
 // dst.UnmarshalData isn't called on the block.
+// Block.MissingValuesDictID must be checked before calling dst.UnmarshalData.
 func UnmarshalBlock(dst *Block, src []byte) ([]byte, error) {
 	tail, err := dst.bh.Unmarshal(src)
 	if err != nil {
@@ -122,9 +145,36 @@ func UnmarshalBlock(dst *Block, src []byte) ([]byte, error) {
 	dst.valuesData = append(dst.valuesData[:0], vd...)
 	src = tail
 
+	dst.dict = nil
+	dst.dictID = 0
+	if hasValuesDict(dst) {
+		if len(src) < 8 {
+			return src, fmt.Errorf("cannot unmarshal values dictionary id from %d bytes; need at least 8 bytes", len(src))
+		}
+		dst.dictID = encoding.UnmarshalUint64(src)
+		dst.dict = valuesDicts.get(dst.dictID)
+		src = src[8:]
+	}
+
 	return src, nil
 }
 
+// hasValuesDict returns true if b contains values compressed with zstd dictionary.
+func hasValuesDict(b *Block) bool {
+	return len(b.valuesData) > 0 && b.bh.ValuesMarshalType == encodingext.MarshalTypeZSTDDictBytesArray
+}
+
+// MissingValuesDictID returns the id of the dictionary needed for unmarshaling b values obtained via UnmarshalBlock.
+//
+// Zero is returned if b values can be unmarshaled. Otherwise the dictionary with the returned id
+// must be registered via AddValuesDict and b must be unmarshaled again.
+func (b *Block) MissingValuesDictID() uint64 {
+	if b.dict != nil || !hasValuesDict(b) {
+		return 0
+	}
+	return b.dictID
+}
+
 // Search is a search for time series.
 type Search struct {
 	// MetricBlockRef is updated with each Search.NextMetricBlock call.
@@ -170,6 +220,13 @@ func (s *Search) reset() {
 //
 // Init returns the upper bound on the number of found time series.
 func (s *Search) Init(storage *Storage, tfss []*TagFilters, tr TimeRange, limit, maxMetrics int, deadline uint64) int {
+	return s.InitShard(storage, tfss, tr, limit, maxMetrics, 0, 1, deadline)
+}
+
+// InitShard is like Init, but leaves only time series from the shard shardIdx out of shardsCount shards.
+//
+// Time series from other shards are dropped before reading their blocks. See GetShard for details.
+func (s *Search) InitShard(storage *Storage, tfss []*TagFilters, tr TimeRange, limit, maxMetrics, shardIdx, shardsCount int, deadline uint64) int {
 	if s.needClosing {
 		logger.Panicf("BUG: missing MustClose call before the next call to Init")
 	}
@@ -182,7 +239,12 @@ func (s *Search) Init(storage *Storage, tfss []*TagFilters, tr TimeRange, limit,
 
 	tsids, err := storage.searchTSIDs(tfss, tr, limit, maxMetrics, deadline)
 	if err == nil {
-		err = storage.prefetchMetricNames(tsids, deadline)
+		if shardsCount > 1 {
+			// filterTSIDsByShard loads metricNames for all the tsids, so there is no need in prefetching them.
+			tsids, err = storage.filterTSIDsByShard(tsids, shardIdx, shardsCount, deadline)
+		} else {
+			err = storage.prefetchMetricNames(tsids, deadline)
+		}
 	}
 	// It is ok to call Init on error from storage.searchTSIDs.
 	// Init must be called before returning because it will fail
@@ -207,6 +269,43 @@ func (s *Search) MustClose() {
 	s.reset()
 }
 
+// GetShard returns shard index for the time series with the given marshaled metricName out of shardsCount shards.
+//
+// Time series are assigned to shards by the hash of their names, so every time series belongs to the same shard
+// on all the time ranges.
+func GetShard(metricName []byte, shardsCount int) int {
+	if shardsCount <= 1 {
+		return 0
+	}
+	return int(xxhash.Sum64(metricName) % uint64(shardsCount))
+}
+
+func (s *Storage) filterTSIDsByShard(tsids []TSID, shardIdx, shardsCount int, deadline uint64) ([]TSID, error) {
+	var metricName []byte
+	var err error
+	dst := tsids[:0]
+	for i := range tsids {
+		if i&paceLimiterSlowIterationsMask == 0 {
+			if err := checkSearchDeadlineAndPace(deadline); err != nil {
+				return nil, err
+			}
+		}
+		tsid := &tsids[i]
+		metricName, err = s.searchMetricName(metricName[:0], tsid.MetricID, tsid.AccountID, tsid.ProjectID)
+		if err != nil {
+			if err == io.EOF {
+				// Skip missing metricName for tsid.MetricID. It is skipped by Search.NextMetricBlock anyway.
+				continue
+			}
+			return nil, fmt.Errorf("cannot obtain metricName for metricID=%d: %w", tsid.MetricID, err)
+		}
+		if GetShard(metricName, shardsCount) == shardIdx {
+			dst = append(dst, *tsid)
+		}
+	}
+	return dst, nil
+}
+
 // Error returns the last error from s.
 func (s *Search) Error() error {
 	if s.err == io.EOF || s.err == nil {
@@ -270,6 +369,12 @@ type SearchQuery struct {
 	Limit        int64
 	Forward      bool
 	FetchData    FetchDataOption
+
+	// ShardIdx and ShardsCount limit the search to time series from the shard ShardIdx out of ShardsCount shards.
+	//
+	// The search isn't sharded if ShardsCount is 0 or 1.
+	ShardIdx    int
+	ShardsCount int
 }
 
 // TagFilter represents a single tag filter from SearchQuery.
@@ -357,6 +462,9 @@ func (sq *SearchQuery) String() string {
 		fmt.Fprintf(&bb, "\n")
 	}
 	fmt.Fprintf(&bb, "]")
+	if sq.ShardsCount > 1 {
+		fmt.Fprintf(&bb, ", Shard=%d_of_%d", sq.ShardIdx, sq.ShardsCount)
+	}
 	return string(bb.B)
 }
 
@@ -381,6 +489,8 @@ func (sq *SearchQuery) Marshal(dst []byte) []byte {
 	case true:
 		dst = append(dst, FetchAll+sq.FetchData)
 	}
+	dst = encoding.MarshalVarUint64(dst, uint64(sq.ShardIdx))
+	dst = encoding.MarshalVarUint64(dst, uint64(sq.ShardsCount))
 	return dst
 }
 
@@ -451,6 +561,9 @@ func (sq *SearchQuery) Unmarshal(src []byte) ([]byte, error) {
 	sq.Limit = limit
 	src = tail
 
+	if len(src) < 1 {
+		return src, fmt.Errorf("cannot unmarshal Forward+FetchData: too short src len: %d; must be at least 1 byte", len(src))
+	}
 	x := src[0]
 	if x <= FetchAll {
 		sq.Forward = false
@@ -461,6 +574,23 @@ func (sq *SearchQuery) Unmarshal(src []byte) ([]byte, error) {
 	}
 	src = src[1:]
 
+	tail, shardIdx, err := encoding.UnmarshalVarUint64(src)
+	if err != nil {
+		return src, fmt.Errorf("cannot unmarshal ShardIdx: %w", err)
+	}
+	src = tail
+
+	tail, shardsCount, err := encoding.UnmarshalVarUint64(src)
+	if err != nil {
+		return src, fmt.Errorf("cannot unmarshal ShardsCount: %w", err)
+	}
+	src = tail
+	if shardsCount > 1 && shardIdx >= shardsCount {
+		return src, fmt.Errorf("ShardIdx=%d must be smaller than ShardsCount=%d", shardIdx, shardsCount)
+	}
+	sq.ShardIdx = int(shardIdx)
+	sq.ShardsCount = int(shardsCount)
+
 	return src, nil
 }
 
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestGetShard(t *testing.T) {
+	const shardsCount = 4
+	seen := make(map[int]int)
+	for i := 0; i < 100; i++ {
+		metricName := []byte(fmt.Sprintf("foo_%d", i))
+		shardIdx := GetShard(metricName, shardsCount)
+		if shardIdx < 0 || shardIdx >= shardsCount {
+			t.Fatalf("unexpected shard for %q; got %d; want [0..%d)", metricName, shardIdx, shardsCount)
+		}
+		if n := GetShard(metricName, shardsCount); n != shardIdx {
+			t.Fatalf("unstable shard for %q; got %d; want %d", metricName, n, shardIdx)
+		}
+		seen[shardIdx]++
+
+		// Sharding is disabled
+		if n := GetShard(metricName, 1); n != 0 {
+			t.Fatalf("unexpected shard for %q without sharding; got %d; want 0", metricName, n)
+		}
+		if n := GetShard(metricName, 0); n != 0 {
+			t.Fatalf("unexpected shard for %q with zero shardsCount; got %d; want 0", metricName, n)
+		}
+	}
+	for shardIdx := 0; shardIdx < shardsCount; shardIdx++ {
+		if seen[shardIdx] == 0 {
+			t.Fatalf("unexpected empty shard #%d", shardIdx)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestStorageSearchTagsOnTimeRange(t *testing.T) {
+	path := "TestStorageSearchTagsOnTimeRange"
+	s, err := OpenStorage(path, 0)
+	if err != nil {
+		t.Fatalf("cannot open storage: %s", err)
+	}
+	defer func() {
+		s.MustClose()
+		if err := os.RemoveAll(path); err != nil {
+			t.Fatalf("cannot remove %q: %s", path, err)
+		}
+	}()
+
+	// Add streams with distinct labels on distinct days.
+	// Streams have empty metric group, which is returned as an empty tag key.
+	const days = 3
+	baseTimestamp := int64(msecPerDay * 18000)
+	var mrs []MetricRow
+	for day := 0; day < days; day++ {
+		labels := []Label{
+			{Name: []byte("app"), Value: []byte(fmt.Sprintf("app_%d", day))},
+			{Name: []byte(fmt.Sprintf("label_%d", day)), Value: []byte("x")},
+		}
+		mrs = append(mrs, MetricRow{
+			MetricNameRaw: MarshalMetricNameRaw(nil, 0, 0, labels),
+			Timestamp:     baseTimestamp + int64(day)*msecPerDay + 1000,
+			Value:         []byte("line"),
+		})
+	}
+	if err := s.AddRows(mrs, defaultPrecisionBits); err != nil {
+		t.Fatalf("cannot add rows: %s", err)
+	}
+	s.debugFlush()
+
+	searchTagKeys := func(tr TimeRange) []string {
+		t.Helper()
+		keys, err := s.SearchTagKeys(0, 0, tr, 1e5, noDeadline)
+		if err != nil {
+			t.Fatalf("cannot search tag keys on %s: %s", &tr, err)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+	searchTagValues := func(tr TimeRange) []string {
+		t.Helper()
+		values, err := s.SearchTagValues(0, 0, tr, []byte("app"), 1e5, noDeadline)
+		if err != nil {
+			t.Fatalf("cannot search tag values on %s: %s", &tr, err)
+		}
+		sort.Strings(values)
+		return values
+	}
+
+	// The whole retention.
+	keys := searchTagKeys(allTimeRange)
+	if !reflect.DeepEqual(keys, []string{"", "app", "label_0", "label_1", "label_2"}) {
+		t.Fatalf("unexpected tag keys on the whole retention: %q", keys)
+	}
+	values := searchTagValues(allTimeRange)
+	if !reflect.DeepEqual(values, []string{"app_0", "app_1", "app_2"}) {
+		t.Fatalf("unexpected tag values on the whole retention: %q", values)
+	}
+
+	// A single day.
+	tr := TimeRange{
+		MinTimestamp: baseTimestamp + msecPerDay,
+		MaxTimestamp: baseTimestamp + 2*msecPerDay - 1,
+	}
+	keys = searchTagKeys(tr)
+	if !reflect.DeepEqual(keys, []string{"", "app", "label_1"}) {
+		t.Fatalf("unexpected tag keys on %s: %q", &tr, keys)
+	}
+	values = searchTagValues(tr)
+	if !reflect.DeepEqual(values, []string{"app_1"}) {
+		t.Fatalf("unexpected tag values on %s: %q", &tr, values)
+	}
+
+	// Multiple days.
+	tr.MaxTimestamp += msecPerDay
+	keys = searchTagKeys(tr)
+	if !reflect.DeepEqual(keys, []string{"", "app", "label_1", "label_2"}) {
+		t.Fatalf("unexpected tag keys on %s: %q", &tr, keys)
+	}
+	values = searchTagValues(tr)
+	if !reflect.DeepEqual(values, []string{"app_1", "app_2"}) {
+		t.Fatalf("unexpected tag values on %s: %q", &tr, values)
+	}
+}
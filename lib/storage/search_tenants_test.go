@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestStorageSearchTenants(t *testing.T) {
+	path := "TestStorageSearchTenants"
+	s, err := OpenStorage(path, 0)
+	if err != nil {
+		t.Fatalf("cannot open storage: %s", err)
+	}
+	defer func() {
+		s.MustClose()
+		if err := os.RemoveAll(path); err != nil {
+			t.Fatalf("cannot remove %q: %s", path, err)
+		}
+	}()
+
+	tenants := []struct {
+		accountID uint32
+		projectID uint32
+	}{
+		{0, 0},
+		{1, 0},
+		{1, 2},
+		{1, math.MaxUint32},
+		{math.MaxUint32, math.MaxUint32},
+	}
+	var mrs []MetricRow
+	for _, tenant := range tenants {
+		// Add multiple streams per tenant in order to verify jumping to the next tenant.
+		for _, app := range []string{"foo", "bar"} {
+			labels := []Label{
+				{Name: []byte("app"), Value: []byte(app)},
+			}
+			mrs = append(mrs, MetricRow{
+				MetricNameRaw: MarshalMetricNameRaw(nil, tenant.accountID, tenant.projectID, labels),
+				Timestamp:     msecPerDay*18000 + 1000,
+				Value:         []byte("line"),
+			})
+		}
+	}
+	if err := s.AddRows(mrs, defaultPrecisionBits); err != nil {
+		t.Fatalf("cannot add rows: %s", err)
+	}
+	s.debugFlush()
+
+	result, err := s.SearchTenants(noDeadline)
+	if err != nil {
+		t.Fatalf("cannot search tenants: %s", err)
+	}
+	sort.Strings(result)
+	resultExpected := []string{"0:0", "1:0", "1:2", "1:4294967295", "4294967295:4294967295"}
+	if !reflect.DeepEqual(result, resultExpected) {
+		t.Fatalf("unexpected tenants\ngot\n%q\nwant\n%q", result, resultExpected)
+	}
+}
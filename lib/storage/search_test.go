@@ -29,6 +29,9 @@ func TestSearchQueryMarshalUnmarshal(t *testing.T) {
 			// Skip nil sq1.
 			continue
 		}
+		// ShardIdx must be smaller than ShardsCount.
+		sq1.ShardsCount = int(uint16(sq1.ShardsCount)) + 1
+		sq1.ShardIdx = int(uint(sq1.ShardIdx) % uint(sq1.ShardsCount))
 		buf = sq1.Marshal(buf[:0])
 
 		tail, err := sq2.Unmarshal(buf)
@@ -50,6 +53,9 @@ func TestSearchQueryMarshalUnmarshal(t *testing.T) {
 		if sq1.MaxTimestamp != sq2.MaxTimestamp {
 			t.Fatalf("unexpected MaxTimestamp; got %d; want %d", sq2.MaxTimestamp, sq1.MaxTimestamp)
 		}
+		if sq1.ShardIdx != sq2.ShardIdx || sq1.ShardsCount != sq2.ShardsCount {
+			t.Fatalf("unexpected shard; got %d_of_%d; want %d_of_%d", sq2.ShardIdx, sq2.ShardsCount, sq1.ShardIdx, sq1.ShardsCount)
+		}
 		if len(sq1.TagFilterss) != len(sq2.TagFilterss) {
 			t.Fatalf("unexpected TagFilterss len; got %d; want %d", len(sq2.TagFilterss), len(sq1.TagFilterss))
 		}
@@ -225,7 +231,7 @@ func testSearchInternal(st *Storage, tr TimeRange, mrs []MetricRow, accountsCoun
 		}
 
 		// Search
-		s.Init(st, []*TagFilters{tfs}, tr, 1e5, noDeadline)
+		s.Init(st, []*TagFilters{tfs}, tr, 1e5, 1e9, noDeadline)
 		var mbs []metricBlock
 		for s.NextMetricBlock() {
 			var b Block
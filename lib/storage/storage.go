@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -69,6 +70,12 @@ type Storage struct {
 	// metricNameCache is MetricID -> MetricName cache.
 	metricNameCache *workingsetcache.Cache
 
+	// streamLimiter enforces per-tenant limits on the number of streams.
+	streamLimiter *streamLimiter
+
+	// tailer pushes added rows to tail subscriptions.
+	tailer *tailer
+
 	// dateMetricIDCache is (Date, MetricID) cache.
 	dateMetricIDCache *dateMetricIDCache
 
@@ -134,6 +141,9 @@ func OpenStorage(path string, retentionMsecs int64) (*Storage, error) {
 		cachePath:       path + "/cache",
 		retentionMonths: int(retentionMonths),
 
+		streamLimiter: newStreamLimiter(),
+		tailer:        newTailer(),
+
 		stop: make(chan struct{}),
 	}
 
@@ -358,6 +368,16 @@ type Metrics struct {
 	TimestampsBlocksMerged uint64
 	TimestampsBytesSaved   uint64
 
+	ValuesDictsTrained      uint64
+	ValuesDictTrainFailures uint64
+
+	NewStreamsPerHourLimitRejectedRows uint64
+	NewStreamsPerDayLimitRejectedRows  uint64
+	ActiveStreamsLimitRejectedRows     uint64
+
+	TailSubscriptions uint64
+	TailDroppedRows   uint64
+
 	TSIDCacheSize       uint64
 	TSIDCacheSizeBytes  uint64
 	TSIDCacheRequests   uint64
@@ -427,6 +447,16 @@ func (s *Storage) UpdateMetrics(m *Metrics) {
 	m.TimestampsBlocksMerged = atomic.LoadUint64(&timestampsBlocksMerged)
 	m.TimestampsBytesSaved = atomic.LoadUint64(&timestampsBytesSaved)
 
+	m.ValuesDictsTrained = atomic.LoadUint64(&valuesDictsTrained)
+	m.ValuesDictTrainFailures = atomic.LoadUint64(&valuesDictTrainFailures)
+
+	m.NewStreamsPerHourLimitRejectedRows += atomic.LoadUint64(&s.streamLimiter.newPerHourRejectedRows)
+	m.NewStreamsPerDayLimitRejectedRows += atomic.LoadUint64(&s.streamLimiter.newPerDayRejectedRows)
+	m.ActiveStreamsLimitRejectedRows += atomic.LoadUint64(&s.streamLimiter.activeRejectedRows)
+
+	m.TailSubscriptions += uint64(len(s.tailer.getSubscriptions()))
+	m.TailDroppedRows += atomic.LoadUint64(&s.tailer.droppedRows)
+
 	var cs fastcache.Stats
 	s.tsidCache.UpdateStats(&cs)
 	m.TSIDCacheSize += cs.EntriesCount
@@ -990,14 +1020,23 @@ func (s *Storage) searchMetricName(dst []byte, metricID uint64, accountID, proje
 	return s.idb().searchMetricName(dst, metricID, accountID, projectID)
 }
 
-// SearchTagKeys searches for tag keys for the given (accountID, projectID).
-func (s *Storage) SearchTagKeys(accountID, projectID uint32, maxTagKeys int, deadline uint64) ([]string, error) {
-	return s.idb().SearchTagKeys(accountID, projectID, maxTagKeys, deadline)
+// SearchTenants returns all the tenants in the form `accountID:projectID`.
+func (s *Storage) SearchTenants(deadline uint64) ([]string, error) {
+	return s.idb().SearchTenants(deadline)
 }
 
-// SearchTagValues searches for tag values for the given tagKey in (accountID, projectID).
-func (s *Storage) SearchTagValues(accountID, projectID uint32, tagKey []byte, maxTagValues int, deadline uint64) ([]string, error) {
-	return s.idb().SearchTagValues(accountID, projectID, tagKey, maxTagValues, deadline)
+// SearchTagKeys searches for tag keys for the given (accountID, projectID) on the given tr.
+//
+// The per-day index is used if tr covers a limited number of days.
+func (s *Storage) SearchTagKeys(accountID, projectID uint32, tr TimeRange, maxTagKeys int, deadline uint64) ([]string, error) {
+	return s.idb().SearchTagKeys(accountID, projectID, tr, maxTagKeys, deadline)
+}
+
+// SearchTagValues searches for tag values for the given tagKey in (accountID, projectID) on the given tr.
+//
+// The per-day index is used if tr covers a limited number of days.
+func (s *Storage) SearchTagValues(accountID, projectID uint32, tr TimeRange, tagKey []byte, maxTagValues int, deadline uint64) ([]string, error) {
+	return s.idb().SearchTagValues(accountID, projectID, tr, tagKey, maxTagValues, deadline)
 }
 
 // SearchTagValueSuffixes returns all the tag value suffixes for the given tagKey and tagValuePrefix on the given tr.
@@ -1008,10 +1047,16 @@ func (s *Storage) SearchTagValueSuffixes(accountID, projectID uint32, tr TimeRan
 	return s.idb().SearchTagValueSuffixes(accountID, projectID, tr, tagKey, tagValuePrefix, delimiter, maxTagValueSuffixes, deadline)
 }
 
+// allTimeRange covers all the stored data, so searches on it use the global index instead of the per-day index.
+var allTimeRange = TimeRange{
+	MinTimestamp: 0,
+	MaxTimestamp: math.MaxInt64,
+}
+
 // SearchTagEntries returns a list of (tagName -> tagValues) for (accountID, projectID).
 func (s *Storage) SearchTagEntries(accountID, projectID uint32, maxTagKeys, maxTagValues int, deadline uint64) ([]TagEntry, error) {
 	idb := s.idb()
-	keys, err := idb.SearchTagKeys(accountID, projectID, maxTagKeys, deadline)
+	keys, err := idb.SearchTagKeys(accountID, projectID, allTimeRange, maxTagKeys, deadline)
 	if err != nil {
 		return nil, fmt.Errorf("cannot search tag keys: %w", err)
 	}
@@ -1021,7 +1066,7 @@ func (s *Storage) SearchTagEntries(accountID, projectID uint32, maxTagKeys, maxT
 
 	tes := make([]TagEntry, len(keys))
 	for i, key := range keys {
-		values, err := idb.SearchTagValues(accountID, projectID, []byte(key), maxTagValues, deadline)
+		values, err := idb.SearchTagValues(accountID, projectID, allTimeRange, []byte(key), maxTagValues, deadline)
 		if err != nil {
 			return nil, fmt.Errorf("cannot search values for tag %q: %w", key, err)
 		}
@@ -1242,6 +1287,13 @@ func (s *Storage) add(rows []rawRow, mrs []MetricRow, precisionBits uint8) ([]ra
 			// There is no need in checking whether r.TSID.MetricID is deleted, since tsidCache doesn't
 			// contain MetricName->TSID entries for deleted time series.
 			// See Storage.DeleteMetrics code for details.
+			if !s.streamLimiter.registerActiveStream(&r.TSID) {
+				if firstWarn == nil {
+					firstWarn = newActiveStreamsLimitError(&r.TSID)
+				}
+				j--
+				continue
+			}
 			prevTSID = r.TSID
 			prevMetricNameRaw = mr.MetricNameRaw
 			continue
@@ -1270,6 +1322,7 @@ func (s *Storage) add(rows []rawRow, mrs []MetricRow, precisionBits uint8) ([]ra
 			return string(pendingMetricRows[i].MetricName) < string(pendingMetricRows[j].MetricName)
 		})
 		is := idb.getIndexSearch(0, 0, noDeadline)
+		mayCreateStream := s.streamLimiter.registerNewStream
 		prevMetricNameRaw = nil
 		var slowInsertsCount uint64
 		for i := range pendingMetricRows {
@@ -1291,21 +1344,39 @@ func (s *Storage) add(rows []rawRow, mrs []MetricRow, precisionBits uint8) ([]ra
 				// There is no need in checking whether r.TSID.MetricID is deleted, since tsidCache doesn't
 				// contain MetricName->TSID entries for deleted time series.
 				// See Storage.DeleteMetrics code for details.
+				if !s.streamLimiter.registerActiveStream(&r.TSID) {
+					if firstWarn == nil {
+						firstWarn = newActiveStreamsLimitError(&r.TSID)
+					}
+					j--
+					continue
+				}
 				prevTSID = r.TSID
 				prevMetricNameRaw = mr.MetricNameRaw
 				continue
 			}
 			slowInsertsCount++
-			if err := is.GetOrCreateTSIDByName(&r.TSID, pmr.MetricName); err != nil {
+			if err := is.GetOrCreateTSIDByName(&r.TSID, pmr.MetricName, mayCreateStream); err != nil {
 				// Do not stop adding rows on error - just skip invalid row.
 				// This guarantees that invalid rows don't prevent
 				// from adding valid rows into the storage.
 				if firstWarn == nil {
+					if err == errTSIDCreationDenied {
+						err = fmt.Errorf("stream limits exceeded; see -storage.maxNewStreamsPerHour, -storage.maxNewStreamsPerDay " +
+							"and -storage.maxActiveStreams command-line flags")
+					}
 					firstWarn = fmt.Errorf("cannot obtain or create TSID for MetricName %q: %w", pmr.MetricName, err)
 				}
 				j--
 				continue
 			}
+			if !s.streamLimiter.registerActiveStream(&r.TSID) {
+				if firstWarn == nil {
+					firstWarn = newActiveStreamsLimitError(&r.TSID)
+				}
+				j--
+				continue
+			}
 			s.putTSIDToCache(&r.TSID, mr.MetricNameRaw)
 		}
 		idb.putIndexSearch(is)
@@ -1321,6 +1392,7 @@ func (s *Storage) add(rows []rawRow, mrs []MetricRow, precisionBits uint8) ([]ra
 	if err := s.tb.AddRows(rows); err != nil {
 		firstError = fmt.Errorf("cannot add rows to table: %w", err)
 	}
+	s.tailer.addRows(rows)
 	if err := s.updatePerDateData(rows); err != nil && firstError == nil {
 		firstError = fmt.Errorf("cannot update per-date data: %w", err)
 	}
@@ -1330,6 +1402,11 @@ func (s *Storage) add(rows []rawRow, mrs []MetricRow, precisionBits uint8) ([]ra
 	return rows, nil
 }
 
+func newActiveStreamsLimitError(tsid *TSID) error {
+	return fmt.Errorf("cannot add rows for new stream of tenant %d:%d, since it exceeds -storage.maxActiveStreams=%d",
+		tsid.AccountID, tsid.ProjectID, maxActiveStreams)
+}
+
 type pendingMetricRow struct {
 	MetricName []byte
 	mr         MetricRow
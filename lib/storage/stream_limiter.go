@@ -0,0 +1,376 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	xxhash "github.com/cespare/xxhash/v2"
+)
+
+// Per-tenant limits on the number of streams. Zero means no limit.
+var (
+	maxNewStreamsPerHour int
+	maxNewStreamsPerDay  int
+	maxActiveStreams     int
+)
+
+// SetStreamLimits sets per-tenant limits on the number of streams.
+//
+// newPerHour and newPerDay limit the number of new streams, which may be created per tenant
+// during the current hour and the current day. active limits the number of streams per tenant,
+// which received rows during the last hour. Zero value disables the corresponding limit.
+//
+// This function must be called before OpenStorage.
+func SetStreamLimits(newPerHour, newPerDay, active int) {
+	maxNewStreamsPerHour = newPerHour
+	maxNewStreamsPerDay = newPerDay
+	maxActiveStreams = active
+}
+
+const (
+	// activeStreamsWindow is the window in seconds for active streams.
+	// A stream is active if it received rows during the last activeStreamsWindow.
+	activeStreamsWindow = 3600
+
+	// activeStreamsCleanupInterval is the interval in seconds for removing streams,
+	// which didn't receive rows during the last activeStreamsWindow.
+	activeStreamsCleanupInterval = 60
+
+	// maxChurnLabelsPerTenant is the maximum number of label names tracked per tenant for new streams churn.
+	maxChurnLabelsPerTenant = 1000
+
+	// maxChurnValuesPerLabel is the maximum number of unique values tracked per label name.
+	maxChurnValuesPerLabel = 100000
+)
+
+// streamLimiter enforces per-tenant limits on the number of streams
+// and tracks labels of new streams.
+type streamLimiter struct {
+	// Atomic counters must go at the top of the structure in order to properly align by 8 bytes on 32-bit archs.
+	newPerHourRejectedRows uint64
+	newPerDayRejectedRows  uint64
+	activeRejectedRows     uint64
+
+	mu      sync.Mutex
+	tenants map[tenantKey]*tenantStreams
+
+	// activeStreams contains *tenantActiveStreams per tenantKey.
+	//
+	// It is a sync.Map, so rows for already active streams may be registered without global locks.
+	activeStreams sync.Map
+
+	// mn is used for unmarshaling new stream names under mu.
+	mn MetricName
+}
+
+type tenantKey struct {
+	accountID uint32
+	projectID uint32
+}
+
+// tenantStreams contains stream stats for a single tenant.
+type tenantStreams struct {
+	hour             uint64
+	newStreamsInHour int
+
+	day             uint64
+	newStreamsInDay int
+
+	// labels contains churn stats for labels of new streams created during the day.
+	labels map[string]*labelChurn
+}
+
+type labelChurn struct {
+	newStreams uint64
+	values     map[uint64]struct{}
+}
+
+func newStreamLimiter() *streamLimiter {
+	return &streamLimiter{
+		tenants: make(map[tenantKey]*tenantStreams),
+	}
+}
+
+func (sl *streamLimiter) getTenantLocked(accountID, projectID uint32) *tenantStreams {
+	k := tenantKey{
+		accountID: accountID,
+		projectID: projectID,
+	}
+	ts := sl.tenants[k]
+	if ts == nil {
+		ts = &tenantStreams{}
+		sl.tenants[k] = ts
+	}
+	hour := fasttime.UnixHour()
+	if ts.hour != hour {
+		ts.hour = hour
+		ts.newStreamsInHour = 0
+	}
+	day := fasttime.UnixDate()
+	if ts.day != day {
+		ts.day = day
+		ts.newStreamsInDay = 0
+		ts.labels = nil
+	}
+	return ts
+}
+
+// registerNewStream returns true if new stream with the given canonical metricName may be created.
+//
+// It is called from indexSearch.GetOrCreateTSIDByName before creating new TSID.
+func (sl *streamLimiter) registerNewStream(metricName []byte) bool {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	mn := &sl.mn
+	if err := mn.Unmarshal(metricName); err != nil {
+		logger.Panicf("BUG: cannot unmarshal canonical MetricName %q: %s", metricName, err)
+	}
+	ts := sl.getTenantLocked(mn.AccountID, mn.ProjectID)
+	if maxNewStreamsPerHour > 0 && ts.newStreamsInHour >= maxNewStreamsPerHour {
+		atomic.AddUint64(&sl.newPerHourRejectedRows, 1)
+		return false
+	}
+	if maxNewStreamsPerDay > 0 && ts.newStreamsInDay >= maxNewStreamsPerDay {
+		atomic.AddUint64(&sl.newPerDayRejectedRows, 1)
+		return false
+	}
+	if maxActiveStreams > 0 && sl.getActiveStreams(mn.AccountID, mn.ProjectID).len() >= maxActiveStreams {
+		atomic.AddUint64(&sl.activeRejectedRows, 1)
+		return false
+	}
+	ts.newStreamsInHour++
+	ts.newStreamsInDay++
+
+	if len(mn.MetricGroup) > 0 {
+		ts.registerLabel("__name__", mn.MetricGroup)
+	}
+	for i := range mn.Tags {
+		tag := &mn.Tags[i]
+		ts.registerLabel(string(tag.Key), tag.Value)
+	}
+	return true
+}
+
+func (ts *tenantStreams) registerLabel(name string, value []byte) {
+	if ts.labels == nil {
+		ts.labels = make(map[string]*labelChurn)
+	}
+	lc := ts.labels[name]
+	if lc == nil {
+		if len(ts.labels) >= maxChurnLabelsPerTenant {
+			return
+		}
+		lc = &labelChurn{
+			values: make(map[uint64]struct{}),
+		}
+		ts.labels[name] = lc
+	}
+	lc.newStreams++
+	if len(lc.values) < maxChurnValuesPerLabel {
+		lc.values[xxhash.Sum64(value)] = struct{}{}
+	}
+}
+
+// registerActiveStream returns true if rows for the given tsid may be added.
+func (sl *streamLimiter) registerActiveStream(tsid *TSID) bool {
+	if maxActiveStreams <= 0 {
+		return true
+	}
+	tas := sl.getActiveStreams(tsid.AccountID, tsid.ProjectID)
+	if !tas.register(tsid.MetricID, fasttime.UnixTimestamp()) {
+		atomic.AddUint64(&sl.activeRejectedRows, 1)
+		return false
+	}
+	return true
+}
+
+func (sl *streamLimiter) getActiveStreams(accountID, projectID uint32) *tenantActiveStreams {
+	k := tenantKey{
+		accountID: accountID,
+		projectID: projectID,
+	}
+	v, ok := sl.activeStreams.Load(k)
+	if !ok {
+		v, _ = sl.activeStreams.LoadOrStore(k, &tenantActiveStreams{})
+	}
+	return v.(*tenantActiveStreams)
+}
+
+// tenantActiveStreams contains streams, which received rows during the last activeStreamsWindow, for a single tenant.
+type tenantActiveStreams struct {
+	// count is the number of streams in streams. It is updated atomically.
+	count uint64
+
+	// streams maps MetricID to *uint64 with the last unix timestamp in seconds when the stream received rows.
+	//
+	// It is a sync.Map, so already active streams are registered without locking.
+	streams sync.Map
+
+	// mu serializes adding new streams and removing inactive streams.
+	mu          sync.Mutex
+	lastCleanup uint64
+}
+
+// register registers rows for the stream with the given metricID at the given unix timestamp in seconds.
+//
+// false is returned if the stream isn't active and the limit on active streams is reached.
+func (tas *tenantActiveStreams) register(metricID, now uint64) bool {
+	if v, ok := tas.streams.Load(metricID); ok {
+		// Fast path - the stream is already active.
+		updateLastSeen(v.(*uint64), now)
+		return true
+	}
+
+	tas.mu.Lock()
+	defer tas.mu.Unlock()
+
+	if v, ok := tas.streams.Load(metricID); ok {
+		updateLastSeen(v.(*uint64), now)
+		return true
+	}
+	tas.removeInactiveLocked(now)
+	if tas.len() >= maxActiveStreams {
+		return false
+	}
+	lastSeen := now
+	tas.streams.Store(metricID, &lastSeen)
+	atomic.AddUint64(&tas.count, 1)
+	return true
+}
+
+func updateLastSeen(lastSeen *uint64, now uint64) {
+	// Avoid writing to the shared memory on every row.
+	if atomic.LoadUint64(lastSeen) != now {
+		atomic.StoreUint64(lastSeen, now)
+	}
+}
+
+// removeInactiveLocked removes streams, which didn't receive rows during the last activeStreamsWindow.
+//
+// Streams are removed at most once per activeStreamsCleanupInterval.
+func (tas *tenantActiveStreams) removeInactiveLocked(now uint64) {
+	if now < tas.lastCleanup+activeStreamsCleanupInterval {
+		return
+	}
+	tas.lastCleanup = now
+	tas.streams.Range(func(k, v interface{}) bool {
+		if atomic.LoadUint64(v.(*uint64))+activeStreamsWindow <= now {
+			tas.streams.Delete(k)
+			atomic.AddUint64(&tas.count, ^uint64(0))
+		}
+		return true
+	})
+}
+
+// getActiveCount removes inactive streams and returns the number of active streams.
+func (tas *tenantActiveStreams) getActiveCount(now uint64) int {
+	tas.mu.Lock()
+	tas.removeInactiveLocked(now)
+	tas.mu.Unlock()
+	return tas.len()
+}
+
+// len returns the number of active streams.
+func (tas *tenantActiveStreams) len() int {
+	return int(atomic.LoadUint64(&tas.count))
+}
+
+// TenantStreamChurn contains new streams stats for a single tenant.
+type TenantStreamChurn struct {
+	AccountID uint32 `json:"accountID"`
+	ProjectID uint32 `json:"projectID"`
+
+	// NewStreamsHour is the number of new streams created during the current hour.
+	NewStreamsHour int `json:"newStreamsHour"`
+
+	// NewStreamsDay is the number of new streams created during the current day.
+	NewStreamsDay int `json:"newStreamsDay"`
+
+	// ActiveStreams is the number of streams with rows during the last hour.
+	//
+	// It is tracked only if the limit on active streams is set.
+	ActiveStreams int `json:"activeStreams"`
+
+	// TopLabels contains labels of new streams sorted by the number of unique values.
+	TopLabels []LabelChurn `json:"topLabels"`
+}
+
+// LabelChurn contains stats for a label of new streams.
+type LabelChurn struct {
+	Name string `json:"name"`
+
+	// NewStreams is the number of new streams with the label.
+	NewStreams uint64 `json:"newStreams"`
+
+	// UniqueValues is the number of unique label values across new streams.
+	UniqueValues int `json:"uniqueValues"`
+}
+
+func (sl *streamLimiter) getChurn(topN int) []TenantStreamChurn {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	// Register tenants, which have only active streams.
+	sl.activeStreams.Range(func(k, v interface{}) bool {
+		tk := k.(tenantKey)
+		sl.getTenantLocked(tk.accountID, tk.projectID)
+		return true
+	})
+
+	now := fasttime.UnixTimestamp()
+	var result []TenantStreamChurn
+	for k := range sl.tenants {
+		ts := sl.getTenantLocked(k.accountID, k.projectID)
+		tc := TenantStreamChurn{
+			AccountID:      k.accountID,
+			ProjectID:      k.projectID,
+			NewStreamsHour: ts.newStreamsInHour,
+			NewStreamsDay:  ts.newStreamsInDay,
+			ActiveStreams:  sl.getActiveStreams(k.accountID, k.projectID).getActiveCount(now),
+			TopLabels:      []LabelChurn{},
+		}
+		for name, lc := range ts.labels {
+			tc.TopLabels = append(tc.TopLabels, LabelChurn{
+				Name:         name,
+				NewStreams:   lc.newStreams,
+				UniqueValues: len(lc.values),
+			})
+		}
+		sort.Slice(tc.TopLabels, func(i, j int) bool {
+			a, b := &tc.TopLabels[i], &tc.TopLabels[j]
+			if a.UniqueValues != b.UniqueValues {
+				return a.UniqueValues > b.UniqueValues
+			}
+			if a.NewStreams != b.NewStreams {
+				return a.NewStreams > b.NewStreams
+			}
+			return a.Name < b.Name
+		})
+		if topN > 0 && len(tc.TopLabels) > topN {
+			tc.TopLabels = tc.TopLabels[:topN]
+		}
+		result = append(result, tc)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := &result[i], &result[j]
+		if a.NewStreamsDay != b.NewStreamsDay {
+			return a.NewStreamsDay > b.NewStreamsDay
+		}
+		if a.AccountID != b.AccountID {
+			return a.AccountID < b.AccountID
+		}
+		return a.ProjectID < b.ProjectID
+	})
+	return result
+}
+
+// GetStreamChurn returns new streams stats per tenant with up to topN labels per tenant.
+//
+// All the labels are returned if topN <= 0.
+func (s *Storage) GetStreamChurn(topN int) []TenantStreamChurn {
+	return s.streamLimiter.getChurn(topN)
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestStreamLimiter(t *testing.T) {
+	defer SetStreamLimits(0, 0, 0)
+
+	newMetricName := func(accountID uint32, requestID int) []byte {
+		mn := MetricName{
+			AccountID:   accountID,
+			MetricGroup: []byte("loki"),
+		}
+		mn.AddTag("app", "foo")
+		mn.AddTag("request_id", fmt.Sprintf("%d", requestID))
+		mn.sortTags()
+		return mn.Marshal(nil)
+	}
+
+	// New streams per hour.
+	SetStreamLimits(3, 0, 0)
+	sl := newStreamLimiter()
+	for i := 0; i < 3; i++ {
+		if !sl.registerNewStream(newMetricName(1, i)) {
+			t.Fatalf("unexpected rejection of stream #%d", i)
+		}
+	}
+	if sl.registerNewStream(newMetricName(1, 3)) {
+		t.Fatalf("expecting rejection of stream exceeding the limit")
+	}
+	if !sl.registerNewStream(newMetricName(2, 3)) {
+		t.Fatalf("unexpected rejection of stream for another tenant")
+	}
+	if sl.newPerHourRejectedRows != 1 {
+		t.Fatalf("unexpected newPerHourRejectedRows; got %d; want 1", sl.newPerHourRejectedRows)
+	}
+
+	churn := sl.getChurn(2)
+	if len(churn) != 2 {
+		t.Fatalf("unexpected number of tenants; got %d; want 2", len(churn))
+	}
+	tc := churn[0]
+	if tc.AccountID != 1 || tc.NewStreamsHour != 3 || tc.NewStreamsDay != 3 {
+		t.Fatalf("unexpected churn for the first tenant: %+v", tc)
+	}
+	if len(tc.TopLabels) != 2 {
+		t.Fatalf("unexpected number of top labels; got %d; want 2", len(tc.TopLabels))
+	}
+	if lc := tc.TopLabels[0]; lc.Name != "request_id" || lc.UniqueValues != 3 || lc.NewStreams != 3 {
+		t.Fatalf("unexpected top label: %+v", lc)
+	}
+	if lc := tc.TopLabels[1]; lc.Name != "__name__" || lc.UniqueValues != 1 || lc.NewStreams != 3 {
+		t.Fatalf("unexpected second label: %+v", lc)
+	}
+
+	// Active streams.
+	SetStreamLimits(0, 0, 2)
+	sl = newStreamLimiter()
+	for metricID := uint64(1); metricID <= 2; metricID++ {
+		if !sl.registerActiveStream(&TSID{AccountID: 1, MetricID: metricID}) {
+			t.Fatalf("unexpected rejection of active stream %d", metricID)
+		}
+	}
+	if !sl.registerActiveStream(&TSID{AccountID: 1, MetricID: 1}) {
+		t.Fatalf("unexpected rejection of already active stream")
+	}
+	if sl.registerActiveStream(&TSID{AccountID: 1, MetricID: 3}) {
+		t.Fatalf("expecting rejection of stream exceeding active streams limit")
+	}
+	if sl.registerNewStream(newMetricName(1, 100)) {
+		t.Fatalf("expecting rejection of new stream exceeding active streams limit")
+	}
+	if sl.activeRejectedRows != 2 {
+		t.Fatalf("unexpected activeRejectedRows; got %d; want 2", sl.activeRejectedRows)
+	}
+}
+
+func TestTenantActiveStreams(t *testing.T) {
+	defer SetStreamLimits(0, 0, 0)
+	SetStreamLimits(0, 0, 2)
+
+	var tas tenantActiveStreams
+	const now = 1e9
+	if !tas.register(1, now) || !tas.register(2, now+10) {
+		t.Fatalf("unexpected rejection of streams within the limit")
+	}
+	if tas.register(3, now+20) {
+		t.Fatalf("expecting rejection of stream exceeding the limit")
+	}
+
+	// The stream 1 becomes inactive after activeStreamsWindow, so the stream 3 may be added.
+	if !tas.register(3, now+activeStreamsWindow) {
+		t.Fatalf("unexpected rejection of stream after the stream 1 became inactive")
+	}
+	if n := tas.len(); n != 2 {
+		t.Fatalf("unexpected number of active streams; got %d; want 2", n)
+	}
+
+	// Rows for the active stream 2 extend its activity window.
+	if !tas.register(2, now+activeStreamsWindow+5) {
+		t.Fatalf("unexpected rejection of active stream")
+	}
+	if tas.register(1, now+activeStreamsWindow+activeStreamsCleanupInterval) {
+		t.Fatalf("expecting rejection of stream 1, since streams 2 and 3 are active")
+	}
+	if n := tas.getActiveCount(now + 2*activeStreamsWindow); n != 1 {
+		t.Fatalf("unexpected number of active streams after the window; got %d; want 1", n)
+	}
+}
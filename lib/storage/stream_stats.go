@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+)
+
+// StreamStats contains stats for a single stream.
+//
+// The stats are calculated from block headers, so block data isn't read.
+// Blocks partially overlapping the search time range are counted in full.
+type StreamStats struct {
+	// MetricName is the marshaled MetricName for the stream.
+	MetricName []byte
+
+	// Blocks is the number of blocks for the stream.
+	Blocks uint64
+
+	// Rows is the number of log lines in the blocks.
+	Rows uint64
+
+	// Size is the size in bytes of compressed blocks.
+	Size uint64
+}
+
+// Reset resets ss.
+func (ss *StreamStats) Reset() {
+	ss.MetricName = ss.MetricName[:0]
+	ss.Blocks = 0
+	ss.Rows = 0
+	ss.Size = 0
+}
+
+// AddBlockRef adds stats for the block referred by br to ss.
+func (ss *StreamStats) AddBlockRef(br *BlockRef) {
+	ss.Blocks++
+	ss.Rows += uint64(br.bh.RowsCount)
+	ss.Size += uint64(br.bh.TimestampsBlockSize) + uint64(br.bh.ValuesBlockSize)
+}
+
+// Add adds stats from src to ss.
+func (ss *StreamStats) Add(src *StreamStats) {
+	ss.Blocks += src.Blocks
+	ss.Rows += src.Rows
+	ss.Size += src.Size
+}
+
+// Marshal appends marshaled ss to dst and returns the result.
+func (ss *StreamStats) Marshal(dst []byte) []byte {
+	dst = encoding.MarshalBytes(dst, ss.MetricName)
+	dst = encoding.MarshalVarUint64(dst, ss.Blocks)
+	dst = encoding.MarshalVarUint64(dst, ss.Rows)
+	dst = encoding.MarshalVarUint64(dst, ss.Size)
+	return dst
+}
+
+// Unmarshal unmarshals ss from src and returns the tail.
+func (ss *StreamStats) Unmarshal(src []byte) ([]byte, error) {
+	tail, metricName, err := encoding.UnmarshalBytes(src)
+	if err != nil {
+		return tail, fmt.Errorf("cannot unmarshal MetricName: %w", err)
+	}
+	ss.MetricName = append(ss.MetricName[:0], metricName...)
+	tail, ss.Blocks, err = encoding.UnmarshalVarUint64(tail)
+	if err != nil {
+		return tail, fmt.Errorf("cannot unmarshal Blocks: %w", err)
+	}
+	tail, ss.Rows, err = encoding.UnmarshalVarUint64(tail)
+	if err != nil {
+		return tail, fmt.Errorf("cannot unmarshal Rows: %w", err)
+	}
+	tail, ss.Size, err = encoding.UnmarshalVarUint64(tail)
+	if err != nil {
+		return tail, fmt.Errorf("cannot unmarshal Size: %w", err)
+	}
+	return tail, nil
+}
@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStreamStatsMarshalUnmarshal(t *testing.T) {
+	ss := &StreamStats{
+		MetricName: []byte("foobar"),
+		Blocks:     3,
+		Rows:       1234,
+		Size:       1 << 40,
+	}
+	data := ss.Marshal(nil)
+	var ss2 StreamStats
+	tail, err := ss2.Unmarshal(data)
+	if err != nil {
+		t.Fatalf("cannot unmarshal StreamStats: %s", err)
+	}
+	if len(tail) > 0 {
+		t.Fatalf("unexpected non-empty tail: %X", tail)
+	}
+	if !reflect.DeepEqual(ss, &ss2) {
+		t.Fatalf("unexpected StreamStats\ngot\n%+v\nwant\n%+v", &ss2, ss)
+	}
+
+	// Unmarshal truncated data
+	for i := 0; i < len(data); i++ {
+		if _, err := ss2.Unmarshal(data[:i]); err == nil {
+			t.Fatalf("expecting non-nil error when unmarshaling %d bytes out of %d", i, len(data))
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+// maxTailMetricNamesPerSubscription is the maximum number of cached metricID -> MetricName entries per tail subscription.
+//
+// The cache is reset when it exceeds this size.
+const maxTailMetricNamesPerSubscription = 100000
+
+// TailRow is a row pushed to TailSubscription after it is added to Storage.
+type TailRow struct {
+	// MetricName is the marshaled MetricName for the row.
+	MetricName []byte
+
+	// Timestamp is unix timestamp in milliseconds.
+	Timestamp int64
+
+	// Value is the raw row value. It is empty for dropped rows.
+	Value []byte
+}
+
+// Marshal appends marshaled tr to dst and returns the result.
+func (tr *TailRow) Marshal(dst []byte) []byte {
+	dst = encoding.MarshalBytes(dst, tr.MetricName)
+	dst = encoding.MarshalInt64(dst, tr.Timestamp)
+	dst = encoding.MarshalBytes(dst, tr.Value)
+	return dst
+}
+
+// Unmarshal unmarshals tr from src and returns the tail.
+//
+// tr refers to src, so src mustn't be modified while tr is in use.
+func (tr *TailRow) Unmarshal(src []byte) ([]byte, error) {
+	tail, metricName, err := encoding.UnmarshalBytes(src)
+	if err != nil {
+		return tail, fmt.Errorf("cannot unmarshal MetricName: %w", err)
+	}
+	tr.MetricName = metricName
+	if len(tail) < 8 {
+		return tail, fmt.Errorf("cannot unmarshal Timestamp from %d bytes; need at least 8 bytes", len(tail))
+	}
+	tr.Timestamp = encoding.UnmarshalInt64(tail)
+	tail = tail[8:]
+	tail, value, err := encoding.UnmarshalBytes(tail)
+	if err != nil {
+		return tail, fmt.Errorf("cannot unmarshal Value: %w", err)
+	}
+	tr.Value = value
+	return tail, nil
+}
+
+// tailer pushes added rows to tail subscriptions.
+type tailer struct {
+	// Atomic counters must go at the top of the structure in order to properly align by 8 bytes on 32-bit archs.
+	droppedRows uint64
+
+	mu sync.Mutex
+
+	// subs contains the current subscriptions. It is updated under mu with copy-on-write,
+	// so it may be loaded without locking when adding rows.
+	subs atomic.Value
+}
+
+func newTailer() *tailer {
+	var t tailer
+	t.subs.Store([]*TailSubscription(nil))
+	return &t
+}
+
+func (t *tailer) getSubscriptions() []*TailSubscription {
+	return t.subs.Load().([]*TailSubscription)
+}
+
+func (t *tailer) addSubscription(ts *TailSubscription) {
+	t.mu.Lock()
+	subs := t.getSubscriptions()
+	subsNew := make([]*TailSubscription, 0, len(subs)+1)
+	subsNew = append(subsNew, subs...)
+	subsNew = append(subsNew, ts)
+	t.subs.Store(subsNew)
+	t.mu.Unlock()
+}
+
+func (t *tailer) removeSubscription(ts *TailSubscription) {
+	t.mu.Lock()
+	subs := t.getSubscriptions()
+	subsNew := make([]*TailSubscription, 0, len(subs))
+	for _, sub := range subs {
+		if sub != ts {
+			subsNew = append(subsNew, sub)
+		}
+	}
+	t.subs.Store(subsNew)
+	t.mu.Unlock()
+}
+
+// addRows pushes rows to the matching subscriptions.
+//
+// Rows are handed to subscriptions via bounded queues, so slow subscriptions don't slow down data ingestion.
+// Rows are dropped if the subscription queue is full.
+func (t *tailer) addRows(rows []rawRow) {
+	subs := t.getSubscriptions()
+	if len(subs) == 0 {
+		// Fast path - there are no subscriptions.
+		return
+	}
+	for _, ts := range subs {
+		ts.enqueueRows(rows)
+	}
+}
+
+// tailQueueSize is the maximum number of pending batches of added rows per tail subscription.
+const tailQueueSize = 256
+
+// tailBatch is a batch of added rows pending processing by TailSubscription.
+type tailBatch struct {
+	rows []rawRow
+
+	// buf holds row values, so they don't refer to the buffers reused by Storage.add.
+	buf []byte
+}
+
+// TailSubscription receives rows matching the given tag filters when they are added to Storage.
+//
+// TailSubscription must be created via Storage.SubscribeTail and must be closed via Unsubscribe.
+type TailSubscription struct {
+	s *Storage
+
+	accountID uint32
+	projectID uint32
+
+	// tfss contains tag filters for matching the rows. Rows match if they match any of tfss.
+	tfss [][]*tagFilter
+
+	maxBufferedRows int
+
+	notifyCh chan struct{}
+
+	// queueCh contains batches of added rows pending processing by the subscription goroutine.
+	queueCh chan *tailBatch
+	stopCh  chan struct{}
+	wg      sync.WaitGroup
+
+	mu sync.Mutex
+
+	// metricNames contains marshaled MetricName per each seen metricID.
+	// It contains nil for metricIDs, which don't match tfss.
+	metricNames map[uint64][]byte
+
+	rows    []TailRow
+	dropped []TailRow
+
+	mn MetricName
+	kb bytesutil.ByteBuffer
+}
+
+// SubscribeTail returns a subscription for rows with the given (accountID, projectID) matching tfss,
+// which are added to s after the call.
+//
+// Up to maxBufferedRows rows are buffered until they are read via TailSubscription.Read.
+// The remaining rows are dropped and are returned via TailSubscription.Read without values,
+// so the caller could notify the client about missing rows.
+func (s *Storage) SubscribeTail(accountID, projectID uint32, tfss []*TagFilters, maxBufferedRows int) *TailSubscription {
+	if maxBufferedRows <= 0 {
+		logger.Panicf("BUG: maxBufferedRows must be positive; got %d", maxBufferedRows)
+	}
+	ts := &TailSubscription{
+		s:               s,
+		accountID:       accountID,
+		projectID:       projectID,
+		maxBufferedRows: maxBufferedRows,
+		notifyCh:        make(chan struct{}, 1),
+		queueCh:         make(chan *tailBatch, tailQueueSize),
+		stopCh:          make(chan struct{}),
+		metricNames:     make(map[uint64][]byte),
+	}
+	for _, tfs := range tfss {
+		tfsPtrs := make([]*tagFilter, len(tfs.tfs))
+		for i := range tfs.tfs {
+			tfsPtrs[i] = &tfs.tfs[i]
+		}
+		ts.tfss = append(ts.tfss, tfsPtrs)
+	}
+	ts.wg.Add(1)
+	go func() {
+		defer ts.wg.Done()
+		ts.processQueue()
+	}()
+	s.tailer.addSubscription(ts)
+	return ts
+}
+
+// Unsubscribe stops pushing new rows to ts.
+func (ts *TailSubscription) Unsubscribe() {
+	ts.s.tailer.removeSubscription(ts)
+	close(ts.stopCh)
+	ts.wg.Wait()
+}
+
+func (ts *TailSubscription) processQueue() {
+	for {
+		select {
+		case <-ts.stopCh:
+			return
+		case tb := <-ts.queueCh:
+			ts.addRows(tb.rows)
+		}
+	}
+}
+
+// enqueueRows copies rows for the subscription tenant to the subscription queue.
+//
+// Rows are dropped and are counted in vm_tail_dropped_rows_total if the queue is full.
+func (ts *TailSubscription) enqueueRows(rows []rawRow) {
+	var tb *tailBatch
+	for i := range rows {
+		r := &rows[i]
+		if r.TSID.AccountID != ts.accountID || r.TSID.ProjectID != ts.projectID {
+			continue
+		}
+		if tb == nil {
+			tb = &tailBatch{}
+		}
+		bufLen := len(tb.buf)
+		tb.buf = append(tb.buf, r.Value...)
+		tb.rows = append(tb.rows, rawRow{
+			TSID:      r.TSID,
+			Timestamp: r.Timestamp,
+			Value:     tb.buf[bufLen:len(tb.buf):len(tb.buf)],
+		})
+	}
+	if tb == nil {
+		return
+	}
+	select {
+	case ts.queueCh <- tb:
+	default:
+		atomic.AddUint64(&ts.s.tailer.droppedRows, uint64(len(tb.rows)))
+	}
+}
+
+// NotifyCh returns a channel, which is notified when new rows are available for reading via Read.
+func (ts *TailSubscription) NotifyCh() <-chan struct{} {
+	return ts.notifyCh
+}
+
+// Read returns buffered rows and dropped rows since the previous call.
+//
+// Dropped rows contain only MetricName and Timestamp.
+func (ts *TailSubscription) Read() ([]TailRow, []TailRow) {
+	ts.mu.Lock()
+	rows, dropped := ts.rows, ts.dropped
+	ts.rows = nil
+	ts.dropped = nil
+	ts.mu.Unlock()
+	return rows, dropped
+}
+
+func (ts *TailSubscription) addRows(rows []rawRow) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	added := false
+	for i := range rows {
+		r := &rows[i]
+		if r.TSID.AccountID != ts.accountID || r.TSID.ProjectID != ts.projectID {
+			continue
+		}
+		metricName := ts.getMetricName(&r.TSID)
+		if metricName == nil {
+			continue
+		}
+		added = true
+		if len(ts.rows) >= ts.maxBufferedRows {
+			atomic.AddUint64(&ts.s.tailer.droppedRows, 1)
+			if len(ts.dropped) < ts.maxBufferedRows {
+				ts.dropped = append(ts.dropped, TailRow{
+					MetricName: metricName,
+					Timestamp:  r.Timestamp,
+				})
+			}
+			continue
+		}
+		ts.rows = append(ts.rows, TailRow{
+			MetricName: metricName,
+			Timestamp:  r.Timestamp,
+			Value:      append([]byte{}, r.Value...),
+		})
+	}
+	if !added {
+		return
+	}
+	select {
+	case ts.notifyCh <- struct{}{}:
+	default:
+		// The reader has been already notified.
+	}
+}
+
+// getMetricName returns marshaled MetricName for tsid if it matches ts filters.
+//
+// nil is returned if tsid doesn't match ts filters.
+func (ts *TailSubscription) getMetricName(tsid *TSID) []byte {
+	metricName, ok := ts.metricNames[tsid.MetricID]
+	if ok {
+		return metricName
+	}
+	metricName, err := ts.s.searchMetricName(nil, tsid.MetricID, tsid.AccountID, tsid.ProjectID)
+	if err != nil {
+		if err != io.EOF {
+			logger.Errorf("cannot find MetricName for metricID=%d: %s", tsid.MetricID, err)
+		}
+		// Do not cache the missing MetricName, since it may appear later.
+		return nil
+	}
+	if err := ts.mn.Unmarshal(metricName); err != nil {
+		logger.Errorf("cannot unmarshal MetricName %q: %s", metricName, err)
+		return nil
+	}
+	ok = false
+	for _, tfs := range ts.tfss {
+		matched, err := matchTagFilters(&ts.mn, tfs, &ts.kb)
+		if err != nil {
+			logger.Errorf("cannot match MetricName %s against tag filters: %s", &ts.mn, err)
+			return nil
+		}
+		if matched {
+			ok = true
+			break
+		}
+	}
+	if !ok {
+		metricName = nil
+	}
+	if len(ts.metricNames) >= maxTailMetricNamesPerSubscription {
+		ts.metricNames = make(map[uint64][]byte)
+	}
+	ts.metricNames[tsid.MetricID] = metricName
+	return metricName
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"os"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStorageSubscribeTail(t *testing.T) {
+	path := "TestStorageSubscribeTail"
+	s, err := OpenStorage(path, 0)
+	if err != nil {
+		t.Fatalf("cannot open storage: %s", err)
+	}
+	defer func() {
+		s.MustClose()
+		if err := os.RemoveAll(path); err != nil {
+			t.Fatalf("cannot remove %q: %s", path, err)
+		}
+	}()
+
+	tfs := NewTagFilters(1, 2)
+	if err := tfs.Add([]byte("app"), []byte("foo|baz"), false, true); err != nil {
+		t.Fatalf("cannot add tag filter: %s", err)
+	}
+	ts := s.SubscribeTail(1, 2, []*TagFilters{tfs}, 2)
+
+	newRow := func(accountID, projectID uint32, app string, timestamp int64) MetricRow {
+		labels := []Label{
+			{Name: []byte("app"), Value: []byte(app)},
+		}
+		return MetricRow{
+			MetricNameRaw: MarshalMetricNameRaw(nil, accountID, projectID, labels),
+			Timestamp:     timestamp,
+			Value:         []byte(app),
+		}
+	}
+	const timestamp = msecPerDay*18000 + 1000
+	mrs := []MetricRow{
+		newRow(1, 2, "foo", timestamp),
+		// Non-matching stream
+		newRow(1, 2, "bar", timestamp+1),
+		// Matching stream for another tenant
+		newRow(1, 3, "foo", timestamp+2),
+		newRow(1, 2, "baz", timestamp+3),
+		// Must be dropped, since maxBufferedRows=2
+		newRow(1, 2, "foo", timestamp+4),
+	}
+	if err := s.AddRows(mrs, defaultPrecisionBits); err != nil {
+		t.Fatalf("cannot add rows: %s", err)
+	}
+	// Rows are pushed to the subscription asynchronously.
+	select {
+	case <-ts.NotifyCh():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expecting notification for the added rows")
+	}
+
+	getApp := func(tr *TailRow) string {
+		t.Helper()
+		var mn MetricName
+		if err := mn.Unmarshal(tr.MetricName); err != nil {
+			t.Fatalf("cannot unmarshal MetricName: %s", err)
+		}
+		if mn.AccountID != 1 || mn.ProjectID != 2 {
+			t.Fatalf("unexpected tenant for the row: %d:%d", mn.AccountID, mn.ProjectID)
+		}
+		return string(mn.GetTagValue("app"))
+	}
+	// Rows may be reordered by Storage.add, so verify only the set of matching rows.
+	rows, dropped := ts.Read()
+	if len(rows) != 2 || len(dropped) != 1 {
+		t.Fatalf("unexpected number of rows; got %d rows and %d dropped rows; want 2 rows and 1 dropped row", len(rows), len(dropped))
+	}
+	result := make(map[int64]string)
+	for i := range rows {
+		row := &rows[i]
+		app := getApp(row)
+		if string(row.Value) != app {
+			t.Fatalf("unexpected value for the row; got %q; want %q", row.Value, app)
+		}
+		result[row.Timestamp] = app
+	}
+	if len(dropped[0].Value) > 0 {
+		t.Fatalf("unexpected non-empty value for dropped row: %q", dropped[0].Value)
+	}
+	result[dropped[0].Timestamp] = getApp(&dropped[0])
+	resultExpected := map[int64]string{
+		timestamp:     "foo",
+		timestamp + 3: "baz",
+		timestamp + 4: "foo",
+	}
+	if !reflect.DeepEqual(result, resultExpected) {
+		t.Fatalf("unexpected rows\ngot\n%v\nwant\n%v", result, resultExpected)
+	}
+
+	// Rows mustn't be pushed after Unsubscribe.
+	ts.Unsubscribe()
+	if err := s.AddRows([]MetricRow{newRow(1, 2, "foo", timestamp+5)}, defaultPrecisionBits); err != nil {
+		t.Fatalf("cannot add rows: %s", err)
+	}
+	if rows, dropped := ts.Read(); len(rows) > 0 || len(dropped) > 0 {
+		t.Fatalf("unexpected rows after Unsubscribe: %d rows, %d dropped rows", len(rows), len(dropped))
+	}
+}
+
+func TestTailSubscriptionEnqueueRowsQueueFull(t *testing.T) {
+	s := &Storage{
+		tailer: newTailer(),
+	}
+	ts := &TailSubscription{
+		s:         s,
+		accountID: 1,
+		projectID: 2,
+		queueCh:   make(chan *tailBatch, 1),
+	}
+	value := []byte("foo")
+	rows := []rawRow{
+		{TSID: TSID{AccountID: 1, ProjectID: 2, MetricID: 1}, Timestamp: 1, Value: value},
+		// Row for another tenant
+		{TSID: TSID{AccountID: 1, ProjectID: 3, MetricID: 2}, Timestamp: 2, Value: value},
+	}
+	ts.enqueueRows(rows)
+	value[0] = 'b'
+	tb := <-ts.queueCh
+	if len(tb.rows) != 1 || string(tb.rows[0].Value) != "foo" || tb.rows[0].Timestamp != 1 {
+		t.Fatalf("unexpected queued rows: %+v", tb.rows)
+	}
+
+	// Rows for another tenant aren't queued.
+	ts.enqueueRows(rows[1:])
+	if n := len(ts.queueCh); n != 0 {
+		t.Fatalf("unexpected number of queued batches; got %d; want 0", n)
+	}
+
+	// Rows are dropped when the queue is full.
+	ts.enqueueRows(rows)
+	ts.enqueueRows(rows)
+	if n := atomic.LoadUint64(&s.tailer.droppedRows); n != 1 {
+		t.Fatalf("unexpected number of dropped rows; got %d; want 1", n)
+	}
+}
+
+func TestTailRowMarshalUnmarshal(t *testing.T) {
+	tr := TailRow{
+		MetricName: []byte("metric name"),
+		Timestamp:  -123,
+		Value:      []byte("line"),
+	}
+	data := tr.Marshal(nil)
+	var tr2 TailRow
+	tail, err := tr2.Unmarshal(data)
+	if err != nil {
+		t.Fatalf("cannot unmarshal TailRow: %s", err)
+	}
+	if len(tail) > 0 {
+		t.Fatalf("unexpected non-empty tail: %q", tail)
+	}
+	if !reflect.DeepEqual(&tr, &tr2) {
+		t.Fatalf("unexpected TailRow after unmarshaling\ngot\n%+v\nwant\n%+v", &tr2, &tr)
+	}
+}
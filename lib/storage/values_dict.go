@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/encodingext"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+// valuesDictFilename is the name of the file with zstd dictionary for values blocks in the part directory.
+const valuesDictFilename = "values.dict"
+
+var valuesDictSize = 0
+
+// SetValuesDictSize sets the size of zstd dictionaries trained per partition during big merges.
+//
+// Dictionaries aren't trained if size is 0. Parts with values compressed with dictionaries
+// remain readable in this case.
+//
+// This function may be called only before Storage initialization.
+func SetValuesDictSize(size int) {
+	valuesDictSize = size
+}
+
+// maxValuesDictSamplesSize is the maximum size of values samples used for dictionary training.
+const maxValuesDictSamplesSize = 16 * 1024 * 1024
+
+var (
+	valuesDictsTrained      uint64
+	valuesDictTrainFailures uint64
+)
+
+// readValuesDict reads values dictionary from the part at partPath.
+//
+// nil is returned if the part has no dictionary.
+func readValuesDict(partPath string) (*encodingext.Dict, error) {
+	path := partPath + "/" + valuesDictFilename
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("cannot read values dictionary: %w", err)
+	}
+	d, err := encodingext.NewDict(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load values dictionary from %q: %w", path, err)
+	}
+	return d, nil
+}
+
+// writeValuesDict writes d to the part at partPath.
+func writeValuesDict(partPath string, d *encodingext.Dict) error {
+	if d == nil {
+		return nil
+	}
+	path := partPath + "/" + valuesDictFilename
+	if err := fs.WriteFileAtomically(path, d.Data()); err != nil {
+		return fmt.Errorf("cannot write values dictionary: %w", err)
+	}
+	return nil
+}
+
+// getValuesDictForBigMerge returns the dictionary for compressing values in the big part created from pws.
+//
+// The dictionary is trained from pws values if the partition has no dictionary yet.
+func (pt *partition) getValuesDictForBigMerge(pws []*partWrapper) *encodingext.Dict {
+	if valuesDictSize <= 0 {
+		return pt.valuesDict
+	}
+
+	pt.valuesDictLock.Lock()
+	defer pt.valuesDictLock.Unlock()
+
+	if pt.valuesDict != nil {
+		return pt.valuesDict
+	}
+	startTime := time.Now()
+	samples, err := collectValuesDictSamples(pws, maxValuesDictSamplesSize)
+	if err != nil {
+		atomic.AddUint64(&valuesDictTrainFailures, 1)
+		logger.Errorf("cannot collect samples for values dictionary in partition %q: %s", pt.name, err)
+		return nil
+	}
+	data := encodingext.TrainDict(samples, valuesDictSize)
+	if len(data) == 0 {
+		// Not enough samples or the dictionary training isn't supported.
+		// Try again during the next big merge.
+		return nil
+	}
+	d, err := encodingext.NewDict(data)
+	if err != nil {
+		atomic.AddUint64(&valuesDictTrainFailures, 1)
+		logger.Errorf("cannot create values dictionary for partition %q: %s", pt.name, err)
+		return nil
+	}
+	atomic.AddUint64(&valuesDictsTrained, 1)
+	logger.Infof("trained values dictionary with size %d bytes from %d samples for partition %q in %.3f seconds",
+		len(data), len(samples), pt.name, time.Since(startTime).Seconds())
+	pt.valuesDict = d
+	return d
+}
+
+// collectValuesDictSamples returns up to maxSize bytes of values from pws.
+//
+// Values are collected evenly from all the parts.
+func collectValuesDictSamples(pws []*partWrapper, maxSize int) ([][]byte, error) {
+	maxSizePerPart := maxSize / len(pws)
+	var samples [][]byte
+	var bhs []blockHeader
+	var b Block
+	for _, pw := range pws {
+		p := pw.p
+		size := 0
+		for i := range p.metaindex {
+			if size >= maxSizePerPart {
+				break
+			}
+			mr := &p.metaindex[i]
+			var err error
+			bhs, err = p.readBlockHeaders(bhs[:0], mr)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read block headers from part %q: %w", p, err)
+			}
+			for j := range bhs {
+				if size >= maxSizePerPart {
+					break
+				}
+				br := BlockRef{
+					p:  p,
+					bh: bhs[j],
+				}
+				br.MustReadBlock(&b, FetchAll)
+				if err := b.UnmarshalData(true); err != nil {
+					return nil, fmt.Errorf("cannot unmarshal block from part %q: %w", p, err)
+				}
+				for _, v := range b.values {
+					samples = append(samples, v)
+					size += len(v)
+				}
+			}
+		}
+	}
+	return samples, nil
+}
+
+// GetValuesDict returns the dictionary with the given id from s parts.
+//
+// nil is returned if s has no such dictionary.
+func (s *Storage) GetValuesDict(id uint64) *encodingext.Dict {
+	ptws := s.tb.GetPartitions(nil)
+	defer s.tb.PutPartitions(ptws)
+
+	var pws []*partWrapper
+	for _, ptw := range ptws {
+		pws = ptw.pt.GetParts(pws[:0])
+		for _, pw := range pws {
+			if d := pw.p.valuesDict; d != nil && d.ID() == id {
+				ptw.pt.PutParts(pws)
+				return d
+			}
+		}
+		ptw.pt.PutParts(pws)
+	}
+	return nil
+}
+
+// AddValuesDict registers d for unmarshaling blocks obtained with UnmarshalBlock.
+//
+// Blocks marshaled with MarshalBlock contain only the id of the dictionary for their values,
+// so the dictionary must be registered before reading values from such blocks.
+func AddValuesDict(d *encodingext.Dict) {
+	valuesDicts.add(d)
+}
+
+// valuesDicts contains dictionaries registered via AddValuesDict.
+var valuesDicts = &valuesDictsCache{
+	m: make(map[uint64]*valuesDictsCacheEntry),
+}
+
+// valuesDictsCacheExpireDuration is the duration in seconds for keeping unused dictionaries in valuesDicts.
+const valuesDictsCacheExpireDuration = 3600
+
+type valuesDictsCache struct {
+	mu sync.Mutex
+	m  map[uint64]*valuesDictsCacheEntry
+}
+
+type valuesDictsCacheEntry struct {
+	// lastAccessTime must be accessed via atomic calls.
+	lastAccessTime uint64
+
+	d *encodingext.Dict
+}
+
+func (vdc *valuesDictsCache) get(id uint64) *encodingext.Dict {
+	vdc.mu.Lock()
+	e := vdc.m[id]
+	vdc.mu.Unlock()
+	if e == nil {
+		return nil
+	}
+	atomic.StoreUint64(&e.lastAccessTime, fasttime.UnixTimestamp())
+	return e.d
+}
+
+func (vdc *valuesDictsCache) add(d *encodingext.Dict) {
+	currentTime := fasttime.UnixTimestamp()
+
+	vdc.mu.Lock()
+	defer vdc.mu.Unlock()
+
+	// Remove dictionaries for parts, which are no longer queried.
+	for id, e := range vdc.m {
+		if currentTime-atomic.LoadUint64(&e.lastAccessTime) > valuesDictsCacheExpireDuration {
+			delete(vdc.m, id)
+		}
+	}
+	vdc.m[d.ID()] = &valuesDictsCacheEntry{
+		lastAccessTime: currentTime,
+		d:              d,
+	}
+}
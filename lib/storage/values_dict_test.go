@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/encodingext"
+)
+
+func TestMarshalUnmarshalBlockWithValuesDict(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 20000; i++ {
+		samples = append(samples, []byte(fmt.Sprintf(`level=info component=parser msg="processed request" request_id=%d duration=%dms`, i, i%100)))
+	}
+	data := encodingext.TrainDict(samples, 16*1024)
+	if len(data) == 0 {
+		t.Skipf("dictionary training isn't supported by the build")
+	}
+	d, err := encodingext.NewDict(data)
+	if err != nil {
+		t.Fatalf("cannot create dictionary: %s", err)
+	}
+
+	values := samples[:100]
+	timestamps := make([]int64, len(values))
+	for i := range timestamps {
+		timestamps[i] = int64(i) * 1000
+	}
+	var b Block
+	b.Init(&TSID{MetricID: 1}, timestamps, values, 64)
+	b.dict = d
+	b.MarshalData(0, 0)
+	if b.bh.ValuesMarshalType != encodingext.MarshalTypeZSTDDictBytesArray {
+		t.Fatalf("unexpected ValuesMarshalType; got %d; want %d", b.bh.ValuesMarshalType, encodingext.MarshalTypeZSTDDictBytesArray)
+	}
+	valuesData := append([]byte{}, b.valuesData...)
+
+	// The block values must be sent without re-compression.
+	data = MarshalBlock(nil, &b)
+	var b2 Block
+	tail, err := UnmarshalBlock(&b2, data)
+	if err != nil {
+		t.Fatalf("cannot unmarshal block: %s", err)
+	}
+	if len(tail) > 0 {
+		t.Fatalf("unexpected non-empty tail after unmarshaling block: %X", tail)
+	}
+	if string(b2.valuesData) != string(valuesData) {
+		t.Fatalf("unexpected valuesData; got %X; want %X", b2.valuesData, valuesData)
+	}
+	if id := b2.MissingValuesDictID(); id != d.ID() {
+		t.Fatalf("unexpected missing dictionary id; got %016X; want %016X", id, d.ID())
+	}
+
+	AddValuesDict(d)
+	if _, err := UnmarshalBlock(&b2, data); err != nil {
+		t.Fatalf("cannot unmarshal block: %s", err)
+	}
+	if id := b2.MissingValuesDictID(); id != 0 {
+		t.Fatalf("unexpected missing dictionary id after AddValuesDict: %016X", id)
+	}
+	if err := b2.UnmarshalData(true); err != nil {
+		t.Fatalf("cannot unmarshal block data: %s", err)
+	}
+	if !equalLines(b2.values, values) {
+		t.Fatalf("unexpected lines\ngot\n%q\nwant\n%q", b2.values, values)
+	}
+}
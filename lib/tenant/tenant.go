@@ -0,0 +1,173 @@
+package tenant
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/procutil"
+	"gopkg.in/yaml.v2"
+)
+
+var orgIDMapFile = flag.String("tenant.orgIDMapFile", "", "Optional path to YAML file with mapping from X-Scope-OrgID values to `accountID[:projectID]`, "+
+	"for example `team-a: \"1:0\"`. Numeric X-Scope-OrgID values in the form `accountID[:projectID]` are accepted without mapping. "+
+	"The file is re-read on SIGHUP")
+
+// OrgIDHeader is the name of http header with tenant ids used by Loki clients such as Promtail, Grafana and logcli.
+const OrgIDHeader = "X-Scope-OrgID"
+
+// LabelName is the name of the label containing tenant id, which is added to results of multi-tenant queries.
+const LabelName = "__tenant_id__"
+
+// Tenant is a tenant referred by X-Scope-OrgID header.
+type Tenant struct {
+	// OrgID is the tenant id from X-Scope-OrgID header.
+	OrgID string
+
+	// AuthToken contains AccountID and ProjectID for the OrgID.
+	AuthToken *auth.Token
+}
+
+// Init must be called after flag.Parse and before using the tenant package.
+func Init() {
+	m, err := loadOrgIDMap()
+	if err != nil {
+		logger.Fatalf("cannot load -tenant.orgIDMapFile: %s", err)
+	}
+	orgIDMapGlobal.Store(m)
+	if len(*orgIDMapFile) == 0 {
+		return
+	}
+	sighupCh := procutil.NewSighupChan()
+	go func() {
+		for range sighupCh {
+			logger.Infof("received SIGHUP; reloading -tenant.orgIDMapFile=%q...", *orgIDMapFile)
+			m, err := loadOrgIDMap()
+			if err != nil {
+				logger.Errorf("cannot load the updated -tenant.orgIDMapFile: %s; preserving the previous mapping", err)
+				continue
+			}
+			orgIDMapGlobal.Store(m)
+			logger.Infof("successfully reloaded -tenant.orgIDMapFile=%q", *orgIDMapFile)
+		}
+	}()
+}
+
+var orgIDMapGlobal atomic.Value
+
+func loadOrgIDMap() (map[string]*auth.Token, error) {
+	if len(*orgIDMapFile) == 0 {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(*orgIDMapFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read -tenant.orgIDMapFile=%q: %w", *orgIDMapFile, err)
+	}
+	m, err := parseOrgIDMap(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse -tenant.orgIDMapFile=%q: %w", *orgIDMapFile, err)
+	}
+	return m, nil
+}
+
+func parseOrgIDMap(data []byte) (map[string]*auth.Token, error) {
+	var raw map[string]string
+	if err := yaml.UnmarshalStrict(data, &raw); err != nil {
+		return nil, err
+	}
+	m := make(map[string]*auth.Token, len(raw))
+	for orgID, authToken := range raw {
+		if err := validateOrgID(orgID); err != nil {
+			return nil, err
+		}
+		at, err := auth.NewToken(authToken)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse tenant for org id %q: %w", orgID, err)
+		}
+		m[orgID] = at
+	}
+	return m, nil
+}
+
+func validateOrgID(orgID string) error {
+	if len(orgID) == 0 {
+		return fmt.Errorf("org id cannot be empty")
+	}
+	if strings.Contains(orgID, "|") {
+		return fmt.Errorf("org id %q cannot contain `|`", orgID)
+	}
+	return nil
+}
+
+// GetTenants returns tenants from X-Scope-OrgID header of r.
+//
+// Tenant with zero AccountID and ProjectID is returned if the header is missing.
+func GetTenants(r *http.Request) ([]*Tenant, error) {
+	orgIDs := r.Header.Get(OrgIDHeader)
+	if len(orgIDs) == 0 {
+		return []*Tenant{{
+			OrgID:     "0",
+			AuthToken: &auth.Token{},
+		}}, nil
+	}
+	tenants, err := ParseOrgIDs(orgIDs)
+	if err != nil {
+		return nil, &httpserver.ErrorWithStatusCode{
+			Err:        fmt.Errorf("cannot parse %s header: %w", OrgIDHeader, err),
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+	return tenants, nil
+}
+
+// ParseOrgIDs parses X-Scope-OrgID header value.
+//
+// Multiple tenants may be delimited by `|`. Duplicate tenants are ignored.
+func ParseOrgIDs(orgIDs string) ([]*Tenant, error) {
+	m, _ := orgIDMapGlobal.Load().(map[string]*auth.Token)
+	var tenants []*Tenant
+	seen := make(map[auth.Token]bool)
+	for _, orgID := range strings.Split(orgIDs, "|") {
+		orgID = strings.TrimSpace(orgID)
+		if err := validateOrgID(orgID); err != nil {
+			return nil, err
+		}
+		at := m[orgID]
+		if at == nil {
+			var err error
+			at, err = auth.NewToken(orgID)
+			if err != nil {
+				return nil, fmt.Errorf("unknown org id %q; it must be in the form `accountID[:projectID]` or must be mapped via -tenant.orgIDMapFile", orgID)
+			}
+		}
+		if seen[*at] {
+			continue
+		}
+		seen[*at] = true
+		tenants = append(tenants, &Tenant{
+			OrgID:     orgID,
+			AuthToken: at,
+		})
+	}
+	return tenants, nil
+}
+
+// CrossTenantLabelName is the name of the label containing `accountID:projectID` tenant,
+// which is added to results of cross-tenant queries.
+const CrossTenantLabelName = "__tenant__"
+
+// NewCrossTenant returns tenant for cross-tenant queries for the given auth token.
+//
+// The OrgID of the returned tenant is set to `accountID:projectID`.
+func NewCrossTenant(at *auth.Token) *Tenant {
+	return &Tenant{
+		OrgID:     fmt.Sprintf("%d:%d", at.AccountID, at.ProjectID),
+		AuthToken: at,
+	}
+}
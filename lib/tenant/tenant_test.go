@@ -0,0 +1,97 @@
+package tenant
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+)
+
+func TestParseOrgIDMapSuccess(t *testing.T) {
+	m, err := parseOrgIDMap([]byte(`
+team-a: "1"
+team-b: "1:2"
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	mExpected := map[string]*auth.Token{
+		"team-a": {AccountID: 1},
+		"team-b": {AccountID: 1, ProjectID: 2},
+	}
+	if !reflect.DeepEqual(m, mExpected) {
+		t.Fatalf("unexpected mapping\ngot\n%v\nwant\n%v", m, mExpected)
+	}
+}
+
+func TestParseOrgIDMapFailure(t *testing.T) {
+	f := func(s string) {
+		t.Helper()
+		if _, err := parseOrgIDMap([]byte(s)); err == nil {
+			t.Fatalf("expecting non-nil error when parsing %q", s)
+		}
+	}
+	f(`foo`)
+	f(`team-a: foo`)
+	f(`team-a: "1:2:3"`)
+	f(`"a|b": "1"`)
+	f(`"": "1"`)
+	f(`team-a: [1]`)
+}
+
+func TestParseOrgIDs(t *testing.T) {
+	m, err := parseOrgIDMap([]byte(`team-a: "1"`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	orgIDMapGlobal.Store(m)
+	defer orgIDMapGlobal.Store(map[string]*auth.Token(nil))
+
+	f := func(s string, tenantsExpected []*Tenant) {
+		t.Helper()
+		tenants, err := ParseOrgIDs(s)
+		if tenantsExpected == nil {
+			if err == nil {
+				t.Fatalf("expecting non-nil error when parsing %q", s)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %q: %s", s, err)
+		}
+		if !reflect.DeepEqual(tenants, tenantsExpected) {
+			t.Fatalf("unexpected tenants for %q\ngot\n%v\nwant\n%v", s, tenants, tenantsExpected)
+		}
+	}
+	f("team-a", []*Tenant{{OrgID: "team-a", AuthToken: &auth.Token{AccountID: 1}}})
+	f("2:3", []*Tenant{{OrgID: "2:3", AuthToken: &auth.Token{AccountID: 2, ProjectID: 3}}})
+	f("team-a|5", []*Tenant{
+		{OrgID: "team-a", AuthToken: &auth.Token{AccountID: 1}},
+		{OrgID: "5", AuthToken: &auth.Token{AccountID: 5}},
+	})
+
+	// Duplicate tenants
+	f("team-a|1:0", []*Tenant{{OrgID: "team-a", AuthToken: &auth.Token{AccountID: 1}}})
+
+	// Errors
+	f("team-b", nil)
+	f("team-a|", nil)
+	f("|", nil)
+	f("1:2:3", nil)
+}
+
+func TestGetTenantsMissingHeader(t *testing.T) {
+	r, err := http.NewRequest("GET", "/loki/api/v1/query", nil)
+	if err != nil {
+		t.Fatalf("cannot create request: %s", err)
+	}
+	tenants, err := GetTenants(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	tenantsExpected := []*Tenant{{OrgID: "0", AuthToken: &auth.Token{}}}
+	if !reflect.DeepEqual(tenants, tenantsExpected) {
+		t.Fatalf("unexpected tenants\ngot\n%v\nwant\n%v", tenants, tenantsExpected)
+	}
+}